@@ -0,0 +1,158 @@
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultArtifactThreshold is the encoded result size above which a step
+// result is stored as an artifact instead of inline in the log.
+const DefaultArtifactThreshold = 64 << 10 // 64 KiB
+
+// ArtifactStore holds large step results outside the checkpoint log.
+// Keys are content addresses ("sha256:<hex>" of the data), so Put may
+// skip data it already has and a store can be shared across runs.
+// Implementations backed by object storage let workers on different
+// hosts resume each other's runs.
+type ArtifactStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+}
+
+// WithArtifactThreshold sets the encoded result size in bytes above
+// which results are written to the artifact store. Zero or negative
+// keeps every result inline.
+func WithArtifactThreshold(n int) Option {
+	return func(t *Tracker) { t.artifactThreshold = n }
+}
+
+// WithArtifactStore stores large results in s instead of the default
+// FileArtifacts in the checkpoint directory.
+func WithArtifactStore(s ArtifactStore) Option {
+	return func(t *Tracker) { t.artifacts = s }
+}
+
+// FileArtifacts stores artifacts as files named by their hash in dir.
+type FileArtifacts struct {
+	dir string
+}
+
+// NewFileArtifacts returns a file artifact store rooted at dir, which is
+// created on first Put.
+func NewFileArtifacts(dir string) *FileArtifacts {
+	return &FileArtifacts{dir: dir}
+}
+
+// Put writes data under key unless it is already present. The file is
+// written to a temporary name and renamed into place, so readers never
+// see a partial artifact.
+func (f *FileArtifacts) Put(key string, data []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(f.dir, 0o700); err != nil {
+		return fmt.Errorf("checkpoint: artifact: %w", err)
+	}
+	tmp, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("checkpoint: artifact: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("checkpoint: artifact: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("checkpoint: artifact: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("checkpoint: artifact: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("checkpoint: artifact: %w", err)
+	}
+	return nil
+}
+
+// Get reads the artifact stored under key.
+func (f *FileArtifacts) Get(key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: artifact: %w", err)
+	}
+	return data, nil
+}
+
+// path maps a key to its file, rejecting anything that is not a content
+// address so a tampered log cannot point outside dir.
+func (f *FileArtifacts) path(key string) (string, error) {
+	sum, ok := strings.CutPrefix(key, "sha256:")
+	if !ok || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("checkpoint: invalid artifact key %q", key)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("checkpoint: invalid artifact key %q", key)
+	}
+	return filepath.Join(f.dir, sum), nil
+}
+
+func artifactKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// externalize moves a large result out of r into the artifact store. On
+// any failure the result stays inline, which is slower to replay but
+// still correct.
+func (t *Tracker) externalize(r *Record) {
+	if t.artifactThreshold <= 0 || r.Result == nil {
+		return
+	}
+	data, err := json.Marshal(r.Result)
+	if err != nil || len(data) <= t.artifactThreshold {
+		return
+	}
+	key := artifactKey(data)
+	if data, err = t.sealArtifact(data); err != nil {
+		return
+	}
+	if err := t.artifacts.Put(key, data); err != nil {
+		return
+	}
+	r.Result = nil
+	r.Artifact = key
+}
+
+// loadArtifact reads and decodes an artifact result, verifying that the
+// data matches its key.
+func (t *Tracker) loadArtifact(key string) (any, error) {
+	data, err := t.artifacts.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = t.openArtifact(data); err != nil {
+		return nil, fmt.Errorf("checkpoint: artifact %s: %w", key, err)
+	}
+	if artifactKey(data) != key {
+		return nil, fmt.Errorf("checkpoint: artifact %s is corrupt", key)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("checkpoint: artifact %s: %w", key, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,127 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLargeResultStoredAsArtifact(t *testing.T) {
+	dir := tmpDir(t)
+	big := strings.Repeat("x", 1000)
+
+	cp, err := Open(dir, "run-art", WithArtifactThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Step(context.Background(), "big", func(context.Context) (any, error) { return big, nil })
+	cp.Step(context.Background(), "small", func(context.Context) (any, error) { return "tiny", nil })
+	if cp.Result("big") != big {
+		t.Error("in-memory result lost")
+	}
+	cp.Close()
+
+	log, _ := os.ReadFile(filepath.Join(dir, "run-art.jsonl"))
+	if strings.Contains(string(log), big) {
+		t.Error("large result inlined in log")
+	}
+	if !strings.Contains(string(log), `"artifact":"sha256:`) || !strings.Contains(string(log), `"tiny"`) {
+		t.Errorf("log = %s", log)
+	}
+
+	cp2, err := Open(dir, "run-art", WithArtifactThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp2.Close()
+	if cp2.Result("big") != big || cp2.Result("small") != "tiny" {
+		t.Errorf("replayed results = %v, %v", cp2.Result("big"), cp2.Result("small"))
+	}
+	if err := cp2.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	log, _ = os.ReadFile(filepath.Join(dir, "run-art.jsonl"))
+	if strings.Contains(string(log), big) {
+		t.Error("compaction inlined artifact")
+	}
+}
+
+func TestArtifactCorrupt(t *testing.T) {
+	dir := tmpDir(t)
+	cp, _ := Open(dir, "run-corrupt", WithArtifactThreshold(10))
+	cp.Step(context.Background(), "big", func(context.Context) (any, error) { return strings.Repeat("y", 100), nil })
+	cp.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "artifacts", "*"))
+	if len(files) != 1 {
+		t.Fatalf("artifacts = %v", files)
+	}
+	os.WriteFile(files[0], []byte(`"tampered"`), 0o600)
+
+	cp2, _ := Open(dir, "run-corrupt", WithArtifactThreshold(10))
+	defer cp2.Close()
+	if !cp2.IsCompleted("big") {
+		t.Error("step not completed")
+	}
+	if v := cp2.Result("big"); v != nil {
+		t.Errorf("Result = %v, want nil for corrupt artifact", v)
+	}
+}
+
+type memArtifacts struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (m *memArtifacts) Put(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = data
+	return nil
+}
+
+func (m *memArtifacts) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.data[key]
+	if !ok {
+		return nil, fmt.Errorf("no artifact %s", key)
+	}
+	return d, nil
+}
+
+func TestArtifactStoreOption(t *testing.T) {
+	dir := tmpDir(t)
+	store := &memArtifacts{data: map[string][]byte{}}
+	result := map[string]any{"rows": strings.Repeat("z", 200)}
+
+	cp, _ := Open(dir, "run-remote", WithArtifactThreshold(50), WithArtifactStore(store))
+	cp.Step(context.Background(), "q", func(context.Context) (any, error) { return result, nil })
+	cp.Close()
+	if len(store.data) != 1 {
+		t.Fatalf("store has %d artifacts", len(store.data))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "artifacts")); !os.IsNotExist(err) {
+		t.Error("file store used despite WithArtifactStore")
+	}
+
+	cp2, _ := Open(dir, "run-remote", WithArtifactThreshold(50), WithArtifactStore(store))
+	defer cp2.Close()
+	got, ok := cp2.Result("q").(map[string]any)
+	if !ok || got["rows"] != result["rows"] {
+		t.Errorf("Result = %v", cp2.Result("q"))
+	}
+}
+
+func TestFileArtifactsRejectsBadKey(t *testing.T) {
+	fa := NewFileArtifacts(t.TempDir())
+	for _, key := range []string{"../etc/passwd", "sha256:../../x", "sha256:" + strings.Repeat("g", 64)} {
+		if _, err := fa.Get(key); err == nil || !strings.Contains(err.Error(), "invalid artifact key") {
+			t.Errorf("Get(%q) err = %v", key, err)
+		}
+	}
+}
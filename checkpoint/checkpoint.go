@@ -15,17 +15,31 @@
 //	cp.Step("process", func(ctx context.Context) (any, error) {
 //	    return processData(ctx)
 //	})
+//
+// Results larger than DefaultArtifactThreshold are stored as
+// content-addressed artifacts beside the log (or in WithArtifactStore) and
+// loaded again on demand by Result.
+//
+// WithEncryption encrypts the log and artifacts at rest.
+//
+// Open gives one tracker exclusive ownership of a run. Workers that need
+// to share a run use OpenShared, which leases individual steps instead.
 package checkpoint
 
 import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/greynewell/mist-go/platform"
+	"github.com/greynewell/mist-go/secrets"
 )
 
 // Status represents the state of a step.
@@ -37,6 +51,11 @@ const (
 	StatusCompleted Status = "completed"
 	StatusFailed    Status = "failed"
 	StatusSkipped   Status = "skipped"
+
+	// Run-level control records have an empty Step.
+	StatusPaused    Status = "paused"
+	StatusResumed   Status = "resumed"
+	StatusCancelled Status = "cancelled"
 )
 
 // Record is a single checkpoint entry persisted to the log file.
@@ -45,8 +64,16 @@ type Record struct {
 	Status    Status    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 	Result    any       `json:"result,omitempty"`
-	Error     string    `json:"error,omitempty"`
-	Attempt   int       `json:"attempt,omitempty"`
+
+	// Artifact is the artifact store key holding the result when it was
+	// too large to inline; Result is then empty.
+	Artifact string `json:"artifact,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Attempt  int    `json:"attempt,omitempty"`
+
+	// DurationMS is how long a completed step took, including retries.
+	// It feeds progress estimates for later runs.
+	DurationMS int64 `json:"duration_ms,omitempty"`
 }
 
 // Tracker manages checkpoint state for a single job run.
@@ -57,6 +84,41 @@ type Tracker struct {
 	file      *os.File
 	completed map[string]*Record
 	results   map[string]any
+
+	// lock is the run-level lock: exclusive for Open, shared for
+	// OpenShared, whose trackers also take per-step leases.
+	lock   *platform.FileLock
+	shared bool
+
+	// size is the current log size in bytes. When it exceeds maxLogSize
+	// the log is compacted automatically (exclusive trackers only);
+	// compactErrors counts automatic compactions that failed.
+	size          int64
+	maxLogSize    int64
+	compactErrors int64
+
+	// lines is the number of lines in the log, which the next record is
+	// sealed at when the log is encrypted.
+	lines int64
+
+	// Run control. resumeCh is closed when a paused run resumes or is
+	// cancelled; runCtx is cancelled (with cause ErrCancelled) by Cancel.
+	state     RunState
+	resumeCh  chan struct{}
+	runCtx    context.Context
+	cancelRun context.CancelCauseFunc
+
+	// Progress tracking; see Plan and Progress.
+	plan       []string
+	history    map[string]float64 // step → mean duration (ms) in other runs
+	onProgress func(Progress)
+
+	// Large results are kept in artifacts; see WithArtifactThreshold.
+	artifacts         ArtifactStore
+	artifactThreshold int
+
+	// keys encrypts the log and artifacts; see WithEncryption.
+	keys *secrets.Keyring
 }
 
 // ValidRunID reports whether a run ID contains only safe characters
@@ -80,7 +142,42 @@ func ValidRunID(id string) bool {
 // for checkpoint files. The runID uniquely identifies this job execution —
 // reusing the same runID resumes from the last successful step.
 // The runID must contain only alphanumeric characters, hyphens, and underscores.
-func Open(dir, runID string) (*Tracker, error) {
+//
+// Open takes exclusive ownership of the run: if another process (or
+// another Tracker in this process) already has it open, exclusively or
+// with OpenShared, Open returns an error wrapping ErrConflict. Use
+// OpenShared to let several workers cooperate on one run.
+func Open(dir, runID string, opts ...Option) (*Tracker, error) {
+	t, err := open(dir, runID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := platform.TryLock(t.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	if lock == nil {
+		return nil, fmt.Errorf("checkpoint: run %q: %w", runID, ErrConflict)
+	}
+	t.lock = lock
+
+	if err := t.openLog(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	if t.overLimit() {
+		if err := t.Compact(); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// open validates the run ID, prepares the directory, and replays any
+// existing log. The log file is not opened for writing.
+func open(dir, runID string, opts []Option) (*Tracker, error) {
 	if !ValidRunID(runID) {
 		return nil, fmt.Errorf("checkpoint: invalid runID %q: must be alphanumeric, hyphens, underscores only", runID)
 	}
@@ -89,30 +186,73 @@ func Open(dir, runID string) (*Tracker, error) {
 		return nil, fmt.Errorf("checkpoint: mkdir: %w", err)
 	}
 
-	path := filepath.Join(dir, runID+".jsonl")
 	t := &Tracker{
-		runID:     runID,
-		dir:       dir,
-		completed: make(map[string]*Record),
-		results:   make(map[string]any),
+		runID:      runID,
+		dir:        dir,
+		completed:  make(map[string]*Record),
+		results:    make(map[string]any),
+		maxLogSize: DefaultMaxLogSize,
+		state:      RunActive,
+
+		artifactThreshold: DefaultArtifactThreshold,
+	}
+	t.runCtx, t.cancelRun = context.WithCancelCause(context.Background())
+	for _, o := range opts {
+		o(t)
+	}
+	if t.artifacts == nil {
+		t.artifacts = NewFileArtifacts(filepath.Join(dir, "artifacts"))
 	}
 
 	// Replay existing checkpoint log.
-	if data, err := os.ReadFile(path); err == nil {
+	if data, err := os.ReadFile(t.logPath()); err == nil {
+		if data, err = t.decrypt(data); err != nil {
+			return nil, fmt.Errorf("checkpoint: %s: %w", t.logPath(), err)
+		}
 		t.replay(data)
 	}
 
+	return t, nil
+}
+
+// openLog opens the checkpoint log for appending.
+func (t *Tracker) openLog() error {
+	path := t.logPath()
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
 	if err != nil {
-		return nil, fmt.Errorf("checkpoint: open %s: %w", path, err)
+		return fmt.Errorf("checkpoint: open %s: %w", path, err)
+	}
+	if t.keys != nil {
+		// Count the lines the next record is sealed after, along with
+		// the size they fill.
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("checkpoint: read %s: %w", path, err)
+		}
+		t.file = f
+		t.size, t.lines = int64(len(data)), int64(bytes.Count(data, []byte{'\n'}))
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("checkpoint: stat %s: %w", path, err)
 	}
 	t.file = f
+	t.size = info.Size()
+	return nil
+}
 
-	return t, nil
+func (t *Tracker) logPath() string {
+	return filepath.Join(t.dir, t.runID+".jsonl")
 }
 
 // replay parses existing checkpoint records and rebuilds state.
 func (t *Tracker) replay(data []byte) {
+	state := t.state
+	defer func() { t.setState(state) }()
+
 	dec := json.NewDecoder(bytes.NewReader(data))
 	for dec.More() {
 		var r Record
@@ -121,10 +261,19 @@ func (t *Tracker) replay(data []byte) {
 			// find the next valid JSON object boundary.
 			return
 		}
+		if r.Step == "" {
+			state = runStateFor(r.Status, state)
+			continue
+		}
 		switch r.Status {
 		case StatusCompleted:
 			t.completed[r.Step] = &r
-			t.results[r.Step] = r.Result
+			if r.Artifact != "" {
+				// Loaded on first use by Result.
+				delete(t.results, r.Step)
+			} else {
+				t.results[r.Step] = r.Result
+			}
 		case StatusFailed, StatusRunning:
 			// A step that was running when the process died needs re-execution.
 			delete(t.completed, r.Step)
@@ -135,100 +284,116 @@ func (t *Tracker) replay(data []byte) {
 
 // Step executes fn if the step has not already completed in a previous run.
 // If the step was already completed, fn is not called and the previous
-// result is available via Result(). Returns any error from fn.
+// result is available via Result(). Returns any error from fn, or from
+// writing the step to the log.
+//
+// Step waits while the run is paused and returns ErrCancelled once it has
+// been cancelled. The ctx passed to fn is cancelled by Cancel.
 func (t *Tracker) Step(ctx context.Context, name string, fn func(ctx context.Context) (any, error)) error {
-	t.mu.Lock()
-	if _, done := t.completed[name]; done {
-		t.mu.Unlock()
-		return nil
+	if err := t.wait(ctx); err != nil {
+		return err
 	}
-	t.mu.Unlock()
+	release, done, err := t.claim(name)
+	if err != nil || done {
+		return err
+	}
+	defer release()
+
+	ctx, stop := t.stepContext(ctx)
+	defer stop()
 
 	// Record that we're starting.
-	t.append(Record{
+	start := time.Now()
+	if err := t.append(Record{
 		Step:      name,
 		Status:    StatusRunning,
-		Timestamp: time.Now(),
-	})
+		Timestamp: start,
+	}); err != nil {
+		return err
+	}
 
 	result, err := fn(ctx)
 	if err != nil {
-		t.append(Record{
+		if logErr := t.append(Record{
 			Step:      name,
 			Status:    StatusFailed,
 			Timestamp: time.Now(),
 			Error:     err.Error(),
-		})
-		return err
-	}
-
-	r := Record{
-		Step:      name,
-		Status:    StatusCompleted,
-		Timestamp: time.Now(),
-		Result:    result,
+		}); logErr != nil {
+			return errors.Join(cancelCause(ctx, err), logErr)
+		}
+		return cancelCause(ctx, err)
 	}
-	t.append(r)
 
-	t.mu.Lock()
-	t.completed[name] = &r
-	t.results[name] = result
-	t.mu.Unlock()
+	err = t.append(Record{
+		Step:       name,
+		Status:     StatusCompleted,
+		Timestamp:  time.Now(),
+		Result:     result,
+		DurationMS: time.Since(start).Milliseconds(),
+	})
+	t.notifyProgress()
 
-	return nil
+	return err
 }
 
 // StepRetry executes fn with up to maxAttempts retries using the retry
 // package's logic. Each attempt is logged. The step is skipped if already
 // completed from a previous run.
 func (t *Tracker) StepRetry(ctx context.Context, name string, maxAttempts int, fn func(ctx context.Context) (any, error)) error {
-	t.mu.Lock()
-	if _, done := t.completed[name]; done {
-		t.mu.Unlock()
-		return nil
+	if err := t.wait(ctx); err != nil {
+		return err
+	}
+	release, done, err := t.claim(name)
+	if err != nil || done {
+		return err
 	}
-	t.mu.Unlock()
+	defer release()
 
+	ctx, stop := t.stepContext(ctx)
+	defer stop()
+
+	start := time.Now()
 	var lastErr error
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		if err := ctx.Err(); err != nil {
-			return err
+			return cancelCause(ctx, err)
 		}
 
-		t.append(Record{
+		if err := t.append(Record{
 			Step:      name,
 			Status:    StatusRunning,
 			Timestamp: time.Now(),
 			Attempt:   attempt,
-		})
+		}); err != nil {
+			return err
+		}
 
 		result, err := fn(ctx)
 		if err == nil {
-			r := Record{
-				Step:      name,
-				Status:    StatusCompleted,
-				Timestamp: time.Now(),
-				Result:    result,
-				Attempt:   attempt,
-			}
-			t.append(r)
+			err = t.append(Record{
+				Step:       name,
+				Status:     StatusCompleted,
+				Timestamp:  time.Now(),
+				Result:     result,
+				Attempt:    attempt,
+				DurationMS: time.Since(start).Milliseconds(),
+			})
+			t.notifyProgress()
 
-			t.mu.Lock()
-			t.completed[name] = &r
-			t.results[name] = result
-			t.mu.Unlock()
-
-			return nil
+			return err
 		}
 
 		lastErr = err
-		t.append(Record{
+		if err := t.append(Record{
 			Step:      name,
 			Status:    StatusFailed,
 			Timestamp: time.Now(),
 			Error:     err.Error(),
 			Attempt:   attempt,
-		})
+		}); err != nil {
+			return errors.Join(lastErr, err)
+		}
 
 		// Exponential backoff: 100ms, 200ms, 400ms, ...
 		wait := time.Duration(1<<uint(attempt-1)) * 100 * time.Millisecond
@@ -237,12 +402,12 @@ func (t *Tracker) StepRetry(ctx context.Context, name string, maxAttempts int, f
 		}
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return cancelCause(ctx, ctx.Err())
 		case <-time.After(wait):
 		}
 	}
 
-	return lastErr
+	return cancelCause(ctx, lastErr)
 }
 
 // IsCompleted reports whether the named step has already completed.
@@ -254,11 +419,24 @@ func (t *Tracker) IsCompleted(name string) bool {
 }
 
 // Result returns the stored result for a completed step. Returns nil
-// if the step hasn't completed.
+// if the step hasn't completed, or if its result was stored as an
+// artifact that can no longer be loaded.
 func (t *Tracker) Result(name string) any {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.results[name]
+	if v, ok := t.results[name]; ok {
+		return v
+	}
+	r, ok := t.completed[name]
+	if !ok || r.Artifact == "" {
+		return nil
+	}
+	v, err := t.loadArtifact(r.Artifact)
+	if err != nil {
+		return nil
+	}
+	t.results[name] = v
+	return v
 }
 
 // CompletedSteps returns the names of all completed steps.
@@ -277,38 +455,112 @@ func (t *Tracker) RunID() string {
 	return t.runID
 }
 
-// Close flushes and closes the checkpoint file.
+// Close flushes and closes the checkpoint file and releases run ownership.
 func (t *Tracker) Close() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	var err error
 	if t.file != nil {
-		return t.file.Close()
+		err = t.file.Close()
+		t.file = nil
 	}
-	return nil
+	if t.lock != nil {
+		t.lock.Unlock()
+		t.lock = nil
+	}
+	return err
 }
 
 // Reset deletes the checkpoint file, forcing a full re-run next time.
+// It also clears any paused or cancelled run state.
 func (t *Tracker) Reset() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.completed = make(map[string]*Record)
 	t.results = make(map[string]any)
-	path := filepath.Join(t.dir, t.runID+".jsonl")
-	return os.Remove(path)
+	t.size, t.lines = 0, 0
+	if t.state == RunCancelled {
+		t.runCtx, t.cancelRun = context.WithCancelCause(context.Background())
+	}
+	t.setState(RunActive)
+	if err := os.Remove(t.logPath()); err != nil || t.file == nil {
+		return err
+	}
+	// Steps recorded after the reset go to a new log, not the removed one.
+	t.file.Close()
+	t.file = nil
+	return t.openLog()
 }
 
-// append writes a record to the checkpoint file.
-func (t *Tracker) append(r Record) {
+// append writes a record to the checkpoint file and, for completed
+// records, updates in-memory state. Large results are written to the
+// artifact store first and the record keeps only the reference. An
+// error means the record may not be durable. A failed automatic
+// compaction leaves the durable record in the uncompacted log; it is
+// logged and counted rather than returned.
+func (t *Tracker) append(r Record) error {
+	result := r.Result
+	if r.Status == StatusCompleted {
+		t.externalize(&r)
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	if r.Status == StatusCompleted {
+		t.completed[r.Step] = &r
+		t.results[r.Step] = result
+	}
+	if err := t.writeLocked(&r); err != nil {
+		return err
+	}
+	t.autoCompactLocked(r.Status)
+	return nil
+}
+
+// writeLocked appends r to the log and syncs it. Callers must hold t.mu.
+func (t *Tracker) writeLocked(r *Record) error {
 	if t.file == nil {
-		return
+		return nil
 	}
-	data, err := json.Marshal(r)
+	if t.keys != nil && t.shared {
+		// Other workers append too; count their lines under the log
+		// lock so this record is sealed at its position.
+		lock, err := platform.Lock(t.logLockPath())
+		if err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+		defer lock.Unlock()
+		if err := t.catchUp(); err != nil {
+			return err
+		}
+	}
+	data, err := t.encodeRecord(r, t.lines)
+	if err != nil {
+		return fmt.Errorf("checkpoint: encode %q: %w", r.Step, err)
+	}
+	n, err := t.file.Write(data)
+	t.size += int64(n)
 	if err != nil {
+		return fmt.Errorf("checkpoint: write %s: %w", t.logPath(), err)
+	}
+	t.lines++
+	if err := t.file.Sync(); err != nil { // fsync for durability
+		return fmt.Errorf("checkpoint: sync %s: %w", t.logPath(), err)
+	}
+	return nil
+}
+
+// autoCompactLocked compacts the log if it has grown over the limit
+// after a record with the given status was written. A failure is logged
+// and counted. Callers must hold t.mu.
+func (t *Tracker) autoCompactLocked(status Status) {
+	// Only terminal records shrink under compaction, so there is no
+	// point compacting right after a running record.
+	if status == StatusRunning || !t.overLimit() {
 		return
 	}
-	data = append(data, '\n')
-	t.file.Write(data)
-	t.file.Sync() // fsync for durability
+	if err := t.compactLocked(); err != nil {
+		t.compactErrors++
+		slog.Default().Warn("checkpoint: automatic compaction failed", "path", t.logPath(), "error", err)
+	}
 }
@@ -273,7 +273,17 @@ func TestReset(t *testing.T) {
 	if cp.IsCompleted("a") {
 		t.Error("step should not be completed after reset")
 	}
+
+	// Steps after the reset are still persisted.
+	cp.Step(context.Background(), "b", func(_ context.Context) (any, error) {
+		return "done", nil
+	})
 	cp.Close()
+	cp, _ = Open(dir, "run-reset")
+	defer cp.Close()
+	if cp.IsCompleted("a") || !cp.IsCompleted("b") {
+		t.Errorf("after reopen: completed = %v, want [b]", cp.CompletedSteps())
+	}
 }
 
 func TestRunID(t *testing.T) {
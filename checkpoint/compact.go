@@ -0,0 +1,136 @@
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultMaxLogSize is the log size above which an exclusive tracker
+// compacts its log automatically.
+const DefaultMaxLogSize = 16 << 20 // 16 MiB
+
+// Option configures a Tracker.
+type Option func(*Tracker)
+
+// WithMaxLogSize sets the log size in bytes above which the log is
+// compacted automatically. Zero or negative disables automatic compaction;
+// Compact can still be called explicitly.
+func WithMaxLogSize(n int64) Option {
+	return func(t *Tracker) { t.maxLogSize = n }
+}
+
+// LogSize returns the current size of the checkpoint log in bytes.
+func (t *Tracker) LogSize() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// CompactionErrors returns the number of automatic compactions that
+// failed. Each left the log uncompacted but lost no records.
+func (t *Tracker) CompactionErrors() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.compactErrors
+}
+
+// Compact rewrites the log as a snapshot of completed steps, plus the
+// run's paused or cancelled state if any, dropping running and failed
+// records and superseded retries. Replaying the compacted log yields the
+// same completed steps, results, and run state. The new log
+// is written to a temporary file and renamed into place, so a crash
+// mid-compaction leaves the previous log intact.
+//
+// Compact requires exclusive ownership and fails on trackers created
+// by OpenShared.
+func (t *Tracker) Compact() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.compactLocked()
+}
+
+func (t *Tracker) compactLocked() error {
+	if t.shared {
+		return errors.New("checkpoint: compact requires exclusive Open")
+	}
+	if t.file == nil {
+		return errors.New("checkpoint: compact: tracker is closed")
+	}
+
+	records := make([]*Record, 0, len(t.completed))
+	for _, r := range t.completed {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Timestamp.Equal(records[j].Timestamp) {
+			return records[i].Timestamp.Before(records[j].Timestamp)
+		}
+		return records[i].Step < records[j].Step
+	})
+
+	// Keep a paused or cancelled run in that state after compaction.
+	switch t.state {
+	case RunPaused:
+		records = append(records, &Record{Status: StatusPaused, Timestamp: time.Now()})
+	case RunCancelled:
+		records = append(records, &Record{Status: StatusCancelled, Timestamp: time.Now()})
+	}
+
+	path := t.logPath()
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("checkpoint: compact: %w", err)
+	}
+
+	var size int64
+	for i, r := range records {
+		data, err := t.encodeRecord(r, int64(i))
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("checkpoint: compact: encode %q: %w", r.Step, err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("checkpoint: compact: %w", err)
+	}
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("checkpoint: compact: %w", err)
+	}
+
+	// Open the new log for appends before it replaces the old one, so
+	// a failure either way leaves the old log and its handle in use.
+	nf, err := os.OpenFile(tmp, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("checkpoint: compact: reopen: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		nf.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("checkpoint: compact: %w", err)
+	}
+	t.file.Close()
+	t.file = nf
+	t.size, t.lines = size, int64(len(records))
+	return nil
+}
+
+// overLimit reports whether the log should be compacted automatically.
+// Callers must hold t.mu or otherwise own the tracker exclusively.
+func (t *Tracker) overLimit() bool {
+	return !t.shared && t.maxLogSize > 0 && t.size > t.maxLogSize
+}
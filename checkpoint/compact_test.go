@@ -0,0 +1,165 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompactKeepsCompletedSteps(t *testing.T) {
+	dir := tmpDir(t)
+	cp, err := Open(dir, "run-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	attempts := 0
+	cp.StepRetry(ctx, "flaky", 3, func(context.Context) (any, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("transient")
+		}
+		return "ok", nil
+	})
+	cp.Step(ctx, "broken", func(context.Context) (any, error) {
+		return nil, errors.New("boom")
+	})
+	cp.Step(ctx, "plain", func(context.Context) (any, error) { return float64(42), nil })
+
+	before := cp.LogSize()
+	if err := cp.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	after := cp.LogSize()
+	if after >= before {
+		t.Errorf("log size %d -> %d, expected shrink", before, after)
+	}
+
+	// Appends continue to work after compaction.
+	cp.Step(ctx, "late", func(context.Context) (any, error) { return "x", nil })
+	cp.Close()
+
+	// Two snapshot records, then running + completed for "late".
+	data, _ := os.ReadFile(filepath.Join(dir, "run-c.jsonl"))
+	if n := strings.Count(string(data), "\n"); n != 4 {
+		t.Errorf("compacted log has %d lines, want 4:\n%s", n, data)
+	}
+
+	cp2, err := Open(dir, "run-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp2.Close()
+	for _, step := range []string{"flaky", "plain", "late"} {
+		if !cp2.IsCompleted(step) {
+			t.Errorf("%s should be completed after compaction", step)
+		}
+	}
+	if cp2.IsCompleted("broken") {
+		t.Error("failed step should not be completed")
+	}
+	if cp2.Result("plain") != float64(42) {
+		t.Errorf("plain result = %v", cp2.Result("plain"))
+	}
+}
+
+func TestAutoCompaction(t *testing.T) {
+	dir := tmpDir(t)
+	cp, err := Open(dir, "run-auto", WithMaxLogSize(2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("step-%d", i%5)
+		cp.Step(ctx, name+"-fail", func(context.Context) (any, error) {
+			return nil, errors.New("retry me later please")
+		})
+		cp.Step(ctx, name, func(context.Context) (any, error) { return i, nil })
+	}
+
+	if size := cp.LogSize(); size > 2048 {
+		t.Errorf("log size = %d, want <= 2048 after auto compaction", size)
+	}
+	if got := len(cp.CompletedSteps()); got != 5 {
+		t.Errorf("completed = %d, want 5", got)
+	}
+}
+
+func TestAutoCompactionFailure(t *testing.T) {
+	dir := tmpDir(t)
+	cp, err := Open(dir, "run-stuck", WithMaxLogSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	// Compaction can't create its temporary file in place of a directory.
+	os.Mkdir(cp.logPath()+".tmp", 0o700)
+
+	ctx := context.Background()
+	for _, step := range []string{"a", "b", "c"} {
+		err := cp.Step(ctx, step, func(context.Context) (any, error) { return step, nil })
+		if err != nil {
+			t.Errorf("Step %s = %v; the record was durable", step, err)
+		}
+	}
+	if n := cp.CompactionErrors(); n != 3 {
+		t.Errorf("compaction errors = %d, want 3", n)
+	}
+
+	// Every record was still written to the old log.
+	os.Remove(cp.logPath() + ".tmp")
+	cp.Close()
+	reopened, err := Open(dir, "run-stuck", WithMaxLogSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.CompletedSteps(); len(got) != 3 {
+		t.Errorf("completed after reopening = %v, want a, b, and c", got)
+	}
+}
+
+func TestCompactOnOpen(t *testing.T) {
+	dir := tmpDir(t)
+	cp, _ := Open(dir, "run-big", WithMaxLogSize(0))
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		cp.Step(ctx, "fails", func(context.Context) (any, error) {
+			return nil, errors.New("nope")
+		})
+	}
+	cp.Step(ctx, "done", func(context.Context) (any, error) { return nil, nil })
+	big := cp.LogSize()
+	cp.Close()
+
+	cp2, err := Open(dir, "run-big", WithMaxLogSize(big/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp2.Close()
+	if cp2.LogSize() >= big/2 {
+		t.Errorf("log not compacted on open: %d", cp2.LogSize())
+	}
+	if !cp2.IsCompleted("done") {
+		t.Error("done should survive compaction")
+	}
+}
+
+func TestCompactShared(t *testing.T) {
+	cp, err := OpenShared(tmpDir(t), "run-sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if err := cp.Compact(); err == nil {
+		t.Error("Compact on shared tracker should fail")
+	}
+}
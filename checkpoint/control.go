@@ -0,0 +1,261 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunState is the control state of a run.
+type RunState string
+
+const (
+	RunActive    RunState = "running"
+	RunPaused    RunState = "paused"
+	RunCancelled RunState = "cancelled"
+)
+
+// ErrCancelled is returned by Step and StepRetry once the run has been
+// cancelled. It is also the cause of the ctx handed to running steps.
+var ErrCancelled = errors.New("checkpoint: run cancelled")
+
+// State returns the run's current control state.
+func (t *Tracker) State() RunState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Pause stops the run at the next step boundary. The step in progress,
+// if any, runs to completion; later Step calls block until Resume or
+// Cancel. The paused state is persisted, so a run reopened after a
+// restart stays paused until resumed.
+func (t *Tracker) Pause() error {
+	return t.control(RunPaused, StatusPaused)
+}
+
+// Resume continues a paused run. It is a no-op for a running run.
+func (t *Tracker) Resume() error {
+	return t.control(RunActive, StatusResumed)
+}
+
+// Cancel stops the run. The ctx of any step in progress is cancelled,
+// and all later Step calls return ErrCancelled. Cancellation is persisted
+// and only cleared by Reset.
+func (t *Tracker) Cancel() error {
+	return t.control(RunCancelled, StatusCancelled)
+}
+
+// control records status and then moves the run to s, so a record that
+// can't be written leaves the state unchanged. Compaction runs only after
+// the state changes, so its snapshot includes it.
+func (t *Tracker) control(s RunState, status Status) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == s {
+		return nil
+	}
+	if t.state == RunCancelled {
+		return ErrCancelled
+	}
+	if err := t.writeLocked(&Record{Status: status, Timestamp: time.Now()}); err != nil {
+		return err
+	}
+	t.setState(s)
+	t.autoCompactLocked(status)
+	return nil
+}
+
+// setState moves the run to s, waking paused waiters and cancelling
+// running steps as needed. Callers must hold t.mu or otherwise own the
+// tracker exclusively.
+func (t *Tracker) setState(s RunState) {
+	if s == t.state {
+		return
+	}
+	if t.state == RunPaused {
+		close(t.resumeCh)
+	}
+	t.state = s
+	switch s {
+	case RunPaused:
+		t.resumeCh = make(chan struct{})
+	case RunCancelled:
+		t.cancelRun(ErrCancelled)
+	}
+}
+
+// wait blocks while the run is paused. It returns ErrCancelled if the
+// run is cancelled, or ctx's error if ctx ends first.
+func (t *Tracker) wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		state, resumed := t.state, t.resumeCh
+		t.mu.Unlock()
+
+		switch state {
+		case RunCancelled:
+			return ErrCancelled
+		case RunActive:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+}
+
+// stepContext derives the ctx handed to a step function. It is cancelled
+// when either the caller's ctx ends or the run is cancelled.
+func (t *Tracker) stepContext(ctx context.Context) (context.Context, func()) {
+	t.mu.Lock()
+	runCtx := t.runCtx
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(runCtx, func() { cancel(context.Cause(runCtx)) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// cancelCause replaces err with ErrCancelled when the step's ctx was
+// cancelled by Cancel, so callers can check errors.Is(err, ErrCancelled).
+func cancelCause(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrCancelled) {
+		return ErrCancelled
+	}
+	return err
+}
+
+// runStateFor maps a run-level control record to the state it sets.
+func runStateFor(status Status, cur RunState) RunState {
+	switch status {
+	case StatusPaused:
+		return RunPaused
+	case StatusResumed:
+		return RunActive
+	case StatusCancelled:
+		return RunCancelled
+	}
+	return cur
+}
+
+// Controller exposes pause, resume, and cancel for a set of trackers
+// over HTTP.
+//
+//	ctl := checkpoint.NewController()
+//	ctl.Add(cp)
+//	mux.HandleFunc("GET /jobs", ctl.Jobs)
+//	mux.HandleFunc("GET /jobs/{id}", ctl.Job)
+//	mux.HandleFunc("POST /jobs/{id}/{action}", ctl.Control)
+type Controller struct {
+	mu   sync.RWMutex
+	runs map[string]*Tracker
+}
+
+// NewController creates an empty controller.
+func NewController() *Controller {
+	return &Controller{runs: make(map[string]*Tracker)}
+}
+
+// Add makes a tracker controllable by its run ID.
+func (c *Controller) Add(t *Tracker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runs[t.RunID()] = t
+}
+
+// Remove stops exposing a run.
+func (c *Controller) Remove(runID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.runs, runID)
+}
+
+// Get returns the tracker for a run ID.
+func (c *Controller) Get(runID string) (*Tracker, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t, ok := c.runs[runID]
+	return t, ok
+}
+
+// RunStatus is the JSON body describing a run.
+type RunStatus struct {
+	RunID     string   `json:"run_id"`
+	State     RunState `json:"state"`
+	Completed []string `json:"completed"`
+	Progress  Progress `json:"progress"`
+}
+
+func statusOf(t *Tracker) RunStatus {
+	steps := t.CompletedSteps()
+	sort.Strings(steps)
+	return RunStatus{RunID: t.RunID(), State: t.State(), Completed: steps, Progress: t.Progress()}
+}
+
+// Jobs handles GET /jobs — lists every registered run.
+func (c *Controller) Jobs(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	runs := make([]RunStatus, 0, len(c.runs))
+	for _, t := range c.runs {
+		runs = append(runs, statusOf(t))
+	}
+	c.mu.RUnlock()
+	sort.Slice(runs, func(i, j int) bool { return runs[i].RunID < runs[j].RunID })
+
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// Job handles GET /jobs/{id} — returns one run's status.
+func (c *Controller) Job(w http.ResponseWriter, r *http.Request) {
+	t, ok := c.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusOf(t))
+}
+
+// Control handles POST /jobs/{id}/{action}, where action is pause,
+// resume, or cancel. It returns the run's status after the action.
+func (c *Controller) Control(w http.ResponseWriter, r *http.Request) {
+	t, ok := c.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	switch r.PathValue("action") {
+	case "pause":
+		err = t.Pause()
+	case "resume":
+		err = t.Resume()
+	case "cancel":
+		err = t.Cancel()
+	default:
+		http.Error(w, "action must be pause, resume, or cancel", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusOf(t))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,200 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPauseBlocksUntilResume(t *testing.T) {
+	cp, err := Open(tmpDir(t), "run-p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	if err := cp.Pause(); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cp.Step(context.Background(), "a", func(context.Context) (any, error) { return 1, nil })
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("step ran while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cp.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Step: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("step did not run after Resume")
+	}
+	if !cp.IsCompleted("a") {
+		t.Error("a should be completed")
+	}
+}
+
+func TestPausePersists(t *testing.T) {
+	dir := tmpDir(t)
+	cp, _ := Open(dir, "run-pp")
+	cp.Pause()
+	cp.Close()
+
+	cp2, err := Open(dir, "run-pp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp2.Close()
+	if cp2.State() != RunPaused {
+		t.Fatalf("state = %s, want paused", cp2.State())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = cp2.Step(ctx, "a", func(context.Context) (any, error) { return nil, nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Step on paused run err = %v, want deadline exceeded", err)
+	}
+
+	cp2.Resume()
+	if cp2.State() != RunActive {
+		t.Errorf("state = %s after Resume", cp2.State())
+	}
+}
+
+func TestControlWriteFailureKeepsState(t *testing.T) {
+	cp, err := Open(tmpDir(t), "run-wf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	cp.file.Close() // every later write fails
+
+	if err := cp.Pause(); err == nil {
+		t.Error("Pause succeeded without recording it")
+	}
+	if err := cp.Cancel(); err == nil {
+		t.Error("Cancel succeeded without recording it")
+	}
+	if cp.State() != RunActive {
+		t.Errorf("state = %s, want running after failed writes", cp.State())
+	}
+}
+
+func TestPauseSurvivesAutoCompaction(t *testing.T) {
+	dir := tmpDir(t)
+	cp, _ := Open(dir, "run-pc", WithMaxLogSize(1))
+	cp.Pause()
+	cp.Close()
+
+	cp2, err := Open(dir, "run-pc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp2.Close()
+	if cp2.State() != RunPaused || cp.CompactionErrors() != 0 {
+		t.Errorf("state = %s, compaction errors = %d", cp2.State(), cp.CompactionErrors())
+	}
+}
+
+func TestCancelStopsRunningStep(t *testing.T) {
+	dir := tmpDir(t)
+	cp, _ := Open(dir, "run-cancel")
+
+	started := make(chan struct{})
+	go func() {
+		<-started
+		cp.Cancel()
+	}()
+
+	err := cp.Step(context.Background(), "long", func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+
+	err = cp.Step(context.Background(), "next", func(context.Context) (any, error) {
+		t.Error("step should not run after cancel")
+		return nil, nil
+	})
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want ErrCancelled", err)
+	}
+	if err := cp.Resume(); !errors.Is(err, ErrCancelled) {
+		t.Errorf("Resume after Cancel err = %v", err)
+	}
+	cp.Close()
+
+	// Cancellation survives a restart and compaction, and Reset clears it.
+	cp2, _ := Open(dir, "run-cancel")
+	defer cp2.Close()
+	cp2.Compact()
+	if cp2.State() != RunCancelled {
+		t.Fatalf("state = %s, want cancelled", cp2.State())
+	}
+	cp2.Reset()
+	if err := cp2.Step(context.Background(), "next", func(context.Context) (any, error) { return nil, nil }); err != nil {
+		t.Errorf("Step after Reset: %v", err)
+	}
+}
+
+func TestControllerHTTP(t *testing.T) {
+	cp, _ := Open(tmpDir(t), "run-http")
+	defer cp.Close()
+
+	ctl := NewController()
+	ctl.Add(cp)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs", ctl.Jobs)
+	mux.HandleFunc("GET /jobs/{id}", ctl.Job)
+	mux.HandleFunc("POST /jobs/{id}/{action}", ctl.Control)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := do("POST", "/jobs/run-http/pause")
+	if w.Code != http.StatusOK {
+		t.Fatalf("pause status = %d: %s", w.Code, w.Body)
+	}
+	var st RunStatus
+	json.Unmarshal(w.Body.Bytes(), &st)
+	if st.State != RunPaused {
+		t.Errorf("state = %s, want paused", st.State)
+	}
+
+	if w := do("POST", "/jobs/run-http/explode"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad action status = %d", w.Code)
+	}
+	if w := do("GET", "/jobs/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("missing run status = %d", w.Code)
+	}
+
+	do("POST", "/jobs/run-http/cancel")
+	if w := do("POST", "/jobs/run-http/resume"); w.Code != http.StatusConflict {
+		t.Errorf("resume after cancel status = %d, want 409", w.Code)
+	}
+
+	var list []RunStatus
+	json.Unmarshal(do("GET", "/jobs").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].State != RunCancelled {
+		t.Errorf("jobs = %+v", list)
+	}
+}
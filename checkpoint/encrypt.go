@@ -0,0 +1,112 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/greynewell/mist-go/secrets"
+)
+
+// WithEncryption encrypts the checkpoint log and artifacts with the
+// primary key of ring (AES-256-GCM), so step results on a shared disk
+// can't be read, or forged, without the key. Each record is sealed as
+// its own line by secrets.Keyring.SealLineAt, bound to its place in the
+// log. Records and artifacts sealed with any key in ring are read.
+// Plaintext records are refused unless ring is from AllowPlaintext, to
+// resume a run started before encryption was turned on. Logs are
+// rekeyed, or encrypted in place, with secrets.RekeyFile or mist
+// secrets rekey; compaction also rewrites the log under the primary key.
+//
+// Open fails if the existing log has records no key in ring can open,
+// or plaintext ones it doesn't allow, rather than silently re-running
+// their steps or trusting them. Opening an encrypted log without
+// WithEncryption fails too.
+func WithEncryption(ring *secrets.Keyring) Option {
+	return func(t *Tracker) { t.keys = ring }
+}
+
+// decrypt returns the plaintext of log data read from disk.
+func (t *Tracker) decrypt(data []byte) ([]byte, error) {
+	if t.keys == nil {
+		for line := range bytes.Lines(data) {
+			if secrets.IsSealed(line) {
+				return nil, fmt.Errorf("checkpoint: log is encrypted; open the tracker WithEncryption")
+			}
+		}
+		return data, nil
+	}
+	plain, err := io.ReadAll(t.keys.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: decrypt: %w", err)
+	}
+	return plain, nil
+}
+
+// encodeRecord returns r as line n of the log, sealed if the tracker
+// encrypts.
+func (t *Tracker) encodeRecord(r *Record, n int64) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	if t.keys == nil {
+		return data, nil
+	}
+	line, err := t.keys.SealLineAt(data, n)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// logLockPath returns the lock shared trackers of an encrypted log take
+// to append, so each knows the position of the record it seals.
+func (t *Tracker) logLockPath() string {
+	return filepath.Join(t.dir, t.runID+".leases", "log.lock")
+}
+
+// catchUp counts the lines other workers have appended to the log since
+// this tracker last did. The caller holds t.mu and the log lock.
+func (t *Tracker) catchUp() error {
+	f, err := os.Open(t.logPath())
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(t.size, io.SeekStart); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	t.size += int64(len(rest))
+	t.lines += int64(bytes.Count(rest, []byte{'\n'}))
+	return nil
+}
+
+// sealArtifact returns artifact data as stored, sealed if the tracker
+// encrypts. It is sealed as the first line of its file, so mist secrets
+// rekey rewrites artifacts as it does logs.
+func (t *Tracker) sealArtifact(data []byte) ([]byte, error) {
+	if t.keys == nil {
+		return data, nil
+	}
+	return t.keys.SealLineAt(data, 0)
+}
+
+// openArtifact returns the plaintext of stored artifact data.
+func (t *Tracker) openArtifact(data []byte) ([]byte, error) {
+	if !secrets.IsSealed(data) {
+		return data, nil
+	}
+	if t.keys == nil {
+		return nil, fmt.Errorf("checkpoint: artifact is encrypted; open the tracker WithEncryption")
+	}
+	return t.keys.OpenLineAt(data, 0)
+}
@@ -0,0 +1,176 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/secrets"
+)
+
+func testKeyring(t *testing.T, old ...*secrets.Key) *secrets.Keyring {
+	t.Helper()
+	k, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secrets.NewKeyring(k, old...)
+}
+
+func TestEncryption(t *testing.T) {
+	dir := tmpDir(t)
+	ctx := context.Background()
+	big := "prompt:" + strings.Repeat("s", 500)
+
+	// A run started in plaintext is resumed with encryption on.
+	cp, _ := Open(dir, "run-enc")
+	cp.Step(ctx, "plain", func(context.Context) (any, error) { return "before", nil })
+	cp.Close()
+
+	ring := testKeyring(t)
+	if _, err := Open(dir, "run-enc", WithEncryption(ring)); !errors.Is(err, secrets.ErrPlaintext) {
+		t.Fatalf("plaintext log opened without AllowPlaintext: %v", err)
+	}
+	cp, err := Open(dir, "run-enc", WithEncryption(ring.AllowPlaintext()), WithArtifactThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.IsCompleted("plain") {
+		t.Error("plaintext record not replayed")
+	}
+	cp.Step(ctx, "secret", func(context.Context) (any, error) { return "result:private", nil })
+	cp.Step(ctx, "big", func(context.Context) (any, error) { return big, nil })
+	cp.Close()
+
+	log, _ := os.ReadFile(filepath.Join(dir, "run-enc.jsonl"))
+	if strings.Contains(string(log), "private") || strings.Contains(string(log), `"step":"secret"`) {
+		t.Errorf("log has plaintext: %s", log)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "artifacts", "*"))
+	for _, f := range files {
+		if data, _ := os.ReadFile(f); strings.Contains(string(data), "prompt:") {
+			t.Error("artifact stored in plaintext")
+		}
+	}
+
+	if _, err := Open(dir, "run-enc", WithEncryption(testKeyring(t))); err == nil {
+		t.Error("expected error opening with the wrong key")
+	}
+
+	if _, err := Open(dir, "run-enc"); err == nil {
+		t.Error("encrypted log opened without WithEncryption")
+	}
+
+	cp, err = Open(dir, "run-enc", WithEncryption(ring.AllowPlaintext()), WithArtifactThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Result("secret") != "result:private" || cp.Result("big") != big || !cp.IsCompleted("plain") {
+		t.Errorf("results = %v, %v, %v", cp.Result("plain"), cp.Result("secret"), cp.Result("big"))
+	}
+	if err := cp.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	log, _ = os.ReadFile(filepath.Join(dir, "run-enc.jsonl"))
+	for _, line := range strings.Split(strings.TrimSpace(string(log)), "\n") {
+		if !secrets.IsSealed([]byte(line)) {
+			t.Errorf("compacted line not sealed: %s", line)
+		}
+	}
+	// Once compacted, the log opens without allowing plaintext.
+	cp, err = Open(dir, "run-enc", WithEncryption(ring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+}
+
+func TestEncryptionRefusesForgedRecords(t *testing.T) {
+	dir := tmpDir(t)
+	ctx := context.Background()
+	ring := testKeyring(t)
+	cp, _ := Open(dir, "run-forged", WithEncryption(ring))
+	cp.Step(ctx, "a", func(context.Context) (any, error) { return "a", nil })
+	cp.Step(ctx, "b", func(context.Context) (any, error) { return "b", nil })
+	cp.Close()
+	path := filepath.Join(dir, "run-forged.jsonl")
+	log, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(log), "\n")
+
+	for name, forged := range map[string]string{
+		"plaintext record": string(log) + `{"step":"c","status":"completed","result":"forged"}` + "\n",
+		"reordered":        lines[2] + lines[3] + lines[0] + lines[1],
+		"replayed":         string(log) + lines[1],
+	} {
+		os.WriteFile(path, []byte(forged), 0o600)
+		if cp, err := Open(dir, "run-forged", WithEncryption(ring)); err == nil {
+			cp.Close()
+			t.Errorf("%s: log opened", name)
+		}
+	}
+}
+
+func TestEncryptionShared(t *testing.T) {
+	dir := tmpDir(t)
+	ctx := context.Background()
+	ring := testKeyring(t)
+	w1, err := OpenShared(dir, "run-shared", WithEncryption(ring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w1.Close()
+	w2, err := OpenShared(dir, "run-shared", WithEncryption(ring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	// Each worker seals its records after those the other appended.
+	w1.Step(ctx, "a", func(context.Context) (any, error) { return 1, nil })
+	w2.Step(ctx, "b", func(context.Context) (any, error) { return 2, nil })
+	w1.Step(ctx, "c", func(context.Context) (any, error) { return 3, nil })
+
+	cp, err := OpenShared(dir, "run-shared", WithEncryption(ring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	for _, step := range []string{"a", "b", "c"} {
+		if !cp.IsCompleted(step) {
+			t.Errorf("step %s not replayed", step)
+		}
+	}
+}
+
+func TestEncryptionRekey(t *testing.T) {
+	dir := tmpDir(t)
+	old := testKeyring(t)
+	big := strings.Repeat("b", 500)
+	cp, _ := Open(dir, "run-rekey", WithEncryption(old), WithArtifactThreshold(100))
+	cp.Step(context.Background(), "a", func(context.Context) (any, error) { return 1, nil })
+	cp.Step(context.Background(), "big", func(context.Context) (any, error) { return big, nil })
+	cp.Close()
+
+	ring := testKeyring(t, old.Primary())
+	files, _ := filepath.Glob(filepath.Join(dir, "artifacts", "*"))
+	for _, path := range append(files, filepath.Join(dir, "run-rekey.jsonl")) {
+		if _, err := secrets.RekeyFile(path, ring); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// After rekeying, the new key alone opens the log.
+	cp, err := Open(dir, "run-rekey", WithEncryption(secrets.NewKeyring(ring.Primary())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if !cp.IsCompleted("a") || cp.Result("big") != big {
+		t.Errorf("after rekey: a completed %v, big = %.10v", cp.IsCompleted("a"), cp.Result("big"))
+	}
+}
@@ -0,0 +1,115 @@
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/greynewell/mist-go/platform"
+)
+
+// ErrConflict is returned when another tracker owns the run (Open) or is
+// currently executing the same step (OpenShared). Check with errors.Is.
+var ErrConflict = errors.New("checkpoint: held by another process")
+
+// OpenShared opens a run for cooperative execution by several processes.
+// Unlike Open, it does not take ownership of the whole run. Instead each
+// Step acquires a lease on that step alone: the first worker to claim a
+// step runs it, and workers arriving later either skip it (if it has
+// since completed) or get an error wrapping ErrConflict (if it is still
+// running elsewhere).
+//
+// OpenShared fails with ErrConflict if the run is held exclusively by
+// Open. Shared trackers hold a shared lock on the run, which Open's
+// exclusive lock is refused while any remain. Shared trackers never
+// compact the log, since other workers may be appending to it;
+// WithMaxLogSize has no effect on them.
+func OpenShared(dir, runID string, opts ...Option) (*Tracker, error) {
+	t, err := open(dir, runID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := platform.TryRLock(t.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	if lock == nil {
+		return nil, fmt.Errorf("checkpoint: run %q: %w", runID, ErrConflict)
+	}
+	t.lock = lock
+
+	t.shared = true
+	if err := t.openLog(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tracker) lockPath() string {
+	return filepath.Join(t.dir, t.runID+".lock")
+}
+
+// leasePath returns the lease file for a step. Step names are hashed so
+// any name maps to a safe file name.
+func (t *Tracker) leasePath(step string) string {
+	sum := sha256.Sum256([]byte(step))
+	return filepath.Join(t.dir, t.runID+".leases", hex.EncodeToString(sum[:8])+".lock")
+}
+
+// claim decides whether the caller should execute a step. It reports
+// done when the step has already completed. Otherwise the returned
+// release func must be called once the step's final record is written.
+// Shared trackers take a step lease and re-read the log first, since
+// another worker may have finished the step since this tracker opened.
+func (t *Tracker) claim(step string) (release func(), done bool, err error) {
+	t.mu.Lock()
+	_, done = t.completed[step]
+	t.mu.Unlock()
+	if done || !t.shared {
+		return func() {}, done, nil
+	}
+
+	lease, err := platform.TryLock(t.leasePath(step))
+	if err != nil {
+		return nil, false, fmt.Errorf("checkpoint: %w", err)
+	}
+	if lease == nil {
+		return nil, false, fmt.Errorf("checkpoint: step %q: %w", step, ErrConflict)
+	}
+
+	if err := t.refresh(); err != nil {
+		lease.Unlock()
+		return nil, false, err
+	}
+	t.mu.Lock()
+	_, done = t.completed[step]
+	t.mu.Unlock()
+	if done {
+		lease.Unlock()
+		return func() {}, true, nil
+	}
+	return func() { lease.Unlock() }, false, nil
+}
+
+// refresh rebuilds completed state from the log on disk.
+func (t *Tracker) refresh() error {
+	data, err := os.ReadFile(t.logPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("checkpoint: read %s: %w", t.logPath(), err)
+	}
+	if data, err = t.decrypt(data); err != nil {
+		return fmt.Errorf("checkpoint: %s: %w", t.logPath(), err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.completed = make(map[string]*Record)
+	t.results = make(map[string]any)
+	t.replay(data)
+	return nil
+}
@@ -0,0 +1,180 @@
+package checkpoint
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestOpenExclusive(t *testing.T) {
+	dir := tmpDir(t)
+	first, err := Open(dir, "run-x")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if _, err := Open(dir, "run-x"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second Open err = %v, want ErrConflict", err)
+	}
+	if _, err := OpenShared(dir, "run-x"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("OpenShared err = %v, want ErrConflict", err)
+	}
+
+	// A different run in the same directory is unaffected.
+	other, err := Open(dir, "run-y")
+	if err != nil {
+		t.Fatalf("Open other run: %v", err)
+	}
+	other.Close()
+
+	first.Close()
+	second, err := Open(dir, "run-x")
+	if err != nil {
+		t.Fatalf("Open after Close: %v", err)
+	}
+	second.Close()
+
+	// Shared workers exclude Open in turn, until the last one closes.
+	a, _ := OpenShared(dir, "run-x")
+	b, _ := OpenShared(dir, "run-x")
+	if _, err := Open(dir, "run-x"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Open with shared workers err = %v, want ErrConflict", err)
+	}
+	a.Close()
+	if _, err := Open(dir, "run-x"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Open with a shared worker err = %v, want ErrConflict", err)
+	}
+	b.Close()
+	third, err := Open(dir, "run-x")
+	if err != nil {
+		t.Fatalf("Open after shared workers closed: %v", err)
+	}
+	third.Close()
+}
+
+// TestOpenAcrossProcesses holds a run in a child process, started from
+// this test binary, and opens it here.
+func TestOpenAcrossProcesses(t *testing.T) {
+	if mode := os.Getenv("CHECKPOINT_TEST_HOLD"); mode != "" {
+		holdRun(mode, os.Getenv("CHECKPOINT_TEST_DIR"))
+		return
+	}
+	dir := tmpDir(t)
+	for _, tc := range []struct {
+		hold  string
+		open  func(string, string, ...Option) (*Tracker, error)
+		which string
+	}{
+		{"exclusive", OpenShared, "OpenShared"},
+		{"exclusive", Open, "Open"},
+		{"shared", Open, "Open"},
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestOpenAcrossProcesses$")
+		cmd.Env = append(os.Environ(), "CHECKPOINT_TEST_HOLD="+tc.hold, "CHECKPOINT_TEST_DIR="+dir)
+		stdin, _ := cmd.StdinPipe()
+		stdout, _ := cmd.StdoutPipe()
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if line != "held\n" {
+			t.Fatalf("child holding %s: %q, %v", tc.hold, line, err)
+		}
+		if _, err := tc.open(dir, "run-p"); !errors.Is(err, ErrConflict) {
+			t.Errorf("%s while another process holds the run %s: err = %v, want ErrConflict", tc.which, tc.hold, err)
+		}
+		stdin.Close()
+		cmd.Wait()
+	}
+
+	tr, err := Open(dir, "run-p")
+	if err != nil {
+		t.Fatalf("Open after the child exited: %v", err)
+	}
+	tr.Close()
+}
+
+// holdRun opens run-p in dir as mode says, reports it on stdout, and
+// holds it until stdin closes.
+func holdRun(mode, dir string) {
+	open := Open
+	if mode == "shared" {
+		open = OpenShared
+	}
+	tr, err := open(dir, "run-p")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("held")
+	io.Copy(io.Discard, os.Stdin)
+	tr.Close()
+}
+
+func TestOpenSharedRunsStepOnce(t *testing.T) {
+	dir := tmpDir(t)
+	a, err := OpenShared(dir, "run-s")
+	if err != nil {
+		t.Fatalf("OpenShared: %v", err)
+	}
+	defer a.Close()
+	b, err := OpenShared(dir, "run-s")
+	if err != nil {
+		t.Fatalf("OpenShared: %v", err)
+	}
+	defer b.Close()
+
+	var calls int
+	fn := func(_ context.Context) (any, error) {
+		calls++
+		return "done", nil
+	}
+
+	if err := a.Step(context.Background(), "fetch", fn); err != nil {
+		t.Fatalf("a.Step: %v", err)
+	}
+	// b opened before the step ran, but sees it completed once it
+	// takes the lease.
+	if err := b.Step(context.Background(), "fetch", fn); err != nil {
+		t.Fatalf("b.Step: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("step ran %d times, want 1", calls)
+	}
+	if b.Result("fetch") != "done" {
+		t.Errorf("b.Result = %v, want done", b.Result("fetch"))
+	}
+}
+
+func TestOpenSharedLeaseConflict(t *testing.T) {
+	dir := tmpDir(t)
+	a, _ := OpenShared(dir, "run-l")
+	defer a.Close()
+	b, _ := OpenShared(dir, "run-l")
+	defer b.Close()
+
+	var inner error
+	err := a.Step(context.Background(), "train", func(ctx context.Context) (any, error) {
+		inner = b.Step(ctx, "train", func(context.Context) (any, error) {
+			t.Error("step should not run while leased")
+			return nil, nil
+		})
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("a.Step: %v", err)
+	}
+	if !errors.Is(inner, ErrConflict) {
+		t.Errorf("concurrent step err = %v, want ErrConflict", inner)
+	}
+
+	// Lease is released once the step finishes.
+	if err := b.Step(context.Background(), "eval", func(context.Context) (any, error) { return nil, nil }); err != nil {
+		t.Errorf("b.Step eval: %v", err)
+	}
+}
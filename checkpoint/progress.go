@@ -0,0 +1,181 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Progress describes how far a run has advanced through its plan.
+// Weights are expected step durations in milliseconds, taken from earlier
+// runs in the same checkpoint directory; when no timing is known at all,
+// every step weighs 1 and ETAMS is zero.
+type Progress struct {
+	RunID           string  `json:"run_id"`
+	Completed       int     `json:"completed"`
+	Total           int     `json:"total"`
+	CompletedWeight float64 `json:"completed_weight"`
+	TotalWeight     float64 `json:"total_weight"`
+	Percent         float64 `json:"percent"`
+	ETAMS           int64   `json:"eta_ms"`
+}
+
+// ETA returns the estimated time remaining, or zero if unknown.
+func (p Progress) ETA() time.Duration {
+	return time.Duration(p.ETAMS) * time.Millisecond
+}
+
+// Message wraps the progress in a job.progress protocol message.
+func (p Progress) Message(source string, state RunState) (*protocol.Message, error) {
+	return protocol.New(source, protocol.TypeJobProgress, protocol.JobProgress{
+		RunID:     p.RunID,
+		Completed: p.Completed,
+		Total:     p.Total,
+		Percent:   p.Percent,
+		ETAMS:     p.ETAMS,
+		State:     string(state),
+	})
+}
+
+// WithProgressFunc registers fn to be called after each step completes,
+// for example to send job.progress messages:
+//
+//	checkpoint.WithProgressFunc(func(p checkpoint.Progress) {
+//	    msg, _ := p.Message("matchspec", checkpoint.RunActive)
+//	    t.Send(ctx, msg)
+//	})
+func WithProgressFunc(fn func(Progress)) Option {
+	return func(t *Tracker) { t.onProgress = fn }
+}
+
+// Plan declares the steps this run is expected to execute. Progress is
+// measured against the plan; steps that complete without being planned
+// are counted as well.
+func (t *Tracker) Plan(steps ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.plan = append([]string(nil), steps...)
+}
+
+// Progress reports completed versus total weight and an ETA for the
+// remaining planned steps.
+func (t *Tracker) Progress() Progress {
+	history := t.loadHistory()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	steps := append([]string(nil), t.plan...)
+	planned := make(map[string]bool, len(steps))
+	for _, s := range steps {
+		planned[s] = true
+	}
+	for s := range t.completed {
+		if !planned[s] {
+			steps = append(steps, s)
+		}
+	}
+
+	// Fall back to the mean of every known duration for steps without
+	// history of their own.
+	var sum float64
+	var n int
+	for _, d := range history {
+		sum += d
+		n++
+	}
+	for _, r := range t.completed {
+		if r.DurationMS > 0 {
+			sum += float64(r.DurationMS)
+			n++
+		}
+	}
+	fallback, timed := 1.0, n > 0
+	if timed {
+		fallback = sum / float64(n)
+	}
+
+	p := Progress{RunID: t.runID, Total: len(steps)}
+	for _, s := range steps {
+		w, ok := history[s]
+		if !ok {
+			w = fallback
+		}
+		if r, done := t.completed[s]; done {
+			if r.DurationMS > 0 {
+				w = float64(r.DurationMS)
+			}
+			p.Completed++
+			p.CompletedWeight += w
+		}
+		p.TotalWeight += w
+	}
+
+	if p.TotalWeight > 0 {
+		p.Percent = 100 * p.CompletedWeight / p.TotalWeight
+	}
+	if timed {
+		p.ETAMS = int64(p.TotalWeight - p.CompletedWeight)
+	}
+	return p
+}
+
+func (t *Tracker) notifyProgress() {
+	if t.onProgress != nil {
+		t.onProgress(t.Progress())
+	}
+}
+
+// loadHistory computes mean completed-step durations across the other
+// run logs in the checkpoint directory. The result is cached for the
+// lifetime of the tracker.
+func (t *Tracker) loadHistory() map[string]float64 {
+	t.mu.Lock()
+	if t.history != nil {
+		defer t.mu.Unlock()
+		return t.history
+	}
+	t.mu.Unlock()
+
+	paths, _ := filepath.Glob(filepath.Join(t.dir, "*.jsonl"))
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, path := range paths {
+		if strings.TrimSuffix(filepath.Base(path), ".jsonl") == t.runID {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if data, err = t.decrypt(data); err != nil {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for dec.More() {
+			var r Record
+			if err := dec.Decode(&r); err != nil {
+				break
+			}
+			if r.Status == StatusCompleted && r.DurationMS > 0 {
+				sums[r.Step] += float64(r.DurationMS)
+				counts[r.Step]++
+			}
+		}
+	}
+
+	history := make(map[string]float64, len(sums))
+	for s, sum := range sums {
+		history[s] = sum / float64(counts[s])
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.history = history
+	return history
+}
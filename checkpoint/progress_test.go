@@ -0,0 +1,109 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// writeHistory writes a finished run log with the given step durations.
+func writeHistory(t *testing.T, dir, runID string, durations map[string]int64) {
+	t.Helper()
+	os.MkdirAll(dir, 0o700)
+	f, err := os.Create(filepath.Join(dir, runID+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for step, ms := range durations {
+		enc.Encode(Record{Step: step, Status: StatusCompleted, Timestamp: time.Now(), DurationMS: ms})
+	}
+}
+
+func TestProgressUsesHistory(t *testing.T) {
+	dir := tmpDir(t)
+	writeHistory(t, dir, "prev-1", map[string]int64{"download": 1000, "train": 8000})
+	writeHistory(t, dir, "prev-2", map[string]int64{"download": 3000, "train": 8000})
+
+	cp, err := Open(dir, "run-now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	cp.Plan("download", "train", "report")
+
+	p := cp.Progress()
+	if p.Total != 3 || p.Completed != 0 {
+		t.Fatalf("progress = %+v", p)
+	}
+	// download 2000 + train 8000 + report (unknown, mean of history 5000).
+	if p.TotalWeight != 15000 {
+		t.Errorf("total weight = %v, want 15000", p.TotalWeight)
+	}
+	if p.ETA() != 15*time.Second {
+		t.Errorf("ETA = %v, want 15s", p.ETA())
+	}
+
+	cp.Step(context.Background(), "download", func(context.Context) (any, error) { return nil, nil })
+	p = cp.Progress()
+	if p.Completed != 1 {
+		t.Errorf("completed = %d, want 1", p.Completed)
+	}
+	if p.Percent <= 0 || p.Percent >= 50 {
+		t.Errorf("percent = %.1f, want a small share", p.Percent)
+	}
+}
+
+func TestProgressWithoutHistory(t *testing.T) {
+	cp, _ := Open(tmpDir(t), "run-fresh")
+	defer cp.Close()
+	cp.Plan("a", "b", "c", "d")
+
+	cp.Step(context.Background(), "a", func(context.Context) (any, error) { return nil, nil })
+
+	p := cp.Progress()
+	if p.Completed != 1 || p.Total != 4 {
+		t.Fatalf("progress = %+v", p)
+	}
+	if p.Percent != 25 {
+		t.Errorf("percent = %v, want 25 with uniform weights", p.Percent)
+	}
+}
+
+func TestProgressFuncAndMessage(t *testing.T) {
+	var got []Progress
+	cp, _ := Open(tmpDir(t), "run-notify", WithProgressFunc(func(p Progress) {
+		got = append(got, p)
+	}))
+	defer cp.Close()
+	cp.Plan("a", "b")
+
+	ctx := context.Background()
+	cp.Step(ctx, "a", func(context.Context) (any, error) { return nil, nil })
+	cp.StepRetry(ctx, "b", 2, func(context.Context) (any, error) { return nil, nil })
+
+	if len(got) != 2 || got[1].Completed != 2 || got[1].Percent != 100 {
+		t.Fatalf("progress callbacks = %+v", got)
+	}
+
+	msg, err := got[1].Message("matchspec", cp.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != protocol.TypeJobProgress {
+		t.Errorf("type = %s", msg.Type)
+	}
+	var jp protocol.JobProgress
+	if err := msg.Decode(&jp); err != nil {
+		t.Fatal(err)
+	}
+	if jp.RunID != "run-notify" || jp.Completed != 2 || jp.State != "running" {
+		t.Errorf("payload = %+v", jp)
+	}
+}
@@ -65,6 +65,11 @@ type Config struct {
 	// HalfOpenMax is the maximum number of concurrent probe requests
 	// allowed in the half-open state.
 	HalfOpenMax int
+
+	// OnStateChange, if set, is called after each state transition, for
+	// example to alert when the breaker trips open. It runs without the
+	// breaker's lock held.
+	OnStateChange func(from, to State)
 }
 
 // Breaker is a circuit breaker that tracks failures and controls access.
@@ -78,8 +83,11 @@ type Breaker struct {
 	consecutFail     int
 	openedAt         time.Time
 	halfOpenInFlight int32
+	changes          []transition // awaiting OnStateChange
 }
 
+type transition struct{ from, to State }
+
 // New creates a circuit breaker with the given configuration.
 func New(cfg Config) *Breaker {
 	if cfg.Threshold < 1 {
@@ -98,15 +106,33 @@ func New(cfg Config) *Breaker {
 // State returns the current circuit breaker state.
 func (b *Breaker) State() State {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	defer b.unlock()
 	return b.currentState()
 }
 
+// setState transitions to s. Must be called with mu held.
+func (b *Breaker) setState(s State) {
+	if b.cfg.OnStateChange != nil && s != b.state {
+		b.changes = append(b.changes, transition{b.state, s})
+	}
+	b.state = s
+}
+
+// unlock releases mu, then reports transitions made while it was held.
+func (b *Breaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	for _, c := range changes {
+		b.cfg.OnStateChange(c.from, c.to)
+	}
+}
+
 // currentState returns the state, transitioning open→half-open if timeout has elapsed.
 // Must be called with mu held.
 func (b *Breaker) currentState() State {
 	if b.state == Open && time.Since(b.openedAt) >= b.cfg.Timeout {
-		b.state = HalfOpen
+		b.setState(HalfOpen)
 		b.halfOpenInFlight = 0
 	}
 	return b.state
@@ -117,6 +143,24 @@ func (b *Breaker) Counts() (successes, failures int64) {
 	return atomic.LoadInt64(&b.successes), atomic.LoadInt64(&b.failures)
 }
 
+// Stats is a breaker's state and call counts.
+type Stats struct {
+	State     string `json:"state"`
+	Successes int64  `json:"successes"`
+	Failures  int64  `json:"failures"`
+}
+
+// Stats returns the state and counts, read together.
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.unlock()
+	return Stats{
+		State:     b.currentState().String(),
+		Successes: atomic.LoadInt64(&b.successes),
+		Failures:  atomic.LoadInt64(&b.failures),
+	}
+}
+
 // Do executes fn if the circuit breaker allows it. Returns ErrOpen if the
 // breaker is open. Context cancellation errors do not count toward the
 // failure threshold.
@@ -148,7 +192,7 @@ func (b *Breaker) DoWithFallback(ctx context.Context, fn func(ctx context.Contex
 // beforeCall checks if the request is allowed through.
 func (b *Breaker) beforeCall() error {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	defer b.unlock()
 
 	switch b.currentState() {
 	case Closed:
@@ -174,7 +218,7 @@ func (b *Breaker) afterCall(err error, ctx context.Context) {
 	}
 
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	defer b.unlock()
 
 	if err == nil {
 		atomic.AddInt64(&b.successes, 1)
@@ -192,7 +236,7 @@ func (b *Breaker) onSuccess() {
 		b.consecutFail = 0
 	case HalfOpen:
 		// Probe succeeded — close the circuit.
-		b.state = Closed
+		b.setState(Closed)
 		b.consecutFail = 0
 		b.halfOpenInFlight = 0
 	}
@@ -204,12 +248,12 @@ func (b *Breaker) onFailure() {
 	case Closed:
 		b.consecutFail++
 		if b.consecutFail >= b.cfg.Threshold {
-			b.state = Open
+			b.setState(Open)
 			b.openedAt = time.Now()
 		}
 	case HalfOpen:
 		// Probe failed — reopen.
-		b.state = Open
+		b.setState(Open)
 		b.openedAt = time.Now()
 		b.halfOpenInFlight = 0
 	}
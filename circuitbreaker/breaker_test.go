@@ -295,6 +295,17 @@ func TestCounts(t *testing.T) {
 	}
 }
 
+func TestStats(t *testing.T) {
+	cb := New(Config{Threshold: 1, Timeout: time.Hour})
+	cb.Do(context.Background(), func(ctx context.Context) error { return nil })
+	cb.Do(context.Background(), func(ctx context.Context) error { return fmt.Errorf("x") })
+
+	want := Stats{State: "open", Successes: 1, Failures: 1}
+	if got := cb.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
 func TestContextCancellation(t *testing.T) {
 	cb := New(Config{
 		Threshold:   5,
@@ -318,3 +329,30 @@ func TestContextCancellation(t *testing.T) {
 		t.Errorf("failures = %d, want 0 (context errors don't trip)", f)
 	}
 }
+
+func TestOnStateChange(t *testing.T) {
+	var got []string
+	cb := New(Config{
+		Threshold: 1,
+		Timeout:   10 * time.Millisecond,
+		OnStateChange: func(from, to State) {
+			got = append(got, from.String()+"→"+to.String())
+		},
+	})
+	fail := func(context.Context) error { return fmt.Errorf("down") }
+	ok := func(context.Context) error { return nil }
+
+	cb.Do(context.Background(), fail)
+	time.Sleep(15 * time.Millisecond)
+	cb.Do(context.Background(), ok)
+
+	want := []string{"closed→open", "open→half-open", "half-open→closed"}
+	if len(got) != len(want) {
+		t.Fatalf("transitions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transitions = %v, want %v", got, want)
+		}
+	}
+}
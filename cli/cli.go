@@ -19,12 +19,16 @@
 package cli
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strings"
 	"text/tabwriter"
+
+	misterrors "github.com/greynewell/mist-go/errors"
 )
 
 // App is the top-level CLI application.
@@ -32,8 +36,10 @@ type App struct {
 	Name     string
 	Version  string
 	commands map[string]*Command
-	order    []string // insertion order for help display
+	aliases  map[string]string // alias → command name
+	order    []string          // insertion order for help display
 	out      io.Writer
+	ran      *Command // command selected by the last Execute, for error output
 }
 
 // Command is a single CLI subcommand with its own flag set.
@@ -43,6 +49,18 @@ type Command struct {
 	Flags *flag.FlagSet
 	Run   func(cmd *Command, args []string) error
 
+	// Aliases are alternative names that invoke this command (e.g. "rm"
+	// for "remove"). They are shown in help but not listed separately.
+	Aliases []string
+
+	// Hidden excludes the command from the app's command listing. It can
+	// still be invoked and has its own --help.
+	Hidden bool
+
+	// Deprecated, if non-empty, is printed as a warning each time the
+	// command is used, e.g. "use 'mist trace diff' instead".
+	Deprecated string
+
 	// Set by App when the command is registered, for help output.
 	appName string
 }
@@ -53,6 +71,7 @@ func NewApp(name, version string) *App {
 		Name:     name,
 		Version:  version,
 		commands: make(map[string]*Command),
+		aliases:  make(map[string]string),
 		out:      os.Stderr,
 	}
 	a.AddCommand(&Command{
@@ -80,6 +99,22 @@ func (a *App) AddCommand(c *Command) {
 		a.order = append(a.order, c.Name)
 	}
 	a.commands[c.Name] = c
+	for _, alias := range c.Aliases {
+		a.aliases[alias] = c.Name
+	}
+}
+
+// lookup resolves a command by name or alias. Real command names take
+// precedence over aliases.
+func (a *App) lookup(name string) (*Command, bool) {
+	if c, ok := a.commands[name]; ok {
+		return c, true
+	}
+	if target, ok := a.aliases[name]; ok {
+		c, ok := a.commands[target]
+		return c, ok
+	}
+	return nil, false
 }
 
 // Execute parses the argument list and runs the matching subcommand.
@@ -89,26 +124,81 @@ func (a *App) Execute(args []string) error {
 		return nil
 	}
 
+	a.ran = nil
+
 	name := args[0]
 	if name == "-h" || name == "--help" || name == "help" {
 		a.printUsage()
 		return nil
 	}
 
-	cmd, ok := a.commands[name]
+	cmd, ok := a.lookup(name)
 	if !ok {
 		fmt.Fprintf(a.out, "unknown command: %s\n\n", name)
 		a.printUsage()
-		return fmt.Errorf("unknown command: %s", name)
+		return Usagef("unknown command: %s", name)
+	}
+	a.ran = cmd
+
+	if cmd.Deprecated != "" {
+		fmt.Fprintf(a.out, "warning: command %q is deprecated: %s\n", cmd.Name, cmd.Deprecated)
 	}
 
 	if err := cmd.Flags.Parse(args[1:]); err != nil {
-		return err
+		if err == flag.ErrHelp {
+			return err
+		}
+		return misterrors.Wrap(misterrors.CodeValidation, err, "invalid flags")
 	}
 
 	return cmd.Run(cmd, cmd.Flags.Args())
 }
 
+// Run executes the argument list and returns the process exit code.
+// Errors are printed to stderr — as a JSON object if the command defines
+// a "format" flag set to "json", as mist commands do, otherwise as plain
+// text. The exit code comes from ExitCode.
+func (a *App) Run(args []string) int {
+	err := a.Execute(args)
+	code := ExitCode(err)
+	if code == 0 {
+		return 0
+	}
+
+	if a.ran != nil && a.ran.GetString("format") == "json" {
+		var e *misterrors.Error
+		if !misterrors.As(err, &e) {
+			e = misterrors.New(misterrors.CodeInternal, err.Error())
+		}
+		json.NewEncoder(a.out).Encode(map[string]any{"error": e})
+	} else {
+		fmt.Fprintf(a.out, "error: %v\n", err)
+	}
+	return code
+}
+
+// ExecuteAndExit runs the argument list and exits the process with the
+// code returned by Run. Use it as the last line of main.
+func (a *App) ExecuteAndExit(args []string) {
+	os.Exit(a.Run(args))
+}
+
+// Usagef returns an error describing invalid command-line usage. Usage
+// errors carry the validation code and so exit with status 2.
+func Usagef(format string, args ...any) error {
+	return misterrors.Newf(misterrors.CodeValidation, format, args...)
+}
+
+// ExitCode maps an error returned by Execute to a process exit code:
+// 0 for success (including --help), the errors package mapping for
+// MIST errors (2 for usage errors), and 1 for any other failure.
+func ExitCode(err error) int {
+	if err == nil || err == flag.ErrHelp {
+		return 0
+	}
+	return misterrors.ExitCode(misterrors.Code(err))
+}
+
 // --- Flag definition helpers ---
 
 // AddStringFlag defines a string flag on this command.
@@ -224,6 +314,12 @@ func (c *Command) printHelp(w io.Writer) {
 	if c.Usage != "" {
 		fmt.Fprintf(w, "\n%s\n", c.Usage)
 	}
+	if len(c.Aliases) > 0 {
+		fmt.Fprintf(w, "\nAliases: %s\n", strings.Join(c.Aliases, ", "))
+	}
+	if c.Deprecated != "" {
+		fmt.Fprintf(w, "\nDeprecated: %s\n", c.Deprecated)
+	}
 
 	// Count defined flags.
 	hasFlags := false
@@ -247,12 +343,21 @@ func (a *App) printUsage() {
 	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\n", a.Name)
 	fmt.Fprintln(w, "Commands:")
 
-	names := make([]string, len(a.order))
-	copy(names, a.order)
+	names := make([]string, 0, len(a.order))
+	for _, name := range a.order {
+		if !a.commands[name].Hidden {
+			names = append(names, name)
+		}
+	}
 	sort.Strings(names)
 
 	for _, name := range names {
-		fmt.Fprintf(w, "  %s\t%s\n", name, a.commands[name].Usage)
+		c := a.commands[name]
+		label := name
+		if len(c.Aliases) > 0 {
+			label += " (" + strings.Join(c.Aliases, ", ") + ")"
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", label, c.Usage)
 	}
 	fmt.Fprintf(w, "\nRun '%s <command> --help' for command-specific flags.\n", a.Name)
 	w.Flush()
@@ -2,8 +2,12 @@ package cli
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"strings"
 	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
 )
 
 func TestNewAppHasVersionCommand(t *testing.T) {
@@ -425,3 +429,152 @@ func TestCommandInitFlagsIdempotent(t *testing.T) {
 		t.Error("initFlags should not replace existing FlagSet")
 	}
 }
+
+func TestExitCodes(t *testing.T) {
+	app := NewApp("test", "1.0.0")
+	app.out = &bytes.Buffer{}
+	app.AddCommand(&Command{
+		Name: "fail",
+		Run: func(_ *Command, _ []string) error {
+			return misterrors.New(misterrors.CodeNotFound, "no such suite")
+		},
+	})
+	app.AddCommand(&Command{
+		Name: "plain",
+		Run:  func(_ *Command, _ []string) error { return fmt.Errorf("boom") },
+	})
+	app.AddCommand(&Command{
+		Name: "usage",
+		Run:  func(_ *Command, _ []string) error { return Usagef("usage: test usage <x>") },
+	})
+	app.AddCommand(&Command{
+		Name: "ok",
+		Run:  func(_ *Command, _ []string) error { return nil },
+	})
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"ok"}, 0},
+		{[]string{"ok", "-h"}, 0},
+		{[]string{"nope"}, 2},
+		{[]string{"ok", "-bogus"}, 2},
+		{[]string{"usage"}, 2},
+		{[]string{"fail"}, 3},
+		{[]string{"plain"}, 1},
+	}
+	for _, tt := range tests {
+		if got := app.Run(tt.args); got != tt.want {
+			t.Errorf("Run(%v) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRunJSONError(t *testing.T) {
+	app := NewApp("test", "1.0.0")
+	var buf bytes.Buffer
+	app.out = &buf
+
+	cmd := &Command{
+		Name: "fail",
+		Run: func(_ *Command, _ []string) error {
+			return misterrors.New(misterrors.CodeTimeout, "took too long")
+		},
+	}
+	cmd.AddStringFlag("format", "text", "Output format")
+	app.AddCommand(cmd)
+
+	if code := app.Run([]string{"fail", "-format", "json"}); code != 5 {
+		t.Errorf("exit code = %d, want 5", code)
+	}
+
+	var out struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("stderr is not JSON: %v (%q)", err, buf.String())
+	}
+	if out.Error.Code != "timeout" || out.Error.Message != "took too long" {
+		t.Errorf("unexpected error JSON: %+v", out.Error)
+	}
+
+	buf.Reset()
+	app.Run([]string{"fail", "-format", "text"})
+	if !strings.HasPrefix(buf.String(), "error: ") {
+		t.Errorf("text output = %q, want 'error: ' prefix", buf.String())
+	}
+}
+
+func TestCommandAlias(t *testing.T) {
+	app := NewApp("test", "1.0.0")
+	var buf bytes.Buffer
+	app.out = &buf
+
+	ran := false
+	app.AddCommand(&Command{
+		Name:    "remove",
+		Usage:   "Remove a thing",
+		Aliases: []string{"rm"},
+		Run: func(cmd *Command, _ []string) error {
+			ran = cmd.Name == "remove"
+			return nil
+		},
+	})
+
+	if err := app.Execute([]string{"rm"}); err != nil {
+		t.Fatalf("Execute(rm): %v", err)
+	}
+	if !ran {
+		t.Error("alias should run the remove command")
+	}
+
+	app.Execute(nil)
+	if !strings.Contains(buf.String(), "remove (rm)") {
+		t.Errorf("help should list alias, got:\n%s", buf.String())
+	}
+}
+
+func TestCommandHidden(t *testing.T) {
+	app := NewApp("test", "1.0.0")
+	var buf bytes.Buffer
+	app.out = &buf
+
+	ran := false
+	app.AddCommand(&Command{
+		Name:   "secret",
+		Usage:  "Internal debugging",
+		Hidden: true,
+		Run:    func(_ *Command, _ []string) error { ran = true; return nil },
+	})
+
+	app.Execute(nil)
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("hidden command should not appear in help:\n%s", buf.String())
+	}
+	if err := app.Execute([]string{"secret"}); err != nil || !ran {
+		t.Errorf("hidden command should still run (err=%v, ran=%v)", err, ran)
+	}
+}
+
+func TestCommandDeprecated(t *testing.T) {
+	app := NewApp("test", "1.0.0")
+	var buf bytes.Buffer
+	app.out = &buf
+
+	app.AddCommand(&Command{
+		Name:       "old",
+		Deprecated: "use 'new' instead",
+		Run:        func(_ *Command, _ []string) error { return nil },
+	})
+
+	if err := app.Execute([]string{"old"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "deprecated: use 'new' instead") {
+		t.Errorf("expected deprecation warning, got %q", buf.String())
+	}
+}
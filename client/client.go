@@ -0,0 +1,214 @@
+// Package client provides Go clients for the HTTP APIs of MIST services,
+// so Go services don't have to hand-roll requests against them:
+//
+//	im := client.NewInferMux("http://infermux:8081", client.WithToken(os.Getenv("MIST_TOKEN")))
+//	resp, err := im.Infer(ctx, protocol.InferRequest{Model: "fast", Messages: msgs})
+//
+//	tt := client.NewTokenTrace("http://tokentrace:8700")
+//	stats, err := tt.Stats(ctx)
+//
+// Every call propagates the trace in ctx with a W3C traceparent header
+// and records a client span, retries transient failures (timeouts,
+// 429, 502, 503) with exponential backoff, and returns MIST errors whose
+// code reflects the server's response, so misterrors.Code and
+// misterrors.IsRetryable work on them.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/retry"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// Option configures a client.
+type Option func(*base)
+
+// WithHTTPClient sets the HTTP client used for requests. The default has
+// a 60-second timeout; streamed responses are bounded by ctx alone.
+func WithHTTPClient(c *http.Client) Option {
+	return func(b *base) { b.http = c }
+}
+
+// WithToken sends "Authorization: Bearer <token>" with every request.
+func WithToken(token string) Option {
+	return func(b *base) { b.token = token }
+}
+
+// WithRetry sets the retry policy for failed requests (default
+// retry.DefaultPolicy). Only retryable errors are retried; use
+// retry.Policy{MaxAttempts: 1} to turn retries off.
+func WithRetry(p retry.Policy) Option {
+	return func(b *base) { b.retry = p }
+}
+
+// base holds what every client shares: the service URL and how to reach
+// it.
+type base struct {
+	url     string
+	service string // e.g. "infermux", in errors and span names
+	http    *http.Client
+	stream  *http.Client // http without its timeout, for streamed responses
+	token   string
+	retry   retry.Policy
+}
+
+func newBase(baseURL, service string, opts []Option) base {
+	b := base{
+		url:     strings.TrimRight(baseURL, "/"),
+		service: service,
+		http:    &http.Client{Timeout: 60 * time.Second},
+		retry:   retry.DefaultPolicy,
+	}
+	for _, opt := range opts {
+		opt(&b)
+	}
+	stream := *b.http
+	stream.Timeout = 0
+	b.stream = &stream
+	return b
+}
+
+// call sends a request with a JSON body (if in is non-nil) and decodes a
+// JSON response into out (if non-nil), retrying transient failures.
+// name identifies the call in its span, e.g. "infer".
+func (b *base) call(ctx context.Context, name, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return misterrors.Wrap(misterrors.CodeValidation, err, b.service+": marshal request")
+		}
+	}
+
+	ctx, span := b.startSpan(ctx, name, method, path)
+	err := retry.DoAuto(ctx, b.retry, func(ctx context.Context) error {
+		resp, err := b.do(ctx, b.http, method, path, body, "application/json")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if out == nil {
+			io.Copy(io.Discard, resp.Body)
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return misterrors.Wrap(misterrors.CodeProtocol, err, b.service+": decode response").Permanent()
+		}
+		return nil
+	})
+	endSpan(span, err)
+	return err
+}
+
+// do sends one request and returns the response if its status is 2xx,
+// or the error it reports otherwise.
+func (b *base) do(ctx context.Context, hc *http.Client, method, path string, body []byte, accept string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.url+path, r)
+	if err != nil {
+		return nil, misterrors.Wrap(misterrors.CodeValidation, err, b.service).Permanent()
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", accept)
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	trace.InjectHTTP(ctx, req.Header)
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, misterrors.Wrap(misterrors.CodeCancelled, err, b.service).Permanent()
+		}
+		return nil, misterrors.Wrap(misterrors.CodeTransport, err, b.service)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, b.statusError(resp)
+	}
+	return resp, nil
+}
+
+// statusError converts an error response into a MIST error. JSON error
+// bodies written by misterrors.WriteHTTP keep their code and request ID;
+// other bodies are classified by status.
+func (b *base) statusError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error.Code != "" {
+		err := misterrors.Newf(body.Error.Code, "%s: %s (status %d)", b.service, body.Error.Message, resp.StatusCode)
+		if body.Error.RequestID != "" {
+			err = err.WithMeta("request_id", body.Error.RequestID)
+		}
+		return err
+	}
+	msg := strings.TrimSpace(string(data))
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return misterrors.Newf(codeForStatus(resp.StatusCode), "%s: %s (status %d)", b.service, msg, resp.StatusCode)
+}
+
+// codeForStatus maps an HTTP status to the MIST error code it most
+// likely came from, the inverse of misterrors.HTTPStatus. 502 means an
+// upstream failed, which may succeed on retry.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusMethodNotAllowed:
+		return misterrors.CodeValidation
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return misterrors.CodeAuth
+	case http.StatusNotFound:
+		return misterrors.CodeNotFound
+	case http.StatusConflict:
+		return misterrors.CodeConflict
+	case http.StatusTooManyRequests:
+		return misterrors.CodeRateLimit
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return misterrors.CodeTimeout
+	case http.StatusServiceUnavailable:
+		return misterrors.CodeUnavailable
+	case http.StatusBadGateway:
+		return misterrors.CodeTransport
+	default:
+		return misterrors.CodeInternal
+	}
+}
+
+// startSpan starts the client span for a call.
+func (b *base) startSpan(ctx context.Context, name, method, path string) (context.Context, *trace.Span) {
+	ctx, span := trace.Start(ctx, "client."+b.service+"."+name)
+	span.SetAttr("http.method", method)
+	span.SetAttr("http.path", path)
+	return ctx, span
+}
+
+// endSpan ends a client span with the outcome of its call.
+func endSpan(span *trace.Span, err error) {
+	if err != nil {
+		span.SetAttr("error", err.Error())
+		span.SetAttr("error.code", misterrors.Code(err))
+		span.End("error")
+		return
+	}
+	span.End("ok")
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/infermux"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/retry"
+	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/trace"
+)
+
+var fastRetry = WithRetry(retry.Policy{MaxAttempts: 3, InitialWait: time.Millisecond, MaxWait: time.Millisecond, Multiplier: 1})
+
+func inferMuxServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	reg := infermux.NewRegistry()
+	reg.Register(infermux.NewEchoProvider("echo", []string{"echo-v1"}, 0))
+	h := infermux.NewHandler(infermux.NewRouter(reg, tokentrace.NewReporter("infermux", "")), reg)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /infer", h.InferDirect)
+	mux.HandleFunc("GET /providers", h.Providers)
+	mux.HandleFunc("GET /models", h.Models)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func hello() protocol.InferRequest {
+	return protocol.InferRequest{Model: "echo-v1", Messages: []protocol.ChatMessage{{Role: "user", Content: "hello"}}}
+}
+
+func TestInferMux(t *testing.T) {
+	im := NewInferMux(inferMuxServer(t).URL + "/")
+	ctx := context.Background()
+
+	resp, err := im.Infer(ctx, hello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Provider != "echo" || !strings.Contains(resp.Content, "hello") {
+		t.Errorf("resp = %+v", resp)
+	}
+
+	var chunks []string
+	final, err := im.Stream(ctx, hello(), func(c protocol.InferResponseChunk) error {
+		chunks = append(chunks, c.Delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final.Content != resp.Content || strings.Join(chunks, "") != resp.Content {
+		t.Errorf("stream = %q, final %q; want %q", chunks, final.Content, resp.Content)
+	}
+
+	providers, err := im.Providers(ctx)
+	if err != nil || len(providers) != 1 || providers[0].Name != "echo" {
+		t.Errorf("Providers = %+v, %v", providers, err)
+	}
+	models, err := im.Models(ctx)
+	if err != nil || len(models.Models) != 1 {
+		t.Errorf("Models = %+v, %v", models, err)
+	}
+
+	_, err = im.Infer(ctx, protocol.InferRequest{Model: "no-such-model"})
+	if err == nil || misterrors.IsRetryable(err) && misterrors.Code(err) != misterrors.CodeTransport {
+		t.Errorf("unknown model: err = %v (%s)", err, misterrors.Code(err))
+	}
+}
+
+func TestTokenTrace(t *testing.T) {
+	h := tokentrace.NewHandler(tokentrace.DefaultConfig())
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /mist", h.Ingest)
+	mux.HandleFunc("GET /traces", h.Traces)
+	mux.HandleFunc("GET /traces/{id}", h.TraceByID)
+	mux.HandleFunc("GET /spans", h.Spans)
+	mux.HandleFunc("/alerts/silence", h.SilenceAlerts)
+	mux.HandleFunc("GET /stats", h.StatsHandler)
+	mux.HandleFunc("GET /alerts", h.Alerts)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	rep := tokentrace.NewReporter("test", srv.URL)
+	now := time.Now().UnixNano()
+	rep.ReportProto(context.Background(), protocol.TraceSpan{
+		TraceID: "t1", SpanID: "s1", Operation: "infer", StartNS: now, EndNS: now + 1e6,
+		Status: "ok", Attrs: map[string]any{"model": "m1"},
+	})
+	if err := rep.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := NewTokenTrace(srv.URL, fastRetry)
+	ctx := context.Background()
+	if ids, err := tt.Traces(ctx); err != nil || len(ids) != 1 || ids[0] != "t1" {
+		t.Fatalf("Traces = %v, %v", ids, err)
+	}
+	if spans, err := tt.Trace(ctx, "t1"); err != nil || len(spans) != 1 {
+		t.Errorf("Trace = %v, %v", spans, err)
+	}
+	if _, err := tt.Trace(ctx, "missing"); misterrors.Code(err) != misterrors.CodeNotFound {
+		t.Errorf("missing trace: err = %v, want not_found", err)
+	}
+	spans, err := tt.Spans(ctx, tokentrace.SpanQuery{Attrs: map[string]string{"model": "m1"}, Limit: 5})
+	if err != nil || len(spans) != 1 {
+		t.Errorf("Spans = %v, %v", spans, err)
+	}
+	if stats, err := tt.Stats(ctx); err != nil || stats.TotalSpans != 1 {
+		t.Errorf("Stats = %+v, %v", stats, err)
+	}
+	sil, err := tt.Silence(ctx, tokentrace.SilenceRequest{Matcher: tokentrace.AlertMatcher{Level: "warning"}, Duration: "1h", Comment: "deploy"})
+	if err != nil || sil.ID == "" {
+		t.Fatalf("Silence = %+v, %v", sil, err)
+	}
+	if alerts, err := tt.Alerts(ctx); err != nil || len(alerts.Silences) != 1 {
+		t.Errorf("Alerts = %+v, %v", alerts, err)
+	}
+}
+
+func TestClientRetryAuthAndTracing(t *testing.T) {
+	var calls atomic.Int32
+	var auth, traceparent atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		traceparent.Store(r.Header.Get(trace.TraceparentHeader))
+		if calls.Add(1) < 3 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"total_spans":7}`))
+	}))
+	defer srv.Close()
+
+	tt := NewTokenTrace(srv.URL, WithToken("s3cret"), fastRetry)
+	ctx, span := trace.Start(context.Background(), "caller")
+	stats, err := tt.Stats(ctx)
+	span.End("ok")
+	if err != nil || stats.TotalSpans != 7 {
+		t.Fatalf("Stats = %+v, %v", stats, err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+	if auth.Load() != "Bearer s3cret" {
+		t.Errorf("Authorization = %q", auth.Load())
+	}
+	if tp, _ := traceparent.Load().(string); !strings.Contains(tp, span.TraceID) {
+		t.Errorf("traceparent = %q, want trace %s", tp, span.TraceID)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		misterrors.WriteHTTP(w, r, misterrors.New(misterrors.CodeAuth, "bad token"))
+	}))
+	defer srv.Close()
+
+	_, err := NewTokenTrace(srv.URL, fastRetry).Stats(context.Background())
+	if misterrors.Code(err) != misterrors.CodeAuth {
+		t.Errorf("err = %v, want auth", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("auth error retried: %d calls", calls.Load())
+	}
+
+	for status, code := range map[int]string{
+		http.StatusBadRequest:          misterrors.CodeValidation,
+		http.StatusBadGateway:          misterrors.CodeTransport,
+		http.StatusTooManyRequests:     misterrors.CodeRateLimit,
+		http.StatusInternalServerError: misterrors.CodeInternal,
+	} {
+		if got := codeForStatus(status); got != code {
+			t.Errorf("codeForStatus(%d) = %s, want %s", status, got, code)
+		}
+	}
+}
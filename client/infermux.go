@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/infermux"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/retry"
+)
+
+// InferMux is a client for the InferMux HTTP API. It is safe for
+// concurrent use.
+type InferMux struct {
+	base
+}
+
+// NewInferMux creates a client for the InferMux at baseURL, e.g.
+// "http://localhost:8081", or "http://localhost:8080/infermux" for an
+// all-in-one mist serve node.
+func NewInferMux(baseURL string, opts ...Option) *InferMux {
+	return &InferMux{newBase(baseURL, "infermux", opts)}
+}
+
+// Infer runs an inference request (POST /infer).
+func (c *InferMux) Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error) {
+	var resp protocol.InferResponse
+	err := c.call(ctx, "infer", http.MethodPost, "/infer", req, &resp)
+	return resp, err
+}
+
+// Estimate returns what req would cost without running it
+// (POST /infer?dry_run=true).
+func (c *InferMux) Estimate(ctx context.Context, req protocol.InferRequest) (infermux.CostEstimate, error) {
+	var est infermux.CostEstimate
+	err := c.call(ctx, "estimate", http.MethodPost, "/infer?dry_run=true", req, &est)
+	return est, err
+}
+
+// Stream runs req as a streamed inference, calling fn with each chunk as
+// it arrives, and returns the final response. A failure reported by the
+// server mid-stream is returned as an error. An error from fn stops the
+// stream and is returned.
+//
+// Only a request that fails before the first chunk is retried, so fn
+// never sees a chunk twice.
+func (c *InferMux) Stream(ctx context.Context, req protocol.InferRequest, fn func(protocol.InferResponseChunk) error) (protocol.InferResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return protocol.InferResponse{}, misterrors.Wrap(misterrors.CodeValidation, err, "infermux: marshal request")
+	}
+
+	ctx, span := c.startSpan(ctx, "stream", http.MethodPost, "/infer")
+	var resp *http.Response
+	err = retry.DoAuto(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+		resp, err = c.do(ctx, c.stream, http.MethodPost, "/infer", body, infermux.ContentTypeNDJSON)
+		return err
+	})
+	if err != nil {
+		endSpan(span, err)
+		return protocol.InferResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	final, err := readChunks(resp, fn)
+	endSpan(span, err)
+	return final, err
+}
+
+// readChunks reads a newline-delimited chunk stream until its final
+// chunk.
+func readChunks(resp *http.Response, fn func(protocol.InferResponseChunk) error) (protocol.InferResponse, error) {
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), protocol.MaxMessageSize)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var chunk protocol.InferResponseChunk
+		if err := json.Unmarshal(sc.Bytes(), &chunk); err != nil {
+			return protocol.InferResponse{}, misterrors.Wrap(misterrors.CodeProtocol, err, "infermux: decode chunk")
+		}
+		if chunk.Error != "" {
+			return protocol.InferResponse{}, misterrors.New(misterrors.CodeTransport, "infermux: stream failed: "+chunk.Error).Permanent()
+		}
+		if err := fn(chunk); err != nil {
+			return protocol.InferResponse{}, err
+		}
+		if chunk.Done {
+			if chunk.Response != nil {
+				return *chunk.Response, nil
+			}
+			return protocol.InferResponse{}, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return protocol.InferResponse{}, misterrors.Wrap(misterrors.CodeTransport, err, "infermux: stream interrupted").Permanent()
+	}
+	return protocol.InferResponse{}, misterrors.New(misterrors.CodeTransport, "infermux: stream ended before the final chunk").Permanent()
+}
+
+// Providers lists the registered providers and their models
+// (GET /providers).
+func (c *InferMux) Providers(ctx context.Context) ([]infermux.ProviderInfo, error) {
+	var resp infermux.ProvidersResponse
+	err := c.call(ctx, "providers", http.MethodGet, "/providers", nil, &resp)
+	return resp.Providers, err
+}
+
+// Models lists model aliases, with what they currently resolve to, and
+// concrete models (GET /models).
+func (c *InferMux) Models(ctx context.Context) (infermux.ModelsResponse, error) {
+	var resp infermux.ModelsResponse
+	err := c.call(ctx, "models", http.MethodGet, "/models", nil, &resp)
+	return resp, err
+}
+
+// Budgets returns the state of each cost budget (GET /budgets).
+func (c *InferMux) Budgets(ctx context.Context) ([]infermux.BudgetStatus, error) {
+	var resp []infermux.BudgetStatus
+	err := c.call(ctx, "budgets", http.MethodGet, "/budgets", nil, &resp)
+	return resp, err
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+// TokenTrace is a client for the TokenTrace HTTP API. It is safe for
+// concurrent use. To send spans to TokenTrace, use tokentrace.Reporter,
+// which batches them in the background.
+type TokenTrace struct {
+	base
+}
+
+// NewTokenTrace creates a client for the TokenTrace at baseURL, e.g.
+// "http://localhost:8700", or "http://localhost:8080/tokentrace" for an
+// all-in-one mist serve node.
+func NewTokenTrace(baseURL string, opts ...Option) *TokenTrace {
+	return &TokenTrace{newBase(baseURL, "tokentrace", opts)}
+}
+
+// Traces lists the IDs of stored traces (GET /traces).
+func (c *TokenTrace) Traces(ctx context.Context) ([]string, error) {
+	var resp tokentrace.TracesResponse
+	err := c.call(ctx, "traces", http.MethodGet, "/traces", nil, &resp)
+	return resp.TraceIDs, err
+}
+
+// Trace returns the spans of one trace (GET /traces/{id}). A trace
+// that isn't stored returns an error with code not_found.
+func (c *TokenTrace) Trace(ctx context.Context, traceID string) ([]protocol.TraceSpan, error) {
+	var resp tokentrace.TraceResponse
+	err := c.call(ctx, "trace", http.MethodGet, "/traces/"+url.PathEscape(traceID), nil, &resp)
+	return resp.Spans, err
+}
+
+// Spans returns stored spans matching q, newest first (GET /spans). A
+// zero q.Limit returns the server's default of 100.
+func (c *TokenTrace) Spans(ctx context.Context, q tokentrace.SpanQuery) ([]protocol.TraceSpan, error) {
+	params := url.Values{}
+	for k, v := range q.Attrs {
+		params.Set("attr."+k, v)
+	}
+	if q.Status != "" {
+		params.Set("status", q.Status)
+	}
+	if q.Operation != "" {
+		params.Set("operation", q.Operation)
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	path := "/spans"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	var resp tokentrace.SpansResponse
+	err := c.call(ctx, "spans", http.MethodGet, path, nil, &resp)
+	return resp.Spans, err
+}
+
+// Compare compares two traces span by span (GET /traces/compare).
+func (c *TokenTrace) Compare(ctx context.Context, traceA, traceB string) (tokentrace.TraceComparison, error) {
+	params := url.Values{"a": {traceA}, "b": {traceB}}
+	var resp tokentrace.TraceComparison
+	err := c.call(ctx, "compare", http.MethodGet, "/traces/compare?"+params.Encode(), nil, &resp)
+	return resp, err
+}
+
+// Stats returns aggregated metrics over every ingested span
+// (GET /stats).
+func (c *TokenTrace) Stats(ctx context.Context) (tokentrace.AggregatorStats, error) {
+	var resp tokentrace.AggregatorStats
+	err := c.call(ctx, "stats", http.MethodGet, "/stats", nil, &resp)
+	return resp, err
+}
+
+// Alerts returns active and resolved alerts and current silences
+// (GET /alerts).
+func (c *TokenTrace) Alerts(ctx context.Context) (tokentrace.AlertsResponse, error) {
+	var resp tokentrace.AlertsResponse
+	err := c.call(ctx, "alerts", http.MethodGet, "/alerts", nil, &resp)
+	return resp, err
+}
+
+// Silence mutes the alert rules matching req for its duration
+// (POST /alerts/silence).
+func (c *TokenTrace) Silence(ctx context.Context, req tokentrace.SilenceRequest) (tokentrace.Silence, error) {
+	var resp tokentrace.Silence
+	err := c.call(ctx, "silence", http.MethodPost, "/alerts/silence", req, &resp)
+	return resp, err
+}
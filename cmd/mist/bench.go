@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/output"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/trace"
+	"github.com/greynewell/mist-go/transport"
+)
+
+// benchBuckets are the send latency buckets in milliseconds. They start
+// well below metrics.DefaultBuckets, as local sends take microseconds.
+var benchBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// benchPayloads generate the synthetic payload of each message type mist
+// bench can send.
+var benchPayloads = map[string]func(i int64) any{
+	protocol.TypeTraceSpan: func(i int64) any {
+		end := time.Now().UnixNano()
+		return protocol.TraceSpan{
+			TraceID:   trace.NewID(),
+			SpanID:    trace.NewID(),
+			Operation: "bench.op",
+			StartNS:   end - int64(time.Millisecond),
+			EndNS:     end,
+			Status:    "ok",
+			Attrs:     map[string]any{"seq": i, "model": "bench-model", "tokens_in": 100, "tokens_out": 50},
+		}
+	},
+	protocol.TypeInferRequest: func(i int64) any {
+		return protocol.InferRequest{
+			Model:    "auto",
+			Messages: []protocol.ChatMessage{{Role: "user", Content: "bench request " + strconv.FormatInt(i, 10)}},
+		}
+	},
+	protocol.TypeHealthPing: func(int64) any {
+		return protocol.HealthPing{From: "mist-bench"}
+	},
+}
+
+// benchResult is the output of mist bench.
+type benchResult struct {
+	URL      string  `json:"url"`
+	Type     string  `json:"type"`
+	Rate     int     `json:"target_rate"`
+	Duration string  `json:"duration"`
+	Sent     int64   `json:"sent"`
+	Errors   int64   `json:"errors"`
+	Skipped  int64   `json:"skipped"` // sends missed because every worker was busy
+	Achieved float64 `json:"achieved_rate"`
+	P50MS    float64 `json:"p50_ms"`
+	P90MS    float64 `json:"p90_ms"`
+	P99MS    float64 `json:"p99_ms"`
+	MaxMS    float64 `json:"max_ms"`
+}
+
+func cmdBench(cmd *cli.Command, args []string) error {
+	args, err := parseInterspersed(cmd, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return cli.Usagef("usage: mist bench <url> [--type trace.span] [--rate 1000] [--duration 30s]")
+	}
+	url, typ := args[0], cmd.GetString("type")
+	payload, ok := benchPayloads[typ]
+	if !ok {
+		types := make([]string, 0, len(benchPayloads))
+		for t := range benchPayloads {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return cli.Usagef("unsupported --type %q (want one of %v)", typ, types)
+	}
+	rate, workers := cmd.GetInt("rate"), cmd.GetInt("concurrency")
+	if rate <= 0 || workers <= 0 {
+		return cli.Usagef("--rate and --concurrency must be > 0")
+	}
+	duration, err := time.ParseDuration(cmd.GetString("duration"))
+	if err != nil || duration <= 0 {
+		return cli.Usagef("invalid --duration %q", cmd.GetString("duration"))
+	}
+
+	t, err := transport.Dial(url)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer t.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, stop := context.WithTimeout(ctx, duration)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "sending %s to %s at %d/s for %v\n", typ, url, rate, duration)
+	reg := metrics.NewRegistry()
+	latency := reg.Histogram("bench_send_latency_ms", benchBuckets)
+	sent, errs := reg.Counter("bench_sent_total"), reg.Counter("bench_errors_total")
+	var (
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	// The schedule is open-loop: sends are due at fixed intervals
+	// whatever the latency, and a send that no worker is free for is
+	// skipped rather than delayed, so a slow target shows up as skips
+	// instead of a quietly lower rate.
+	jobs := make(chan int64, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				msg, err := protocol.New("mist-bench", typ, payload(i))
+				if err == nil {
+					sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+					start := time.Now()
+					err = t.Send(sendCtx, msg)
+					latency.Observe(float64(time.Since(start).Microseconds()) / 1000)
+					cancel()
+				}
+				if err != nil {
+					errs.Inc()
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				sent.Inc()
+			}
+		}()
+	}
+
+	interval := time.Second / time.Duration(rate)
+	start := time.Now()
+	var skipped int64
+	timer := time.NewTimer(0)
+schedule:
+	for i := int64(0); ; i++ {
+		select {
+		case <-ctx.Done():
+			break schedule
+		case <-timer.C:
+		}
+		select {
+		case jobs <- i:
+		default:
+			skipped++
+		}
+		timer.Reset(time.Until(start.Add(time.Duration(i+1) * interval)))
+	}
+	timer.Stop()
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	snap := latency.Snapshot()
+	res := benchResult{
+		URL: url, Type: typ, Rate: rate, Duration: elapsed.Round(time.Millisecond).String(),
+		Sent: sent.Value(), Errors: errs.Value(), Skipped: skipped,
+		Achieved: float64(sent.Value()) / elapsed.Seconds(),
+		P50MS:    snap.Percentile(50), P90MS: snap.Percentile(90), P99MS: snap.Percentile(99), MaxMS: snap.Max,
+	}
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		if err := out.JSON(res); err != nil {
+			return err
+		}
+	} else {
+		ms := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+		out.Table([]string{"SENT", "ERRORS", "SKIPPED", "RATE/S", "P50_MS", "P90_MS", "P99_MS", "MAX_MS"}, [][]string{{
+			strconv.FormatInt(res.Sent, 10), strconv.FormatInt(res.Errors, 10), strconv.FormatInt(res.Skipped, 10),
+			strconv.FormatFloat(res.Achieved, 'f', 1, 64), ms(res.P50MS), ms(res.P90MS), ms(res.P99MS), ms(res.MaxMS),
+		}})
+	}
+
+	if firstErr != nil {
+		fmt.Fprintf(os.Stderr, "first send error: %v\n", firstErr)
+	}
+	if res.Sent == 0 && res.Errors > 0 {
+		return fmt.Errorf("bench: every send failed")
+	}
+	return nil
+}
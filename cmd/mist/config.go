@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/config"
+	"github.com/greynewell/mist-go/infermux"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/output"
+	"github.com/greynewell/mist-go/resource"
+	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/transport"
+)
+
+// configSchema describes the config file of one MIST component for
+// mist config check.
+type configSchema struct {
+	// newConfig returns a pointer to the component's config struct,
+	// holding its defaults.
+	newConfig func() any
+
+	// validate checks the decoded config, or the raw data for components
+	// whose loaders read it directly. It runs only if the structure
+	// checks pass.
+	validate func(v any, data map[string]any) error
+}
+
+// configSchemas are the components whose config files mist config check
+// understands. A "node" file holds any of them as [serve], [relay],
+// [tokentrace], and [infermux] tables.
+var configSchemas = map[string]configSchema{
+	"serve": {
+		newConfig: func() any {
+			return &serveConfig{LogLevel: "info", LogFormat: "json", DebugPrefix: "/debug", MaxSeries: defaultMaxSeries}
+		},
+		validate: func(v any, _ map[string]any) error {
+			if err := config.Validate(v); err != nil {
+				return err
+			}
+			sc := v.(*serveConfig)
+			if err := sc.Backpressure.Validate(); err != nil {
+				return fmt.Errorf("serve: backpressure: %w", err)
+			}
+			if sc.MetricsPush.URL != "" {
+				if err := sc.MetricsPush.Validate(); err != nil {
+					return fmt.Errorf("serve: metrics_push: %w", err)
+				}
+			}
+			return nil
+		},
+	},
+	"relay": {
+		newConfig: func() any { return &relayConfig{DedupEntries: 100000, BatchSize: transport.DefaultBatchSize} },
+		validate:  func(v any, _ map[string]any) error { return v.(*relayConfig).Validate() },
+	},
+	"tokentrace": {
+		newConfig: func() any { cfg := tokentrace.DefaultConfig(); return &cfg },
+		validate:  func(v any, _ map[string]any) error { return v.(*tokentrace.Config).Validate() },
+	},
+	"infermux": {
+		newConfig: func() any { return &infermuxConfig{} },
+		validate: func(v any, data map[string]any) error {
+			cfg := v.(*infermuxConfig)
+			for name, p := range cfg.Providers {
+				if p.Kind != "" && p.Kind != "echo" {
+					return fmt.Errorf("infermux: providers.%s: unknown kind %q (only echo is built in)", name, p.Kind)
+				}
+			}
+			for name, b := range cfg.Budgets {
+				if b.Period == "" {
+					b.Period = infermux.PeriodDaily
+				}
+				if err := config.Validate(&b); err != nil {
+					return fmt.Errorf("infermux: budgets.%s: %w", name, err)
+				}
+			}
+			if len(cfg.Aliases) > 0 && cfg.AliasesFile != "" {
+				return fmt.Errorf("infermux: set aliases or aliases_file, not both")
+			}
+			aliases, err := infermux.AliasesFromConfig(config.New(data))
+			if err != nil {
+				return err
+			}
+			_, err = infermux.NewAliases(aliases...)
+			return err
+		},
+	},
+}
+
+// serveConfig is the [serve] table: the HTTP server and telemetry every
+// MIST tool runs.
+type serveConfig struct {
+	Addr         string        `toml:"addr" validate:"required"`
+	Tool         string        `toml:"tool"`
+	LogLevel     string        `toml:"log_level" validate:"oneof=debug info warn error"`
+	LogFormat    string        `toml:"log_format" validate:"oneof=json text"`
+	LogSpans     bool          `toml:"log_spans"`
+	SpanLogAttrs []string      `toml:"span_log_attrs"`
+	TraceURL     string        `toml:"trace_url"`
+	CPUInterval  time.Duration `toml:"cpu_interval"`
+	MaxProcs     bool          `toml:"max_procs"`
+	MaxSeries    int           `toml:"max_series"` // per metric name; 0 for no limit
+	Debug        bool          `toml:"debug"`      // mount server.EnableDebug endpoints
+	DebugPrefix  string        `toml:"debug_prefix"`
+	DebugToken   string        `toml:"debug_token"`
+	CORS         corsConfig    `toml:"cors"`
+
+	// DrainToken lets other hosts drain the node's TokenTrace and
+	// InferMux ingest by sending it as a bearer token with control.drain
+	// (see mist admin drain --token). Without it only the node's own
+	// host can.
+	DrainToken string `toml:"drain_token"`
+
+	// Backpressure refuses TokenTrace and InferMux ingest with 503 and a
+	// Retry-After once either component has max_in_flight requests in
+	// flight, the heap passes memory_limit, or a request's estimated
+	// memory doesn't fit in what is left of the component's
+	// memory_budget. Off by default.
+	Backpressure resource.BackpressureConfig `toml:"backpressure"`
+
+	// MetricsPush pushes the node's metrics to a StatsD agent or an
+	// OpenMetrics endpoint at url, flushing once more on shutdown. Off
+	// without a url.
+	MetricsPush metrics.PushConfig `toml:"metrics_push"`
+}
+
+type corsConfig struct {
+	AllowedOrigins   []string      `toml:"allowed_origins"`
+	AllowedMethods   []string      `toml:"allowed_methods"`
+	AllowedHeaders   []string      `toml:"allowed_headers"`
+	ExposedHeaders   []string      `toml:"exposed_headers"`
+	AllowCredentials bool          `toml:"allow_credentials"`
+	MaxAge           time.Duration `toml:"max_age"`
+}
+
+// relayConfig is the [relay] table, mirroring the mist relay flags.
+type relayConfig struct {
+	Src          string        `toml:"src" validate:"required"`
+	Dst          string        `toml:"dst" validate:"required"`
+	DedupWindow  time.Duration `toml:"dedup_window"`
+	DedupEntries int           `toml:"dedup_entries"`
+	DedupFile    string        `toml:"dedup_file"`
+	BatchSize    int           `toml:"batch_size"`
+}
+
+// Validate checks the relay settings as mist relay does.
+func (c *relayConfig) Validate() error {
+	switch {
+	case c.DedupWindow < 0:
+		return fmt.Errorf("relay: dedup_window must be >= 0")
+	case c.DedupFile != "" && c.DedupWindow == 0:
+		return fmt.Errorf("relay: dedup_file requires dedup_window")
+	case c.DedupEntries <= 0:
+		return fmt.Errorf("relay: dedup_entries must be > 0 (got %d)", c.DedupEntries)
+	case c.BatchSize <= 0:
+		return fmt.Errorf("relay: batch_size must be > 0 (got %d)", c.BatchSize)
+	}
+	return nil
+}
+
+// infermuxConfig is the [infermux] table: providers, model aliases (see
+// infermux.AliasesFromConfig), pricing, cost budgets, and the request
+// policy. Aliases are fixed for the life of the node when written
+// inline; in aliases_file, an [aliases.*] file, they reload when it
+// changes.
+type infermuxConfig struct {
+	Providers   map[string]providerConfig `toml:"providers"`
+	Aliases     map[string]aliasConfig    `toml:"aliases"`
+	AliasesFile string                    `toml:"aliases_file"`
+	PricingFile string                    `toml:"pricing_file"`
+	Budgets     map[string]budgetConfig   `toml:"budgets"`
+	Policy      policyConfig              `toml:"policy"`
+	Breaker     breakerConfig             `toml:"breaker"`
+	Webhooks    map[string]webhookConfig  `toml:"webhooks"`
+}
+
+// providerConfig is an [infermux.providers.NAME] table. The only
+// built-in kind is echo (infermux.EchoProvider), for local development;
+// real providers are registered by a tool's own main.
+type providerConfig struct {
+	Kind   string        `toml:"kind"`
+	Models []string      `toml:"models" validate:"required"`
+	Delay  time.Duration `toml:"delay"`
+}
+
+type aliasConfig struct {
+	Target    string   `toml:"target" validate:"required"`
+	Fallbacks []string `toml:"fallbacks"`
+}
+
+// budgetConfig is an [infermux.budgets.NAME] table; see infermux.Budget.
+// Spend is the providers' reported cost, or else their tokens priced
+// from pricing_file.
+type budgetConfig struct {
+	Provider string  `toml:"provider"`
+	Tenant   string  `toml:"tenant"`
+	Period   string  `toml:"period" validate:"oneof=daily monthly"` // default daily
+	LimitUSD float64 `toml:"limit_usd" validate:"required,min=0"`
+	WarnAt   float64 `toml:"warn_at"`
+	Block    bool    `toml:"block"`
+}
+
+// breakerConfig is the [infermux.breaker] table, the circuit breaker
+// each provider sits behind; see circuitbreaker.Config for defaults.
+type breakerConfig struct {
+	Threshold int           `toml:"threshold" validate:"min=0"`
+	Timeout   time.Duration `toml:"timeout"`
+}
+
+// webhookConfig is an [infermux.webhooks.NAME] table; see
+// infermux.Webhook.
+type webhookConfig struct {
+	URL    string   `toml:"url" validate:"required"`
+	Secret string   `toml:"secret"`
+	Events []string `toml:"events"`
+}
+
+type policyConfig struct {
+	AllowedModels  map[string][]string `toml:"allowed_models"`
+	MaxTemperature float64             `toml:"max_temperature"`
+	MaxTokens      int                 `toml:"max_tokens"`
+	BannedParams   []string            `toml:"banned_params"`
+}
+
+// checkConfig checks data against the named schema, prefixing problem
+// keys with prefix.
+func checkConfig(schema string, data map[string]any, prefix string) []config.Problem {
+	if schema == "node" {
+		var problems []config.Problem
+		for _, section := range sortedKeys(data) {
+			if _, ok := configSchemas[section]; !ok {
+				problems = append(problems, config.Problem{Key: section, Message: "unknown section"})
+				continue
+			}
+			table, ok := data[section].(map[string]any)
+			if !ok {
+				problems = append(problems, config.Problem{Key: section, Message: "expected table"})
+				continue
+			}
+			problems = append(problems, checkConfig(section, table, section+".")...)
+		}
+		return problems
+	}
+
+	s := configSchemas[schema]
+	v := s.newConfig()
+	problems := config.Check(data, v)
+	if len(problems) == 0 {
+		err := config.Decode(data, v)
+		if err == nil {
+			err = s.validate(v, data)
+		}
+		if err != nil {
+			problems = append(problems, config.Problem{Message: err.Error()})
+		}
+	}
+	for i := range problems {
+		if problems[i].Key == "" {
+			problems[i].Key = strings.TrimSuffix(prefix, ".")
+		} else {
+			problems[i].Key = prefix + problems[i].Key
+		}
+	}
+	return problems
+}
+
+// parseInterspersed parses cmd's flags from args, allowing them to
+// follow positional arguments as in "mist config check f.toml --schema
+// relay", and returns the positional arguments.
+func parseInterspersed(cmd *cli.Command, args []string) ([]string, error) {
+	var positional []string
+	for len(args) > 0 {
+		if err := cmd.Flags.Parse(args); err != nil {
+			return nil, cli.Usagef("%v", err)
+		}
+		if args = cmd.Flags.Args(); len(args) > 0 {
+			positional, args = append(positional, args[0]), args[1:]
+		}
+	}
+	return positional, nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configCheckResult is the JSON output of mist config check.
+type configCheckResult struct {
+	File     string           `json:"file"`
+	Schema   string           `json:"schema"`
+	Problems []config.Problem `json:"problems"`
+}
+
+func cmdConfig(cmd *cli.Command, args []string) error {
+	usage := cli.Usagef("usage: mist config check <file.toml> --schema node|serve|relay|tokentrace|infermux")
+	if len(args) < 1 || args[0] != "check" {
+		return usage
+	}
+
+	files, err := parseInterspersed(cmd, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return usage
+	}
+
+	schema := cmd.GetString("schema")
+	if _, ok := configSchemas[schema]; !ok && schema != "node" {
+		return cli.Usagef("unknown schema %q (want node, serve, relay, tokentrace, or infermux)", schema)
+	}
+
+	data, err := config.ParseFile(files[0])
+	if err != nil {
+		return fmt.Errorf("config check: %w", err)
+	}
+	problems := checkConfig(schema, data, "")
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		if problems == nil {
+			problems = []config.Problem{}
+		}
+		if err := out.JSON(configCheckResult{File: files[0], Schema: schema, Problems: problems}); err != nil {
+			return err
+		}
+	} else if len(problems) > 0 {
+		rows := make([][]string, 0, len(problems))
+		for _, p := range problems {
+			rows = append(rows, []string{p.Key, p.Message})
+		}
+		out.Table([]string{"KEY", "PROBLEM"}, rows)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ok (%s)\n", files[0], schema)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problems", files[0], len(problems))
+	}
+	return nil
+}
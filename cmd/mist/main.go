@@ -6,19 +6,58 @@
 //	mist version          Print version
 //	mist ping <url>       Send health.ping to a MIST service
 //	mist validate         Read JSON messages from stdin, validate envelope
-//	mist relay <src> <dst> Relay messages between two transport URLs
+//	mist relay <src> <dst> Relay messages between two transport URLs (--dead-letter, --drop-types, --tee)
+//	mist trace diff <a> <b> Compare two traces stored in TokenTrace
+//	mist checkpoint compact <run-id> Compact a checkpoint log
+//	mist job pause <run-id> Pause, resume, cancel, or inspect a running job
+//	mist infer <prompt>   Run inference via InferMux (--dry-run for a cost preview)
+//	mist pricing check    Flag models in recent spans missing from the pricing table
+//	mist reconcile        Compare span token/cost totals with provider-billed usage
+//	mist debug profile <url> Fetch a pprof profile from a running node
+//	mist errors list      Print the error code catalog
+//	mist admin drain <url> Drain a relay or collector before a deploy
+//	mist admin --socket <path> status Inspect, pause, resume, or stop a running relay or node
+//	mist snapshot <url>   Save a node's /snapshotz state for an incident ticket
+//	mist import <file>    Load Jaeger, OTLP/JSON, or CSV trace dumps into TokenTrace
+//	mist export spans     Export TokenTrace spans as CSV or Parquet for analytics
+//	mist config check <file> Check a config file against a component's schema
+//	mist serve            Run an all-in-one node (TokenTrace, InferMux) from a config file
+//	mist smoke            Drive infer → span → stats → alert through a throwaway node
+//	mist proxy <listen> <upstream> Inject latency and errors between two services
+//	mist bench <url>      Send synthetic messages at a fixed rate and report latency
+//	mist secrets rekey <file>... Encrypt or re-encrypt files under a new key
+//	mist schema diff <old> [new] Flag breaking payload schema changes between releases
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/greynewell/mist-go/checkpoint"
 	"github.com/greynewell/mist-go/cli"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/infermux"
+	"github.com/greynewell/mist-go/output"
+	"github.com/greynewell/mist-go/pricing"
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/relay"
+	"github.com/greynewell/mist-go/retry"
+	"github.com/greynewell/mist-go/secrets"
+	"github.com/greynewell/mist-go/server"
+	"github.com/greynewell/mist-go/tokentrace"
 	"github.com/greynewell/mist-go/transport"
 )
 
@@ -39,20 +78,229 @@ func main() {
 		Run:   cmdValidate,
 	})
 
-	app.AddCommand(&cli.Command{
+	relayCmd := &cli.Command{
 		Name:  "relay",
 		Usage: "Relay messages between two transport URLs (src dst)",
 		Run:   cmdRelay,
-	})
+	}
+	relayCmd.AddStringFlag("dedup-window", "", "Drop messages whose ID was seen within this duration (e.g. 10m)")
+	relayCmd.AddIntFlag("dedup-entries", 100000, "Maximum message IDs held in memory for dedup")
+	relayCmd.AddStringFlag("dedup-file", "", "Persist a dedup bitmap here to survive restarts")
+	relayCmd.AddIntFlag("batch-size", transport.DefaultBatchSize, "Messages per send when the destination supports batches (1 disables)")
+	relayCmd.AddBoolFlag("resume", false, "Keep a file source's progress in <path>.ack and start after the messages already relayed")
+	relayCmd.AddStringFlag("dead-letter", "", "Write messages the destination refuses to this file for replay, instead of stopping")
+	relayCmd.AddStringFlag("dst-version", protocol.CurrentVersion, "Envelope version to forward messages as (1 for destinations that predate v2; messages with attachments need 3)")
+	relayCmd.AddStringFlag("compress", "", "Compress what is sent to an HTTP or file destination: gzip or another registered encoding")
+	relayCmd.AddStringFlag("send-timeout", "", "Fail a send the destination hasn't accepted within this duration (e.g. 30s)")
+	relayCmd.AddStringFlag("drop-types", "", "Comma-separated message types not to forward (e.g. health.ping,health.pong)")
+	relayCmd.AddStringFlag("source", "", "Rewrite the source of forwarded messages")
+	relayCmd.AddStringFlag("tee", "", "Comma-separated transport URLs that also receive every forwarded message")
+	relayCmd.AddStringFlag("admin-socket", "", "Serve status, pause, resume, and stop on this unix socket (see mist admin)")
+	relayCmd.AddStringFlag("drain-token", "", "Bearer token that lets other hosts drain an http source (default $MIST_DRAIN_TOKEN; without one only this host can)")
+	app.AddCommand(relayCmd)
+
+	traceCmd := &cli.Command{
+		Name:  "trace",
+		Usage: "Inspect traces in TokenTrace (diff <a> <b>)",
+		Run:   cmdTrace,
+	}
+	traceCmd.AddStringFlag("url", "http://localhost:8700", "TokenTrace base URL")
+	traceCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(traceCmd)
+
+	checkpointCmd := &cli.Command{
+		Name:  "checkpoint",
+		Usage: "Manage checkpoint logs (compact <run-id>)",
+		Run:   cmdCheckpoint,
+	}
+	checkpointCmd.AddStringFlag("dir", ".", "Checkpoint directory")
+	checkpointCmd.AddStringFlag("key", "", "Key for an encrypted log (env:NAME or file:PATH)")
+	app.AddCommand(checkpointCmd)
+
+	jobCmd := &cli.Command{
+		Name:  "job",
+		Usage: "Control a running job (pause|resume|cancel|status <run-id>)",
+		Run:   cmdJob,
+	}
+	jobCmd.AddStringFlag("url", "http://localhost:8080", "Job control base URL")
+	jobCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(jobCmd)
+
+	inferCmd := &cli.Command{
+		Name:  "infer",
+		Usage: "Send a prompt to InferMux, or preview its cost with --dry-run",
+		Run:   cmdInfer,
+	}
+	inferCmd.AddStringFlag("url", "http://localhost:8081", "InferMux base URL")
+	inferCmd.AddStringFlag("model", "auto", "Model name, or auto for routing")
+	inferCmd.AddIntFlag("max-tokens", 0, "Maximum output tokens (0 for provider default)")
+	inferCmd.AddBoolFlag("dry-run", false, "Estimate tokens and cost without calling the provider")
+	inferCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(inferCmd)
+
+	pricingCmd := &cli.Command{
+		Name:  "pricing",
+		Usage: "Check a pricing table against models seen in TokenTrace (check)",
+		Run:   cmdPricing,
+	}
+	pricingCmd.AddStringFlag("file", "pricing.toml", "Pricing TOML file")
+	pricingCmd.AddStringFlag("url", "http://localhost:8700", "TokenTrace base URL")
+	pricingCmd.AddIntFlag("limit", 1000, "Number of recent spans to inspect")
+	pricingCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(pricingCmd)
+
+	reconcileCmd := &cli.Command{
+		Name:  "reconcile",
+		Usage: "Compare TokenTrace token and cost totals with provider-billed usage",
+		Run:   cmdReconcile,
+	}
+	reconcileCmd.AddStringFlag("url", "http://localhost:8700", "TokenTrace base URL")
+	reconcileCmd.AddStringFlag("usage", "", "Comma-separated provider=source pairs; a source is a billing export (.csv, .json) or an http(s) URL")
+	reconcileCmd.AddStringFlag("from", "", "Start of the period, YYYY-MM-DD or RFC 3339 (default 30 days before --to)")
+	reconcileCmd.AddStringFlag("to", "", "End of the period, exclusive (default now)")
+	reconcileCmd.AddFloat64Flag("threshold", 0.02, "Flag differences above this fraction (0.02 = 2%)")
+	reconcileCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(reconcileCmd)
+
+	debugCmd := &cli.Command{
+		Name:  "debug",
+		Usage: "Fetch a runtime profile from a running node (profile <url>)",
+		Run:   cmdDebug,
+	}
+	debugCmd.AddStringFlag("type", "cpu", "Profile: cpu, trace, heap, allocs, goroutine, block, or mutex")
+	debugCmd.AddIntFlag("seconds", 30, "Duration of cpu and trace profiles")
+	debugCmd.AddStringFlag("out", "", "Output file (default <type>.pprof)")
+	debugCmd.AddStringFlag("prefix", "/debug", "Debug endpoint prefix on the node")
+	debugCmd.AddStringFlag("token", "", "Bearer token for debug endpoints (default $MIST_DEBUG_TOKEN)")
+	app.AddCommand(debugCmd)
+
+	errorsCmd := &cli.Command{
+		Name:  "errors",
+		Usage: "Describe MIST error codes (list)",
+		Run:   cmdErrors,
+	}
+	errorsCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(errorsCmd)
+
+	adminCmd := &cli.Command{
+		Name:  "admin",
+		Usage: "Operate a running relay or collector (drain <url>, or status|pause|resume|stop --socket <path>)",
+		Run:   cmdAdmin,
+	}
+	adminCmd.AddStringFlag("socket", "", "Admin socket of a local relay or node, from its --admin-socket")
+	adminCmd.AddStringFlag("reason", "", "Reason recorded in the control.drain message")
+	adminCmd.AddStringFlag("timeout", "5m", "How long to wait for the node to drain")
+	adminCmd.AddBoolFlag("no-wait", false, "Start the drain without waiting for it to finish")
+	adminCmd.AddStringFlag("token", "", "Bearer token for draining a node on another host (default $MIST_DRAIN_TOKEN)")
+	adminCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(adminCmd)
+
+	snapshotCmd := &cli.Command{
+		Name:  "snapshot",
+		Usage: "Save a node's state from /snapshotz (snapshot <url>)",
+		Run:   cmdSnapshot,
+	}
+	snapshotCmd.AddStringFlag("out", "", "Output file (default stdout)")
+	snapshotCmd.AddStringFlag("token", "", "Bearer token for /snapshotz (default $MIST_DEBUG_TOKEN)")
+	app.AddCommand(snapshotCmd)
+
+	importCmd := &cli.Command{
+		Name:  "import",
+		Usage: "Load an external trace dump into TokenTrace (import <file>)",
+		Run:   cmdImport,
+	}
+	importCmd.AddStringFlag("format", "", "Dump format: jaeger, otlp-json, or csv")
+	importCmd.AddStringFlag("url", "http://localhost:8700", "TokenTrace base URL")
+	importCmd.AddIntFlag("batch", 100, "Spans sent per batch")
+	importCmd.AddStringFlag("timeout", "10m", "How long to wait for all spans to be sent")
+	app.AddCommand(importCmd)
 
-	if err := app.Execute(os.Args[1:]); err != nil {
-		os.Exit(1)
+	exportCmd := &cli.Command{
+		Name:  "export",
+		Usage: "Export TokenTrace spans as CSV or Parquet (spans)",
+		Run:   cmdExport,
 	}
+	exportCmd.AddStringFlag("url", "http://localhost:8700", "TokenTrace base URL")
+	exportCmd.AddStringFlag("since", "24h", "Export spans started within this duration")
+	exportCmd.AddStringFlag("format", "csv", "Output format: csv or parquet")
+	exportCmd.AddStringFlag("columns", "", "Comma-separated columns, e.g. trace_id,duration_ms,attrs.model (default all)")
+	exportCmd.AddStringFlag("out", "", "Output file (default stdout)")
+	app.AddCommand(exportCmd)
+
+	configCmd := &cli.Command{
+		Name:  "config",
+		Usage: "Check a config file before deploy (check <file> --schema node)",
+		Run:   cmdConfig,
+	}
+	configCmd.AddStringFlag("schema", "node", "Schema: node, serve, relay, tokentrace, or infermux")
+	configCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(configCmd)
+
+	serveCmd := &cli.Command{
+		Name:  "serve",
+		Usage: "Run an all-in-one MIST node from a config file",
+		Run:   cmdServe,
+	}
+	serveCmd.AddStringFlag("config", "mist.toml", "Node config file ([serve], [tokentrace], [infermux])")
+	serveCmd.AddStringFlag("admin-socket", "", "Serve status, pause, resume, and stop on this unix socket (see mist admin)")
+	infermux.AddCacheFlags(serveCmd)
+	app.AddCommand(serveCmd)
+
+	smokeCmd := &cli.Command{
+		Name:  "smoke",
+		Usage: "Run an end-to-end smoke test against an in-process node",
+		Run:   cmdSmoke,
+	}
+	smokeCmd.AddStringFlag("config", "", "Node config file whose [tokentrace] and [infermux] tables to test (default: echo provider, empty store)")
+	smokeCmd.AddStringFlag("timeout", "30s", "Give up on the stages still running after this long")
+	smokeCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(smokeCmd)
+
+	proxyCmd := &cli.Command{
+		Name:  "proxy",
+		Usage: "Inject latency and errors between two services (listen upstream)",
+		Run:   cmdProxy,
+	}
+	proxyCmd.AddStringFlag("latency", "0s", "Delay added to every request or message")
+	proxyCmd.AddStringFlag("jitter", "0s", "Random extra delay, up to this duration")
+	proxyCmd.AddFloat64Flag("error-rate", 0, "Fraction of requests failed (HTTP 503) or messages dropped, 0 to 1")
+	app.AddCommand(proxyCmd)
+
+	benchCmd := &cli.Command{
+		Name:  "bench",
+		Usage: "Measure send throughput and latency to a transport URL (bench <url>)",
+		Run:   cmdBench,
+	}
+	benchCmd.AddStringFlag("type", protocol.TypeTraceSpan, "Message type: trace.span, infer.request, or health.ping")
+	benchCmd.AddIntFlag("rate", 1000, "Messages per second")
+	benchCmd.AddStringFlag("duration", "30s", "How long to send for")
+	benchCmd.AddIntFlag("concurrency", 16, "Concurrent senders")
+	benchCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(benchCmd)
+
+	secretsCmd := &cli.Command{
+		Name:  "secrets",
+		Usage: "Generate keys and rotate encrypted files (keygen | rekey <file>...)",
+		Run:   cmdSecrets,
+	}
+	secretsCmd.AddStringFlag("key", "", "New key (env:NAME or file:PATH)")
+	secretsCmd.AddStringFlag("old-key", "", "Key the files are sealed with now, if rotating")
+	app.AddCommand(secretsCmd)
+
+	schemaCmd := &cli.Command{
+		Name:  "schema",
+		Usage: "Dump payload schemas or flag breaking changes between releases (dump | diff <old> [new])",
+		Run:   cmdSchema,
+	}
+	schemaCmd.AddStringFlag("format", "table", "Output format: table or json")
+	app.AddCommand(schemaCmd)
+
+	app.ExecuteAndExit(os.Args[1:])
 }
 
 func cmdPing(_ *cli.Command, args []string) error {
 	if len(args) < 1 {
-		return fmt.Errorf("usage: mist ping <url>")
+		return cli.Usagef("usage: mist ping <url>")
 	}
 
 	t, err := transport.Dial(args[0])
@@ -107,44 +355,1043 @@ func cmdValidate(_ *cli.Command, _ []string) error {
 	return nil
 }
 
-func cmdRelay(_ *cli.Command, args []string) error {
+// deadLetterRetry is how a relay with --dead-letter retries a failed
+// send before diverting it, so a brief outage of the destination doesn't
+// fill the dead-letter file.
+var deadLetterRetry = transport.RetryPolicy{
+	MaxAttempts: 5,
+	InitialWait: 200 * time.Millisecond,
+	MaxWait:     5 * time.Second,
+	Multiplier:  2,
+}
+
+func cmdRelay(cmd *cli.Command, args []string) error {
 	if len(args) < 2 {
-		return fmt.Errorf("usage: mist relay <src-url> <dst-url>")
+		return cli.Usagef("usage: mist relay <src-url> <dst-url>")
+	}
+
+	// Expired messages are dropped on both sides, and forwarded ones
+	// carry their remaining TTL.
+	opts := []transport.MiddlewareOption{transport.WithExpiry(transport.ExpiryPolicy{})}
+	if w := cmd.GetString("dedup-window"); w != "" {
+		window, err := time.ParseDuration(w)
+		if err != nil || window <= 0 {
+			return cli.Usagef("invalid --dedup-window %q", w)
+		}
+		var dopts []transport.DedupOption
+		if path := cmd.GetString("dedup-file"); path != "" {
+			dopts = append(dopts, transport.WithDedupFile(path, 1<<23))
+		}
+		opts = append(opts, transport.WithDedup(window, cmd.GetInt("dedup-entries"), dopts...))
+	} else if cmd.GetString("dedup-file") != "" {
+		return cli.Usagef("--dedup-file requires --dedup-window")
 	}
 
-	src, err := transport.Dial(args[0])
+	in, err := transport.Dial(args[0])
 	if err != nil {
 		return fmt.Errorf("dial src: %w", err)
 	}
+	// Without --resume a file is relayed from the top each time, so a
+	// dead-letter file can be replayed more than once.
+	if cmd.GetBool("resume") {
+		rs, ok := in.(interface{ SetResume(bool) })
+		if !ok {
+			in.Close()
+			return cli.Usagef("--resume needs a file source, not %s", args[0])
+		}
+		rs.SetResume(true)
+	}
+	// An http source listens for messages POSTed to /mist on its host
+	// and port, and ends the relay once drained with mist admin drain.
+	var drained <-chan struct{}
+	listenErr := make(chan error, 1)
+	if h, ok := in.(*transport.HTTP); ok {
+		u, err := url.Parse(args[0])
+		if err != nil || u.Host == "" {
+			return cli.Usagef("invalid http source %q", args[0])
+		}
+		gate := h.EnableDrain()
+		gate.SetToken(envDefault(cmd.GetString("drain-token"), "MIST_DRAIN_TOKEN"))
+		drained = gate.Done()
+		go func() {
+			if err := h.ListenForMessages(u.Host); !errors.Is(err, http.ErrServerClosed) {
+				listenErr <- fmt.Errorf("listen: %w", err)
+			}
+		}()
+	}
+	src := transport.Wrap(in, opts...)
 	defer src.Close()
 
-	dst, err := transport.Dial(args[1])
+	// Messages are converted to the destination's envelope version, so a
+	// relay from a newer service to an older one doesn't hand it fields
+	// it would drop.
+	version := cmd.GetString("dst-version")
+	if !protocol.IsCompatible(version) {
+		return cli.Usagef("invalid --dst-version %q (supported: %s)", version, protocol.SupportedVersions)
+	}
+
+	out, err := transport.Dial(args[1])
 	if err != nil {
 		return fmt.Errorf("dial dst: %w", err)
 	}
+	if name := cmd.GetString("compress"); name != "" {
+		c, ok := transport.LookupCompressor(name)
+		dc, settable := out.(interface{ SetCompression(transport.Compressor) })
+		if !ok || !settable {
+			out.Close()
+			return cli.Usagef("invalid --compress %q for %s", name, args[1])
+		}
+		dc.SetCompression(c)
+	}
+	// A stalled destination is reported rather than hanging the relay
+	// silently, and with --send-timeout its sends fail.
+	dstOpts := []transport.MiddlewareOption{
+		transport.WithExpiry(transport.ExpiryPolicy{OnSend: true, Annotate: true}),
+		transport.WithPeerVersion(version),
+		transport.WithSlowConsumer(transport.SlowConsumerPolicy{
+			OnStall: func(d time.Duration) {
+				fmt.Fprintf(os.Stderr, "destination %s slow for %s\n", args[1], d.Round(time.Second))
+			},
+			OnRecover: func(d time.Duration) {
+				fmt.Fprintf(os.Stderr, "destination %s recovered after %s\n", args[1], d.Round(time.Second))
+			},
+		}),
+	}
+	if v := cmd.GetString("send-timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cli.Usagef("invalid --send-timeout %q", v)
+		}
+		dstOpts = append(dstOpts, transport.WithSendTimeout(d))
+	}
+	if cmd.GetString("dead-letter") != "" {
+		dstOpts = append(dstOpts, transport.WithRetry(deadLetterRetry))
+	}
+	var dst transport.Transport = transport.Wrap(out, dstOpts...)
+
+	// Messages the destination still refuses after deadLetterRetry go to
+	// the dead-letter file, to be replayed later with
+	// mist relay file://<path> <dst>.
+	var dead *transport.DeadLetter
+	if path := cmd.GetString("dead-letter"); path != "" {
+		dlq, err := transport.NewFile(path)
+		if err != nil {
+			dst.Close()
+			return fmt.Errorf("dead letter: %w", err)
+		}
+		dead = transport.NewDeadLetter(dst, dlq, args[1])
+		dst = dead
+	}
 	defer dst.Close()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
+	failed := make(chan error, 1)
+	go func() {
+		select {
+		case <-drained:
+			fmt.Fprintln(os.Stderr, "drained; stopping")
+		case err := <-listenErr:
+			failed <- err
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	// Batch only when the destination sends batches in one call. The
+	// POST /mist endpoints of mist serve take the JSON arrays HTTP sends.
+	batch := 1
+	if _, ok := out.(transport.BatchSender); ok {
+		batch = cmd.GetInt("batch-size")
+	}
+	ropts := []relay.Option{relay.WithBatchSize(batch)}
+	if types := cmd.GetString("drop-types"); types != "" {
+		ropts = append(ropts, relay.WithFilter(relay.DropTypes(strings.Split(types, ",")...)))
+	}
+	if source := cmd.GetString("source"); source != "" {
+		ropts = append(ropts, relay.WithTransform(relay.SetSource(source)))
+	}
+	if urls := cmd.GetString("tee"); urls != "" {
+		var tees []transport.Sender
+		for _, u := range strings.Split(urls, ",") {
+			t, err := transport.Dial(u)
+			if err != nil {
+				return fmt.Errorf("dial tee: %w", err)
+			}
+			tee := transport.Wrap(t, transport.WithPeerVersion(version))
+			defer tee.Close()
+			tees = append(tees, tee)
+		}
+		ropts = append(ropts, relay.WithTee(tees...))
+	}
+
+	r := relay.New(src, dst, ropts...)
+	if path := cmd.GetString("admin-socket"); path != "" {
+		a := server.NewAdmin("relay")
+		a.Status = func() any {
+			st := r.Stats()
+			return relayStatus{
+				Src: args[0], Dst: args[1],
+				Relayed: st.Relayed, Expired: st.Expired, Filtered: st.Filtered,
+				DeadLettered: st.DeadLettered, DrainedBy: st.DrainedBy,
+			}
+		}
+		a.Pause, a.Resume = r.Pause, r.Resume
+		a.Stop = cancel
+		closeAdmin, err := serveAdmin(ctx, a, path)
+		if err != nil {
+			return err
+		}
+		defer closeAdmin()
+	}
 
-	var count int64
 	fmt.Fprintf(os.Stderr, "relaying %s → %s\n", args[0], args[1])
+	err = r.Run(ctx)
+	select {
+	case lerr := <-failed:
+		err = errors.Join(err, lerr)
+	default:
+	}
+	stats := r.Stats()
+	if stats.DrainedBy != "" {
+		fmt.Fprintf(os.Stderr, "drain requested by %s\n", stats.DrainedBy)
+	}
+	if err != nil {
+		return err
+	}
+
+	var dlq int64
+	if dead != nil {
+		dlq = dead.Count()
+	}
+	summary := fmt.Sprintf("relayed %d messages (%d expired in transit", stats.Relayed-dlq, stats.Expired)
+	if stats.Filtered > 0 {
+		summary += fmt.Sprintf(", %d filtered", stats.Filtered)
+	}
+	if dlq > 0 {
+		summary += fmt.Sprintf(", %d dead-lettered to %s", dlq, cmd.GetString("dead-letter"))
+	}
+	fmt.Fprintln(os.Stderr, summary+")")
+	return nil
+}
+
+func cmdTrace(cmd *cli.Command, args []string) error {
+	if len(args) < 3 || args[0] != "diff" {
+		return cli.Usagef("usage: mist trace diff <trace-a> <trace-b>")
+	}
+
+	base := strings.TrimRight(cmd.GetString("url"), "/")
+	q := url.Values{"a": {args[1]}, "b": {args[2]}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/traces/compare?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+	defer resp.Body.Close()
 
-	for {
-		msg, err := src.Receive(ctx)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("compare: status %d", resp.StatusCode)
+	}
+
+	var cmp tokentrace.TraceComparison
+	if err := json.NewDecoder(resp.Body).Decode(&cmp); err != nil {
+		return fmt.Errorf("compare: decode: %w", err)
+	}
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		return out.JSON(cmp)
+	}
+
+	rows := make([][]string, 0, len(cmp.Spans))
+	for _, d := range cmp.Spans {
+		status := d.StatusA
+		if d.StatusChanged() {
+			status = d.StatusA + "→" + d.StatusB
+		}
+		rows = append(rows, []string{
+			fmt.Sprintf("%s#%d", d.Operation, d.Occurrence),
+			fmt.Sprintf("%.1f", d.LatencyAMS),
+			fmt.Sprintf("%.1f", d.LatencyBMS),
+			fmt.Sprintf("%+.1f", d.LatencyDeltaMS),
+			fmt.Sprintf("%+.6f", d.CostDeltaUSD),
+			status,
+		})
+	}
+	out.Table([]string{"OPERATION", "A_MS", "B_MS", "DELTA_MS", "DELTA_USD", "STATUS"}, rows)
+
+	for _, op := range cmp.OnlyInA {
+		fmt.Fprintf(os.Stdout, "- %s (only in %s)\n", op, cmp.TraceA)
+	}
+	for _, op := range cmp.OnlyInB {
+		fmt.Fprintf(os.Stdout, "+ %s (only in %s)\n", op, cmp.TraceB)
+	}
+	fmt.Fprintf(os.Stdout, "total: %+.1f ms, %+.6f USD\n", cmp.LatencyDeltaMS, cmp.CostDeltaUSD)
+	return nil
+}
+
+func cmdCheckpoint(cmd *cli.Command, args []string) error {
+	if len(args) < 2 || args[0] != "compact" {
+		return cli.Usagef("usage: mist checkpoint compact <run-id>")
+	}
+
+	opts := []checkpoint.Option{checkpoint.WithMaxLogSize(0)}
+	if ref := cmd.GetString("key"); ref != "" {
+		ring, err := secrets.LoadKeyring(ref)
 		if err != nil {
-			if ctx.Err() != nil {
-				break
+			return err
+		}
+		opts = append(opts, checkpoint.WithEncryption(ring))
+	}
+	cp, err := checkpoint.Open(cmd.GetString("dir"), args[1], opts...)
+	if err != nil {
+		return err
+	}
+	defer cp.Close()
+
+	before := cp.LogSize()
+	if err := cp.Compact(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "compacted %s: %d → %d bytes (%d completed steps)\n",
+		cp.RunID(), before, cp.LogSize(), len(cp.CompletedSteps()))
+	return nil
+}
+
+func cmdJob(cmd *cli.Command, args []string) error {
+	if len(args) < 2 {
+		return cli.Usagef("usage: mist job pause|resume|cancel|status <run-id>")
+	}
+	action, runID := args[0], args[1]
+
+	base := strings.TrimRight(cmd.GetString("url"), "/")
+	method, target := http.MethodPost, base+"/jobs/"+url.PathEscape(runID)+"/"+action
+	switch action {
+	case "pause", "resume", "cancel":
+	case "status":
+		method, target = http.MethodGet, base+"/jobs/"+url.PathEscape(runID)
+	default:
+		return cli.Usagef("unknown job action %q (want pause, resume, cancel, or status)", action)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("job %s: status %d", action, resp.StatusCode)
+	}
+
+	var st checkpoint.RunStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return fmt.Errorf("job %s: decode: %w", action, err)
+	}
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		return out.JSON(st)
+	}
+	eta := "-"
+	if d := st.Progress.ETA(); d > 0 {
+		eta = d.Round(time.Second).String()
+	}
+	out.Table([]string{"RUN", "STATE", "STEPS", "PROGRESS", "ETA"}, [][]string{{
+		st.RunID,
+		string(st.State),
+		fmt.Sprintf("%d/%d", st.Progress.Completed, st.Progress.Total),
+		fmt.Sprintf("%.0f%%", st.Progress.Percent),
+		eta,
+	}})
+	return nil
+}
+
+func cmdInfer(cmd *cli.Command, args []string) error {
+	if len(args) < 1 {
+		return cli.Usagef("usage: mist infer [--dry-run] <prompt>")
+	}
+
+	req := protocol.InferRequest{
+		Model:    cmd.GetString("model"),
+		Messages: []protocol.ChatMessage{{Role: "user", Content: strings.Join(args, " ")}},
+	}
+	if n := cmd.GetInt("max-tokens"); n > 0 {
+		req.Params = map[string]any{"max_tokens": n}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	dryRun := cmd.GetBool("dry-run")
+	target := strings.TrimRight(cmd.GetString("url"), "/") + "/infer"
+	if dryRun {
+		target += "?dry_run=true"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return fmt.Errorf("infer: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("infer: status %d", resp.StatusCode)
+	}
+
+	out := output.New(cmd.GetString("format"))
+	if dryRun {
+		var est infermux.CostEstimate
+		if err := json.NewDecoder(resp.Body).Decode(&est); err != nil {
+			return fmt.Errorf("infer: decode: %w", err)
+		}
+		if out.Format == "json" {
+			return out.JSON(est)
+		}
+		cost, maxCost := "unpriced", "unpriced"
+		if est.Priced {
+			cost = fmt.Sprintf("$%.6f", est.InputCostUSD)
+			maxCost = "-"
+			if est.MaxTokensOut > 0 {
+				maxCost = fmt.Sprintf("$%.6f", est.MaxCostUSD)
+			}
+		}
+		out.Table([]string{"MODEL", "PROVIDER", "TOKENS IN", "MAX OUT", "INPUT COST", "MAX COST"}, [][]string{{
+			est.Model,
+			est.Provider,
+			fmt.Sprint(est.TokensIn),
+			fmt.Sprint(est.MaxTokensOut),
+			cost,
+			maxCost,
+		}})
+		return nil
+	}
+
+	var ir protocol.InferResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ir); err != nil {
+		return fmt.Errorf("infer: decode: %w", err)
+	}
+	if out.Format == "json" {
+		return out.JSON(ir)
+	}
+	fmt.Fprintln(os.Stdout, ir.Content)
+	fmt.Fprintf(os.Stderr, "%s/%s: %d in, %d out, $%.6f, %dms\n",
+		ir.Provider, ir.Model, ir.TokensIn, ir.TokensOut, ir.CostUSD, ir.LatencyMS)
+	return nil
+}
+
+// pricingCheck is one model seen in spans, for mist pricing check.
+type pricingCheck struct {
+	Model    string `json:"model"`
+	Provider string `json:"provider"`
+	Spans    int    `json:"spans"`
+	Priced   bool   `json:"priced"`
+}
+
+func cmdPricing(cmd *cli.Command, args []string) error {
+	if len(args) < 1 || args[0] != "check" {
+		return cli.Usagef("usage: mist pricing check [--file pricing.toml]")
+	}
+
+	table, err := pricing.Load(cmd.GetString("file"))
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimRight(cmd.GetString("url"), "/")
+	q := url.Values{"limit": {fmt.Sprint(cmd.GetInt("limit"))}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/spans?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("pricing check: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pricing check: status %d", resp.StatusCode)
+	}
+
+	var spans tokentrace.SpansResponse
+	if err := json.NewDecoder(resp.Body).Decode(&spans); err != nil {
+		return fmt.Errorf("pricing check: decode: %w", err)
+	}
+
+	seen := make(map[string]*pricingCheck)
+	var models []string
+	for _, s := range spans.Spans {
+		model, _ := s.Attrs["model"].(string)
+		if model == "" || model == "auto" {
+			continue
+		}
+		c, ok := seen[model]
+		if !ok {
+			provider, _ := s.Attrs["provider"].(string)
+			c = &pricingCheck{Model: model, Provider: provider, Priced: table.Has(model)}
+			seen[model] = c
+			models = append(models, model)
+		}
+		c.Spans++
+	}
+	sort.Strings(models)
+
+	checks := make([]pricingCheck, 0, len(models))
+	var unknown int
+	for _, m := range models {
+		checks = append(checks, *seen[m])
+		if !seen[m].Priced {
+			unknown++
+		}
+	}
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		if err := out.JSON(checks); err != nil {
+			return err
+		}
+	} else {
+		rows := make([][]string, 0, len(checks))
+		for _, c := range checks {
+			status := "ok"
+			if !c.Priced {
+				status = "UNKNOWN"
 			}
-			return fmt.Errorf("receive: %w", err)
+			rows = append(rows, []string{c.Model, c.Provider, fmt.Sprint(c.Spans), status})
+		}
+		out.Table([]string{"MODEL", "PROVIDER", "SPANS", "PRICING"}, rows)
+	}
+
+	if unknown > 0 {
+		return fmt.Errorf("%d models have no pricing", unknown)
+	}
+	return nil
+}
+
+func cmdDebug(cmd *cli.Command, args []string) error {
+	usage := cli.Usagef("usage: mist debug profile <url> [--seconds 30] [--out cpu.pprof]")
+	if len(args) < 2 || args[0] != "profile" {
+		return usage
+	}
+	// Flags may also follow the subcommand or the URL, as in
+	// "mist debug profile <url> --seconds 10".
+	if err := cmd.Flags.Parse(args[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	if cmd.Flags.NArg() < 1 {
+		return usage
+	}
+	node := cmd.Flags.Arg(0)
+	if err := cmd.Flags.Parse(cmd.Flags.Args()[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+
+	kind := cmd.GetString("type")
+	seconds := cmd.GetInt("seconds")
+	prefix := "/" + strings.Trim(cmd.GetString("prefix"), "/")
+
+	endpoint := strings.TrimRight(node, "/") + prefix + "/pprof/"
+	timeout := 30 * time.Second
+	switch kind {
+	case "cpu", "trace":
+		if seconds <= 0 {
+			return cli.Usagef("--seconds must be positive")
+		}
+		name := "profile"
+		if kind == "trace" {
+			name = "trace"
+		}
+		endpoint += name + "?seconds=" + fmt.Sprint(seconds)
+		timeout += time.Duration(seconds) * time.Second
+	case "heap", "allocs", "goroutine", "block", "mutex", "threadcreate":
+		endpoint += kind
+	default:
+		return cli.Usagef("unknown profile type %q", kind)
+	}
+
+	out := cmd.GetString("out")
+	if out == "" {
+		out = kind + ".pprof"
+	}
+	token := cmd.GetString("token")
+	if token == "" {
+		token = os.Getenv("MIST_DEBUG_TOKEN")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if kind == "cpu" || kind == "trace" {
+		fmt.Fprintf(os.Stderr, "profiling %s for %ds\n", node, seconds)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("profile: status %d", resp.StatusCode)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s (%d bytes)\n", out, n)
+	return nil
+}
+
+func cmdErrors(cmd *cli.Command, args []string) error {
+	if len(args) < 1 || args[0] != "list" {
+		return cli.Usagef("usage: mist errors list")
+	}
+
+	catalog := misterrors.Catalog()
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		return out.JSON(catalog)
+	}
+
+	rows := make([][]string, 0, len(catalog))
+	for _, c := range catalog {
+		retry := "no"
+		if c.Retryable {
+			retry = "yes"
+		}
+		rows = append(rows, []string{
+			c.Code,
+			fmt.Sprint(c.HTTPStatus),
+			fmt.Sprint(c.ExitCode),
+			retry,
+			c.Description,
+		})
+	}
+	out.Table([]string{"CODE", "HTTP", "EXIT", "RETRY", "DESCRIPTION"}, rows)
+	return nil
+}
+
+// relayStatus is the details of a relay's admin status.
+type relayStatus struct {
+	Src          string `json:"src"`
+	Dst          string `json:"dst"`
+	Relayed      int64  `json:"relayed"`
+	Expired      int64  `json:"expired"`
+	Filtered     int64  `json:"filtered"`
+	DeadLettered int64  `json:"dead_lettered"`
+	DrainedBy    string `json:"drained_by,omitempty"`
+}
+
+// serveAdmin serves a on the unix socket at path until ctx is done or
+// the returned func is called. That func waits for the socket to close,
+// so a stop request is answered before the command exits.
+func serveAdmin(ctx context.Context, a *server.Admin, path string) (func(), error) {
+	ln, err := server.ListenAdmin(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := a.Serve(ctx, ln); err != nil {
+			fmt.Fprintf(os.Stderr, "admin: %v\n", err)
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "admin socket %s\n", path)
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+func cmdAdmin(cmd *cli.Command, args []string) error {
+	usage := cli.Usagef("usage: mist admin drain <url> [--timeout 5m] [--reason text]\n       mist admin --socket <path> status|pause|resume|stop")
+	if len(args) > 0 && args[0] != "drain" {
+		return adminSocket(cmd, args, usage)
+	}
+	if len(args) < 2 {
+		return usage
+	}
+	if err := cmd.Flags.Parse(args[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	if cmd.Flags.NArg() < 1 {
+		return usage
+	}
+	node := strings.TrimRight(cmd.Flags.Arg(0), "/")
+	if err := cmd.Flags.Parse(cmd.Flags.Args()[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	timeout, err := time.ParseDuration(cmd.GetString("timeout"))
+	if err != nil || timeout <= 0 {
+		return cli.Usagef("invalid --timeout %q", cmd.GetString("timeout"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	msg, err := protocol.New("mist", protocol.TypeControlDrain, protocol.ControlDrain{Reason: cmd.GetString("reason")})
+	if err != nil {
+		return err
+	}
+	if err := sendDrain(ctx, node, envDefault(cmd.GetString("token"), "MIST_DRAIN_TOKEN"), msg); err != nil {
+		return fmt.Errorf("drain: %w", err)
+	}
+
+	st, err := drainStatus(ctx, node)
+	for err == nil && st.State != protocol.DrainDrained && !cmd.GetBool("no-wait") {
+		fmt.Fprintf(os.Stderr, "draining %s: %d in flight, %d queued\n", node, st.InFlight, st.Queued)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("drain: %s not drained after %s (%d in flight, %d queued)", node, timeout, st.InFlight, st.Queued)
+		case <-time.After(time.Second):
+		}
+		st, err = drainStatus(ctx, node)
+	}
+	if err != nil {
+		return fmt.Errorf("drain: %w", err)
+	}
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		return out.JSON(st)
+	}
+	out.Table([]string{"NODE", "STATE", "IN FLIGHT", "QUEUED"}, [][]string{{
+		node, st.State, fmt.Sprint(st.InFlight), fmt.Sprint(st.Queued),
+	}})
+	return nil
+}
+
+// adminSocket runs a status, pause, resume, or stop command against the
+// admin socket of a local relay or node.
+func adminSocket(cmd *cli.Command, args []string, usage error) error {
+	if err := cmd.Flags.Parse(args[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	path := cmd.GetString("socket")
+	if path == "" || cmd.Flags.NArg() > 0 {
+		return usage
+	}
+	method := http.MethodPost
+	switch args[0] {
+	case "status":
+		method = http.MethodGet
+	case "pause", "resume", "stop":
+	default:
+		return usage
+	}
+
+	req, err := http.NewRequest(method, "http://admin/"+args[0], nil)
+	if err != nil {
+		return err
+	}
+	resp, err := server.AdminClient(path).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin %s: status %d", args[0], resp.StatusCode)
+	}
+	var st server.AdminStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return fmt.Errorf("admin %s: %w", args[0], err)
+	}
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		return out.JSON(st)
+	}
+	state := "running"
+	switch {
+	case st.Stopping:
+		state = "stopping"
+	case st.Paused:
+		state = "paused"
+	}
+	rows := [][]string{
+		{"command", st.Command},
+		{"pid", fmt.Sprint(st.PID)},
+		{"state", state},
+		{"uptime", time.Since(st.Started).Round(time.Second).String()},
+	}
+	details, _ := st.Details.(map[string]any)
+	for _, k := range slices.Sorted(maps.Keys(details)) {
+		rows = append(rows, []string{k, fmt.Sprint(details[k])})
+	}
+	out.Table([]string{"FIELD", "VALUE"}, rows)
+	return nil
+}
+
+// sendDrain POSTs msg, a control.drain, to node's /mist endpoint with
+// token, if set, as a bearer token.
+func sendDrain(ctx context.Context, node, token string, msg *protocol.Message) error {
+	body, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node+"/mist", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", protocol.JSON.ContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusForbidden:
+		return fmt.Errorf("%s refused the drain; run it on the node's host or pass its --token", node)
+	}
+	return fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// envDefault returns v, or if it is empty the environment variable name.
+func envDefault(v, name string) string {
+	if v == "" {
+		return os.Getenv(name)
+	}
+	return v
+}
+
+// drainStatus fetches GET /drain from node.
+func drainStatus(ctx context.Context, node string) (protocol.DrainStatus, error) {
+	var st protocol.DrainStatus
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+"/drain", nil)
+	if err != nil {
+		return st, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return st, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	return st, err
+}
+
+func cmdSnapshot(cmd *cli.Command, args []string) error {
+	usage := cli.Usagef("usage: mist snapshot <url> [--out node.json]")
+	if len(args) < 1 {
+		return usage
+	}
+	// Flags may follow the URL, as in "mist snapshot <url> --out node.json".
+	if err := cmd.Flags.Parse(args[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	node := strings.TrimRight(args[0], "/")
+	token := cmd.GetString("token")
+	if token == "" {
+		token = os.Getenv("MIST_DEBUG_TOKEN")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+"/snapshotz", nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("snapshot: status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	var doc bytes.Buffer
+	if err := json.Indent(&doc, body, "", "  "); err != nil {
+		return fmt.Errorf("snapshot: invalid response: %w", err)
+	}
+
+	out := cmd.GetString("out")
+	if out == "" {
+		_, err := doc.WriteTo(os.Stdout)
+		return err
+	}
+	if err := os.WriteFile(out, doc.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s (%d bytes)\n", out, doc.Len())
+	return nil
+}
+
+func cmdImport(cmd *cli.Command, args []string) error {
+	if len(args) < 1 {
+		return cli.Usagef("usage: mist import --format jaeger|otlp-json|csv <file>")
+	}
+	if err := cmd.Flags.Parse(args[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	format := cmd.GetString("format")
+	if format == "" {
+		return cli.Usagef("--format is required (jaeger, otlp-json, or csv)")
+	}
+	timeout, err := time.ParseDuration(cmd.GetString("timeout"))
+	if err != nil || timeout <= 0 {
+		return cli.Usagef("invalid --timeout %q", cmd.GetString("timeout"))
+	}
+
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
 		}
+		defer f.Close()
+		in = f
+	}
+	// Spans are sent in batches as the dump is decoded, so a dump never
+	// has to fit in memory; each batch is one request, retried whole.
+	base := strings.TrimRight(cmd.GetString("url"), "/")
+	tr := transport.NewHTTP(base + "/mist")
+	defer tr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-		if err := dst.Send(ctx, msg); err != nil {
-			return fmt.Errorf("send: %w", err)
+	size := max(cmd.GetInt("batch"), 1)
+	batch := make([]*protocol.Message, 0, size)
+	sent := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := retry.Do(ctx, retry.DefaultPolicy, func(ctx context.Context) error {
+			return transport.SendBatch(ctx, tr, batch)
+		})
+		if err != nil {
+			return fmt.Errorf("import: sending batch after %d spans: %w", sent, err)
 		}
-		count++
+		sent += len(batch)
+		batch = batch[:0]
+		return nil
 	}
+	err = tokentrace.ImportEach(in, format, func(span protocol.TraceSpan) error {
+		msg, err := protocol.New("mist-import", protocol.TypeTraceSpan, span)
+		if err != nil {
+			return err
+		}
+		if batch = append(batch, msg); len(batch) < size {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	fmt.Fprintf(os.Stderr, "imported %d spans into %s\n", sent, base)
+	return err
+}
+
+func cmdExport(cmd *cli.Command, args []string) error {
+	if len(args) < 1 || args[0] != "spans" {
+		return cli.Usagef("usage: mist export spans [--since 24h] [--format csv|parquet] [--out spans.csv]")
+	}
+	if err := cmd.Flags.Parse(args[1:]); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	format := cmd.GetString("format")
+	if format != "csv" && format != "parquet" {
+		return cli.Usagef("unknown format %q (want csv or parquet)", format)
+	}
+
+	q := url.Values{"format": {format}}
+	if since := cmd.GetString("since"); since != "" {
+		q.Set("since", since)
+	}
+	if cols := cmd.GetString("columns"); cols != "" {
+		q.Set("columns", cols)
+	}
+	endpoint := strings.TrimRight(cmd.GetString("url"), "/") + "/export?" + q.Encode()
 
-	fmt.Fprintf(os.Stderr, "relayed %d messages\n", count)
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	out := cmd.GetString("out")
+	if out == "" {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s (%d bytes)\n", out, n)
 	return nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/misttest"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/server"
+	"github.com/greynewell/mist-go/transport"
+)
+
+// errProxyFault is the fault mist proxy injects into transport streams.
+var errProxyFault = errors.New("proxy: fault injected")
+
+// cmdProxy injects latency and errors between two running services
+// without modifying either. An http(s) upstream gets a reverse proxy
+// listening on <listen>, in which failed requests are answered 503; any
+// other upstream is a transport URL, and messages received from the
+// <listen> transport URL are forwarded to it, with failed ones dropped:
+//
+//	mist proxy :9090 http://localhost:8081 --latency 200ms --jitter 50ms --error-rate 0.01
+//	mist proxy file:///tmp/in.jsonl grpc://tokentrace:9090 --error-rate 0.1
+func cmdProxy(cmd *cli.Command, args []string) error {
+	args, err := parseInterspersed(cmd, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return cli.Usagef("usage: mist proxy <listen> <upstream> [--latency 200ms] [--jitter 50ms] [--error-rate 0.01]")
+	}
+
+	var cfg misttest.FaultConfig
+	for _, f := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"latency", &cfg.Delay}, {"jitter", &cfg.DelayJitter}} {
+		d, err := time.ParseDuration(cmd.GetString(f.name))
+		if err != nil || d < 0 {
+			return cli.Usagef("invalid --%s %q", f.name, cmd.GetString(f.name))
+		}
+		*f.dst = d
+	}
+	cfg.ErrorRate = cmd.GetFloat64("error-rate")
+	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		return cli.Usagef("invalid --error-rate %g (want 0 to 1)", cfg.ErrorRate)
+	}
+
+	listen, upstream := args[0], args[1]
+	if strings.HasPrefix(upstream, "http://") || strings.HasPrefix(upstream, "https://") {
+		return proxyHTTP(listen, upstream, cfg)
+	}
+	return proxyTransport(listen, upstream, cfg)
+}
+
+// proxyHTTP serves a fault-injecting reverse proxy to upstream on addr
+// until interrupted.
+func proxyHTTP(addr, upstream string, cfg misttest.FaultConfig) error {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Host == "" {
+		return cli.Usagef("invalid upstream URL %q", upstream)
+	}
+	addr = strings.TrimPrefix(addr, "http://")
+
+	h := misttest.NewFaultProxy(u, cfg)
+	srv := server.New(addr)
+	srv.Mux().Handle("/", h)
+
+	fmt.Fprintf(os.Stderr, "proxying %s → %s (latency %v±%v, error rate %g)\n", addr, upstream, cfg.Delay, cfg.DelayJitter, cfg.ErrorRate)
+	if err := srv.ListenAndServe(); err != nil {
+		return err
+	}
+	n, injected := h.Stats()
+	fmt.Fprintf(os.Stderr, "proxied %d requests (%d faults injected)\n", n, injected)
+	return nil
+}
+
+// proxyTransport forwards messages from the src transport URL to dst
+// through a misttest.FaultTransport until src ends or the proxy is
+// interrupted. A message hit by an injected fault is dropped, as it
+// would be by a lossy link.
+func proxyTransport(src, dst string, cfg misttest.FaultConfig) error {
+	cfg.Error = errProxyFault
+
+	in, err := transport.Dial(src)
+	if err != nil {
+		return fmt.Errorf("dial src: %w", err)
+	}
+	defer in.Close()
+	out, err := transport.Dial(dst)
+	if err != nil {
+		return fmt.Errorf("dial dst: %w", err)
+	}
+	faulty := misttest.NewFault(out, cfg)
+	defer faulty.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "proxying %s → %s (latency %v±%v, error rate %g)\n", src, dst, cfg.Delay, cfg.DelayJitter, cfg.ErrorRate)
+	var forwarded, dropped int64
+	for msg, err := range transport.Messages(ctx, in) {
+		if err != nil {
+			return fmt.Errorf("receive: %w", err)
+		}
+		if msg.Type == protocol.TypeControlDrain {
+			fmt.Fprintf(os.Stderr, "drain requested by %s\n", msg.Source)
+			break
+		}
+		switch err := faulty.Send(ctx, msg); {
+		case errors.Is(err, errProxyFault):
+			dropped++
+		case err != nil:
+			return fmt.Errorf("send: %w", err)
+		default:
+			forwarded++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "proxied %d messages (%d dropped by injected faults)\n", forwarded, dropped)
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/output"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+// cmdReconcile compares the token and cost totals TokenTrace computed
+// from spans with what each provider billed over the same period:
+//
+//	mist reconcile --from 2025-02-01 --to 2025-03-01 \
+//	    --usage openai=openai-feb.csv,anthropic=https://billing.internal/anthropic
+//
+// Provider usage comes from a billing export (.csv or .json) or an HTTP
+// endpoint returning JSON. The command exits non-zero if any provider or
+// model differs by more than --threshold.
+func cmdReconcile(cmd *cli.Command, args []string) error {
+	usage := cli.Usagef("usage: mist reconcile --usage provider=file.csv|url[,...] [--from 2025-02-01] [--to 2025-03-01]")
+	extra, err := parseInterspersed(cmd, args)
+	if err != nil {
+		return err
+	}
+	fetchers, err := parseUsageFetchers(cmd.GetString("usage"))
+	if err != nil {
+		return err
+	}
+	if len(extra) > 0 || len(fetchers) == 0 {
+		return usage
+	}
+	threshold := cmd.GetFloat64("threshold")
+	if threshold < 0 {
+		return cli.Usagef("--threshold must not be negative")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	local, err := fetchLocalUsage(ctx, cmd.GetString("url"), cmd.GetString("from"), cmd.GetString("to"))
+	if err != nil {
+		return err
+	}
+	if !local.Complete {
+		fmt.Fprintf(os.Stderr, "warning: TokenTrace no longer holds every span since %s; local totals undercount\n", local.From.Format(time.RFC3339))
+	}
+	rec := tokentrace.Reconcile(ctx, local.Usage, fetchers, local.From, local.To, threshold)
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		if err := out.JSON(rec); err != nil {
+			return err
+		}
+	} else {
+		rows := make([][]string, 0, len(rec.Providers)+len(rec.Unreconciled))
+		for _, d := range rec.Providers {
+			model := d.Model
+			if model == "" {
+				model = "(total)"
+			}
+			status := "ok"
+			if d.Flagged {
+				status = "MISMATCH"
+			}
+			tokens := "-"
+			if d.Reported.Tokens() > 0 {
+				tokens = fmt.Sprintf("%d / %d (%+.1f%%)", d.Local.Tokens(), d.Reported.Tokens(), d.TokensDelta*100)
+			}
+			rows = append(rows, []string{d.Provider, model, tokens,
+				fmt.Sprintf("$%.2f / $%.2f (%+.1f%%)", d.Local.CostUSD, d.Reported.CostUSD, d.CostDelta*100), status})
+		}
+		for _, u := range rec.Unreconciled {
+			provider := u.Provider
+			if provider == "" {
+				provider = "(none)"
+			}
+			rows = append(rows, []string{provider, "(total)", fmt.Sprint(u.Tokens()), fmt.Sprintf("$%.2f", u.CostUSD), "no usage source"})
+		}
+		out.Table([]string{"PROVIDER", "MODEL", "TOKENS LOCAL / BILLED", "COST LOCAL / BILLED", "STATUS"}, rows)
+	}
+
+	for provider, msg := range rec.Errors {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", provider, msg)
+	}
+	if n := len(rec.Flagged()); n > 0 {
+		return fmt.Errorf("reconcile: %d discrepancies above %.1f%%", n, threshold*100)
+	}
+	if len(rec.Errors) > 0 {
+		return fmt.Errorf("reconcile: %d providers could not be fetched", len(rec.Errors))
+	}
+	return nil
+}
+
+// parseUsageFetchers parses provider=source pairs, where a source is an
+// http(s) URL or a billing export file.
+func parseUsageFetchers(s string) (map[string]tokentrace.UsageFetcher, error) {
+	fetchers := make(map[string]tokentrace.UsageFetcher)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		provider, source, ok := strings.Cut(pair, "=")
+		if !ok || provider == "" || source == "" {
+			return nil, cli.Usagef("--usage: want provider=file-or-url, got %q", pair)
+		}
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			fetchers[provider] = tokentrace.UsageHTTP(source, nil)
+		} else {
+			fetchers[provider] = tokentrace.UsageFile(source)
+		}
+	}
+	return fetchers, nil
+}
+
+// fetchLocalUsage asks TokenTrace for its span totals over the period.
+func fetchLocalUsage(ctx context.Context, base, from, to string) (tokentrace.UsageResponse, error) {
+	var resp tokentrace.UsageResponse
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	endpoint := strings.TrimRight(base, "/") + "/usage?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return resp, err
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return resp, fmt.Errorf("reconcile: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return resp, fmt.Errorf("reconcile: status %d: %s", r.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("reconcile: decode: %w", err)
+	}
+	return resp, nil
+}
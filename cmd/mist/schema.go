@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/output"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// cmdSchema dumps the payload schemas of this build, and diffs two dumps
+// to flag breaking changes before a release:
+//
+//	mist schema dump > schemas-v1.4.json
+//	mist schema diff schemas-v1.4.json            # against this build
+//	mist schema diff schemas-v1.4.json schemas-v1.5.json
+//
+// diff exits non-zero if any change is breaking, so it can gate a
+// release in CI.
+func cmdSchema(cmd *cli.Command, args []string) error {
+	usage := cli.Usagef("usage: mist schema dump | diff <old.json> [new.json]")
+	if len(args) < 1 {
+		return usage
+	}
+	switch args[0] {
+	case "dump":
+		// Indented, so a dump checked in with each release diffs well.
+		data, err := json.MarshalIndent(protocol.PayloadSchemas(), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", data)
+		return err
+
+	case "diff":
+		files, err := parseInterspersed(cmd, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(files) < 1 || len(files) > 2 {
+			return usage
+		}
+		prev, err := readSchemaSet(files[0])
+		if err != nil {
+			return err
+		}
+		next := protocol.PayloadSchemas()
+		if len(files) == 2 {
+			if next, err = readSchemaSet(files[1]); err != nil {
+				return err
+			}
+		}
+		changes := protocol.DiffSchemas(prev, next)
+
+		out := output.New(cmd.GetString("format"))
+		if out.Format == "json" {
+			if changes == nil {
+				changes = []protocol.SchemaChange{}
+			}
+			if err := out.JSON(changes); err != nil {
+				return err
+			}
+		} else if len(changes) > 0 {
+			rows := make([][]string, 0, len(changes))
+			for _, c := range changes {
+				breaking := ""
+				if c.Breaking {
+					breaking = "yes"
+				}
+				rows = append(rows, []string{c.Path, c.Kind, c.Old, c.New, breaking})
+			}
+			out.Table([]string{"PATH", "CHANGE", "OLD", "NEW", "BREAKING"}, rows)
+		} else {
+			fmt.Fprintln(os.Stderr, "no schema changes")
+		}
+
+		if n := len(protocol.BreakingChanges(changes)); n > 0 {
+			return fmt.Errorf("schema diff: %d breaking changes", n)
+		}
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+// readSchemaSet reads a schema set written by mist schema dump.
+func readSchemaSet(path string) (protocol.SchemaSet, error) {
+	var set protocol.SchemaSet
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return set, err
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return set, fmt.Errorf("%s: %w", path, err)
+	}
+	if set.Payloads == nil {
+		return set, fmt.Errorf("%s: no payloads; is it from mist schema dump?", path)
+	}
+	return set, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/secrets"
+)
+
+func cmdSecrets(cmd *cli.Command, args []string) error {
+	usage := cli.Usagef("usage: mist secrets keygen | rekey <file>... --key env:NAME [--old-key env:NAME]")
+	if len(args) < 1 {
+		return usage
+	}
+	switch args[0] {
+	case "keygen":
+		k, err := secrets.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, k.String())
+		fmt.Fprintf(os.Stderr, "key %s: store it in a secret store and pass it as key=env:NAME\n", k.ID())
+		return nil
+
+	case "rekey":
+		files, err := parseInterspersed(cmd, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 || cmd.GetString("key") == "" {
+			return usage
+		}
+		ring, err := secrets.LoadKeyring(cmd.GetString("key"), cmd.GetString("old-key"))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			n, err := secrets.RekeyFile(f, ring)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "rekeyed %s: %d lines under key %s\n", f, n, ring.Primary().ID())
+		}
+		return nil
+
+	default:
+		return usage
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/greynewell/mist-go/circuitbreaker"
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/config"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/health"
+	"github.com/greynewell/mist-go/infermux"
+	"github.com/greynewell/mist-go/lifecycle"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/observability"
+	"github.com/greynewell/mist-go/pricing"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/resource"
+	"github.com/greynewell/mist-go/server"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+// Node components are mounted under these prefixes on the [serve] addr,
+// so one node can run both, each with the API it has standalone.
+const (
+	tokentracePrefix = "/tokentrace"
+	infermuxPrefix   = "/infermux"
+)
+
+// defaultMaxSeries bounds the label combinations of each metric a node
+// keeps, so one label fed unbounded values can't exhaust its memory.
+const defaultMaxSeries = 10000
+
+// Intervals at which a node rechecks retention and its pricing and
+// aliases files.
+const (
+	retentionInterval = time.Minute
+	pricingInterval   = 30 * time.Second
+	aliasesInterval   = 30 * time.Second
+)
+
+// cmdServe runs an all-in-one MIST node from a config file holding a
+// [serve] table and optional [tokentrace] and [infermux] tables, the
+// "node" schema of mist config check:
+//
+//	[serve]
+//	addr = ":8080"
+//
+//	[tokentrace]
+//	max_spans = 50000
+//
+//	[infermux.providers.local]
+//	kind = "echo"
+//	models = ["echo-small", "echo-large"]
+//
+// Every node serves /healthz, /readyz, /metricsz, and /resourcez; with
+// [serve] debug it also serves /snapshotz, /configz, and /logz/level,
+// behind debug_token if one is set.
+// TokenTrace is served under /tokentrace and InferMux under /infermux;
+// when the node runs TokenTrace and no trace_url is set, its spans,
+// including every InferMux request, are reported to itself. A
+// [serve.backpressure] table makes both shed ingest load with 503 and
+// Retry-After once saturated, and [serve.metrics_push] pushes the node's
+// metrics to StatsD or an OpenMetrics endpoint. With --admin-socket, mist
+// admin can pause the node's ingest, resume it, or stop the node. With
+// --cache-dir, InferMux caches provider responses in that directory, and
+// --replay-only serves only those, for offline and deterministic runs.
+func cmdServe(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return cli.Usagef("usage: mist serve [--config mist.toml]")
+	}
+
+	path := cmd.GetString("config")
+	data, err := config.ParseFile(path)
+	if err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	if problems := checkConfig("node", data, ""); len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, p)
+		}
+		return fmt.Errorf("serve: %s: %d problems", path, len(problems))
+	}
+	if _, ok := data["serve"]; !ok {
+		return fmt.Errorf("serve: %s: missing [serve] table", path)
+	}
+	if _, ok := data["relay"]; ok {
+		fmt.Fprintf(os.Stderr, "%s: [relay] is not run by mist serve; use mist relay\n", path)
+	}
+
+	n := decodeNode(data)
+	n.adminSocket = cmd.GetString("admin-socket")
+	if cmd.GetString("cache-dir") != "" || cmd.GetBool("replay-only") {
+		if n.infermux == nil {
+			return cli.Usagef("--cache-dir and --replay-only need an [infermux] table")
+		}
+		n.cacheFlags = cmd
+	}
+	ln, err := net.Listen("tcp", n.serve.Addr)
+	if err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	defer ln.Close()
+	if err := n.setup(ln); err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return lifecycle.Run(func(ctx context.Context) error {
+		return n.run(ctx, ln)
+	}, n.tel.LifecycleOptions()...)
+}
+
+// node is an all-in-one MIST node: the decoded tables of a node config
+// and, once set up, the server and telemetry serving them.
+type node struct {
+	cfg        *config.Config // the whole file, for /configz
+	serve      *serveConfig
+	tokentrace *tokentrace.Config // nil without a [tokentrace] table
+	infermux   map[string]any     // the [infermux] table, or nil
+
+	srv  *server.Server
+	tel  *observability.Telemetry
+	snap *server.Snapshotter // nil unless [serve] debug is set
+
+	adminSocket string       // from --admin-socket, or empty
+	cacheFlags  *cli.Command // for infermux.CacheFromFlags, or nil
+	paused      atomic.Bool  // ingest refused, from the admin socket
+}
+
+// decodeNode decodes a node config already vetted by checkConfig.
+func decodeNode(data map[string]any) *node {
+	n := &node{cfg: config.New(data), serve: decodeSection(data, "serve").(*serveConfig)}
+	if _, ok := data["tokentrace"]; ok {
+		n.tokentrace = decodeSection(data, "tokentrace").(*tokentrace.Config)
+	}
+	if table, ok := data["infermux"].(map[string]any); ok {
+		n.infermux = table
+	}
+	return n
+}
+
+// setup initializes telemetry and builds the server for a node that will
+// listen on ln. When the node runs TokenTrace and no trace_url is set,
+// spans are reported to the node itself. Every request gets an ID, and
+// with [serve] debug the node also serves /snapshotz, /configz, and
+// /logz/level.
+func (n *node) setup(ln net.Listener) error {
+	sc := n.serve
+	traceURL := sc.TraceURL
+	if n.tokentrace != nil && traceURL == "" {
+		traceURL = loopbackURL(ln.Addr()) + tokentracePrefix
+	}
+	tool := sc.Tool
+	if tool == "" {
+		tool = "mist"
+	}
+	tel, err := observability.Init(observability.Config{
+		Tool:         tool,
+		LogLevel:     sc.LogLevel,
+		LogFormat:    sc.LogFormat,
+		LogSpans:     sc.LogSpans,
+		SpanLogAttrs: sc.SpanLogAttrs,
+		TraceURL:     traceURL,
+		CPUInterval:  sc.CPUInterval,
+		MaxProcs:     sc.MaxProcs,
+		MaxSeries:    sc.MaxSeries,
+	})
+	if err != nil {
+		return err
+	}
+
+	srv := server.New(sc.Addr)
+	srv.Use(server.RequestID())
+	if len(sc.CORS.AllowedOrigins) > 0 {
+		srv.Use(server.CORS(server.CORSConfig{
+			AllowedOrigins:   sc.CORS.AllowedOrigins,
+			AllowedMethods:   sc.CORS.AllowedMethods,
+			AllowedHeaders:   sc.CORS.AllowedHeaders,
+			ExposedHeaders:   sc.CORS.ExposedHeaders,
+			AllowCredentials: sc.CORS.AllowCredentials,
+			MaxAge:           sc.CORS.MaxAge,
+		}))
+	}
+	h := health.New(tool, version)
+	srv.Handle("GET /healthz", h.Liveness())
+	srv.Handle("GET /readyz", h.Readiness())
+	tel.Mount(srv.Mux())
+	if sc.Debug {
+		var opts []server.DebugOption
+		if sc.DebugToken != "" {
+			opts = append(opts, server.WithDebugToken(sc.DebugToken))
+		}
+		srv.EnableDebug(sc.DebugPrefix, opts...)
+
+		z := server.NewConfigz(n.cfg, server.WithRedact("debug_token", "drain_token", "delete_token"))
+		n.snap = server.NewSnapshotter(
+			server.WithSnapshotMetrics(tel.Metrics),
+			server.WithSnapshotResources(tel.Resources),
+			server.WithSnapshotConfig(z),
+		)
+		srv.EnableSnapshotz(n.snap, opts...)
+		srv.EnableConfigz(z, opts...)
+		srv.EnableLogz(tel.Logger, opts...)
+	}
+	n.srv, n.tel = srv, tel
+	return nil
+}
+
+// run mounts the node's components and serves on ln until ctx is done,
+// or until a stop request on the admin socket.
+func (n *node) run(ctx context.Context, ln net.Listener) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	if n.adminSocket != "" {
+		closeAdmin, err := serveAdmin(ctx, n.admin(ln, stop), n.adminSocket)
+		if err != nil {
+			return fmt.Errorf("serve: %w", err)
+		}
+		defer closeAdmin()
+	}
+	n.tel.Start(ctx)
+	if cfg := n.serve.MetricsPush; cfg.URL != "" {
+		cfg.OnError = func(err error) { slog.Warn("serve: metrics push failed", "error", err) }
+		p, err := metrics.NewPusher(n.tel.Metrics, cfg)
+		if err != nil {
+			return fmt.Errorf("serve: %w", err)
+		}
+		go p.Run(ctx)
+		lifecycle.OnShutdownHook(ctx, "metrics-push", p.Push)
+	}
+	if n.tokentrace != nil {
+		if err := n.mountTokenTrace(ctx); err != nil {
+			return fmt.Errorf("serve: %w", err)
+		}
+	}
+	if n.infermux != nil {
+		if err := n.mountInferMux(ctx); err != nil {
+			return fmt.Errorf("serve: %w", err)
+		}
+	}
+	return n.srv.Serve(ctx, ln)
+}
+
+// backpressure returns the controller for a component's ingest
+// endpoints, or nil if [serve.backpressure] sets no threshold.
+func (n *node) backpressure(component string) *resource.Backpressure {
+	if !n.serve.Backpressure.Enabled() {
+		return nil
+	}
+	return resource.NewBackpressure(component, n.serve.Backpressure, n.tel.Metrics)
+}
+
+// ingest returns the wrapper for a component's ingest handlers, which
+// refuses them while the node is paused and sheds load with the
+// component's backpressure controller, if there is one.
+func (n *node) ingest(component string) func(http.HandlerFunc) http.Handler {
+	bp := n.backpressure(component)
+	return func(h http.HandlerFunc) http.Handler {
+		var next http.Handler = h
+		if bp != nil {
+			next = bp.Middleware(h)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n.paused.Load() {
+				w.Header().Set("Retry-After", "5")
+				misterrors.WriteHTTP(w, r, misterrors.New(misterrors.CodeUnavailable, "serve: ingest is paused"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// nodeStatus is the details of a node's admin status.
+type nodeStatus struct {
+	Addr       string   `json:"addr"`
+	Components []string `json:"components"`
+}
+
+// admin returns the node's admin endpoint. Pausing refuses ingest with
+// 503 until resumed, while reads are still served; stop ends the node
+// as SIGTERM does.
+func (n *node) admin(ln net.Listener, stop func()) *server.Admin {
+	st := nodeStatus{Addr: ln.Addr().String(), Components: []string{}}
+	if n.tokentrace != nil {
+		st.Components = append(st.Components, "tokentrace")
+	}
+	if n.infermux != nil {
+		st.Components = append(st.Components, "infermux")
+	}
+	a := server.NewAdmin("serve")
+	a.Status = func() any { return st }
+	a.Pause = func() { n.paused.Store(true) }
+	a.Resume = func() { n.paused.Store(false) }
+	a.Stop = stop
+	return a
+}
+
+// decodeSection decodes the named table of a node file, already vetted by
+// checkConfig, over the schema's defaults.
+func decodeSection(data map[string]any, name string) any {
+	v := configSchemas[name].newConfig()
+	if table, ok := data[name].(map[string]any); ok {
+		config.Decode(table, v)
+	}
+	return v
+}
+
+// loopbackURL returns the http URL of a listener on this host, mapping
+// an unspecified address such as ":8080" to 127.0.0.1.
+func loopbackURL(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "http://" + addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// mountTokenTrace serves the TokenTrace API under tokentracePrefix. The
+// config's own addr is not used; the node listens on [serve] addr.
+func (n *node) mountTokenTrace(ctx context.Context) error {
+	cfg, srv, ingest := n.tokentrace, n.srv, n.ingest("tokentrace")
+	tt := tokentrace.NewHandler(*cfg)
+	tt.DrainGate().SetToken(n.serve.DrainToken)
+	if n.snap != nil {
+		n.snap.Register("aggregator", func() any { return tt.Aggregator().Stats() })
+	}
+	if cfg.Enrich.PricingFile != "" {
+		src, err := pricing.Watch(ctx, cfg.Enrich.PricingFile, pricingInterval)
+		if err != nil {
+			return err
+		}
+		tt.Pricer = src
+	}
+	tt.OnAlert = func(a protocol.TraceAlert) {
+		slog.Warn("tokentrace: alert", "level", a.Level, "metric", a.Metric, "value", a.Value, "threshold", a.Threshold, "message", a.Message)
+	}
+	if len(cfg.Retention) > 0 {
+		go tt.RunRetention(ctx, retentionInterval)
+	}
+	if a := tt.Autoscaler(); a != nil {
+		a.OnResize = func(from, to int) {
+			slog.Info("tokentrace: store resized", "from", from, "to", to, "pressure", a.Pressure())
+		}
+		go a.Run(ctx)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /mist", ingest(tt.Ingest))
+	mux.HandleFunc("GET /drain", tt.Drain)
+	mux.HandleFunc("GET /traces", tt.Traces)
+	mux.HandleFunc("DELETE /traces", tt.DeleteTraces)
+	mux.HandleFunc("GET /traces/compare", tt.Compare)
+	mux.HandleFunc("GET /traces/{id}", tt.TraceByID)
+	mux.HandleFunc("GET /traces/recent", tt.RecentSpans)
+	mux.HandleFunc("GET /spans", tt.Spans)
+	mux.HandleFunc("DELETE /spans", tt.DeleteSpans)
+	mux.HandleFunc("GET /stats", tt.StatsHandler)
+	mux.HandleFunc("GET /stats/operations", tt.TopOperations)
+	mux.HandleFunc("GET /stats/heatmap", tt.StatsHeatmap)
+	mux.HandleFunc("GET /audit", tt.Audit)
+	mux.HandleFunc("GET /export", tt.Export)
+	mux.HandleFunc("GET /usage", tt.Usage)
+	mux.HandleFunc("/store", tt.StoreCapacity)
+	mux.HandleFunc("GET /alerts", tt.Alerts)
+	mux.HandleFunc("/alerts/silence", tt.SilenceAlerts)
+	srv.Mux().Handle(tokentracePrefix+"/", http.StripPrefix(tokentracePrefix, mux))
+	return nil
+}
+
+// mountInferMux serves the InferMux API under infermuxPrefix from its
+// [infermux] table, routing to the configured providers and reporting
+// request spans to the node's reporter.
+func (n *node) mountInferMux(ctx context.Context) error {
+	table, srv, ingest := n.infermux, n.srv, n.ingest("infermux")
+	cfg := configSchemas["infermux"].newConfig().(*infermuxConfig)
+	config.Decode(table, cfg)
+
+	opts := []infermux.RouterOption{infermux.WithBreakers(circuitbreaker.Config{
+		Threshold: cfg.Breaker.Threshold,
+		Timeout:   cfg.Breaker.Timeout,
+	})}
+	if len(cfg.Webhooks) > 0 {
+		var list []infermux.Webhook
+		for _, name := range slices.Sorted(maps.Keys(cfg.Webhooks)) {
+			h := cfg.Webhooks[name]
+			list = append(list, infermux.Webhook{URL: h.URL, Secret: h.Secret, Events: h.Events})
+		}
+		hooks := infermux.NewWebhooks(list)
+		lifecycle.OnShutdownHook(ctx, "infermux-webhooks", hooks.Close)
+		opts = append(opts, infermux.WithWebhooks(hooks))
+	}
+	if len(cfg.Aliases) > 0 {
+		list, err := infermux.AliasesFromConfig(config.New(table))
+		if err != nil {
+			return err
+		}
+		aliases, err := infermux.NewAliases(list...)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, infermux.WithAliases(aliases))
+	}
+	if cfg.AliasesFile != "" {
+		aliases, err := infermux.WatchAliases(ctx, cfg.AliasesFile, aliasesInterval)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, infermux.WithAliases(aliases))
+	}
+	if cfg.PricingFile != "" {
+		src, err := pricing.Watch(ctx, cfg.PricingFile, pricingInterval)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, infermux.WithPricer(src))
+	}
+	if len(cfg.Budgets) > 0 {
+		var budgets []infermux.Budget
+		for _, name := range slices.Sorted(maps.Keys(cfg.Budgets)) {
+			b := cfg.Budgets[name]
+			budgets = append(budgets, infermux.Budget{
+				Name: name, Provider: b.Provider, Tenant: b.Tenant, Period: b.Period,
+				LimitUSD: b.LimitUSD, WarnAt: b.WarnAt, Block: b.Block,
+			})
+		}
+		opts = append(opts, infermux.WithBudgets(infermux.NewBudgetTracker(budgets, infermux.WithBudgetAlert(func(a protocol.TraceAlert) {
+			slog.Warn("infermux: budget alert", "level", a.Level, "value", a.Value, "threshold", a.Threshold, "message", a.Message)
+		}))))
+	}
+	opts = append(opts, infermux.WithPolicy(infermux.Policy{
+		AllowedModels:  cfg.Policy.AllowedModels,
+		MaxTemperature: cfg.Policy.MaxTemperature,
+		MaxTokens:      cfg.Policy.MaxTokens,
+		BannedParams:   cfg.Policy.BannedParams,
+	}))
+
+	// Providers are registered after the router is built, so webhooks
+	// hear about them. Spans reach TokenTrace through the exporter
+	// observability.Init installed, so the router has no reporter.
+	reg := infermux.NewRegistry()
+	router := infermux.NewRouter(reg, nil, opts...)
+	im := infermux.NewHandler(router, reg)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Providers)) {
+		p := cfg.Providers[name]
+		reg.Register(infermux.NewEchoProvider(name, p.Models, p.Delay))
+	}
+	if n.cacheFlags != nil {
+		if err := infermux.CacheFromFlags(n.cacheFlags, reg); err != nil {
+			return err
+		}
+	}
+	im.DrainGate().SetToken(n.serve.DrainToken)
+	if n.snap != nil {
+		n.snap.Register("breakers", func() any { return router.Breakers() })
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /mist", ingest(im.Ingest))
+	mux.Handle("POST /infer", ingest(im.InferDirect))
+	mux.HandleFunc("GET /drain", im.Drain)
+	mux.HandleFunc("GET /budgets", im.Budgets)
+	mux.HandleFunc("GET /experiments", im.Experiments)
+	mux.HandleFunc("GET /providers", im.Providers)
+	mux.HandleFunc("GET /models", im.Models)
+	srv.Mux().Handle(infermuxPrefix+"/", http.StripPrefix(infermuxPrefix, mux))
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/cli"
+	"github.com/greynewell/mist-go/config"
+	"github.com/greynewell/mist-go/output"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+// Smoke stages, in the order they run. Each needs the ones before it.
+var smokeStages = []string{"start", "infer", "span", "stats", "alert"}
+
+// smokeRule is the alert rule mist smoke adds. It holds whenever fewer
+// than all spans fail, so it fires on the first span ingested.
+var smokeRule = tokentrace.AlertRule{Metric: "error_rate", Op: "<", Threshold: 1, Level: "warning"}
+
+// smokeResult is one stage of a smoke test.
+type smokeResult struct {
+	Stage      string `json:"stage"`
+	OK         bool   `json:"ok"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Detail     string `json:"detail"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// cmdSmoke starts a throwaway node in process and drives a request
+// through it end to end: the node comes up, InferMux answers an infer
+// request, its span reaches TokenTrace, shows up in the stats, and fires
+// an alert. With --config, the node runs that file's [tokentrace] and
+// [infermux] tables, so a config can be checked before it's deployed;
+// without one it runs an echo provider and an empty store. The node
+// listens on a random loopback port and reports spans only to itself.
+func cmdSmoke(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return cli.Usagef("usage: mist smoke [--config node.toml] [--timeout 30s]")
+	}
+	timeout, err := time.ParseDuration(cmd.GetString("timeout"))
+	if err != nil || timeout <= 0 {
+		return cli.Usagef("invalid --timeout %q", cmd.GetString("timeout"))
+	}
+
+	data := map[string]any{}
+	if path := cmd.GetString("config"); path != "" {
+		if data, err = config.ParseFile(path); err != nil {
+			return fmt.Errorf("smoke: %w", err)
+		}
+		if problems := checkConfig("node", data, ""); len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, p)
+			}
+			return fmt.Errorf("smoke: %s: %d problems", path, len(problems))
+		}
+	}
+	n, model := smokeNode(data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results := runSmoke(ctx, n, model)
+
+	out := output.New(cmd.GetString("format"))
+	if out.Format == "json" {
+		if err := out.JSON(results); err != nil {
+			return err
+		}
+	} else {
+		rows := make([][]string, 0, len(results))
+		for _, r := range results {
+			status := "PASS"
+			switch {
+			case r.Skipped:
+				status = "SKIP"
+			case !r.OK:
+				status = "FAIL"
+			}
+			rows = append(rows, []string{r.Stage, status, fmt.Sprintf("%dms", r.DurationMS), r.Detail})
+		}
+		out.Table([]string{"STAGE", "RESULT", "TIME", "DETAIL"}, rows)
+	}
+
+	for _, r := range results {
+		if !r.OK {
+			return fmt.Errorf("smoke: failed at %s: %s", r.Stage, r.Detail)
+		}
+	}
+	return nil
+}
+
+// smokeNode adapts a node config for a smoke test and returns the model
+// to request. The node listens on a random loopback port, logs only
+// errors, and reports its spans to itself; TokenTrace gets smokeRule;
+// and InferMux gets an echo provider if the config has none.
+func smokeNode(data map[string]any) (*node, string) {
+	n := decodeNode(data)
+	n.serve.Addr = "127.0.0.1:0"
+	n.serve.TraceURL = ""
+	n.serve.LogLevel = "error"
+	n.serve.Debug = false
+
+	if n.tokentrace == nil {
+		cfg := tokentrace.DefaultConfig()
+		n.tokentrace = &cfg
+	}
+	n.tokentrace.AlertRules = append(n.tokentrace.AlertRules, smokeRule)
+
+	im := configSchemas["infermux"].newConfig().(*infermuxConfig)
+	if n.infermux != nil {
+		config.Decode(n.infermux, im)
+	}
+	for _, name := range slices.Sorted(maps.Keys(im.Providers)) {
+		if models := im.Providers[name].Models; len(models) > 0 {
+			return n, models[0]
+		}
+	}
+	n.infermux = maps.Clone(n.infermux)
+	if n.infermux == nil {
+		n.infermux = make(map[string]any)
+	}
+	n.infermux["providers"] = map[string]any{
+		"smoke": map[string]any{"kind": "echo", "models": []any{"mist-smoke"}},
+	}
+	return n, "mist-smoke"
+}
+
+// runSmoke starts n and runs each stage against it, skipping the stages
+// after the first failure.
+func runSmoke(ctx context.Context, n *node, model string) (results []smokeResult) {
+	results = make([]smokeResult, 0, len(smokeStages))
+	record := func(stage string, start time.Time, detail string, err error) bool {
+		r := smokeResult{Stage: stage, OK: err == nil, Detail: detail, DurationMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			r.Detail = err.Error()
+		}
+		results = append(results, r)
+		return r.OK
+	}
+	defer func() {
+		for _, stage := range smokeStages[len(results):] {
+			results = append(results, smokeResult{Stage: stage, Skipped: true, Detail: "skipped"})
+		}
+	}()
+
+	start := time.Now()
+	ln, err := net.Listen("tcp", n.serve.Addr)
+	if err == nil {
+		err = n.setup(ln)
+	}
+	if err != nil {
+		record("start", start, "", err)
+		return results
+	}
+	nodeCtx, stop := context.WithCancel(ctx)
+	var serveErr error
+	served := make(chan struct{})
+	go func() {
+		serveErr = n.run(nodeCtx, ln)
+		close(served)
+	}()
+	defer func() {
+		stop()
+		<-served
+	}()
+	base := loopbackURL(ln.Addr())
+	if err := waitHealthy(ctx, base, served, &serveErr); !record("start", start, "node up on "+base, err) {
+		return results
+	}
+
+	start = time.Now()
+	resp, err := smokeInfer(ctx, base, model)
+	detail := fmt.Sprintf("%s/%s answered, %d tokens", resp.Provider, resp.Model, resp.TokensIn+resp.TokensOut)
+	if !record("infer", start, detail, err) {
+		return results
+	}
+
+	start = time.Now()
+	span, err := waitSpan(ctx, base, model)
+	if !record("span", start, "trace "+span.TraceID, err) {
+		return results
+	}
+
+	start = time.Now()
+	var stats tokentrace.AggregatorStats
+	err = getJSON(ctx, base+tokentracePrefix+"/stats", &stats)
+	if err == nil && stats.ByOperation[span.Operation].Count == 0 {
+		err = fmt.Errorf("no %s spans counted in /stats", span.Operation)
+	}
+	detail = fmt.Sprintf("%d spans, %.1fms avg latency", stats.TotalSpans, stats.LatencyAvg)
+	if !record("stats", start, detail, err) {
+		return results
+	}
+
+	start = time.Now()
+	var alerts tokentrace.AlertsResponse
+	err = getJSON(ctx, base+tokentracePrefix+"/alerts", &alerts)
+	if err == nil && !alertActive(alerts, smokeRule.String()) {
+		err = fmt.Errorf("alert %q not firing", smokeRule.String())
+	}
+	record("alert", start, "fired "+smokeRule.String(), err)
+	return results
+}
+
+// waitHealthy polls /healthz until the node answers, it stops serving
+// (closing served, with the reason in *serveErr), or ctx is done.
+func waitHealthy(ctx context.Context, base string, served <-chan struct{}, serveErr *error) error {
+	for {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, base+"/healthz", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("/healthz: status %d", resp.StatusCode)
+		}
+		select {
+		case <-served:
+			return fmt.Errorf("node stopped: %v", *serveErr)
+		case <-ctx.Done():
+			return fmt.Errorf("node not healthy: %w", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func smokeInfer(ctx context.Context, base, model string) (protocol.InferResponse, error) {
+	var resp protocol.InferResponse
+	body, err := json.Marshal(protocol.InferRequest{
+		Model:    model,
+		Messages: []protocol.ChatMessage{{Role: "user", Content: "mist smoke test"}},
+	})
+	if err != nil {
+		return resp, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+infermuxPrefix+"/infer", bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return resp, fmt.Errorf("infer %s: status %d: %s", model, r.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	err = json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+// waitSpan polls TokenTrace until the span of the smoke request arrives.
+// The node is fresh, so any infer span for model is the smoke test's.
+func waitSpan(ctx context.Context, base, model string) (protocol.TraceSpan, error) {
+	q := url.Values{"operation": {"infermux.infer"}, "attr.model": {model}, "limit": {"1"}}
+	for {
+		var resp tokentrace.SpansResponse
+		err := getJSON(ctx, base+tokentracePrefix+"/spans?"+q.Encode(), &resp)
+		if err == nil && len(resp.Spans) > 0 {
+			return resp.Spans[0], nil
+		}
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = fmt.Errorf("no infermux.infer span for %s", model)
+			}
+			return protocol.TraceSpan{}, fmt.Errorf("span not reported: %w", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func alertActive(alerts tokentrace.AlertsResponse, rule string) bool {
+	for _, a := range alerts.Active {
+		if a.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// getJSON decodes the JSON body of GET url into v.
+func getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", req.URL.Path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
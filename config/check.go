@@ -0,0 +1,183 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Problem is a key in a config file that does not match the structure a
+// component expects.
+type Problem struct {
+	Key     string `json:"key"` // dotted path, e.g. "server.port" or "rules[2].op"
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return p.Message
+	}
+	return p.Key + ": " + p.Message
+}
+
+// Check compares parsed TOML data with the struct v, as Decode would map
+// it, and returns every problem found, sorted by key: keys with no
+// matching field, values of the wrong type, and missing fields tagged
+// validate:"required". Unlike Decode, which stops at the first error and
+// ignores unknown keys, Check is meant for vetting a file before deploy.
+// Required fields of a nested table are only checked if the table is
+// present.
+//
+//	data, err := config.ParseFile("tokentrace.toml")
+//	for _, p := range config.Check(data, &tokentrace.Config{}) {
+//		fmt.Println(p)
+//	}
+func Check(data map[string]any, v any) []Problem {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return []Problem{{Message: fmt.Sprintf("check: expected struct, got %v", t)}}
+	}
+
+	var problems []Problem
+	checkStruct("", data, t, &problems)
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Key < problems[j].Key })
+	return problems
+}
+
+func checkStruct(prefix string, data map[string]any, t reflect.Type, problems *[]Problem) {
+	known := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := fieldKey(field)
+		known[key] = true
+
+		val, ok := data[key]
+		if !ok {
+			if hasRule(field.Tag.Get("validate"), "required") {
+				*problems = append(*problems, Problem{Key: prefix + key, Message: "missing required field"})
+			}
+			continue
+		}
+		checkValue(prefix+key, val, field.Type, problems)
+	}
+
+	for key := range data {
+		if !known[key] {
+			*problems = append(*problems, Problem{Key: prefix + key, Message: "unknown key"})
+		}
+	}
+}
+
+func checkValue(key string, val any, t reflect.Type, problems *[]Problem) {
+	mismatch := func(want string) {
+		*problems = append(*problems, Problem{Key: key, Message: fmt.Sprintf("expected %s, got %s", want, tomlType(val))})
+	}
+
+	if t == durationType {
+		switch v := val.(type) {
+		case int64:
+		case string:
+			if _, err := time.ParseDuration(v); err != nil {
+				*problems = append(*problems, Problem{Key: key, Message: fmt.Sprintf("invalid duration %q", v)})
+			}
+		default:
+			mismatch("duration")
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if _, ok := val.(string); !ok {
+			mismatch("string")
+		}
+
+	case reflect.Int, reflect.Int64:
+		switch val.(type) {
+		case int64, float64:
+		default:
+			mismatch("integer")
+		}
+
+	case reflect.Float64:
+		switch val.(type) {
+		case int64, float64:
+		default:
+			mismatch("float")
+		}
+
+	case reflect.Bool:
+		if _, ok := val.(bool); !ok {
+			mismatch("boolean")
+		}
+
+	case reflect.Slice:
+		arr, ok := val.([]any)
+		if !ok {
+			mismatch("array")
+			return
+		}
+		for i, elem := range arr {
+			checkValue(fmt.Sprintf("%s[%d]", key, i), elem, t.Elem(), problems)
+		}
+
+	case reflect.Map:
+		m, ok := val.(map[string]any)
+		if !ok || t.Key().Kind() != reflect.String {
+			mismatch("table")
+			return
+		}
+		for k, elem := range m {
+			checkValue(key+"."+k, elem, t.Elem(), problems)
+		}
+
+	case reflect.Struct:
+		m, ok := val.(map[string]any)
+		if !ok {
+			mismatch("table")
+			return
+		}
+		checkStruct(key+".", m, t, problems)
+
+	default:
+		*problems = append(*problems, Problem{Key: key, Message: fmt.Sprintf("unsupported field type %s", t)})
+	}
+}
+
+// tomlType names the TOML type of a parsed value.
+func tomlType(val any) string {
+	switch val.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "table"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
+
+// hasRule reports whether a validate tag includes rule.
+func hasRule(tag, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if strings.TrimSpace(r) == rule {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type checkTarget struct {
+	Name   string `toml:"name" validate:"required"`
+	Weight int    `toml:"weight"`
+}
+
+type checkConfig struct {
+	Addr    string                 `toml:"addr" validate:"required"`
+	Port    int                    `toml:"port"`
+	Debug   bool                   `toml:"debug"`
+	Timeout time.Duration          `toml:"timeout"`
+	Tags    []string               `toml:"tags"`
+	Server  checkTarget            `toml:"server"`
+	Routes  map[string]checkTarget `toml:"routes"`
+}
+
+func TestCheckValid(t *testing.T) {
+	data, err := ParseTOML(strings.NewReader(`
+addr = ":8080"
+port = 8080
+timeout = "30s"
+tags = ["a", "b"]
+
+[server]
+name = "primary"
+
+[routes.fast]
+name = "haiku"
+weight = 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := Check(data, &checkConfig{}); len(problems) != 0 {
+		t.Errorf("problems = %v", problems)
+	}
+}
+
+func TestCheckProblems(t *testing.T) {
+	data, err := ParseTOML(strings.NewReader(`
+port = "8080"
+debug = 1
+timeout = "soon"
+tags = ["a", 2]
+colour = "blue"
+
+[server]
+weight = 1.5
+extra = true
+
+[routes.fast]
+weight = "heavy"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range Check(data, checkConfig{}) {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"addr: missing required field",
+		"colour: unknown key",
+		"debug: expected boolean, got integer",
+		"port: expected integer, got string",
+		"routes.fast.name: missing required field",
+		"routes.fast.weight: expected integer, got string",
+		"server.extra: unknown key",
+		"server.name: missing required field",
+		"tags[1]: expected string, got integer",
+		`timeout: invalid duration "soon"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCheckOptionalTable(t *testing.T) {
+	// Required fields of an absent table are not reported.
+	data := map[string]any{"addr": ":80"}
+	if problems := Check(data, &checkConfig{}); len(problems) != 0 {
+		t.Errorf("problems = %v", problems)
+	}
+}
+
+func TestCheckNotStruct(t *testing.T) {
+	if problems := Check(nil, 42); len(problems) != 1 {
+		t.Errorf("problems = %v, want one", problems)
+	}
+}
@@ -6,19 +6,15 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Load reads a TOML file and decodes it into the struct pointed to by v.
-// Environment variables with the given prefix override file values.
-// For a prefix "MATCHSPEC" and a field "Port", MATCHSPEC_PORT wins.
+// Files listed in the file's include directive are merged beneath it
+// (see ParseFile). Environment variables with the given prefix override
+// file values. For a prefix "MATCHSPEC" and a field "Port", MATCHSPEC_PORT wins.
 func Load(path, envPrefix string, v any) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("config: %w", err)
-	}
-	defer f.Close()
-
-	data, err := ParseTOML(f)
+	data, err := ParseFile(path)
 	if err != nil {
 		return fmt.Errorf("config: %w", err)
 	}
@@ -36,6 +32,8 @@ func Load(path, envPrefix string, v any) error {
 
 // Decode maps a parsed TOML map onto a struct. Fields are matched by
 // their toml tag, or by lowercased field name if no tag is present.
+// time.Duration fields accept strings such as "5m" as well as
+// nanoseconds, and map fields with string keys are read from tables.
 func Decode(data map[string]any, v any) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
@@ -53,12 +51,7 @@ func decodeStruct(data map[string]any, rv reflect.Value) error {
 			continue
 		}
 
-		key := field.Tag.Get("toml")
-		if key == "" {
-			key = strings.ToLower(field.Name)
-		}
-
-		val, ok := data[key]
+		val, ok := data[fieldKey(field)]
 		if !ok {
 			continue
 		}
@@ -70,7 +63,29 @@ func decodeStruct(data map[string]any, rv reflect.Value) error {
 	return nil
 }
 
+// fieldKey is the TOML key of a struct field: its toml tag, or its
+// lowercased name.
+func fieldKey(field reflect.StructField) string {
+	if key := field.Tag.Get("toml"); key != "" {
+		return key
+	}
+	return strings.ToLower(field.Name)
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
 func setField(fv reflect.Value, val any) error {
+	if fv.Type() == durationType {
+		if s, ok := val.(string); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("invalid duration %q", s)
+			}
+			fv.SetInt(int64(d))
+			return nil
+		}
+	}
+
 	switch fv.Kind() {
 	case reflect.String:
 		s, ok := val.(string)
@@ -126,6 +141,24 @@ func setField(fv reflect.Value, val any) error {
 		}
 		return decodeStruct(m, fv)
 
+	case reflect.Map:
+		m, ok := val.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected table, got %T", val)
+		}
+		if fv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key kind: %s", fv.Type().Key().Kind())
+		}
+		out := reflect.MakeMapWithSize(fv.Type(), len(m))
+		for k, elem := range m {
+			ev := reflect.New(fv.Type().Elem()).Elem()
+			if err := setField(ev, elem); err != nil {
+				return fmt.Errorf("key %s: %w", k, err)
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(fv.Type().Key()), ev)
+		}
+		fv.Set(out)
+
 	default:
 		return fmt.Errorf("unsupported kind: %s", fv.Kind())
 	}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 type testConfig struct {
@@ -141,6 +142,35 @@ func TestDecodeIntFromFloat(t *testing.T) {
 	}
 }
 
+func TestDecodeDurationAndMap(t *testing.T) {
+	type route struct {
+		Target string `toml:"target"`
+	}
+	var cfg struct {
+		Timeout  time.Duration    `toml:"timeout"`
+		Interval time.Duration    `toml:"interval"`
+		Routes   map[string]route `toml:"routes"`
+	}
+	data := map[string]any{
+		"timeout":  "1m30s",
+		"interval": int64(time.Second),
+		"routes":   map[string]any{"fast": map[string]any{"target": "a/b"}},
+	}
+	if err := Decode(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeout != 90*time.Second || cfg.Interval != time.Second {
+		t.Errorf("durations = %v, %v", cfg.Timeout, cfg.Interval)
+	}
+	if cfg.Routes["fast"].Target != "a/b" {
+		t.Errorf("routes = %+v", cfg.Routes)
+	}
+
+	if err := Decode(map[string]any{"timeout": "soon"}, &cfg); err == nil {
+		t.Error("expected error for invalid duration")
+	}
+}
+
 func TestApplyEnv(t *testing.T) {
 	type cfg struct {
 		Name string
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IncludeKey is the top-level key that lists other TOML files to load
+// beneath the current one:
+//
+//	include = ["base.toml", "prod.toml"]
+//
+// Relative paths are resolved against the including file's directory.
+const IncludeKey = "include"
+
+// Merge combines maps into a new map. Precedence runs left to right:
+// later maps override earlier ones. Nested tables are merged key by key;
+// any other value (including arrays) is replaced wholesale. The inputs
+// are not modified.
+//
+//	merged := config.Merge(base, env, local) // local wins
+func Merge(maps ...map[string]any) map[string]any {
+	out := make(map[string]any)
+	for _, m := range maps {
+		mergeInto(out, m)
+	}
+	return out
+}
+
+func mergeInto(dst, src map[string]any) {
+	for k, v := range src {
+		if srcTable, ok := v.(map[string]any); ok {
+			dstTable, ok := dst[k].(map[string]any)
+			if !ok {
+				dstTable = make(map[string]any)
+				dst[k] = dstTable
+			}
+			mergeInto(dstTable, srcTable)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// ParseFile reads a TOML file and resolves its include directive. Included
+// files are merged in listed order, and the including file's own values
+// override all of them. Includes may nest; cycles are an error.
+func ParseFile(path string) (map[string]any, error) {
+	return parseFile(path, nil)
+}
+
+func parseFile(path string, stack []string) (map[string]any, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stack {
+		if p == abs {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ParseTOML(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	raw, ok := data[IncludeKey]
+	if !ok {
+		return data, nil
+	}
+	delete(data, IncludeKey)
+
+	var includes []string
+	switch v := raw.(type) {
+	case string:
+		includes = []string{v}
+	case []any:
+		for i, elem := range v {
+			s, ok := elem.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: include[%d]: expected string, got %T", path, i, elem)
+			}
+			includes = append(includes, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s: include: expected string or array, got %T", path, raw)
+	}
+
+	layers := make([]map[string]any, 0, len(includes)+1)
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(abs), inc)
+		}
+		m, err := parseFile(inc, stack)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, m)
+	}
+	layers = append(layers, data)
+	return Merge(layers...), nil
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTOML(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMergePrecedence(t *testing.T) {
+	base := map[string]any{
+		"name":   "base",
+		"tags":   []any{"a", "b"},
+		"server": map[string]any{"addr": ":8080", "workers": int64(4)},
+	}
+	override := map[string]any{
+		"tags":   []any{"c"},
+		"server": map[string]any{"addr": ":9090"},
+	}
+
+	m := Merge(base, override)
+	if m["name"] != "base" {
+		t.Errorf("name = %v, want base", m["name"])
+	}
+	if tags := m["tags"].([]any); len(tags) != 1 || tags[0] != "c" {
+		t.Errorf("arrays should be replaced, got %v", tags)
+	}
+	server := m["server"].(map[string]any)
+	if server["addr"] != ":9090" || server["workers"] != int64(4) {
+		t.Errorf("tables should merge key by key, got %v", server)
+	}
+
+	// Inputs are untouched.
+	if base["server"].(map[string]any)["addr"] != ":8080" {
+		t.Error("Merge modified its input")
+	}
+}
+
+func TestParseFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeTOML(t, dir, "base.toml", `
+name = "base"
+[server]
+addr = ":8080"
+workers = 4
+`)
+	writeTOML(t, dir, "prod.toml", `
+[server]
+workers = 16
+`)
+	path := writeTOML(t, dir, "app.toml", `
+include = ["base.toml", "prod.toml"]
+name = "app"
+`)
+
+	data, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if _, ok := data[IncludeKey]; ok {
+		t.Error("include key should be removed from result")
+	}
+	if data["name"] != "app" {
+		t.Errorf("including file should win, name = %v", data["name"])
+	}
+	server := data["server"].(map[string]any)
+	if server["addr"] != ":8080" || server["workers"] != int64(16) {
+		t.Errorf("server = %v", server)
+	}
+}
+
+func TestParseFileNestedInclude(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "shared"), 0o700)
+	writeTOML(t, dir, "shared/root.toml", `level = "root"`)
+	writeTOML(t, dir, "shared/mid.toml", `include = "root.toml"
+mid = true`)
+	path := writeTOML(t, dir, "app.toml", `include = ["shared/mid.toml"]`)
+
+	data, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if data["level"] != "root" || data["mid"] != true {
+		t.Errorf("nested include not resolved: %v", data)
+	}
+}
+
+func TestParseFileIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeTOML(t, dir, "a.toml", `include = ["b.toml"]`)
+	writeTOML(t, dir, "b.toml", `include = ["a.toml"]`)
+
+	_, err := ParseFile(filepath.Join(dir, "a.toml"))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected include cycle error, got %v", err)
+	}
+}
+
+func TestParseFileIncludeMissing(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTOML(t, dir, "app.toml", `include = ["nope.toml"]`)
+	if _, err := ParseFile(path); err == nil {
+		t.Error("expected error for missing include")
+	}
+}
+
+func TestLoadWithInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeTOML(t, dir, "base.toml", `port = 8080
+name = "base"`)
+	path := writeTOML(t, dir, "app.toml", `include = ["base.toml"]
+name = "app"`)
+
+	var cfg testConfig
+	if err := Load(path, "", &cfg); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Port != 8080 || cfg.Name != "app" {
+		t.Errorf("cfg = %+v", cfg)
+	}
+}
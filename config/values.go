@@ -0,0 +1,337 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config wraps a parsed TOML map with dotted-key lookup, a defaults
+// registry, and typed getters. Getters never fail: a missing key yields
+// the registered default (or the caller's fallback), and a value of the
+// wrong type is recorded so Err can report every problem at once.
+//
+//	cfg, err := config.ReadFile("matchspec.toml")
+//	cfg.SetDefault("server.addr", ":8080")
+//	addr := cfg.GetString("server.addr", "")
+//	timeout := cfg.GetDuration("server.timeout", 30*time.Second)
+//	if err := cfg.Require("infer.url"); err != nil { ... }
+//	if err := cfg.Err(); err != nil { ... }
+type Config struct {
+	data map[string]any
+
+	mu       sync.Mutex
+	defaults map[string]any
+	errs     map[string]error // key → most recent type error
+}
+
+// New wraps an already-parsed TOML map.
+func New(data map[string]any) *Config {
+	if data == nil {
+		data = make(map[string]any)
+	}
+	return &Config{
+		data:     data,
+		defaults: make(map[string]any),
+		errs:     make(map[string]error),
+	}
+}
+
+// ReadFile parses the TOML file at path, resolving includes, into a Config.
+func ReadFile(path string) (*Config, error) {
+	data, err := ParseFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return New(data), nil
+}
+
+// Map returns the underlying parsed map.
+func (c *Config) Map() map[string]any {
+	return c.data
+}
+
+// SetDefault registers a default for a dotted key. Defaults apply when
+// the key is absent from the parsed data, and take precedence over the
+// fallback passed to a getter.
+func (c *Config) SetDefault(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaults[key] = value
+}
+
+// Get looks up a dotted key ("server.addr"), falling back to the
+// registered default. It reports whether a value was found.
+func (c *Config) Get(key string) (any, bool) {
+	if v, ok := lookup(c.data, key); ok {
+		return v, true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.defaults[key]
+	return v, ok
+}
+
+// Has reports whether the key is present in the parsed data or defaults.
+func (c *Config) Has(key string) bool {
+	_, ok := c.Get(key)
+	return ok
+}
+
+// GetString returns a string value, or def if the key is absent.
+func (c *Config) GetString(key, def string) string {
+	v, ok := c.Get(key)
+	if !ok {
+		return def
+	}
+	s, ok := v.(string)
+	if !ok {
+		c.typeError(key, "string", v)
+		return def
+	}
+	return s
+}
+
+// GetInt returns an integer value, or def if the key is absent.
+// Whole-number floats are accepted.
+func (c *Config) GetInt(key string, def int) int {
+	v, ok := c.Get(key)
+	if !ok {
+		return def
+	}
+	switch n := v.(type) {
+	case int64:
+		return int(n)
+	case int:
+		return n
+	case float64:
+		if n == float64(int64(n)) {
+			return int(n)
+		}
+	}
+	c.typeError(key, "integer", v)
+	return def
+}
+
+// GetFloat returns a float value, or def if the key is absent.
+func (c *Config) GetFloat(key string, def float64) float64 {
+	v, ok := c.Get(key)
+	if !ok {
+		return def
+	}
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	}
+	c.typeError(key, "float", v)
+	return def
+}
+
+// GetBool returns a boolean value, or def if the key is absent.
+func (c *Config) GetBool(key string, def bool) bool {
+	v, ok := c.Get(key)
+	if !ok {
+		return def
+	}
+	b, ok := v.(bool)
+	if !ok {
+		c.typeError(key, "bool", v)
+		return def
+	}
+	return b
+}
+
+// GetDuration returns a duration, or def if the key is absent. Strings
+// are parsed with time.ParseDuration ("30s", "5m"); integers are seconds.
+func (c *Config) GetDuration(key string, def time.Duration) time.Duration {
+	v, ok := c.Get(key)
+	if !ok {
+		return def
+	}
+	switch d := v.(type) {
+	case time.Duration:
+		return d
+	case string:
+		parsed, err := time.ParseDuration(d)
+		if err != nil {
+			c.recordError(key, fmt.Errorf("config: %s: invalid duration %q", key, d))
+			return def
+		}
+		return parsed
+	case int64:
+		return time.Duration(d) * time.Second
+	case int:
+		return time.Duration(d) * time.Second
+	}
+	c.typeError(key, "duration", v)
+	return def
+}
+
+// GetStringSlice returns an array of strings, or def if the key is absent.
+func (c *Config) GetStringSlice(key string, def []string) []string {
+	v, ok := c.Get(key)
+	if !ok {
+		return def
+	}
+	switch arr := v.(type) {
+	case []string:
+		return arr
+	case []any:
+		out := make([]string, 0, len(arr))
+		for i, elem := range arr {
+			s, ok := elem.(string)
+			if !ok {
+				c.typeError(fmt.Sprintf("%s[%d]", key, i), "string", elem)
+				return def
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+	c.typeError(key, "array of strings", v)
+	return def
+}
+
+// Require checks that every key is present (in the data or defaults) and
+// returns a single error listing all missing keys, or nil.
+func (c *Config) Require(keys ...string) error {
+	var missing []string
+	for _, k := range keys {
+		if !c.Has(k) {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("config: missing required keys: %s", strings.Join(missing, ", "))
+}
+
+// Err returns an aggregated error describing every type mismatch seen
+// by the getters so far, or nil.
+func (c *Config) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.errs) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(c.errs))
+	for k := range c.errs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	errs := make([]error, 0, len(keys))
+	for _, k := range keys {
+		errs = append(errs, c.errs[k])
+	}
+	return errors.Join(errs...)
+}
+
+// Flatten returns the effective configuration (data merged over
+// defaults) keyed by dotted key. Values whose full dotted key or final
+// segment matches one of redactKeys are replaced by "[REDACTED]".
+func (c *Config) Flatten(redactKeys ...string) map[string]any {
+	flat := make(map[string]any)
+	c.mu.Lock()
+	for k, v := range c.defaults {
+		flat[k] = v
+	}
+	c.mu.Unlock()
+	flatten("", c.data, flat)
+
+	redact := make(map[string]bool, len(redactKeys))
+	for _, k := range redactKeys {
+		redact[k] = true
+	}
+	for k := range flat {
+		leaf := k
+		if i := strings.LastIndexByte(k, '.'); i >= 0 {
+			leaf = k[i+1:]
+		}
+		if redact[k] || redact[leaf] {
+			flat[k] = "[REDACTED]"
+		}
+	}
+	return flat
+}
+
+// Dump renders the effective configuration as sorted "key = value" lines
+// for startup logging, redacting as Flatten does.
+func (c *Config) Dump(redactKeys ...string) string {
+	flat := c.Flatten(redactKeys...)
+
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %v\n", k, flat[k])
+	}
+	return b.String()
+}
+
+// Checksum returns "sha256:" and the hex digest of Dump(redactKeys...),
+// so nodes can be compared for config drift without revealing values.
+func (c *Config) Checksum(redactKeys ...string) string {
+	sum := sha256.Sum256([]byte(c.Dump(redactKeys...)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (c *Config) typeError(key, want string, got any) {
+	c.recordError(key, fmt.Errorf("config: %s: expected %s, got %T", key, want, got))
+}
+
+func (c *Config) recordError(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs[key] = err
+}
+
+// lookup walks a dotted key through nested tables.
+func lookup(data map[string]any, key string) (any, bool) {
+	parts := strings.Split(key, ".")
+	cur := data
+	for i, p := range parts {
+		v, ok := cur[p]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return v, true
+		}
+		cur, ok = v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// flatten writes every leaf of a nested map into out under its dotted key.
+func flatten(prefix string, data map[string]any, out map[string]any) {
+	for k, v := range data {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if m, ok := v.(map[string]any); ok {
+			flatten(key, m, out)
+			continue
+		}
+		out[key] = v
+	}
+}
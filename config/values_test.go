@@ -0,0 +1,204 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseConfig(t *testing.T, src string) *Config {
+	t.Helper()
+	data, err := ParseTOML(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("ParseTOML: %v", err)
+	}
+	return New(data)
+}
+
+func TestConfigTypedGetters(t *testing.T) {
+	cfg := parseConfig(t, `
+name = "matchspec"
+workers = 8
+rate = 0.5
+debug = true
+
+[server]
+addr = ":9090"
+timeout = "45s"
+idle = 60
+tags = ["a", "b"]
+`)
+
+	if got := cfg.GetString("name", ""); got != "matchspec" {
+		t.Errorf("name = %q", got)
+	}
+	if got := cfg.GetInt("workers", 1); got != 8 {
+		t.Errorf("workers = %d", got)
+	}
+	if got := cfg.GetFloat("rate", 0); got != 0.5 {
+		t.Errorf("rate = %g", got)
+	}
+	if got := cfg.GetBool("debug", false); !got {
+		t.Error("debug = false")
+	}
+	if got := cfg.GetString("server.addr", ""); got != ":9090" {
+		t.Errorf("server.addr = %q", got)
+	}
+	if got := cfg.GetDuration("server.timeout", 0); got != 45*time.Second {
+		t.Errorf("server.timeout = %v", got)
+	}
+	if got := cfg.GetDuration("server.idle", 0); got != 60*time.Second {
+		t.Errorf("server.idle = %v", got)
+	}
+	if got := cfg.GetStringSlice("server.tags", nil); len(got) != 2 || got[1] != "b" {
+		t.Errorf("server.tags = %v", got)
+	}
+	if err := cfg.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := parseConfig(t, `[server]
+addr = ":9090"`)
+	cfg.SetDefault("server.addr", ":8080")
+	cfg.SetDefault("server.workers", int64(4))
+
+	if got := cfg.GetString("server.addr", ""); got != ":9090" {
+		t.Errorf("file value should beat default, got %q", got)
+	}
+	if got := cfg.GetInt("server.workers", 1); got != 4 {
+		t.Errorf("default should beat fallback, got %d", got)
+	}
+	if got := cfg.GetInt("server.missing", 7); got != 7 {
+		t.Errorf("fallback = %d, want 7", got)
+	}
+}
+
+func TestConfigTypeErrorsAggregate(t *testing.T) {
+	cfg := parseConfig(t, `
+port = "not-a-number"
+timeout = "forever"
+tags = ["ok", 3]
+`)
+
+	if got := cfg.GetInt("port", 80); got != 80 {
+		t.Errorf("port = %d, want fallback 80", got)
+	}
+	cfg.GetDuration("timeout", time.Second)
+	cfg.GetStringSlice("tags", nil)
+
+	err := cfg.Err()
+	if err == nil {
+		t.Fatal("expected aggregated error")
+	}
+	for _, want := range []string{"port", "timeout", "tags[1]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error should mention %s: %v", want, err)
+		}
+	}
+}
+
+func TestConfigRequire(t *testing.T) {
+	cfg := parseConfig(t, `name = "x"`)
+	cfg.SetDefault("server.addr", ":8080")
+
+	if err := cfg.Require("name", "server.addr"); err != nil {
+		t.Errorf("Require: %v", err)
+	}
+	err := cfg.Require("name", "infer.url", "db.dsn")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "infer.url, db.dsn") {
+		t.Errorf("error should list all missing keys: %v", err)
+	}
+}
+
+func TestConfigDump(t *testing.T) {
+	cfg := parseConfig(t, `
+name = "infermux"
+
+[provider]
+api_key = "sk-secret"
+url = "https://api.example.com"
+`)
+	cfg.SetDefault("workers", int64(4))
+
+	out := cfg.Dump("api_key")
+	if strings.Contains(out, "sk-secret") {
+		t.Errorf("secret leaked in dump:\n%s", out)
+	}
+	for _, want := range []string{
+		"name = infermux\n",
+		"provider.api_key = [REDACTED]\n",
+		"provider.url = https://api.example.com\n",
+		"workers = 4\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestConfigFlatten(t *testing.T) {
+	cfg := parseConfig(t, `
+[provider]
+api_key = "sk-secret"
+url = "https://api.example.com"
+`)
+	cfg.SetDefault("workers", int64(4))
+
+	flat := cfg.Flatten("provider.api_key")
+	want := map[string]any{
+		"provider.api_key": "[REDACTED]",
+		"provider.url":     "https://api.example.com",
+		"workers":          int64(4),
+	}
+	if len(flat) != len(want) {
+		t.Errorf("Flatten = %v", flat)
+	}
+	for k, v := range want {
+		if flat[k] != v {
+			t.Errorf("%s = %v, want %v", k, flat[k], v)
+		}
+	}
+}
+
+func TestConfigChecksum(t *testing.T) {
+	a := parseConfig(t, "x = 1\ny = \"b\"\n")
+	b := parseConfig(t, "y = \"b\"\nx = 1\n")
+	c := parseConfig(t, "x = 2\ny = \"b\"\n")
+
+	if a.Checksum() != b.Checksum() {
+		t.Error("checksum depends on key order")
+	}
+	if a.Checksum() == c.Checksum() {
+		t.Error("checksum unchanged by a value change")
+	}
+	if !strings.HasPrefix(a.Checksum(), "sha256:") {
+		t.Errorf("checksum = %q", a.Checksum())
+	}
+	if a.Checksum("x") != c.Checksum("x") {
+		t.Error("redacted key changed the checksum")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.toml")
+	os.WriteFile(path, []byte("name = \"app\"\n"), 0o600)
+
+	cfg, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if cfg.GetString("name", "") != "app" {
+		t.Errorf("name = %q", cfg.GetString("name", ""))
+	}
+
+	if _, err := ReadFile(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
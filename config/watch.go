@@ -0,0 +1,59 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Watch polls the file at path every interval and calls fn with the
+// re-read Config each time its contents change. fn is also called once
+// at start with the initial contents. A read or parse error is passed to
+// fn with a nil Config; callers should keep their previous config. Only
+// path itself is watched, not files it includes. Watch blocks until ctx
+// is done.
+//
+//	go config.Watch(ctx, "pricing.toml", 5*time.Second, func(cfg *config.Config, err error) {
+//	    if err != nil {
+//	        log.Printf("pricing reload: %v", err)
+//	        return
+//	    }
+//	    apply(cfg)
+//	})
+func Watch(ctx context.Context, path string, interval time.Duration, fn func(*Config, error)) error {
+	var last []byte
+	var missing bool
+	check := func() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// Report a read failure once, not on every tick.
+			if !missing {
+				fn(nil, fmt.Errorf("config: %w", err))
+			}
+			missing, last = true, nil
+			return
+		}
+		missing = false
+		sum := sha256.Sum256(data)
+		if last != nil && bytes.Equal(last, sum[:]) {
+			return
+		}
+		last = sum[:]
+		fn(ReadFile(path))
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			check()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.toml")
+	os.WriteFile(path, []byte("level = 1\n"), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type event struct {
+		level int
+		err   error
+	}
+	events := make(chan event, 10)
+	go Watch(ctx, path, 10*time.Millisecond, func(cfg *Config, err error) {
+		if err != nil {
+			events <- event{err: err}
+			return
+		}
+		events <- event{level: cfg.GetInt("level", 0)}
+	})
+
+	next := func() event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("no watch event")
+			return event{}
+		}
+	}
+
+	if e := next(); e.level != 1 {
+		t.Fatalf("initial = %+v", e)
+	}
+
+	os.WriteFile(path, []byte("level = 2\n"), 0o644)
+	if e := next(); e.level != 2 {
+		t.Fatalf("after change = %+v", e)
+	}
+
+	os.WriteFile(path, []byte("level = [broken\n"), 0o644)
+	if e := next(); e.err == nil {
+		t.Fatalf("expected parse error, got %+v", e)
+	}
+
+	os.WriteFile(path, []byte("level = 3\n"), 0o644)
+	if e := next(); e.level != 3 {
+		t.Fatalf("after fix = %+v", e)
+	}
+
+	// Unchanged content does not re-fire.
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
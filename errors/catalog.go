@@ -0,0 +1,62 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// CodeInfo documents one error code for clients: what it means, whether
+// retrying can help, and how it maps to HTTP statuses and exit codes.
+type CodeInfo struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Retryable   bool   `json:"retryable"`
+	HTTPStatus  int    `json:"http_status"`
+	ExitCode    int    `json:"exit_code"`
+}
+
+// codeDescriptions lists every standard code in declaration order.
+var codeDescriptions = []struct {
+	code, description string
+}{
+	{CodeInternal, "Unexpected failure inside the service."},
+	{CodeTimeout, "The operation did not finish before its deadline."},
+	{CodeCancelled, "The caller cancelled the operation."},
+	{CodeTransport, "Sending or receiving a message failed."},
+	{CodeProtocol, "A message or envelope was malformed."},
+	{CodeValidation, "The input or configuration is invalid."},
+	{CodeNotFound, "The requested resource does not exist."},
+	{CodeUnavailable, "The service or a dependency is down or unreachable."},
+	{CodeRateLimit, "Too many requests; slow down and retry later."},
+	{CodeAuth, "Authentication or authorization failed."},
+	{CodeConflict, "The request conflicts with current state or version."},
+}
+
+// Catalog returns documentation for every standard error code, in
+// declaration order. Retryable reflects the default for the code;
+// individual errors may override it with Retriable or Permanent.
+func Catalog() []CodeInfo {
+	out := make([]CodeInfo, len(codeDescriptions))
+	for i, d := range codeDescriptions {
+		out[i] = CodeInfo{
+			Code:        d.code,
+			Description: d.description,
+			Retryable:   retryableCodes[d.code],
+			HTTPStatus:  HTTPStatus(d.code),
+			ExitCode:    ExitCode(d.code),
+		}
+	}
+	return out
+}
+
+// CatalogHandler serves the catalog as JSON. Mount it at /errorsz:
+//
+//	srv.Handle("GET /errorsz", errors.CatalogHandler())
+func CatalogHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Codes []CodeInfo `json:"codes"`
+		}{Catalog()})
+	}
+}
@@ -0,0 +1,112 @@
+package errors
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// declaredCodes returns the values of the Code* constants in errors.go,
+// so a new code can't be left out of the tables below.
+func declaredCodes(t *testing.T) []string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || len(spec.Values) != 1 || !strings.HasPrefix(spec.Names[0].Name, "Code") {
+			return true
+		}
+		if lit, ok := spec.Values[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			code, _ := strconv.Unquote(lit.Value)
+			codes = append(codes, code)
+		}
+		return true
+	})
+	if len(codes) == 0 {
+		t.Fatal("no Code constants found in errors.go")
+	}
+	return codes
+}
+
+func TestCodeTablesCoverAllCodes(t *testing.T) {
+	codes := declaredCodes(t)
+
+	var described []string
+	for _, d := range codeDescriptions {
+		described = append(described, d.code)
+	}
+	if !slices.Equal(described, codes) {
+		t.Errorf("codeDescriptions = %v, want %v in declaration order", described, codes)
+	}
+
+	tables := map[string][]string{}
+	for code := range defaultUserMessages[DefaultLocale] {
+		tables["defaultUserMessages"] = append(tables["defaultUserMessages"], code)
+	}
+	for code := range retryableCodes {
+		tables["retryableCodes"] = append(tables["retryableCodes"], code)
+	}
+	want := slices.Sorted(slices.Values(codes))
+	for name, got := range tables {
+		if slices.Sort(got); !slices.Equal(got, want) {
+			t.Errorf("%s covers %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCatalogCoversAllCodes(t *testing.T) {
+	cat := Catalog()
+	byCode := make(map[string]CodeInfo, len(cat))
+	for _, info := range cat {
+		if info.Description == "" {
+			t.Errorf("%s has no description", info.Code)
+		}
+		byCode[info.Code] = info
+	}
+
+	for _, code := range []string{
+		CodeInternal, CodeTimeout, CodeCancelled, CodeTransport,
+		CodeProtocol, CodeValidation, CodeNotFound, CodeUnavailable,
+		CodeRateLimit, CodeAuth, CodeConflict,
+	} {
+		info, ok := byCode[code]
+		if !ok {
+			t.Errorf("catalog missing %s", code)
+			continue
+		}
+		if info.HTTPStatus != HTTPStatus(code) || info.ExitCode != ExitCode(code) {
+			t.Errorf("%s mappings = %+v", code, info)
+		}
+		if info.Retryable != IsRetryable(New(code, "x")) {
+			t.Errorf("%s retryable = %v", code, info.Retryable)
+		}
+	}
+}
+
+func TestCatalogHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	CatalogHandler()(w, httptest.NewRequest("GET", "/errorsz", nil))
+
+	var body struct {
+		Codes []CodeInfo `json:"codes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Codes) != len(Catalog()) {
+		t.Errorf("codes = %d, want %d", len(body.Codes), len(Catalog()))
+	}
+	if body.Codes[0].Code != CodeInternal {
+		t.Errorf("first code = %s, want declaration order", body.Codes[0].Code)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"runtime/debug"
 )
 
 // Standard error codes used across all MIST tools.
@@ -28,10 +29,16 @@ const (
 // Error is a structured error that carries a code, message, causal chain,
 // and optional metadata. It implements the error and json.Marshaler interfaces.
 type Error struct {
-	Code    string            `json:"code"`
-	Message string            `json:"message"`
-	Cause   error             `json:"-"`
-	Meta    map[string]string `json:"meta,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// UserMessage is safe to show to end users. Message is operator
+	// detail and may include internal names, paths, or upstream errors.
+	UserMessage string            `json:"user_message,omitempty"`
+	Cause       error             `json:"-"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	// Stack is the goroutine stack captured by Recovered. It is kept out
+	// of JSON so API responses never leak it.
+	Stack string `json:"-"`
 	// retryOverride: nil = use default for code, ptr to true/false = explicit.
 	retryOverride *bool
 }
@@ -63,6 +70,54 @@ func Wrapf(code string, cause error, format string, args ...any) *Error {
 	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Cause: cause}
 }
 
+// Recovered converts a value recovered from a panic into an internal,
+// non-retryable error carrying the current goroutine's stack trace. Call
+// it directly from the deferred function that calls recover. If v is an
+// error it becomes the cause.
+//
+//	defer func() {
+//	    if v := recover(); v != nil {
+//	        err = errors.Recovered(v)
+//	    }
+//	}()
+func Recovered(v any) *Error {
+	e := &Error{
+		Code:    CodeInternal,
+		Message: fmt.Sprintf("panic: %v", v),
+		Stack:   string(debug.Stack()),
+	}
+	if cause, ok := v.(error); ok {
+		e.Cause = cause
+	}
+	return e.Permanent()
+}
+
+// IsPanic reports whether err's chain contains an error produced by
+// Recovered.
+func IsPanic(err error) bool {
+	var e *Error
+	for As(err, &e) {
+		if e.Stack != "" {
+			return true
+		}
+		err = e.Cause
+	}
+	return false
+}
+
+// WithUserMessage returns a copy of the error with a user-facing message.
+func (e *Error) WithUserMessage(msg string) *Error {
+	cp := *e
+	cp.UserMessage = msg
+	return &cp
+}
+
+// NewUser creates an error with both an operator message and a message
+// safe to show to end users.
+func NewUser(code, message, userMessage string) *Error {
+	return &Error{Code: code, Message: message, UserMessage: userMessage}
+}
+
 // WithMeta returns a copy of the error with additional metadata.
 func (e *Error) WithMeta(key, value string) *Error {
 	cp := *e
@@ -99,13 +154,20 @@ func (e *Error) MarshalJSON() ([]byte, error) {
 	return json.Marshal(aux)
 }
 
-// retryableCodes are error codes that indicate a transient failure
-// which may succeed on retry.
+// retryableCodes records, for every standard code, whether it
+// indicates a transient failure which may succeed on retry.
 var retryableCodes = map[string]bool{
+	CodeInternal:    false,
 	CodeTimeout:     true,
+	CodeCancelled:   false,
 	CodeTransport:   true,
+	CodeProtocol:    false,
+	CodeValidation:  false,
+	CodeNotFound:    false,
 	CodeUnavailable: true,
 	CodeRateLimit:   true,
+	CodeAuth:        false,
+	CodeConflict:    false,
 }
 
 // IsRetryable reports whether an error is worth retrying.
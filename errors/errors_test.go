@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -311,3 +312,48 @@ func TestAllCodesAreUnique(t *testing.T) {
 		seen[c] = true
 	}
 }
+
+func TestRecovered(t *testing.T) {
+	var err error
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				err = Recovered(v)
+			}
+		}()
+		panic("boom")
+	}()
+
+	var e *Error
+	if !As(err, &e) {
+		t.Fatalf("expected *Error, got %T", err)
+	}
+	if e.Code != CodeInternal || e.Message != "panic: boom" {
+		t.Errorf("error = %v", e)
+	}
+	if !strings.Contains(e.Stack, "TestRecovered") {
+		t.Errorf("stack should include the panicking frame:\n%s", e.Stack)
+	}
+	if IsRetryable(err) {
+		t.Error("panics should not be retryable")
+	}
+	if !IsPanic(Wrap(CodeInternal, err, "task 3")) {
+		t.Error("IsPanic should see through wrapping")
+	}
+	if IsPanic(New(CodeInternal, "plain")) {
+		t.Error("IsPanic on plain error")
+	}
+
+	data, _ := json.Marshal(e)
+	if strings.Contains(string(data), "TestRecovered") {
+		t.Errorf("stack leaked into JSON: %s", data)
+	}
+}
+
+func TestRecoveredErrorCause(t *testing.T) {
+	cause := New(CodeTimeout, "deadline")
+	err := Recovered(cause)
+	if !Is(err, cause) {
+		t.Error("panic value error should be the cause")
+	}
+}
@@ -0,0 +1,140 @@
+package errors
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/greynewell/mist-go/metadata"
+)
+
+var (
+	localeMu sync.RWMutex
+
+	// defaultUserMessages are the user-facing messages used when an error
+	// has no UserMessage of its own, keyed by language then code.
+	defaultUserMessages = map[string]map[string]string{
+		"en": {
+			CodeInternal:    "An internal error occurred.",
+			CodeTimeout:     "The request timed out.",
+			CodeCancelled:   "The request was cancelled.",
+			CodeTransport:   "A downstream service could not be reached.",
+			CodeProtocol:    "The request could not be understood.",
+			CodeValidation:  "The request is invalid.",
+			CodeNotFound:    "The requested resource was not found.",
+			CodeUnavailable: "The service is temporarily unavailable.",
+			CodeRateLimit:   "Too many requests. Please retry later.",
+			CodeAuth:        "Authentication is required or was rejected.",
+			CodeConflict:    "The request conflicts with the current state.",
+		},
+	}
+)
+
+// DefaultLocale is the language used when no registered locale matches.
+const DefaultLocale = "en"
+
+// RegisterLocale adds or replaces the default user-facing messages for a
+// language (e.g. "de", "pt-BR"), keyed by error code. Codes missing from
+// messages fall back to DefaultLocale.
+func RegisterLocale(lang string, messages map[string]string) {
+	localeMu.Lock()
+	defer localeMu.Unlock()
+	cp := make(map[string]string, len(messages))
+	for code, msg := range messages {
+		cp[code] = msg
+	}
+	defaultUserMessages[strings.ToLower(lang)] = cp
+}
+
+// UserMessage returns the message for err that is safe to show to end
+// users in the given language: the error's own UserMessage if set,
+// otherwise the locale's default for its code. It never returns operator
+// detail.
+func UserMessage(err error, lang string) string {
+	var e *Error
+	if As(err, &e) && e.UserMessage != "" {
+		return e.UserMessage
+	}
+	code := Code(err)
+
+	localeMu.RLock()
+	defer localeMu.RUnlock()
+	for _, l := range []string{strings.ToLower(lang), baseLang(lang), DefaultLocale} {
+		if msg, ok := defaultUserMessages[l][code]; ok {
+			return msg
+		}
+	}
+	return defaultUserMessages[DefaultLocale][CodeInternal]
+}
+
+// WriteHTTP writes err as a JSON error response with the status for its
+// code. Only the code and user-safe message reach the client; the full
+// error, cause chain, and metadata are logged via slog at error level
+// for 5xx statuses and warn level otherwise. The language is chosen from
+// the request's Accept-Language header. A request ID in the request's
+// metadata (see server.RequestID) is logged and returned so clients can
+// quote it in support requests.
+//
+//	{"error": {"code": "not_found", "message": "The requested resource was not found.", "request_id": "4f1c..."}}
+func WriteHTTP(w http.ResponseWriter, r *http.Request, err error) {
+	code := Code(err)
+	status := HTTPStatus(code)
+
+	level := slog.LevelWarn
+	if status >= 500 {
+		level = slog.LevelError
+	}
+	attrs := []any{"code", code, "status", status, "error", err.Error()}
+	var e *Error
+	if As(err, &e) {
+		for k, v := range e.Meta {
+			attrs = append(attrs, "meta."+k, v)
+		}
+		if e.Stack != "" {
+			attrs = append(attrs, "stack", e.Stack)
+		}
+	}
+	ctx := r.Context()
+	attrs = append(attrs, "method", r.Method, "path", r.URL.Path)
+	requestID := metadata.Get(ctx, metadata.KeyRequestID)
+	if requestID != "" {
+		attrs = append(attrs, "request_id", requestID)
+	}
+	slog.Default().Log(ctx, level, "request failed", attrs...)
+
+	body := struct {
+		Error userError `json:"error"`
+	}{userError{
+		Code:      code,
+		Message:   UserMessage(err, acceptLanguage(r)),
+		RequestID: requestID,
+	}}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+type userError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// acceptLanguage returns the first language tag in the request's
+// Accept-Language header, ignoring quality weights.
+func acceptLanguage(r *http.Request) string {
+	header := r.Header.Get("Accept-Language")
+	if header == "" {
+		return DefaultLocale
+	}
+	tag, _, _ := strings.Cut(header, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	return strings.TrimSpace(tag)
+}
+
+func baseLang(lang string) string {
+	base, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	return base
+}
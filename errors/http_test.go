@@ -0,0 +1,108 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestWriteHTTPHidesOperatorDetail(t *testing.T) {
+	logs := captureLogs(t)
+
+	cause := fmt.Errorf("dial tcp 10.0.3.7:5432: connection refused")
+	err := Wrap(CodeUnavailable, cause, "postgres pool exhausted").WithMeta("db", "primary")
+
+	w := httptest.NewRecorder()
+	WriteHTTP(w, httptest.NewRequest("GET", "/v1/things", nil), err)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, secret := range []string{"10.0.3.7", "postgres", "primary"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("response leaked %q: %s", secret, body)
+		}
+	}
+
+	var resp struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error.Code != CodeUnavailable || resp.Error.Message != "The service is temporarily unavailable." {
+		t.Errorf("resp = %+v", resp)
+	}
+
+	for _, want := range []string{"10.0.3.7", "postgres pool exhausted", "meta.db", "/v1/things"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log missing %q: %s", want, logs)
+		}
+	}
+}
+
+func TestWriteHTTPUserMessage(t *testing.T) {
+	captureLogs(t)
+	err := NewUser(CodeValidation, "field max_tokens=-1 failed check >0", "max_tokens must be positive.")
+
+	w := httptest.NewRecorder()
+	WriteHTTP(w, httptest.NewRequest("POST", "/infer", nil), err)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "max_tokens must be positive.") || strings.Contains(w.Body.String(), ">0") {
+		t.Errorf("body = %s", w.Body)
+	}
+}
+
+func TestWriteHTTPPlainError(t *testing.T) {
+	captureLogs(t)
+	w := httptest.NewRecorder()
+	WriteHTTP(w, httptest.NewRequest("GET", "/", nil), fmt.Errorf("nil pointer in handler"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "nil pointer") {
+		t.Errorf("plain error leaked: %s", w.Body)
+	}
+}
+
+func TestUserMessageLocale(t *testing.T) {
+	RegisterLocale("de", map[string]string{CodeNotFound: "Nicht gefunden."})
+
+	err := New(CodeNotFound, "no row for id=42")
+	if got := UserMessage(err, "de-AT"); got != "Nicht gefunden." {
+		t.Errorf("de-AT = %q", got)
+	}
+	if got := UserMessage(New(CodeTimeout, "x"), "de"); got != "The request timed out." {
+		t.Errorf("missing code should fall back to English, got %q", got)
+	}
+	if got := UserMessage(err.WithUserMessage("Custom."), "de"); got != "Custom." {
+		t.Errorf("explicit user message should win, got %q", got)
+	}
+
+	captureLogs(t)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Language", "de-DE;q=0.9, en;q=0.8")
+	w := httptest.NewRecorder()
+	WriteHTTP(w, r, err)
+	if !strings.Contains(w.Body.String(), "Nicht gefunden.") {
+		t.Errorf("body = %s", w.Body)
+	}
+}
@@ -0,0 +1,207 @@
+package infermux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/config"
+)
+
+// Target is a concrete provider and model that serves an alias.
+type Target struct {
+	Provider string `json:"provider,omitempty"` // empty resolves Model through the registry
+	Model    string `json:"model"`              // model sent to the provider
+}
+
+// ParseTarget parses "provider/model", or a bare model name resolved
+// through the registry. Only the first slash separates the provider, so
+// model names may contain slashes.
+func ParseTarget(s string) (Target, error) {
+	s = strings.TrimSpace(s)
+	provider, model, ok := strings.Cut(s, "/")
+	if !ok {
+		provider, model = "", s
+	}
+	if model == "" || (ok && provider == "") {
+		return Target{}, fmt.Errorf("infermux: invalid target %q, want provider/model", s)
+	}
+	return Target{Provider: provider, Model: model}, nil
+}
+
+// String formats t as ParseTarget accepts it.
+func (t Target) String() string {
+	if t.Provider == "" {
+		return t.Model
+	}
+	return t.Provider + "/" + t.Model
+}
+
+// Alias maps a logical model name such as "fast" or "smart" to the
+// target that serves it, so clients never hardcode provider-specific
+// model strings. If the target's provider is not registered, or the call
+// fails with a retryable error, the router tries each fallback in turn.
+type Alias struct {
+	Name      string   `json:"name"`
+	Target    Target   `json:"target"`
+	Fallbacks []Target `json:"fallbacks,omitempty"`
+}
+
+// targets returns the target followed by the fallbacks.
+func (a Alias) targets() []Target {
+	return append([]Target{a.Target}, a.Fallbacks...)
+}
+
+// Aliases is the routing table of model aliases. The whole table can be
+// replaced at runtime with Set, as WatchAliases does when its file
+// changes. It is safe for concurrent use.
+type Aliases struct {
+	mu     sync.RWMutex
+	byName map[string]Alias
+}
+
+// NewAliases validates and activates aliases.
+func NewAliases(aliases ...Alias) (*Aliases, error) {
+	a := &Aliases{}
+	if err := a.Set(aliases...); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Set replaces every alias. On a validation error the previous table is
+// kept. Each alias needs a unique name and a target model.
+func (a *Aliases) Set(aliases ...Alias) error {
+	byName := make(map[string]Alias, len(aliases))
+	for _, alias := range aliases {
+		if alias.Name == "" {
+			return fmt.Errorf("infermux: alias needs a name")
+		}
+		if _, dup := byName[alias.Name]; dup {
+			return fmt.Errorf("infermux: duplicate alias %q", alias.Name)
+		}
+		for _, t := range alias.targets() {
+			if t.Model == "" {
+				return fmt.Errorf("infermux: alias %s: target needs a model", alias.Name)
+			}
+		}
+		alias.Fallbacks = append([]Target(nil), alias.Fallbacks...)
+		byName[alias.Name] = alias
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.byName = byName
+	return nil
+}
+
+// Lookup returns the alias with the given name.
+func (a *Aliases) Lookup(name string) (Alias, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	alias, ok := a.byName[name]
+	return alias, ok
+}
+
+// List returns every alias, sorted by name.
+func (a *Aliases) List() []Alias {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]Alias, 0, len(a.byName))
+	for _, alias := range a.byName {
+		out = append(out, alias)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// LoadAliases reads aliases from a TOML file (see AliasesFromConfig).
+func LoadAliases(path string) ([]Alias, error) {
+	cfg, err := config.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("infermux: %w", err)
+	}
+	return AliasesFromConfig(cfg)
+}
+
+// AliasesFromConfig reads the [aliases.*] tables of cfg. The table name
+// is the alias; targets are written as ParseTarget accepts them:
+//
+//	[aliases.fast]
+//	target = "anthropic/claude-haiku"
+//	fallbacks = ["openai/gpt-4o-mini"]
+//
+//	[aliases.default]
+//	target = "anthropic/claude-sonnet"
+func AliasesFromConfig(cfg *config.Config) ([]Alias, error) {
+	raw, _ := cfg.Map()["aliases"].(map[string]any)
+	aliases := make([]Alias, 0, len(raw))
+	for name, v := range raw {
+		entry, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("infermux: aliases.%s: want a table", name)
+		}
+		s, _ := entry["target"].(string)
+		target, err := ParseTarget(s)
+		if err != nil {
+			return nil, fmt.Errorf("infermux: aliases.%s: target: %w", name, err)
+		}
+		alias := Alias{Name: name, Target: target}
+		fallbacks, _ := entry["fallbacks"].([]any)
+		for _, f := range fallbacks {
+			s, _ := f.(string)
+			t, err := ParseTarget(s)
+			if err != nil {
+				return nil, fmt.Errorf("infermux: aliases.%s: fallbacks: %w", name, err)
+			}
+			alias.Fallbacks = append(alias.Fallbacks, t)
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+// WatchAliases loads the aliases file at path and reloads it whenever
+// the file changes, until ctx is done. A file that fails to load on
+// reload is logged and the previous table stays in effect; a failure on
+// the initial load is returned.
+func WatchAliases(ctx context.Context, path string, interval time.Duration) (*Aliases, error) {
+	list, err := LoadAliases(path)
+	if err != nil {
+		return nil, err
+	}
+	a, err := NewAliases(list...)
+	if err != nil {
+		return nil, err
+	}
+	go config.Watch(ctx, path, interval, func(cfg *config.Config, err error) {
+		var list []Alias
+		if err == nil {
+			list, err = AliasesFromConfig(cfg)
+		}
+		if err == nil {
+			err = a.Set(list...)
+		}
+		if err != nil {
+			slog.Default().Warn("infermux: alias reload failed; keeping previous table", "path", path, "error", err)
+		}
+	})
+	return a, nil
+}
+
+// AliasInfo describes an alias for GET /models: its targets in order and
+// the one requests currently route to, the first whose provider is
+// registered.
+type AliasInfo struct {
+	Alias
+	Resolved *Target `json:"resolved,omitempty"`
+}
+
+// ModelsResponse is the JSON body for GET /models.
+type ModelsResponse struct {
+	Aliases []AliasInfo `json:"aliases"`
+	Models  []string    `json:"models"` // concrete models of registered providers, sorted
+}
@@ -0,0 +1,192 @@
+package infermux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+// failingProvider fails every request with err.
+type failingProvider struct {
+	*EchoProvider
+	err error
+}
+
+func (f *failingProvider) Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error) {
+	return protocol.InferResponse{}, f.err
+}
+
+func (f *failingProvider) InferStream(ctx context.Context, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error) {
+	return protocol.InferResponse{}, f.err
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Target
+	}{
+		{"anthropic/claude-haiku", Target{Provider: "anthropic", Model: "claude-haiku"}},
+		{"openrouter/meta-llama/llama-3", Target{Provider: "openrouter", Model: "meta-llama/llama-3"}},
+		{"echo-v1", Target{Model: "echo-v1"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+		if got.String() != tt.in {
+			t.Errorf("String() = %q, want %q", got.String(), tt.in)
+		}
+	}
+	for _, bad := range []string{"", "/model", "provider/"} {
+		if _, err := ParseTarget(bad); err == nil {
+			t.Errorf("ParseTarget(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAliasesValidate(t *testing.T) {
+	a, err := NewAliases(Alias{Name: "fast", Target: Target{Model: "echo-v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][]Alias{
+		{{Target: Target{Model: "m"}}},
+		{{Name: "x", Target: Target{Model: "m"}}, {Name: "x", Target: Target{Model: "m"}}},
+		{{Name: "x", Target: Target{Model: "m"}, Fallbacks: []Target{{Provider: "p"}}}},
+	} {
+		if err := a.Set(bad...); err == nil {
+			t.Errorf("Set(%+v) succeeded", bad)
+		}
+	}
+	if _, ok := a.Lookup("fast"); !ok {
+		t.Error("failed Set replaced the table")
+	}
+}
+
+func TestRouterAliasFallback(t *testing.T) {
+	reg := echoRegistry()
+	reg.Register(&failingProvider{
+		EchoProvider: NewEchoProvider("down", []string{"down-v1"}, 0),
+		err:          misterrors.New(misterrors.CodeUnavailable, "overloaded"),
+	})
+	reg.Register(&failingProvider{
+		EchoProvider: NewEchoProvider("strict", []string{"strict-v1"}, 0),
+		err:          misterrors.New(misterrors.CodeValidation, "bad request"),
+	})
+	aliases, err := NewAliases(
+		Alias{Name: "fast", Target: Target{Provider: "missing", Model: "m"}, Fallbacks: []Target{
+			{Provider: "down", Model: "down-v1"},
+			{Provider: "echo", Model: "echo-v2"},
+		}},
+		Alias{Name: "strict", Target: Target{Provider: "strict", Model: "strict-v1"}, Fallbacks: []Target{{Model: "echo-v1"}}},
+		Alias{Name: "gone", Target: Target{Provider: "missing", Model: "m"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""), WithAliases(aliases))
+	msgs := []protocol.ChatMessage{{Role: "user", Content: "hi"}}
+
+	resp, err := router.Infer(context.Background(), protocol.InferRequest{Model: "fast", Messages: msgs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Provider != "echo" || resp.Model != "echo-v2" {
+		t.Errorf("served by %s/%s, want echo/echo-v2", resp.Provider, resp.Model)
+	}
+
+	// Non-retryable failures do not fall back.
+	_, err = router.Infer(context.Background(), protocol.InferRequest{Model: "strict", Messages: msgs})
+	if misterrors.Code(err) != misterrors.CodeValidation {
+		t.Errorf("strict err = %v, want the provider's validation error", err)
+	}
+
+	if _, err := router.Infer(context.Background(), protocol.InferRequest{Model: "gone", Messages: msgs}); err == nil {
+		t.Error("alias with no registered target succeeded")
+	}
+
+	est, err := router.Estimate(context.Background(), protocol.InferRequest{Model: "fast", Messages: msgs})
+	if err != nil || est.Provider != "down" || est.Model != "down-v1" {
+		t.Errorf("Estimate = %+v, %v; want first registered target", est, err)
+	}
+}
+
+func TestHandlerModels(t *testing.T) {
+	reg := echoRegistry()
+	aliases, _ := NewAliases(
+		Alias{Name: "smart", Target: Target{Provider: "missing", Model: "m"}, Fallbacks: []Target{{Model: "echo-v2"}}},
+		Alias{Name: "gone", Target: Target{Provider: "missing", Model: "m"}},
+	)
+	h := NewHandler(NewRouter(reg, tokentrace.NewReporter("infermux", ""), WithAliases(aliases)), reg)
+
+	w := httptest.NewRecorder()
+	h.Models(w, httptest.NewRequest("GET", "/models", nil))
+	var resp ModelsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Aliases) != 2 || resp.Aliases[0].Name != "gone" || resp.Aliases[0].Resolved != nil {
+		t.Fatalf("aliases = %s", w.Body)
+	}
+	if r := resp.Aliases[1].Resolved; r == nil || *r != (Target{Provider: "echo", Model: "echo-v2"}) {
+		t.Errorf("smart resolved to %+v", r)
+	}
+	if len(resp.Models) != 2 || resp.Models[0] != "echo-v1" {
+		t.Errorf("models = %v", resp.Models)
+	}
+}
+
+func TestWatchAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.toml")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("[aliases.fast]\ntarget = \"echo/echo-v1\"\nfallbacks = [\"echo-v2\"]\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := WatchAliases(ctx, path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, ok := a.Lookup("fast")
+	if !ok || fast.Target != (Target{Provider: "echo", Model: "echo-v1"}) || len(fast.Fallbacks) != 1 || fast.Fallbacks[0].Model != "echo-v2" {
+		t.Fatalf("fast = %+v", fast)
+	}
+
+	// An invalid file keeps the previous table; a valid one replaces it.
+	write("[aliases.fast]\ntarget = \"\"\n")
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := a.Lookup("fast"); !ok {
+		t.Fatal("invalid reload dropped the table")
+	}
+	write("[aliases.smart]\ntarget = \"echo/echo-v2\"\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := a.Lookup("smart"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("aliases not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := a.Lookup("fast"); ok {
+		t.Error("removed alias still present")
+	}
+
+	if _, err := WatchAliases(ctx, filepath.Join(t.TempDir(), "none.toml"), time.Second); err == nil {
+		t.Error("missing file succeeded")
+	}
+}
@@ -0,0 +1,265 @@
+package infermux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metadata"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// ErrBudgetExceeded is returned for requests blocked by a hard budget.
+var ErrBudgetExceeded = misterrors.New(misterrors.CodeRateLimit, "infermux: cost budget exceeded").Permanent()
+
+// Budget periods.
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// Budget states reported by BudgetStatus.
+const (
+	BudgetOK       = "ok"
+	BudgetWarning  = "warning"
+	BudgetExceeded = "exceeded"
+)
+
+// Budget is a cost threshold for a provider, a tenant, or both. Empty
+// Provider or Tenant matches any. Spend resets at the start of each UTC
+// day or month.
+type Budget struct {
+	Name     string  `json:"name"`
+	Provider string  `json:"provider,omitempty"`
+	Tenant   string  `json:"tenant,omitempty"`
+	Period   string  `json:"period"` // PeriodDaily or PeriodMonthly
+	LimitUSD float64 `json:"limit_usd"`
+
+	// WarnAt is the fraction of LimitUSD at which a warning alert fires.
+	// Zero means 0.8.
+	WarnAt float64 `json:"warn_at,omitempty"`
+
+	// Block rejects further matching requests with ErrBudgetExceeded
+	// once the limit is reached, until the period resets.
+	Block bool `json:"block,omitempty"`
+}
+
+// BudgetStatus is the current state of a budget, served by /budgets.
+type BudgetStatus struct {
+	Budget
+	SpentUSD float64   `json:"spent_usd"`
+	State    string    `json:"state"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+type budgetState struct {
+	Budget
+	start  time.Time
+	spent  float64
+	warned bool
+	over   bool
+}
+
+func (b *budgetState) matches(provider, tenant string) bool {
+	return (b.Provider == "" || b.Provider == provider) && (b.Tenant == "" || b.Tenant == tenant)
+}
+
+// roll resets spend if now is past the current period.
+func (b *budgetState) roll(now time.Time) {
+	if start := periodStart(b.Period, now); !start.Equal(b.start) {
+		b.start, b.spent, b.warned, b.over = start, 0, false, false
+	}
+}
+
+func (b *budgetState) state() string {
+	switch {
+	case b.over:
+		return BudgetExceeded
+	case b.warned:
+		return BudgetWarning
+	}
+	return BudgetOK
+}
+
+func periodStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	if period == PeriodMonthly {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func periodEnd(period string, start time.Time) time.Time {
+	if period == PeriodMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// BudgetTracker accumulates request cost against budgets and raises
+// TraceAlert payloads when they are approached or exceeded. Each alert
+// fires at most once per budget period.
+//
+//	budgets := infermux.NewBudgetTracker(cfg.Budgets, infermux.WithBudgetAlert(func(a protocol.TraceAlert) {
+//	    msg, _ := protocol.New(protocol.SourceInferMux, protocol.TypeTraceAlert, a)
+//	    tokentraceTransport.Send(ctx, msg)
+//	}))
+//	router := infermux.NewRouter(reg, reporter, infermux.WithBudgets(budgets))
+type BudgetTracker struct {
+	mu          sync.Mutex
+	budgets     []*budgetState
+	onAlert     []func(protocol.TraceAlert)
+	onExhausted []func(Budget, float64) // budget and spend when a limit is reached
+	now         func() time.Time
+}
+
+// BudgetOption configures a BudgetTracker.
+type BudgetOption func(*BudgetTracker)
+
+// WithBudgetAlert adds a function called for each budget alert.
+func WithBudgetAlert(fn func(protocol.TraceAlert)) BudgetOption {
+	return func(t *BudgetTracker) { t.onAlert = append(t.onAlert, fn) }
+}
+
+// OnExhausted adds a function called with a budget and its spend each
+// time the budget reaches its limit.
+func (t *BudgetTracker) OnExhausted(fn func(Budget, float64)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onExhausted = append(t.onExhausted, fn)
+}
+
+// NewBudgetTracker creates a tracker for the given budgets.
+func NewBudgetTracker(budgets []Budget, opts ...BudgetOption) *BudgetTracker {
+	t := &BudgetTracker{now: time.Now}
+	for _, opt := range opts {
+		opt(t)
+	}
+	for i, b := range budgets {
+		if b.Period != PeriodMonthly {
+			b.Period = PeriodDaily
+		}
+		if b.WarnAt <= 0 || b.WarnAt >= 1 {
+			b.WarnAt = 0.8
+		}
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("budget-%d", i+1)
+		}
+		t.budgets = append(t.budgets, &budgetState{Budget: b})
+	}
+	return t
+}
+
+// Allow returns ErrBudgetExceeded if a blocking budget matching the
+// provider and tenant has been exceeded in the current period.
+func (t *BudgetTracker) Allow(provider, tenant string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, b := range t.budgets {
+		b.roll(now)
+		if b.Block && b.over && b.matches(provider, tenant) {
+			return misterrors.Wrapf(misterrors.CodeRateLimit, ErrBudgetExceeded,
+				"budget %s: spent $%.2f of $%.2f %s", b.Name, b.spent, b.LimitUSD, b.Period).
+				WithMeta("budget", b.Name)
+		}
+	}
+	return nil
+}
+
+// Record adds a request's cost to every matching budget and emits any
+// alerts that newly fire.
+func (t *BudgetTracker) Record(provider, tenant string, costUSD float64) {
+	var alerts []protocol.TraceAlert
+	var exhausted []BudgetStatus
+
+	t.mu.Lock()
+	now := t.now()
+	for _, b := range t.budgets {
+		b.roll(now)
+		if !b.matches(provider, tenant) {
+			continue
+		}
+		b.spent += costUSD
+		if !b.over && b.spent >= b.LimitUSD {
+			b.over, b.warned = true, true
+			alerts = append(alerts, b.alert("critical", b.LimitUSD))
+			exhausted = append(exhausted, BudgetStatus{Budget: b.Budget, SpentUSD: b.spent})
+		} else if !b.warned && b.spent >= b.WarnAt*b.LimitUSD {
+			b.warned = true
+			alerts = append(alerts, b.alert("warning", b.WarnAt*b.LimitUSD))
+		}
+	}
+	onAlert, onExhausted := t.onAlert, t.onExhausted
+	t.mu.Unlock()
+
+	for _, a := range alerts {
+		for _, fn := range onAlert {
+			fn(a)
+		}
+	}
+	for _, b := range exhausted {
+		for _, fn := range onExhausted {
+			fn(b.Budget, b.SpentUSD)
+		}
+	}
+}
+
+func (b *budgetState) alert(level string, threshold float64) protocol.TraceAlert {
+	scope := "all requests"
+	switch {
+	case b.Provider != "" && b.Tenant != "":
+		scope = fmt.Sprintf("provider %s, tenant %s", b.Provider, b.Tenant)
+	case b.Provider != "":
+		scope = "provider " + b.Provider
+	case b.Tenant != "":
+		scope = "tenant " + b.Tenant
+	}
+	return protocol.TraceAlert{
+		Level:     level,
+		Metric:    "cost_" + b.Period,
+		Value:     b.spent,
+		Threshold: threshold,
+		Message: fmt.Sprintf("budget %s (%s): spent $%.2f of $%.2f %s limit",
+			b.Name, scope, b.spent, b.LimitUSD, b.Period),
+	}
+}
+
+// Status returns the state of every budget in configuration order.
+func (t *BudgetTracker) Status() []BudgetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	out := make([]BudgetStatus, 0, len(t.budgets))
+	for _, b := range t.budgets {
+		b.roll(now)
+		out = append(out, BudgetStatus{
+			Budget:   b.Budget,
+			SpentUSD: b.spent,
+			State:    b.state(),
+			ResetsAt: periodEnd(b.Period, b.start),
+		})
+	}
+	return out
+}
+
+// Handler serves GET /budgets — the status of every budget.
+func (t *BudgetTracker) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Status())
+	}
+}
+
+// tenantOf returns the tenant for a request: the context metadata, or
+// the request's own Meta when called without propagated metadata.
+func tenantOf(ctx context.Context, req protocol.InferRequest) string {
+	if tenant := metadata.Get(ctx, metadata.KeyTenant); tenant != "" {
+		return tenant
+	}
+	return req.Meta[metadata.KeyTenant]
+}
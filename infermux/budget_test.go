@@ -0,0 +1,148 @@
+package infermux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metadata"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+func TestBudgetTrackerAlerts(t *testing.T) {
+	var alerts []protocol.TraceAlert
+	bt := NewBudgetTracker([]Budget{
+		{Name: "openai-daily", Provider: "openai", LimitUSD: 10},
+		{Name: "acme-monthly", Tenant: "acme", Period: PeriodMonthly, LimitUSD: 100, Block: true},
+	}, WithBudgetAlert(func(a protocol.TraceAlert) { alerts = append(alerts, a) }))
+
+	bt.Record("openai", "other", 5)
+	bt.Record("anthropic", "other", 50) // matches neither
+	if len(alerts) != 0 {
+		t.Fatalf("early alerts: %+v", alerts)
+	}
+
+	bt.Record("openai", "other", 3.5) // 8.5 ≥ 80% of 10
+	bt.Record("openai", "other", 0.1) // no repeat warning
+	if len(alerts) != 1 || alerts[0].Level != "warning" || alerts[0].Metric != "cost_daily" {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+
+	bt.Record("openai", "other", 2)
+	if len(alerts) != 2 || alerts[1].Level != "critical" || alerts[1].Threshold != 10 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+
+	// Non-blocking budgets only alert.
+	if err := bt.Allow("openai", "other"); err != nil {
+		t.Errorf("Allow on non-blocking budget: %v", err)
+	}
+
+	bt.Record("anthropic", "acme", 120)
+	if err := bt.Allow("anthropic", "acme"); !misterrors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Allow err = %v, want ErrBudgetExceeded", err)
+	}
+	if err := bt.Allow("anthropic", "globex"); err != nil {
+		t.Errorf("other tenant blocked: %v", err)
+	}
+
+	st := bt.Status()
+	if st[0].State != BudgetExceeded || st[0].SpentUSD != 10.6 || st[1].State != BudgetExceeded {
+		t.Errorf("status = %+v", st)
+	}
+}
+
+func TestBudgetPeriodReset(t *testing.T) {
+	now := time.Date(2026, 3, 14, 23, 0, 0, 0, time.UTC)
+	bt := NewBudgetTracker([]Budget{{LimitUSD: 1, Block: true}})
+	bt.now = func() time.Time { return now }
+
+	bt.Record("p", "", 2)
+	if bt.Allow("p", "") == nil {
+		t.Fatal("expected block")
+	}
+	if st := bt.Status()[0]; !st.ResetsAt.Equal(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("resets at %v", st.ResetsAt)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := bt.Allow("p", ""); err != nil {
+		t.Errorf("still blocked after reset: %v", err)
+	}
+	if st := bt.Status()[0]; st.SpentUSD != 0 || st.State != BudgetOK {
+		t.Errorf("status after reset = %+v", st)
+	}
+}
+
+func TestRouterBudgets(t *testing.T) {
+	reg := echoRegistry()
+	bt := NewBudgetTracker([]Budget{{Tenant: "acme", LimitUSD: 1e-9, Block: true}})
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""), WithBudgets(bt))
+	h := NewHandler(router, reg)
+
+	ctx := metadata.With(context.Background(), metadata.KeyTenant, "acme")
+	req := protocol.InferRequest{Model: "echo-v1", Messages: []protocol.ChatMessage{{Role: "user", Content: "hello world"}}}
+	if _, err := router.Infer(ctx, req); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if _, err := router.Infer(ctx, req); !misterrors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("second request err = %v, want ErrBudgetExceeded", err)
+	}
+	// Tenant from the request's Meta is honoured too.
+	req.Meta = map[string]string{"tenant": "acme"}
+	if _, err := router.Infer(context.Background(), req); !misterrors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("meta tenant err = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.Budgets(w, httptest.NewRequest("GET", "/budgets", nil))
+	var st []BudgetStatus
+	json.Unmarshal(w.Body.Bytes(), &st)
+	if len(st) != 1 || st[0].State != BudgetExceeded {
+		t.Errorf("/budgets = %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	body := `{"model":"echo-v1","messages":[{"role":"user","content":"hi"}],"meta":{"tenant":"acme"}}`
+	h.InferDirect(w, httptest.NewRequest("POST", "/infer", strings.NewReader(body)))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("blocked /infer status = %d, want 429", w.Code)
+	}
+}
+
+// unpriced is a provider that reports token counts but no cost, as most
+// real providers do.
+type unpriced struct{ *EchoProvider }
+
+func (u unpriced) Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error) {
+	resp, err := u.EchoProvider.Infer(ctx, req)
+	resp.CostUSD = 0
+	return resp, err
+}
+
+func TestRouterBudgetsPriceTokens(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(unpriced{NewEchoProvider("echo", []string{"echo-v1"}, 0)})
+	bt := NewBudgetTracker([]Budget{{Name: "all", LimitUSD: 100}})
+	prices := PriceTable{"echo-v1": {InputPerMTok: 1e6, OutputPerMTok: 2e6}}
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""), WithBudgets(bt), WithPricer(prices))
+
+	req := protocol.InferRequest{Model: "echo-v1", Messages: []protocol.ChatMessage{{Role: "user", Content: "hello world"}}}
+	resp, err := router.Infer(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := float64(resp.TokensIn) + 2*float64(resp.TokensOut)
+	if resp.CostUSD != want {
+		t.Errorf("CostUSD = %v, want %v from the price table", resp.CostUSD, want)
+	}
+	if st := bt.Status(); st[0].SpentUSD != want {
+		t.Errorf("spent = %v, want %v", st[0].SpentUSD, want)
+	}
+}
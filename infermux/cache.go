@@ -0,0 +1,254 @@
+package infermux
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/greynewell/mist-go/cli"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// ErrCacheMiss is returned by a replay-only CachedProvider when no
+// response has been recorded for a request.
+var ErrCacheMiss = misterrors.New(misterrors.CodeNotFound, "infermux: no cached response").Permanent()
+
+// ErrCacheCorrupt is returned by a replay-only CachedProvider when the
+// response recorded for a request can't be decoded.
+var ErrCacheCorrupt = misterrors.New(misterrors.CodeInternal, "infermux: corrupt cached response").Permanent()
+
+// CachedProvider wraps a Provider and persists its responses to a local
+// directory, one JSON file per request hash. Cached responses are served
+// without calling the provider, so recorded eval suites run offline and
+// deterministically. In replay-only mode a miss is an error instead of a
+// provider call. A cache file that can't be decoded is logged and
+// counted; it is an error in replay-only mode, and otherwise a miss
+// whose fresh response replaces it. A response that can't be stored is
+// still returned; the failure is logged and counted.
+type CachedProvider struct {
+	inner      Provider
+	dir        string
+	replayOnly bool
+
+	hits        atomic.Int64
+	misses      atomic.Int64
+	corrupt     atomic.Int64
+	storeErrors atomic.Int64
+}
+
+// CacheOption configures a CachedProvider.
+type CacheOption func(*CachedProvider)
+
+// WithReplayOnly serves only cached responses and never calls the
+// wrapped provider; misses return ErrCacheMiss.
+func WithReplayOnly(on bool) CacheOption {
+	return func(c *CachedProvider) { c.replayOnly = on }
+}
+
+// NewCachedProvider wraps p with a response cache stored in dir, which is
+// created if needed.
+func NewCachedProvider(p Provider, dir string, opts ...CacheOption) (*CachedProvider, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("infermux: cache: %w", err)
+	}
+	c := &CachedProvider{inner: p, dir: dir}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+func (c *CachedProvider) Name() string     { return c.inner.Name() }
+func (c *CachedProvider) Models() []string { return c.inner.Models() }
+
+// Modalities returns those of the wrapped provider.
+func (c *CachedProvider) Modalities() []string {
+	if mm, ok := c.inner.(Multimodal); ok {
+		return mm.Modalities()
+	}
+	return nil
+}
+
+// Hits returns the number of requests served from the cache.
+func (c *CachedProvider) Hits() int64 { return c.hits.Load() }
+
+// Misses returns the number of requests not found in the cache.
+func (c *CachedProvider) Misses() int64 { return c.misses.Load() }
+
+// Corrupt returns the number of cache files read that couldn't be
+// decoded.
+func (c *CachedProvider) Corrupt() int64 { return c.corrupt.Load() }
+
+// StoreErrors returns the number of responses that couldn't be written
+// to the cache.
+func (c *CachedProvider) StoreErrors() int64 { return c.storeErrors.Load() }
+
+// cacheEntry is the on-disk form of a cached response.
+type cacheEntry struct {
+	Key      string                 `json:"key"`
+	Provider string                 `json:"provider"`
+	Request  protocol.InferRequest  `json:"request"`
+	Response protocol.InferResponse `json:"response"`
+	Created  time.Time              `json:"created"`
+}
+
+func (c *CachedProvider) Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error) {
+	return c.infer(req, func() (protocol.InferResponse, error) {
+		return c.inner.Infer(ctx, req)
+	}, nil)
+}
+
+// InferStream replays a cached response as a single chunk, or streams
+// from the wrapped provider and caches the complete response.
+func (c *CachedProvider) InferStream(ctx context.Context, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error) {
+	return c.infer(req, func() (protocol.InferResponse, error) {
+		return inferStream(ctx, c.inner, req, emit)
+	}, emit)
+}
+
+// infer serves req from the cache, or calls miss and caches its
+// response. On a hit, a non-nil emit receives the content as one chunk.
+func (c *CachedProvider) infer(req protocol.InferRequest, miss func() (protocol.InferResponse, error), emit StreamFunc) (protocol.InferResponse, error) {
+	key := CacheKey(c.inner.Name(), req)
+	path := filepath.Join(c.dir, key+".json")
+
+	if data, err := os.ReadFile(path); err == nil {
+		var e cacheEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			c.corrupt.Add(1)
+			slog.Default().Warn("infermux: corrupt cache file", "path", path, "error", err)
+			if c.replayOnly {
+				return protocol.InferResponse{}, misterrors.Wrapf(misterrors.CodeInternal, ErrCacheCorrupt,
+					"provider %s model %q key %s: %v", c.inner.Name(), req.Model, key[:12], err)
+			}
+		} else {
+			c.hits.Add(1)
+			if emit != nil {
+				if err := emit(protocol.InferResponseChunk{Delta: e.Response.Content}); err != nil {
+					return protocol.InferResponse{}, err
+				}
+			}
+			return e.Response, nil
+		}
+	}
+	c.misses.Add(1)
+
+	if c.replayOnly {
+		return protocol.InferResponse{}, misterrors.Wrapf(misterrors.CodeNotFound, ErrCacheMiss,
+			"provider %s model %q key %s", c.inner.Name(), req.Model, key[:12])
+	}
+
+	resp, err := miss()
+	if err != nil {
+		return resp, err
+	}
+	if err := c.store(path, cacheEntry{
+		Key:      key,
+		Provider: c.inner.Name(),
+		Request:  req,
+		Response: resp,
+		Created:  time.Now().UTC(),
+	}); err != nil {
+		c.storeErrors.Add(1)
+		slog.Default().Warn("infermux: cache store failed", "path", path, "error", err)
+	}
+	return resp, nil
+}
+
+// store writes an entry via a temporary file so concurrent readers never
+// see a partial response.
+func (c *CachedProvider) store(path string, e cacheEntry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("infermux: cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("infermux: cache: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("infermux: cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("infermux: cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("infermux: cache: %w", err)
+	}
+	return nil
+}
+
+// CacheKey returns the hex SHA-256 identifying a request to a provider.
+// It covers the model, messages, params, and the name and digest of each
+// attachment; Meta is excluded because it carries per-request trace
+// context. The fields are hashed in protocol.Canonicalize form, so the
+// key doesn't depend on param order or how numbers are written.
+func CacheKey(provider string, req protocol.InferRequest) string {
+	var attachments []string
+	for _, a := range req.Attachments {
+		attachments = append(attachments, a.Name+"="+a.Digest)
+	}
+	data, _ := json.Marshal(struct {
+		Provider    string                 `json:"provider"`
+		Model       string                 `json:"model"`
+		Messages    []protocol.ChatMessage `json:"messages"`
+		Params      map[string]any         `json:"params,omitempty"`
+		Attachments []string               `json:"attachments,omitempty"`
+	}{provider, req.Model, req.Messages, req.Params, attachments})
+	if canonical, err := protocol.Canonicalize(data); err == nil {
+		data = canonical
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache wraps every registered provider in a CachedProvider storing
+// responses under dir/<provider>.
+func (r *Registry) Cache(dir string, opts ...CacheOption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, p := range r.providers {
+		if _, ok := p.(*CachedProvider); ok {
+			continue
+		}
+		cp, err := NewCachedProvider(p, filepath.Join(dir, name), opts...)
+		if err != nil {
+			return err
+		}
+		r.providers[name] = cp
+	}
+	return nil
+}
+
+// AddCacheFlags defines --cache-dir and --replay-only on cmd. Apply them
+// with CacheFromFlags after providers are registered.
+func AddCacheFlags(cmd *cli.Command) {
+	cmd.AddStringFlag("cache-dir", "", "Cache provider responses in this directory")
+	cmd.AddBoolFlag("replay-only", false, "Serve only cached responses; never call providers")
+}
+
+// CacheFromFlags enables response caching on reg when --cache-dir is set.
+// --replay-only without --cache-dir is a usage error.
+func CacheFromFlags(cmd *cli.Command, reg *Registry) error {
+	dir := cmd.GetString("cache-dir")
+	replay := cmd.GetBool("replay-only")
+	if dir == "" {
+		if replay {
+			return cli.Usagef("--replay-only requires --cache-dir")
+		}
+		return nil
+	}
+	return reg.Cache(dir, WithReplayOnly(replay))
+}
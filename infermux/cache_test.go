@@ -0,0 +1,178 @@
+package infermux
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/greynewell/mist-go/cli"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// countingProvider counts calls to an echo provider.
+type countingProvider struct {
+	*EchoProvider
+	calls atomic.Int64
+}
+
+func (c *countingProvider) Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error) {
+	c.calls.Add(1)
+	return c.EchoProvider.Infer(ctx, req)
+}
+
+func cacheReq(prompt string) protocol.InferRequest {
+	return protocol.InferRequest{
+		Model:    "echo-v1",
+		Messages: []protocol.ChatMessage{{Role: "user", Content: prompt}},
+		Meta:     map[string]string{"trace_id": prompt + "-trace"},
+	}
+}
+
+func TestCachedProviderReadThrough(t *testing.T) {
+	dir := t.TempDir()
+	inner := &countingProvider{EchoProvider: NewEchoProvider("echo", []string{"echo-v1"}, 0)}
+	cp, err := NewCachedProvider(inner, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	first, err := cp.Infer(ctx, cacheReq("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := cacheReq("hello")
+	req.Meta["trace_id"] = "different" // Meta does not affect the key
+	second, err := cp.Infer(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inner.calls.Load() != 1 {
+		t.Errorf("provider calls = %d, want 1", inner.calls.Load())
+	}
+	if second.Content != first.Content || cp.Hits() != 1 || cp.Misses() != 1 {
+		t.Errorf("second = %+v, hits=%d misses=%d", second, cp.Hits(), cp.Misses())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Errorf("cache files = %v", files)
+	}
+}
+
+func TestCachedProviderReplayOnly(t *testing.T) {
+	dir := t.TempDir()
+	inner := &countingProvider{EchoProvider: NewEchoProvider("echo", []string{"echo-v1"}, 0)}
+
+	rec, _ := NewCachedProvider(inner, dir)
+	rec.Infer(context.Background(), cacheReq("recorded"))
+
+	replay, _ := NewCachedProvider(inner, dir, WithReplayOnly(true))
+	if _, err := replay.Infer(context.Background(), cacheReq("recorded")); err != nil {
+		t.Fatalf("replay hit: %v", err)
+	}
+	_, err := replay.Infer(context.Background(), cacheReq("new prompt"))
+	if !misterrors.Is(err, ErrCacheMiss) || misterrors.Code(err) != misterrors.CodeNotFound {
+		t.Errorf("miss err = %v, want ErrCacheMiss", err)
+	}
+	if inner.calls.Load() != 1 {
+		t.Errorf("provider calls = %d, want 1 (replay must not call)", inner.calls.Load())
+	}
+}
+
+func TestCachedProviderCorruptEntry(t *testing.T) {
+	dir := t.TempDir()
+	inner := &countingProvider{EchoProvider: NewEchoProvider("echo", []string{"echo-v1"}, 0)}
+	path := filepath.Join(dir, CacheKey("echo", cacheReq("x"))+".json")
+	os.WriteFile(path, []byte("{truncated"), 0o600)
+
+	replay, _ := NewCachedProvider(inner, dir, WithReplayOnly(true))
+	_, err := replay.Infer(context.Background(), cacheReq("x"))
+	if !misterrors.Is(err, ErrCacheCorrupt) || replay.Corrupt() != 1 {
+		t.Errorf("replay err = %v, corrupt = %d", err, replay.Corrupt())
+	}
+
+	// Read-through replaces the corrupt entry with a fresh response.
+	rec, _ := NewCachedProvider(inner, dir)
+	if _, err := rec.Infer(context.Background(), cacheReq("x")); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Corrupt() != 1 || rec.Misses() != 1 || inner.calls.Load() != 1 {
+		t.Errorf("corrupt = %d, misses = %d, calls = %d", rec.Corrupt(), rec.Misses(), inner.calls.Load())
+	}
+	if _, err := replay.Infer(context.Background(), cacheReq("x")); err != nil {
+		t.Errorf("replay after rewrite: %v", err)
+	}
+}
+
+func TestCachedProviderStoreFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	inner := &countingProvider{EchoProvider: NewEchoProvider("echo", []string{"echo-v1"}, 0)}
+	cp, err := NewCachedProvider(inner, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dir) // every store now fails
+
+	resp, err := cp.Infer(context.Background(), cacheReq("hello"))
+	if err != nil {
+		t.Fatalf("Infer: %v (a store failure must not fail the request)", err)
+	}
+	if resp.Content != "echo: hello" || cp.StoreErrors() != 1 {
+		t.Errorf("resp = %+v, store errors = %d", resp, cp.StoreErrors())
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	a := CacheKey("p", cacheReq("x"))
+	if a != CacheKey("p", cacheReq("x")) {
+		t.Error("key not deterministic")
+	}
+	if a == CacheKey("q", cacheReq("x")) || a == CacheKey("p", cacheReq("y")) {
+		t.Error("key ignores provider or messages")
+	}
+	withParams := cacheReq("x")
+	withParams.Params = map[string]any{"temperature": 0.5}
+	if a == CacheKey("p", withParams) {
+		t.Error("key ignores params")
+	}
+
+	written := cacheReq("x")
+	written.Params = map[string]any{"top_k": json.Number("40.0"), "temperature": 0.5}
+	decoded := cacheReq("x")
+	decoded.Params = map[string]any{"temperature": json.Number("5e-1"), "top_k": 40}
+	if CacheKey("p", written) != CacheKey("p", decoded) {
+		t.Error("key depends on how param numbers are written")
+	}
+}
+
+func TestCacheFromFlags(t *testing.T) {
+	dir := t.TempDir()
+	cmd := &cli.Command{Name: "serve"}
+	AddCacheFlags(cmd)
+	cmd.Flags.Parse([]string{"--cache-dir", dir, "--replay-only"})
+
+	reg := echoRegistry()
+	if err := CacheFromFlags(cmd, reg); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := reg.Get("echo")
+	cp, ok := p.(*CachedProvider)
+	if !ok || !cp.replayOnly {
+		t.Fatalf("provider = %T, want replay-only *CachedProvider", p)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "echo")); err != nil {
+		t.Errorf("cache dir not created: %v", err)
+	}
+
+	bad := &cli.Command{Name: "serve"}
+	AddCacheFlags(bad)
+	bad.Flags.Parse([]string{"--replay-only"})
+	if err := CacheFromFlags(bad, echoRegistry()); cli.ExitCode(err) != 2 {
+		t.Errorf("replay-only without dir err = %v", err)
+	}
+}
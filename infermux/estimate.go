@@ -0,0 +1,128 @@
+package infermux
+
+import (
+	"context"
+	"math"
+
+	"github.com/greynewell/mist-go/pricing"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Price is the cost of a model's tokens; see the pricing package.
+type Price = pricing.Price
+
+// Pricer looks up the price of a model. *pricing.Table and
+// *pricing.Source implement it.
+type Pricer interface {
+	Price(model string) (Price, bool)
+}
+
+// PriceTable is a static Pricer keyed by model name.
+type PriceTable map[string]Price
+
+// Price returns the price for model.
+func (t PriceTable) Price(model string) (Price, bool) {
+	p, ok := t[model]
+	return p, ok
+}
+
+// TokenCounter is implemented by providers that can count prompt tokens
+// exactly. Other providers are estimated with EstimateTokens.
+type TokenCounter interface {
+	CountTokens(req protocol.InferRequest) int64
+}
+
+// EstimateTokens approximates the token count of text at four bytes per
+// token, the usual rule of thumb for English with BPE tokenizers.
+func EstimateTokens(text string) int64 {
+	return int64(math.Ceil(float64(len(text)) / 4))
+}
+
+// CostEstimate is the result of a dry run: what a request would cost
+// without sending it to the provider.
+type CostEstimate struct {
+	Model    string `json:"model"`
+	Provider string `json:"provider"`
+	TokensIn int64  `json:"tokens_in"`
+
+	// MaxTokensOut is the request's max_tokens param, if set. Output cost
+	// is only included when it is known.
+	MaxTokensOut int64 `json:"max_tokens_out,omitempty"`
+
+	// ImageTokens is the part of TokensIn estimated for images; see
+	// EstimateImageTokens.
+	ImageTokens int64 `json:"image_tokens,omitempty"`
+
+	InputCostUSD  float64 `json:"input_cost_usd"`
+	MaxCostUSD    float64 `json:"max_cost_usd"`
+	Priced        bool    `json:"priced"`
+	TokensCounted bool    `json:"tokens_counted"` // exact count from the provider
+}
+
+// Estimate resolves the provider for req (for an alias, its first
+// available target) and estimates its cost from the router's pricing
+// without calling the provider. Unpriced models return an estimate with
+// Priced false and zero costs. Requests the router's Policy rejects fail
+// as they would in Infer.
+func (r *Router) Estimate(ctx context.Context, req protocol.InferRequest) (CostEstimate, error) {
+	if r.policy != nil {
+		if err := r.policy.Check(tenantOf(ctx, req), &req); err != nil {
+			return CostEstimate{}, err
+		}
+	}
+	if _, err := checkParts(&req); err != nil {
+		return CostEstimate{}, err
+	}
+	var provider Provider
+	var err error
+	if alias, ok := r.lookupAlias(req.Model); ok {
+		var routes []route
+		if routes, err = r.aliasRoutes(alias); err != nil {
+			return CostEstimate{}, err
+		}
+		provider, req.Model = routes[0].provider, routes[0].model
+	} else if provider, err = r.registry.Resolve(req.Model); err != nil {
+		return CostEstimate{}, err
+	}
+
+	model := req.Model
+	if (model == "" || model == "auto") && len(provider.Models()) > 0 {
+		model = provider.Models()[0]
+	}
+	est := CostEstimate{Model: model, Provider: provider.Name()}
+
+	if tc, ok := provider.(TokenCounter); ok {
+		est.TokensIn = tc.CountTokens(req)
+		est.TokensCounted = true
+	} else {
+		for _, m := range req.Messages {
+			est.TokensIn += EstimateTokens(m.Text())
+		}
+		est.ImageTokens = imageTokens(&req)
+		est.TokensIn += est.ImageTokens
+	}
+	est.MaxTokensOut = maxTokens(req.Params)
+
+	if r.pricer != nil {
+		if p, ok := r.pricer.Price(model); ok {
+			est.Priced = true
+			est.InputCostUSD = p.Cost(est.TokensIn, 0)
+			est.MaxCostUSD = p.Cost(est.TokensIn, est.MaxTokensOut)
+		}
+	}
+	return est, nil
+}
+
+// maxTokens reads the max_tokens param, which arrives as float64 from
+// JSON or as an int from Go callers.
+func maxTokens(params map[string]any) int64 {
+	switch v := params["max_tokens"].(type) {
+	case float64:
+		return int64(v)
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package infermux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+func TestRouterEstimate(t *testing.T) {
+	inner := &countingProvider{EchoProvider: NewEchoProvider("echo", []string{"echo-v1"}, 0)}
+	reg := NewRegistry()
+	reg.Register(inner)
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""), WithPricer(PriceTable{
+		"echo-v1": {InputPerMTok: 3, OutputPerMTok: 15},
+	}))
+
+	req := protocol.InferRequest{
+		Model:    "auto",
+		Messages: []protocol.ChatMessage{{Role: "user", Content: "0123456789abcdef"}}, // 4 tokens
+		Params:   map[string]any{"max_tokens": float64(100)},
+	}
+	est, err := router.Estimate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.calls.Load() != 0 {
+		t.Error("Estimate called the provider")
+	}
+	if est.Model != "echo-v1" || est.Provider != "echo" || est.TokensIn != 4 || est.MaxTokensOut != 100 || !est.Priced {
+		t.Errorf("estimate = %+v", est)
+	}
+	if want := (4*3.0 + 100*15.0) / 1e6; math.Abs(est.MaxCostUSD-want) > 1e-12 {
+		t.Errorf("max cost = %v, want %v", est.MaxCostUSD, want)
+	}
+
+	unpriced, _ := NewRouter(reg, nil).Estimate(context.Background(), req)
+	if unpriced.Priced || unpriced.MaxCostUSD != 0 {
+		t.Errorf("unpriced estimate = %+v", unpriced)
+	}
+}
+
+func TestHandlerInferDryRun(t *testing.T) {
+	h := testHandler()
+	body, _ := json.Marshal(protocol.InferRequest{
+		Model:    "echo-v1",
+		Messages: []protocol.ChatMessage{{Role: "user", Content: "hello"}},
+	})
+	req := httptest.NewRequest("POST", "/infer?dry_run=true", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	h.InferDirect(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var est CostEstimate
+	if err := json.Unmarshal(w.Body.Bytes(), &est); err != nil {
+		t.Fatal(err)
+	}
+	if est.Provider != "echo" || est.TokensIn != 2 {
+		t.Errorf("estimate = %+v", est)
+	}
+}
@@ -0,0 +1,199 @@
+package infermux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// Arm is one side of a traffic split: a provider and model that serve a
+// share of an experiment's requests.
+type Arm struct {
+	Name     string  `json:"name"`
+	Provider string  `json:"provider,omitempty"` // empty resolves Model through the registry
+	Model    string  `json:"model"`              // model sent to the provider
+	Weight   float64 `json:"weight"`             // relative share; 0 disables the arm
+}
+
+// Experiment splits requests for a logical model name between arms, for
+// canary rollouts and A/B tests without client-side switching:
+//
+//	infermux.Experiment{Name: "gpt4-canary", Model: "gpt-4", Arms: []infermux.Arm{
+//	    {Name: "control", Model: "gpt-4", Weight: 95},
+//	    {Name: "canary", Provider: "vllm", Model: "new-model", Weight: 5},
+//	}}
+//
+// Routed spans carry "experiment" and "arm" attrs, so TokenTrace's
+// aggregator reports latency, errors, and cost per arm.
+type Experiment struct {
+	Name  string `json:"name"`
+	Model string `json:"model"`
+	Arms  []Arm  `json:"arms"`
+}
+
+// Experiments holds the active experiments. Weights can be changed at
+// runtime through Handler. It is safe for concurrent use.
+type Experiments struct {
+	mu      sync.RWMutex
+	byName  map[string]*Experiment
+	byModel map[string]*Experiment
+	rand    func() float64
+}
+
+// NewExperiments validates and activates exps. Each experiment needs a
+// unique name and model, and at least one arm with a positive weight.
+func NewExperiments(exps ...Experiment) (*Experiments, error) {
+	e := &Experiments{
+		byName:  make(map[string]*Experiment),
+		byModel: make(map[string]*Experiment),
+		rand:    rand.Float64,
+	}
+	for _, exp := range exps {
+		if exp.Name == "" || exp.Model == "" {
+			return nil, fmt.Errorf("infermux: experiment needs a name and model")
+		}
+		if _, dup := e.byName[exp.Name]; dup {
+			return nil, fmt.Errorf("infermux: duplicate experiment %q", exp.Name)
+		}
+		if _, dup := e.byModel[exp.Model]; dup {
+			return nil, fmt.Errorf("infermux: experiment %q: model %q already split", exp.Name, exp.Model)
+		}
+		exp.Arms = append([]Arm(nil), exp.Arms...)
+		if err := checkArms(exp.Name, exp.Arms); err != nil {
+			return nil, err
+		}
+		e.byName[exp.Name] = &exp
+		e.byModel[exp.Model] = &exp
+	}
+	return e, nil
+}
+
+func checkArms(name string, arms []Arm) error {
+	var total float64
+	for _, a := range arms {
+		if a.Name == "" || a.Model == "" {
+			return fmt.Errorf("infermux: experiment %q: arm needs a name and model", name)
+		}
+		if a.Weight < 0 {
+			return fmt.Errorf("infermux: experiment %q: arm %q has negative weight", name, a.Name)
+		}
+		total += a.Weight
+	}
+	if total <= 0 {
+		return fmt.Errorf("infermux: experiment %q: no arm has a positive weight", name)
+	}
+	return nil
+}
+
+// pick chooses an arm for a request for model, weighted at random.
+func (e *Experiments) pick(model string) (string, Arm, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	exp, ok := e.byModel[model]
+	if !ok {
+		return "", Arm{}, false
+	}
+	var total float64
+	for _, a := range exp.Arms {
+		total += a.Weight
+	}
+	x := e.rand() * total
+	for _, a := range exp.Arms {
+		if a.Weight <= 0 {
+			continue
+		}
+		if x < a.Weight {
+			return exp.Name, a, true
+		}
+		x -= a.Weight
+	}
+	// Rounding left x just past the end; use the last enabled arm.
+	for i := len(exp.Arms) - 1; i >= 0; i-- {
+		if exp.Arms[i].Weight > 0 {
+			return exp.Name, exp.Arms[i], true
+		}
+	}
+	return "", Arm{}, false
+}
+
+// SetWeights changes arm weights for the named experiment. Arms not in
+// weights keep their weight. The change applies to the next request.
+func (e *Experiments) SetWeights(name string, weights map[string]float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp, ok := e.byName[name]
+	if !ok {
+		return misterrors.Newf(misterrors.CodeNotFound, "infermux: no experiment %q", name)
+	}
+	arms := append([]Arm(nil), exp.Arms...)
+	for arm, w := range weights {
+		found := false
+		for j := range arms {
+			if arms[j].Name == arm {
+				arms[j].Weight, found = w, true
+			}
+		}
+		if !found {
+			return misterrors.Newf(misterrors.CodeValidation, "infermux: experiment %q has no arm %q", name, arm)
+		}
+	}
+	if err := checkArms(name, arms); err != nil {
+		return misterrors.Wrap(misterrors.CodeValidation, err, "infermux: set weights")
+	}
+	exp.Arms = arms
+	return nil
+}
+
+// List returns the experiments sorted by name.
+func (e *Experiments) List() []Experiment {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]Experiment, 0, len(e.byName))
+	for _, exp := range e.byName {
+		cp := *exp
+		cp.Arms = append([]Arm(nil), exp.Arms...)
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// WeightsRequest is the body of PUT /experiments.
+type WeightsRequest struct {
+	Experiment string             `json:"experiment"`
+	Weights    map[string]float64 `json:"weights"`
+}
+
+// Handler serves GET /experiments — every experiment with its current
+// weights — and PUT /experiments to change weights:
+//
+//	curl -X PUT -d '{"experiment":"gpt4-canary","weights":{"control":50,"canary":50}}' localhost:8081/experiments
+func (e *Experiments) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req WeightsRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+				misterrors.WriteHTTP(w, r, misterrors.Wrap(misterrors.CodeValidation, err, "infermux: decode weights"))
+				return
+			}
+			if err := e.SetWeights(req.Experiment, req.Weights); err != nil {
+				misterrors.WriteHTTP(w, r, err)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e.List())
+	}
+}
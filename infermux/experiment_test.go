@@ -0,0 +1,137 @@
+package infermux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+func canary(control, canary float64) Experiment {
+	return Experiment{Name: "canary", Model: "gpt-4", Arms: []Arm{
+		{Name: "control", Provider: "echo", Model: "echo-v1", Weight: control},
+		{Name: "canary", Model: "echo-v2", Weight: canary},
+	}}
+}
+
+func TestNewExperimentsValidation(t *testing.T) {
+	bad := []Experiment{
+		{Model: "gpt-4", Arms: []Arm{{Name: "a", Model: "m", Weight: 1}}},
+		{Name: "x", Model: "gpt-4"},
+		{Name: "x", Model: "gpt-4", Arms: []Arm{{Name: "a", Model: "m", Weight: 0}}},
+		{Name: "x", Model: "gpt-4", Arms: []Arm{{Name: "a", Model: "m", Weight: -1}, {Name: "b", Model: "m", Weight: 2}}},
+		{Name: "x", Model: "gpt-4", Arms: []Arm{{Name: "a", Weight: 1}}},
+	}
+	for i, exp := range bad {
+		if _, err := NewExperiments(exp); err == nil {
+			t.Errorf("%d: invalid experiment accepted", i)
+		}
+	}
+	if _, err := NewExperiments(canary(1, 1), canary(1, 1)); err == nil {
+		t.Error("duplicate experiment accepted")
+	}
+}
+
+func TestExperimentsPick(t *testing.T) {
+	e, err := NewExperiments(canary(95, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		r    float64
+		want string
+	}{{0, "control"}, {0.94, "control"}, {0.95, "canary"}, {0.9999, "canary"}} {
+		e.rand = func() float64 { return tc.r }
+		if _, arm, _ := e.pick("gpt-4"); arm.Name != tc.want {
+			t.Errorf("pick at %v = %s, want %s", tc.r, arm.Name, tc.want)
+		}
+	}
+	if _, _, ok := e.pick("echo-v1"); ok {
+		t.Error("picked an arm for a model without an experiment")
+	}
+
+	// A disabled arm is never picked.
+	if err := e.SetWeights("canary", map[string]float64{"control": 0}); err != nil {
+		t.Fatal(err)
+	}
+	e.rand = func() float64 { return 0 }
+	if _, arm, _ := e.pick("gpt-4"); arm.Name != "canary" {
+		t.Errorf("pick = %s, want canary", arm.Name)
+	}
+}
+
+func TestExperimentsSetWeightsErrors(t *testing.T) {
+	e, _ := NewExperiments(canary(95, 5))
+	if err := e.SetWeights("nope", nil); err == nil {
+		t.Error("unknown experiment accepted")
+	}
+	if err := e.SetWeights("canary", map[string]float64{"other": 1}); err == nil {
+		t.Error("unknown arm accepted")
+	}
+	if err := e.SetWeights("canary", map[string]float64{"control": 0, "canary": 0}); err == nil {
+		t.Error("all-zero weights accepted")
+	}
+	if w := e.List()[0].Arms[0].Weight; w != 95 {
+		t.Errorf("failed update changed weight to %v", w)
+	}
+}
+
+func TestRouterExperiment(t *testing.T) {
+	exps, _ := NewExperiments(canary(0, 1))
+	router := NewRouter(echoRegistry(), tokentrace.NewReporter("infermux", ""), WithExperiments(exps))
+
+	resp, err := router.Infer(context.Background(), protocol.InferRequest{Model: "gpt-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Model != "echo-v2" {
+		t.Errorf("Model = %s, want echo-v2", resp.Model)
+	}
+
+	exps.SetWeights("canary", map[string]float64{"control": 1, "canary": 0})
+	exps.byName["canary"].Arms[0].Provider = "missing"
+	if _, err := router.Infer(context.Background(), protocol.InferRequest{Model: "gpt-4"}); err == nil {
+		t.Error("expected error for an arm with an unregistered provider")
+	}
+}
+
+func TestHandlerExperiments(t *testing.T) {
+	exps, _ := NewExperiments(canary(95, 5))
+	reg := echoRegistry()
+	h := NewHandler(NewRouter(reg, tokentrace.NewReporter("infermux", ""), WithExperiments(exps)), reg)
+
+	w := httptest.NewRecorder()
+	h.Experiments(w, httptest.NewRequest("PUT", "/experiments", strings.NewReader(`{"experiment":"canary","weights":{"control":50,"canary":50}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", w.Code, w.Body)
+	}
+	var got []Experiment
+	json.NewDecoder(w.Body).Decode(&got)
+	if len(got) != 1 || got[0].Arms[0].Weight != 50 || got[0].Arms[1].Weight != 50 {
+		t.Errorf("experiments = %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.Experiments(w, httptest.NewRequest("PUT", "/experiments", strings.NewReader(`{"experiment":"nope","weights":{}}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown experiment status = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.Experiments(w, httptest.NewRequest("DELETE", "/experiments", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE status = %d", w.Code)
+	}
+
+	empty := testHandler()
+	w = httptest.NewRecorder()
+	empty.Experiments(w, httptest.NewRequest("GET", "/experiments", nil))
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("no experiments = %s", w.Body)
+	}
+}
@@ -1,67 +1,130 @@
 package infermux
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 
+	misterrors "github.com/greynewell/mist-go/errors"
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/transport"
 )
 
 // Handler provides HTTP handlers for the InferMux API.
 type Handler struct {
 	router   *Router
 	registry *Registry
+	drain    *transport.DrainGate
 }
 
 // NewHandler creates a handler wired to the given router and registry.
 func NewHandler(router *Router, registry *Registry) *Handler {
-	return &Handler{router: router, registry: registry}
+	return &Handler{router: router, registry: registry, drain: transport.NewDrainGate(nil)}
+}
+
+// DrainGate returns the gate Ingest and InferDirect use for the
+// control.drain handshake.
+func (h *Handler) DrainGate() *transport.DrainGate { return h.drain }
+
+// Drain handles GET /drain — reports drain progress after a control.drain.
+func (h *Handler) Drain(w http.ResponseWriter, r *http.Request) {
+	h.drain.ServeHTTP(w, r)
 }
 
 // Ingest handles POST /mist — accepts MIST protocol messages containing
-// inference requests and returns inference responses.
+// inference requests and returns inference responses, answers
+// control.handshake with the agreed envelope version, and takes
+// control.drain to stop accepting requests once those in flight finish
+// (from this host, or with the gate's token; see
+// transport.DrainGate.Authorize). A client that asks
+// for a stream (see InferDirect) receives infer.response.chunk messages
+// instead. The body may be compressed (see transport.ReadBody). A JSON
+// array of requests, as a relay batching into the node sends, is
+// answered with an array of responses in the same order; the batch stops
+// at the first request that fails, whose error is the reply.
 func (h *Handler) Ingest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var msg protocol.Message
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+	data, ok := transport.ReadBody(w, r, protocol.MaxMessageSize)
+	if !ok {
+		return
+	}
+	msgs, err := transport.DecodeMessages(data)
+	if err != nil {
 		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if msg.Type != protocol.TypeInferRequest {
-		http.Error(w, "expected type infer.request, got "+msg.Type, http.StatusBadRequest)
+	if len(msgs) == 1 && (transport.HandleHandshake(w, protocol.SourceInferMux, msgs[0]) || h.drain.HandleControl(w, r, msgs[0])) {
 		return
 	}
-
-	var req protocol.InferRequest
-	if err := msg.Decode(&req); err != nil {
-		http.Error(w, "invalid request payload: "+err.Error(), http.StatusBadRequest)
+	if h.drain.Forbid(w, r, msgs...) {
 		return
 	}
-
-	resp, err := h.router.Infer(r.Context(), req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+	msgs = slices.DeleteFunc(msgs, h.drain.Control)
+	if !h.drain.Acquire() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
 		return
 	}
+	defer h.drain.Release()
 
-	respMsg, err := protocol.New(protocol.SourceInferMux, protocol.TypeInferResponse, resp)
-	if err != nil {
-		http.Error(w, "response marshal: "+err.Error(), http.StatusInternalServerError)
+	reqs := make([]protocol.InferRequest, len(msgs))
+	for i, msg := range msgs {
+		if msg.Type != protocol.TypeInferRequest {
+			http.Error(w, "expected type infer.request, got "+msg.Type, http.StatusBadRequest)
+			return
+		}
+		if err := msg.Decode(&reqs[i]); err != nil {
+			http.Error(w, "invalid request payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(msg.Attachments) > 0 {
+			reqs[i].Attachments = msg.Attachments
+		}
+	}
+
+	batch := bytes.HasPrefix(bytes.TrimSpace(data), []byte{'['})
+	if ct, ok := wantsStream(r); ok && !batch {
+		h.stream(w, r, reqs[0], ct, true)
 		return
 	}
 
+	replies := make([]*protocol.Message, len(msgs))
+	for i, msg := range msgs {
+		resp, err := h.router.Infer(r.Context(), reqs[i])
+		if err != nil {
+			writeInferError(w, r, err)
+			return
+		}
+		if replies[i], err = msg.Reply(protocol.SourceInferMux, protocol.TypeInferResponse, resp); err != nil {
+			http.Error(w, "response marshal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(respMsg)
+	if batch {
+		json.NewEncoder(w).Encode(replies)
+		return
+	}
+	json.NewEncoder(w).Encode(replies[0])
 }
 
 // InferDirect handles POST /infer — accepts a direct InferRequest JSON body
-// (without the MIST envelope) for simpler integration.
+// (without the MIST envelope) for simpler integration. With
+// ?dry_run=true it returns a CostEstimate instead of calling the provider.
+//
+// Clients that send Accept: text/event-stream, or ?stream=true, receive
+// the response as server-sent events, one InferResponseChunk per event;
+// Accept: application/x-ndjson streams the same chunks one per line.
+// Once the handler is draining, requests are refused with 503.
 func (h *Handler) InferDirect(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -74,9 +137,31 @@ func (h *Handler) InferDirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if dry, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dry {
+		est, err := h.router.Estimate(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(est)
+		return
+	}
+
+	if !h.drain.Acquire() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.drain.Release()
+
+	if ct, ok := wantsStream(r); ok {
+		h.stream(w, r, req, ct, false)
+		return
+	}
+
 	resp, err := h.router.Infer(r.Context(), req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeInferError(w, r, err)
 		return
 	}
 
@@ -84,6 +169,39 @@ func (h *Handler) InferDirect(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// writeInferError reports a failed inference: 400 for policy violations,
+// 429 for budget blocks, 502 for provider failures.
+func writeInferError(w http.ResponseWriter, r *http.Request, err error) {
+	if misterrors.Is(err, ErrBudgetExceeded) || misterrors.Code(err) == misterrors.CodeValidation {
+		misterrors.WriteHTTP(w, r, err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// Budgets handles GET /budgets — the state of each cost budget. It
+// returns an empty list when the router has no budgets.
+func (h *Handler) Budgets(w http.ResponseWriter, r *http.Request) {
+	if b := h.router.Budgets(); b != nil {
+		b.Handler()(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]BudgetStatus{})
+}
+
+// Experiments handles GET /experiments — every traffic split with its
+// current weights — and PUT /experiments to change weights at runtime.
+// It returns an empty list when the router has no experiments.
+func (h *Handler) Experiments(w http.ResponseWriter, r *http.Request) {
+	if e := h.router.Experiments(); e != nil {
+		e.Handler()(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]Experiment{})
+}
+
 // ProvidersResponse is the JSON body for GET /providers.
 type ProvidersResponse struct {
 	Providers []ProviderInfo `json:"providers"`
@@ -110,6 +228,31 @@ func (h *Handler) Providers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Models handles GET /models — every alias with the target it currently
+// routes to, and the concrete models of registered providers. Clients
+// should request an alias rather than a concrete model where one fits.
+func (h *Handler) Models(w http.ResponseWriter, r *http.Request) {
+	resp := ModelsResponse{Aliases: []AliasInfo{}, Models: []string{}}
+	if a := h.router.Aliases(); a != nil {
+		for _, alias := range a.List() {
+			info := AliasInfo{Alias: alias}
+			if routes, err := h.router.aliasRoutes(alias); err == nil {
+				info.Resolved = &Target{Provider: routes[0].provider.Name(), Model: routes[0].model}
+			}
+			resp.Aliases = append(resp.Aliases, info)
+		}
+	}
+	for _, name := range h.registry.Providers() {
+		if p, ok := h.registry.Get(name); ok {
+			resp.Models = append(resp.Models, p.Models()...)
+		}
+	}
+	sort.Strings(resp.Models)
+	resp.Models = slices.Compact(resp.Models)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 // InferFromCLI performs a one-shot inference from CLI arguments.
 func InferFromCLI(ctx context.Context, router *Router, model, prompt string) (protocol.InferResponse, error) {
 	req := protocol.InferRequest{
@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/greynewell/mist-go/protocol"
 	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/trace"
+	"github.com/greynewell/mist-go/transport"
 )
 
 func echoRegistry() *Registry {
@@ -153,6 +157,51 @@ func TestRouterInfer(t *testing.T) {
 	}
 }
 
+func TestRouterReportsEachSpanOnce(t *testing.T) {
+	var mu sync.Mutex
+	var spans []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		msgs, err := transport.DecodeMessages(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		for _, m := range msgs {
+			spans = append(spans, m.Type)
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+
+	req := protocol.InferRequest{
+		Model:    "echo-v1",
+		Messages: []protocol.ChatMessage{{Role: "user", Content: "test"}},
+	}
+	for _, global := range []bool{false, true} {
+		spans = nil
+		reporter := tokentrace.NewReporter("infermux", collector.URL)
+		router := NewRouter(echoRegistry(), reporter)
+		if global {
+			// As wired by observability.Init and mist serve.
+			trace.SetExporter(reporter)
+			router = NewRouter(echoRegistry(), nil)
+		}
+		if _, err := router.Infer(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+		trace.SetExporter(nil)
+		if err := reporter.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(spans) != 1 {
+			t.Errorf("global exporter %v: collector ingested %d spans for one request, want 1", global, len(spans))
+		}
+	}
+}
+
 func TestRouterInferUnknownModel(t *testing.T) {
 	router := testRouter()
 	_, err := router.Infer(context.Background(), protocol.InferRequest{
@@ -202,6 +251,75 @@ func TestHandlerIngestSuccess(t *testing.T) {
 	if respMsg.Type != protocol.TypeInferResponse {
 		t.Errorf("type = %s, want infer.response", respMsg.Type)
 	}
+	if respMsg.CorrelationID != msg.ID {
+		t.Errorf("correlation_id = %q, want request ID %q", respMsg.CorrelationID, msg.ID)
+	}
+}
+
+func TestHandlerIngestBatch(t *testing.T) {
+	h := testHandler()
+	var reqs []*protocol.Message
+	for _, text := range []string{"one", "two"} {
+		msg, _ := protocol.New("test", protocol.TypeInferRequest, protocol.InferRequest{
+			Model:    "echo-v1",
+			Messages: []protocol.ChatMessage{{Role: "user", Content: text}},
+		})
+		reqs = append(reqs, msg)
+	}
+	body, _ := json.Marshal(reqs)
+
+	w := httptest.NewRecorder()
+	h.Ingest(w, httptest.NewRequest("POST", "/mist", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200, body: %s", w.Code, w.Body.String())
+	}
+	var replies []protocol.Message
+	if err := json.Unmarshal(w.Body.Bytes(), &replies); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 {
+		t.Fatalf("%d replies, want 2", len(replies))
+	}
+	for i, r := range replies {
+		if r.Type != protocol.TypeInferResponse || r.CorrelationID != reqs[i].ID {
+			t.Errorf("reply %d = %s for %q, want infer.response for %q", i, r.Type, r.CorrelationID, reqs[i].ID)
+		}
+	}
+}
+
+func TestHandlerIngestHandshake(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(testHandler().Ingest))
+	defer srv.Close()
+	v, err := transport.Handshake(context.Background(), transport.NewHTTP(srv.URL), "client")
+	if err != nil || v != protocol.MaxSupportedVersion {
+		t.Errorf("Handshake = %q, %v; want %s", v, err, protocol.MaxSupportedVersion)
+	}
+}
+
+func TestHandlerIngestDrain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(testHandler().Ingest))
+	defer srv.Close()
+	ctx := context.Background()
+	client := transport.NewHTTP(srv.URL)
+	msg, _ := protocol.New("mist", protocol.TypeControlDrain, protocol.ControlDrain{Reason: "deploy"})
+	if err := client.Send(ctx, msg); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	req, _ := protocol.New("test", protocol.TypeInferRequest, protocol.InferRequest{
+		Model:    "echo-v1",
+		Messages: []protocol.ChatMessage{{Role: "user", Content: "late"}},
+	})
+	if _, err := client.Request(ctx, req); err == nil {
+		t.Error("request accepted after drain")
+	}
+
+	h := testHandler()
+	body, _ := msg.Marshal()
+	w := httptest.NewRecorder()
+	h.Ingest(w, httptest.NewRequest("POST", "/mist", bytes.NewReader(body)))
+	if w.Code != http.StatusForbidden || h.DrainGate().Draining() {
+		t.Errorf("drain from another host: status = %d, want 403", w.Code)
+	}
 }
 
 func TestHandlerIngestWrongType(t *testing.T) {
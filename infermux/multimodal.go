@@ -0,0 +1,223 @@
+package infermux
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for imageTokens
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"slices"
+	"strings"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/transport"
+)
+
+// Multimodal is implemented by providers that accept more than text.
+// Modalities returns the part types they accept besides text, such as
+// protocol.PartImage. Providers without it accept only text, and requests
+// with image or audio parts are routed past them.
+type Multimodal interface {
+	Modalities() []string
+}
+
+// DefaultImageTokens is the input tokens charged for an image whose size
+// is unknown, and the most any image is charged.
+const DefaultImageTokens = 1600
+
+// maxImageEdge is the longest edge providers scale an image down to
+// before tokenizing it.
+const maxImageEdge = 1568
+
+// EstimateImageTokens approximates the input tokens of a width×height
+// image: one per 750 pixels once its longest edge is scaled down to
+// 1568, at most DefaultImageTokens. This is the rule Anthropic documents;
+// OpenAI's tile count comes out close for typical sizes.
+func EstimateImageTokens(width, height int) int64 {
+	if width <= 0 || height <= 0 {
+		return DefaultImageTokens
+	}
+	scale := min(1, maxImageEdge/float64(max(width, height)))
+	pixels := float64(width) * scale * float64(height) * scale
+	return min(int64(math.Ceil(pixels/750)), DefaultImageTokens)
+}
+
+// imageTokens estimates the input tokens of req's images, sized from
+// their parts or, for inline attachments, from the image header.
+func imageTokens(req *protocol.InferRequest) int64 {
+	var n int64
+	for _, m := range req.Messages {
+		for _, p := range m.Parts {
+			if p.Type != protocol.PartImage {
+				continue
+			}
+			w, h := p.Width, p.Height
+			if w <= 0 || h <= 0 {
+				if a, ok := req.Attachment(p.Attachment); ok && a.Inline() {
+					if cfg, _, err := image.DecodeConfig(bytes.NewReader(a.Data)); err == nil {
+						w, h = cfg.Width, cfg.Height
+					}
+				}
+			}
+			n += EstimateImageTokens(w, h)
+		}
+	}
+	return n
+}
+
+// checkParts validates req's content parts and returns the non-text part
+// types it uses. Malformed parts are CodeValidation errors.
+func checkParts(req *protocol.InferRequest) ([]string, error) {
+	var mods []string
+	for i, m := range req.Messages {
+		for j, p := range m.Parts {
+			switch p.Type {
+			case protocol.PartText:
+				continue
+			case protocol.PartImage, protocol.PartAudio:
+			default:
+				return nil, partErr(i, j, "unknown type %q", p.Type)
+			}
+			if p.Attachment == "" {
+				return nil, partErr(i, j, "%s part has no attachment", p.Type)
+			}
+			if _, ok := req.Attachment(p.Attachment); !ok {
+				return nil, partErr(i, j, "no attachment %q", p.Attachment)
+			}
+			if !slices.Contains(mods, p.Type) {
+				mods = append(mods, p.Type)
+			}
+		}
+	}
+	return mods, nil
+}
+
+func partErr(msg, part int, format string, args ...any) error {
+	return misterrors.Newf(misterrors.CodeValidation, "infermux: messages[%d].parts[%d]: %s",
+		msg, part, fmt.Sprintf(format, args...))
+}
+
+// accepts reports whether p accepts every part type in mods, returning
+// the first it doesn't.
+func accepts(p Provider, mods []string) (string, bool) {
+	var supported []string
+	if mm, ok := p.(Multimodal); ok {
+		supported = mm.Modalities()
+	}
+	for _, m := range mods {
+		if !slices.Contains(supported, m) {
+			return m, false
+		}
+	}
+	return "", true
+}
+
+// OpenAIContent returns msg's content in the OpenAI chat completions
+// format: a string, or for a message with parts, an array of text,
+// image_url, and input_audio parts. Attachments are fetched and inlined
+// as base64, except images referenced by http(s) URL, which OpenAI
+// fetches itself. Other references are fetched only where fetch allows.
+func OpenAIContent(ctx context.Context, req *protocol.InferRequest, msg protocol.ChatMessage, fetch transport.AttachmentPolicy) (any, error) {
+	if len(msg.Parts) == 0 {
+		return msg.Content, nil
+	}
+	parts := make([]map[string]any, 0, len(msg.Parts))
+	for _, p := range msg.Parts {
+		if p.Type == protocol.PartText {
+			parts = append(parts, map[string]any{"type": "text", "text": p.Text})
+			continue
+		}
+		a, data, err := partContent(ctx, req, p, fetch)
+		if err != nil {
+			return nil, err
+		}
+		switch p.Type {
+		case protocol.PartImage:
+			url := a.URL
+			if !httpURL(url) {
+				url = "data:" + a.ContentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+			}
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]any{"url": url}})
+		case protocol.PartAudio:
+			format, ok := audioFormats[a.ContentType]
+			if !ok {
+				return nil, fmt.Errorf("infermux: attachment %q: unsupported audio type %q", a.Name, a.ContentType)
+			}
+			parts = append(parts, map[string]any{"type": "input_audio", "input_audio": map[string]any{
+				"data":   base64.StdEncoding.EncodeToString(data),
+				"format": format,
+			}})
+		}
+	}
+	return parts, nil
+}
+
+// audioFormats maps audio content types to OpenAI input_audio formats.
+var audioFormats = map[string]string{
+	"audio/wav":   "wav",
+	"audio/x-wav": "wav",
+	"audio/mpeg":  "mp3",
+	"audio/mp3":   "mp3",
+}
+
+// AnthropicContent returns msg's content in the Anthropic messages
+// format: a string, or for a message with parts, an array of text and
+// image blocks. Images referenced by http(s) URL are passed by URL;
+// others are fetched, where fetch allows, and inlined as base64.
+// Anthropic does not accept audio.
+func AnthropicContent(ctx context.Context, req *protocol.InferRequest, msg protocol.ChatMessage, fetch transport.AttachmentPolicy) (any, error) {
+	if len(msg.Parts) == 0 {
+		return msg.Content, nil
+	}
+	blocks := make([]map[string]any, 0, len(msg.Parts))
+	for _, p := range msg.Parts {
+		switch p.Type {
+		case protocol.PartText:
+			blocks = append(blocks, map[string]any{"type": "text", "text": p.Text})
+		case protocol.PartImage:
+			a, data, err := partContent(ctx, req, p, fetch)
+			if err != nil {
+				return nil, err
+			}
+			source := map[string]any{"type": "url", "url": a.URL}
+			if !httpURL(a.URL) {
+				source = map[string]any{
+					"type":       "base64",
+					"media_type": a.ContentType,
+					"data":       base64.StdEncoding.EncodeToString(data),
+				}
+			}
+			blocks = append(blocks, map[string]any{"type": "image", "source": source})
+		default:
+			return nil, misterrors.Newf(misterrors.CodeValidation, "infermux: anthropic does not accept %s input", p.Type)
+		}
+	}
+	return blocks, nil
+}
+
+// partContent returns the attachment a part names and its content,
+// unless it is an image at an http(s) URL, which providers fetch
+// themselves.
+func partContent(ctx context.Context, req *protocol.InferRequest, p protocol.ContentPart, fetch transport.AttachmentPolicy) (*protocol.Attachment, []byte, error) {
+	a, ok := req.Attachment(p.Attachment)
+	if !ok {
+		return nil, nil, misterrors.Newf(misterrors.CodeValidation, "infermux: no attachment %q", p.Attachment)
+	}
+	if p.Type == protocol.PartImage && httpURL(a.URL) {
+		return a, nil, nil
+	}
+	data, err := transport.FetchAttachment(ctx, a, fetch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("infermux: %w", err)
+	}
+	return a, data, nil
+}
+
+func httpURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
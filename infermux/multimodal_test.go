@@ -0,0 +1,202 @@
+package infermux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/transport"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// visionRequest asks about an inline 300×150 PNG: 60 image tokens.
+func visionRequest(t *testing.T) protocol.InferRequest {
+	req := protocol.InferRequest{
+		Model: "echo-v1",
+		Messages: []protocol.ChatMessage{{Role: "user", Parts: []protocol.ContentPart{
+			{Type: protocol.PartText, Text: "what is in this image?"},
+			{Type: protocol.PartImage, Attachment: "photo.png"},
+		}}},
+	}
+	var msg protocol.Message
+	msg.Attach("photo.png", "image/png", testPNG(t, 300, 150))
+	req.Attachments = msg.Attachments
+	return req
+}
+
+func TestEstimateImageTokens(t *testing.T) {
+	for _, tt := range []struct {
+		w, h int
+		want int64
+	}{
+		{0, 0, DefaultImageTokens},
+		{200, 200, 54},
+		{1000, 1000, 1334},
+		{3000, 1500, DefaultImageTokens}, // scaled to 1568×784, then capped
+	} {
+		if got := EstimateImageTokens(tt.w, tt.h); got != tt.want {
+			t.Errorf("EstimateImageTokens(%d, %d) = %d, want %d", tt.w, tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestRouterInferMultimodal(t *testing.T) {
+	resp, err := testRouter().Infer(context.Background(), visionRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "echo: what is in this image? [image: photo.png]" {
+		t.Errorf("Content = %q", resp.Content)
+	}
+	if resp.ImageTokens != 60 || resp.TokensIn != 5+60 {
+		t.Errorf("TokensIn = %d, ImageTokens = %d; want 65 and 60", resp.TokensIn, resp.ImageTokens)
+	}
+
+	est, err := testRouter().Estimate(context.Background(), visionRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.ImageTokens != 60 || est.TokensIn != 6+60 {
+		t.Errorf("estimate TokensIn = %d, ImageTokens = %d; want 66 and 60", est.TokensIn, est.ImageTokens)
+	}
+}
+
+// textOnly hides a provider's Modalities.
+type textOnly struct{ Provider }
+
+func TestRouterInferMultimodalUnsupported(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(textOnly{NewEchoProvider("text", []string{"text-v1"}, 0)})
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""))
+
+	req := visionRequest(t)
+	req.Model = "text-v1"
+	_, err := router.Infer(context.Background(), req)
+	if misterrors.Code(err) != misterrors.CodeValidation || !strings.Contains(err.Error(), "does not accept image input") {
+		t.Errorf("Infer on a text-only provider = %v", err)
+	}
+
+	req = visionRequest(t)
+	req.Attachments = nil
+	if _, err := testRouter().Infer(context.Background(), req); misterrors.Code(err) != misterrors.CodeValidation {
+		t.Errorf("Infer with a missing attachment = %v", err)
+	}
+}
+
+func TestHandlerIngestAttachments(t *testing.T) {
+	req := visionRequest(t)
+	attachments := req.Attachments
+	req.Attachments = nil
+	msg, _ := protocol.New("test", protocol.TypeInferRequest, req)
+	msg.Attachments, msg.Version = attachments, protocol.AttachmentsVersion
+	body, _ := msg.MarshalWith(protocol.JSON)
+
+	w := httptest.NewRecorder()
+	testHandler().Ingest(w, httptest.NewRequest("POST", "/mist", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var respMsg protocol.Message
+	json.Unmarshal(w.Body.Bytes(), &respMsg)
+	var resp protocol.InferResponse
+	respMsg.Decode(&resp)
+	if resp.ImageTokens != 60 {
+		t.Errorf("ImageTokens = %d, want 60 from the envelope's attachment", resp.ImageTokens)
+	}
+}
+
+func TestProviderContent(t *testing.T) {
+	ctx := context.Background()
+	var msg protocol.Message
+	msg.Attach("a.png", "image/png", []byte("png"))
+	msg.AttachRef("b.jpg", "image/jpeg", "https://img.example/b.jpg", 3, protocol.Digest([]byte("jpg")))
+	msg.Attach("c.wav", "audio/wav", []byte("wav"))
+	req := &protocol.InferRequest{Attachments: msg.Attachments}
+	chat := protocol.ChatMessage{Role: "user", Parts: []protocol.ContentPart{
+		{Type: protocol.PartText, Text: "compare"},
+		{Type: protocol.PartImage, Attachment: "a.png"},
+		{Type: protocol.PartImage, Attachment: "b.jpg"},
+	}}
+
+	got, err := OpenAIContent(ctx, req, chat, transport.AttachmentPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{"type": "text", "text": "compare"},
+		{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,cG5n"}},
+		{"type": "image_url", "image_url": map[string]any{"url": "https://img.example/b.jpg"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OpenAIContent = %v", got)
+	}
+
+	got, err = AnthropicContent(ctx, req, chat, transport.AttachmentPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []map[string]any{
+		{"type": "text", "text": "compare"},
+		{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "cG5n"}},
+		{"type": "image", "source": map[string]any{"type": "url", "url": "https://img.example/b.jpg"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnthropicContent = %v", got)
+	}
+
+	audio := protocol.ChatMessage{Role: "user", Parts: []protocol.ContentPart{{Type: protocol.PartAudio, Attachment: "c.wav"}}}
+	got, err = OpenAIContent(ctx, req, audio, transport.AttachmentPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []map[string]any{{"type": "input_audio", "input_audio": map[string]any{"data": "d2F2", "format": "wav"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OpenAIContent audio = %v", got)
+	}
+	if _, err := AnthropicContent(ctx, req, audio, transport.AttachmentPolicy{}); misterrors.Code(err) != misterrors.CodeValidation {
+		t.Errorf("AnthropicContent audio = %v, want a validation error", err)
+	}
+
+	// Audio by reference is fetched only from an allowed host.
+	msg.AttachRef("d.wav", "audio/wav", "http://169.254.169.254/latest/meta-data", 3, protocol.Digest([]byte("wav")))
+	req.Attachments = msg.Attachments
+	remote := protocol.ChatMessage{Role: "user", Parts: []protocol.ContentPart{{Type: protocol.PartAudio, Attachment: "d.wav"}}}
+	if _, err := OpenAIContent(ctx, req, remote, transport.AttachmentPolicy{}); !misterrors.Is(err, transport.ErrAttachmentNotAllowed) {
+		t.Errorf("OpenAIContent of remote audio = %v, want ErrAttachmentNotAllowed", err)
+	}
+
+	if got, _ := OpenAIContent(ctx, req, protocol.ChatMessage{Content: "plain"}, transport.AttachmentPolicy{}); got != "plain" {
+		t.Errorf("text-only content = %v", got)
+	}
+}
+
+func TestCacheKeyAttachments(t *testing.T) {
+	a, b := visionRequest(t), visionRequest(t)
+	if CacheKey("echo", a) != CacheKey("echo", b) {
+		t.Error("equal requests have different cache keys")
+	}
+	var msg protocol.Message
+	msg.Attach("photo.png", "image/png", testPNG(t, 10, 10))
+	b.Attachments = msg.Attachments
+	if CacheKey("echo", a) == CacheKey("echo", b) {
+		t.Error("requests with different images share a cache key")
+	}
+}
@@ -0,0 +1,137 @@
+package infermux
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Policy constrains what clients may request. It is checked before
+// routing, so a rejected request never reaches a provider or counts
+// against a budget. The zero Policy allows everything.
+//
+//	router := infermux.NewRouter(reg, reporter, infermux.WithPolicy(infermux.Policy{
+//		AllowedModels:  map[string][]string{"*": {"claude-haiku"}, "research": {"claude-haiku", "claude-opus"}},
+//		MaxTemperature: 1.0,
+//		MaxTokens:      4096,
+//		BannedParams:   []string{"logit_bias"},
+//	}))
+type Policy struct {
+	// AllowedModels lists the models, or aliases, each tenant may
+	// request. The "*" entry applies to tenants without their own.
+	// Tenants with no entry, when there is no "*", may request any
+	// model. List "auto" to allow automatic routing.
+	AllowedModels map[string][]string `json:"allowed_models,omitempty"`
+
+	// MaxTemperature is the highest temperature param allowed. Zero means
+	// no limit.
+	MaxTemperature float64 `json:"max_temperature,omitempty"`
+
+	// MaxTokens is the highest max_tokens param allowed. Requests that
+	// leave max_tokens unset get MaxTokens. Zero means no limit.
+	MaxTokens int `json:"max_tokens,omitempty"`
+
+	// BannedParams are params clients may not set at all.
+	BannedParams []string `json:"banned_params,omitempty"`
+}
+
+// WithPolicy rejects requests that violate p with CodeValidation errors.
+func WithPolicy(p Policy) RouterOption {
+	return func(r *Router) { r.policy = &p }
+}
+
+// Check validates req for tenant, returning a CodeValidation error that
+// names the violated rule. If MaxTokens is set and req has no max_tokens,
+// Check sets it in a copy of req.Params.
+func (p *Policy) Check(tenant string, req *protocol.InferRequest) error {
+	if allowed, ok := p.allowedModels(tenant); ok && !slices.Contains(allowed, modelName(req.Model)) {
+		if tenant == "" {
+			return policyErr("model %q is not allowed", req.Model)
+		}
+		return policyErr("model %q is not allowed for tenant %q", req.Model, tenant)
+	}
+
+	for _, name := range p.BannedParams {
+		if _, ok := req.Params[name]; ok {
+			return policyErr("param %q is not allowed", name)
+		}
+	}
+
+	if p.MaxTemperature > 0 {
+		if v, ok := req.Params["temperature"]; ok {
+			temp, ok := paramNumber(v)
+			if !ok {
+				return policyErr("temperature must be a number, got %v", v)
+			}
+			if temp > p.MaxTemperature {
+				return policyErr("temperature %g exceeds the maximum of %g", temp, p.MaxTemperature)
+			}
+		}
+	}
+
+	if p.MaxTokens > 0 {
+		v, ok := req.Params["max_tokens"]
+		if !ok {
+			params := maps.Clone(req.Params)
+			if params == nil {
+				params = make(map[string]any, 1)
+			}
+			params["max_tokens"] = p.MaxTokens
+			req.Params = params
+			return nil
+		}
+		n, ok := paramNumber(v)
+		if !ok {
+			return policyErr("max_tokens must be a number, got %v", v)
+		}
+		if n > float64(p.MaxTokens) {
+			return policyErr("max_tokens %g exceeds the maximum of %d", n, p.MaxTokens)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) allowedModels(tenant string) ([]string, bool) {
+	if allowed, ok := p.AllowedModels[tenant]; ok {
+		return allowed, true
+	}
+	allowed, ok := p.AllowedModels["*"]
+	return allowed, ok
+}
+
+// modelName normalizes the empty model to "auto", which it means.
+func modelName(model string) string {
+	if model == "" {
+		return "auto"
+	}
+	return model
+}
+
+// paramNumber reads a numeric param, which is float64 when decoded from
+// JSON but may be any numeric type when set in Go.
+func paramNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// policyErr builds a policy violation. The message is shown to clients,
+// since it tells them how to fix the request.
+func policyErr(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	return misterrors.New(misterrors.CodeValidation, "infermux: policy: "+msg).WithUserMessage(msg)
+}
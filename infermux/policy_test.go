@@ -0,0 +1,114 @@
+package infermux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metadata"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	p := Policy{
+		AllowedModels:  map[string][]string{"*": {"small"}, "research": {"small", "large"}},
+		MaxTemperature: 1,
+		MaxTokens:      1000,
+		BannedParams:   []string{"logit_bias"},
+	}
+	tests := []struct {
+		name   string
+		tenant string
+		model  string
+		params map[string]any
+		want   string // substring of the error, or "" for allowed
+	}{
+		{"allowed", "acme", "small", map[string]any{"temperature": 0.5, "max_tokens": float64(500)}, ""},
+		{"default tenant model", "acme", "large", nil, `model "large" is not allowed for tenant "acme"`},
+		{"tenant model", "research", "large", nil, ""},
+		{"auto not listed", "acme", "", nil, `model "" is not allowed`},
+		{"banned param", "acme", "small", map[string]any{"logit_bias": map[string]any{}}, `param "logit_bias"`},
+		{"temperature", "acme", "small", map[string]any{"temperature": 1.5}, "temperature 1.5 exceeds the maximum of 1"},
+		{"temperature type", "acme", "small", map[string]any{"temperature": "hot"}, "temperature must be a number"},
+		{"max tokens", "acme", "small", map[string]any{"max_tokens": 4096}, "max_tokens 4096 exceeds the maximum of 1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := protocol.InferRequest{Model: tt.model, Params: tt.params}
+			err := p.Check(tt.tenant, &req)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Check = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Check = %v, want %q", err, tt.want)
+			}
+			if misterrors.Code(err) != misterrors.CodeValidation {
+				t.Errorf("code = %s, want validation", misterrors.Code(err))
+			}
+		})
+	}
+}
+
+func TestPolicyDefaultMaxTokens(t *testing.T) {
+	p := Policy{MaxTokens: 256}
+	params := map[string]any{"temperature": 0.2}
+	req := protocol.InferRequest{Model: "m", Params: params}
+	if err := p.Check("", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Params["max_tokens"] != 256 {
+		t.Errorf("max_tokens = %v, want 256", req.Params["max_tokens"])
+	}
+	if _, ok := params["max_tokens"]; ok {
+		t.Error("Check modified the caller's params")
+	}
+
+	var zero Policy
+	if err := zero.Check("any", &protocol.InferRequest{Model: "x", Params: map[string]any{"temperature": 9.0}}); err != nil {
+		t.Errorf("zero Policy rejected request: %v", err)
+	}
+}
+
+func TestRouterPolicy(t *testing.T) {
+	reg := echoRegistry()
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""), WithPolicy(Policy{
+		AllowedModels: map[string][]string{"acme": {"echo-v1"}, "*": {}},
+		MaxTokens:     100,
+	}))
+	h := NewHandler(router, reg)
+
+	msgs := []protocol.ChatMessage{{Role: "user", Content: "hi"}}
+	ctx := metadata.With(context.Background(), metadata.KeyTenant, "acme")
+	if _, err := router.Infer(ctx, protocol.InferRequest{Model: "echo-v1", Messages: msgs}); err != nil {
+		t.Fatalf("allowed request: %v", err)
+	}
+	_, err := router.Infer(context.Background(), protocol.InferRequest{Model: "echo-v1", Messages: msgs})
+	if misterrors.Code(err) != misterrors.CodeValidation {
+		t.Errorf("other tenant err = %v, want validation", err)
+	}
+	if _, err := router.Estimate(context.Background(), protocol.InferRequest{Model: "echo-v1"}); err == nil {
+		t.Error("Estimate allowed a request the policy rejects")
+	}
+
+	w := httptest.NewRecorder()
+	body := `{"model":"echo-v1","messages":[{"role":"user","content":"hi"}],"params":{"max_tokens":5000},"meta":{"tenant":"acme"}}`
+	h.InferDirect(w, httptest.NewRequest("POST", "/infer", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	var resp struct {
+		Error struct{ Message string } `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error.Message != "max_tokens 5000 exceeds the maximum of 100" {
+		t.Errorf("message = %q", resp.Error.Message)
+	}
+}
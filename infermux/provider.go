@@ -6,6 +6,7 @@ package infermux
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,6 +25,39 @@ type Provider interface {
 	Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error)
 }
 
+// StreamingProvider is a Provider that streams natively. Streams from
+// other providers fall back to InferAsStream.
+type StreamingProvider interface {
+	Provider
+
+	// InferStream performs inference, calling emit with each piece of
+	// content as it arrives, and returns the complete response. It stops
+	// and returns emit's error if emit fails.
+	InferStream(ctx context.Context, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error)
+}
+
+// StreamFunc receives the chunks of a streamed response in order.
+type StreamFunc func(chunk protocol.InferResponseChunk) error
+
+// inferStream streams req from p, natively if p is a StreamingProvider
+// and otherwise with InferAsStream.
+func inferStream(ctx context.Context, p Provider, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error) {
+	if sp, ok := p.(StreamingProvider); ok {
+		return sp.InferStream(ctx, req, emit)
+	}
+	return InferAsStream(ctx, p, req, emit)
+}
+
+// InferAsStream streams from a provider without native streaming: it
+// calls p.Infer and emits the whole content as one chunk.
+func InferAsStream(ctx context.Context, p Provider, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error) {
+	resp, err := p.Infer(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	return resp, emit(protocol.InferResponseChunk{Delta: resp.Content})
+}
+
 // EchoProvider is a test/development provider that echoes the request back.
 // It simulates realistic latency, token counts, and costs.
 type EchoProvider struct {
@@ -40,6 +74,11 @@ func NewEchoProvider(name string, models []string, delay time.Duration) *EchoPro
 func (e *EchoProvider) Name() string     { return e.name }
 func (e *EchoProvider) Models() []string { return e.models }
 
+// Modalities reports that the echo provider accepts images and audio.
+func (e *EchoProvider) Modalities() []string {
+	return []string{protocol.PartImage, protocol.PartAudio}
+}
+
 func (e *EchoProvider) Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error) {
 	select {
 	case <-time.After(e.delay):
@@ -47,10 +86,16 @@ func (e *EchoProvider) Infer(ctx context.Context, req protocol.InferRequest) (pr
 		return protocol.InferResponse{}, ctx.Err()
 	}
 
-	// Build echo content from last message.
+	// Build echo content from last message, naming its attachments.
 	content := "echo: "
 	if len(req.Messages) > 0 {
-		content += req.Messages[len(req.Messages)-1].Content
+		last := req.Messages[len(req.Messages)-1]
+		content += last.Text()
+		for _, p := range last.Parts {
+			if p.Type != protocol.PartText {
+				content += " [" + p.Type + ": " + p.Attachment + "]"
+			}
+		}
 	}
 
 	model := req.Model
@@ -62,8 +107,10 @@ func (e *EchoProvider) Infer(ctx context.Context, req protocol.InferRequest) (pr
 
 	tokensIn := int64(0)
 	for _, m := range req.Messages {
-		tokensIn += int64(len(m.Content) / 4) // rough estimate
+		tokensIn += int64(len(m.Text()) / 4) // rough estimate
 	}
+	images := imageTokens(&req)
+	tokensIn += images
 	tokensOut := int64(len(content) / 4)
 	if tokensOut < 1 {
 		tokensOut = 1
@@ -75,17 +122,37 @@ func (e *EchoProvider) Infer(ctx context.Context, req protocol.InferRequest) (pr
 		Content:      content,
 		TokensIn:     tokensIn,
 		TokensOut:    tokensOut,
+		ImageTokens:  images,
 		CostUSD:      float64(tokensIn+tokensOut) * 0.00001,
 		LatencyMS:    e.delay.Milliseconds(),
 		FinishReason: "stop",
 	}, nil
 }
 
+// InferStream emits the echo a word at a time, each word keeping its
+// trailing space.
+func (e *EchoProvider) InferStream(ctx context.Context, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error) {
+	resp, err := e.Infer(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	for i, word := range strings.SplitAfter(resp.Content, " ") {
+		if err := ctx.Err(); err != nil {
+			return protocol.InferResponse{}, err
+		}
+		if err := emit(protocol.InferResponseChunk{Index: i, Delta: word}); err != nil {
+			return protocol.InferResponse{}, err
+		}
+	}
+	return resp, nil
+}
+
 // Registry holds configured providers.
 type Registry struct {
 	mu        sync.RWMutex
 	providers map[string]Provider
 	modelMap  map[string]string // model name → provider name
+	watchers  []func(Provider)
 }
 
 // NewRegistry creates an empty provider registry.
@@ -99,11 +166,23 @@ func NewRegistry() *Registry {
 // Register adds a provider to the registry.
 func (r *Registry) Register(p Provider) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	r.providers[p.Name()] = p
 	for _, model := range p.Models() {
 		r.modelMap[model] = p.Name()
 	}
+	watchers := r.watchers
+	r.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(p)
+	}
+}
+
+// OnRegister calls fn for every provider registered from now on.
+func (r *Registry) OnRegister(fn func(Provider)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchers = append(r.watchers, fn)
 }
 
 // Get returns a provider by name.
@@ -3,8 +3,11 @@ package infermux
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/greynewell/mist-go/circuitbreaker"
+	misterrors "github.com/greynewell/mist-go/errors"
 	"github.com/greynewell/mist-go/protocol"
 	"github.com/greynewell/mist-go/tokentrace"
 	"github.com/greynewell/mist-go/trace"
@@ -15,47 +18,383 @@ import (
 type Router struct {
 	registry *Registry
 	reporter *tokentrace.Reporter
+	pricer   Pricer
+	budgets  *BudgetTracker
+	webhooks *Webhooks
+	exps     *Experiments
+	shadow   *Shadow
+	shadows  sync.WaitGroup
+	policy   *Policy
+	aliases  *Aliases
+
+	breakerCfg *circuitbreaker.Config
+	bmu        sync.Mutex
+	breakers   map[string]*circuitbreaker.Breaker // by provider name
+}
+
+// RouterOption configures a Router.
+type RouterOption func(*Router)
+
+// WithPricer sets the pricing used for cost estimates, and to price the
+// tokens of responses whose provider reports no cost, so their spend is
+// traced and charged to budgets.
+func WithPricer(p Pricer) RouterOption {
+	return func(r *Router) { r.pricer = p }
+}
+
+// WithBudgets enforces and records cost budgets for every request.
+func WithBudgets(t *BudgetTracker) RouterOption {
+	return func(r *Router) { r.budgets = t }
+}
+
+// WithWebhooks notifies hooks when providers are registered, when a
+// budget set with WithBudgets is exhausted, when a breaker set with
+// WithBreakers trips, and when a request breaks the WithPolicy policy.
+func WithWebhooks(hooks *Webhooks) RouterOption {
+	return func(r *Router) { r.webhooks = hooks }
+}
+
+// WithBreakers puts each provider behind its own circuit breaker built
+// from cfg. Only retryable provider failures count against a breaker,
+// and an open breaker moves the request on to its next route.
+func WithBreakers(cfg circuitbreaker.Config) RouterOption {
+	return func(r *Router) {
+		r.breakerCfg = &cfg
+		r.breakers = make(map[string]*circuitbreaker.Breaker)
+	}
+}
+
+// WithExperiments splits traffic for experiment models between arms.
+func WithExperiments(e *Experiments) RouterOption {
+	return func(r *Router) { r.exps = e }
+}
+
+// WithAliases routes requests for an alias name to its targets.
+func WithAliases(a *Aliases) RouterOption {
+	return func(r *Router) { r.aliases = a }
+}
+
+// Budgets returns the router's budget tracker, or nil.
+func (r *Router) Budgets() *BudgetTracker {
+	return r.budgets
+}
+
+// Experiments returns the router's experiments, or nil.
+func (r *Router) Experiments() *Experiments {
+	return r.exps
+}
+
+// Breakers returns the stats of each provider's breaker, by provider
+// name, or nil without WithBreakers.
+func (r *Router) Breakers() map[string]circuitbreaker.Stats {
+	if r.breakerCfg == nil {
+		return nil
+	}
+	r.bmu.Lock()
+	defer r.bmu.Unlock()
+	stats := make(map[string]circuitbreaker.Stats, len(r.breakers))
+	for name, b := range r.breakers {
+		stats[name] = b.Stats()
+	}
+	return stats
+}
+
+// report sends an ended span to the router's reporter, if it has one.
+func (r *Router) report(ctx context.Context, span *trace.Span) {
+	if r.reporter != nil {
+		r.reporter.Report(ctx, span)
+	}
+}
+
+// Aliases returns the router's aliases, or nil.
+func (r *Router) Aliases() *Aliases {
+	return r.aliases
 }
 
 // NewRouter creates a router with the given provider registry and trace reporter.
-func NewRouter(reg *Registry, reporter *tokentrace.Reporter) *Router {
-	return &Router{registry: reg, reporter: reporter}
+// A nil reporter leaves span export to the trace exporter, which is the
+// right choice when that exporter already reports to TokenTrace (as
+// after observability.Init); passing the same reporter would send every
+// span twice.
+func NewRouter(reg *Registry, reporter *tokentrace.Reporter, opts ...RouterOption) *Router {
+	r := &Router{registry: reg, reporter: reporter}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if hooks := r.webhooks; hooks != nil {
+		reg.OnRegister(func(p Provider) {
+			hooks.Fire(WebhookEvent{
+				Type:     EventProviderRegistered,
+				Provider: p.Name(),
+				Data:     map[string]any{"models": p.Models()},
+			})
+		})
+		if r.budgets != nil {
+			r.budgets.OnExhausted(hooks.budgetExhausted)
+		}
+	}
+	return r
 }
 
 // Infer routes a request to the appropriate provider, instruments the
 // call with tracing, and returns the response.
 func (r *Router) Infer(ctx context.Context, req protocol.InferRequest) (protocol.InferResponse, error) {
+	return r.infer(ctx, req, nil)
+}
+
+// InferStream is like Infer but streams the response: emit receives each
+// chunk of content as the provider produces it, then a final chunk with
+// Done set and the complete Response. Chunks are numbered in order. The
+// span records the time to the first chunk in "ttft_ms".
+func (r *Router) InferStream(ctx context.Context, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error) {
+	return r.infer(ctx, req, emit)
+}
+
+// infer implements Infer, and InferStream when emit is non-nil.
+func (r *Router) infer(ctx context.Context, req protocol.InferRequest, emit StreamFunc) (protocol.InferResponse, error) {
 	ctx, span := trace.Start(ctx, "infermux.infer")
 
-	provider, err := r.registry.Resolve(req.Model)
+	tenant := tenantOf(ctx, req)
+	var routes []route
+	var mods []string
+	var err error
+	if r.policy != nil {
+		if err = r.policy.Check(tenant, &req); err != nil && r.webhooks != nil {
+			r.webhooks.Fire(WebhookEvent{
+				Type:   EventGuardrailViolation,
+				Tenant: tenant,
+				Data:   map[string]any{"model": req.Model, "error": err.Error()},
+			})
+		}
+	}
+	if err == nil {
+		mods, err = checkParts(&req)
+	}
+	if err == nil {
+		routes, err = r.route(span, &req)
+	}
 	if err != nil {
 		span.SetAttr("error", err.Error())
 		span.End("error")
-		r.reporter.Report(ctx, span)
+		r.report(ctx, span)
 		return protocol.InferResponse{}, err
 	}
 
-	span.SetAttr("provider", provider.Name())
-	span.SetAttr("model", req.Model)
+	// Try each route in turn until one succeeds. A budget block or a
+	// retryable provider failure moves on to the next route, unless a
+	// stream has already emitted chunks.
+	var (
+		provider Provider
+		resp     protocol.InferResponse
+		shadow   chan<- shadowResult
+		start    time.Time
+		latency  time.Duration
+		chunks   int
+	)
+	for i, rt := range routes {
+		provider, req.Model = rt.provider, rt.model
+		if i > 0 {
+			span.SetAttr("fallbacks", i)
+		}
+		if mod, ok := accepts(provider, mods); !ok {
+			err = misterrors.Newf(misterrors.CodeValidation, "infermux: provider %s does not accept %s input", provider.Name(), mod)
+			continue
+		}
+		if r.budgets != nil {
+			if err = r.budgets.Allow(provider.Name(), tenant); err != nil {
+				continue
+			}
+		}
 
-	start := time.Now()
-	resp, err := provider.Infer(ctx, req)
-	latency := time.Since(start)
+		if start.IsZero() {
+			shadow = r.startShadow(ctx, span, req)
+		}
+		start = time.Now()
+		err = r.guard(ctx, provider.Name(), func(ctx context.Context) (err error) {
+			if emit == nil {
+				resp, err = provider.Infer(ctx, req)
+				return err
+			}
+			span.SetAttr("stream", true)
+			resp, err = inferStream(ctx, provider, req, func(c protocol.InferResponseChunk) error {
+				if chunks == 0 {
+					span.SetAttr("ttft_ms", time.Since(start).Milliseconds())
+				}
+				c.Index = chunks
+				chunks++
+				return emit(c)
+			})
+			return err
+		})
+		latency = time.Since(start)
+		if err != nil {
+			err = fmt.Errorf("provider %s: %w", provider.Name(), err)
+			if chunks == 0 && ctx.Err() == nil && misterrors.IsRetryable(err) {
+				continue
+			}
+		}
+		break
+	}
+	finishShadow(shadow, resp.Content, err)
 
+	span.SetAttr("provider", provider.Name())
+	span.SetAttr("model", req.Model)
 	if err != nil {
 		span.SetAttr("error", err.Error())
 		span.End("error")
-		r.reporter.Report(ctx, span)
-		return protocol.InferResponse{}, fmt.Errorf("provider %s: %w", provider.Name(), err)
+		r.report(ctx, span)
+		return protocol.InferResponse{}, err
 	}
 
+	if resp.CostUSD == 0 && r.pricer != nil {
+		resp.CostUSD = r.cost(resp, req.Model)
+	}
 	span.SetAttr("tokens_in", float64(resp.TokensIn))
 	span.SetAttr("tokens_out", float64(resp.TokensOut))
+	if resp.ImageTokens > 0 {
+		span.SetAttr("image_tokens", float64(resp.ImageTokens))
+	}
 	span.SetAttr("cost_usd", resp.CostUSD)
 	span.SetAttr("latency_ms", latency.Milliseconds())
 	span.SetAttr("finish_reason", resp.FinishReason)
+	if emit != nil {
+		span.SetAttr("chunks", chunks)
+	}
 	span.End("ok")
 
-	r.reporter.Report(ctx, span)
+	r.report(ctx, span)
+	if r.budgets != nil {
+		r.budgets.Record(provider.Name(), tenant, resp.CostUSD)
+	}
+	if emit != nil {
+		if err := emit(protocol.InferResponseChunk{Index: chunks, Done: true, Response: &resp}); err != nil {
+			return resp, err
+		}
+	}
 	return resp, nil
 }
+
+// guard runs call through provider's breaker, if the router has
+// breakers. Only retryable failures count against the breaker, so bad
+// requests don't trip it.
+func (r *Router) guard(ctx context.Context, provider string, call func(context.Context) error) error {
+	if r.breakerCfg == nil {
+		return call(ctx)
+	}
+	var err error
+	if berr := r.breaker(provider).Do(ctx, func(ctx context.Context) error {
+		err = call(ctx)
+		if misterrors.IsRetryable(err) {
+			return err
+		}
+		return nil
+	}); berr != nil {
+		return berr
+	}
+	return err
+}
+
+// breaker returns provider's breaker, creating it on first use.
+func (r *Router) breaker(provider string) *circuitbreaker.Breaker {
+	r.bmu.Lock()
+	defer r.bmu.Unlock()
+	b, ok := r.breakers[provider]
+	if !ok {
+		cfg := *r.breakerCfg
+		if r.webhooks != nil {
+			hook, prev := r.webhooks.BreakerHook(provider), cfg.OnStateChange
+			cfg.OnStateChange = hook
+			if prev != nil {
+				cfg.OnStateChange = func(from, to circuitbreaker.State) {
+					prev(from, to)
+					hook(from, to)
+				}
+			}
+		}
+		b = circuitbreaker.New(cfg)
+		r.breakers[provider] = b
+	}
+	return b
+}
+
+// cost prices resp's tokens for the model that answered it, or else the
+// model requested. It is 0 for a model the router's pricer doesn't know.
+func (r *Router) cost(resp protocol.InferResponse, model string) float64 {
+	if resp.Model != "" {
+		model = resp.Model
+	}
+	p, ok := r.pricer.Price(model)
+	if !ok {
+		return 0
+	}
+	return p.Cost(resp.TokensIn, resp.TokensOut)
+}
+
+// route is a provider and the model to request from it.
+type route struct {
+	provider Provider
+	model    string
+}
+
+// route returns the routes to try for req, in order: the registered
+// targets of an alias, or the single provider chosen by resolve.
+func (r *Router) route(span *trace.Span, req *protocol.InferRequest) ([]route, error) {
+	if alias, ok := r.lookupAlias(req.Model); ok {
+		span.SetAttr("alias", alias.Name)
+		return r.aliasRoutes(alias)
+	}
+	p, err := r.resolve(span, req)
+	if err != nil {
+		return nil, err
+	}
+	return []route{{p, req.Model}}, nil
+}
+
+func (r *Router) lookupAlias(model string) (Alias, bool) {
+	if r.aliases == nil {
+		return Alias{}, false
+	}
+	return r.aliases.Lookup(model)
+}
+
+// aliasRoutes returns the alias's targets whose providers are registered.
+func (r *Router) aliasRoutes(alias Alias) ([]route, error) {
+	var routes []route
+	for _, t := range alias.targets() {
+		if t.Provider == "" {
+			if p, err := r.registry.Resolve(t.Model); err == nil {
+				routes = append(routes, route{p, t.Model})
+			}
+		} else if p, ok := r.registry.Get(t.Provider); ok {
+			routes = append(routes, route{p, t.Model})
+		}
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("alias %s: no registered provider for any target", alias.Name)
+	}
+	return routes, nil
+}
+
+// resolve picks the provider for req. If req.Model is split by an
+// experiment, an arm is chosen and req.Model rewritten to the arm's model.
+func (r *Router) resolve(span *trace.Span, req *protocol.InferRequest) (Provider, error) {
+	if r.exps == nil {
+		return r.registry.Resolve(req.Model)
+	}
+	exp, arm, ok := r.exps.pick(req.Model)
+	if !ok {
+		return r.registry.Resolve(req.Model)
+	}
+	span.SetAttr("experiment", exp)
+	span.SetAttr("arm", arm.Name)
+	span.SetAttr("requested_model", req.Model)
+	req.Model = arm.Model
+	if arm.Provider == "" {
+		return r.registry.Resolve(arm.Model)
+	}
+	p, ok := r.registry.Get(arm.Provider)
+	if !ok {
+		return nil, fmt.Errorf("experiment %s: arm %s: no provider %q", exp, arm.Name, arm.Provider)
+	}
+	return p, nil
+}
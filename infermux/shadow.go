@@ -0,0 +1,250 @@
+package infermux
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// agreementBuckets are histogram boundaries for shadow agreement scores.
+var agreementBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+
+// Shadow sends a copy of each routed request to a second model in the
+// background, to qualify a replacement model on production traffic
+// without affecting callers:
+//
+//	router := infermux.NewRouter(reg, reporter, infermux.WithShadow(infermux.Shadow{
+//	    Model:   "new-model",
+//	    Scorer:  infermux.SemanticScorer,
+//	    Metrics: metricsReg,
+//	}))
+//
+// The shadow call is traced as an "infermux.shadow" span whose parent is
+// the primary "infermux.infer" span; the primary span carries the shadow's
+// span ID in "shadow_span_id". Shadow costs are not recorded against
+// budgets.
+type Shadow struct {
+	Model    string // model sent to the shadow provider
+	Provider string // empty resolves Model through the registry
+
+	// Rate is the fraction of requests shadowed, in (0, 1]. Zero means
+	// every request.
+	Rate float64
+
+	// Scorer compares the primary and shadow responses. The score is
+	// recorded in the shadow span's "agreement" attr. Nil skips scoring.
+	Scorer Scorer
+
+	// Threshold is the score at or above which the responses agree.
+	// Default 0.8.
+	Threshold float64
+
+	// Timeout bounds each shadow call. Default 60s.
+	Timeout time.Duration
+
+	// Metrics, if set, records infermux_shadow_requests_total{model,result},
+	// infermux_shadow_agreement{model} (score histogram), and
+	// infermux_shadow_agreed_total{model}.
+	Metrics *metrics.Registry
+}
+
+// WithShadow mirrors requests to a shadow model. See Shadow.
+func WithShadow(s Shadow) RouterOption {
+	return func(r *Router) {
+		if s.Threshold == 0 {
+			s.Threshold = 0.8
+		}
+		if s.Timeout == 0 {
+			s.Timeout = 60 * time.Second
+		}
+		r.shadow = &s
+	}
+}
+
+// Scorer scores how closely a shadow response agrees with the primary
+// response, from 0 (unrelated) to 1 (equivalent).
+type Scorer func(primary, shadow string) float64
+
+// ExactScorer scores 1 if the responses are identical after trimming
+// surrounding whitespace, and 0 otherwise.
+func ExactScorer(primary, shadow string) float64 {
+	if strings.TrimSpace(primary) == strings.TrimSpace(shadow) {
+		return 1
+	}
+	return 0
+}
+
+// SemanticScorer scores the cosine similarity of the responses' word
+// frequencies, ignoring case and punctuation. It tolerates rewording and
+// reordering that ExactScorer would not; for embedding-based similarity,
+// supply a custom Scorer.
+func SemanticScorer(primary, shadow string) float64 {
+	a, b := wordCounts(primary), wordCounts(shadow)
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	var dot, na, nb float64
+	for w, n := range a {
+		dot += n * b[w]
+		na += n * n
+	}
+	for _, n := range b {
+		nb += n * n
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func wordCounts(s string) map[string]float64 {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	counts := make(map[string]float64, len(words))
+	for _, w := range words {
+		counts[w]++
+	}
+	return counts
+}
+
+// ScorerByName returns the built-in scorer "exact" or "semantic", for
+// selecting a scorer from configuration.
+func ScorerByName(name string) (Scorer, error) {
+	switch name {
+	case "exact":
+		return ExactScorer, nil
+	case "semantic":
+		return SemanticScorer, nil
+	}
+	return nil, fmt.Errorf("infermux: unknown scorer %q (want exact or semantic)", name)
+}
+
+// shadowResult is the primary call's outcome, handed to the shadow call
+// for scoring.
+type shadowResult struct {
+	content string
+	err     error
+}
+
+// startShadow starts the shadow call for req if it is sampled, and
+// returns the channel to send the primary result on, or nil. ctx carries
+// the primary span.
+func (r *Router) startShadow(ctx context.Context, primary *trace.Span, req protocol.InferRequest) chan<- shadowResult {
+	s := r.shadow
+	if s == nil || (s.Rate > 0 && s.Rate < 1 && rand.Float64() >= s.Rate) {
+		return nil
+	}
+	ctx, span := trace.Start(context.WithoutCancel(ctx), "infermux.shadow")
+	primary.SetAttr("shadow_span_id", span.SpanID)
+	primary.SetAttr("shadow_model", s.Model)
+
+	result := make(chan shadowResult, 1)
+	r.shadows.Add(1)
+	go func() {
+		defer r.shadows.Done()
+		r.runShadow(ctx, span, req, result)
+	}()
+	return result
+}
+
+// finishShadow hands the primary result to a started shadow call.
+func finishShadow(ch chan<- shadowResult, content string, err error) {
+	if ch != nil {
+		ch <- shadowResult{content: content, err: err}
+	}
+}
+
+func (r *Router) runShadow(ctx context.Context, span *trace.Span, req protocol.InferRequest, primary <-chan shadowResult) {
+	s := r.shadow
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	span.SetAttr("shadow_of", span.ParentID)
+	span.SetAttr("model", s.Model)
+	req.Model = s.Model
+
+	var provider Provider
+	var err error
+	if s.Provider != "" {
+		var ok bool
+		if provider, ok = r.registry.Get(s.Provider); !ok {
+			err = fmt.Errorf("no provider %q", s.Provider)
+		}
+	} else {
+		provider, err = r.registry.Resolve(s.Model)
+	}
+
+	var resp protocol.InferResponse
+	if err == nil {
+		span.SetAttr("provider", provider.Name())
+		start := time.Now()
+		resp, err = provider.Infer(ctx, req)
+		span.SetAttr("latency_ms", time.Since(start).Milliseconds())
+	}
+	if err != nil {
+		span.SetAttr("error", err.Error())
+		span.End("error")
+		r.report(ctx, span)
+		r.shadowCount(s.Metrics, "infermux_shadow_requests_total", "result", "error")
+		return
+	}
+
+	span.SetAttr("tokens_in", float64(resp.TokensIn))
+	span.SetAttr("tokens_out", float64(resp.TokensOut))
+	span.SetAttr("cost_usd", resp.CostUSD)
+	span.SetAttr("finish_reason", resp.FinishReason)
+	r.shadowCount(s.Metrics, "infermux_shadow_requests_total", "result", "ok")
+
+	if s.Scorer != nil {
+		var p shadowResult
+		select {
+		case p = <-primary:
+		case <-ctx.Done():
+			p.err = ctx.Err()
+		}
+		if p.err == nil {
+			score := s.Scorer(p.content, resp.Content)
+			agreed := score >= s.Threshold
+			span.SetAttr("agreement", score)
+			span.SetAttr("agreed", agreed)
+			if s.Metrics != nil {
+				s.Metrics.Histogram("infermux_shadow_agreement", agreementBuckets, "model", s.Model).Observe(score)
+			}
+			if agreed {
+				r.shadowCount(s.Metrics, "infermux_shadow_agreed_total")
+			}
+		}
+	}
+	span.End("ok")
+	r.report(ctx, span)
+}
+
+func (r *Router) shadowCount(reg *metrics.Registry, name string, labels ...string) {
+	if reg != nil {
+		reg.Counter(name, append([]string{"model", r.shadow.Model}, labels...)...).Inc()
+	}
+}
+
+// WaitShadows waits for in-flight shadow calls, or until ctx is done.
+func (r *Router) WaitShadows(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.shadows.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
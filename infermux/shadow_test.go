@@ -0,0 +1,92 @@
+package infermux
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/trace"
+)
+
+func TestScorers(t *testing.T) {
+	if ExactScorer("Paris ", "Paris") != 1 || ExactScorer("Paris", "paris") != 0 {
+		t.Error("ExactScorer")
+	}
+	if s := SemanticScorer("The capital is Paris.", "paris is the capital"); math.Abs(s-1) > 1e-9 {
+		t.Errorf("reordered score = %v, want 1", s)
+	}
+	if s := SemanticScorer("the capital is Paris", "the capital is Lyon"); s < 0.7 || s > 0.8 {
+		t.Errorf("partial score = %v, want 0.75", s)
+	}
+	if s := SemanticScorer("yes", ""); s != 0 {
+		t.Errorf("empty score = %v", s)
+	}
+	if _, err := ScorerByName("bleu"); err == nil {
+		t.Error("unknown scorer accepted")
+	}
+}
+
+func TestRouterShadow(t *testing.T) {
+	var mu sync.Mutex
+	spans := map[string]protocol.TraceSpan{}
+	ctx := trace.WithExporter(context.Background(), trace.ExporterFunc(func(s protocol.TraceSpan) {
+		mu.Lock()
+		spans[s.Operation] = s
+		mu.Unlock()
+	}))
+
+	reg := echoRegistry()
+	reg.Register(NewEchoProvider("shadow", []string{"new-model"}, 10*time.Millisecond))
+	m := metrics.NewRegistry()
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""),
+		WithShadow(Shadow{Model: "new-model", Scorer: ExactScorer, Metrics: m}))
+
+	resp, err := router.Infer(ctx, protocol.InferRequest{
+		Model:    "echo-v1",
+		Messages: []protocol.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Provider != "echo" {
+		t.Errorf("caller got %s response", resp.Provider)
+	}
+	if err := router.WaitShadows(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	primary, shadow := spans["infermux.infer"], spans["infermux.shadow"]
+	if shadow.TraceID != primary.TraceID || shadow.ParentID != primary.SpanID {
+		t.Errorf("shadow span not linked: %+v / %+v", shadow, primary)
+	}
+	if primary.Attrs["shadow_span_id"] != shadow.SpanID {
+		t.Errorf("shadow_span_id = %v", primary.Attrs["shadow_span_id"])
+	}
+	if shadow.Attrs["provider"] != "shadow" || shadow.Attrs["agreement"] != 1.0 || shadow.Status != "ok" {
+		t.Errorf("shadow attrs = %v", shadow.Attrs)
+	}
+	if n := m.Counter("infermux_shadow_agreed_total", "model", "new-model").Value(); n != 1 {
+		t.Errorf("agreed = %d", n)
+	}
+	if n := m.Counter("infermux_shadow_requests_total", "model", "new-model", "result", "ok").Value(); n != 1 {
+		t.Errorf("requests = %d", n)
+	}
+}
+
+func TestRouterShadowFailureIsolated(t *testing.T) {
+	m := metrics.NewRegistry()
+	router := NewRouter(echoRegistry(), tokentrace.NewReporter("infermux", ""),
+		WithShadow(Shadow{Model: "missing", Provider: "missing", Metrics: m}))
+	if _, err := router.Infer(context.Background(), protocol.InferRequest{Model: "echo-v1"}); err != nil {
+		t.Fatalf("shadow failure reached caller: %v", err)
+	}
+	router.WaitShadows(context.Background())
+	if n := m.Counter("infermux_shadow_requests_total", "model", "missing", "result", "error").Value(); n != 1 {
+		t.Errorf("errors = %d", n)
+	}
+}
@@ -0,0 +1,101 @@
+package infermux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Streaming content types. Clients ask for a stream with an Accept header
+// naming one of them, or with ?stream=true (server-sent events).
+const (
+	ContentTypeSSE    = "text/event-stream"
+	ContentTypeNDJSON = "application/x-ndjson"
+)
+
+// wantsStream reports whether r asks for a streamed response, and in
+// which content type.
+func wantsStream(r *http.Request) (string, bool) {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, ContentTypeNDJSON):
+		return ContentTypeNDJSON, true
+	case strings.Contains(accept, ContentTypeSSE):
+		return ContentTypeSSE, true
+	}
+	if on, _ := strconv.ParseBool(r.URL.Query().Get("stream")); on {
+		return ContentTypeSSE, true
+	}
+	return "", false
+}
+
+// chunkWriter writes streamed chunks to an HTTP response, as SSE "data:"
+// events or newline-delimited JSON, flushing after each. With envelope
+// set each chunk is wrapped in a MIST message of type
+// infer.response.chunk. Headers are sent with the first chunk, so errors
+// before then can still be reported with a status code.
+type chunkWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	envelope    bool
+	started     bool
+}
+
+func (c *chunkWriter) write(chunk protocol.InferResponseChunk) error {
+	var v any = chunk
+	if c.envelope {
+		msg, err := protocol.New(protocol.SourceInferMux, protocol.TypeInferResponseChunk, chunk)
+		if err != nil {
+			return err
+		}
+		v = msg
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if !c.started {
+		c.started = true
+		c.w.Header().Set("Content-Type", c.contentType)
+		c.w.Header().Set("Cache-Control", "no-cache")
+		c.w.WriteHeader(http.StatusOK)
+	}
+	if c.contentType == ContentTypeSSE {
+		_, err = fmt.Fprintf(c.w, "data: %s\n\n", data)
+	} else {
+		_, err = fmt.Fprintf(c.w, "%s\n", data)
+	}
+	if err != nil {
+		return err
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// stream serves req as a streamed response. A failure after streaming has
+// begun is reported in a final chunk with Done and Error set.
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request, req protocol.InferRequest, contentType string, envelope bool) {
+	cw := &chunkWriter{w: w, contentType: contentType, envelope: envelope}
+	next := 0
+	_, err := h.router.InferStream(r.Context(), req, func(c protocol.InferResponseChunk) error {
+		next = c.Index + 1
+		return cw.write(c)
+	})
+	if err == nil {
+		return
+	}
+	if !cw.started {
+		writeInferError(w, r, err)
+		return
+	}
+	if r.Context().Err() == nil {
+		cw.write(protocol.InferResponseChunk{Index: next, Done: true, Error: err.Error()})
+	}
+}
@@ -0,0 +1,191 @@
+package infermux
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/trace"
+)
+
+func streamReq(prompt string) protocol.InferRequest {
+	return protocol.InferRequest{
+		Model:    "echo-v1",
+		Messages: []protocol.ChatMessage{{Role: "user", Content: prompt}},
+	}
+}
+
+func TestRouterInferStream(t *testing.T) {
+	var mu sync.Mutex
+	var span protocol.TraceSpan
+	ctx := trace.WithExporter(context.Background(), trace.ExporterFunc(func(s protocol.TraceSpan) {
+		mu.Lock()
+		span = s
+		mu.Unlock()
+	}))
+
+	var chunks []protocol.InferResponseChunk
+	resp, err := testRouter().InferStream(ctx, streamReq("one two three"), func(c protocol.InferResponseChunk) error {
+		chunks = append(chunks, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var content strings.Builder
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Errorf("chunk %d has index %d", i, c.Index)
+		}
+		content.WriteString(c.Delta)
+	}
+	if content.String() != resp.Content || resp.Content != "echo: one two three" {
+		t.Errorf("streamed %q, response %q", content.String(), resp.Content)
+	}
+	last := chunks[len(chunks)-1]
+	if !last.Done || last.Response == nil || last.Response.TokensOut != resp.TokensOut {
+		t.Errorf("final chunk = %+v", last)
+	}
+	if len(chunks) != 5 {
+		t.Errorf("got %d chunks, want 4 deltas and done", len(chunks))
+	}
+	if span.Attrs["stream"] != true || span.Attrs["chunks"] != 4 || span.Attrs["ttft_ms"] == nil {
+		t.Errorf("span attrs = %v", span.Attrs)
+	}
+}
+
+func TestRouterInferStreamNonStreamingProvider(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(struct{ Provider }{NewEchoProvider("plain", []string{"echo-v1"}, 0)})
+	router := NewRouter(reg, tokentrace.NewReporter("test", ""))
+
+	var chunks []protocol.InferResponseChunk
+	resp, err := router.InferStream(context.Background(), streamReq("one two"), func(c protocol.InferResponseChunk) error {
+		chunks = append(chunks, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].Delta != "echo: one two" || !chunks[1].Done || resp.Content != "echo: one two" {
+		t.Errorf("chunks = %+v", chunks)
+	}
+}
+
+func TestRouterInferStreamEmitError(t *testing.T) {
+	gone := errors.New("client gone")
+	n := 0
+	_, err := testRouter().InferStream(context.Background(), streamReq("a b c"), func(protocol.InferResponseChunk) error {
+		if n++; n == 2 {
+			return gone
+		}
+		return nil
+	})
+	if !errors.Is(err, gone) || n != 2 {
+		t.Errorf("err = %v after %d chunks", err, n)
+	}
+}
+
+func TestHandlerInferDirectSSE(t *testing.T) {
+	body, _ := json.Marshal(streamReq("hello world"))
+	req := httptest.NewRequest("POST", "/infer?stream=true", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	testHandler().InferDirect(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != ContentTypeSSE {
+		t.Fatalf("Content-Type = %q, body %s", ct, w.Body)
+	}
+	var content string
+	var done bool
+	for _, event := range strings.Split(strings.TrimSpace(w.Body.String()), "\n\n") {
+		var c protocol.InferResponseChunk
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &c); err != nil {
+			t.Fatalf("event %q: %v", event, err)
+		}
+		content += c.Delta
+		done = c.Done
+	}
+	if content != "echo: hello world" || !done {
+		t.Errorf("content = %q, done = %v", content, done)
+	}
+}
+
+func TestHandlerIngestNDJSON(t *testing.T) {
+	msg, _ := protocol.New("test", protocol.TypeInferRequest, streamReq("hi there"))
+	body, _ := json.Marshal(msg)
+	req := httptest.NewRequest("POST", "/mist", bytes.NewReader(body))
+	req.Header.Set("Accept", ContentTypeNDJSON)
+	w := httptest.NewRecorder()
+	testHandler().Ingest(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != ContentTypeNDJSON {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	sc := bufio.NewScanner(w.Body)
+	lines := 0
+	for sc.Scan() {
+		m, err := protocol.Unmarshal(sc.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != protocol.TypeInferResponseChunk {
+			t.Errorf("type = %s", m.Type)
+		}
+		lines++
+	}
+	if lines != 4 {
+		t.Errorf("lines = %d, want 3 deltas and done", lines)
+	}
+}
+
+func TestHandlerStreamErrorBeforeStart(t *testing.T) {
+	body, _ := json.Marshal(protocol.InferRequest{Model: "missing"})
+	req := httptest.NewRequest("POST", "/infer", bytes.NewReader(body))
+	req.Header.Set("Accept", ContentTypeSSE)
+	w := httptest.NewRecorder()
+	testHandler().InferDirect(w, req)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", w.Code)
+	}
+}
+
+func TestCachedProviderStream(t *testing.T) {
+	inner := &countingProvider{EchoProvider: NewEchoProvider("echo", []string{"echo-v1"}, 0)}
+	cp, err := NewCachedProvider(inner, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	reg.Register(cp)
+	router := NewRouter(reg, tokentrace.NewReporter("infermux", ""))
+
+	collect := func() []string {
+		var deltas []string
+		_, err := router.InferStream(context.Background(), streamReq("x y"), func(c protocol.InferResponseChunk) error {
+			if !c.Done {
+				deltas = append(deltas, c.Delta)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deltas
+	}
+	if miss := collect(); len(miss) != 3 {
+		t.Errorf("miss streamed %q", miss)
+	}
+	if hit := collect(); len(hit) != 1 || hit[0] != "echo: x y" || cp.Hits() != 1 {
+		t.Errorf("hit streamed %q, hits = %d", hit, cp.Hits())
+	}
+}
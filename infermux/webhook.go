@@ -0,0 +1,338 @@
+package infermux
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/greynewell/mist-go/circuitbreaker"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/retry"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// Webhook event types.
+const (
+	EventProviderRegistered = "provider.registered" // a provider was added to the registry
+	EventBreakerTripped     = "breaker.tripped"     // a provider's circuit breaker opened
+	EventQuotaExhausted     = "quota.exhausted"     // a cost budget reached its limit
+	EventGuardrailViolation = "guardrail.violation" // a request or response broke a guardrail
+)
+
+// Headers set on every webhook delivery.
+const (
+	HeaderWebhookEvent     = "X-Mist-Event"
+	HeaderWebhookDelivery  = "X-Mist-Delivery"
+	HeaderWebhookTimestamp = "X-Mist-Timestamp"
+	HeaderWebhookSignature = "X-Mist-Signature"
+)
+
+// Webhook is an endpoint notified of InferMux events.
+type Webhook struct {
+	URL string `json:"url"`
+
+	// Secret signs each payload. Deliveries carry
+	// X-Mist-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">,
+	// where timestamp is the X-Mist-Timestamp header (Unix seconds).
+	// Receivers check it with VerifyWebhook.
+	Secret string `json:"secret,omitempty"`
+
+	// Events limits delivery to these event types. Empty means all.
+	Events []string `json:"events,omitempty"`
+}
+
+// WebhookEvent is the JSON body POSTed to webhooks.
+type WebhookEvent struct {
+	ID       string         `json:"id"`
+	Type     string         `json:"type"`
+	Time     time.Time      `json:"time"`
+	Provider string         `json:"provider,omitempty"`
+	Tenant   string         `json:"tenant,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Webhooks delivers events to configured webhooks in the background,
+// retrying failed deliveries. Deliveries wait in a bounded queue drained
+// by a fixed pool of workers; a delivery fired while the queue is full
+// is dropped and counted. It is safe for concurrent use.
+//
+//	hooks := infermux.NewWebhooks(cfg.Webhooks, infermux.WithWebhookMetrics(reg))
+//	defer hooks.Close(ctx)
+//	router := infermux.NewRouter(reg, reporter, infermux.WithBudgets(budgets), infermux.WithWebhooks(hooks))
+type Webhooks struct {
+	hooks     []Webhook
+	client    *http.Client
+	policy    retry.Policy
+	metrics   *metrics.Registry
+	queueSize int
+	workers   int
+
+	mu     sync.RWMutex // guards closed and sends on queue
+	closed bool
+	queue  chan webhookDelivery
+	wg     sync.WaitGroup
+	ctx    context.Context // cancelled when Close gives up
+	cancel context.CancelFunc
+
+	dropped atomic.Int64
+}
+
+// webhookDelivery is one event bound for one webhook.
+type webhookDelivery struct {
+	hook Webhook
+	ev   WebhookEvent
+	body []byte
+}
+
+// WebhookOption configures Webhooks.
+type WebhookOption func(*Webhooks)
+
+// WithWebhookRetry sets the retry policy for each delivery. Default
+// retry.DefaultPolicy. Responses other than 5xx and 429 are not retried.
+func WithWebhookRetry(p retry.Policy) WebhookOption {
+	return func(w *Webhooks) { w.policy = p }
+}
+
+// WithWebhookClient sets the HTTP client. Default a client with a 10s
+// timeout.
+func WithWebhookClient(c *http.Client) WebhookOption {
+	return func(w *Webhooks) { w.client = c }
+}
+
+// WithWebhookQueue sets how many deliveries may wait to be sent and how
+// many workers send them. Default 1024 deliveries and 4 workers.
+func WithWebhookQueue(size, workers int) WebhookOption {
+	return func(w *Webhooks) {
+		w.queueSize = size
+		w.workers = workers
+	}
+}
+
+// WithWebhookMetrics records infermux_webhook_deliveries_total{event,result}
+// and infermux_webhook_attempts_total{event} in reg.
+func WithWebhookMetrics(reg *metrics.Registry) WebhookOption {
+	return func(w *Webhooks) { w.metrics = reg }
+}
+
+// NewWebhooks creates a dispatcher for hooks and starts its workers.
+func NewWebhooks(hooks []Webhook, opts ...WebhookOption) *Webhooks {
+	w := &Webhooks{
+		hooks:     hooks,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		policy:    retry.DefaultPolicy,
+		queueSize: 1024,
+		workers:   4,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.queue = make(chan webhookDelivery, max(w.queueSize, 1))
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	for range max(w.workers, 1) {
+		w.wg.Add(1)
+		go w.run()
+	}
+	return w
+}
+
+// Fire queues ev for every webhook subscribed to its type without
+// blocking. ID and Time are filled in if empty. Events fired after Close,
+// or while the queue is full, are dropped.
+func (w *Webhooks) Fire(ev WebhookEvent) {
+	if ev.ID == "" {
+		ev.ID = trace.NewID()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		w.count(ev.Type, "encode_failed")
+		return
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.count(ev.Type, "closed")
+		return
+	}
+	for _, h := range w.hooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, ev.Type) {
+			continue
+		}
+		select {
+		case w.queue <- webhookDelivery{hook: h, ev: ev, body: body}:
+		default:
+			w.dropped.Add(1)
+			w.count(ev.Type, "queue_full")
+		}
+	}
+}
+
+// run sends queued deliveries until the queue is closed and drained.
+// Once Close gives up, the remaining deliveries are dropped.
+func (w *Webhooks) run() {
+	defer w.wg.Done()
+	for d := range w.queue {
+		if w.ctx.Err() != nil {
+			w.dropped.Add(1)
+			w.count(d.ev.Type, "shutdown")
+			continue
+		}
+		err := retry.DoAuto(w.ctx, w.policy, func(ctx context.Context) error {
+			return w.deliver(ctx, d.hook, d.ev, d.body)
+		})
+		switch {
+		case err == nil:
+			w.count(d.ev.Type, "ok")
+		case w.ctx.Err() != nil:
+			w.dropped.Add(1)
+			w.count(d.ev.Type, "shutdown")
+		default:
+			w.count(d.ev.Type, "failed")
+		}
+	}
+}
+
+// deliver makes one delivery attempt.
+func (w *Webhooks) deliver(ctx context.Context, h Webhook, ev WebhookEvent, body []byte) error {
+	if w.metrics != nil {
+		w.metrics.Counter("infermux_webhook_attempts_total", "event", ev.Type).Inc()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return misterrors.Wrap(misterrors.CodeValidation, err, "infermux: webhook")
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookEvent, ev.Type)
+	req.Header.Set(HeaderWebhookDelivery, ev.ID)
+	req.Header.Set(HeaderWebhookTimestamp, ts)
+	if h.Secret != "" {
+		req.Header.Set(HeaderWebhookSignature, SignWebhook(h.Secret, ts, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return misterrors.Wrap(misterrors.CodeTransport, err, "infermux: webhook")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return misterrors.Newf(misterrors.CodeRateLimit, "infermux: webhook %s: status %d", h.URL, resp.StatusCode)
+	case resp.StatusCode >= 500:
+		return misterrors.Newf(misterrors.CodeUnavailable, "infermux: webhook %s: status %d", h.URL, resp.StatusCode)
+	}
+	return misterrors.Newf(misterrors.CodeValidation, "infermux: webhook %s: status %d", h.URL, resp.StatusCode)
+}
+
+// Dropped returns the number of deliveries dropped because the queue
+// was full or Close gave up on them.
+func (w *Webhooks) Dropped() int64 { return w.dropped.Load() }
+
+func (w *Webhooks) count(event, result string) {
+	if w.metrics != nil {
+		w.metrics.Counter("infermux_webhook_deliveries_total", "event", event, "result", result).Inc()
+	}
+}
+
+// Close stops accepting events and waits for queued deliveries. If ctx
+// ends first, in-flight retries are cancelled, the remaining deliveries
+// are dropped, and the context error is returned. Close is safe to call
+// more than once.
+func (w *Webhooks) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		before := w.Dropped()
+		w.cancel()
+		<-done
+		return misterrors.Wrapf(misterrors.CodeTimeout, ctx.Err(),
+			"infermux: webhooks: dropped %d pending deliveries", w.Dropped()-before)
+	}
+}
+
+// BreakerHook returns an OnStateChange function for provider's circuit
+// breaker that fires EventBreakerTripped when it opens.
+func (w *Webhooks) BreakerHook(provider string) func(from, to circuitbreaker.State) {
+	return func(from, to circuitbreaker.State) {
+		if to != circuitbreaker.Open {
+			return
+		}
+		w.Fire(WebhookEvent{
+			Type:     EventBreakerTripped,
+			Provider: provider,
+			Data:     map[string]any{"from": from.String()},
+		})
+	}
+}
+
+// budgetExhausted fires EventQuotaExhausted when b reaches its limit.
+func (w *Webhooks) budgetExhausted(b Budget, spent float64) {
+	w.Fire(WebhookEvent{
+		Type:     EventQuotaExhausted,
+		Provider: b.Provider,
+		Tenant:   b.Tenant,
+		Data: map[string]any{
+			"budget":    b.Name,
+			"period":    b.Period,
+			"spent_usd": spent,
+			"limit_usd": b.LimitUSD,
+			"blocking":  b.Block,
+		},
+	})
+}
+
+// SignWebhook returns the X-Mist-Signature value for body sent at
+// timestamp (Unix seconds, as in X-Mist-Timestamp).
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature is valid for body and
+// timestamp, and the timestamp is within maxAge of now, guarding against
+// replayed deliveries. A maxAge of zero skips the age check.
+func VerifyWebhook(secret, timestamp, signature string, body []byte, maxAge time.Duration) bool {
+	if maxAge > 0 {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return false
+		}
+		if age := time.Since(time.Unix(sec, 0)); age > maxAge || age < -maxAge {
+			return false
+		}
+	}
+	return hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body)))
+}
@@ -0,0 +1,243 @@
+package infermux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/circuitbreaker"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/retry"
+	"github.com/greynewell/mist-go/tokentrace"
+)
+
+// webhookSink records deliveries, checking signatures if secret is set,
+// and fails the first failures requests with status.
+type webhookSink struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	failures int
+	status   int
+	events   []WebhookEvent
+}
+
+func (s *webhookSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if s.secret != "" && !VerifyWebhook(s.secret, r.Header.Get(HeaderWebhookTimestamp), r.Header.Get(HeaderWebhookSignature), body, time.Minute) {
+		s.t.Errorf("bad signature on %s", r.Header.Get(HeaderWebhookEvent))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(s.status)
+		return
+	}
+	var ev WebhookEvent
+	json.Unmarshal(body, &ev)
+	s.events = append(s.events, ev)
+}
+
+func (s *webhookSink) types() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, ev := range s.events {
+		out = append(out, ev.Type)
+	}
+	return out
+}
+
+var fastRetry = retry.Policy{MaxAttempts: 3, InitialWait: time.Millisecond, MaxWait: time.Millisecond, Multiplier: 1}
+
+func TestWebhooksRetryAndMetrics(t *testing.T) {
+	sink := &webhookSink{t: t, secret: "s3cret", failures: 2, status: http.StatusBadGateway}
+	ts := httptest.NewServer(sink)
+	defer ts.Close()
+
+	reg := metrics.NewRegistry()
+	hooks := NewWebhooks([]Webhook{{URL: ts.URL, Secret: "s3cret"}}, WithWebhookRetry(fastRetry), WithWebhookMetrics(reg))
+	hooks.Fire(WebhookEvent{Type: EventGuardrailViolation, Tenant: "acme", Data: map[string]any{"rule": "pii"}})
+	if err := hooks.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.events) != 1 || sink.events[0].Tenant != "acme" || sink.events[0].ID == "" {
+		t.Fatalf("events = %+v", sink.events)
+	}
+	if n := reg.Counter("infermux_webhook_attempts_total", "event", EventGuardrailViolation).Value(); n != 3 {
+		t.Errorf("attempts = %d, want 3", n)
+	}
+	if n := reg.Counter("infermux_webhook_deliveries_total", "event", EventGuardrailViolation, "result", "ok").Value(); n != 1 {
+		t.Errorf("delivered = %d", n)
+	}
+}
+
+func TestWebhooksClientErrorNotRetried(t *testing.T) {
+	sink := &webhookSink{t: t, failures: 5, status: http.StatusBadRequest}
+	ts := httptest.NewServer(sink)
+	defer ts.Close()
+
+	reg := metrics.NewRegistry()
+	hooks := NewWebhooks([]Webhook{{URL: ts.URL}}, WithWebhookRetry(fastRetry), WithWebhookMetrics(reg))
+	hooks.Fire(WebhookEvent{Type: EventBreakerTripped})
+	hooks.Close(context.Background())
+
+	if n := reg.Counter("infermux_webhook_attempts_total", "event", EventBreakerTripped).Value(); n != 1 {
+		t.Errorf("attempts = %d, want 1", n)
+	}
+	if n := reg.Counter("infermux_webhook_deliveries_total", "event", EventBreakerTripped, "result", "failed").Value(); n != 1 {
+		t.Errorf("failed = %d", n)
+	}
+}
+
+func TestWebhooksRouterEvents(t *testing.T) {
+	all := &webhookSink{t: t}
+	quota := &webhookSink{t: t}
+	tsAll, tsQuota := httptest.NewServer(all), httptest.NewServer(quota)
+	defer tsAll.Close()
+	defer tsQuota.Close()
+
+	hooks := NewWebhooks([]Webhook{
+		{URL: tsAll.URL},
+		{URL: tsQuota.URL, Events: []string{EventQuotaExhausted}},
+	}, WithWebhookRetry(fastRetry))
+
+	reg := NewRegistry()
+	budgets := NewBudgetTracker([]Budget{{Name: "acme", Tenant: "acme", LimitUSD: 1}})
+	router := NewRouter(reg, tokentrace.NewReporter("test", ""),
+		WithBudgets(budgets),
+		WithBreakers(circuitbreaker.Config{Threshold: 1}),
+		WithPolicy(Policy{BannedParams: []string{"seed"}}),
+		WithWebhooks(hooks))
+
+	reg.Register(NewEchoProvider("echo", []string{"echo-1"}, 0))
+	reg.Register(&failingProvider{
+		EchoProvider: NewEchoProvider("down", []string{"down-1"}, 0),
+		err:          misterrors.New(misterrors.CodeUnavailable, "overloaded"),
+	})
+	budgets.Record("echo", "acme", 2)
+
+	ctx := context.Background()
+	router.Infer(ctx, protocol.InferRequest{Model: "down-1"})
+	if _, err := router.Infer(ctx, protocol.InferRequest{Model: "down-1"}); !errors.Is(err, circuitbreaker.ErrOpen) {
+		t.Errorf("second request to a tripped provider: err = %v, want ErrOpen", err)
+	}
+	router.Infer(ctx, protocol.InferRequest{Model: "echo-1", Params: map[string]any{"seed": 1}})
+	hooks.Close(ctx)
+
+	got := map[string]bool{}
+	for _, typ := range all.types() {
+		got[typ] = true
+	}
+	for _, want := range []string{EventProviderRegistered, EventQuotaExhausted, EventBreakerTripped, EventGuardrailViolation} {
+		if !got[want] {
+			t.Errorf("missing %s in %v", want, all.types())
+		}
+	}
+	if types := quota.types(); len(types) != 1 || types[0] != EventQuotaExhausted {
+		t.Errorf("filtered hook got %v", types)
+	}
+	for _, ev := range quota.events {
+		if ev.Tenant != "acme" || ev.Data["budget"] != "acme" {
+			t.Errorf("quota event = %+v", ev)
+		}
+	}
+}
+
+func TestRouterBreakerIgnoresBadRequests(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(&failingProvider{
+		EchoProvider: NewEchoProvider("strict", []string{"strict-1"}, 0),
+		err:          misterrors.New(misterrors.CodeValidation, "bad request"),
+	})
+	router := NewRouter(reg, tokentrace.NewReporter("test", ""), WithBreakers(circuitbreaker.Config{Threshold: 1}))
+
+	for range 3 {
+		_, err := router.Infer(context.Background(), protocol.InferRequest{Model: "strict-1"})
+		if misterrors.Code(err) != misterrors.CodeValidation {
+			t.Fatalf("err = %v, want the provider's validation error", err)
+		}
+	}
+}
+
+func TestWebhooksFireAfterClose(t *testing.T) {
+	sink := &webhookSink{t: t}
+	ts := httptest.NewServer(sink)
+	defer ts.Close()
+
+	reg := metrics.NewRegistry()
+	hooks := NewWebhooks([]Webhook{{URL: ts.URL}}, WithWebhookMetrics(reg))
+	hooks.Close(context.Background())
+	hooks.Fire(WebhookEvent{Type: EventBreakerTripped})
+
+	if types := sink.types(); len(types) != 0 {
+		t.Errorf("delivered after Close: %v", types)
+	}
+	if n := reg.Counter("infermux_webhook_deliveries_total", "event", EventBreakerTripped, "result", "closed").Value(); n != 1 {
+		t.Errorf("closed = %d, want 1", n)
+	}
+}
+
+func TestWebhooksQueueFullAndCloseCancels(t *testing.T) {
+	started := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	reg := metrics.NewRegistry()
+	slow := retry.Policy{MaxAttempts: 5, InitialWait: time.Hour, MaxWait: time.Hour, Multiplier: 1}
+	hooks := NewWebhooks([]Webhook{{URL: ts.URL}}, WithWebhookRetry(slow), WithWebhookQueue(1, 1), WithWebhookMetrics(reg))
+
+	// The only worker is waiting to retry the first event, the second
+	// fills the queue, and the third is dropped.
+	hooks.Fire(WebhookEvent{Type: EventBreakerTripped})
+	<-started
+	hooks.Fire(WebhookEvent{Type: EventBreakerTripped})
+	hooks.Fire(WebhookEvent{Type: EventBreakerTripped})
+	if n := reg.Counter("infermux_webhook_deliveries_total", "event", EventBreakerTripped, "result", "queue_full").Value(); n != 1 || hooks.Dropped() != 1 {
+		t.Errorf("queue_full = %d, dropped = %d, want 1", n, hooks.Dropped())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := hooks.Close(ctx); misterrors.Code(err) != misterrors.CodeTimeout {
+		t.Errorf("Close = %v, want timeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Close took %v; the pending retry was not cancelled", d)
+	}
+	if n := reg.Counter("infermux_webhook_deliveries_total", "event", EventBreakerTripped, "result", "shutdown").Value(); n != 2 || hooks.Dropped() != 3 {
+		t.Errorf("shutdown = %d, dropped = %d, want 2 and 3", n, hooks.Dropped())
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"type":"x"}`)
+	ts := "1700000000"
+	sig := SignWebhook("k", ts, body)
+	if !VerifyWebhook("k", ts, sig, body, 0) {
+		t.Error("valid signature rejected")
+	}
+	if VerifyWebhook("other", ts, sig, body, 0) || VerifyWebhook("k", ts, sig, []byte("{}"), 0) {
+		t.Error("forged signature accepted")
+	}
+	if VerifyWebhook("k", ts, sig, body, time.Minute) {
+		t.Error("stale timestamp accepted")
+	}
+}
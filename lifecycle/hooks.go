@@ -0,0 +1,192 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// hook is a registered shutdown function. Hooks registered with
+// OnShutdown are unnamed: their errors are returned as is, as they were
+// before hooks had names.
+type hook struct {
+	name    string
+	unnamed bool
+	fn      func(ctx context.Context) error
+	timeout time.Duration
+	group   string
+}
+
+// HookOption configures a shutdown hook.
+type HookOption func(*hook)
+
+// HookTimeout bounds a single hook. Its ctx is cancelled after d, and
+// shutdown moves on without waiting for it further. The overall
+// WithShutdownTimeout still applies.
+func HookTimeout(d time.Duration) HookOption {
+	return func(h *hook) { h.timeout = d }
+}
+
+// InGroup places the hook in a named parallel group. Hooks in the same
+// group run concurrently, at the position of the group's first
+// registration in the LIFO order. Use it for independent cleanups, such
+// as closing unrelated connections.
+func InGroup(name string) HookOption {
+	return func(h *hook) { h.group = name }
+}
+
+// OnShutdownHook registers a named shutdown hook. The name appears in
+// the hook's log line and in its error. fn's ctx is cancelled when the
+// hook's timeout expires.
+//
+//	lifecycle.OnShutdownHook(ctx, "flush-traces", exporter.Flush,
+//	    lifecycle.HookTimeout(2*time.Second))
+//	lifecycle.OnShutdownHook(ctx, "close-db", db.Close, lifecycle.InGroup("conns"))
+//	lifecycle.OnShutdownHook(ctx, "close-cache", cache.Close, lifecycle.InGroup("conns"))
+func OnShutdownHook(ctx context.Context, name string, fn func(ctx context.Context) error, opts ...HookOption) {
+	addHook(ctx, name, fn, opts)
+}
+
+func addHook(ctx context.Context, name string, fn func(ctx context.Context) error, opts []HookOption) {
+	st := stateFromContext(ctx)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	h := &hook{name: name, fn: fn}
+	if name == "" {
+		h.name, h.unnamed = fmt.Sprintf("hook-%d", len(st.hooks)+1), true
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	st.hooks = append(st.hooks, h)
+}
+
+// stages orders hooks for execution: reverse registration order, with
+// each group's members gathered into one stage at the position of the
+// group's first registration.
+func stages(hooks []*hook) [][]*hook {
+	var out [][]*hook
+	groups := make(map[string]int)
+	for _, h := range hooks {
+		if h.group == "" {
+			out = append(out, []*hook{h})
+			continue
+		}
+		if i, ok := groups[h.group]; ok {
+			out[i] = append(out[i], h)
+			continue
+		}
+		groups[h.group] = len(out)
+		out = append(out, []*hook{h})
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// shutdown runs hooks in reverse order with timeout.
+func (s *state) shutdown() error {
+	s.mu.Lock()
+	hooks := make([]*hook, len(s.hooks))
+	copy(hooks, s.hooks)
+	s.mu.Unlock()
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutTTL)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		var firstErr error
+		for _, stage := range stages(hooks) {
+			if err := runStage(ctx, stage); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		done <- firstErr
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return misterrors.Newf(misterrors.CodeTimeout, "lifecycle: shutdown timeout after %v", s.shutTTL)
+	}
+}
+
+// runStage runs a stage's hooks concurrently and returns the first error
+// in registration order.
+func runStage(ctx context.Context, stage []*hook) error {
+	if len(stage) == 1 {
+		return runHook(ctx, stage[0])
+	}
+	errs := make([]error, len(stage))
+	var wg sync.WaitGroup
+	for i, h := range stage {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = runHook(ctx, h)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runHook runs one hook under its own timeout and logs its outcome.
+func runHook(ctx context.Context, h *hook) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- misterrors.Recovered(r)
+			}
+		}()
+		done <- h.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = misterrors.Newf(misterrors.CodeTimeout, "timed out after %v", time.Since(start).Round(time.Millisecond))
+	}
+	elapsed := time.Since(start)
+
+	attrs := []any{"hook", h.name, "duration", elapsed}
+	if h.group != "" {
+		attrs = append(attrs, "group", h.group)
+	}
+	if err != nil {
+		slog.Default().Error("lifecycle: shutdown hook failed", append(attrs, "error", err.Error())...)
+		if h.unnamed {
+			return err
+		}
+		return fmt.Errorf("lifecycle: hook %q: %w", h.name, err)
+	}
+	slog.Default().Info("lifecycle: shutdown hook", attrs...)
+	return nil
+}
@@ -0,0 +1,108 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHookTimeout(t *testing.T) {
+	var ran atomic.Bool
+	start := time.Now()
+	err := Run(func(ctx context.Context) error {
+		OnShutdown(ctx, func() error {
+			ran.Store(true)
+			return nil
+		})
+		OnShutdownHook(ctx, "stuck", func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Second) // ignores cancellation
+			return nil
+		}, HookTimeout(30*time.Millisecond))
+		return nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), `"stuck"`) {
+		t.Fatalf("err = %v, want named hook timeout", err)
+	}
+	if !ran.Load() {
+		t.Error("hook after the stuck one should still run")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %v; per-hook timeout not applied", elapsed)
+	}
+}
+
+func TestHookGroupsRunInParallel(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	start := time.Now()
+	err := Run(func(ctx context.Context) error {
+		OnShutdownHook(ctx, "first", record("first"))
+		OnShutdownHook(ctx, "db", record("db"), InGroup("conns"))
+		OnShutdownHook(ctx, "cache", record("cache"), InGroup("conns"))
+		OnShutdownHook(ctx, "queue", record("queue"), InGroup("conns"))
+		OnShutdownHook(ctx, "last", record("last"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three stages of ~50ms (last, the conns group, first) rather than five.
+	if elapsed := time.Since(start); elapsed > 220*time.Millisecond {
+		t.Errorf("shutdown took %v; group did not run in parallel", elapsed)
+	}
+	if len(order) != 5 || order[0] != "last" || order[4] != "first" {
+		t.Errorf("order = %v", order)
+	}
+}
+
+func TestHookErrorNamed(t *testing.T) {
+	want := errors.New("flush failed")
+	rep, err := RunReport(func(ctx context.Context) error {
+		OnShutdownHook(ctx, "flush", func(context.Context) error { return want })
+		return nil
+	})
+	if !errors.Is(err, want) || !strings.Contains(err.Error(), `hook "flush"`) {
+		t.Errorf("err = %v", err)
+	}
+	if rep.Reason != ReasonHookFailure {
+		t.Errorf("reason = %s", rep.Reason)
+	}
+}
+
+func TestOnShutdownErrorUnwrapped(t *testing.T) {
+	want := errors.New("cleanup failed")
+	err := Run(func(ctx context.Context) error {
+		OnShutdown(ctx, func() error { return want })
+		return nil
+	})
+	if err != want {
+		t.Errorf("err = %v, want the hook's own error", err)
+	}
+}
+
+func TestHookPanicRecovered(t *testing.T) {
+	err := Run(func(ctx context.Context) error {
+		OnShutdownHook(ctx, "explode", func(context.Context) error { panic("boom") })
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "panic: boom") {
+		t.Errorf("err = %v", err)
+	}
+}
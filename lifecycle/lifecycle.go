@@ -5,28 +5,31 @@
 // Every MIST tool should wrap its main logic in lifecycle.Run:
 //
 //	func main() {
-//	    err := lifecycle.Run(func(ctx context.Context) error {
+//	    lifecycle.Main(func(ctx context.Context) error {
 //	        dg := lifecycle.DrainGroup(ctx)
 //	        lifecycle.OnShutdown(ctx, func() error {
 //	            return server.Close()
 //	        })
 //	        return server.ListenAndServe()
 //	    })
-//	    if err != nil { os.Exit(1) }
 //	}
 //
 // On SIGTERM/SIGINT, the context is cancelled, drain groups are awaited
-// (with timeout), then shutdown hooks run in reverse order.
+// (with timeout), then shutdown hooks run in reverse order. Named hooks
+// (OnShutdownHook) can carry their own timeout and join parallel groups.
 package lifecycle
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
 )
 
 type contextKey struct{}
@@ -34,10 +37,11 @@ type contextKey struct{}
 // state holds the lifecycle state for a single Run invocation.
 type state struct {
 	mu       sync.Mutex
-	hooks    []func() error
+	hooks    []*hook
 	drains   []*sync.WaitGroup
 	drainTTL time.Duration
 	shutTTL  time.Duration
+	registry *metrics.Registry
 }
 
 // Option configures lifecycle behavior.
@@ -61,8 +65,19 @@ func WithShutdownTimeout(d time.Duration) Option {
 //  2. Runs shutdown hooks in reverse order (with timeout)
 //  3. Returns the first error encountered
 //
-// Panics in fn are recovered and returned as errors.
-func Run(fn func(ctx context.Context) error, opts ...Option) (retErr error) {
+// Panics in fn are recovered and returned as errors. After a signal, fn
+// returning the context's error (context.Canceled) counts as a clean
+// exit.
+func Run(fn func(ctx context.Context) error, opts ...Option) error {
+	_, err := RunReport(fn, opts...)
+	return err
+}
+
+// RunReport is Run, additionally returning a Report of why and how the
+// shutdown happened. The report is also logged via slog and, with
+// WithRegistry, counted in shutdowns_total.
+func RunReport(fn func(ctx context.Context) error, opts ...Option) (rep Report, retErr error) {
+	start := time.Now()
 	st := &state{
 		drainTTL: 15 * time.Second,
 		shutTTL:  10 * time.Second,
@@ -87,7 +102,7 @@ func Run(fn func(ctx context.Context) error, opts ...Option) (retErr error) {
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				done <- fmt.Errorf("panic: %v", r)
+				done <- misterrors.Recovered(r)
 			}
 		}()
 		done <- fn(ctx)
@@ -99,19 +114,21 @@ func Run(fn func(ctx context.Context) error, opts ...Option) (retErr error) {
 		cancel()
 	case sig := <-sigCh:
 		cancel()
+		rep.Signal = sig.String()
 		// Wait briefly for main to notice cancellation.
 		select {
-		case fnErr := <-done:
-			if retErr == nil {
-				retErr = fnErr
-			}
+		case retErr = <-done:
 		case <-time.After(st.drainTTL):
 		}
-		_ = sig
+		if errors.Is(retErr, context.Canceled) {
+			retErr = nil
+		}
 	}
+	rep.MainErr = retErr
 
 	// Phase 1: Drain in-flight work.
 	if err := st.drain(); err != nil {
+		rep.DrainErr = err
 		if retErr == nil {
 			retErr = err
 		}
@@ -119,24 +136,24 @@ func Run(fn func(ctx context.Context) error, opts ...Option) (retErr error) {
 
 	// Phase 2: Run shutdown hooks (reverse order).
 	if err := st.shutdown(); err != nil {
+		rep.HookErr = err
 		if retErr == nil {
 			retErr = err
 		}
 	}
 
-	return retErr
+	rep.Err = retErr
+	rep.Reason = rep.reason()
+	rep.Duration = time.Since(start)
+	st.record(rep)
+	return rep, retErr
 }
 
 // OnShutdown registers a function to run during shutdown. Hooks run in
 // reverse registration order (LIFO). The context must come from Run.
+// Use OnShutdownHook for a named hook with its own timeout.
 func OnShutdown(ctx context.Context, fn func() error) {
-	st := stateFromContext(ctx)
-	if st == nil {
-		return
-	}
-	st.mu.Lock()
-	defer st.mu.Unlock()
-	st.hooks = append(st.hooks, fn)
+	addHook(ctx, "", func(context.Context) error { return fn() }, nil)
 }
 
 // DrainGroup returns a WaitGroup that lifecycle.Run will wait on before
@@ -183,42 +200,7 @@ func (s *state) drain() error {
 	case <-done:
 		return nil
 	case <-time.After(s.drainTTL):
-		return fmt.Errorf("lifecycle: drain timeout after %v", s.drainTTL)
-	}
-}
-
-// shutdown runs hooks in reverse order with timeout.
-func (s *state) shutdown() error {
-	s.mu.Lock()
-	hooks := make([]func() error, len(s.hooks))
-	copy(hooks, s.hooks)
-	s.mu.Unlock()
-
-	if len(hooks) == 0 {
-		return nil
-	}
-
-	type hookResult struct {
-		err error
-	}
-
-	done := make(chan hookResult, 1)
-	go func() {
-		var firstErr error
-		// Run in reverse order (LIFO).
-		for i := len(hooks) - 1; i >= 0; i-- {
-			if err := hooks[i](); err != nil && firstErr == nil {
-				firstErr = err
-			}
-		}
-		done <- hookResult{err: firstErr}
-	}()
-
-	select {
-	case result := <-done:
-		return result.err
-	case <-time.After(s.shutTTL):
-		return fmt.Errorf("lifecycle: shutdown timeout after %v", s.shutTTL)
+		return misterrors.Newf(misterrors.CodeTimeout, "lifecycle: drain timeout after %v", s.drainTTL)
 	}
 }
 
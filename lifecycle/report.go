@@ -0,0 +1,102 @@
+package lifecycle
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+)
+
+// Reason is the primary cause of a shutdown.
+type Reason string
+
+const (
+	ReasonCompleted    Reason = "completed"     // main returned nil
+	ReasonSignal       Reason = "signal"        // SIGINT or SIGTERM, clean shutdown
+	ReasonError        Reason = "error"         // main returned an error or panicked
+	ReasonDrainTimeout Reason = "drain_timeout" // in-flight work did not drain in time
+	ReasonHookFailure  Reason = "hook_failure"  // a shutdown hook failed or timed out
+)
+
+// Report describes how a Run ended.
+type Report struct {
+	// Reason is the most significant cause, in the order error, drain
+	// timeout, hook failure, signal, completed.
+	Reason Reason
+
+	// Signal is the signal that started the shutdown, if any.
+	Signal string
+
+	MainErr  error // returned by main, including recovered panics
+	DrainErr error // drain timeout
+	HookErr  error // first shutdown hook error, or hook timeout
+
+	// Err is the error Run returns: the first of MainErr, DrainErr, HookErr.
+	Err error
+
+	// Duration is the total time from start to the end of shutdown.
+	Duration time.Duration
+}
+
+func (r Report) reason() Reason {
+	switch {
+	case r.MainErr != nil:
+		return ReasonError
+	case r.DrainErr != nil:
+		return ReasonDrainTimeout
+	case r.HookErr != nil:
+		return ReasonHookFailure
+	case r.Signal != "":
+		return ReasonSignal
+	}
+	return ReasonCompleted
+}
+
+// ExitCode maps the outcome to a process exit code: 0 on a clean exit,
+// otherwise errors.ExitCode of Err's code.
+func (r Report) ExitCode() int {
+	if r.Err == nil {
+		return 0
+	}
+	return misterrors.ExitCode(misterrors.Code(r.Err))
+}
+
+// WithRegistry counts shutdowns in reg as shutdowns_total{reason=...}.
+func WithRegistry(reg *metrics.Registry) Option {
+	return func(s *state) { s.registry = reg }
+}
+
+// record emits the final log line and metric for a shutdown.
+func (s *state) record(r Report) {
+	if s.registry != nil {
+		s.registry.Counter("shutdowns_total", "reason", string(r.Reason)).Inc()
+	}
+
+	attrs := []any{
+		"reason", r.Reason,
+		"duration", r.Duration,
+		"exit_code", r.ExitCode(),
+	}
+	if r.Signal != "" {
+		attrs = append(attrs, "signal", r.Signal)
+	}
+	if r.Err != nil {
+		attrs = append(attrs, "error", r.Err.Error())
+		slog.Default().Error("lifecycle: shutdown", attrs...)
+		return
+	}
+	slog.Default().Info("lifecycle: shutdown", attrs...)
+}
+
+// exit is os.Exit, replaced in tests.
+var exit = os.Exit
+
+// Main runs fn under RunReport and exits the process with the report's
+// exit code. It does not return.
+func Main(fn func(ctx context.Context) error, opts ...Option) {
+	rep, _ := RunReport(fn, opts...)
+	exit(rep.ExitCode())
+}
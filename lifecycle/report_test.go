@@ -0,0 +1,122 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+)
+
+func TestRunReportReasons(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context) error
+		opts []Option
+		want Reason
+	}{
+		{"completed", func(context.Context) error { return nil }, nil, ReasonCompleted},
+		{"error", func(context.Context) error { return errors.New("boom") }, nil, ReasonError},
+		{"panic", func(context.Context) error { panic("boom") }, nil, ReasonError},
+		{"drain", func(ctx context.Context) error {
+			DrainGroup(ctx).Add(1)
+			return nil
+		}, []Option{WithDrainTimeout(20 * time.Millisecond)}, ReasonDrainTimeout},
+		{"hook", func(ctx context.Context) error {
+			OnShutdown(ctx, func() error { return errors.New("close failed") })
+			return nil
+		}, nil, ReasonHookFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, err := RunReport(tt.fn, tt.opts...)
+			if rep.Reason != tt.want {
+				t.Errorf("reason = %s, want %s", rep.Reason, tt.want)
+			}
+			if err != rep.Err {
+				t.Errorf("err = %v, report.Err = %v", err, rep.Err)
+			}
+			if (tt.want == ReasonCompleted) != (rep.ExitCode() == 0) {
+				t.Errorf("exit code = %d", rep.ExitCode())
+			}
+		})
+	}
+}
+
+func TestRunReportSignal(t *testing.T) {
+	reg := metrics.NewRegistry()
+	started := make(chan struct{})
+	go func() {
+		<-started
+		time.Sleep(20 * time.Millisecond)
+		syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	}()
+
+	rep, err := RunReport(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	}, WithRegistry(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Reason != ReasonSignal || rep.Signal != syscall.SIGTERM.String() {
+		t.Errorf("report = %+v", rep)
+	}
+	if rep.ExitCode() != 0 {
+		t.Errorf("exit code = %d, want 0 for a clean signal shutdown", rep.ExitCode())
+	}
+	if got := reg.Counter("shutdowns_total", "reason", "signal").Value(); got != 1 {
+		t.Errorf("shutdowns_total{reason=signal} = %d", got)
+	}
+}
+
+func TestMainSignalCanceled(t *testing.T) {
+	var code int
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	started := make(chan struct{})
+	go func() {
+		<-started
+		time.Sleep(20 * time.Millisecond)
+		syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	}()
+
+	reg := metrics.NewRegistry()
+	code = -1
+	Main(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return fmt.Errorf("serve: %w", ctx.Err())
+	}, WithRegistry(reg))
+	if code != 0 {
+		t.Errorf("exit code = %d, want 0 for a main that returns ctx.Err() after a signal", code)
+	}
+	if got := reg.Counter("shutdowns_total", "reason", "signal").Value(); got != 1 {
+		t.Errorf("shutdowns_total{reason=signal} = %d", got)
+	}
+}
+
+func TestMainExitCode(t *testing.T) {
+	var code int
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	Main(func(context.Context) error {
+		return misterrors.New(misterrors.CodeValidation, "bad flag")
+	})
+	if code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+
+	Main(func(context.Context) error { return nil })
+	if code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+}
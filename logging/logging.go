@@ -1,20 +1,26 @@
 // Package logging provides structured, trace-aware logging for MIST tools.
 // Built on log/slog (standard library since Go 1.21), it automatically
-// includes trace_id and span_id from context in every log entry.
+// includes trace_id, span_id, and request_id from context in every log
+// entry.
 //
 // Usage:
 //
 //	log := logging.New("matchspec", logging.LevelInfo)
 //	log.Info(ctx, "eval started", "suite", "math", "tasks", 42)
 //	log.Error(ctx, "eval failed", "error", err)
+//
+// LogSpans additionally writes a record for every ended span, for
+// operators following traces through log aggregation.
 package logging
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 
+	"github.com/greynewell/mist-go/metadata"
 	"github.com/greynewell/mist-go/trace"
 )
 
@@ -94,6 +100,20 @@ func (l *Logger) SetLevel(level Level) {
 	l.level.Set(level)
 }
 
+// Level returns the current minimum log level.
+func (l *Logger) Level() Level {
+	return l.level.Level()
+}
+
+// ParseLevel parses a level name such as "debug", "INFO", or "warn+2".
+func ParseLevel(s string) (Level, error) {
+	var level Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("logging: %w", err)
+	}
+	return level, nil
+}
+
 // Debug logs at debug level.
 func (l *Logger) Debug(ctx context.Context, msg string, args ...any) {
 	l.log(ctx, LevelDebug, msg, args...)
@@ -123,6 +143,9 @@ func (l *Logger) log(ctx context.Context, level Level, msg string, args ...any)
 	if span := trace.FromContext(ctx); span != nil {
 		args = append(args, "trace_id", span.TraceID, "span_id", span.SpanID)
 	}
+	if id := metadata.Get(ctx, metadata.KeyRequestID); id != "" {
+		args = append(args, "request_id", id)
+	}
 
 	l.slog.Log(ctx, level, msg, args...)
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/greynewell/mist-go/metadata"
 	"github.com/greynewell/mist-go/trace"
 )
 
@@ -89,6 +90,18 @@ func TestTraceContext(t *testing.T) {
 	}
 }
 
+func TestRequestIDContext(t *testing.T) {
+	var buf bytes.Buffer
+	log := New("test", LevelInfo, WithWriter(&buf), WithFormat("json"))
+
+	ctx := metadata.With(context.Background(), metadata.KeyRequestID, "req-42")
+	log.Info(ctx, "handled")
+
+	if !strings.Contains(buf.String(), `"request_id":"req-42"`) {
+		t.Errorf("expected request_id in output: %s", buf.String())
+	}
+}
+
 func TestNoTraceContext(t *testing.T) {
 	var buf bytes.Buffer
 	log := New("test", LevelInfo, WithWriter(&buf), WithFormat("json"))
@@ -156,3 +169,27 @@ func TestSlogInterop(t *testing.T) {
 		t.Error("Slog() should return working slog.Logger")
 	}
 }
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]Level{
+		"debug":  LevelDebug,
+		"INFO":   LevelInfo,
+		"warn":   LevelWarn,
+		"error":  LevelError,
+		"info+2": LevelInfo + 2,
+	} {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose) succeeded")
+	}
+
+	log := New("test", LevelInfo)
+	log.SetLevel(LevelDebug)
+	if log.Level() != LevelDebug {
+		t.Errorf("Level = %v after SetLevel(debug)", log.Level())
+	}
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// SpanExporter returns a trace.Exporter that writes one log record per
+// ended span, so traces can be followed in plain log aggregation without
+// running TokenTrace. Each record carries trace_id, span_id, parent_id,
+// operation, duration_ms, status, and the listed span attributes.
+// Spans with status "error" are logged at warn level, others at info.
+func SpanExporter(l *Logger, attrs ...string) trace.Exporter {
+	return trace.ExporterFunc(func(s protocol.TraceSpan) {
+		level := LevelInfo
+		if s.Status == "error" {
+			level = LevelWarn
+		}
+		ctx := context.Background()
+		if !l.slog.Enabled(ctx, level) {
+			return
+		}
+
+		args := make([]slog.Attr, 0, 7+len(attrs))
+		args = append(args,
+			slog.String("trace_id", s.TraceID),
+			slog.String("span_id", s.SpanID),
+		)
+		if s.ParentID != "" {
+			args = append(args, slog.String("parent_id", s.ParentID))
+		}
+		args = append(args,
+			slog.String("operation", s.Operation),
+			slog.Float64("duration_ms", float64(s.EndNS-s.StartNS)/1e6),
+			slog.String("status", s.Status),
+		)
+		for _, k := range attrs {
+			if v, ok := s.Attrs[k]; ok {
+				args = append(args, slog.Any(k, v))
+			}
+		}
+		l.slog.LogAttrs(ctx, level, "span", args...)
+	})
+}
+
+// LogSpans makes every span End also emit a log record through l, in
+// addition to the current process-wide exporter. attrs selects which
+// span attributes are included:
+//
+//	logging.LogSpans(log, "model", "provider", "tokens_out")
+func LogSpans(l *Logger, attrs ...string) {
+	trace.SetExporter(trace.MultiExporter(trace.DefaultExporter(), SpanExporter(l, attrs...)))
+}
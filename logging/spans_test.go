@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/trace"
+)
+
+func TestSpanExporter(t *testing.T) {
+	var buf bytes.Buffer
+	log := New("infermux", LevelInfo, WithWriter(&buf))
+
+	ctx := trace.WithExporter(context.Background(), SpanExporter(log, "model", "missing"))
+	ctx, parent := trace.Start(ctx, "route")
+	_, span := trace.Start(ctx, "inference")
+	span.SetAttr("model", "gpt-4o")
+	span.SetAttr("secret", "not logged")
+	span.End("error")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	want := map[string]any{
+		"msg":       "span",
+		"level":     "WARN",
+		"tool":      "infermux",
+		"trace_id":  span.TraceID,
+		"span_id":   span.SpanID,
+		"parent_id": parent.SpanID,
+		"operation": "inference",
+		"status":    "error",
+		"model":     "gpt-4o",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+	if _, ok := entry["duration_ms"].(float64); !ok {
+		t.Errorf("duration_ms missing: %v", entry)
+	}
+	for _, k := range []string{"secret", "missing"} {
+		if _, ok := entry[k]; ok {
+			t.Errorf("unexpected attr %s", k)
+		}
+	}
+}
+
+func TestSpanExporterLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log := New("test", LevelWarn, WithWriter(&buf))
+	exp := SpanExporter(log)
+
+	exp.ExportSpan(protocol.TraceSpan{Operation: "op", Status: "ok"})
+	if buf.Len() != 0 {
+		t.Errorf("ok span logged at warn level: %s", buf.String())
+	}
+	exp.ExportSpan(protocol.TraceSpan{Operation: "op", Status: "error"})
+	if buf.Len() == 0 {
+		t.Error("error span not logged")
+	}
+}
+
+func TestLogSpans(t *testing.T) {
+	var exported int
+	trace.SetExporter(trace.ExporterFunc(func(protocol.TraceSpan) { exported++ }))
+	defer trace.SetExporter(nil)
+
+	var buf bytes.Buffer
+	LogSpans(New("test", LevelInfo, WithWriter(&buf)))
+
+	_, span := trace.Start(context.Background(), "op")
+	span.End("ok")
+
+	if exported != 1 {
+		t.Errorf("previous exporter called %d times, want 1", exported)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"operation":"op"`)) {
+		t.Errorf("span not logged: %s", buf.String())
+	}
+}
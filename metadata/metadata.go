@@ -0,0 +1,117 @@
+// Package metadata carries request-scoped key/value context (tenant,
+// user, request ID, arbitrary baggage) through context.Context and across
+// tool boundaries on the protocol.Message envelope.
+//
+//	ctx = metadata.With(ctx, metadata.KeyTenant, "acme")
+//	metadata.Inject(ctx, msg)          // sender (or transport.WithMetadata)
+//	ctx = metadata.Extract(ctx, msg)   // receiver
+//	tenant := metadata.Get(ctx, metadata.KeyTenant)
+package metadata
+
+import (
+	"context"
+	"maps"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Well-known keys.
+const (
+	KeyTenant    = "tenant"
+	KeyUser      = "user"
+	KeyRequestID = "request_id"
+)
+
+// Limits applied when extracting metadata from received messages, so a
+// misbehaving peer can't inflate every downstream context.
+const (
+	MaxEntries  = 64
+	MaxKeyLen   = 128
+	MaxValueLen = 1024
+)
+
+// MD is a set of metadata entries.
+type MD map[string]string
+
+type contextKey struct{}
+
+// FromContext returns a copy of the metadata attached to ctx, or nil.
+func FromContext(ctx context.Context) MD {
+	md, _ := ctx.Value(contextKey{}).(MD)
+	if md == nil {
+		return nil
+	}
+	return maps.Clone(md)
+}
+
+// NewContext returns a ctx carrying md merged over any metadata already
+// in ctx. Entries in md win.
+func NewContext(ctx context.Context, md MD) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	merged := FromContext(ctx)
+	if merged == nil {
+		merged = make(MD, len(md))
+	}
+	maps.Copy(merged, md)
+	return context.WithValue(ctx, contextKey{}, merged)
+}
+
+// With returns a ctx with a single entry set.
+func With(ctx context.Context, key, value string) context.Context {
+	return NewContext(ctx, MD{key: value})
+}
+
+// Get returns the value for key, or "" if unset.
+func Get(ctx context.Context, key string) string {
+	md, _ := ctx.Value(contextKey{}).(MD)
+	return md[key]
+}
+
+// Inject copies ctx's metadata onto msg. Entries already on the message
+// are kept.
+func Inject(ctx context.Context, msg *protocol.Message) {
+	md, _ := ctx.Value(contextKey{}).(MD)
+	if len(md) == 0 {
+		return
+	}
+	if msg.Meta == nil {
+		msg.Meta = make(map[string]string, len(md))
+	}
+	for k, v := range md {
+		if _, ok := msg.Meta[k]; !ok {
+			msg.Meta[k] = v
+		}
+	}
+}
+
+// Extract restores msg's metadata into ctx. Entries that exceed the
+// size limits or contain control characters are dropped.
+func Extract(ctx context.Context, msg *protocol.Message) context.Context {
+	if len(msg.Meta) == 0 {
+		return ctx
+	}
+	md := make(MD, min(len(msg.Meta), MaxEntries))
+	for k, v := range msg.Meta {
+		if len(md) == MaxEntries {
+			break
+		}
+		if k != "" && valid(k, MaxKeyLen) && valid(v, MaxValueLen) {
+			md[k] = v
+		}
+	}
+	return NewContext(ctx, md)
+}
+
+func valid(s string, maxLen int) bool {
+	if len(s) > maxLen {
+		return false
+	}
+	for _, ch := range s {
+		if ch < 32 || ch == 127 {
+			return false
+		}
+	}
+	return true
+}
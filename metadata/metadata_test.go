@@ -0,0 +1,78 @@
+package metadata
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestContextRoundTrip(t *testing.T) {
+	ctx := With(context.Background(), KeyTenant, "acme")
+	ctx = NewContext(ctx, MD{KeyUser: "u1", KeyTenant: "globex"})
+
+	if Get(ctx, KeyTenant) != "globex" {
+		t.Errorf("later entries should win, tenant = %q", Get(ctx, KeyTenant))
+	}
+	if Get(ctx, KeyUser) != "u1" {
+		t.Errorf("user = %q", Get(ctx, KeyUser))
+	}
+	if Get(context.Background(), KeyUser) != "" {
+		t.Error("empty ctx should have no metadata")
+	}
+
+	md := FromContext(ctx)
+	md[KeyUser] = "mutated"
+	if Get(ctx, KeyUser) != "u1" {
+		t.Error("FromContext should return a copy")
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	ctx := NewContext(context.Background(), MD{KeyRequestID: "req-9", "baggage.region": "eu"})
+	msg, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{})
+	msg.Meta = map[string]string{KeyRequestID: "explicit"}
+
+	Inject(ctx, msg)
+	if msg.Meta[KeyRequestID] != "explicit" {
+		t.Error("Inject should not overwrite entries already on the message")
+	}
+	if msg.Meta["baggage.region"] != "eu" {
+		t.Errorf("meta = %v", msg.Meta)
+	}
+
+	data, _ := msg.Marshal()
+	got, err := protocol.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rctx := Extract(context.Background(), got)
+	if Get(rctx, "baggage.region") != "eu" || Get(rctx, KeyRequestID) != "explicit" {
+		t.Errorf("extracted = %v", FromContext(rctx))
+	}
+}
+
+func TestExtractDropsInvalid(t *testing.T) {
+	msg := &protocol.Message{Meta: map[string]string{
+		"ok":                     "fine",
+		"":                       "empty key",
+		"inject":                 "line\nbreak",
+		strings.Repeat("k", 200): "long key",
+		"big":                    strings.Repeat("v", MaxValueLen+1),
+	}}
+	md := FromContext(Extract(context.Background(), msg))
+	if len(md) != 1 || md["ok"] != "fine" {
+		t.Errorf("extracted = %v, want only ok", md)
+	}
+}
+
+func TestExtractCapsEntries(t *testing.T) {
+	msg := &protocol.Message{Meta: make(map[string]string)}
+	for i := 0; i < MaxEntries*2; i++ {
+		msg.Meta[strings.Repeat("k", i+1)] = "v"
+	}
+	if n := len(FromContext(Extract(context.Background(), msg))); n != MaxEntries {
+		t.Errorf("entries = %d, want %d", n, MaxEntries)
+	}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+// OverflowValue replaces every label value of a series created past its
+// metric's series limit, so all such label combinations share one
+// series.
+const OverflowValue = "other"
+
+// DroppedSeriesMetric counts, per metric name in its "metric" label,
+// the label combinations collapsed into the OverflowValue series because
+// the metric had reached its series limit.
+const DroppedSeriesMetric = "metrics_series_dropped_total"
+
+// maxOverflowKeys bounds how many collapsed label combinations a
+// registry remembers. A remembered one is counted once and later
+// lookups of it take the read path; past the bound, a combination is
+// collapsed and counted again on every lookup.
+const maxOverflowKeys = 1 << 16
+
+// RegistryOption configures NewRegistry.
+type RegistryOption func(*Registry)
+
+// WithMaxSeries limits every metric name to n label combinations (series)
+// across counters, gauges, histograms, and summaries. Past the limit a
+// new combination gets the name's overflow series, whose label values
+// are all OverflowValue, and DroppedSeriesMetric is incremented, so a
+// label fed unbounded values (a user ID, a raw path) can't grow memory
+// without bound. Zero or less means no limit, the default.
+func WithMaxSeries(n int) RegistryOption {
+	return func(r *Registry) { r.maxSeries = n }
+}
+
+// WithSeriesLimit sets the series limit of one metric name, overriding
+// WithMaxSeries for it. Zero or less means no limit.
+func WithSeriesLimit(name string, n int) RegistryOption {
+	return func(r *Registry) {
+		if r.seriesLimits == nil {
+			r.seriesLimits = make(map[string]int)
+		}
+		r.seriesLimits[name] = n
+	}
+}
+
+// admit returns the labels and key to create a new series of name under:
+// labels and key themselves while name is within its limit, or else the
+// overflow series. A series without labels is always admitted, as it has
+// nothing to collapse. The caller holds r.mu for writing.
+func (r *Registry) admit(name string, labels []string, key string) ([]string, string) {
+	limit, ok := r.seriesLimits[name]
+	if !ok {
+		limit = r.maxSeries
+	}
+	if limit <= 0 || len(labels) == 0 || r.series[name] < limit {
+		return labels, key
+	}
+	r.dropped(name).Inc()
+	overflow := make([]string, len(labels))
+	for i := range labels {
+		overflow[i] = labels[i]
+		if i%2 == 1 {
+			overflow[i] = OverflowValue
+		}
+	}
+	overflowKey := metricKey(name, overflow)
+	if len(r.overflowed) < maxOverflowKeys {
+		if r.overflowed == nil {
+			r.overflowed = make(map[string]string)
+		}
+		r.overflowed[key] = overflowKey
+	}
+	return overflow, overflowKey
+}
+
+// resolve returns the key a series is stored under: its overflow
+// series' key if admit collapsed it, or else key itself. The caller
+// holds r.mu.
+func (r *Registry) resolve(key string) string {
+	if k, ok := r.overflowed[key]; ok {
+		return k
+	}
+	return key
+}
+
+// dropped returns the DroppedSeriesMetric counter of name. It is created
+// directly, outside any series limit. The caller holds r.mu for writing.
+func (r *Registry) dropped(name string) *Counter {
+	labels := []string{"metric", name}
+	key := metricKey(DroppedSeriesMetric, labels)
+	c, ok := r.counters[key]
+	if !ok {
+		c = &Counter{name: DroppedSeriesMetric, labels: labels}
+		r.counters[key] = c
+	}
+	return c
+}
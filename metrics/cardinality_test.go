@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMaxSeries(t *testing.T) {
+	reg := NewRegistry(WithMaxSeries(3))
+	for i := range 10 {
+		reg.Counter("requests_total", "user", fmt.Sprint(i), "method", "GET").Inc()
+	}
+	// Series within the limit are still returned as before.
+	reg.Counter("requests_total", "user", "0", "method", "GET").Inc()
+
+	snap := reg.Snapshot()
+	if c := snap.Counters["requests_total{user,0,method,GET}"]; c.Value != 2 {
+		t.Errorf("user 0 = %d, want 2", c.Value)
+	}
+	other := snap.Counters["requests_total{user,other,method,other}"]
+	if other.Value != 7 || other.Labels[1] != OverflowValue {
+		t.Errorf("overflow series = %+v, want the 7 collapsed increments", other)
+	}
+	if d := snap.Counters[DroppedSeriesMetric+"{metric,requests_total}"]; d.Value != 7 {
+		t.Errorf("%s = %d, want 7", DroppedSeriesMetric, d.Value)
+	}
+	n := 0
+	for _, c := range snap.Counters {
+		if c.Name == "requests_total" {
+			n++
+		}
+	}
+	if n != 4 {
+		t.Errorf("%d requests_total series, want the limit of 3 plus overflow", n)
+	}
+}
+
+func TestMaxSeriesCountsDroppedOnce(t *testing.T) {
+	reg := NewRegistry(WithMaxSeries(1))
+	reg.Counter("hits_total", "path", "/a").Inc()
+	for range 5 {
+		reg.Counter("hits_total", "path", "/b").Inc()
+		reg.Gauge("hits_total", "path", "/c").Set(1)
+	}
+
+	snap := reg.Snapshot()
+	if d := snap.Counters[DroppedSeriesMetric+"{metric,hits_total}"]; d.Value != 2 {
+		t.Errorf("%s = %d, want 2 for /b and /c", DroppedSeriesMetric, d.Value)
+	}
+	if c := snap.Counters["hits_total{path,other}"]; c.Value != 5 {
+		t.Errorf("overflow counter = %d, want 5", c.Value)
+	}
+	if reg.Counter("hits_total", "path", "/b") != reg.Counter("hits_total", "path", "/z") {
+		t.Error("collapsed series differ")
+	}
+}
+
+func TestMaxSeriesKinds(t *testing.T) {
+	reg := NewRegistry(WithMaxSeries(1), WithSeriesLimit("path_latency_ms", 2), WithSeriesLimit("free", 0))
+	reg.Gauge("queue_depth", "queue", "a").Set(1)
+	reg.Gauge("queue_depth", "queue", "b").Set(2)
+	reg.Gauge("queue_depth").Set(3) // no labels to collapse
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		reg.Histogram("path_latency_ms", DefaultBuckets, "path", path).Observe(1)
+		reg.Summary("path_quantiles_ms", time.Minute, "path", path).Observe(1)
+		reg.Counter("free", "path", path).Inc()
+	}
+
+	snap := reg.Snapshot()
+	if g := snap.Gauges["queue_depth{queue,other}"]; g.Value != 2 {
+		t.Errorf("overflow gauge = %v, want 2", g.Value)
+	}
+	if _, ok := snap.Gauges["queue_depth"]; !ok {
+		t.Error("unlabeled gauge was not created")
+	}
+	if h := snap.Histograms["path_latency_ms{path,other}"]; h.Count != 2 {
+		t.Errorf("overflow histogram count = %d, want 2 under a limit of 2", h.Count)
+	}
+	if s := snap.Summaries["path_quantiles_ms{path,other}"]; s.Count != 3 {
+		t.Errorf("overflow summary count = %d, want 3", s.Count)
+	}
+	if len(snap.Counters) != 4+3 {
+		t.Errorf("counters = %v; want every free series and three dropped counters", snap.Counters)
+	}
+}
@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// RegistryDelta is the change in every metric over an interval, for
+// dashboards that want rates rather than monotonic totals.
+type RegistryDelta struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	IntervalS float64   `json:"interval_s"`
+
+	Counters   map[string]CounterDelta   `json:"counters,omitempty"`
+	Gauges     map[string]GaugeDelta     `json:"gauges,omitempty"`
+	Histograms map[string]HistogramDelta `json:"histograms,omitempty"`
+	Summaries  map[string]SummaryDelta   `json:"summaries,omitempty"`
+
+	// Current is the snapshot at End. Pass it to the next Delta call so
+	// consecutive intervals neither overlap nor miss increments.
+	Current RegistrySnapshot `json:"-"`
+}
+
+// CounterDelta is a counter's increase over an interval.
+type CounterDelta struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels,omitempty"`
+	Delta  int64    `json:"delta"`
+	Rate   float64  `json:"rate"` // per second
+}
+
+// GaugeDelta is a gauge's current value and its change over an interval.
+type GaugeDelta struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels,omitempty"`
+	Value  float64  `json:"value"`
+	Delta  float64  `json:"delta"`
+	Rate   float64  `json:"rate"` // per second
+}
+
+// HistogramDelta summarizes the observations a histogram received over an
+// interval.
+type HistogramDelta struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels,omitempty"`
+	Count  int64    `json:"count"`
+	Sum    float64  `json:"sum"`
+	Avg    float64  `json:"avg"`
+	Rate   float64  `json:"rate"` // observations per second
+}
+
+// SummaryDelta summarizes the observations a summary received over an
+// interval, with its current window quantiles keyed by their %g form.
+type SummaryDelta struct {
+	Name      string             `json:"name"`
+	Labels    []string           `json:"labels,omitempty"`
+	Count     int64              `json:"count"`
+	Sum       float64            `json:"sum"`
+	Avg       float64            `json:"avg"`
+	Rate      float64            `json:"rate"` // observations per second
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// Delta returns the change in every metric since prev, a snapshot taken
+// earlier from this registry:
+//
+//	prev := reg.Snapshot()
+//	for range time.Tick(10 * time.Second) {
+//	    d := reg.Delta(prev)
+//	    publish(d)
+//	    prev = d.Current
+//	}
+func (r *Registry) Delta(prev RegistrySnapshot) RegistryDelta {
+	return r.Snapshot().Delta(prev)
+}
+
+// Delta returns the change from prev to s. Metrics absent from prev count
+// from zero, and a counter that went backwards (its process restarted,
+// for a federated source) counts from zero as well.
+func (s RegistrySnapshot) Delta(prev RegistrySnapshot) RegistryDelta {
+	d := RegistryDelta{
+		Start:      prev.Time,
+		End:        s.Time,
+		Counters:   make(map[string]CounterDelta, len(s.Counters)),
+		Gauges:     make(map[string]GaugeDelta, len(s.Gauges)),
+		Histograms: make(map[string]HistogramDelta, len(s.Histograms)),
+		Summaries:  make(map[string]SummaryDelta, len(s.Summaries)),
+		Current:    s,
+	}
+	if !prev.Time.IsZero() && s.Time.After(prev.Time) {
+		d.IntervalS = s.Time.Sub(prev.Time).Seconds()
+	}
+	rate := func(v float64) float64 {
+		if d.IntervalS == 0 {
+			return 0
+		}
+		return v / d.IntervalS
+	}
+
+	for key, c := range s.Counters {
+		delta := c.Value
+		if p, ok := prev.Counters[key]; ok && p.Value <= c.Value {
+			delta -= p.Value
+		}
+		d.Counters[key] = CounterDelta{Name: c.Name, Labels: c.Labels, Delta: delta, Rate: rate(float64(delta))}
+	}
+	for key, g := range s.Gauges {
+		delta := g.Value - prev.Gauges[key].Value
+		d.Gauges[key] = GaugeDelta{Name: g.Name, Labels: g.Labels, Value: g.Value, Delta: delta, Rate: rate(delta)}
+	}
+	for key, h := range s.Histograms {
+		count, sum := h.Count, h.Sum
+		if p, ok := prev.Histograms[key]; ok && p.Count <= h.Count {
+			count -= p.Count
+			sum -= p.Sum
+		}
+		hd := HistogramDelta{Name: h.Name, Labels: h.Labels, Count: count, Sum: sum, Rate: rate(float64(count))}
+		if count > 0 {
+			hd.Avg = sum / float64(count)
+		}
+		d.Histograms[key] = hd
+	}
+	for key, sm := range s.Summaries {
+		count, sum := sm.Count, sm.Sum
+		if p, ok := prev.Summaries[key]; ok && p.Count <= sm.Count {
+			count -= p.Count
+			sum -= p.Sum
+		}
+		sd := SummaryDelta{Name: sm.Name, Labels: sm.Labels, Count: count, Sum: sum, Rate: rate(float64(count)),
+			Quantiles: quantileKeys(sm.Quantiles)}
+		if count > 0 {
+			sd.Avg = sum / float64(count)
+		}
+		d.Summaries[key] = sd
+	}
+	return d
+}
+
+// WithRate makes the counter track its per-second rate over a rolling
+// window, rounded up to whole seconds. Registry snapshots then include a
+// gauge named "<name>_rate" with the counter's labels, so /metricsz
+// reports rates directly:
+//
+//	reqs := reg.Counter("http_requests_total", "path", "/api").WithRate(time.Minute)
+//
+// Calling WithRate again has no effect. Tracking adds a clock read and a
+// short lock to every Add.
+func (c *Counter) WithRate(window time.Duration) *Counter {
+	secs := int((window + time.Second - 1) / time.Second)
+	c.rate.CompareAndSwap(nil, &rateWindow{
+		stamps: make([]int64, max(secs, 1)),
+		counts: make([]int64, max(secs, 1)),
+	})
+	return c
+}
+
+// Rate returns the counter's per-second rate over its WithRate window, or
+// zero if rate tracking is off.
+func (c *Counter) Rate() float64 {
+	if w := c.rate.Load(); w != nil {
+		return w.rate(time.Now())
+	}
+	return 0
+}
+
+// rateWindow counts increments in one-second buckets over a ring of
+// len(counts) seconds.
+type rateWindow struct {
+	mu     sync.Mutex
+	stamps []int64 // unix second each bucket counts
+	counts []int64
+}
+
+func (w *rateWindow) add(now time.Time, n int64) {
+	sec := now.Unix()
+	i := int(sec % int64(len(w.counts)))
+	w.mu.Lock()
+	if w.stamps[i] != sec {
+		w.stamps[i], w.counts[i] = sec, 0
+	}
+	w.counts[i] += n
+	w.mu.Unlock()
+}
+
+func (w *rateWindow) rate(now time.Time) float64 {
+	oldest := now.Unix() - int64(len(w.counts))
+	var total int64
+	w.mu.Lock()
+	for i, stamp := range w.stamps {
+		if stamp > oldest {
+			total += w.counts[i]
+		}
+	}
+	w.mu.Unlock()
+	return float64(total) / float64(len(w.counts))
+}
+
+// maxDeltaConsumers caps the baselines Handler keeps for ?delta; the
+// least recently used is forgotten past it.
+const maxDeltaConsumers = 64
+
+// deltaBaselines holds the snapshot each ?delta consumer last received.
+type deltaBaselines struct {
+	mu    sync.Mutex
+	prev  map[string]RegistrySnapshot
+	order []string // consumers, least recently used first
+}
+
+// next returns r's delta since consumer's last call and makes the
+// current snapshot its new baseline.
+func (b *deltaBaselines) next(consumer string, r *Registry) RegistryDelta {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.prev == nil {
+		b.prev = make(map[string]RegistrySnapshot)
+	}
+	d := r.Delta(b.prev[consumer])
+	if i := slices.Index(b.order, consumer); i >= 0 {
+		b.order = slices.Delete(b.order, i, i+1)
+	} else if len(b.order) == maxDeltaConsumers {
+		delete(b.prev, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.order = append(b.order, consumer)
+	b.prev[consumer] = d.Current
+	return d
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistryDelta(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("reqs", "path", "/a")
+	g := r.Gauge("queue")
+	h := r.Histogram("lat", DefaultBuckets)
+	c.Add(5)
+	g.Set(10)
+	h.Observe(100)
+
+	prev := r.Snapshot()
+	prev.Time = prev.Time.Add(-2 * time.Second)
+	c.Add(20)
+	g.Set(4)
+	h.Observe(10)
+	h.Observe(30)
+	r.Counter("new_total").Add(3)
+
+	d := r.Delta(prev)
+	if math.Abs(d.IntervalS-2) > 0.5 {
+		t.Fatalf("interval = %v", d.IntervalS)
+	}
+	cd := d.Counters["reqs{path,/a}"]
+	if cd.Delta != 20 || math.Abs(cd.Rate-20/d.IntervalS) > 1e-9 {
+		t.Errorf("counter delta = %+v", cd)
+	}
+	if d.Counters["new_total"].Delta != 3 {
+		t.Errorf("new counter = %+v", d.Counters["new_total"])
+	}
+	if gd := d.Gauges["queue"]; gd.Value != 4 || gd.Delta != -6 {
+		t.Errorf("gauge delta = %+v", gd)
+	}
+	if hd := d.Histograms["lat"]; hd.Count != 2 || hd.Sum != 40 || hd.Avg != 20 {
+		t.Errorf("histogram delta = %+v", hd)
+	}
+
+	next := r.Delta(d.Current)
+	if next.Counters["reqs{path,/a}"].Delta != 0 {
+		t.Errorf("chained delta = %+v", next.Counters["reqs{path,/a}"])
+	}
+}
+
+func TestDeltaCounterReset(t *testing.T) {
+	now := time.Now()
+	prev := RegistrySnapshot{Time: now, Counters: map[string]CounterSnapshot{"x": {Name: "x", Value: 100}}}
+	cur := RegistrySnapshot{Time: now.Add(time.Second), Counters: map[string]CounterSnapshot{"x": {Name: "x", Value: 7}}}
+	if d := cur.Delta(prev).Counters["x"]; d.Delta != 7 || d.Rate != 7 {
+		t.Errorf("reset delta = %+v", d)
+	}
+	if d := cur.Delta(RegistrySnapshot{}); d.IntervalS != 0 || d.Counters["x"].Rate != 0 {
+		t.Errorf("delta from empty = %+v", d)
+	}
+}
+
+func TestCounterRate(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("reqs", "path", "/a").WithRate(10 * time.Second)
+	if r.Counter("reqs", "path", "/a").WithRate(time.Second) != c {
+		t.Fatal("WithRate returned a different counter")
+	}
+	c.Add(50)
+	if rate := c.Rate(); rate != 5 {
+		t.Errorf("rate = %v, want 5", rate)
+	}
+	if r.Counter("plain").Rate() != 0 {
+		t.Error("rate without tracking")
+	}
+
+	data, _ := json.Marshal(r.Snapshot())
+	var snap struct {
+		Gauges map[string]GaugeSnapshot `json:"gauges"`
+	}
+	json.Unmarshal(data, &snap)
+	if g := snap.Gauges["reqs_rate{path,/a}"]; g.Name != "reqs_rate" || g.Value != 5 {
+		t.Errorf("rate gauge = %+v", g)
+	}
+}
+
+func TestRateWindowExpires(t *testing.T) {
+	w := &rateWindow{stamps: make([]int64, 3), counts: make([]int64, 3)}
+	base := time.Unix(1000, 0)
+	w.add(base, 6)
+	w.add(base.Add(time.Second), 3)
+	if r := w.rate(base.Add(time.Second)); r != 3 {
+		t.Errorf("rate = %v, want 3", r)
+	}
+	if r := w.rate(base.Add(3 * time.Second)); r != 1 {
+		t.Errorf("rate after expiry = %v, want 1", r)
+	}
+	w.add(base.Add(4*time.Second), 9) // reuses the first bucket
+	if r := w.rate(base.Add(4 * time.Second)); r != 3 {
+		t.Errorf("rate after wrap = %v, want 3", r)
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("x")
+	c.Add(5)
+	prev := r.Snapshot()
+	if got := c.Reset(); got != 5 || c.Value() != 0 {
+		t.Fatalf("Reset = %d, value %d after", got, c.Value())
+	}
+	c.Add(2)
+	if d := r.Delta(prev).Counters["x"]; d.Delta != 2 {
+		t.Errorf("delta across a reset = %+v, want 2", d)
+	}
+}
+
+func TestHandlerDelta(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("reqs")
+	c.Add(10)
+	get := func(consumer string) RegistryDelta {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.Handler()(w, httptest.NewRequest("GET", "/metricsz?delta="+consumer, nil))
+		var d RegistryDelta
+		if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	if d := get("a"); d.Counters["reqs"].Delta != 10 || d.IntervalS != 0 {
+		t.Errorf("first delta = %+v, want the total and no interval", d)
+	}
+	c.Add(3)
+	if d := get("a"); d.Counters["reqs"].Delta != 3 || d.IntervalS <= 0 {
+		t.Errorf("second delta = %+v, want 3 over an interval", d)
+	}
+	// Another consumer has its own baseline.
+	if d := get("b"); d.Counters["reqs"].Delta != 13 {
+		t.Errorf("new consumer delta = %+v, want 13", d.Counters["reqs"])
+	}
+
+	// Past the cap, the least recently used baseline is forgotten.
+	for i := range maxDeltaConsumers {
+		get(fmt.Sprint("c", i))
+	}
+	if len(r.baselines.prev) != maxDeltaConsumers {
+		t.Errorf("%d baselines kept, want %d", len(r.baselines.prev), maxDeltaConsumers)
+	}
+	if d := get("a"); d.Counters["reqs"].Delta != 13 {
+		t.Errorf("evicted consumer delta = %+v, want the total", d.Counters["reqs"])
+	}
+}
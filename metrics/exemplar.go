@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/greynewell/mist-go/trace"
+)
+
+// Exemplar is one observation kept alongside a histogram bucket with the
+// trace it was made in, so a slow bucket in /metricsz leads straight to
+// a trace in tokentrace (GET /traces/{trace_id}).
+type Exemplar struct {
+	Value   float64   `json:"value"`
+	TraceID string    `json:"trace_id"`
+	SpanID  string    `json:"span_id,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// ObserveContext records a value and, when ctx carries a sampled span,
+// keeps it as the exemplar of its bucket. Spans that are not sampled are
+// never exported, so there would be no trace to link to.
+func (h *Histogram) ObserveContext(ctx context.Context, v float64) {
+	span := trace.FromContext(ctx)
+	if !span.Sampled() {
+		h.Observe(v)
+		return
+	}
+	h.ObserveExemplar(v, span.TraceID, span.SpanID)
+}
+
+// ObserveExemplar records a value made in the given trace and keeps it as
+// the exemplar of its bucket, replacing the one before. It suits code
+// that has a span's IDs rather than a context, such as a span collector.
+func (h *Histogram) ObserveExemplar(v float64, traceID, spanID string) {
+	h.Observe(v)
+	if traceID == "" {
+		return
+	}
+	// Index len(bounds) is past every bound: the +Inf bucket, where the
+	// slowest requests land.
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.exemplars[i].Store(&Exemplar{Value: v, TraceID: traceID, SpanID: spanID, Time: time.Now()})
+}
+
+// exemplarSnapshot returns the latest exemplar of each bucket that has
+// one, keyed by the bucket's upper bound, or nil if none do.
+func (h *Histogram) exemplarSnapshot() map[float64]Exemplar {
+	var out map[float64]Exemplar
+	for i := range h.exemplars {
+		e := h.exemplars[i].Load()
+		if e == nil {
+			continue
+		}
+		if out == nil {
+			out = make(map[float64]Exemplar)
+		}
+		bound := math.Inf(1)
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+		out[bound] = *e
+	}
+	return out
+}
+
+// exemplarKeys returns es keyed by the %g form of each bound ("+Inf" for
+// the overflow bucket), as float64 map keys can't be encoded.
+func exemplarKeys(es map[float64]Exemplar) map[string]Exemplar {
+	if len(es) == 0 {
+		return nil
+	}
+	out := make(map[string]Exemplar, len(es))
+	for b, e := range es {
+		out[fmt.Sprintf("%g", b)] = e
+	}
+	return out
+}
+
+// parseExemplars reverses exemplarKeys.
+func parseExemplars(name string, es map[string]Exemplar) (map[float64]Exemplar, error) {
+	if len(es) == 0 {
+		return nil, nil
+	}
+	out := make(map[float64]Exemplar, len(es))
+	for k, e := range es {
+		b, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			return nil, fmt.Errorf("metrics: histogram %s: bad exemplar bound %q", name, k)
+		}
+		out[b] = e
+	}
+	return out, nil
+}
+
+// mergeExemplars keeps the newer exemplar of each bucket.
+func mergeExemplars(a, b map[float64]Exemplar) map[float64]Exemplar {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	out := make(map[float64]Exemplar, max(len(a), len(b)))
+	for bound, e := range a {
+		out[bound] = e
+	}
+	for bound, e := range b {
+		if cur, ok := out[bound]; !ok || e.Time.After(cur.Time) {
+			out[bound] = e
+		}
+	}
+	return out
+}
+
+// openMetricsExemplar returns the exemplar suffix of a _bucket line,
+// " # {trace_id="…",span_id="…"} value timestamp", or "" for none.
+func openMetricsExemplar(e Exemplar, ok bool) string {
+	if !ok {
+		return ""
+	}
+	labels := []string{"trace_id", e.TraceID}
+	if e.SpanID != "" {
+		labels = append(labels, "span_id", e.SpanID)
+	}
+	ts := strconv.FormatFloat(float64(e.Time.UnixMilli())/1000, 'f', 3, 64)
+	return " # " + openMetricsLabels(labels) + " " + formatFloat(e.Value) + " " + ts
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/trace"
+)
+
+func TestHistogramObserveContext(t *testing.T) {
+	h := NewRegistry().Histogram("latency_ms", []float64{10, 100})
+	ctx, span := trace.Start(context.Background(), "request")
+
+	h.ObserveContext(ctx, 5)
+	h.ObserveContext(ctx, 7)
+	h.ObserveContext(ctx, 4000)
+	h.ObserveContext(context.Background(), 50)
+	unsampled, _ := trace.Start(trace.WithSampler(context.Background(), trace.NeverSample()), "request")
+	h.ObserveContext(unsampled, 60)
+
+	snap := h.Snapshot()
+	if snap.Count != 5 {
+		t.Errorf("count = %d, want every observation recorded", snap.Count)
+	}
+	if len(snap.Exemplars) != 2 {
+		t.Fatalf("exemplars = %+v; want the 10 and +Inf buckets only", snap.Exemplars)
+	}
+	if e := snap.Exemplars[10]; e.Value != 7 || e.TraceID != span.TraceID || e.SpanID != span.SpanID || e.Time.IsZero() {
+		t.Errorf("exemplar for le=10 = %+v; want the latest value, 7, with the span's IDs", e)
+	}
+	if e := snap.Exemplars[math.Inf(1)]; e.Value != 4000 {
+		t.Errorf("exemplar for le=+Inf = %+v, want 4000", e)
+	}
+}
+
+func TestExemplarJSON(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.Histogram("latency_ms", []float64{10})
+	h.ObserveExemplar(3, "trace-a", "span-a")
+	h.ObserveExemplar(30, "trace-b", "")
+	h.Observe(1)
+
+	data, err := json.Marshal(reg.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"+Inf":{"value":30,"trace_id":"trace-b"`) {
+		t.Errorf("JSON = %s", data)
+	}
+	var got RegistrySnapshot
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := h.Snapshot().Exemplars
+	es := got.Histograms["latency_ms"].Exemplars
+	if len(es) != len(want) {
+		t.Fatalf("round trip exemplars = %+v, want %+v", es, want)
+	}
+	for b, e := range want {
+		if g := es[b]; g.Value != e.Value || g.TraceID != e.TraceID || g.SpanID != e.SpanID || !g.Time.Equal(e.Time) {
+			t.Errorf("round trip exemplar %g = %+v, want %+v", b, g, e)
+		}
+	}
+}
+
+func TestExemplarExport(t *testing.T) {
+	at := time.UnixMilli(1_700_000_000_250)
+	snap := RegistrySnapshot{Histograms: map[string]HistogramSnapshot{"latency_ms": {
+		Name: "latency_ms", Count: 2, Sum: 12,
+		Buckets:   map[float64]int64{10: 1},
+		Exemplars: map[float64]Exemplar{10: {Value: 2, TraceID: "t1", SpanID: "s1", Time: at}, math.Inf(1): {Value: 10.5, TraceID: "t2", Time: at}},
+	}}}
+	var b strings.Builder
+	WriteOpenMetrics(&b, snap, "")
+	want := "# TYPE latency_ms histogram\n" +
+		"latency_ms_bucket{le=\"10\"} 1 # {trace_id=\"t1\",span_id=\"s1\"} 2 1700000000.250\n" +
+		"latency_ms_bucket{le=\"+Inf\"} 2 # {trace_id=\"t2\"} 10.5 1700000000.250\n" +
+		"latency_ms_sum 12\nlatency_ms_count 2\n# EOF\n"
+	if b.String() != want {
+		t.Errorf("OpenMetrics:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestExemplarMerge(t *testing.T) {
+	older, newer := time.Unix(100, 0), time.Unix(200, 0)
+	a := HistogramSnapshot{Name: "latency_ms", Count: 1, Buckets: map[float64]int64{10: 1},
+		Exemplars: map[float64]Exemplar{10: {Value: 1, TraceID: "new", Time: newer}}}
+	b := HistogramSnapshot{Name: "latency_ms", Count: 2, Buckets: map[float64]int64{10: 1},
+		Exemplars: map[float64]Exemplar{10: {Value: 2, TraceID: "old", Time: older}, math.Inf(1): {Value: 50, TraceID: "slow", Time: older}}}
+
+	m, err := b.Merge(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Exemplars[10].TraceID != "new" || m.Exemplars[math.Inf(1)].TraceID != "slow" {
+		t.Errorf("merged exemplars = %+v; want the newer per bucket", m.Exemplars)
+	}
+}
+
+func TestTimerStartContext(t *testing.T) {
+	reg := NewRegistry()
+	timer := reg.Timer("request_duration_ms")
+	ctx, span := trace.Start(context.Background(), "request")
+	timer.StartContext(ctx).Stop()
+	timer.Start().Stop()
+
+	snap := reg.Histogram("request_duration_ms", DefaultBuckets).Snapshot()
+	if snap.Count != 2 || len(snap.Exemplars) != 1 {
+		t.Fatalf("count = %d, exemplars = %+v; want 2 and one", snap.Count, snap.Exemplars)
+	}
+	for _, e := range snap.Exemplars {
+		if e.TraceID != span.TraceID {
+			t.Errorf("exemplar trace = %q, want %q", e.TraceID, span.TraceID)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Gatherer is anything that can produce a point-in-time metrics snapshot.
+// *Registry and *Federation both implement it.
+type Gatherer interface {
+	Snapshot() RegistrySnapshot
+}
+
+// Federation merges several gatherers into one namespaced view, so a tool
+// that embeds multiple subsystems (e.g. infermux + tokentrace + transport)
+// can serve all of their metrics from a single /metricsz endpoint.
+//
+//	fed := metrics.NewFederation()
+//	fed.Register("infermux", routerRegistry)
+//	fed.Register("tokentrace", handler.Aggregator().Registry())
+//	http.HandleFunc("/metricsz", fed.Handler())
+type Federation struct {
+	mu      sync.RWMutex
+	sources []federated
+}
+
+type federated struct {
+	prefix string
+	g      Gatherer
+}
+
+// NewFederation creates an empty federation.
+func NewFederation() *Federation {
+	return &Federation{}
+}
+
+// Register adds a gatherer whose metric names will be prefixed with
+// prefix + "_". An empty prefix passes names through unchanged. It is an
+// error to register the same non-empty prefix twice.
+func (f *Federation) Register(prefix string, g Gatherer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if prefix != "" {
+		for _, s := range f.sources {
+			if s.prefix == prefix {
+				return fmt.Errorf("metrics: prefix %q already registered", prefix)
+			}
+		}
+	}
+	f.sources = append(f.sources, federated{prefix: prefix, g: g})
+	return nil
+}
+
+// Gather returns the merged snapshot of all registered gatherers. If two
+// sources produce the same namespaced metric, the first registered source
+// wins and an error naming every conflicting key is returned alongside the
+// merged snapshot.
+func (f *Federation) Gather() (RegistrySnapshot, error) {
+	f.mu.RLock()
+	sources := make([]federated, len(f.sources))
+	copy(sources, f.sources)
+	f.mu.RUnlock()
+
+	merged := RegistrySnapshot{
+		Time:       time.Now(),
+		Counters:   make(map[string]CounterSnapshot),
+		Gauges:     make(map[string]GaugeSnapshot),
+		Histograms: make(map[string]HistogramSnapshot),
+		Summaries:  make(map[string]SummarySnapshot),
+	}
+	var conflicts []string
+
+	for _, s := range sources {
+		snap := s.g.Snapshot()
+		for key, c := range snap.Counters {
+			key = prefixed(s.prefix, key)
+			if _, dup := merged.Counters[key]; dup {
+				conflicts = append(conflicts, "counter "+key)
+				continue
+			}
+			c.Name = prefixed(s.prefix, c.Name)
+			merged.Counters[key] = c
+		}
+		for key, g := range snap.Gauges {
+			key = prefixed(s.prefix, key)
+			if _, dup := merged.Gauges[key]; dup {
+				conflicts = append(conflicts, "gauge "+key)
+				continue
+			}
+			g.Name = prefixed(s.prefix, g.Name)
+			merged.Gauges[key] = g
+		}
+		for key, h := range snap.Histograms {
+			key = prefixed(s.prefix, key)
+			if _, dup := merged.Histograms[key]; dup {
+				conflicts = append(conflicts, "histogram "+key)
+				continue
+			}
+			h.Name = prefixed(s.prefix, h.Name)
+			merged.Histograms[key] = h
+		}
+		for key, sm := range snap.Summaries {
+			key = prefixed(s.prefix, key)
+			if _, dup := merged.Summaries[key]; dup {
+				conflicts = append(conflicts, "summary "+key)
+				continue
+			}
+			sm.Name = prefixed(s.prefix, sm.Name)
+			merged.Summaries[key] = sm
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return merged, fmt.Errorf("metrics: conflicting metrics: %v", conflicts)
+	}
+	return merged, nil
+}
+
+// Snapshot returns the merged snapshot, ignoring conflicts. This lets a
+// Federation be nested inside another Federation.
+func (f *Federation) Snapshot() RegistrySnapshot {
+	snap, _ := f.Gather()
+	return snap
+}
+
+// Handler returns an HTTP handler that serves the merged metrics as JSON.
+// Conflicting metric names produce a 500 so they're caught early rather
+// than silently shadowed.
+func (f *Federation) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		snap, err := f.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		data, err := json.Marshal(snap)
+		if err != nil {
+			http.Error(w, "metrics marshal error", http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func prefixed(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFederationPrefixes(t *testing.T) {
+	a := NewRegistry()
+	a.Counter("requests_total").Add(3)
+	a.Histogram("latency_ms", DefaultBuckets).Observe(10)
+
+	b := NewRegistry()
+	b.Counter("requests_total").Add(5)
+	b.Gauge("queue_depth").Set(7)
+
+	fed := NewFederation()
+	if err := fed.Register("infermux", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := fed.Register("tokentrace", b); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := fed.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	if c := snap.Counters["infermux_requests_total"]; c.Value != 3 || c.Name != "infermux_requests_total" {
+		t.Errorf("infermux counter = %+v", c)
+	}
+	if c := snap.Counters["tokentrace_requests_total"]; c.Value != 5 {
+		t.Errorf("tokentrace counter = %+v", c)
+	}
+	if g := snap.Gauges["tokentrace_queue_depth"]; g.Value != 7 {
+		t.Errorf("gauge = %+v", g)
+	}
+	h := snap.Histograms["infermux_latency_ms"]
+	if h.Count != 1 || h.Percentile(50) == 0 {
+		t.Errorf("histogram = %+v", h)
+	}
+}
+
+func TestFederationDuplicatePrefix(t *testing.T) {
+	fed := NewFederation()
+	fed.Register("x", NewRegistry())
+	if err := fed.Register("x", NewRegistry()); err == nil {
+		t.Error("expected error for duplicate prefix")
+	}
+}
+
+func TestFederationConflict(t *testing.T) {
+	a := NewRegistry()
+	a.Counter("shared_total").Add(1)
+	b := NewRegistry()
+	b.Counter("shared_total").Add(2)
+
+	fed := NewFederation()
+	fed.Register("", a)
+	fed.Register("", b)
+
+	snap, err := fed.Gather()
+	if err == nil || !strings.Contains(err.Error(), "shared_total") {
+		t.Fatalf("expected conflict error naming shared_total, got %v", err)
+	}
+	if snap.Counters["shared_total"].Value != 1 {
+		t.Errorf("first source should win, got %d", snap.Counters["shared_total"].Value)
+	}
+
+	w := httptest.NewRecorder()
+	fed.Handler()(w, httptest.NewRequest("GET", "/metricsz", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500 on conflict", w.Code)
+	}
+}
+
+func TestFederationNested(t *testing.T) {
+	inner := NewFederation()
+	r := NewRegistry()
+	r.Counter("sent_total").Inc()
+	inner.Register("http", r)
+
+	outer := NewFederation()
+	outer.Register("transport", inner)
+
+	w := httptest.NewRecorder()
+	outer.Handler()(w, httptest.NewRequest("GET", "/metricsz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var snap struct {
+		Counters map[string]CounterSnapshot `json:"counters"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if snap.Counters["transport_http_sent_total"].Value != 1 {
+		t.Errorf("nested counter missing: %+v", snap.Counters)
+	}
+}
@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Merge combines two snapshots of the same histogram taken on different
+// nodes, as if every observation had been made on one. Both must use the
+// same bucket bounds, or percentiles of the result would be meaningless,
+// so differing bounds are an error. A zero HistogramSnapshot merges as
+// empty, so merging into one accumulates a fleet total. Name and labels
+// are taken from s, or from other if s is the zero value.
+func (s HistogramSnapshot) Merge(other HistogramSnapshot) (HistogramSnapshot, error) {
+	if s.zero() {
+		return other.clone(), nil
+	}
+	if other.zero() {
+		return s.clone(), nil
+	}
+	sb, ob := s.sortedBounds(), other.sortedBounds()
+	if !slices.Equal(sb, ob) {
+		return HistogramSnapshot{}, fmt.Errorf("metrics: histogram %s: bucket bounds differ: %v and %v", s.Name, sb, ob)
+	}
+
+	m := HistogramSnapshot{
+		Name:      s.Name,
+		Labels:    s.Labels,
+		Count:     s.Count + other.Count,
+		Sum:       s.Sum + other.Sum,
+		Min:       s.Min,
+		Max:       s.Max,
+		Buckets:   make(map[float64]int64, len(sb)),
+		Exemplars: mergeExemplars(s.Exemplars, other.Exemplars),
+		bounds:    sb,
+	}
+	switch {
+	case s.Count == 0:
+		m.Min, m.Max = other.Min, other.Max
+	case other.Count > 0:
+		m.Min, m.Max = math.Min(s.Min, other.Min), math.Max(s.Max, other.Max)
+	}
+	// Cumulative counts add bucket by bucket just as raw counts do.
+	for _, b := range sb {
+		m.Buckets[b] = s.Buckets[b] + other.Buckets[b]
+	}
+	return m, nil
+}
+
+// zero reports whether s is a zero HistogramSnapshot rather than a
+// snapshot of a histogram that has no observations yet.
+func (s HistogramSnapshot) zero() bool {
+	return s.Count == 0 && len(s.bounds) == 0 && len(s.Buckets) == 0
+}
+
+func (s HistogramSnapshot) clone() HistogramSnapshot {
+	s.Buckets = maps.Clone(s.Buckets)
+	s.Exemplars = maps.Clone(s.Exemplars)
+	s.bounds = s.sortedBounds()
+	return s
+}
+
+// sortedBounds returns the bucket bounds, recovering them from Buckets
+// for a snapshot decoded from JSON.
+func (s HistogramSnapshot) sortedBounds() []float64 {
+	if len(s.bounds) == len(s.Buckets) {
+		return s.bounds
+	}
+	bounds := make([]float64, 0, len(s.Buckets))
+	for b := range s.Buckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+// UnmarshalJSON decodes the JSON form written by MarshalJSON, so
+// snapshots fetched from another node's /metricsz can be merged and
+// their percentiles computed.
+func (s *HistogramSnapshot) UnmarshalJSON(data []byte) error {
+	var a struct {
+		Name    string           `json:"name"`
+		Labels  []string         `json:"labels,omitempty"`
+		Count   int64            `json:"count"`
+		Sum     float64          `json:"sum"`
+		Min     float64          `json:"min"`
+		Max     float64          `json:"max"`
+		Buckets map[string]int64 `json:"buckets"`
+
+		Exemplars map[string]Exemplar `json:"exemplars,omitempty"`
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	exemplars, err := parseExemplars(a.Name, a.Exemplars)
+	if err != nil {
+		return err
+	}
+	*s = HistogramSnapshot{
+		Name: a.Name, Labels: a.Labels,
+		Count: a.Count, Sum: a.Sum, Min: a.Min, Max: a.Max,
+		Buckets:   make(map[float64]int64, len(a.Buckets)),
+		Exemplars: exemplars,
+	}
+	for k, v := range a.Buckets {
+		b, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			return fmt.Errorf("metrics: histogram %s: bad bucket bound %q", a.Name, k)
+		}
+		s.Buckets[b] = v
+	}
+	s.bounds = s.sortedBounds()
+	return nil
+}
+
+// Merge combines registry snapshots from several nodes into one fleet
+// view: counters and gauges with the same key are summed, histograms are
+// merged with HistogramSnapshot.Merge, and Time is the latest of the
+// snapshots. Summing suits gauges that count things (in-flight requests,
+// queue depth) but not ratios; keep those per node. Histograms whose
+// bounds differ are left out of the result, and an error naming each of
+// them is returned alongside it. Quantiles can't be combined, so a
+// summary reported by more than one snapshot keeps only its summed Count,
+// Sum, and WindowCount.
+func (s RegistrySnapshot) Merge(others ...RegistrySnapshot) (RegistrySnapshot, error) {
+	return MergeSnapshots(append([]RegistrySnapshot{s}, others...)...)
+}
+
+// MergeSnapshots merges snaps as RegistrySnapshot.Merge does.
+func MergeSnapshots(snaps ...RegistrySnapshot) (RegistrySnapshot, error) {
+	merged := RegistrySnapshot{
+		Counters:   make(map[string]CounterSnapshot),
+		Gauges:     make(map[string]GaugeSnapshot),
+		Histograms: make(map[string]HistogramSnapshot),
+		Summaries:  make(map[string]SummarySnapshot),
+	}
+	incompatible := make(map[string]bool)
+	for _, snap := range snaps {
+		if snap.Time.After(merged.Time) {
+			merged.Time = snap.Time
+		}
+		for key, c := range snap.Counters {
+			if m, ok := merged.Counters[key]; ok {
+				c.Value += m.Value
+				c.Name, c.Labels = m.Name, m.Labels
+			}
+			merged.Counters[key] = c
+		}
+		for key, g := range snap.Gauges {
+			if m, ok := merged.Gauges[key]; ok {
+				g.Value += m.Value
+				g.Name, g.Labels = m.Name, m.Labels
+			}
+			merged.Gauges[key] = g
+		}
+		for key, h := range snap.Histograms {
+			if incompatible[key] {
+				continue
+			}
+			m, err := merged.Histograms[key].Merge(h)
+			if err != nil {
+				incompatible[key] = true
+				delete(merged.Histograms, key)
+				continue
+			}
+			merged.Histograms[key] = m
+		}
+		for key, sm := range snap.Summaries {
+			if m, ok := merged.Summaries[key]; ok {
+				sm.Count += m.Count
+				sm.Sum += m.Sum
+				sm.WindowCount += m.WindowCount
+				sm.Name, sm.Labels, sm.Quantiles = m.Name, m.Labels, nil
+			}
+			merged.Summaries[key] = sm
+		}
+	}
+
+	if len(incompatible) > 0 {
+		keys := make([]string, 0, len(incompatible))
+		for key := range incompatible {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return merged, fmt.Errorf("metrics: histograms with differing bucket bounds: %v", keys)
+	}
+	return merged, nil
+}
+
+// Merged is a Gatherer whose snapshot merges those of its members, such
+// as the registries of every node in a fleet. Register it with a
+// Federation to serve the fleet view alongside local metrics:
+//
+//	fed.Register("fleet", metrics.NewMerged(nodeA, nodeB, nodeC))
+type Merged struct {
+	members []Gatherer
+}
+
+// NewMerged creates a Gatherer merging the snapshots of members.
+func NewMerged(members ...Gatherer) *Merged {
+	return &Merged{members: members}
+}
+
+// Gather returns the merged snapshot of every member, with
+// MergeSnapshots's error if histogram bounds disagree.
+func (m *Merged) Gather() (RegistrySnapshot, error) {
+	snaps := make([]RegistrySnapshot, len(m.members))
+	for i, g := range m.members {
+		snaps[i] = g.Snapshot()
+	}
+	merged, err := MergeSnapshots(snaps...)
+	if merged.Time.IsZero() {
+		merged.Time = time.Now()
+	}
+	return merged, err
+}
+
+// Snapshot returns the merged snapshot, ignoring incompatible histograms.
+func (m *Merged) Snapshot() RegistrySnapshot {
+	snap, _ := m.Gather()
+	return snap
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHistogramSnapshotMerge(t *testing.T) {
+	buckets := []float64{10, 100, 1000}
+	a := NewRegistry().Histogram("latency_ms", buckets)
+	b := NewRegistry().Histogram("latency_ms", buckets)
+	for _, v := range []float64{5, 50, 500} {
+		a.Observe(v)
+	}
+	for _, v := range []float64{1, 2000} {
+		b.Observe(v)
+	}
+
+	m, err := a.Snapshot().Merge(b.Snapshot())
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if m.Count != 5 || m.Sum != 2556 || m.Min != 1 || m.Max != 2000 {
+		t.Errorf("merged = count %d sum %g min %g max %g", m.Count, m.Sum, m.Min, m.Max)
+	}
+	want := map[float64]int64{10: 2, 100: 3, 1000: 4}
+	for bound, n := range want {
+		if m.Buckets[bound] != n {
+			t.Errorf("bucket %g = %d, want %d", bound, m.Buckets[bound], n)
+		}
+	}
+	if p := m.Percentile(50); p <= 10 || p > 100 {
+		t.Errorf("p50 = %g, want within (10, 100]", p)
+	}
+
+	// An empty histogram keeps the other's min and max.
+	empty := NewRegistry().Histogram("latency_ms", buckets).Snapshot()
+	if m, _ := empty.Merge(b.Snapshot()); m.Min != 1 || m.Max != 2000 || m.Count != 2 {
+		t.Errorf("empty merge = %+v", m)
+	}
+	// The zero value is an identity, for accumulating a total.
+	var total HistogramSnapshot
+	if total, err = total.Merge(a.Snapshot()); err != nil || total.Count != 3 || total.Percentile(99) == 0 {
+		t.Errorf("zero merge = %+v, %v", total, err)
+	}
+}
+
+func TestHistogramSnapshotMergeBounds(t *testing.T) {
+	a := NewRegistry().Histogram("latency_ms", []float64{10, 100})
+	b := NewRegistry().Histogram("latency_ms", []float64{10, 50, 100})
+	_, err := a.Snapshot().Merge(b.Snapshot())
+	if err == nil || !strings.Contains(err.Error(), "bucket bounds differ") {
+		t.Fatalf("err = %v, want bounds error", err)
+	}
+}
+
+func TestHistogramSnapshotJSONRoundTrip(t *testing.T) {
+	h := NewRegistry().Histogram("latency_ms", []float64{0.5, 10, 100}, "op", "infer")
+	h.Observe(0.2)
+	h.Observe(42)
+
+	data, err := json.Marshal(h.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got HistogramSnapshot
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.Count != 2 || got.Buckets[0.5] != 1 || got.Buckets[100] != 2 || len(got.Labels) != 2 {
+		t.Errorf("decoded = %+v", got)
+	}
+	if got.Percentile(90) != h.Snapshot().Percentile(90) {
+		t.Errorf("p90 = %g, want %g", got.Percentile(90), h.Snapshot().Percentile(90))
+	}
+	// A decoded snapshot merges with a local one.
+	if m, err := got.Merge(h.Snapshot()); err != nil || m.Count != 4 {
+		t.Errorf("merge decoded = %+v, %v", m, err)
+	}
+}
+
+func TestMergeSnapshots(t *testing.T) {
+	a := NewRegistry()
+	a.Counter("requests_total", "node", "x").Add(3)
+	a.Counter("errors_total").Add(1)
+	a.Gauge("inflight").Set(2)
+	a.Histogram("latency_ms", []float64{10, 100}).Observe(5)
+	a.Histogram("size_bytes", []float64{1, 2}).Observe(1)
+
+	b := NewRegistry()
+	b.Counter("errors_total").Add(4)
+	b.Gauge("inflight").Set(5)
+	b.Histogram("latency_ms", []float64{10, 100}).Observe(50)
+	b.Histogram("size_bytes", []float64{1, 2, 4}).Observe(3)
+
+	m, err := a.Snapshot().Merge(b.Snapshot())
+	if err == nil || !strings.Contains(err.Error(), "size_bytes") {
+		t.Errorf("err = %v, want size_bytes named", err)
+	}
+	if c := m.Counters["errors_total"]; c.Value != 5 || c.Name != "errors_total" {
+		t.Errorf("errors_total = %+v", c)
+	}
+	if c := m.Counters[metricKey("requests_total", []string{"node", "x"})]; c.Value != 3 {
+		t.Errorf("requests_total = %+v", c)
+	}
+	if g := m.Gauges["inflight"]; g.Value != 7 {
+		t.Errorf("inflight = %g, want 7", g.Value)
+	}
+	if h := m.Histograms["latency_ms"]; h.Count != 2 || h.Max != 50 {
+		t.Errorf("latency_ms = %+v", h)
+	}
+	if _, ok := m.Histograms["size_bytes"]; ok {
+		t.Error("incompatible histogram kept")
+	}
+}
+
+func TestMergedFederation(t *testing.T) {
+	nodeA, nodeB := NewRegistry(), NewRegistry()
+	nodeA.Counter("spans_total").Add(2)
+	nodeB.Counter("spans_total").Add(3)
+
+	fed := NewFederation()
+	fed.Register("fleet", NewMerged(nodeA, nodeB))
+	fed.Register("local", nodeA)
+	snap, err := fed.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	if c := snap.Counters["fleet_spans_total"]; c.Value != 5 {
+		t.Errorf("fleet_spans_total = %d, want 5", c.Value)
+	}
+	if c := snap.Counters["local_spans_total"]; c.Value != 2 {
+		t.Errorf("local_spans_total = %d, want 2", c.Value)
+	}
+}
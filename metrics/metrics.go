@@ -1,5 +1,5 @@
 // Package metrics provides lightweight, zero-dependency counters, gauges,
-// and histograms for MIST tools. All types are concurrent-safe and designed
+// histograms, and windowed summaries for MIST tools. All types are concurrent-safe and designed
 // for high-throughput recording with minimal overhead.
 //
 // Usage:
@@ -24,6 +24,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // DefaultBuckets are the default histogram boundaries for latency (milliseconds).
@@ -35,15 +36,29 @@ type Registry struct {
 	counters   map[string]*Counter
 	gauges     map[string]*Gauge
 	histograms map[string]*Histogram
+	summaries  map[string]*Summary
+
+	maxSeries    int               // per metric name; see WithMaxSeries
+	seriesLimits map[string]int    // per-name overrides of maxSeries
+	series       map[string]int    // series created per metric name
+	overflowed   map[string]string // collapsed series key → overflow key
+
+	baselines deltaBaselines // for Handler's ?delta
 }
 
 // NewRegistry creates an empty metric registry.
-func NewRegistry() *Registry {
-	return &Registry{
+func NewRegistry(opts ...RegistryOption) *Registry {
+	r := &Registry{
 		counters:   make(map[string]*Counter),
 		gauges:     make(map[string]*Gauge),
 		histograms: make(map[string]*Histogram),
+		summaries:  make(map[string]*Summary),
+		series:     make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
 }
 
 // metricKey builds a deduplication key from name + label pairs.
@@ -60,7 +75,7 @@ func (r *Registry) Counter(name string, labels ...string) *Counter {
 	key := metricKey(name, labels)
 
 	r.mu.RLock()
-	if c, ok := r.counters[key]; ok {
+	if c, ok := r.counters[r.resolve(key)]; ok {
 		r.mu.RUnlock()
 		return c
 	}
@@ -68,11 +83,16 @@ func (r *Registry) Counter(name string, labels ...string) *Counter {
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if c, ok := r.counters[r.resolve(key)]; ok {
+		return c
+	}
+	labels, key = r.admit(name, labels, key)
 	if c, ok := r.counters[key]; ok {
 		return c
 	}
 	c := &Counter{name: name, labels: labels}
 	r.counters[key] = c
+	r.series[name]++
 	return c
 }
 
@@ -81,7 +101,7 @@ func (r *Registry) Gauge(name string, labels ...string) *Gauge {
 	key := metricKey(name, labels)
 
 	r.mu.RLock()
-	if g, ok := r.gauges[key]; ok {
+	if g, ok := r.gauges[r.resolve(key)]; ok {
 		r.mu.RUnlock()
 		return g
 	}
@@ -89,11 +109,16 @@ func (r *Registry) Gauge(name string, labels ...string) *Gauge {
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if g, ok := r.gauges[r.resolve(key)]; ok {
+		return g
+	}
+	labels, key = r.admit(name, labels, key)
 	if g, ok := r.gauges[key]; ok {
 		return g
 	}
 	g := &Gauge{name: name, labels: labels}
 	r.gauges[key] = g
+	r.series[name]++
 	return g
 }
 
@@ -103,7 +128,7 @@ func (r *Registry) Histogram(name string, buckets []float64, labels ...string) *
 	key := metricKey(name, labels)
 
 	r.mu.RLock()
-	if h, ok := r.histograms[key]; ok {
+	if h, ok := r.histograms[r.resolve(key)]; ok {
 		r.mu.RUnlock()
 		return h
 	}
@@ -111,6 +136,10 @@ func (r *Registry) Histogram(name string, buckets []float64, labels ...string) *
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if h, ok := r.histograms[r.resolve(key)]; ok {
+		return h
+	}
+	labels, key = r.admit(name, labels, key)
 	if h, ok := r.histograms[key]; ok {
 		return h
 	}
@@ -122,18 +151,23 @@ func (r *Registry) Histogram(name string, buckets []float64, labels ...string) *
 		labels:  labels,
 		bounds:  sorted,
 		buckets: make([]atomic.Int64, len(sorted)),
+
+		exemplars: make([]atomic.Pointer[Exemplar], len(sorted)+1),
 	}
 	h.minBits.Store(math.Float64bits(math.Inf(1)))
 	h.maxBits.Store(math.Float64bits(math.Inf(-1)))
 	r.histograms[key] = h
+	r.series[name]++
 	return h
 }
 
 // RegistrySnapshot is a point-in-time view of all metrics.
 type RegistrySnapshot struct {
+	Time       time.Time                    `json:"time,omitzero"`
 	Counters   map[string]CounterSnapshot   `json:"counters,omitempty"`
 	Gauges     map[string]GaugeSnapshot     `json:"gauges,omitempty"`
 	Histograms map[string]HistogramSnapshot `json:"histograms,omitempty"`
+	Summaries  map[string]SummarySnapshot   `json:"summaries,omitempty"`
 }
 
 // Snapshot returns a point-in-time copy of all registered metrics.
@@ -142,9 +176,11 @@ func (r *Registry) Snapshot() RegistrySnapshot {
 	defer r.mu.RUnlock()
 
 	snap := RegistrySnapshot{
+		Time:       time.Now(),
 		Counters:   make(map[string]CounterSnapshot, len(r.counters)),
 		Gauges:     make(map[string]GaugeSnapshot, len(r.gauges)),
 		Histograms: make(map[string]HistogramSnapshot, len(r.histograms)),
+		Summaries:  make(map[string]SummarySnapshot, len(r.summaries)),
 	}
 
 	for key, c := range r.counters {
@@ -153,6 +189,14 @@ func (r *Registry) Snapshot() RegistrySnapshot {
 			Labels: c.labels,
 			Value:  c.Value(),
 		}
+		if c.rate.Load() != nil {
+			name := c.name + "_rate"
+			snap.Gauges[metricKey(name, c.labels)] = GaugeSnapshot{
+				Name:   name,
+				Labels: c.labels,
+				Value:  c.Rate(),
+			}
+		}
 	}
 	for key, g := range r.gauges {
 		snap.Gauges[key] = GaugeSnapshot{
@@ -164,15 +208,27 @@ func (r *Registry) Snapshot() RegistrySnapshot {
 	for key, h := range r.histograms {
 		snap.Histograms[key] = h.Snapshot()
 	}
+	for key, s := range r.summaries {
+		snap.Summaries[key] = s.Snapshot()
+	}
 
 	return snap
 }
 
 // Handler returns an HTTP handler that serves the current metrics as JSON.
+//
+// With ?delta=<consumer> it serves a RegistryDelta instead: the change
+// since that consumer's previous delta request, so a dashboard gets
+// rates from a single scrape. Each consumer name keeps its own baseline;
+// the first request for a name reports totals and zero rates.
 func (r *Registry) Handler() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		data, err := json.Marshal(r.Snapshot())
+		var v any = r.Snapshot()
+		if consumer := req.URL.Query().Get("delta"); consumer != "" {
+			v = r.baselines.next(consumer, r)
+		}
+		data, err := json.Marshal(v)
 		if err != nil {
 			http.Error(w, "metrics marshal error", http.StatusInternalServerError)
 			return
@@ -188,17 +244,29 @@ type Counter struct {
 	name   string
 	labels []string
 	value  atomic.Int64
+	rate   atomic.Pointer[rateWindow] // set by WithRate
 }
 
 // Inc increments the counter by 1.
-func (c *Counter) Inc() { c.value.Add(1) }
+func (c *Counter) Inc() { c.Add(1) }
 
 // Add increments the counter by n.
-func (c *Counter) Add(n int64) { c.value.Add(n) }
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+	if w := c.rate.Load(); w != nil {
+		w.add(time.Now(), n)
+	}
+}
 
 // Value returns the current counter value.
 func (c *Counter) Value() int64 { return c.value.Load() }
 
+// Reset sets the counter to zero and returns its value before, for
+// callers that read and clear a count each interval. Snapshot deltas
+// treat the drop as a restart and count from zero. A WithRate window
+// keeps the increments it has seen.
+func (c *Counter) Reset() int64 { return c.value.Swap(0) }
+
 // CounterSnapshot is a point-in-time counter value.
 type CounterSnapshot struct {
 	Name   string   `json:"name"`
@@ -261,6 +329,10 @@ type Histogram struct {
 	sum     atomic.Uint64 // stored as float64 bits
 	minBits atomic.Uint64 // stored as float64 bits
 	maxBits atomic.Uint64 // stored as float64 bits
+
+	// exemplars holds the latest exemplar per bucket, with one more
+	// than bounds for values past the last bound.
+	exemplars []atomic.Pointer[Exemplar]
 }
 
 // Observe records a value.
@@ -308,16 +380,19 @@ func (h *Histogram) Observe(v float64) {
 	// Value exceeds all buckets — no bucket incremented.
 }
 
-// HistogramSnapshot is a point-in-time histogram state.
+// HistogramSnapshot is a point-in-time histogram state. Exemplars holds
+// the latest traced observation of each bucket, keyed by its upper bound
+// (+Inf for values past the last), for buckets that have one.
 type HistogramSnapshot struct {
-	Name    string            `json:"name"`
-	Labels  []string          `json:"labels,omitempty"`
-	Count   int64             `json:"count"`
-	Sum     float64           `json:"sum"`
-	Min     float64           `json:"min"`
-	Max     float64           `json:"max"`
-	Buckets map[float64]int64 `json:"-"` // use custom marshal
-	bounds  []float64
+	Name      string               `json:"name"`
+	Labels    []string             `json:"labels,omitempty"`
+	Count     int64                `json:"count"`
+	Sum       float64              `json:"sum"`
+	Min       float64              `json:"min"`
+	Max       float64              `json:"max"`
+	Buckets   map[float64]int64    `json:"-"` // use custom marshal
+	Exemplars map[float64]Exemplar `json:"-"` // use custom marshal
+	bounds    []float64
 }
 
 // MarshalJSON implements custom JSON marshaling to handle float64 map keys.
@@ -330,11 +405,14 @@ func (s HistogramSnapshot) MarshalJSON() ([]byte, error) {
 		Min     float64          `json:"min"`
 		Max     float64          `json:"max"`
 		Buckets map[string]int64 `json:"buckets"`
+
+		Exemplars map[string]Exemplar `json:"exemplars,omitempty"`
 	}
 	a := alias{
 		Name: s.Name, Labels: s.Labels,
 		Count: s.Count, Sum: s.Sum, Min: s.Min, Max: s.Max,
-		Buckets: make(map[string]int64, len(s.Buckets)),
+		Buckets:   make(map[string]int64, len(s.Buckets)),
+		Exemplars: exemplarKeys(s.Exemplars),
 	}
 	for k, v := range s.Buckets {
 		a.Buckets[fmt.Sprintf("%g", k)] = v
@@ -348,14 +426,15 @@ func (h *Histogram) Snapshot() HistogramSnapshot {
 	max := math.Float64frombits(h.maxBits.Load())
 
 	snap := HistogramSnapshot{
-		Name:    h.name,
-		Labels:  h.labels,
-		Count:   h.count.Load(),
-		Sum:     math.Float64frombits(h.sum.Load()),
-		Min:     min,
-		Max:     max,
-		Buckets: make(map[float64]int64, len(h.bounds)),
-		bounds:  h.bounds,
+		Name:      h.name,
+		Labels:    h.labels,
+		Count:     h.count.Load(),
+		Sum:       math.Float64frombits(h.sum.Load()),
+		Min:       min,
+		Max:       max,
+		Buckets:   make(map[float64]int64, len(h.bounds)),
+		Exemplars: h.exemplarSnapshot(),
+		bounds:    h.bounds,
 	}
 
 	if snap.Count == 0 {
@@ -0,0 +1,392 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pusher defaults.
+const (
+	DefaultPushInterval = 10 * time.Second
+	DefaultPushTimeout  = 5 * time.Second
+)
+
+// statsdPacketSize keeps each StatsD datagram under a typical MTU.
+const statsdPacketSize = 1432
+
+// PushConfig controls a Pusher.
+type PushConfig struct {
+	// URL is where snapshots are pushed: statsd://host:port for a StatsD
+	// or Datadog agent, or an http(s) URL that accepts OpenMetrics text
+	// by POST, such as a Prometheus Pushgateway or a remote collector.
+	URL string `toml:"url"`
+
+	// Interval is the time between pushes (default 10s).
+	Interval time.Duration `toml:"interval"`
+
+	// Prefix is prepended to every metric name: joined with "." for
+	// StatsD and "_" for OpenMetrics.
+	Prefix string `toml:"prefix"`
+
+	// Timeout bounds each push (default 5s).
+	Timeout time.Duration `toml:"timeout"`
+
+	// OnError is called with each failed push made by Run.
+	OnError func(error) `toml:"-"`
+}
+
+// Validate checks the URL and durations.
+func (c PushConfig) Validate() error {
+	if _, err := pushTarget(c.URL); err != nil {
+		return err
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("metrics: push interval must be >= 0 (got %s)", c.Interval)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("metrics: push timeout must be >= 0 (got %s)", c.Timeout)
+	}
+	return nil
+}
+
+// pushTarget parses a push URL, accepting the statsd, http, and https
+// schemes.
+func pushTarget(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: push url: %w", err)
+	}
+	switch u.Scheme {
+	case "statsd", "http", "https":
+	default:
+		return nil, fmt.Errorf("metrics: push url %q: scheme must be statsd, http, or https", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("metrics: push url %q: missing host", raw)
+	}
+	return u, nil
+}
+
+// Pusher periodically pushes a gatherer's metrics to a StatsD agent or
+// an HTTP endpoint, for deployments that collect by push rather than by
+// scraping /metricsz.
+//
+//	p, err := metrics.NewPusher(reg, metrics.PushConfig{URL: "statsd://localhost:8125", Prefix: "mist"})
+//	...
+//	go p.Run(ctx)
+//	lifecycle.OnShutdownHook(ctx, "metrics-push", p.Push)
+//
+// StatsD receives each counter's increase since the last successful push
+// and each gauge's value, with labels as DogStatsD tags; a histogram is
+// sent as its .count increase and the .avg of the values observed since.
+// An HTTP endpoint receives the full snapshot as OpenMetrics text. Run
+// stops when its ctx is done, so register Push as a shutdown hook to
+// flush the final values.
+type Pusher struct {
+	g      Gatherer
+	cfg    PushConfig
+	target *url.URL
+	client *http.Client
+
+	mu   sync.Mutex // serializes pushes
+	prev RegistrySnapshot
+}
+
+// NewPusher creates a pusher for g. It returns an error if cfg does not
+// validate.
+func NewPusher(g Gatherer, cfg PushConfig) (*Pusher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultPushInterval
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultPushTimeout
+	}
+	target, _ := pushTarget(cfg.URL)
+	return &Pusher{g: g, cfg: cfg, target: target, client: &http.Client{}}, nil
+}
+
+// Run pushes every Interval until ctx is done.
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Push(ctx); err != nil && p.cfg.OnError != nil {
+				p.cfg.OnError(err)
+			}
+		}
+	}
+}
+
+// Push sends the current snapshot now.
+func (p *Pusher) Push(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snap := p.g.Snapshot()
+	var err error
+	if p.target.Scheme == "statsd" {
+		err = p.pushStatsD(ctx, snap)
+	} else {
+		err = p.pushHTTP(ctx, snap)
+	}
+	if err != nil {
+		return fmt.Errorf("metrics: push to %s: %w", p.target.Redacted(), err)
+	}
+	p.prev = snap
+	return nil
+}
+
+func (p *Pusher) pushStatsD(ctx context.Context, snap RegistrySnapshot) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", p.target.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, packet := range statsdPackets(snap.Delta(p.prev), p.cfg.Prefix) {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pusher) pushHTTP(ctx context.Context, snap RegistrySnapshot) error {
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, snap, p.cfg.Prefix); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.target.String(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", OpenMetricsContentType)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// statsdPackets encodes d as StatsD lines, packed into datagrams of at
+// most statsdPacketSize bytes.
+func statsdPackets(d RegistryDelta, prefix string) [][]byte {
+	var lines []string
+	for _, key := range slices.Sorted(maps.Keys(d.Counters)) {
+		c := d.Counters[key]
+		lines = append(lines, statsdLine(prefix, c.Name, strconv.FormatInt(c.Delta, 10), "c", c.Labels))
+	}
+	for _, key := range slices.Sorted(maps.Keys(d.Gauges)) {
+		g := d.Gauges[key]
+		lines = append(lines, statsdLine(prefix, g.Name, formatFloat(g.Value), "g", g.Labels))
+	}
+	for _, key := range slices.Sorted(maps.Keys(d.Histograms)) {
+		h := d.Histograms[key]
+		lines = append(lines, statsdLine(prefix, h.Name+".count", strconv.FormatInt(h.Count, 10), "c", h.Labels))
+		if h.Count > 0 {
+			lines = append(lines, statsdLine(prefix, h.Name+".avg", formatFloat(h.Avg), "g", h.Labels))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(d.Summaries)) {
+		s := d.Summaries[key]
+		lines = append(lines, statsdLine(prefix, s.Name+".count", strconv.FormatInt(s.Count, 10), "c", s.Labels))
+		if s.Count > 0 {
+			lines = append(lines, statsdLine(prefix, s.Name+".avg", formatFloat(s.Avg), "g", s.Labels))
+		}
+		for _, q := range slices.Sorted(maps.Keys(s.Quantiles)) {
+			// Quantile 0.99 is sent as <name>.p99.
+			p, _ := strconv.ParseFloat(q, 64)
+			lines = append(lines, statsdLine(prefix, s.Name+".p"+strconv.FormatFloat(p*100, 'g', 6, 64), formatFloat(s.Quantiles[q]), "g", s.Labels))
+		}
+	}
+
+	var packets [][]byte
+	var cur []byte
+	for _, line := range lines {
+		if len(cur) > 0 && len(cur)+1+len(line) > statsdPacketSize {
+			packets = append(packets, cur)
+			cur = nil
+		}
+		if len(cur) > 0 {
+			cur = append(cur, '\n')
+		}
+		cur = append(cur, line...)
+	}
+	if len(cur) > 0 {
+		packets = append(packets, cur)
+	}
+	return packets
+}
+
+func statsdLine(prefix, name, value, kind string, labels []string) string {
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+	line := statsdName(name) + ":" + value + "|" + kind
+	if len(labels) >= 2 {
+		tags := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			tags = append(tags, statsdName(labels[i])+":"+statsdName(labels[i+1]))
+		}
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsdName replaces the characters StatsD uses as separators.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// OpenMetricsContentType is the media type WriteOpenMetrics produces.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// WriteOpenMetrics writes snap to w in the OpenMetrics text format, with
+// prefix and "_" prepended to every metric name. Counters become
+// <name>_total samples, histograms get cumulative _bucket samples ending
+// in le="+Inf", plus _sum and _count, and summaries get a sample per
+// window quantile, plus _sum and _count.
+func WriteOpenMetrics(w io.Writer, snap RegistrySnapshot, prefix string) error {
+	type family struct {
+		kind  string
+		lines []string
+	}
+	families := map[string]*family{}
+	add := func(name, kind, line string) {
+		f := families[name]
+		if f == nil {
+			f = &family{kind: kind}
+			families[name] = f
+		}
+		f.lines = append(f.lines, line)
+	}
+	name := func(n string) string {
+		if prefix != "" {
+			n = prefix + "_" + n
+		}
+		return openMetricsName(n)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(snap.Counters)) {
+		c := snap.Counters[key]
+		n := strings.TrimSuffix(name(c.Name), "_total")
+		add(n, "counter", n+"_total"+openMetricsLabels(c.Labels)+" "+strconv.FormatInt(c.Value, 10))
+	}
+	for _, key := range slices.Sorted(maps.Keys(snap.Gauges)) {
+		g := snap.Gauges[key]
+		n := name(g.Name)
+		add(n, "gauge", n+openMetricsLabels(g.Labels)+" "+formatFloat(g.Value))
+	}
+	for _, key := range slices.Sorted(maps.Keys(snap.Histograms)) {
+		h := snap.Histograms[key]
+		n := name(h.Name)
+		for _, bound := range slices.Sorted(maps.Keys(h.Buckets)) {
+			le := append(slices.Clip(h.Labels), "le", formatFloat(bound))
+			e, ok := h.Exemplars[bound]
+			add(n, "histogram", n+"_bucket"+openMetricsLabels(le)+" "+strconv.FormatInt(h.Buckets[bound], 10)+openMetricsExemplar(e, ok))
+		}
+		le := append(slices.Clip(h.Labels), "le", "+Inf")
+		e, ok := h.Exemplars[math.Inf(1)]
+		add(n, "histogram", n+"_bucket"+openMetricsLabels(le)+" "+strconv.FormatInt(h.Count, 10)+openMetricsExemplar(e, ok))
+		add(n, "histogram", n+"_sum"+openMetricsLabels(h.Labels)+" "+formatFloat(h.Sum))
+		add(n, "histogram", n+"_count"+openMetricsLabels(h.Labels)+" "+strconv.FormatInt(h.Count, 10))
+	}
+	for _, key := range slices.Sorted(maps.Keys(snap.Summaries)) {
+		s := snap.Summaries[key]
+		n := name(s.Name)
+		for _, q := range slices.Sorted(maps.Keys(s.Quantiles)) {
+			ql := append(slices.Clip(s.Labels), "quantile", formatFloat(q))
+			add(n, "summary", n+openMetricsLabels(ql)+" "+formatFloat(s.Quantiles[q]))
+		}
+		add(n, "summary", n+"_sum"+openMetricsLabels(s.Labels)+" "+formatFloat(s.Sum))
+		add(n, "summary", n+"_count"+openMetricsLabels(s.Labels)+" "+strconv.FormatInt(s.Count, 10))
+	}
+
+	var buf bytes.Buffer
+	for _, n := range slices.Sorted(maps.Keys(families)) {
+		f := families[n]
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", n, f.kind)
+		for _, line := range f.lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteString("# EOF\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// openMetricsName replaces characters not allowed in a metric or label
+// name with underscores.
+func openMetricsName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '_' || r == ':' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func openMetricsLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		fmt.Fprintf(&b, `%s="%s"`, openMetricsName(labels[i]), v)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPusherStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	read := func() string {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, statsdPacketSize)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	reg := NewRegistry()
+	reqs := reg.Counter("requests_total", "path", "/api")
+	reg.Gauge("inflight").Set(3)
+	lat := reg.Histogram("latency_ms", []float64{10, 100})
+	reqs.Add(5)
+	lat.Observe(4)
+	lat.Observe(8)
+
+	p, err := NewPusher(reg, PushConfig{URL: "statsd://" + conn.LocalAddr().String(), Prefix: "mist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := p.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := "mist.requests_total:5|c|#path:/api\nmist.inflight:3|g\nmist.latency_ms.count:2|c\nmist.latency_ms.avg:6|g"
+	if got := read(); got != want {
+		t.Errorf("first push:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Counters are sent as their increase since the last push.
+	reqs.Add(2)
+	if err := p.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = "mist.requests_total:2|c|#path:/api\nmist.inflight:3|g\nmist.latency_ms.count:0|c"
+	if got := read(); got != want {
+		t.Errorf("second push:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStatsDPacketSize(t *testing.T) {
+	reg := NewRegistry()
+	for i := range 200 {
+		reg.Counter("requests_total", "id", strings.Repeat("x", i)).Inc()
+	}
+	packets := statsdPackets(reg.Snapshot().Delta(RegistrySnapshot{}), "")
+	lines := 0
+	for _, p := range packets {
+		if len(p) > statsdPacketSize {
+			t.Errorf("packet of %d bytes", len(p))
+		}
+		lines += strings.Count(string(p), "\n") + 1
+	}
+	if len(packets) < 2 || lines != 200 {
+		t.Errorf("%d lines in %d packets, want 200 across several", lines, len(packets))
+	}
+}
+
+func TestPusherHTTP(t *testing.T) {
+	var body, contentType string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body, contentType = string(data), r.Header.Get("Content-Type")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	reg := NewRegistry()
+	reg.Counter("requests_total").Add(7)
+	p, _ := NewPusher(reg, PushConfig{URL: srv.URL + "/metrics/job/mist"})
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != OpenMetricsContentType || !strings.Contains(body, "requests_total 7\n") {
+		t.Errorf("pushed %q as %q", body, contentType)
+	}
+
+	status = http.StatusBadGateway
+	if err := p.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "status 502") {
+		t.Errorf("Push to a failing endpoint = %v", err)
+	}
+}
+
+func TestPusherRun(t *testing.T) {
+	pushed := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed <- struct{}{}
+	}))
+	defer srv.Close()
+
+	p, _ := NewPusher(NewRegistry(), PushConfig{URL: srv.URL, Interval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	for range 2 {
+		select {
+		case <-pushed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not push")
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("requests_total", "path", `/a"b`).Add(3)
+	reg.Gauge("queue.depth").Set(1.5)
+	h := reg.Histogram("latency_ms", []float64{10, 100})
+	h.Observe(5)
+	h.Observe(50)
+
+	var b strings.Builder
+	if err := WriteOpenMetrics(&b, reg.Snapshot(), "mist"); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE mist_latency_ms histogram
+mist_latency_ms_bucket{le="10"} 1
+mist_latency_ms_bucket{le="100"} 2
+mist_latency_ms_bucket{le="+Inf"} 2
+mist_latency_ms_sum 55
+mist_latency_ms_count 2
+# TYPE mist_queue_depth gauge
+mist_queue_depth 1.5
+# TYPE mist_requests counter
+mist_requests_total{path="/a\"b"} 3
+# EOF
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestPushConfigValidate(t *testing.T) {
+	for _, cfg := range []PushConfig{
+		{},
+		{URL: "udp://localhost:8125"},
+		{URL: "statsd://"},
+		{URL: "http://collector", Interval: -time.Second},
+	} {
+		if cfg.Validate() == nil {
+			t.Errorf("Validate(%+v) = nil", cfg)
+		}
+	}
+	if err := (PushConfig{URL: "statsd://localhost:8125"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultQuantiles are the quantiles a Summary reports in snapshots.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// DefaultSummaryWindow is the window a Summary computes quantiles over
+// when none is given.
+const DefaultSummaryWindow = time.Minute
+
+const (
+	// summaryBuckets is how many slices a Summary's window is divided
+	// into; observations expire a slice at a time.
+	summaryBuckets = 6
+
+	// summarySamples bounds the observations kept per slice. Past it a
+	// uniform sample is kept, so memory stays fixed at any rate.
+	summarySamples = 512
+)
+
+// Summary tracks quantiles over a sliding time window, so they follow a
+// distribution that shifts over a run, where a Histogram's cumulative
+// buckets mostly remember the start. Count and Sum are cumulative like a
+// Histogram's; only the quantiles are windowed.
+//
+// The window is kept as six slices, each holding up to 512 observations
+// (a uniform sample past that), and quantiles are computed from the
+// slices younger than the window, so they lag by at most a sixth of it.
+type Summary struct {
+	name      string
+	labels    []string
+	window    time.Duration
+	quantiles []float64
+	count     atomic.Int64
+	sum       atomic.Uint64 // stored as float64 bits
+
+	mu      sync.Mutex
+	ring    [summaryBuckets]summarySlice
+	now     func() time.Time
+	sampler *rand.Rand
+}
+
+// summarySlice holds the observations of one slice of the window.
+type summarySlice struct {
+	epoch   int64 // slice number since the Unix epoch
+	n       int64 // observations in the slice
+	samples []float64
+}
+
+// Summary returns a summary with the given name, window, and optional
+// label key-value pairs. A window of zero means DefaultSummaryWindow.
+// Snapshots report DefaultQuantiles; Quantile computes any other.
+func (r *Registry) Summary(name string, window time.Duration, labels ...string) *Summary {
+	key := metricKey(name, labels)
+
+	r.mu.RLock()
+	if s, ok := r.summaries[r.resolve(key)]; ok {
+		r.mu.RUnlock()
+		return s
+	}
+	r.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.summaries[r.resolve(key)]; ok {
+		return s
+	}
+	labels, key = r.admit(name, labels, key)
+	if s, ok := r.summaries[key]; ok {
+		return s
+	}
+	s := newSummary(name, window, labels)
+	r.summaries[key] = s
+	r.series[name]++
+	return s
+}
+
+func newSummary(name string, window time.Duration, labels []string) *Summary {
+	if window <= 0 {
+		window = DefaultSummaryWindow
+	}
+	return &Summary{
+		name:      name,
+		labels:    labels,
+		window:    window,
+		quantiles: DefaultQuantiles,
+		now:       time.Now,
+		sampler:   rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+}
+
+// span is the length of one slice of the window.
+func (s *Summary) span() int64 {
+	return max(int64(s.window)/summaryBuckets, 1)
+}
+
+// Observe records a value.
+func (s *Summary) Observe(v float64) {
+	s.count.Add(1)
+	for {
+		old := s.sum.Load()
+		if s.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+
+	epoch := s.now().UnixNano() / s.span()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sl := &s.ring[epoch%summaryBuckets]
+	if sl.epoch != epoch {
+		sl.epoch, sl.n, sl.samples = epoch, 0, sl.samples[:0]
+	}
+	sl.n++
+	if len(sl.samples) < summarySamples {
+		sl.samples = append(sl.samples, v)
+	} else if i := s.sampler.Int64N(sl.n); i < summarySamples {
+		sl.samples[i] = v
+	}
+}
+
+// Quantile returns the q-quantile (0 to 1) of the values observed over
+// the window, or 0 if there were none.
+func (s *Summary) Quantile(q float64) float64 {
+	qs, _ := s.windowQuantiles([]float64{q})
+	return qs[0]
+}
+
+// weighted is a sampled observation standing for weight observations.
+type weighted struct {
+	v, weight float64
+}
+
+// windowQuantiles returns the quantiles qs of the window and how many
+// observations it holds.
+func (s *Summary) windowQuantiles(qs []float64) ([]float64, int64) {
+	oldest := s.now().UnixNano()/s.span() - summaryBuckets + 1
+	var points []weighted
+	var n int64
+	s.mu.Lock()
+	for _, sl := range s.ring {
+		if sl.epoch < oldest || len(sl.samples) == 0 {
+			continue
+		}
+		w := float64(sl.n) / float64(len(sl.samples))
+		for _, v := range sl.samples {
+			points = append(points, weighted{v, w})
+		}
+		n += sl.n
+	}
+	s.mu.Unlock()
+
+	out := make([]float64, len(qs))
+	if n == 0 {
+		return out, 0
+	}
+	slices.SortFunc(points, func(a, b weighted) int { return cmpFloat(a.v, b.v) })
+	for i, q := range qs {
+		target := q * float64(n)
+		var acc float64
+		out[i] = points[len(points)-1].v
+		for _, p := range points {
+			if acc += p.weight; acc >= target {
+				out[i] = p.v
+				break
+			}
+		}
+	}
+	return out, n
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Snapshot returns a point-in-time copy of the summary state.
+func (s *Summary) Snapshot() SummarySnapshot {
+	qs, n := s.windowQuantiles(s.quantiles)
+	snap := SummarySnapshot{
+		Name:        s.name,
+		Labels:      s.labels,
+		Count:       s.count.Load(),
+		Sum:         math.Float64frombits(s.sum.Load()),
+		Window:      s.window,
+		WindowCount: n,
+	}
+	if n > 0 {
+		snap.Quantiles = make(map[float64]float64, len(qs))
+		for i, q := range s.quantiles {
+			snap.Quantiles[q] = qs[i]
+		}
+	}
+	return snap
+}
+
+// SummarySnapshot is a point-in-time summary state. Count and Sum cover
+// every observation; Quantiles cover the WindowCount observations in the
+// window, and are empty when it has none.
+type SummarySnapshot struct {
+	Name        string              `json:"name"`
+	Labels      []string            `json:"labels,omitempty"`
+	Count       int64               `json:"count"`
+	Sum         float64             `json:"sum"`
+	Window      time.Duration       `json:"-"` // use custom marshal
+	WindowCount int64               `json:"window_count"`
+	Quantiles   map[float64]float64 `json:"-"` // use custom marshal
+}
+
+// summaryJSON is the JSON form of a SummarySnapshot: the window in
+// seconds, and quantiles keyed by their %g form, as float64 map keys
+// can't be encoded.
+type summaryJSON struct {
+	Name        string             `json:"name"`
+	Labels      []string           `json:"labels,omitempty"`
+	Count       int64              `json:"count"`
+	Sum         float64            `json:"sum"`
+	WindowS     float64            `json:"window_s"`
+	WindowCount int64              `json:"window_count"`
+	Quantiles   map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// MarshalJSON implements custom JSON marshaling to handle float64 map keys.
+func (s SummarySnapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(summaryJSON{
+		Name: s.Name, Labels: s.Labels, Count: s.Count, Sum: s.Sum,
+		WindowS: s.Window.Seconds(), WindowCount: s.WindowCount,
+		Quantiles: quantileKeys(s.Quantiles),
+	})
+}
+
+// UnmarshalJSON decodes the JSON form written by MarshalJSON.
+func (s *SummarySnapshot) UnmarshalJSON(data []byte) error {
+	var a summaryJSON
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	*s = SummarySnapshot{
+		Name: a.Name, Labels: a.Labels, Count: a.Count, Sum: a.Sum,
+		Window:      time.Duration(a.WindowS * float64(time.Second)),
+		WindowCount: a.WindowCount,
+	}
+	if len(a.Quantiles) > 0 {
+		s.Quantiles = make(map[float64]float64, len(a.Quantiles))
+	}
+	for k, v := range a.Quantiles {
+		q, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			return fmt.Errorf("metrics: summary %s: bad quantile %q", a.Name, k)
+		}
+		s.Quantiles[q] = v
+	}
+	return nil
+}
+
+// quantileKeys returns qs keyed by the %g form of each quantile.
+func quantileKeys(qs map[float64]float64) map[string]float64 {
+	if len(qs) == 0 {
+		return nil
+	}
+	out := make(map[string]float64, len(qs))
+	for q, v := range qs {
+		out[fmt.Sprintf("%g", q)] = v
+	}
+	return out
+}
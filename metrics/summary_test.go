@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a summary clock starting at a fixed time and a func
+// advancing it.
+func fakeClock(s *Summary) func(time.Duration) {
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestSummaryQuantiles(t *testing.T) {
+	s := NewRegistry().Summary("latency_ms", time.Minute)
+	fakeClock(s)
+	for i := 1; i <= 100; i++ {
+		s.Observe(float64(i))
+	}
+	for q, want := range map[float64]float64{0.5: 50, 0.9: 90, 0.99: 99, 1: 100} {
+		if got := s.Quantile(q); got != want {
+			t.Errorf("Quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+	snap := s.Snapshot()
+	if snap.Count != 100 || snap.Sum != 5050 || snap.WindowCount != 100 || snap.Quantiles[0.95] != 95 {
+		t.Errorf("snapshot = %+v", snap)
+	}
+}
+
+func TestSummarySlidingWindow(t *testing.T) {
+	s := NewRegistry().Summary("latency_ms", time.Minute)
+	advance := fakeClock(s)
+	for range 100 {
+		s.Observe(10)
+	}
+	// The distribution shifts: after half a window both show, and after a
+	// full window only the recent values remain.
+	advance(30 * time.Second)
+	for range 100 {
+		s.Observe(500)
+	}
+	if p50, p99 := s.Quantile(0.5), s.Quantile(0.99); p50 != 10 || p99 != 500 {
+		t.Errorf("mid-window p50 = %v, p99 = %v; want 10 and 500", p50, p99)
+	}
+	advance(40 * time.Second)
+	if p50 := s.Quantile(0.5); p50 != 500 {
+		t.Errorf("p50 = %v after the early values expired, want 500", p50)
+	}
+	advance(time.Hour)
+	snap := s.Snapshot()
+	if snap.WindowCount != 0 || snap.Quantiles != nil || snap.Count != 200 {
+		t.Errorf("idle snapshot = %+v; want an empty window and the cumulative count", snap)
+	}
+	if q := s.Quantile(0.5); q != 0 {
+		t.Errorf("Quantile over an empty window = %v, want 0", q)
+	}
+}
+
+func TestSummarySampling(t *testing.T) {
+	s := NewRegistry().Summary("latency_ms", time.Minute)
+	fakeClock(s)
+	const n = 100_000
+	for i := range n {
+		s.Observe(float64(i % 1000))
+	}
+	kept := 0
+	for _, sl := range s.ring {
+		kept += len(sl.samples)
+	}
+	if kept > summarySamples {
+		t.Errorf("%d samples kept, want at most %d", kept, summarySamples)
+	}
+	// A uniform sample of 512 puts the median well within 10%.
+	if p50 := s.Quantile(0.5); math.Abs(p50-500) > 100 {
+		t.Errorf("sampled p50 = %v, want about 500", p50)
+	}
+	if snap := s.Snapshot(); snap.WindowCount != n {
+		t.Errorf("WindowCount = %d, want %d", snap.WindowCount, n)
+	}
+}
+
+func TestSummarySnapshotJSON(t *testing.T) {
+	reg := NewRegistry()
+	s := reg.Summary("latency_ms", 30*time.Second, "path", "/api")
+	for _, v := range []float64{1, 2, 3, 4} {
+		s.Observe(v)
+	}
+	data, err := json.Marshal(reg.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"window_s":30`) || !strings.Contains(string(data), `"0.5":2`) {
+		t.Errorf("JSON = %s", data)
+	}
+	var got RegistrySnapshot
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := s.Snapshot()
+	if !reflect.DeepEqual(got.Summaries["latency_ms{path,/api}"], want) {
+		t.Errorf("round trip:\n got %+v\nwant %+v", got.Summaries["latency_ms{path,/api}"], want)
+	}
+}
+
+func TestSummaryExport(t *testing.T) {
+	reg := NewRegistry()
+	s := reg.Summary("latency_ms", time.Minute)
+	s.Observe(4)
+	s.Observe(8)
+	prev := reg.Snapshot()
+	s.Observe(12)
+
+	d := reg.Delta(prev)
+	sd := d.Summaries["latency_ms"]
+	if sd.Count != 1 || sd.Avg != 12 || sd.Quantiles["0.5"] != 8 {
+		t.Errorf("delta = %+v", sd)
+	}
+
+	var b strings.Builder
+	snap := reg.Snapshot()
+	snap.Summaries["latency_ms"] = SummarySnapshot{Name: "latency_ms", Count: 3, Sum: 24, Quantiles: map[float64]float64{0.5: 8, 0.99: 12}}
+	WriteOpenMetrics(&b, snap, "")
+	want := "# TYPE latency_ms summary\nlatency_ms{quantile=\"0.5\"} 8\nlatency_ms{quantile=\"0.99\"} 12\nlatency_ms_sum 24\nlatency_ms_count 3\n# EOF\n"
+	if b.String() != want {
+		t.Errorf("OpenMetrics:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	packets := statsdPackets(RegistryDelta{Summaries: map[string]SummaryDelta{"latency_ms": {
+		Name: "latency_ms", Count: 3, Avg: 8, Quantiles: map[string]float64{"0.5": 8, "0.99": 12},
+	}}}, "")
+	if got := string(packets[0]); got != "latency_ms.count:3|c\nlatency_ms.avg:8|g\nlatency_ms.p50:8|g\nlatency_ms.p99:12|g" {
+		t.Errorf("StatsD:\n%s", got)
+	}
+
+	merged, err := MergeSnapshots(reg.Snapshot(), reg.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := merged.Summaries["latency_ms"]; m.Count != 6 || m.Sum != 48 || m.Quantiles != nil {
+		t.Errorf("merged = %+v; want summed counts and no quantiles", m)
+	}
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// Observer records observed values. Histogram and Summary implement it.
+type Observer interface {
+	Observe(v float64)
+}
+
+// Timer records durations, in milliseconds, into a Histogram or Summary:
+//
+//	t := reg.Timer("request_duration_ms", "path", "/api")
+//	sw := t.Start()
+//	handle(req)
+//	sw.Stop()
+//
+// or, for a whole function, defer t.Start().Stop().
+type Timer struct {
+	o Observer
+}
+
+// NewTimer creates a timer recording into o, such as a Summary for
+// latency quantiles over a sliding window.
+func NewTimer(o Observer) *Timer {
+	return &Timer{o: o}
+}
+
+// Timer returns a timer recording into the histogram with the given name
+// and label pairs, with DefaultBuckets.
+func (r *Registry) Timer(name string, labels ...string) *Timer {
+	return NewTimer(r.Histogram(name, DefaultBuckets, labels...))
+}
+
+// Observe records d.
+func (t *Timer) Observe(d time.Duration) {
+	t.o.Observe(float64(d) / float64(time.Millisecond))
+}
+
+// Time calls fn and records how long it took.
+func (t *Timer) Time(fn func()) {
+	defer t.Start().Stop()
+	fn()
+}
+
+// Start begins timing one operation.
+func (t *Timer) Start() Stopwatch {
+	return Stopwatch{t: t, start: time.Now()}
+}
+
+// StartContext begins timing one operation made in ctx. If the timer
+// records into a Histogram and ctx carries a sampled span, Stop keeps the
+// span's trace as the bucket's exemplar.
+func (t *Timer) StartContext(ctx context.Context) Stopwatch {
+	return Stopwatch{t: t, ctx: ctx, start: time.Now()}
+}
+
+// Stopwatch times one operation for a Timer.
+type Stopwatch struct {
+	t     *Timer
+	ctx   context.Context
+	start time.Time
+}
+
+// Stop records the time since Start and returns it. Each call records
+// again, so call it once.
+func (s Stopwatch) Stop() time.Duration {
+	d := time.Since(s.start)
+	if h, ok := s.t.o.(*Histogram); ok && s.ctx != nil {
+		h.ObserveContext(s.ctx, float64(d)/float64(time.Millisecond))
+		return d
+	}
+	s.t.Observe(d)
+	return d
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	reg := NewRegistry()
+	timer := reg.Timer("request_duration_ms", "path", "/api")
+
+	sw := timer.Start()
+	time.Sleep(5 * time.Millisecond)
+	d := sw.Stop()
+	if d < 5*time.Millisecond {
+		t.Errorf("Stop = %s, want at least 5ms", d)
+	}
+	timer.Observe(250 * time.Millisecond)
+	timer.Time(func() {})
+
+	h := reg.Histogram("request_duration_ms", DefaultBuckets, "path", "/api").Snapshot()
+	if h.Count != 3 {
+		t.Fatalf("histogram count = %d, want 3", h.Count)
+	}
+	if h.Max != 250 {
+		t.Errorf("max = %v, want 250 (milliseconds)", h.Max)
+	}
+}
+
+func TestTimerSummary(t *testing.T) {
+	s := NewRegistry().Summary("request_duration_ms", time.Minute)
+	timer := NewTimer(s)
+	timer.Observe(1500 * time.Microsecond)
+	if q := s.Quantile(0.5); q != 1.5 {
+		t.Errorf("p50 = %v, want 1.5", q)
+	}
+}
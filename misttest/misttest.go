@@ -1,6 +1,8 @@
 // Package misttest provides testing utilities for MIST tool authors.
 // It includes mock transports, fault injection, and record/replay
-// functionality for integration testing without real network connections.
+// functionality for integration testing without real network connections,
+// plus metric and span capture for asserting on observability output and
+// a stub LLM provider server for hermetic provider tests.
 package misttest
 
 import (
@@ -124,9 +126,7 @@ type FaultConfig struct {
 // Use it to test error handling and resilience in tool code.
 type FaultTransport struct {
 	inner Transport
-	cfg   FaultConfig
-	mu    sync.Mutex
-	rng   *rand.Rand
+	*faults
 }
 
 // Transport is the interface that FaultTransport wraps. This matches
@@ -142,11 +142,7 @@ func NewFault(inner Transport, cfg FaultConfig) *FaultTransport {
 	if cfg.Error == nil {
 		cfg.Error = fmt.Errorf("fault injected")
 	}
-	return &FaultTransport{
-		inner: inner,
-		cfg:   cfg,
-		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
-	}
+	return &FaultTransport{inner: inner, faults: newFaults(cfg)}
 }
 
 // Send sends through the inner transport, possibly injecting a fault.
@@ -172,7 +168,19 @@ func (f *FaultTransport) Close() error {
 	return f.inner.Close()
 }
 
-func (f *FaultTransport) shouldFail() bool {
+// faults decides, per operation, the delay and whether to fail under a
+// FaultConfig. It is shared by FaultTransport and FaultHandler.
+type faults struct {
+	cfg FaultConfig
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFaults(cfg FaultConfig) *faults {
+	return &faults{cfg: cfg, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (f *faults) shouldFail() bool {
 	if f.cfg.ErrorRate <= 0 {
 		return false
 	}
@@ -182,7 +190,7 @@ func (f *FaultTransport) shouldFail() bool {
 	return r < f.cfg.ErrorRate
 }
 
-func (f *FaultTransport) applyDelay(ctx context.Context) {
+func (f *faults) applyDelay(ctx context.Context) {
 	d := f.cfg.Delay
 	if f.cfg.DelayJitter > 0 {
 		f.mu.Lock()
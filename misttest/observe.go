@@ -0,0 +1,188 @@
+package misttest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// CaptureRegistry is a metrics.Registry with assertion helpers. Pass it
+// (or its embedded Registry) wherever code under test records metrics:
+//
+//	reg := misttest.NewCaptureRegistry()
+//	pool := parallel.NewPool(4, parallel.WithRegistry(reg.Registry))
+//	// ... exercise pool ...
+//	reg.AssertCounter(t, "task_panics_total", nil, 1)
+type CaptureRegistry struct {
+	*metrics.Registry
+}
+
+// NewCaptureRegistry creates an empty capturing registry.
+func NewCaptureRegistry() *CaptureRegistry {
+	return &CaptureRegistry{Registry: metrics.NewRegistry()}
+}
+
+// AssertCounter fails t unless the counter with the given name and label
+// pairs exists and has value want.
+func (c *CaptureRegistry) AssertCounter(t testing.TB, name string, labels []string, want int64) {
+	t.Helper()
+	snap := c.Snapshot()
+	for _, s := range snap.Counters {
+		if s.Name == name && slices.Equal(s.Labels, labels) {
+			if s.Value != want {
+				t.Errorf("counter %s = %d, want %d", describe(name, labels), s.Value, want)
+			}
+			return
+		}
+	}
+	t.Errorf("counter %s not recorded; have %s", describe(name, labels), keys(snap.Counters))
+}
+
+// AssertGauge fails t unless the gauge with the given name and label
+// pairs exists and has value want.
+func (c *CaptureRegistry) AssertGauge(t testing.TB, name string, labels []string, want float64) {
+	t.Helper()
+	snap := c.Snapshot()
+	for _, s := range snap.Gauges {
+		if s.Name == name && slices.Equal(s.Labels, labels) {
+			if s.Value != want {
+				t.Errorf("gauge %s = %v, want %v", describe(name, labels), s.Value, want)
+			}
+			return
+		}
+	}
+	t.Errorf("gauge %s not recorded; have %s", describe(name, labels), keys(snap.Gauges))
+}
+
+// AssertHistogramCount fails t unless the histogram with the given name
+// and label pairs exists and has recorded want observations.
+func (c *CaptureRegistry) AssertHistogramCount(t testing.TB, name string, labels []string, want int64) {
+	t.Helper()
+	snap := c.Snapshot()
+	for _, s := range snap.Histograms {
+		if s.Name == name && slices.Equal(s.Labels, labels) {
+			if s.Count != want {
+				t.Errorf("histogram %s count = %d, want %d", describe(name, labels), s.Count, want)
+			}
+			return
+		}
+	}
+	t.Errorf("histogram %s not recorded; have %s", describe(name, labels), keys(snap.Histograms))
+}
+
+func describe(name string, labels []string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
+
+func keys[V any](m map[string]V) string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return "[" + strings.Join(out, " ") + "]"
+}
+
+// AttrMatcher reports whether a span's attributes are acceptable.
+type AttrMatcher func(attrs map[string]any) bool
+
+// HasAttrs matches spans carrying every given attribute. Values are
+// compared by their fmt.Sprint form, so 500 matches int64(500).
+func HasAttrs(want map[string]any) AttrMatcher {
+	return func(attrs map[string]any) bool {
+		for k, v := range want {
+			got, ok := attrs[k]
+			if !ok || fmt.Sprint(got) != fmt.Sprint(v) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// SpanCollector is a trace.Exporter that keeps ended spans in memory.
+//
+//	spans := misttest.NewSpanCollector()
+//	ctx := spans.Context(context.Background())
+//	handle(ctx, req)
+//	spans.AssertSpan(t, "inference", misttest.HasAttrs(map[string]any{"model": "m1"}))
+type SpanCollector struct {
+	mu    sync.Mutex
+	spans []protocol.TraceSpan
+}
+
+// NewSpanCollector creates an empty collector.
+func NewSpanCollector() *SpanCollector {
+	return &SpanCollector{}
+}
+
+// ExportSpan records a span. It implements trace.Exporter.
+func (c *SpanCollector) ExportSpan(s protocol.TraceSpan) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, s)
+}
+
+// Context returns ctx with the collector as its span exporter.
+func (c *SpanCollector) Context(ctx context.Context) context.Context {
+	return trace.WithExporter(ctx, c)
+}
+
+// Spans returns a copy of the collected spans in the order they ended.
+func (c *SpanCollector) Spans() []protocol.TraceSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]protocol.TraceSpan(nil), c.spans...)
+}
+
+// Find returns the collected spans with the given operation.
+func (c *SpanCollector) Find(operation string) []protocol.TraceSpan {
+	var out []protocol.TraceSpan
+	for _, s := range c.Spans() {
+		if s.Operation == operation {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Reset discards all collected spans.
+func (c *SpanCollector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = nil
+}
+
+// AssertSpan fails t unless a span with the given operation was collected
+// whose attributes satisfy match (nil matches any). It returns the first
+// matching span.
+func (c *SpanCollector) AssertSpan(t testing.TB, operation string, match AttrMatcher) protocol.TraceSpan {
+	t.Helper()
+	found := c.Find(operation)
+	for _, s := range found {
+		if match == nil || match(s.Attrs) {
+			return s
+		}
+	}
+	if len(found) == 0 {
+		ops := make([]string, 0)
+		for _, s := range c.Spans() {
+			ops = append(ops, s.Operation)
+		}
+		t.Errorf("no span %q; have %v", operation, ops)
+	} else {
+		t.Errorf("no span %q with matching attributes; have %d with attrs %v", operation, len(found), found[0].Attrs)
+	}
+	return protocol.TraceSpan{}
+}
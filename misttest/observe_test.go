@@ -0,0 +1,73 @@
+package misttest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// fakeT records failures instead of failing the real test.
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (f *fakeT) Helper() {}
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCaptureRegistry(t *testing.T) {
+	reg := NewCaptureRegistry()
+	reg.Counter("requests_total", "method", "GET").Add(3)
+	reg.Gauge("inflight").Set(2)
+	reg.Histogram("latency_ms", metrics.DefaultBuckets).Observe(12)
+
+	reg.AssertCounter(t, "requests_total", []string{"method", "GET"}, 3)
+	reg.AssertGauge(t, "inflight", nil, 2)
+	reg.AssertHistogramCount(t, "latency_ms", nil, 1)
+
+	ft := &fakeT{}
+	reg.AssertCounter(ft, "requests_total", []string{"method", "GET"}, 4)
+	reg.AssertCounter(ft, "requests_total", []string{"method", "POST"}, 1)
+	if len(ft.errors) != 2 {
+		t.Fatalf("errors = %q", ft.errors)
+	}
+	if !strings.Contains(ft.errors[1], "not recorded") || !strings.Contains(ft.errors[1], "requests_total{method,GET}") {
+		t.Errorf("missing-metric message = %q", ft.errors[1])
+	}
+}
+
+func TestSpanCollector(t *testing.T) {
+	spans := NewSpanCollector()
+	ctx := spans.Context(context.Background())
+
+	ctx, root := trace.Start(ctx, "request")
+	_, inf := trace.Start(ctx, "inference")
+	inf.SetAttr("model", "m1")
+	inf.SetAttr("tokens_out", 500)
+	inf.End("ok")
+	root.End("ok")
+
+	s := spans.AssertSpan(t, "inference", HasAttrs(map[string]any{"model": "m1", "tokens_out": int64(500)}))
+	if s.ParentID != root.SpanID {
+		t.Errorf("parent = %q, want %q", s.ParentID, root.SpanID)
+	}
+	spans.AssertSpan(t, "request", nil)
+
+	ft := &fakeT{}
+	spans.AssertSpan(ft, "inference", HasAttrs(map[string]any{"model": "m2"}))
+	spans.AssertSpan(ft, "missing", nil)
+	if len(ft.errors) != 2 {
+		t.Errorf("errors = %q", ft.errors)
+	}
+
+	spans.Reset()
+	if len(spans.Spans()) != 0 {
+		t.Error("Reset did not clear spans")
+	}
+}
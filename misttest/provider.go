@@ -0,0 +1,356 @@
+package misttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Wire formats emulated by ProviderStub.
+const (
+	FormatOpenAI    = "openai"    // POST /v1/chat/completions
+	FormatAnthropic = "anthropic" // POST /v1/messages
+)
+
+// StubResponse scripts one reply from a ProviderStub.
+type StubResponse struct {
+	// Text is the assistant reply. Streaming requests receive it in
+	// Chunks if set, otherwise split on spaces.
+	Text   string
+	Chunks []string
+
+	// TokensIn and TokensOut are reported as usage. Zero means estimate
+	// from the request body and Text.
+	TokensIn  int
+	TokensOut int
+
+	// Status, if set and not 200, returns an error in the provider's
+	// format. 429 adds a Retry-After header from RetryAfter.
+	Status     int
+	RetryAfter time.Duration
+
+	// Malformed returns a 200 with a truncated JSON body (or, when
+	// streaming, a corrupt chunk) to exercise decoder error paths.
+	Malformed bool
+
+	// Delay is slept before responding, or between stream chunks.
+	Delay time.Duration
+}
+
+// StubRequest is a request received by a ProviderStub.
+type StubRequest struct {
+	Format string
+	Model  string
+	Stream bool
+	Header http.Header
+	Body   []byte
+}
+
+// ProviderStub is an HTTP server emulating the OpenAI chat completions
+// and Anthropic messages APIs with scripted responses, so provider
+// clients can be tested without network access or API keys. Point a
+// client's base URL at URL.
+//
+//	stub := misttest.NewProviderStub(
+//	    misttest.StubResponse{Status: 429, RetryAfter: time.Second},
+//	    misttest.StubResponse{Text: "hello"},
+//	)
+//	defer stub.Close()
+//
+// Scripted responses are consumed in order; once the script is exhausted
+// every request gets the default reply ("ok", or SetDefault's).
+type ProviderStub struct {
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	script   []StubResponse
+	fallback StubResponse
+	requests []StubRequest
+	seq      int
+}
+
+// NewProviderStub starts a stub server with the given script.
+func NewProviderStub(script ...StubResponse) *ProviderStub {
+	s := &ProviderStub{
+		script:   script,
+		fallback: StubResponse{Text: "ok"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, FormatOpenAI)
+	})
+	mux.HandleFunc("POST /v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, FormatAnthropic)
+	})
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Enqueue appends responses to the script.
+func (s *ProviderStub) Enqueue(rs ...StubResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, rs...)
+}
+
+// RateLimit enqueues n 429 responses with the given Retry-After.
+func (s *ProviderStub) RateLimit(n int, retryAfter time.Duration) {
+	for range n {
+		s.Enqueue(StubResponse{Status: http.StatusTooManyRequests, RetryAfter: retryAfter})
+	}
+}
+
+// SetDefault sets the reply used once the script is exhausted.
+func (s *ProviderStub) SetDefault(r StubResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = r
+}
+
+// Requests returns the requests received so far.
+func (s *ProviderStub) Requests() []StubRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StubRequest(nil), s.requests...)
+}
+
+// Close shuts down the server.
+func (s *ProviderStub) Close() {
+	s.srv.Close()
+}
+
+// stubRequestBody is the subset of both request formats the stub reads.
+type stubRequestBody struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+func (s *ProviderStub) serve(w http.ResponseWriter, r *http.Request, format string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeProviderError(w, format, http.StatusBadRequest, "read error")
+		return
+	}
+
+	var req stubRequestBody
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeProviderError(w, format, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, StubRequest{
+		Format: format,
+		Model:  req.Model,
+		Stream: req.Stream,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	resp := s.fallback
+	if len(s.script) > 0 {
+		resp = s.script[0]
+		s.script = s.script[1:]
+	}
+	s.seq++
+	id := s.seq
+	s.mu.Unlock()
+
+	if resp.TokensIn == 0 {
+		resp.TokensIn = len(body)/4 + 1
+	}
+	if resp.TokensOut == 0 {
+		resp.TokensOut = len(strings.Fields(resp.Text))
+	}
+
+	failed := resp.Status != 0 && resp.Status != http.StatusOK
+	if resp.Delay > 0 && (failed || !req.Stream) {
+		time.Sleep(resp.Delay)
+	}
+
+	if failed {
+		if resp.Status == http.StatusTooManyRequests && resp.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((resp.RetryAfter+time.Second-1)/time.Second)))
+		}
+		writeProviderError(w, format, resp.Status, http.StatusText(resp.Status))
+		return
+	}
+
+	if req.Stream {
+		s.stream(w, format, id, req.Model, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Malformed {
+		fmt.Fprintf(w, `{"id": "stub-%d", "choices": [{"message": {"content": "%s`, id, resp.Text)
+		return
+	}
+	var out any
+	switch format {
+	case FormatOpenAI:
+		out = map[string]any{
+			"id":      fmt.Sprintf("chatcmpl-stub-%d", id),
+			"object":  "chat.completion",
+			"created": time.Now().Unix(),
+			"model":   req.Model,
+			"choices": []any{map[string]any{
+				"index":         0,
+				"message":       map[string]any{"role": "assistant", "content": resp.Text},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]any{
+				"prompt_tokens":     resp.TokensIn,
+				"completion_tokens": resp.TokensOut,
+				"total_tokens":      resp.TokensIn + resp.TokensOut,
+			},
+		}
+	case FormatAnthropic:
+		out = map[string]any{
+			"id":          fmt.Sprintf("msg_stub_%d", id),
+			"type":        "message",
+			"role":        "assistant",
+			"model":       req.Model,
+			"content":     []any{map[string]any{"type": "text", "text": resp.Text}},
+			"stop_reason": "end_turn",
+			"usage": map[string]any{
+				"input_tokens":  resp.TokensIn,
+				"output_tokens": resp.TokensOut,
+			},
+		}
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// stream writes resp as server-sent events in the provider's format.
+func (s *ProviderStub) stream(w http.ResponseWriter, format string, id int, model string, resp StubResponse) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+
+	chunks := resp.Chunks
+	if chunks == nil {
+		for i, word := range strings.Fields(resp.Text) {
+			if i > 0 {
+				word = " " + word
+			}
+			chunks = append(chunks, word)
+		}
+	}
+
+	send := func(event string, v any) {
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	pause := func() {
+		if resp.Delay > 0 {
+			time.Sleep(resp.Delay)
+		}
+	}
+
+	switch format {
+	case FormatOpenAI:
+		chunkID := fmt.Sprintf("chatcmpl-stub-%d", id)
+		for i, c := range chunks {
+			pause()
+			if resp.Malformed && i == len(chunks)/2 {
+				fmt.Fprint(w, "data: {\"id\": \"broken\n\n")
+				continue
+			}
+			send("", map[string]any{
+				"id": chunkID, "object": "chat.completion.chunk", "model": model,
+				"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": c}, "finish_reason": nil}},
+			})
+		}
+		send("", map[string]any{
+			"id": chunkID, "object": "chat.completion.chunk", "model": model,
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{}, "finish_reason": "stop"}},
+			"usage": map[string]any{
+				"prompt_tokens":     resp.TokensIn,
+				"completion_tokens": resp.TokensOut,
+				"total_tokens":      resp.TokensIn + resp.TokensOut,
+			},
+		})
+		fmt.Fprint(w, "data: [DONE]\n\n")
+
+	case FormatAnthropic:
+		send("message_start", map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id": fmt.Sprintf("msg_stub_%d", id), "type": "message", "role": "assistant",
+				"model": model, "content": []any{},
+				"usage": map[string]any{"input_tokens": resp.TokensIn, "output_tokens": 0},
+			},
+		})
+		send("content_block_start", map[string]any{
+			"type": "content_block_start", "index": 0,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		})
+		for i, c := range chunks {
+			pause()
+			if resp.Malformed && i == len(chunks)/2 {
+				fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\": \"content_blo\n\n")
+				continue
+			}
+			send("content_block_delta", map[string]any{
+				"type": "content_block_delta", "index": 0,
+				"delta": map[string]any{"type": "text_delta", "text": c},
+			})
+		}
+		send("content_block_stop", map[string]any{"type": "content_block_stop", "index": 0})
+		send("message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": "end_turn"},
+			"usage": map[string]any{"output_tokens": resp.TokensOut},
+		})
+		send("message_stop", map[string]any{"type": "message_stop"})
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// writeProviderError writes an error body in the provider's format.
+func writeProviderError(w http.ResponseWriter, format string, status int, message string) {
+	errType := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		errType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case 529:
+		errType = "overloaded_error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	var body any
+	switch format {
+	case FormatAnthropic:
+		body = map[string]any{
+			"type":  "error",
+			"error": map[string]any{"type": errType, "message": message},
+		}
+	default:
+		body = map[string]any{
+			"error": map[string]any{"message": message, "type": errType, "code": nil},
+		}
+	}
+	json.NewEncoder(w).Encode(body)
+}
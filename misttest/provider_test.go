@@ -0,0 +1,182 @@
+package misttest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postStub(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestProviderStubOpenAI(t *testing.T) {
+	stub := NewProviderStub(StubResponse{Text: "hello there", TokensIn: 7})
+	defer stub.Close()
+
+	resp := postStub(t, stub.URL+"/v1/chat/completions",
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var out struct {
+		Choices []struct {
+			Message struct{ Content string }
+		}
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Choices[0].Message.Content != "hello there" || out.Usage.PromptTokens != 7 || out.Usage.CompletionTokens != 2 {
+		t.Errorf("response = %+v", out)
+	}
+
+	reqs := stub.Requests()
+	if len(reqs) != 1 || reqs[0].Format != FormatOpenAI || reqs[0].Model != "gpt-4o" {
+		t.Errorf("requests = %+v", reqs)
+	}
+}
+
+func TestProviderStubAnthropic(t *testing.T) {
+	stub := NewProviderStub(StubResponse{Text: "bonjour"})
+	defer stub.Close()
+
+	resp := postStub(t, stub.URL+"/v1/messages", `{"model":"claude","max_tokens":10,"messages":[]}`)
+	var out struct {
+		Type    string
+		Content []struct{ Type, Text string }
+		Usage   struct {
+			OutputTokens int `json:"output_tokens"`
+		}
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if out.Type != "message" || out.Content[0].Text != "bonjour" || out.Usage.OutputTokens != 1 {
+		t.Errorf("response = %+v", out)
+	}
+
+	// Script exhausted: default reply.
+	resp = postStub(t, stub.URL+"/v1/messages", `{"model":"claude"}`)
+	json.NewDecoder(resp.Body).Decode(&out)
+	if out.Content[0].Text != "ok" {
+		t.Errorf("default reply = %q", out.Content[0].Text)
+	}
+}
+
+func TestProviderStubRateLimit(t *testing.T) {
+	stub := NewProviderStub()
+	defer stub.Close()
+	stub.RateLimit(2, 1500*time.Millisecond)
+
+	for i := range 2 {
+		resp := postStub(t, stub.URL+"/v1/messages", `{"model":"claude"}`)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("attempt %d status = %d", i, resp.StatusCode)
+		}
+		if ra := resp.Header.Get("Retry-After"); ra != "2" {
+			t.Errorf("Retry-After = %q, want 2", ra)
+		}
+		var e struct {
+			Type  string
+			Error struct{ Type string }
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Type != "error" || e.Error.Type != "rate_limit_error" {
+			t.Errorf("error body = %+v", e)
+		}
+	}
+	if resp := postStub(t, stub.URL+"/v1/messages", `{"model":"claude"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("after rate limit status = %d", resp.StatusCode)
+	}
+}
+
+func TestProviderStubMalformed(t *testing.T) {
+	stub := NewProviderStub(StubResponse{Text: "x", Malformed: true})
+	defer stub.Close()
+
+	resp := postStub(t, stub.URL+"/v1/chat/completions", `{"model":"gpt"}`)
+	var v any
+	if err := json.NewDecoder(resp.Body).Decode(&v); err == nil {
+		t.Error("malformed body decoded without error")
+	}
+}
+
+func TestProviderStubStreaming(t *testing.T) {
+	stub := NewProviderStub(
+		StubResponse{Chunks: []string{"Hel", "lo"}},
+		StubResponse{Text: "one two three"},
+	)
+	defer stub.Close()
+
+	resp := postStub(t, stub.URL+"/v1/chat/completions", `{"model":"gpt","stream":true}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type = %q", ct)
+	}
+	var text strings.Builder
+	var done bool
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct{ Content string }
+			}
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("chunk %q: %v", data, err)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if text.String() != "Hello" || !done {
+		t.Errorf("streamed %q, done=%v", text.String(), done)
+	}
+
+	resp = postStub(t, stub.URL+"/v1/messages", `{"model":"claude","stream":true}`)
+	text.Reset()
+	var events []string
+	sc = bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if ev, ok := strings.CutPrefix(line, "event: "); ok {
+			events = append(events, ev)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var ev struct {
+			Type  string
+			Delta struct{ Text string }
+		}
+		json.Unmarshal([]byte(data), &ev)
+		if ev.Type == "content_block_delta" {
+			text.WriteString(ev.Delta.Text)
+		}
+	}
+	if text.String() != "one two three" {
+		t.Errorf("streamed %q", text.String())
+	}
+	if events[0] != "message_start" || events[len(events)-1] != "message_stop" {
+		t.Errorf("events = %v", events)
+	}
+}
@@ -0,0 +1,67 @@
+package misttest
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// FaultHandler wraps an HTTP handler and injects the faults of a
+// FaultConfig into every request: the Delay plus up to DelayJitter is
+// added before the request reaches the inner handler, and a fraction
+// ErrorRate of requests fail without reaching it. A failed request is
+// answered with FaultConfig.Error through misterrors.WriteHTTP, so the
+// status follows its code; the default error is CodeUnavailable (503).
+//
+// Put it in front of a real service to test how its clients cope with a
+// slow or flaky dependency:
+//
+//	h := misttest.NewFaultProxy(upstream, misttest.FaultConfig{
+//		Delay:     200 * time.Millisecond,
+//		ErrorRate: 0.01,
+//	})
+//	http.ListenAndServe(":9090", h)
+type FaultHandler struct {
+	inner http.Handler
+	*faults
+
+	requests, injected atomic.Int64
+}
+
+// NewFaultHandler creates a fault-injecting wrapper around inner.
+func NewFaultHandler(inner http.Handler, cfg FaultConfig) *FaultHandler {
+	if cfg.Error == nil {
+		cfg.Error = misterrors.New(misterrors.CodeUnavailable, "fault injected")
+	}
+	return &FaultHandler{inner: inner, faults: newFaults(cfg)}
+}
+
+// NewFaultProxy creates a FaultHandler in front of a reverse proxy to
+// upstream, for injecting faults between two deployed services.
+func NewFaultProxy(upstream *url.URL, cfg FaultConfig) *FaultHandler {
+	return NewFaultHandler(httputil.NewSingleHostReverseProxy(upstream), cfg)
+}
+
+// ServeHTTP delays the request, then fails it or passes it on.
+func (f *FaultHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	f.applyDelay(r.Context())
+	if r.Context().Err() != nil {
+		return
+	}
+	if f.shouldFail() {
+		f.injected.Add(1)
+		misterrors.WriteHTTP(w, r, f.cfg.Error)
+		return
+	}
+	f.inner.ServeHTTP(w, r)
+}
+
+// Stats returns the number of requests handled and how many of them
+// failed with an injected fault.
+func (f *FaultHandler) Stats() (requests, injected int64) {
+	return f.requests.Load(), f.injected.Load()
+}
@@ -0,0 +1,76 @@
+package misttest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+func TestFaultProxyPassesThrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	h := NewFaultProxy(u, FaultConfig{Delay: 30 * time.Millisecond})
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+
+	start := time.Now()
+	resp, err := http.Get(proxy.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || string(body) != "upstream /healthz" {
+		t.Errorf("got %d %q", resp.StatusCode, body)
+	}
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("delay too short: %v", elapsed)
+	}
+	if n, injected := h.Stats(); n != 1 || injected != 0 {
+		t.Errorf("Stats() = %d, %d; want 1, 0", n, injected)
+	}
+}
+
+func TestFaultHandlerInjectsErrors(t *testing.T) {
+	reached := false
+	h := NewFaultHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}), FaultConfig{ErrorRate: 1.0})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if reached {
+		t.Error("request should not reach inner handler")
+	}
+	if _, injected := h.Stats(); injected != 1 {
+		t.Errorf("injected = %d, want 1", injected)
+	}
+}
+
+func TestFaultHandlerCustomError(t *testing.T) {
+	h := NewFaultHandler(http.NotFoundHandler(), FaultConfig{
+		ErrorRate: 1.0,
+		Error:     misterrors.New(misterrors.CodeRateLimit, "slow down"),
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", rec.Code)
+	}
+}
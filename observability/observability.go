@@ -0,0 +1,177 @@
+// Package observability sets up consistent telemetry for a MIST tool in
+// one call: a structured logger (also installed as the slog default), a
+// metrics registry, span export to TokenTrace, and a resource monitor
+// with CPU sampling, all tied into lifecycle for a clean shutdown.
+//
+//	func main() {
+//	    obs, err := observability.Init(observability.Config{
+//	        Tool:     "matchspec",
+//	        TraceURL: os.Getenv("TOKENTRACE_URL"),
+//	    })
+//	    if err != nil {
+//	        log.Fatal(err)
+//	    }
+//	    lifecycle.Main(func(ctx context.Context) error {
+//	        obs.Start(ctx)
+//	        srv := server.New(":8080")
+//	        obs.Mount(srv.Mux())
+//	        obs.Logger.Info(ctx, "started")
+//	        return srv.ListenAndServe()
+//	    }, obs.LifecycleOptions()...)
+//	}
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/greynewell/mist-go/lifecycle"
+	"github.com/greynewell/mist-go/logging"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/resource"
+	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// Config selects how a tool's telemetry is set up. Only Tool is
+// required.
+type Config struct {
+	// Tool names the tool in log records and as the span source.
+	Tool string
+
+	// LogLevel is the minimum level: debug, info, warn, or error.
+	// Default info. It can be changed at runtime with Logger.SetLevel.
+	LogLevel string
+
+	// LogFormat is "json" (default) or "text".
+	LogFormat string
+
+	// LogWriter receives log output. Default os.Stderr.
+	LogWriter io.Writer
+
+	// LogSpans writes a log record for every ended span, including the
+	// listed SpanLogAttrs (see logging.LogSpans).
+	LogSpans     bool
+	SpanLogAttrs []string
+
+	// TraceURL is the TokenTrace base URL spans are reported to. Empty
+	// disables reporting.
+	TraceURL string
+
+	// CPUInterval is how often CPU usage is sampled once started.
+	// Default 5s; negative disables CPU sampling.
+	CPUInterval time.Duration
+
+	// MaxProcs sets GOMAXPROCS from the container CPU quota.
+	MaxProcs bool
+
+	// MaxSeries limits each metric name in Metrics to this many label
+	// combinations (see metrics.WithMaxSeries). Zero means no limit.
+	MaxSeries int
+}
+
+// Telemetry holds the handles created by Init.
+type Telemetry struct {
+	Logger  *logging.Logger
+	Metrics *metrics.Registry
+
+	// Reporter is already the trace exporter when TraceURL is set, so
+	// ended spans reach it without being reported explicitly.
+	Reporter  *tokentrace.Reporter
+	Resources *resource.Monitor
+	CPU       *resource.CPUSampler // nil if CPU sampling is disabled
+
+	cpuInterval time.Duration
+}
+
+// Init builds the tool's telemetry from cfg. The logger becomes the slog
+// default and, if TraceURL is set, the Reporter becomes the trace
+// exporter, so spans from every package reach TokenTrace. Call Start
+// inside lifecycle.Run to begin background work.
+func Init(cfg Config) (*Telemetry, error) {
+	if cfg.Tool == "" {
+		return nil, fmt.Errorf("observability: Tool is required")
+	}
+
+	level := logging.LevelInfo
+	if cfg.LogLevel != "" {
+		var err error
+		if level, err = logging.ParseLevel(cfg.LogLevel); err != nil {
+			return nil, fmt.Errorf("observability: %w", err)
+		}
+	}
+	var logOpts []logging.Option
+	if cfg.LogFormat != "" {
+		if cfg.LogFormat != "json" && cfg.LogFormat != "text" {
+			return nil, fmt.Errorf("observability: unknown log format %q", cfg.LogFormat)
+		}
+		logOpts = append(logOpts, logging.WithFormat(cfg.LogFormat))
+	}
+	if cfg.LogWriter != nil {
+		logOpts = append(logOpts, logging.WithWriter(cfg.LogWriter))
+	}
+
+	t := &Telemetry{
+		Logger:      logging.New(cfg.Tool, level, logOpts...),
+		Metrics:     metrics.NewRegistry(metrics.WithMaxSeries(cfg.MaxSeries)),
+		Resources:   resource.NewMonitor(),
+		cpuInterval: cfg.CPUInterval,
+	}
+	slog.SetDefault(t.Logger.Slog())
+
+	t.Reporter = tokentrace.NewReporter(cfg.Tool, cfg.TraceURL, tokentrace.WithReporterMetrics(t.Metrics))
+	if cfg.TraceURL != "" {
+		trace.SetExporter(t.Reporter)
+	}
+	if cfg.LogSpans {
+		logging.LogSpans(t.Logger, cfg.SpanLogAttrs...)
+	}
+
+	if cfg.MaxProcs {
+		resource.SetMaxProcs()
+	}
+	if t.cpuInterval == 0 {
+		t.cpuInterval = 5 * time.Second
+	}
+	if t.cpuInterval > 0 {
+		t.CPU = resource.NewCPUSampler()
+		t.Resources.TrackCPU(t.CPU)
+	}
+	return t, nil
+}
+
+// LifecycleOptions returns the lifecycle options that record shutdowns
+// in the metrics registry.
+func (t *Telemetry) LifecycleOptions() []lifecycle.Option {
+	return []lifecycle.Option{lifecycle.WithRegistry(t.Metrics)}
+}
+
+// Start begins CPU sampling until ctx is done and registers a shutdown
+// hook that flushes queued spans. ctx should come from lifecycle.Run.
+func (t *Telemetry) Start(ctx context.Context) {
+	if t.CPU != nil {
+		t.CPU.Start(ctx, t.cpuInterval)
+	}
+	t.Reporter.CloseOnShutdown(ctx)
+}
+
+// Mount serves GET /metricsz (the metrics registry) and GET /resourcez
+// (resource monitor status) on mux.
+func (t *Telemetry) Mount(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metricsz", t.Metrics.Handler())
+	mux.HandleFunc("GET /resourcez", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Resources.Status())
+	})
+}
+
+// Close flushes queued spans. Tools not using lifecycle call it before
+// exiting; under lifecycle, Start arranges it.
+func (t *Telemetry) Close(ctx context.Context) error {
+	return t.Reporter.Close(ctx)
+}
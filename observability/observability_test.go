@@ -0,0 +1,149 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/greynewell/mist-go/lifecycle"
+	"github.com/greynewell/mist-go/trace"
+	"github.com/greynewell/mist-go/transport"
+)
+
+// restoreGlobals undoes Init's process-wide settings after a test.
+func restoreGlobals(t *testing.T) {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		trace.SetExporter(nil)
+	})
+}
+
+func TestInitValidation(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Tool: "x", LogLevel: "loud"},
+		{Tool: "x", LogFormat: "xml"},
+	} {
+		if _, err := Init(cfg); err == nil {
+			t.Errorf("Init(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestInitLogging(t *testing.T) {
+	restoreGlobals(t)
+	var buf bytes.Buffer
+	obs, err := Init(Config{Tool: "matchspec", LogLevel: "debug", LogWriter: &buf, LogSpans: true, SpanLogAttrs: []string{"model"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Debug("via default")
+	if !strings.Contains(buf.String(), `"tool":"matchspec"`) || !strings.Contains(buf.String(), "via default") {
+		t.Errorf("slog default not installed: %s", buf.String())
+	}
+	buf.Reset()
+
+	_, span := trace.Start(context.Background(), "eval")
+	span.SetAttr("model", "m1")
+	span.End("ok")
+	if !strings.Contains(buf.String(), `"operation":"eval"`) || !strings.Contains(buf.String(), `"model":"m1"`) {
+		t.Errorf("span not logged: %s", buf.String())
+	}
+	if obs.CPU == nil {
+		t.Error("CPU sampler not created by default")
+	}
+}
+
+func TestMount(t *testing.T) {
+	restoreGlobals(t)
+	obs, err := Init(Config{Tool: "test", LogWriter: &bytes.Buffer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs.Metrics.Counter("requests_total").Inc()
+
+	mux := http.NewServeMux()
+	obs.Mount(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metricsz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap map[string]any
+	json.NewDecoder(resp.Body).Decode(&snap)
+	resp.Body.Close()
+	if !strings.Contains(toJSON(snap), "requests_total") {
+		t.Errorf("/metricsz = %v", snap)
+	}
+
+	resp, err = http.Get(ts.URL + "/resourcez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status map[string]any
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if _, ok := status["cpu"]; !ok {
+		t.Errorf("/resourcez = %v, want cpu entry", status)
+	}
+}
+
+func toJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func TestStartFlushesSpansOnShutdown(t *testing.T) {
+	restoreGlobals(t)
+	var received atomic.Int64
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		msgs, err := transport.DecodeMessages(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received.Add(int64(len(msgs)))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+
+	obs, err := Init(Config{Tool: "test", TraceURL: collector.URL, LogWriter: &bytes.Buffer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := lifecycle.RunReport(func(ctx context.Context) error {
+		obs.Start(ctx)
+		for range 3 {
+			_, span := trace.Start(ctx, "op")
+			span.End("ok")
+		}
+		return nil
+	}, obs.LifecycleOptions()...)
+	if err != nil {
+		t.Fatalf("RunReport: %v", err)
+	}
+
+	if received.Load() != 3 {
+		t.Errorf("collector received %d spans, want 3", received.Load())
+	}
+	if rep.Reason != lifecycle.ReasonCompleted {
+		t.Errorf("reason = %s", rep.Reason)
+	}
+	if n := obs.Metrics.Counter("tokentrace_reporter_sent_total").Value(); n != 3 {
+		t.Errorf("sent_total = %d", n)
+	}
+}
@@ -0,0 +1,49 @@
+package parallel
+
+import (
+	"context"
+	"fmt"
+)
+
+// MapChunks splits inputs into consecutive chunks of at most chunkSize
+// items and applies fn to each chunk concurrently. It is meant for APIs
+// with batch endpoints (embeddings, bulk ingest) where one call per item
+// would be wasteful.
+//
+// fn must return exactly one output per input, in order. Results are
+// flattened back into input order; if fn fails for a chunk (or returns
+// the wrong number of outputs), every item in that chunk carries the
+// error. A chunkSize below 1 is treated as 1.
+func MapChunks[In, Out any](ctx context.Context, p *Pool, inputs []In, chunkSize int, fn func(context.Context, []In) ([]Out, error)) []Result[Out] {
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	chunks := make([][]In, 0, (len(inputs)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(inputs); start += chunkSize {
+		end := min(start+chunkSize, len(inputs))
+		chunks = append(chunks, inputs[start:end:end])
+	}
+
+	chunkResults := Map(ctx, p, chunks, func(ctx context.Context, chunk []In) ([]Out, error) {
+		out, err := fn(ctx, chunk)
+		if err == nil && len(out) != len(chunk) {
+			err = fmt.Errorf("parallel: chunk returned %d results for %d inputs", len(out), len(chunk))
+		}
+		return out, err
+	})
+
+	results := make([]Result[Out], 0, len(inputs))
+	for i, cr := range chunkResults {
+		if cr.Err != nil {
+			for range chunks[i] {
+				results = append(results, Result[Out]{Err: cr.Err})
+			}
+			continue
+		}
+		for _, v := range cr.Value {
+			results = append(results, Result[Out]{Value: v})
+		}
+	}
+	return results
+}
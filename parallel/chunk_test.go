@@ -0,0 +1,80 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMapChunksPreservesOrder(t *testing.T) {
+	inputs := make([]int, 10)
+	for i := range inputs {
+		inputs[i] = i
+	}
+
+	var calls atomic.Int32
+	results := MapChunks(context.Background(), NewPool(3), inputs, 4, func(_ context.Context, chunk []int) ([]int, error) {
+		calls.Add(1)
+		if len(chunk) > 4 {
+			t.Errorf("chunk size %d exceeds 4", len(chunk))
+		}
+		out := make([]int, len(chunk))
+		for i, n := range chunk {
+			out[i] = n * n
+		}
+		return out, nil
+	})
+
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3 chunks", calls.Load())
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("len = %d, want %d", len(results), len(inputs))
+	}
+	for i, r := range results {
+		if r.Err != nil || r.Value != i*i {
+			t.Errorf("result[%d] = %+v, want %d", i, r, i*i)
+		}
+	}
+}
+
+func TestMapChunksErrorAppliesToChunk(t *testing.T) {
+	boom := errors.New("batch rejected")
+	results := MapChunks(context.Background(), NewPool(2), []string{"a", "b", "c", "d", "e"}, 2,
+		func(_ context.Context, chunk []string) ([]string, error) {
+			if chunk[0] == "c" {
+				return nil, boom
+			}
+			return chunk, nil
+		})
+
+	for i, r := range results {
+		failed := i == 2 || i == 3
+		if failed != errors.Is(r.Err, boom) {
+			t.Errorf("result[%d] err = %v", i, r.Err)
+		}
+	}
+}
+
+func TestMapChunksLengthMismatch(t *testing.T) {
+	results := MapChunks(context.Background(), NewPool(1), []int{1, 2, 3}, 3,
+		func(_ context.Context, chunk []int) ([]int, error) {
+			return chunk[:1], nil
+		})
+	for i, r := range results {
+		if r.Err == nil {
+			t.Errorf("result[%d] should report length mismatch", i)
+		}
+	}
+}
+
+func TestMapChunksEmpty(t *testing.T) {
+	results := MapChunks(context.Background(), NewPool(1), nil, 8, func(_ context.Context, chunk []int) ([]int, error) {
+		t.Error("fn should not be called")
+		return nil, nil
+	})
+	if len(results) != 0 {
+		t.Errorf("len = %d", len(results))
+	}
+}
@@ -4,21 +4,77 @@ package parallel
 
 import (
 	"context"
+	"strconv"
 	"sync"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/resource"
 )
 
 // Pool executes work functions concurrently with a bounded number of
 // goroutines. Use Map for transforming slices and Do for side-effecting work.
+//
+// A panic in a work function is recovered and recorded as that item's
+// error (see errors.IsPanic); the remaining items still run.
 type Pool struct {
 	workers int
+
+	registry *metrics.Registry
+	panics   *metrics.Counter
+}
+
+// PoolOption configures a Pool.
+type PoolOption func(*Pool)
+
+// WithRegistry records pool metrics (task_panics_total) in reg instead
+// of a private registry.
+func WithRegistry(reg *metrics.Registry) PoolOption {
+	return func(p *Pool) { p.registry = reg }
 }
 
 // NewPool creates a pool with the given concurrency limit.
-func NewPool(workers int) *Pool {
+func NewPool(workers int, opts ...PoolOption) *Pool {
 	if workers < 1 {
 		workers = 1
 	}
-	return &Pool{workers: workers}
+	p := &Pool{workers: workers}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.registry == nil {
+		p.registry = metrics.NewRegistry()
+	}
+	p.panics = p.registry.Counter("task_panics_total")
+	return p
+}
+
+// NewDefaultPool creates a pool sized to resource.EffectiveCPUs, which
+// honours container CPU quotas.
+func NewDefaultPool(opts ...PoolOption) *Pool {
+	return NewPool(resource.EffectiveCPUs(), opts...)
+}
+
+// Registry returns the metrics registry the pool records into.
+func (p *Pool) Registry() *metrics.Registry {
+	return p.registry
+}
+
+// Panics returns the number of work functions that have panicked.
+func (p *Pool) Panics() int64 {
+	return p.panics.Value()
+}
+
+// call runs fn for the item at idx, converting a panic into an internal
+// error that carries the stack trace and the item index.
+func call[In, Out any](ctx context.Context, p *Pool, idx int, in In, fn func(context.Context, In) (Out, error)) (out Out, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			p.panics.Inc()
+			err = misterrors.Recovered(v).WithMeta("index", strconv.Itoa(idx))
+		}
+	}()
+	return fn(ctx, in)
 }
 
 // Result holds the output and error from a single work item.
@@ -48,7 +104,7 @@ func Map[In, Out any](ctx context.Context, p *Pool, inputs []In, fn func(context
 			defer wg.Done()
 			defer func() { <-sem }()
 
-			val, err := fn(ctx, in)
+			val, err := call(ctx, p, idx, in, fn)
 			results[idx] = Result[Out]{Value: val, Err: err}
 		}(i, input)
 	}
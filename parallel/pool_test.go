@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"sync/atomic"
 	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/resource"
 )
 
 func TestMap(t *testing.T) {
@@ -169,3 +173,59 @@ func TestPoolConcurrencyLimit(t *testing.T) {
 		t.Errorf("max concurrent = %d, want <= 2", maxSeen.Load())
 	}
 }
+
+func TestMapRecoversPanics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	p := NewPool(2, WithRegistry(reg))
+
+	results := Map(context.Background(), p, []int{1, 2, 3, 4}, func(_ context.Context, n int) (int, error) {
+		if n%2 == 0 {
+			panic(fmt.Sprintf("bad item %d", n))
+		}
+		return n * 10, nil
+	})
+
+	for i, r := range results {
+		n := i + 1
+		if n%2 == 1 {
+			if r.Err != nil || r.Value != n*10 {
+				t.Errorf("result[%d] = %+v, want %d", i, r, n*10)
+			}
+			continue
+		}
+		if !misterrors.IsPanic(r.Err) {
+			t.Errorf("result[%d] err = %v, want recovered panic", i, r.Err)
+			continue
+		}
+		var e *misterrors.Error
+		misterrors.As(r.Err, &e)
+		if e.Meta["index"] != fmt.Sprint(i) || e.Stack == "" {
+			t.Errorf("result[%d] error missing index or stack: %+v", i, e)
+		}
+	}
+
+	if p.Panics() != 2 {
+		t.Errorf("Panics = %d, want 2", p.Panics())
+	}
+	if reg.Counter("task_panics_total").Value() != 2 {
+		t.Error("panic metric not recorded in shared registry")
+	}
+}
+
+func TestDoReturnsPanicError(t *testing.T) {
+	err := Do(context.Background(), NewPool(1), []int{1}, func(context.Context, int) error {
+		var m map[string]int
+		m["x"] = 1 // nil map write
+		return nil
+	})
+	if !misterrors.IsPanic(err) {
+		t.Errorf("err = %v, want recovered panic", err)
+	}
+}
+
+func TestNewDefaultPool(t *testing.T) {
+	p := NewDefaultPool()
+	if p.workers != resource.EffectiveCPUs() {
+		t.Errorf("workers = %d, want %d", p.workers, resource.EffectiveCPUs())
+	}
+}
@@ -0,0 +1,126 @@
+package parallel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Stage is one step of a concurrent pipeline. Each stage has its own
+// pool of workers and a bounded output buffer; a slow stage fills its
+// upstream buffer and blocks earlier stages, so memory stays bounded.
+// Stages are composed with Chain and started with Run.
+//
+//	enrich := parallel.NewStage(parallel.NewPool(8), 64, fetchMetadata)
+//	store := parallel.NewStage(parallel.NewPool(2), 16, writeRecord)
+//	out, wait := parallel.Chain(enrich, store).Run(ctx, parallel.Source(ctx, ids))
+//	for r := range out { ... }
+//	if err := wait(); err != nil { ... }
+//
+// Items are processed concurrently, so output order is not preserved.
+type Stage[In, Out any] struct {
+	run func(ctx context.Context, in <-chan In, fail func(error)) <-chan Out
+}
+
+// NewStage creates a stage that applies fn to each item using the pool's
+// workers and buffers up to buffer outputs. The first error from fn (or
+// a recovered panic) stops the whole pipeline.
+func NewStage[In, Out any](p *Pool, buffer int, fn func(context.Context, In) (Out, error)) Stage[In, Out] {
+	if buffer < 0 {
+		buffer = 0
+	}
+	return Stage[In, Out]{run: func(ctx context.Context, in <-chan In, fail func(error)) <-chan Out {
+		out := make(chan Out, buffer)
+		var seq atomic.Int64
+		var wg sync.WaitGroup
+
+		for range p.workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					var item In
+					var ok bool
+					select {
+					case <-ctx.Done():
+						return
+					case item, ok = <-in:
+						if !ok {
+							return
+						}
+					}
+
+					v, err := call(ctx, p, int(seq.Add(1)-1), item, fn)
+					if err != nil {
+						fail(err)
+						return
+					}
+
+					select {
+					case out <- v:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+
+		go func() {
+			wg.Wait()
+			close(out)
+		}()
+		return out
+	}}
+}
+
+// Chain connects two stages so the output of first feeds second.
+// Chains nest: Chain(Chain(a, b), c).
+func Chain[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return Stage[A, C]{run: func(ctx context.Context, in <-chan A, fail func(error)) <-chan C {
+		return second.run(ctx, first.run(ctx, in, fail), fail)
+	}}
+}
+
+// Run starts the pipeline reading from in. The caller should range over
+// the returned channel and then call wait, which drains any unread
+// output, waits for the final stage to finish, and returns the first
+// stage error or ctx's error. After a failure, stages stop reading in,
+// so producers feeding in (including Source) should select on a ctx the
+// caller cancels once wait returns.
+func (s Stage[In, Out]) Run(ctx context.Context, in <-chan In) (<-chan Out, func() error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() { cancel(err) })
+	}
+
+	out := s.run(ctx, in, fail)
+	wait := func() error {
+		for range out {
+		}
+		var err error
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+		cancel(nil)
+		return err
+	}
+	return out, wait
+}
+
+// Source returns a channel that yields items in order and then closes.
+// It stops early if ctx is cancelled.
+func Source[T any](ctx context.Context, items []T) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for _, item := range items {
+			select {
+			case ch <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
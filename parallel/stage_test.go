@@ -0,0 +1,125 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+func TestChainStages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	double := NewStage(NewPool(4), 8, func(_ context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	format := NewStage(NewPool(2), 8, func(_ context.Context, n int) (string, error) {
+		return strconv.Itoa(n), nil
+	})
+
+	inputs := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	out, wait := Chain(double, format).Run(ctx, Source(ctx, inputs))
+
+	var got []string
+	for s := range out {
+		got = append(got, s)
+	}
+	if err := wait(); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	sort.Slice(got, func(i, j int) bool {
+		a, _ := strconv.Atoi(got[i])
+		b, _ := strconv.Atoi(got[j])
+		return a < b
+	})
+	for i, s := range got {
+		if want := strconv.Itoa(inputs[i] * 2); s != want {
+			t.Errorf("got[%d] = %s, want %s", i, s, want)
+		}
+	}
+	if len(got) != len(inputs) {
+		t.Errorf("len = %d, want %d", len(got), len(inputs))
+	}
+}
+
+func TestStageErrorStopsPipeline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	boom := errors.New("store failed")
+	var stored atomic.Int32
+	pass := NewStage(NewPool(2), 1, func(_ context.Context, n int) (int, error) { return n, nil })
+	store := NewStage(NewPool(1), 1, func(_ context.Context, n int) (int, error) {
+		if n == 3 {
+			return 0, boom
+		}
+		stored.Add(1)
+		return n, nil
+	})
+
+	items := make([]int, 1000)
+	for i := range items {
+		items[i] = i
+	}
+	_, wait := Chain(pass, store).Run(ctx, Source(ctx, items))
+	if err := wait(); !errors.Is(err, boom) {
+		t.Fatalf("wait err = %v, want %v", err, boom)
+	}
+	if stored.Load() >= int32(len(items)-1) {
+		t.Errorf("pipeline kept processing after failure: stored %d", stored.Load())
+	}
+}
+
+func TestStagePanicIsError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewStage(NewPool(1), 0, func(_ context.Context, n int) (int, error) {
+		panic("stage exploded")
+	})
+	_, wait := s.Run(ctx, Source(ctx, []int{1}))
+	if err := wait(); !misterrors.IsPanic(err) {
+		t.Errorf("wait err = %v, want recovered panic", err)
+	}
+}
+
+func TestStageBackpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var produced atomic.Int32
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			select {
+			case in <- i:
+				produced.Add(1)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	s := NewStage(NewPool(1), 2, func(_ context.Context, n int) (int, error) { return n, nil })
+	out, wait := s.Run(ctx, in)
+
+	// Nobody reads out: one worker plus a buffer of 2 bounds progress.
+	time.Sleep(50 * time.Millisecond)
+	if n := produced.Load(); n > 5 {
+		t.Errorf("produced %d items without a reader, want backpressure", n)
+	}
+
+	<-out
+	cancel()
+	if err := wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package platform
+
+import (
+	"syscall"
+	"time"
+)
+
+// ProcessCPUTime returns the total user plus system CPU time consumed by
+// this process.
+func ProcessCPUTime() (time.Duration, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package platform
+
+import (
+	"syscall"
+	"time"
+)
+
+// ProcessCPUTime returns the total user plus kernel CPU time consumed by
+// this process.
+func ProcessCPUTime() (time.Duration, error) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, err
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return 0, err
+	}
+	return filetimeDuration(kernel) + filetimeDuration(user), nil
+}
+
+// filetimeDuration converts a FILETIME interval (100ns ticks) to a Duration.
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	ticks := int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+	return time.Duration(ticks * 100)
+}
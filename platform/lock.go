@@ -17,72 +17,97 @@ import (
 //	}
 //	defer lock.Unlock()
 type FileLock struct {
-	path string
-	f    *os.File
+	path   string
+	f      *os.File
+	shared bool
 }
 
 // Lock acquires an exclusive lock on the given file path.
 // The file is created if it doesn't exist.
 // Returns an error if the lock is already held by another process.
 func Lock(path string) (*FileLock, error) {
-	abs, err := filepath.Abs(path)
+	l, lockErr, err := acquire("lock", path, lockFile, false)
 	if err != nil {
-		return nil, fmt.Errorf("platform: lock: %w", err)
+		return nil, err
 	}
-
-	// Ensure parent directory exists.
-	dir := filepath.Dir(abs)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return nil, fmt.Errorf("platform: lock: mkdir: %w", err)
+	if lockErr != nil {
+		return nil, fmt.Errorf("platform: lock: %w", lockErr)
 	}
+	return l, nil
+}
 
-	f, err := os.OpenFile(abs, os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("platform: lock: open: %w", err)
+// TryLock attempts to acquire an exclusive lock without blocking.
+// Returns nil, nil if the lock is already held.
+func TryLock(path string) (*FileLock, error) {
+	l, lockErr, err := acquire("trylock", path, tryLockFile, false)
+	if lockErr != nil {
+		return nil, nil // Lock is held by another process.
 	}
+	return l, err
+}
 
-	if err := lockFile(f); err != nil {
-		f.Close()
-		return nil, fmt.Errorf("platform: lock: %w", err)
+// TryRLock attempts to acquire a shared lock without blocking. Any
+// number of shared locks on a path may be held at once, but none while
+// an exclusive lock is. Returns nil, nil if an exclusive lock is held.
+// Unlocking a shared lock leaves the file in place.
+func TryRLock(path string) (*FileLock, error) {
+	l, lockErr, err := acquire("tryrlock", path, tryRLockFile, true)
+	if lockErr != nil {
+		return nil, nil // An exclusive lock is held by another process.
 	}
-
-	return &FileLock{path: abs, f: f}, nil
+	return l, err
 }
 
-// TryLock attempts to acquire an exclusive lock without blocking.
-// Returns nil, nil if the lock is already held.
-func TryLock(path string) (*FileLock, error) {
+// acquire opens the file at path, creating it and its directory, and
+// locks it with lock, returning lock's error as lockErr. An exclusive
+// holder removes the file when it unlocks, so a lock taken on a file
+// that has meanwhile been removed is dropped and taken again on the
+// file now at path; otherwise two processes could each hold a lock on a
+// different file of the same name.
+func acquire(op, path string, lock func(*os.File) error, shared bool) (l *FileLock, lockErr, err error) {
 	abs, err := filepath.Abs(path)
 	if err != nil {
-		return nil, fmt.Errorf("platform: trylock: %w", err)
+		return nil, nil, fmt.Errorf("platform: %s: %w", op, err)
 	}
 
+	// Ensure parent directory exists.
 	dir := filepath.Dir(abs)
 	if err := os.MkdirAll(dir, 0700); err != nil {
-		return nil, fmt.Errorf("platform: trylock: mkdir: %w", err)
+		return nil, nil, fmt.Errorf("platform: %s: mkdir: %w", op, err)
 	}
 
-	f, err := os.OpenFile(abs, os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("platform: trylock: open: %w", err)
-	}
-
-	if err := tryLockFile(f); err != nil {
+	for {
+		f, err := os.OpenFile(abs, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("platform: %s: open: %w", op, err)
+		}
+		if err := lock(f); err != nil {
+			f.Close()
+			return nil, err, nil
+		}
+		locked, err1 := f.Stat()
+		current, err2 := os.Stat(abs)
+		if err1 == nil && err2 == nil && os.SameFile(locked, current) {
+			return &FileLock{path: abs, f: f, shared: shared}, nil, nil
+		}
+		unlockFile(f)
 		f.Close()
-		return nil, nil // Lock is held by another process.
 	}
-
-	return &FileLock{path: abs, f: f}, nil
 }
 
-// Unlock releases the file lock and removes the lock file.
+// Unlock releases the file lock. An exclusive lock also removes the lock
+// file, before releasing it so no other process locks the removed file.
 func (l *FileLock) Unlock() error {
 	if l.f == nil {
 		return nil
 	}
+	removed := l.shared || os.Remove(l.path) == nil
 	unlockFile(l.f)
 	err := l.f.Close()
-	os.Remove(l.path)
+	if !removed {
+		// Windows doesn't remove a file that is open.
+		os.Remove(l.path)
+	}
 	l.f = nil
 	return err
 }
@@ -55,6 +55,21 @@ func tryLockFile(f *os.File) error {
 	return nil
 }
 
+func tryRLockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	r1, _, err := procLockFileEx.Call(
+		uintptr(f.Fd()),
+		uintptr(lockfileFailImmediately),
+		0,
+		1, 0,
+		uintptr(unsafe.Pointer(&ol)),
+	)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
 func unlockFile(f *os.File) {
 	var ol syscall.Overlapped
 	procUnlockFile.Call(
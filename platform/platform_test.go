@@ -229,3 +229,38 @@ func TestNormalizeLineEndingsLarge(t *testing.T) {
 		}
 	}
 }
+
+func TestTryRLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	r1, err := TryRLock(path)
+	if err != nil || r1 == nil {
+		t.Fatalf("TryRLock = %v, %v", r1, err)
+	}
+	r2, err := TryRLock(path)
+	if err != nil || r2 == nil {
+		t.Fatalf("second TryRLock = %v, %v; want shared locks to coexist", r2, err)
+	}
+	if l, _ := TryLock(path); l != nil {
+		l.Unlock()
+		t.Fatal("TryLock succeeded while shared locks are held")
+	}
+
+	// Releasing one shared lock leaves the file for the other.
+	r1.Unlock()
+	if l, _ := TryLock(path); l != nil {
+		l.Unlock()
+		t.Fatal("TryLock succeeded while a shared lock is held")
+	}
+	r2.Unlock()
+
+	l, err := TryLock(path)
+	if err != nil || l == nil {
+		t.Fatalf("TryLock after shared locks released = %v, %v", l, err)
+	}
+	if r, _ := TryRLock(path); r != nil {
+		r.Unlock()
+		t.Error("TryRLock succeeded while an exclusive lock is held")
+	}
+	l.Unlock()
+}
@@ -0,0 +1,227 @@
+// Package pricing provides the shared LLM pricing model for MIST tools:
+// per-model input and output token rates, with date-effective versions,
+// loaded from TOML and hot-reloadable.
+//
+// A pricing file has one table per rate version. The table name is a
+// label; model defaults to it, and effective (YYYY-MM-DD) defaults to
+// the zero time, meaning "always":
+//
+//	[prices.gpt-4o]
+//	input_per_mtok = 5.0
+//	output_per_mtok = 15.0
+//
+//	[prices.gpt-4o-2024-08]
+//	model = "gpt-4o"
+//	effective = "2024-08-06"
+//	input_per_mtok = 2.5
+//	output_per_mtok = 10.0
+//
+// Usage:
+//
+//	table, err := pricing.Load("pricing.toml")
+//	cost := table.Cost("gpt-4o", tokensIn, tokensOut, time.Now())
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/greynewell/mist-go/config"
+)
+
+// Price is the cost of a model's tokens in USD per million tokens.
+type Price struct {
+	InputPerMTok  float64 `json:"input_per_mtok"`
+	OutputPerMTok float64 `json:"output_per_mtok"`
+}
+
+// Cost returns the USD cost of the given token counts.
+func (p Price) Cost(tokensIn, tokensOut int64) float64 {
+	return (float64(tokensIn)*p.InputPerMTok + float64(tokensOut)*p.OutputPerMTok) / 1e6
+}
+
+// Rate is one version of a model's price, in effect from Effective until
+// the next version's Effective.
+type Rate struct {
+	Model     string    `json:"model"`
+	Effective time.Time `json:"effective"`
+	Price
+}
+
+// Table holds rates for a set of models. It is immutable once built.
+type Table struct {
+	rates map[string][]Rate // model → versions, oldest first
+}
+
+// NewTable builds a table from rates in any order.
+func NewTable(rates ...Rate) *Table {
+	t := &Table{rates: make(map[string][]Rate)}
+	for _, r := range rates {
+		t.rates[r.Model] = append(t.rates[r.Model], r)
+	}
+	for _, versions := range t.rates {
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i].Effective.Before(versions[j].Effective)
+		})
+	}
+	return t
+}
+
+// Load reads a pricing TOML file.
+func Load(path string) (*Table, error) {
+	cfg, err := config.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("pricing: %w", err)
+	}
+	return FromConfig(cfg)
+}
+
+// FromConfig builds a table from the [prices.*] tables of cfg.
+func FromConfig(cfg *config.Config) (*Table, error) {
+	raw, _ := cfg.Map()["prices"].(map[string]any)
+	rates := make([]Rate, 0, len(raw))
+	for label, v := range raw {
+		entry, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("pricing: prices.%s: want a table", label)
+		}
+		r := Rate{Model: label}
+		if m, ok := entry["model"].(string); ok && m != "" {
+			r.Model = m
+		}
+		if s, ok := entry["effective"].(string); ok && s != "" {
+			eff, err := time.Parse(time.DateOnly, s)
+			if err != nil {
+				return nil, fmt.Errorf("pricing: prices.%s: effective: %w", label, err)
+			}
+			r.Effective = eff
+		}
+		var err error
+		if r.InputPerMTok, err = number(entry, "input_per_mtok"); err != nil {
+			return nil, fmt.Errorf("pricing: prices.%s: %w", label, err)
+		}
+		if r.OutputPerMTok, err = number(entry, "output_per_mtok"); err != nil {
+			return nil, fmt.Errorf("pricing: prices.%s: %w", label, err)
+		}
+		rates = append(rates, r)
+	}
+	return NewTable(rates...), nil
+}
+
+func number(entry map[string]any, key string) (float64, error) {
+	switch v := entry[key].(type) {
+	case float64:
+		if v < 0 {
+			return 0, fmt.Errorf("%s: negative rate %v", key, v)
+		}
+		return v, nil
+	case int64:
+		if v < 0 {
+			return 0, fmt.Errorf("%s: negative rate %d", key, v)
+		}
+		return float64(v), nil
+	case nil:
+		return 0, fmt.Errorf("%s: missing", key)
+	default:
+		return 0, fmt.Errorf("%s: want a number, got %T", key, v)
+	}
+}
+
+// Lookup returns the rate for model in effect at the given time.
+func (t *Table) Lookup(model string, at time.Time) (Rate, bool) {
+	versions := t.rates[model]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].Effective.After(at) {
+			return versions[i], true
+		}
+	}
+	return Rate{}, false
+}
+
+// Price returns the current price for model. It lets a Table serve as an
+// infermux.Pricer.
+func (t *Table) Price(model string) (Price, bool) {
+	r, ok := t.Lookup(model, time.Now())
+	return r.Price, ok
+}
+
+// Cost returns the USD cost of a request to model at the given time, or
+// zero if the model is unknown.
+func (t *Table) Cost(model string, tokensIn, tokensOut int64, at time.Time) float64 {
+	r, _ := t.Lookup(model, at)
+	return r.Cost(tokensIn, tokensOut)
+}
+
+// Models returns the priced model names, sorted.
+func (t *Table) Models() []string {
+	models := make([]string, 0, len(t.rates))
+	for m := range t.rates {
+		models = append(models, m)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// Has reports whether model has any rate.
+func (t *Table) Has(model string) bool {
+	return len(t.rates[model]) > 0
+}
+
+// Source is a Table that can be replaced at runtime, for hot reload.
+// Its methods always use the most recently stored table.
+type Source struct {
+	table atomic.Pointer[Table]
+}
+
+// NewSource creates a source holding t.
+func NewSource(t *Table) *Source {
+	s := &Source{}
+	s.Store(t)
+	return s
+}
+
+// Load returns the current table.
+func (s *Source) Load() *Table {
+	return s.table.Load()
+}
+
+// Store replaces the current table.
+func (s *Source) Store(t *Table) {
+	if t == nil {
+		t = NewTable()
+	}
+	s.table.Store(t)
+}
+
+// Price returns the current price for model from the current table.
+func (s *Source) Price(model string) (Price, bool) {
+	return s.Load().Price(model)
+}
+
+// Watch loads the pricing file at path into a Source and reloads it
+// whenever the file changes, until ctx is done. A file that fails to
+// load on reload is logged and the previous table stays in effect; a
+// failure on the initial load is returned.
+func Watch(ctx context.Context, path string, interval time.Duration) (*Source, error) {
+	t, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	src := NewSource(t)
+	go config.Watch(ctx, path, interval, func(cfg *config.Config, err error) {
+		var t *Table
+		if err == nil {
+			t, err = FromConfig(cfg)
+		}
+		if err != nil {
+			slog.Default().Warn("pricing: reload failed; keeping previous table", "path", path, "error", err)
+			return
+		}
+		src.Store(t)
+	})
+	return src, nil
+}
@@ -0,0 +1,118 @@
+package pricing
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/config"
+)
+
+const sample = `
+[prices.gpt-4o]
+input_per_mtok = 5.0
+output_per_mtok = 15
+
+[prices.gpt-4o-2024-08]
+model = "gpt-4o"
+effective = "2024-08-06"
+input_per_mtok = 2.5
+output_per_mtok = 10.0
+
+[prices.sonnet]
+model = "claude-sonnet-4.5"
+input_per_mtok = 3
+output_per_mtok = 15
+`
+
+func parse(t *testing.T, src string) *Table {
+	t.Helper()
+	data, err := config.ParseTOML(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := FromConfig(config.New(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestLookupEffectiveVersions(t *testing.T) {
+	table := parse(t, sample)
+
+	old, ok := table.Lookup("gpt-4o", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if !ok || old.InputPerMTok != 5 || old.OutputPerMTok != 15 {
+		t.Errorf("before cut-over = %+v", old)
+	}
+	cur, ok := table.Lookup("gpt-4o", time.Date(2024, 8, 6, 0, 0, 0, 0, time.UTC))
+	if !ok || cur.InputPerMTok != 2.5 {
+		t.Errorf("on effective date = %+v", cur)
+	}
+	if p, ok := table.Price("claude-sonnet-4.5"); !ok || p.InputPerMTok != 3 {
+		t.Errorf("dotted model name = %+v, %v", p, ok)
+	}
+	if _, ok := table.Price("unknown"); ok {
+		t.Error("unknown model priced")
+	}
+
+	got := table.Cost("gpt-4o", 1_000_000, 500_000, time.Now())
+	if math.Abs(got-7.5) > 1e-9 {
+		t.Errorf("cost = %v, want 7.5", got)
+	}
+	if m := table.Models(); len(m) != 2 || m[0] != "claude-sonnet-4.5" {
+		t.Errorf("models = %v", m)
+	}
+}
+
+func TestFromConfigErrors(t *testing.T) {
+	for _, src := range []string{
+		"[prices.a]\noutput_per_mtok = 1\n",
+		"[prices.a]\ninput_per_mtok = \"cheap\"\noutput_per_mtok = 1\n",
+		"[prices.a]\ninput_per_mtok = -1\noutput_per_mtok = 1\n",
+		"[prices.a]\neffective = \"yesterday\"\ninput_per_mtok = 1\noutput_per_mtok = 1\n",
+	} {
+		data, _ := config.ParseTOML(strings.NewReader(src))
+		if _, err := FromConfig(config.New(data)); err == nil {
+			t.Errorf("no error for %q", src)
+		}
+	}
+}
+
+func TestWatchReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.toml")
+	os.WriteFile(path, []byte("[prices.m]\ninput_per_mtok = 1\noutput_per_mtok = 2\n"), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src, err := Watch(ctx, path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := src.Price("m"); p.InputPerMTok != 1 {
+		t.Fatalf("initial price = %+v", p)
+	}
+
+	os.WriteFile(path, []byte("[prices.m]\ninput_per_mtok = 4\noutput_per_mtok = 2\n"), 0o644)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if p, _ := src.Price("m"); p.InputPerMTok == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("price not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A broken file keeps the previous table.
+	os.WriteFile(path, []byte("[prices.m]\ninput_per_mtok = 9\n"), 0o644)
+	time.Sleep(50 * time.Millisecond)
+	if p, _ := src.Price("m"); p.InputPerMTok != 4 {
+		t.Errorf("price after bad reload = %+v, want previous", p)
+	}
+}
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Attachment is binary content sent with a message outside its JSON
+// payload, such as an image for multimodal inference or a parquet shard.
+// It travels inline, in Data, which the JSON envelope carries as base64
+// and MsgPack as raw bytes, or by reference: URL names a copy stored out
+// of band, a file:// path or an http(s) URL, which receivers fetch and
+// check against Size and Digest. The payload refers to attachments by
+// Name.
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	Digest      string `json:"digest"` // "sha256:<hex>" of the content
+	Data        []byte `json:"data,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// Inline reports whether a carries its content rather than a reference.
+func (a *Attachment) Inline() bool { return a.URL == "" }
+
+// Verify checks that data is a's content: that its size and digest
+// match.
+func (a *Attachment) Verify(data []byte) error {
+	if int64(len(data)) != a.Size {
+		return fmt.Errorf("attachment %q: %d bytes, want %d", a.Name, len(data), a.Size)
+	}
+	if d := Digest(data); d != a.Digest {
+		return fmt.Errorf("attachment %q: digest %s, want %s", a.Name, d, a.Digest)
+	}
+	return nil
+}
+
+// Digest returns the content digest of data as "sha256:<hex>", the form
+// of Attachment.Digest.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Attach adds data to m as an inline attachment, replacing any attachment
+// with the same name, and returns it. m is raised to AttachmentsVersion.
+func (m *Message) Attach(name, contentType string, data []byte) *Attachment {
+	return m.setAttachment(Attachment{
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Digest:      Digest(data),
+		Data:        data,
+	})
+}
+
+// AttachRef adds an attachment stored out of band at url, with the size
+// and digest of its content, replacing any attachment with the same name.
+func (m *Message) AttachRef(name, contentType, url string, size int64, digest string) *Attachment {
+	return m.setAttachment(Attachment{
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		Digest:      digest,
+		URL:         url,
+	})
+}
+
+// AttachmentsVersion is the first envelope version that carries
+// attachments. Attach and AttachRef raise a message to it.
+const AttachmentsVersion = "3"
+
+func (m *Message) setAttachment(a Attachment) *Attachment {
+	if envelopeVersion(m.Version) < envelopeVersion(AttachmentsVersion) {
+		m.Version = AttachmentsVersion
+	}
+	for i := range m.Attachments {
+		if m.Attachments[i].Name == a.Name {
+			m.Attachments[i] = a
+			return &m.Attachments[i]
+		}
+	}
+	m.Attachments = append(m.Attachments, a)
+	return &m.Attachments[len(m.Attachments)-1]
+}
+
+// Attachment returns the attachment named name.
+func (m *Message) Attachment(name string) (*Attachment, bool) {
+	for i := range m.Attachments {
+		if m.Attachments[i].Name == name {
+			return &m.Attachments[i], true
+		}
+	}
+	return nil, false
+}
+
+// validateAttachments checks that each attachment is named once, has a
+// digest, and is either a reference or inline data matching its size and
+// digest, and returns the inline bytes they hold.
+func (m *Message) validateAttachments() (inline int, err error) {
+	if len(m.Attachments) > 0 && envelopeVersion(m.Version) < envelopeVersion(AttachmentsVersion) {
+		return 0, fmt.Errorf("message: attachments need envelope version %s, not %q", AttachmentsVersion, m.Version)
+	}
+	for i := range m.Attachments {
+		a := &m.Attachments[i]
+		switch {
+		case a.Name == "":
+			return 0, fmt.Errorf("message: attachment %d: missing name", i)
+		case !validDigest(a.Digest):
+			return 0, fmt.Errorf("message: attachment %q: digest %q is not sha256:<hex>", a.Name, a.Digest)
+		case a.Size < 0:
+			return 0, fmt.Errorf("message: attachment %q: negative size", a.Name)
+		case !a.Inline() && len(a.Data) > 0:
+			return 0, fmt.Errorf("message: attachment %q: has both data and a url", a.Name)
+		}
+		for _, b := range m.Attachments[:i] {
+			if b.Name == a.Name {
+				return 0, fmt.Errorf("message: attachment %q: duplicate name", a.Name)
+			}
+		}
+		if a.Inline() {
+			if err := a.Verify(a.Data); err != nil {
+				return 0, fmt.Errorf("message: %w", err)
+			}
+			inline += len(a.Data)
+		}
+	}
+	return inline, nil
+}
+
+func validDigest(d string) bool {
+	h, ok := strings.CutPrefix(d, "sha256:")
+	if !ok || len(h) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// writeAttachmentHash writes the canonical form of m's attachments that
+// Hash covers: each one's name and digest, in order.
+func (m *Message) writeAttachmentHash(buf *bytes.Buffer) {
+	buf.WriteString(`"attachments":[`)
+	for i, a := range m.Attachments {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`{"digest":`)
+		writeCanonicalString(buf, a.Digest)
+		buf.WriteString(`,"name":`)
+		writeCanonicalString(buf, a.Name)
+		buf.WriteByte('}')
+	}
+	buf.WriteString(`],`)
+}
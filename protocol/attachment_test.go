@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentRoundTrip(t *testing.T) {
+	image := []byte{0x89, 'P', 'N', 'G', 0, 1, 2, 0xff}
+	for _, version := range []string{"1", "3"} {
+		msg, _ := New(SourceInferMux, TypeInferRequest, InferRequest{Model: "vision"})
+		msg.Version = version
+		msg.Attach("image.png", "image/png", image)
+		msg.AttachRef("shard.parquet", "application/vnd.apache.parquet",
+			"file:///data/sha256/ab", 1<<20, Digest([]byte("shard")))
+		if msg.Version != AttachmentsVersion {
+			t.Errorf("v%s: version after Attach = %s, want %s", version, msg.Version, AttachmentsVersion)
+		}
+
+		for _, c := range Codecs {
+			data, err := msg.MarshalWith(c)
+			if err != nil {
+				t.Fatalf("v%s %s: %v", version, c.Name(), err)
+			}
+			got, err := UnmarshalWith(c, data)
+			if err != nil {
+				t.Fatalf("v%s %s: %v", version, c.Name(), err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("v%s %s round trip:\n got %+v\nwant %+v", version, c.Name(), got.Attachments, msg.Attachments)
+			}
+			if c == MsgPack && !bytes.Contains(data, image) {
+				t.Errorf("v%s msgpack: inline data not carried as raw bytes", version)
+			}
+		}
+	}
+}
+
+func TestAttachmentsVersion(t *testing.T) {
+	msg, _ := New(SourceInferMux, TypeInferRequest, InferRequest{Model: "vision"})
+	msg.Attach("a.bin", "", []byte("hello"))
+
+	// An older peer would drop the attachments, so the message can't be
+	// converted for one.
+	if err := msg.ConvertTo("2"); err == nil || msg.Version != AttachmentsVersion {
+		t.Errorf("ConvertTo(2) = %v, version %s; want refused", err, msg.Version)
+	}
+	data, _ := json.Marshal(msg)
+	v1 := strings.Replace(string(data), `"version":"3"`, `"version":"1"`, 1)
+	if _, err := Unmarshal([]byte(v1)); err == nil || !strings.Contains(err.Error(), "need envelope version 3") {
+		t.Errorf("v1 envelope with attachments: err = %v", err)
+	}
+}
+
+func TestAttachmentJSONBase64(t *testing.T) {
+	msg, _ := New(SourceInferMux, TypeInferRequest, InferRequest{Model: "vision"})
+	msg.Attach("a.bin", "", []byte("hello"))
+	data, _ := msg.Marshal()
+	if !strings.Contains(string(data), `"data":"aGVsbG8="`) {
+		t.Errorf("inline attachment not base64 in %s", data)
+	}
+}
+
+func TestAttachReplaces(t *testing.T) {
+	var msg Message
+	msg.Attach("a", "text/plain", []byte("one"))
+	msg.Attach("a", "text/plain", []byte("two"))
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("%d attachments, want 1", len(msg.Attachments))
+	}
+	a, ok := msg.Attachment("a")
+	if !ok || string(a.Data) != "two" {
+		t.Errorf("Attachment(a) = %+v, %v", a, ok)
+	}
+	if _, ok := msg.Attachment("b"); ok {
+		t.Error("Attachment(b) found")
+	}
+}
+
+func TestAttachmentVerify(t *testing.T) {
+	var msg Message
+	a := msg.Attach("a", "", []byte("content"))
+	if err := a.Verify([]byte("content")); err != nil {
+		t.Error(err)
+	}
+	if a.Verify([]byte("contenT")) == nil {
+		t.Error("Verify accepted altered content")
+	}
+	if a.Verify([]byte("short")) == nil {
+		t.Error("Verify accepted content of the wrong size")
+	}
+}
+
+func TestValidateAttachments(t *testing.T) {
+	base := func() *Message {
+		msg, _ := New(SourceInferMux, TypeInferRequest, InferRequest{Model: "m"})
+		return msg
+	}
+	digest := Digest([]byte("x"))
+	for name, a := range map[string][]Attachment{
+		"missing name":   {{Size: 1, Digest: digest, Data: []byte("x")}},
+		"bad digest":     {{Name: "a", Size: 1, Digest: "md5:00", Data: []byte("x")}},
+		"data and url":   {{Name: "a", Size: 1, Digest: digest, Data: []byte("x"), URL: "file:///x"}},
+		"size mismatch":  {{Name: "a", Size: 2, Digest: digest, Data: []byte("x")}},
+		"corrupt data":   {{Name: "a", Size: 1, Digest: digest, Data: []byte("y")}},
+		"negative size":  {{Name: "a", Size: -1, Digest: digest, URL: "file:///x"}},
+		"duplicate name": {{Name: "a", Size: 1, Digest: digest, URL: "file:///x"}, {Name: "a", Size: 1, Digest: digest, URL: "file:///y"}},
+	} {
+		msg := base()
+		msg.Attachments = a
+		if msg.Validate() == nil {
+			t.Errorf("%s: Validate = nil", name)
+		}
+	}
+
+	msg := base()
+	msg.Attach("big", "", make([]byte, MaxMessageSize))
+	if err := msg.Validate(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("oversized inline attachment: Validate = %v", err)
+	}
+}
+
+func TestHashAttachments(t *testing.T) {
+	msg, _ := New(SourceInferMux, TypeInferRequest, InferRequest{Model: "m"})
+	before, _ := msg.Hash()
+
+	msg.Attach("a", "", []byte("one"))
+	inline, _ := msg.Hash()
+	if inline == before {
+		t.Error("attachment did not change the hash")
+	}
+
+	// The same content by reference hashes the same as inline.
+	a, _ := msg.Attachment("a")
+	msg.AttachRef("a", "", "https://blobs.example/one", a.Size, a.Digest)
+	if ref, _ := msg.Hash(); ref != inline {
+		t.Error("reference and inline attachment hash differently")
+	}
+
+	msg.Attach("a", "", []byte("two"))
+	if changed, _ := msg.Hash(); changed == inline {
+		t.Error("changed attachment content did not change the hash")
+	}
+
+	// Messages without attachments hash as they always have.
+	plain := &Message{Version: "1", Source: "s", Type: "t", Payload: json.RawMessage(`{}`)}
+	if h, _ := plain.Hash(); h != "sha256:"+sha256Hex(`{"payload":{},"source":"s","type":"t","version":"1"}`) {
+		t.Errorf("Hash without attachments = %s", h)
+	}
+}
+
+func sha256Hex(s string) string {
+	return strings.TrimPrefix(Digest([]byte(s)), "sha256:")
+}
@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Canonicalize rewrites a JSON document in canonical form: object keys
+// sorted, no insignificant whitespace, and numbers normalized so that
+// equal values encode identically (1, 1.0, and 1e0 all become 1, and
+// 1e20 becomes 100000000000000000000). Two
+// documents with the same content produce the same bytes regardless of
+// key order, encoder, or Go version.
+func Canonicalize(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("canonicalize: %w", err)
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, fmt.Errorf("canonicalize: trailing data after JSON value")
+	}
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		n, err := canonicalNumber(string(v))
+		if err != nil {
+			return err
+		}
+		buf.WriteString(n)
+	case string:
+		writeCanonicalString(buf, v)
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("canonicalize: unexpected %T", v)
+	}
+	return nil
+}
+
+// writeCanonicalString writes s as a JSON string, escaping only what JSON
+// requires: quotes, backslashes, and control characters.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	const hexDigits = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, r := range strings.ToValidUTF8(s, "�") {
+		switch {
+		case r == '"':
+			buf.WriteString(`\"`)
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r < 0x20:
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hexDigits[r>>4])
+			buf.WriteByte(hexDigits[r&0xf])
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber normalizes a JSON number literal by its exact decimal
+// value, so literals of equal value encode identically: 1e20 and
+// 100000000000000000000 both become 100000000000000000000. Nothing is
+// rounded. The layout is that of ECMAScript's Number.prototype.toString:
+// plain digits when the decimal point falls within 21 digits of the
+// first, exponent form otherwise.
+func canonicalNumber(s string) (string, error) {
+	neg := strings.HasPrefix(s, "-")
+	mant, exp := strings.TrimPrefix(s, "-"), int64(0)
+	if i := strings.IndexAny(mant, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(strings.TrimPrefix(mant[i+1:], "+"), 10, 32)
+		if err != nil {
+			return "", invalidNumber(s)
+		}
+		mant, exp = mant[:i], e
+	}
+	intPart, frac, _ := strings.Cut(mant, ".")
+	digits := intPart + frac
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return "", invalidNumber(s)
+	}
+	exp -= int64(len(frac))
+
+	// value = digits × 10^exp, with digits free of leading and trailing
+	// zeros; n is where the decimal point falls relative to its start.
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return "0", nil
+	}
+	trimmed := strings.TrimRight(digits, "0")
+	exp += int64(len(digits) - len(trimmed))
+	digits = trimmed
+	k := int64(len(digits))
+	n := exp + k
+
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	switch {
+	case k <= n && n <= 21:
+		b.WriteString(digits)
+		b.WriteString(strings.Repeat("0", int(n-k)))
+	case 0 < n && n <= 21:
+		b.WriteString(digits[:n])
+		b.WriteByte('.')
+		b.WriteString(digits[n:])
+	case -6 < n && n <= 0:
+		b.WriteString("0.")
+		b.WriteString(strings.Repeat("0", int(-n)))
+		b.WriteString(digits)
+	default:
+		b.WriteString(digits[:1])
+		if k > 1 {
+			b.WriteByte('.')
+			b.WriteString(digits[1:])
+		}
+		b.WriteByte('e')
+		if n-1 >= 0 {
+			b.WriteByte('+')
+		}
+		b.WriteString(strconv.FormatInt(n-1, 10))
+	}
+	return b.String(), nil
+}
+
+func invalidNumber(s string) error {
+	return fmt.Errorf("canonicalize: invalid number %q", s)
+}
+
+// Hash returns a stable digest of the message's content as
+// "sha256:<hex>", for dedup, idempotency keys, cache keys, and audit
+// chains. It covers Version, Source, Type, the canonicalized Payload, the
+// name and digest of each attachment, and the CorrelationID of a
+// response, so equal answers to different requests hash differently.
+// Fields that differ between deliveries of the same content are excluded:
+// ID, TimestampNS, Checksum, DeadlineNS, TTLNS, and Meta, which carries
+// per-request trace context. An empty payload hashes as null.
+func (m *Message) Hash() (string, error) {
+	payload := []byte(m.Payload)
+	if len(bytes.TrimSpace(payload)) == 0 {
+		payload = []byte("null")
+	}
+	canon, err := Canonicalize(payload)
+	if err != nil {
+		return "", fmt.Errorf("message hash: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	if len(m.Attachments) > 0 {
+		m.writeAttachmentHash(&buf)
+	}
+	if m.CorrelationID != "" {
+		buf.WriteString(`"correlation_id":`)
+		writeCanonicalString(&buf, m.CorrelationID)
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"payload":`)
+	buf.Write(canon)
+	buf.WriteString(`,"source":`)
+	writeCanonicalString(&buf, m.Source)
+	buf.WriteString(`,"type":`)
+	writeCanonicalString(&buf, m.Type)
+	buf.WriteString(`,"version":`)
+	writeCanonicalString(&buf, m.Version)
+	buf.WriteByte('}')
+	sum := sha256.Sum256(buf.Bytes())
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
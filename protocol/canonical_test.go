@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`{"b": 1, "a": [true, null, "x"]}`, `{"a":[true,null,"x"],"b":1}`},
+		{`[1.0, 1e0, 10E-1, -0, 0.0, 2.50]`, `[1,1,1,0,0,2.5]`},
+		{`123456789012345678901234567890`, `1.2345678901234567890123456789e+29`},
+		{`-0.000001234`, `-0.000001234`},
+		{`1.234e-7`, `1.234e-7`},
+		{`1.5e300`, `1.5e+300`},
+		{`"<a & b>é\t"`, `"<a & b>é\t"`},
+		{`{"z":{"y":1,"x":2}}`, `{"z":{"x":2,"y":1}}`},
+	}
+	for _, tt := range tests {
+		got, err := Canonicalize([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Canonicalize(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+	for _, bad := range []string{``, `{"a":1} {}`, `{"a":}`} {
+		if _, err := Canonicalize([]byte(bad)); err == nil {
+			t.Errorf("Canonicalize(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCanonicalizeEqualNumbers(t *testing.T) {
+	tests := []struct{ a, b, want string }{
+		{`1e20`, `100000000000000000000`, `100000000000000000000`},
+		{`1e18`, `1000000000000000000`, `1000000000000000000`},
+		{`9007199254740993`, `9007199254740993.0`, `9007199254740993`},
+		{`1e21`, `1000000000000000000000`, `1e+21`},
+		{`12.5E-1`, `1.250`, `1.25`},
+		{`-0.0`, `0e5`, `0`},
+	}
+	for _, tt := range tests {
+		a, errA := Canonicalize([]byte(tt.a))
+		b, errB := Canonicalize([]byte(tt.b))
+		if errA != nil || errB != nil {
+			t.Errorf("%s, %s: %v, %v", tt.a, tt.b, errA, errB)
+			continue
+		}
+		if string(a) != tt.want || string(b) != tt.want {
+			t.Errorf("Canonicalize(%s) = %s and Canonicalize(%s) = %s, want both %s", tt.a, a, tt.b, b, tt.want)
+		}
+	}
+}
+
+func TestMessageHash(t *testing.T) {
+	a, _ := New("infermux", TypeInferRequest, map[string]any{"model": "gpt-4", "temperature": 1})
+	b := &Message{
+		Version: "1", ID: "other", Source: "infermux", Type: TypeInferRequest, TimestampNS: 42,
+		Payload:    json.RawMessage(`{ "temperature": 1.0, "model": "gpt-4" }`),
+		DeadlineNS: 99, Meta: map[string]string{"request_id": "r1"},
+	}
+	b.ComputeChecksum()
+
+	ha, err := a.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, _ := b.Hash()
+	if ha != hb {
+		t.Errorf("equivalent messages hash differently: %s vs %s", ha, hb)
+	}
+	if len(ha) != len("sha256:")+64 {
+		t.Errorf("hash = %q", ha)
+	}
+
+	b.CorrelationID = "req-1"
+	if hc, _ := b.Hash(); hc == ha {
+		t.Error("correlation ID did not change the hash")
+	}
+	b.Type = TypeInferResponse
+	if hc, _ := b.Hash(); hc == ha {
+		t.Error("type change did not change the hash")
+	}
+	b.Payload = json.RawMessage(`{"model":`)
+	if _, err := b.Hash(); err == nil {
+		t.Error("invalid payload hashed")
+	}
+}
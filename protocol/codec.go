@@ -0,0 +1,523 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"sort"
+)
+
+// Codec encodes messages for the wire. Transports pick a codec per
+// connection, announcing it with ContentType so the receiver can decode
+// with CodecFor.
+type Codec interface {
+	Name() string        // short name, e.g. "json" or "msgpack"
+	ContentType() string // MIME type of encoded messages
+	Marshal(m *Message) ([]byte, error)
+	Unmarshal(data []byte) (*Message, error)
+}
+
+// Built-in codecs.
+var (
+	// JSON is the default codec, identical to Message.Marshal and Unmarshal.
+	JSON Codec = jsonCodec{}
+
+	// MsgPack encodes the envelope as a MessagePack map keyed by the JSON
+	// field names, roughly halving envelope overhead for small, frequent
+	// messages such as trace spans. The payload is carried verbatim as a
+	// binary field, so checksums and Hash are unchanged by the encoding.
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Codecs lists the built-in codecs.
+var Codecs = []Codec{JSON, MsgPack}
+
+// CodecFor returns the built-in codec for a MIME type or codec name.
+// Parameters such as charset are ignored.
+func CodecFor(contentTypeOrName string) (Codec, bool) {
+	mt, _, err := mime.ParseMediaType(contentTypeOrName)
+	if err != nil {
+		mt = contentTypeOrName
+	}
+	for _, c := range Codecs {
+		if mt == c.ContentType() || mt == c.Name() {
+			return c, true
+		}
+	}
+	if mt == "application/x-msgpack" {
+		return MsgPack, true
+	}
+	return nil, false
+}
+
+// MarshalWith encodes the message with c.
+func (m *Message) MarshalWith(c Codec) ([]byte, error) {
+	return c.Marshal(m)
+}
+
+// UnmarshalWith decodes a message encoded with c, applying the same size
+// and envelope checks as Unmarshal.
+func UnmarshalWith(c Codec, data []byte) (*Message, error) {
+	return c.Unmarshal(data)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                            { return "json" }
+func (jsonCodec) ContentType() string                     { return "application/json" }
+func (jsonCodec) Marshal(m *Message) ([]byte, error)      { return m.Marshal() }
+func (jsonCodec) Unmarshal(data []byte) (*Message, error) { return Unmarshal(data) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string        { return "msgpack" }
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(m *Message) ([]byte, error) {
+	headers := 0
+	for _, set := range []bool{m.DeadlineNS != 0, m.TTLNS != 0, len(m.Meta) > 0, m.CorrelationID != ""} {
+		if set {
+			headers++
+		}
+	}
+	nested := envelopeVersion(m.Version) >= 2
+	fields := 6
+	switch {
+	case nested && headers > 0:
+		fields++
+	case !nested:
+		fields += headers
+	}
+	if m.Checksum != 0 {
+		fields++
+	}
+	if len(m.Attachments) > 0 {
+		fields++
+	}
+
+	b := make([]byte, 0, 64+len(m.Payload))
+	b = mpMapHeader(b, fields)
+	b = mpString(mpString(b, "version"), m.Version)
+	b = mpString(mpString(b, "id"), m.ID)
+	b = mpString(mpString(b, "source"), m.Source)
+	b = mpString(mpString(b, "type"), m.Type)
+	b = mpInt(mpString(b, "timestamp_ns"), m.TimestampNS)
+	b = mpBinary(mpString(b, "payload"), m.Payload)
+	if m.Checksum != 0 {
+		b = mpInt(mpString(b, "checksum"), int64(m.Checksum))
+	}
+	if nested && headers > 0 {
+		// v2 groups the header fields as the JSON envelope does.
+		b = mpMapHeader(mpString(b, "headers"), headers)
+	}
+	if m.DeadlineNS != 0 {
+		b = mpInt(mpString(b, "deadline_ns"), m.DeadlineNS)
+	}
+	if m.TTLNS != 0 {
+		b = mpInt(mpString(b, "ttl_ns"), m.TTLNS)
+	}
+	if len(m.Meta) > 0 {
+		b = mpString(b, "meta")
+		b = mpMapHeader(b, len(m.Meta))
+		keys := make([]string, 0, len(m.Meta))
+		for k := range m.Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = mpString(mpString(b, k), m.Meta[k])
+		}
+	}
+	if m.CorrelationID != "" {
+		b = mpString(mpString(b, "correlation_id"), m.CorrelationID)
+	}
+	if len(m.Attachments) > 0 {
+		// Attachments stay at the top level in every version, with
+		// inline data as raw bytes rather than base64.
+		b = mpArrayHeader(mpString(b, "attachments"), len(m.Attachments))
+		for _, a := range m.Attachments {
+			b = mpAttachment(b, &a)
+		}
+	}
+	return b, nil
+}
+
+func mpAttachment(b []byte, a *Attachment) []byte {
+	fields := 3
+	for _, set := range []bool{a.ContentType != "", len(a.Data) > 0, a.URL != ""} {
+		if set {
+			fields++
+		}
+	}
+	b = mpMapHeader(b, fields)
+	b = mpString(mpString(b, "name"), a.Name)
+	if a.ContentType != "" {
+		b = mpString(mpString(b, "content_type"), a.ContentType)
+	}
+	b = mpInt(mpString(b, "size"), a.Size)
+	b = mpString(mpString(b, "digest"), a.Digest)
+	if len(a.Data) > 0 {
+		b = mpBinary(mpString(b, "data"), a.Data)
+	}
+	if a.URL != "" {
+		b = mpString(mpString(b, "url"), a.URL)
+	}
+	return b
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (*Message, error) {
+	if len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("message too large: %d bytes (max %d)", len(data), MaxMessageSize)
+	}
+	d := &mpDecoder{b: data}
+	var m Message
+	var headers *Message
+	n := d.mapHeader()
+	for i := 0; i < n && d.err == nil; i++ {
+		switch key := d.readString(); key {
+		case "version":
+			m.Version = d.readString()
+		case "id":
+			m.ID = d.readString()
+		case "source":
+			m.Source = d.readString()
+		case "type":
+			m.Type = d.readString()
+		case "timestamp_ns":
+			m.TimestampNS = d.readInt()
+		case "payload":
+			if p := d.readBinary(); len(p) > 0 {
+				m.Payload = json.RawMessage(p)
+			}
+		case "checksum":
+			m.Checksum = uint32(d.readInt())
+		case "attachments":
+			k := d.arrayHeader()
+			m.Attachments = make([]Attachment, 0, min(k, 64))
+			for j := 0; j < k && d.err == nil; j++ {
+				m.Attachments = append(m.Attachments, d.readAttachment())
+			}
+		case "headers":
+			headers = new(Message)
+			k := d.mapHeader()
+			for j := 0; j < k && d.err == nil; j++ {
+				d.readHeader(headers, d.readString())
+			}
+		default:
+			d.readHeader(&m, key)
+		}
+	}
+	if headers != nil && envelopeVersion(m.Version) >= 2 {
+		m.DeadlineNS, m.TTLNS, m.Meta, m.CorrelationID = headers.DeadlineNS, headers.TTLNS, headers.Meta, headers.CorrelationID
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("msgpack: %w", d.err)
+	}
+	if len(d.b) != 0 {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(d.b))
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// readHeader decodes the value of an envelope header field into m,
+// skipping unknown keys.
+func (d *mpDecoder) readHeader(m *Message, key string) {
+	switch key {
+	case "deadline_ns":
+		m.DeadlineNS = d.readInt()
+	case "ttl_ns":
+		m.TTLNS = d.readInt()
+	case "meta":
+		k := d.mapHeader()
+		m.Meta = make(map[string]string, min(k, 64))
+		for j := 0; j < k && d.err == nil; j++ {
+			mk := d.readString()
+			m.Meta[mk] = d.readString()
+		}
+	case "correlation_id":
+		m.CorrelationID = d.readString()
+	default:
+		d.skip()
+	}
+}
+
+// readAttachment decodes one attachment, skipping unknown keys.
+func (d *mpDecoder) readAttachment() Attachment {
+	var a Attachment
+	n := d.mapHeader()
+	for i := 0; i < n && d.err == nil; i++ {
+		switch key := d.readString(); key {
+		case "name":
+			a.Name = d.readString()
+		case "content_type":
+			a.ContentType = d.readString()
+		case "size":
+			a.Size = d.readInt()
+		case "digest":
+			a.Digest = d.readString()
+		case "data":
+			a.Data = d.readBinary()
+		case "url":
+			a.URL = d.readString()
+		default:
+			d.skip()
+		}
+	}
+	return a
+}
+
+// MessagePack encoding, limited to the types the envelope uses.
+
+func mpMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+func mpArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func mpString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func mpBinary(b []byte, p []byte) []byte {
+	switch n := len(p); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, p...)
+}
+
+func mpInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v < 128:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= 0 && v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+// mpDecoder reads MessagePack values, recording the first error.
+type mpDecoder struct {
+	b     []byte
+	err   error
+	depth int // nesting of skipped containers
+}
+
+// maxSkipDepth bounds recursion when skipping nested unknown fields.
+const maxSkipDepth = 64
+
+func (d *mpDecoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+	d.b = nil
+}
+
+func (d *mpDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *mpDecoder) readByte() byte {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (d *mpDecoder) readUint(size int) uint64 {
+	p := d.next(size)
+	if p == nil {
+		return 0
+	}
+	var v uint64
+	for _, c := range p {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func (d *mpDecoder) mapHeader() int {
+	switch t := d.readByte(); {
+	case t&0xf0 == 0x80:
+		return int(t & 0x0f)
+	case t == 0xde:
+		return int(d.readUint(2))
+	case t == 0xdf:
+		return int(d.readUint(4))
+	default:
+		d.fail("expected map, got 0x%02x", t)
+		return 0
+	}
+}
+
+func (d *mpDecoder) arrayHeader() int {
+	switch t := d.readByte(); {
+	case t&0xf0 == 0x90:
+		return int(t & 0x0f)
+	case t == 0xdc:
+		return int(d.readUint(2))
+	case t == 0xdd:
+		return int(d.readUint(4))
+	default:
+		d.fail("expected array, got 0x%02x", t)
+		return 0
+	}
+}
+
+func (d *mpDecoder) readString() string {
+	var n int
+	switch t := d.readByte(); {
+	case t&0xe0 == 0xa0:
+		n = int(t & 0x1f)
+	case t == 0xd9:
+		n = int(d.readUint(1))
+	case t == 0xda:
+		n = int(d.readUint(2))
+	case t == 0xdb:
+		n = int(d.readUint(4))
+	default:
+		d.fail("expected string, got 0x%02x", t)
+		return ""
+	}
+	return string(d.next(n))
+}
+
+func (d *mpDecoder) readBinary() []byte {
+	var n int
+	switch t := d.readByte(); t {
+	case 0xc0:
+		return nil
+	case 0xc4:
+		n = int(d.readUint(1))
+	case 0xc5:
+		n = int(d.readUint(2))
+	case 0xc6:
+		n = int(d.readUint(4))
+	default:
+		d.fail("expected binary, got 0x%02x", t)
+		return nil
+	}
+	return append([]byte(nil), d.next(n)...)
+}
+
+func (d *mpDecoder) readInt() int64 {
+	switch t := d.readByte(); {
+	case t < 0x80:
+		return int64(t)
+	case t >= 0xe0:
+		return int64(int8(t))
+	case t == 0xcc:
+		return int64(d.readUint(1))
+	case t == 0xcd:
+		return int64(d.readUint(2))
+	case t == 0xce:
+		return int64(d.readUint(4))
+	case t == 0xcf:
+		return int64(d.readUint(8))
+	case t == 0xd0:
+		return int64(int8(d.readUint(1)))
+	case t == 0xd1:
+		return int64(int16(d.readUint(2)))
+	case t == 0xd2:
+		return int64(int32(d.readUint(4)))
+	case t == 0xd3:
+		return int64(d.readUint(8))
+	default:
+		d.fail("expected integer, got 0x%02x", t)
+		return 0
+	}
+}
+
+// skip discards one value of any type, for fields added by newer
+// senders.
+func (d *mpDecoder) skip() {
+	t := d.readByte()
+	switch {
+	case d.err != nil:
+	case t < 0x80, t >= 0xe0, t == 0xc0, t == 0xc2, t == 0xc3:
+	case t&0xf0 == 0x80:
+		d.skipN(2 * int(t&0x0f))
+	case t&0xf0 == 0x90:
+		d.skipN(int(t & 0x0f))
+	case t&0xe0 == 0xa0:
+		d.next(int(t & 0x1f))
+	case t == 0xc4, t == 0xd9:
+		d.next(int(d.readUint(1)))
+	case t == 0xc5, t == 0xda:
+		d.next(int(d.readUint(2)))
+	case t == 0xc6, t == 0xdb:
+		d.next(int(d.readUint(4)))
+	case t == 0xcc, t == 0xd0:
+		d.next(1)
+	case t == 0xcd, t == 0xd1:
+		d.next(2)
+	case t == 0xca, t == 0xce, t == 0xd2:
+		d.next(4)
+	case t == 0xcb, t == 0xcf, t == 0xd3:
+		d.next(8)
+	case t == 0xdc:
+		d.skipN(int(d.readUint(2)))
+	case t == 0xdd:
+		d.skipN(int(d.readUint(4)))
+	case t == 0xde:
+		d.skipN(2 * int(d.readUint(2)))
+	case t == 0xdf:
+		d.skipN(2 * int(d.readUint(4)))
+	default:
+		d.fail("unsupported type 0x%02x", t)
+	}
+}
+
+func (d *mpDecoder) skipN(n int) {
+	if d.depth++; d.depth > maxSkipDepth {
+		d.fail("nesting too deep")
+	}
+	for i := 0; i < n && d.err == nil; i++ {
+		d.skip()
+	}
+	d.depth--
+}
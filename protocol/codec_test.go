@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	msg, _ := New(SourceTokenTrace, TypeTraceSpan, TraceSpan{TraceID: "t1", SpanID: "s1", Operation: "infer", StartNS: 1, EndNS: 2})
+	msg.DeadlineNS = -5
+	msg.TTLNS = 1 << 40
+	msg.Meta = map[string]string{"tenant": "acme", "request_id": "r1"}
+	msg.CorrelationID = "req-1"
+	msg.ComputeChecksum()
+
+	for _, c := range Codecs {
+		data, err := msg.MarshalWith(c)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		got, err := UnmarshalWith(c, data)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("%s round trip:\n got %+v\nwant %+v", c.Name(), got, msg)
+		}
+		if !got.VerifyChecksum() {
+			t.Errorf("%s: checksum broken", c.Name())
+		}
+	}
+
+	j, _ := msg.MarshalWith(JSON)
+	m, _ := msg.MarshalWith(MsgPack)
+	if len(m) >= len(j) {
+		t.Errorf("msgpack %d bytes, json %d", len(m), len(j))
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	for in, want := range map[string]Codec{
+		"application/json; charset=utf-8": JSON,
+		"application/msgpack":             MsgPack,
+		"application/x-msgpack":           MsgPack,
+		"msgpack":                         MsgPack,
+	} {
+		if c, ok := CodecFor(in); !ok || c != want {
+			t.Errorf("CodecFor(%q) = %v", in, c)
+		}
+	}
+	if _, ok := CodecFor("application/xml"); ok {
+		t.Error("xml accepted")
+	}
+}
+
+func TestMsgPackUnmarshalErrors(t *testing.T) {
+	msg, _ := New("test", TypeHealthPing, nil)
+	data, _ := msg.MarshalWith(MsgPack)
+
+	for i := 0; i < len(data); i++ {
+		if _, err := MsgPack.Unmarshal(data[:i]); err == nil {
+			t.Fatalf("truncated at %d accepted", i)
+		}
+	}
+	if _, err := MsgPack.Unmarshal(append(bytes.Clone(data), 0xc0)); err == nil {
+		t.Error("trailing bytes accepted")
+	}
+
+	// Unknown fields from a newer sender are skipped.
+	extra := bytes.Clone(data)
+	extra[0]++ // one more map entry
+	extra = mpString(extra, "priority")
+	extra = append(extra, 0x92, 0xcb, 0, 0, 0, 0, 0, 0, 0, 0, 0x81, 0xa1, 'k', 0xc3)
+	got, err := MsgPack.Unmarshal(extra)
+	if err != nil || got.ID != msg.ID {
+		t.Errorf("unknown field: %v", err)
+	}
+
+	deep := bytes.Repeat([]byte{0x91}, 1000)
+	bad := bytes.Clone(data)
+	bad[0]++
+	bad = append(mpString(bad, "x"), deep...)
+	if _, err := MsgPack.Unmarshal(append(bad, 0xc0)); err == nil {
+		t.Error("deep nesting accepted")
+	}
+}
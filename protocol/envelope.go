@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The v2 envelope groups the optional per-delivery fields — deadline,
+// TTL, correlation ID, and metadata — under "headers", so new ones can be
+// added there without widening the envelope every v1 reader parses:
+//
+//	{"version":"2","id":"…","source":"…","type":"…","timestamp_ns":…,
+//	 "payload":{…},"headers":{"deadline_ns":…,"meta":{…}}}
+//
+// The v3 envelope is the v2 layout with "attachments", which older
+// readers would silently drop; a message with attachments is at least
+// v3, and converting it to an older version fails.
+//
+// A Message is the same in memory whichever version it arrived as; its
+// Version decides only how it is encoded. Receiving a v1 or v2 envelope
+// upgrades it transparently, and ConvertTo rewrites a message for a peer
+// that speaks a different version.
+
+// envelopeHeaders is the "headers" object of a v2 envelope.
+type envelopeHeaders struct {
+	DeadlineNS    int64             `json:"deadline_ns,omitempty"`
+	TTLNS         int64             `json:"ttl_ns,omitempty"`
+	Meta          map[string]string `json:"meta,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+}
+
+func (h *envelopeHeaders) empty() bool {
+	return h.DeadlineNS == 0 && h.TTLNS == 0 && len(h.Meta) == 0 && h.CorrelationID == ""
+}
+
+// wireMessage has Message's fields without its methods, so encoding it
+// doesn't recurse into MarshalJSON.
+type wireMessage Message
+
+// v2Message is the v2 layout: the header fields of wireMessage are
+// shadowed by nil fields of the same names, which omitempty drops, and
+// carried in Headers instead.
+type v2Message struct {
+	*wireMessage
+	DeadlineNS    *struct{}        `json:"deadline_ns,omitempty"`
+	TTLNS         *struct{}        `json:"ttl_ns,omitempty"`
+	Meta          *struct{}        `json:"meta,omitempty"`
+	CorrelationID *struct{}        `json:"correlation_id,omitempty"`
+	Headers       *envelopeHeaders `json:"headers,omitempty"`
+}
+
+// MarshalJSON encodes m in the envelope layout of m.Version.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if envelopeVersion(m.Version) < 2 {
+		return json.Marshal((*wireMessage)(&m))
+	}
+	h := &envelopeHeaders{DeadlineNS: m.DeadlineNS, TTLNS: m.TTLNS, Meta: m.Meta, CorrelationID: m.CorrelationID}
+	if h.empty() {
+		h = nil
+	}
+	return json.Marshal(v2Message{wireMessage: (*wireMessage)(&m), Headers: h})
+}
+
+// UnmarshalJSON decodes a v1 or v2 envelope.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var wire struct {
+		*wireMessage
+		Headers *envelopeHeaders `json:"headers"`
+	}
+	wire.wireMessage = (*wireMessage)(m)
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	if h := wire.Headers; h != nil && envelopeVersion(m.Version) >= 2 {
+		m.DeadlineNS, m.TTLNS, m.Meta, m.CorrelationID = h.DeadlineNS, h.TTLNS, h.Meta, h.CorrelationID
+	}
+	return nil
+}
+
+// CompatibleWith reports whether m can be sent to a peer that speaks
+// version: this library must understand both m's version and the peer's,
+// so ConvertTo can rewrite m without losing fields.
+func (m *Message) CompatibleWith(version string) bool {
+	return IsCompatible(m.Version) && IsCompatible(version)
+}
+
+// ConvertTo rewrites m for a peer that speaks version, upgrading or
+// downgrading its envelope. No fields are lost either way: a message
+// with attachments can't be downgraded below AttachmentsVersion. Hash
+// covers Version, so the converted message hashes differently.
+func (m *Message) ConvertTo(version string) error {
+	if !m.CompatibleWith(version) {
+		return fmt.Errorf("protocol: cannot convert message %s from version %q to %q (supported: %s)",
+			m.ID, m.Version, version, SupportedVersions)
+	}
+	if len(m.Attachments) > 0 && envelopeVersion(version) < envelopeVersion(AttachmentsVersion) {
+		return fmt.Errorf("protocol: cannot convert message %s to version %q: its attachments need version %s",
+			m.ID, version, AttachmentsVersion)
+	}
+	m.Version = version
+	return nil
+}
+
+// envelopeVersion returns the numeric version, or 0 if it isn't one.
+func envelopeVersion(s string) int {
+	v, err := parseVersion(s)
+	if err != nil {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func headered(t *testing.T) *Message {
+	t.Helper()
+	msg, err := New("test", TypeHealthPing, HealthPing{From: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.DeadlineNS = 1700000000000000000
+	msg.TTLNS = 5e9
+	msg.Meta = map[string]string{"tenant": "acme"}
+	msg.CorrelationID = "req-1"
+	return msg
+}
+
+func TestEnvelopeV2Layout(t *testing.T) {
+	msg := headered(t)
+	if err := msg.ConvertTo("2"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, flat := range []string{"deadline_ns", "ttl_ns", "meta", "correlation_id"} {
+		if _, ok := raw[flat]; ok {
+			t.Errorf("v2 envelope has top-level %s: %s", flat, data)
+		}
+	}
+	if !strings.Contains(string(raw["headers"]), `"correlation_id":"req-1"`) {
+		t.Errorf("headers = %s", raw["headers"])
+	}
+
+	// Without headers, v2 omits the object.
+	plain, _ := New("test", TypeHealthPing, HealthPing{})
+	plain.ConvertTo("2")
+	if data, _ := plain.Marshal(); strings.Contains(string(data), "headers") {
+		t.Errorf("empty headers encoded: %s", data)
+	}
+}
+
+func TestEnvelopeConvertRoundTrip(t *testing.T) {
+	for _, codec := range Codecs {
+		for _, from := range []string{"1", "2"} {
+			for _, to := range []string{"1", "2"} {
+				msg := headered(t)
+				msg.ConvertTo(from)
+				data, err := codec.Marshal(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := codec.Unmarshal(data)
+				if err != nil {
+					t.Fatalf("%s v%s: %v", codec.Name(), from, err)
+				}
+				if err := got.ConvertTo(to); err != nil {
+					t.Fatal(err)
+				}
+				want := headered(t)
+				want.ID, want.TimestampNS, want.Version = msg.ID, msg.TimestampNS, to
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s v%s→v%s:\n got %+v\nwant %+v", codec.Name(), from, to, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEnvelopeV1IgnoresHeaders(t *testing.T) {
+	// A v1 envelope's unknown fields are ignored, as they always were.
+	msg, err := Unmarshal([]byte(`{"version":"1","id":"a","source":"s","type":"t","payload":{},"headers":{"correlation_id":"x"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.CorrelationID != "" {
+		t.Errorf("CorrelationID = %q from a v1 envelope's headers", msg.CorrelationID)
+	}
+}
+
+func TestEnvelopeUnsupportedVersion(t *testing.T) {
+	if _, err := Unmarshal([]byte(`{"version":"4","id":"a","source":"s","type":"t","payload":{}}`)); err == nil || !strings.Contains(err.Error(), "too new") {
+		t.Errorf("v4 envelope: err = %v, want too new", err)
+	}
+
+	msg := headered(t)
+	if msg.CompatibleWith("4") {
+		t.Error("CompatibleWith(4) = true")
+	}
+	if err := msg.ConvertTo("4"); err == nil || msg.Version != "1" {
+		t.Errorf("ConvertTo(4) = %v, version %s", err, msg.Version)
+	}
+	if !msg.CompatibleWith("2") {
+		t.Error("CompatibleWith(2) = false")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	req, err := NewHandshake("new-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := AnswerHandshake("peer", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.IsReplyTo(req.ID) || reply.Version != "1" {
+		t.Errorf("reply correlation %q, version %s", reply.CorrelationID, reply.Version)
+	}
+	if v, err := HandshakeVersion(reply); err != nil || v != MaxSupportedVersion {
+		t.Errorf("HandshakeVersion = %q, %v", v, err)
+	}
+
+	// A peer that only speaks a version we don't is refused on both sides.
+	old, _ := New("old-service", TypeControlHandshake, Handshake{Versions: "0"})
+	reply, err = AnswerHandshake("peer", old)
+	if err == nil {
+		t.Error("AnswerHandshake: no error for disjoint ranges")
+	}
+	if _, err := HandshakeVersion(reply); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("HandshakeVersion err = %v, want refused", err)
+	}
+	if _, err := HandshakeVersion(req); err == nil {
+		t.Error("HandshakeVersion accepted a request, which carries no agreed version")
+	}
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import "fmt"
+
+// Handshakes always travel in the v1 envelope, which every version of
+// this library reads, so a peer can answer one before anything is agreed.
+const handshakeVersion = "1"
+
+// NewHandshake creates a control.handshake request offering
+// SupportedVersions. Send it with transport.Request, or use
+// transport.Handshake, and read the agreed version from the reply with
+// HandshakeVersion.
+func NewHandshake(source string) (*Message, error) {
+	msg, err := New(source, TypeControlHandshake, Handshake{Versions: SupportedVersions})
+	if err != nil {
+		return nil, err
+	}
+	msg.Version = handshakeVersion
+	return msg, nil
+}
+
+// AnswerHandshake creates the reply to a control.handshake request: the
+// highest version both sides support. If there is none, the reply
+// carries the error for the requester, and AnswerHandshake returns it
+// too.
+func AnswerHandshake(source string, req *Message) (*Message, error) {
+	var offer Handshake
+	if err := req.Decode(&offer); err != nil {
+		return nil, fmt.Errorf("protocol: handshake: %w", err)
+	}
+	answer := Handshake{Versions: SupportedVersions}
+	version, negErr := NegotiateVersion(SupportedVersions, offer.Versions)
+	if negErr != nil {
+		answer.Error = negErr.Error()
+	} else {
+		answer.Version = version
+	}
+	reply, err := req.Reply(source, TypeControlHandshake, answer)
+	if err != nil {
+		return nil, err
+	}
+	reply.Version = handshakeVersion
+	return reply, negErr
+}
+
+// HandshakeVersion returns the version agreed in a handshake reply.
+func HandshakeVersion(reply *Message) (string, error) {
+	if reply.Type != TypeControlHandshake {
+		return "", fmt.Errorf("protocol: handshake: reply is %s, not %s", reply.Type, TypeControlHandshake)
+	}
+	var answer Handshake
+	if err := reply.Decode(&answer); err != nil {
+		return "", fmt.Errorf("protocol: handshake: %w", err)
+	}
+	if answer.Error != "" {
+		return "", fmt.Errorf("protocol: handshake refused by %s: %s", reply.Source, answer.Error)
+	}
+	if !IsCompatible(answer.Version) {
+		return "", fmt.Errorf("protocol: handshake: %s agreed on unsupported version %q", reply.Source, answer.Version)
+	}
+	return answer.Version, nil
+}
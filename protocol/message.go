@@ -23,8 +23,9 @@ const (
 	TypeDataSchema   = "data.schema"   // schema definition
 
 	// Inference (InferMux)
-	TypeInferRequest  = "infer.request"  // LLM inference request
-	TypeInferResponse = "infer.response" // LLM inference response
+	TypeInferRequest       = "infer.request"        // LLM inference request
+	TypeInferResponse      = "infer.response"       // LLM inference response
+	TypeInferResponseChunk = "infer.response.chunk" // partial response while streaming
 
 	// Evaluation (MatchSpec)
 	TypeEvalRun    = "eval.run"    // start an evaluation
@@ -37,6 +38,16 @@ const (
 	// Health (all tools)
 	TypeHealthPing = "health.ping"
 	TypeHealthPong = "health.pong"
+
+	// Jobs (all tools)
+	TypeJobProgress = "job.progress" // checkpointed job progress and ETA
+
+	// Control (relays and collectors)
+	TypeControlDrain     = "control.drain"     // stop accepting work and drain in-flight messages
+	TypeControlHandshake = "control.handshake" // agree on an envelope version
+
+	// Transport
+	TypeTransportChunk = "transport.chunk" // piece of a message too large to send whole
 )
 
 // Source identifiers for MIST tools.
@@ -56,6 +67,31 @@ type Message struct {
 	TimestampNS int64           `json:"timestamp_ns"`
 	Payload     json.RawMessage `json:"payload"`
 	Checksum    uint32          `json:"checksum,omitempty"`
+
+	// DeadlineNS is the absolute time (Unix nanoseconds) after which the
+	// sender no longer needs a result. Zero means no deadline.
+	DeadlineNS int64 `json:"deadline_ns,omitempty"`
+
+	// TTLNS is how long the message stays worth processing, in
+	// nanoseconds from TimestampNS. Zero means it never goes stale.
+	// Unlike DeadlineNS, which tracks a caller waiting on a result, TTL
+	// belongs to the message itself: a health ping is useless a few
+	// seconds after it was sent, whoever sent it.
+	TTLNS int64 `json:"ttl_ns,omitempty"`
+
+	// Meta carries cross-service request context (tenant, user, request
+	// ID, baggage) so it doesn't have to be packed into every payload.
+	// See the metadata package.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// CorrelationID is the ID of the request this message responds to,
+	// such as the health.ping a health.pong answers. Empty for messages
+	// that aren't responses. See Reply and transport.Request.
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Attachments carry binary content outside Payload, inline or by
+	// reference. See Attach and AttachRef.
+	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
 // New creates a message with a random ID and current timestamp.
@@ -65,7 +101,7 @@ func New(source, typ string, payload any) (*Message, error) {
 		return nil, err
 	}
 	return &Message{
-		Version:     "1",
+		Version:     CurrentVersion,
 		ID:          newID(),
 		Source:      source,
 		Type:        typ,
@@ -74,11 +110,33 @@ func New(source, typ string, payload any) (*Message, error) {
 	}, nil
 }
 
+// Reply creates a response to m: a new message whose CorrelationID is
+// m's ID, so the requester can match it, and which inherits m's deadline.
+func (m *Message) Reply(source, typ string, payload any) (*Message, error) {
+	resp, err := New(source, typ, payload)
+	if err != nil {
+		return nil, err
+	}
+	resp.CorrelationID = m.ID
+	resp.DeadlineNS = m.DeadlineNS
+	return resp, nil
+}
+
+// IsReplyTo reports whether m is a response to the message with ID id.
+func (m *Message) IsReplyTo(id string) bool {
+	return m.CorrelationID != "" && m.CorrelationID == id
+}
+
 // Validate checks that the message envelope has the required fields.
 func (m *Message) Validate() error {
 	if m.Version == "" {
 		return fmt.Errorf("message: missing version")
 	}
+	// An envelope from a newer version may carry fields this library
+	// would silently drop, so it is refused rather than half-read.
+	if err := CheckVersion(m.Version); err != nil {
+		return err
+	}
 	if m.ID == "" {
 		return fmt.Errorf("message: missing id")
 	}
@@ -91,9 +149,60 @@ func (m *Message) Validate() error {
 	if len(m.Payload) > MaxMessageSize {
 		return fmt.Errorf("message: payload too large: %d bytes", len(m.Payload))
 	}
+	inline, err := m.validateAttachments()
+	if err != nil {
+		return err
+	}
+	if len(m.Payload)+inline > MaxMessageSize {
+		return fmt.Errorf("message: payload and inline attachments too large: %d bytes", len(m.Payload)+inline)
+	}
 	return nil
 }
 
+// Deadline returns the message deadline, if one is set.
+func (m *Message) Deadline() (time.Time, bool) {
+	if m.DeadlineNS == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, m.DeadlineNS), true
+}
+
+// SetTTL sets the message's time to live, measured from its timestamp.
+func (m *Message) SetTTL(d time.Duration) {
+	m.TTLNS = int64(d)
+}
+
+// ExpiresAt returns the earlier of the message's deadline and the end of
+// its TTL, if either is set.
+func (m *Message) ExpiresAt() (time.Time, bool) {
+	ns := m.DeadlineNS
+	if m.TTLNS > 0 {
+		if end := m.TimestampNS + m.TTLNS; ns == 0 || end < ns {
+			ns = end
+		}
+	}
+	if ns == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// Remaining returns the time left before the message expires, which is
+// negative once it has. ok is false if the message never expires.
+func (m *Message) Remaining(now time.Time) (d time.Duration, ok bool) {
+	at, ok := m.ExpiresAt()
+	if !ok {
+		return 0, false
+	}
+	return at.Sub(now), true
+}
+
+// Expired reports whether the message's deadline or TTL has passed.
+func (m *Message) Expired(now time.Time) bool {
+	at, ok := m.ExpiresAt()
+	return ok && !now.Before(at)
+}
+
 // Decode unmarshals the payload into the given value.
 func (m *Message) Decode(v any) error {
 	return json.Unmarshal(m.Payload, v)
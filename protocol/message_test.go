@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"testing"
+	"time"
 )
 
 func TestNewMessage(t *testing.T) {
@@ -161,6 +162,8 @@ func TestMessageTypes(t *testing.T) {
 		TypeEvalRun, TypeEvalResult,
 		TypeTraceSpan, TypeTraceAlert,
 		TypeHealthPing, TypeHealthPong,
+		TypeJobProgress, TypeControlDrain,
+		TypeTransportChunk,
 	}
 	seen := make(map[string]bool)
 	for _, typ := range types {
@@ -170,3 +173,92 @@ func TestMessageTypes(t *testing.T) {
 		seen[typ] = true
 	}
 }
+
+func TestMessageDeadline(t *testing.T) {
+	msg, _ := New("test", TypeHealthPing, HealthPing{From: "test"})
+	if _, ok := msg.Deadline(); ok {
+		t.Error("new message should have no deadline")
+	}
+	if msg.Expired(time.Now()) {
+		t.Error("message without deadline should never expire")
+	}
+
+	dl := time.Now().Add(time.Second)
+	msg.DeadlineNS = dl.UnixNano()
+	data, _ := msg.Marshal()
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := got.Deadline(); !ok || !d.Equal(time.Unix(0, dl.UnixNano())) {
+		t.Errorf("deadline = %v, %v", d, ok)
+	}
+	if got.Expired(time.Now()) {
+		t.Error("should not be expired yet")
+	}
+	if !got.Expired(dl.Add(time.Millisecond)) {
+		t.Error("should be expired after deadline")
+	}
+}
+
+func TestMessageTTL(t *testing.T) {
+	msg, _ := New("test", TypeHealthPing, HealthPing{From: "test"})
+	if _, ok := msg.Remaining(time.Now()); ok {
+		t.Error("new message should never expire")
+	}
+
+	sent := time.Unix(0, msg.TimestampNS)
+	msg.SetTTL(5 * time.Second)
+	data, _ := msg.Marshal()
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TTLNS != int64(5*time.Second) {
+		t.Errorf("TTLNS = %d after round trip", got.TTLNS)
+	}
+	if d, ok := got.Remaining(sent.Add(2 * time.Second)); !ok || d != 3*time.Second {
+		t.Errorf("Remaining = %v, %v; want 3s", d, ok)
+	}
+	if got.Expired(sent.Add(4 * time.Second)) {
+		t.Error("should not be expired within TTL")
+	}
+	if !got.Expired(sent.Add(5 * time.Second)) {
+		t.Error("should be expired at end of TTL")
+	}
+
+	// The earlier of deadline and TTL wins.
+	got.DeadlineNS = sent.Add(time.Second).UnixNano()
+	if at, _ := got.ExpiresAt(); !at.Equal(sent.Add(time.Second)) {
+		t.Errorf("ExpiresAt = %v, want deadline", at)
+	}
+	got.DeadlineNS = sent.Add(time.Minute).UnixNano()
+	if at, _ := got.ExpiresAt(); !at.Equal(sent.Add(5 * time.Second)) {
+		t.Errorf("ExpiresAt = %v, want end of TTL", at)
+	}
+}
+
+func TestMessageReply(t *testing.T) {
+	ping, _ := New("cli", TypeHealthPing, HealthPing{From: "cli"})
+	ping.DeadlineNS = time.Now().Add(time.Second).UnixNano()
+
+	pong, err := ping.Reply("tokentrace", TypeHealthPong, HealthPong{From: "tokentrace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pong.ID == ping.ID || pong.CorrelationID != ping.ID || pong.DeadlineNS != ping.DeadlineNS {
+		t.Errorf("reply = %+v", pong)
+	}
+
+	data, _ := pong.Marshal()
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsReplyTo(ping.ID) {
+		t.Errorf("CorrelationID = %q after round trip", got.CorrelationID)
+	}
+	if ping.IsReplyTo(ping.ID) || ping.IsReplyTo("") {
+		t.Error("a request is not a reply")
+	}
+}
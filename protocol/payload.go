@@ -1,5 +1,7 @@
 package protocol
 
+import "strings"
+
 // InferRequest is sent to InferMux to perform LLM inference.
 type InferRequest struct {
 	Model    string            `json:"model"`              // model name or "auto" for routing
@@ -7,12 +9,64 @@ type InferRequest struct {
 	Messages []ChatMessage     `json:"messages"`
 	Params   map[string]any    `json:"params,omitempty"` // temperature, max_tokens, etc.
 	Meta     map[string]string `json:"meta,omitempty"`   // trace context, request tags
+
+	// Attachments hold the content of the image and audio parts of
+	// Messages. Sent in a message envelope they belong on the envelope
+	// instead, where InferMux finds them; see Message.Attach.
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment returns the attachment named name.
+func (r *InferRequest) Attachment(name string) (*Attachment, bool) {
+	for i := range r.Attachments {
+		if r.Attachments[i].Name == name {
+			return &r.Attachments[i], true
+		}
+	}
+	return nil, false
 }
 
-// ChatMessage is a single message in a conversation.
+// ChatMessage is a single message in a conversation. Its content is
+// either Content, plain text, or Parts, for multimodal input.
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string        `json:"role"`
+	Content string        `json:"content"`
+	Parts   []ContentPart `json:"parts,omitempty"`
+}
+
+// Text returns the message's text: Content, or its text parts joined by
+// newlines.
+func (m ChatMessage) Text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	var text []string
+	for _, p := range m.Parts {
+		if p.Type == PartText {
+			text = append(text, p.Text)
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+// Content part types.
+const (
+	PartText  = "text"
+	PartImage = "image"
+	PartAudio = "audio"
+)
+
+// ContentPart is one piece of a multimodal chat message: text, or an
+// image or audio clip whose bytes are the request attachment it names.
+type ContentPart struct {
+	Type       string `json:"type"` // PartText, PartImage, or PartAudio
+	Text       string `json:"text,omitempty"`
+	Attachment string `json:"attachment,omitempty"`
+
+	// Width and Height are an image's size in pixels, if the sender
+	// knows it, for estimating its tokens without decoding it.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
 }
 
 // InferResponse is returned by InferMux after inference completes.
@@ -22,11 +76,24 @@ type InferResponse struct {
 	Content      string  `json:"content"`
 	TokensIn     int64   `json:"tokens_in"`
 	TokensOut    int64   `json:"tokens_out"`
+	ImageTokens  int64   `json:"image_tokens,omitempty"` // part of TokensIn
 	CostUSD      float64 `json:"cost_usd"`
 	LatencyMS    int64   `json:"latency_ms"`
 	FinishReason string  `json:"finish_reason"`
 }
 
+// InferResponseChunk is one increment of a streamed inference response.
+// Chunks carry Delta text in Index order; the last has Done set and
+// carries either the complete Response, with token counts and cost, or
+// the Error that ended the stream.
+type InferResponseChunk struct {
+	Index    int            `json:"index"`
+	Delta    string         `json:"delta,omitempty"`
+	Done     bool           `json:"done,omitempty"`
+	Response *InferResponse `json:"response,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
 // EvalRun starts an evaluation job in MatchSpec.
 type EvalRun struct {
 	Suite    string            `json:"suite"`               // benchmark suite name
@@ -103,3 +170,55 @@ type HealthPong struct {
 	Version string `json:"version"`
 	Uptime  int64  `json:"uptime_s"`
 }
+
+// JobProgress reports how far a checkpointed job has advanced. Percent is
+// weighted by expected step durations; ETAMS is zero when unknown.
+type JobProgress struct {
+	RunID     string  `json:"run_id"`
+	Completed int     `json:"completed"`
+	Total     int     `json:"total"`
+	Percent   float64 `json:"percent"`
+	ETAMS     int64   `json:"eta_ms"`
+	State     string  `json:"state,omitempty"`
+}
+
+// ControlDrain asks a relay or collector to stop accepting new work and
+// finish what it has in flight, ahead of a shutdown or upgrade.
+type ControlDrain struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// MessageChunk carries one piece of an encoded message that was split
+// to fit a transport's size limit. Receivers reassemble the Count pieces
+// of MessageID, Size bytes in all, in Index order.
+type MessageChunk struct {
+	MessageID string `json:"message_id"`
+	Index     int    `json:"index"`
+	Count     int    `json:"count"`
+	Size      int    `json:"size"`
+	Data      []byte `json:"data"`
+}
+
+// Drain states reported in DrainStatus.
+const (
+	DrainAccepting = "accepting" // normal operation
+	DrainDraining  = "draining"  // rejecting new work, finishing in-flight
+	DrainDrained   = "drained"   // nothing left; safe to stop
+)
+
+// Handshake agrees on an envelope version between two services. The
+// request offers the sender's supported range; the reply carries the
+// responder's range and the agreed Version, or Error if the ranges don't
+// overlap. See NewHandshake.
+type Handshake struct {
+	Versions string `json:"versions"`          // supported range, e.g. "1-2"
+	Version  string `json:"version,omitempty"` // agreed version, in replies
+	Error    string `json:"error,omitempty"`
+}
+
+// DrainStatus reports how far a drain has progressed.
+type DrainStatus struct {
+	State    string `json:"state"`
+	InFlight int64  `json:"in_flight"`
+	Queued   int    `json:"queued"`
+}
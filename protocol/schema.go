@@ -0,0 +1,267 @@
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema describes the JSON shape of a payload, or of one of its fields,
+// as a subset of JSON Schema. A field is required if it is always
+// encoded — it has no omitempty — so readers may rely on its presence.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"` // empty for any JSON value
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`                // array elements
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"` // map values
+}
+
+// SchemaSet holds the payload schema of each message type. Generate it
+// with PayloadSchemas, save it with each release, and compare releases
+// with DiffSchemas.
+type SchemaSet struct {
+	Payloads map[string]*Schema `json:"payloads"` // by message type
+}
+
+// payloads maps each message type to its payload type.
+var payloads = map[string]any{
+	TypeDataEntities:       DataEntities{},
+	TypeDataSchema:         DataSchema{},
+	TypeInferRequest:       InferRequest{},
+	TypeInferResponse:      InferResponse{},
+	TypeInferResponseChunk: InferResponseChunk{},
+	TypeEvalRun:            EvalRun{},
+	TypeEvalResult:         EvalResult{},
+	TypeTraceSpan:          TraceSpan{},
+	TypeTraceAlert:         TraceAlert{},
+	TypeHealthPing:         HealthPing{},
+	TypeHealthPong:         HealthPong{},
+	TypeJobProgress:        JobProgress{},
+	TypeControlDrain:       ControlDrain{},
+	TypeControlHandshake:   Handshake{},
+	TypeTransportChunk:     MessageChunk{},
+}
+
+// PayloadSchemas generates the schema of every payload type in this
+// version of the protocol.
+func PayloadSchemas() SchemaSet {
+	set := SchemaSet{Payloads: make(map[string]*Schema, len(payloads))}
+	for typ, v := range payloads {
+		set.Payloads[typ] = SchemaOf(v)
+	}
+	return set
+}
+
+// SchemaOf generates the schema of v's type as encoding/json encodes it.
+func SchemaOf(v any) *Schema {
+	return schemaOf(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"} // base64
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{Type: "object"} // recursive type
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		addFields(s, t, seen)
+		sort.Strings(s.Required)
+		return s
+	}
+	return &Schema{}
+}
+
+// addFields adds the JSON fields of struct type t to s, flattening
+// embedded structs as encoding/json does.
+func addFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addFields(s, ft, seen)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaOf(f.Type, seen)
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// Schema change kinds reported by DiffSchemas.
+const (
+	SchemaRemoved     = "removed"      // a payload or field no longer exists
+	SchemaAdded       = "added"        // a new payload or field
+	SchemaTypeChanged = "type_changed" // a field's JSON type changed
+	SchemaNowRequired = "now_required" // an optional field became required
+	SchemaNowOptional = "now_optional" // a required field became optional
+)
+
+// SchemaChange is one difference between two schema sets. Path names the
+// payload by message type, then its fields, with "[]" for array elements
+// and "{}" for map values, e.g. "infer.request.messages[].role".
+type SchemaChange struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	Breaking bool   `json:"breaking"`
+}
+
+// DiffSchemas compares the payload schemas of two releases, sorted by
+// path. A change is breaking if a reader or writer built against prev
+// can no longer interoperate with next: a removed payload or field, a
+// changed type, or a field that is newly required, which old writers
+// don't send.
+// New optional fields and payloads, and required fields that become
+// optional, are reported but not breaking.
+func DiffSchemas(prev, next SchemaSet) []SchemaChange {
+	var changes []SchemaChange
+	for _, typ := range unionKeys(prev.Payloads, next.Payloads) {
+		o, n := prev.Payloads[typ], next.Payloads[typ]
+		switch {
+		case n == nil:
+			changes = append(changes, SchemaChange{Path: typ, Kind: SchemaRemoved, Old: o.Type, Breaking: true})
+		case o == nil:
+			changes = append(changes, SchemaChange{Path: typ, Kind: SchemaAdded, New: n.Type})
+		default:
+			changes = diffSchema(changes, typ, o, n)
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// BreakingChanges returns the breaking changes among changes.
+func BreakingChanges(changes []SchemaChange) []SchemaChange {
+	var out []SchemaChange
+	for _, c := range changes {
+		if c.Breaking {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func diffSchema(changes []SchemaChange, path string, o, n *Schema) []SchemaChange {
+	if o.Type != n.Type {
+		return append(changes, SchemaChange{Path: path, Kind: SchemaTypeChanged, Old: typeName(o), New: typeName(n), Breaking: true})
+	}
+	if o.Items != nil && n.Items != nil {
+		changes = diffSchema(changes, path+"[]", o.Items, n.Items)
+	}
+	if o.AdditionalProperties != nil && n.AdditionalProperties != nil {
+		changes = diffSchema(changes, path+"{}", o.AdditionalProperties, n.AdditionalProperties)
+	}
+
+	oldReq, newReq := stringSet(o.Required), stringSet(n.Required)
+	for _, name := range unionKeys(o.Properties, n.Properties) {
+		fp := path + "." + name
+		of, nf := o.Properties[name], n.Properties[name]
+		switch {
+		case nf == nil:
+			changes = append(changes, SchemaChange{Path: fp, Kind: SchemaRemoved, Old: typeName(of), Breaking: true})
+		case of == nil:
+			changes = append(changes, SchemaChange{Path: fp, Kind: SchemaAdded, New: typeName(nf), Breaking: newReq[name]})
+		default:
+			if !oldReq[name] && newReq[name] {
+				changes = append(changes, SchemaChange{Path: fp, Kind: SchemaNowRequired, Breaking: true})
+			} else if oldReq[name] && !newReq[name] {
+				changes = append(changes, SchemaChange{Path: fp, Kind: SchemaNowOptional})
+			}
+			changes = diffSchema(changes, fp, of, nf)
+		}
+	}
+	return changes
+}
+
+// typeName describes a schema's type for a SchemaChange, e.g. "string",
+// "array of string", or "any".
+func typeName(s *Schema) string {
+	switch {
+	case s.Type == "":
+		return "any"
+	case s.Type == "array" && s.Items != nil:
+		return "array of " + typeName(s.Items)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "map of " + typeName(s.AdditionalProperties)
+	}
+	return s.Type
+}
+
+func unionKeys(a, b map[string]*Schema) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func stringSet(ss []string) map[string]bool {
+	m := make(map[string]bool, len(ss))
+	for _, s := range ss {
+		m[s] = true
+	}
+	return m
+}
+
+// String formats the change for a log line, e.g.
+// "infer.request.model: type_changed string → integer (breaking)".
+func (c SchemaChange) String() string {
+	s := c.Path + ": " + c.Kind
+	switch {
+	case c.Old != "" && c.New != "":
+		s += fmt.Sprintf(" %s → %s", c.Old, c.New)
+	case c.Old != "":
+		s += " " + c.Old
+	case c.New != "":
+		s += " " + c.New
+	}
+	if c.Breaking {
+		s += " (breaking)"
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(InferRequest{})
+	if s.Type != "object" {
+		t.Fatalf("Type = %q", s.Type)
+	}
+	if got := s.Properties["messages"]; got.Type != "array" || got.Items.Properties["role"].Type != "string" {
+		t.Errorf("messages = %+v", got)
+	}
+	if got := s.Properties["params"]; got.Type != "object" || got.AdditionalProperties.Type != "" {
+		t.Errorf("params = %+v, want map of any", got)
+	}
+	if want := []string{"messages", "model"}; !reflect.DeepEqual(s.Required, want) {
+		t.Errorf("Required = %v, want %v", s.Required, want)
+	}
+	if got := SchemaOf(MessageChunk{}).Properties["data"].Type; got != "string" {
+		t.Errorf("[]byte field type = %q, want string", got)
+	}
+}
+
+func TestPayloadSchemasRoundTrip(t *testing.T) {
+	set := PayloadSchemas()
+	if _, ok := set.Payloads[TypeTraceSpan]; !ok {
+		t.Fatal("no schema for trace.span")
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back SchemaSet
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if changes := DiffSchemas(set, back); len(changes) != 0 {
+		t.Errorf("round trip changed schemas: %v", changes)
+	}
+}
+
+func TestDiffSchemas(t *testing.T) {
+	type spanV1 struct {
+		TraceID string            `json:"trace_id"`
+		Latency int64             `json:"latency"`
+		Tags    []string          `json:"tags,omitempty"`
+		Attrs   map[string]string `json:"attrs"`
+		Note    string            `json:"note,omitempty"`
+		Old     string            `json:"old"`
+	}
+	type spanV2 struct {
+		TraceID string         `json:"trace_id"`
+		Latency float64        `json:"latency"`         // type change
+		Tags    []int          `json:"tags,omitempty"`  // element type change
+		Attrs   map[string]any `json:"attrs,omitempty"` // now optional, value type change
+		Note    string         `json:"note"`            // now required
+		Cost    float64        `json:"cost,omitempty"`  // added, optional
+		Region  string         `json:"region"`          // added, required
+	}
+	prev := SchemaSet{Payloads: map[string]*Schema{"span": SchemaOf(spanV1{}), "gone": SchemaOf(HealthPing{})}}
+	next := SchemaSet{Payloads: map[string]*Schema{"span": SchemaOf(spanV2{}), "new": SchemaOf(HealthPing{})}}
+
+	want := []SchemaChange{
+		{Path: "gone", Kind: SchemaRemoved, Old: "object", Breaking: true},
+		{Path: "new", Kind: SchemaAdded, New: "object"},
+		{Path: "span.attrs", Kind: SchemaNowOptional},
+		{Path: "span.attrs{}", Kind: SchemaTypeChanged, Old: "string", New: "any", Breaking: true},
+		{Path: "span.cost", Kind: SchemaAdded, New: "number"},
+		{Path: "span.latency", Kind: SchemaTypeChanged, Old: "integer", New: "number", Breaking: true},
+		{Path: "span.note", Kind: SchemaNowRequired, Breaking: true},
+		{Path: "span.old", Kind: SchemaRemoved, Old: "string", Breaking: true},
+		{Path: "span.region", Kind: SchemaAdded, New: "string", Breaking: true},
+		{Path: "span.tags[]", Kind: SchemaTypeChanged, Old: "string", New: "integer", Breaking: true},
+	}
+	got := DiffSchemas(prev, next)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffSchemas:\n got %v\nwant %v", got, want)
+	}
+	if n := len(BreakingChanges(got)); n != 7 {
+		t.Errorf("BreakingChanges = %d, want 7", n)
+	}
+	if s := want[5].String(); s != "span.latency: type_changed integer → number (breaking)" {
+		t.Errorf("String = %q", s)
+	}
+}
@@ -8,14 +8,20 @@ import (
 
 // Version constants for the MIST protocol.
 const (
-	// CurrentVersion is the protocol version used by this library.
+	// CurrentVersion is the envelope version New stamps on messages. It
+	// stays at the version every peer reads; services that have agreed
+	// on a newer one with a handshake convert with ConvertTo.
 	CurrentVersion = "1"
 
 	// MinSupportedVersion is the oldest version this library can read.
 	MinSupportedVersion = "1"
 
 	// MaxSupportedVersion is the newest version this library understands.
-	MaxSupportedVersion = "1"
+	MaxSupportedVersion = "3"
+
+	// SupportedVersions is the range this library reads and writes, as
+	// NegotiateVersion and Handshake.Versions take it.
+	SupportedVersions = MinSupportedVersion + "-" + MaxSupportedVersion
 )
 
 // CheckVersion validates that a message version is compatible with this
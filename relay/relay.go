@@ -0,0 +1,492 @@
+// Package relay forwards messages from one transport to others, the loop
+// behind mist relay, with per-message hooks to drop, rewrite, or tee
+// what passes through:
+//
+//	r := relay.New(src, dst,
+//	    relay.WithFilter(relay.DropTypes(protocol.TypeHealthPing)),
+//	    relay.WithTransform(relay.SetSource("edge-relay")),
+//	    relay.WithTee(archive))
+//	err := r.Run(ctx)
+//
+// Filters run first, in the order given; a message any of them rejects
+// is dropped. Transforms then rewrite the kept message in order, and
+// handlers, if any, turn it into the messages to send. Each is sent to
+// the destination and to every fan-out destination.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/transport"
+)
+
+// Filter reports whether a message should be relayed.
+type Filter func(msg *protocol.Message) bool
+
+// Transform rewrites a message in place before it is sent.
+type Transform func(msg *protocol.Message)
+
+// Handler turns a relayed message into the messages to send in its
+// place: itself, rewritten or not, several, e.g. one per entity of a
+// batch, or none to drop it. An error fails the message; see
+// WithDeadLetter.
+type Handler func(ctx context.Context, msg *protocol.Message) ([]*protocol.Message, error)
+
+// FanOut returns the destinations a message is sent to besides the
+// relay's own.
+type FanOut func(msg *protocol.Message) []transport.Sender
+
+// Option configures a Relay.
+type Option func(*Relay)
+
+// WithFilter drops messages f rejects. Filters are applied in order.
+func WithFilter(f Filter) Option {
+	return func(r *Relay) { r.filters = append(r.filters, f) }
+}
+
+// WithTransform rewrites each relayed message with t. Transforms are
+// applied in order, after the filters.
+func WithTransform(t Transform) Option {
+	return func(r *Relay) { r.transforms = append(r.transforms, t) }
+}
+
+// WithHandler passes each relayed message through h, after the filters
+// and transforms. Handlers are applied in order, each to every message
+// the one before returned.
+//
+// A message a handler fails on stops the relay, unacked, unless a
+// dead-letter destination is set with WithDeadLetter.
+func WithHandler(h Handler) Option {
+	return func(r *Relay) { r.handlers = append(r.handlers, h) }
+}
+
+// WithDeadLetter sends messages a handler fails on to dlq, with the
+// error in their transport.MetaDeadLetterError, and carries on. Messages
+// the same handler returned for others are still sent.
+func WithDeadLetter(dlq transport.Sender) Option {
+	return func(r *Relay) { r.dlq = dlq }
+}
+
+// WithFanOut also sends each message to the destinations fn returns for
+// it, e.g. to route by message type. Each gets its own copy of the
+// message, so middleware on one destination doesn't alter what another
+// receives.
+func WithFanOut(fn FanOut) Option {
+	return func(r *Relay) { r.fanOuts = append(r.fanOuts, fn) }
+}
+
+// WithTee also sends every message to dsts, as WithFanOut does.
+func WithTee(dsts ...transport.Sender) Option {
+	return WithFanOut(func(*protocol.Message) []transport.Sender { return dsts })
+}
+
+// WithBatchSize forwards messages in batches of up to n, sending a
+// partial batch once the source has been quiet for a moment. Batches
+// reach destinations that are transport.BatchSenders in one call. Default
+// 1, one Send per message.
+func WithBatchSize(n int) Option {
+	return func(r *Relay) {
+		if n > 0 {
+			r.batchSize = n
+		}
+	}
+}
+
+// DropTypes returns a Filter that drops messages of the given types.
+func DropTypes(types ...string) Filter {
+	return func(msg *protocol.Message) bool { return !slices.Contains(types, msg.Type) }
+}
+
+// SetSource returns a Transform that sets each message's Source, so
+// receivers see the relay as the sender.
+func SetSource(source string) Transform {
+	return func(msg *protocol.Message) { msg.Source = source }
+}
+
+// linger is how long a batching relay holds a partial batch waiting for
+// more messages.
+const linger = 50 * time.Millisecond
+
+// flushTimeout bounds a batch send once ctx is cancelled, so messages
+// already received are not lost on shutdown.
+const flushTimeout = 30 * time.Second
+
+// Relay forwards messages from a source to a destination until the
+// source ends, ctx is done, or a control.drain arrives.
+type Relay struct {
+	src        transport.Receiver
+	dst        transport.Sender
+	filters    []Filter
+	transforms []Transform
+	handlers   []Handler
+	dlq        transport.Sender
+	fanOuts    []FanOut
+	batchSize  int
+
+	relayed, expired, filtered, deadLettered atomic.Int64
+	drainedBy                                atomic.Pointer[string]
+
+	mu     sync.Mutex
+	resume chan struct{} // closed by Resume; nil unless paused
+}
+
+// Stats counts what a relay did with the messages it received.
+type Stats struct {
+	Relayed  int64 // sent to the destination
+	Expired  int64 // refused by the destination with transport.ErrExpired
+	Filtered int64 // dropped by a filter, or by a handler returning none
+
+	// DeadLettered counts messages a handler failed on that were sent
+	// to the WithDeadLetter destination.
+	DeadLettered int64
+
+	// DrainedBy is the source of the control.drain that ended the
+	// relay, if one did.
+	DrainedBy string
+}
+
+// New creates a relay from src to dst.
+func New(src transport.Receiver, dst transport.Sender, opts ...Option) *Relay {
+	r := &Relay{src: src, dst: dst, batchSize: 1}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Stats returns the relay's counts so far. It is safe to call while Run
+// is running.
+func (r *Relay) Stats() Stats {
+	s := Stats{
+		Relayed:      r.relayed.Load(),
+		Expired:      r.expired.Load(),
+		Filtered:     r.filtered.Load(),
+		DeadLettered: r.deadLettered.Load(),
+	}
+	if p := r.drainedBy.Load(); p != nil {
+		s.DrainedBy = *p
+	}
+	return s
+}
+
+// Pause stops the relay taking messages from the source until Resume.
+// Messages already received are still sent, and at most one more is
+// received and held. Pausing a paused relay does nothing.
+func (r *Relay) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resume == nil {
+		r.resume = make(chan struct{})
+	}
+}
+
+// Resume undoes Pause.
+func (r *Relay) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resume != nil {
+		close(r.resume)
+		r.resume = nil
+	}
+}
+
+// Paused reports whether the relay is paused.
+func (r *Relay) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resume != nil
+}
+
+// wait blocks while the relay is paused, returning false if ctx is done
+// first.
+func (r *Relay) wait(ctx context.Context) bool {
+	r.mu.Lock()
+	resume := r.resume
+	r.mu.Unlock()
+	if resume == nil {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Run relays messages until the source ends or ctx is done, returning
+// nil, or until a receive or send fails. Messages refused with
+// transport.ErrExpired are counted and skipped. A control.drain on the
+// source ends the relay once every message before it has been
+// forwarded; it is not passed on.
+//
+// If the source is a transport.AckReceiver, such as a File, each message
+// is acked once it has been sent, filtered, or expired, and nacked if
+// the send fails, so with a source that keeps its acks (a File with
+// SetResume) no message is lost when the relay stops on an error and is
+// restarted: delivery is at least once. A message held while the
+// relay is paused is nacked if ctx ends first.
+func (r *Relay) Run(ctx context.Context) error {
+	if r.batchSize > 1 {
+		return r.batched(ctx)
+	}
+	for d, err := range transport.Deliveries(ctx, r.src) {
+		if err != nil {
+			return fmt.Errorf("relay: receive: %w", err)
+		}
+		if !r.wait(ctx) {
+			d.Nack()
+			return nil
+		}
+		if r.drain(d.Message) {
+			return settle([]*transport.Delivery{d}, nil)
+		}
+		msgs, err := r.process(ctx, d.Message)
+		if err == nil && len(msgs) > 0 {
+			err = r.send(ctx, msgs)
+		}
+		if err := settle([]*transport.Delivery{d}, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settle acks ds if the send that carried them succeeded, or nacks them
+// if it failed with sendErr, which it returns.
+func settle(ds []*transport.Delivery, sendErr error) error {
+	for _, d := range ds {
+		if sendErr != nil {
+			d.Nack()
+			continue
+		}
+		if err := d.Ack(); err != nil {
+			return fmt.Errorf("relay: ack: %w", err)
+		}
+	}
+	return sendErr
+}
+
+// drain reports whether msg is a control.drain, recording its source.
+func (r *Relay) drain(msg *protocol.Message) bool {
+	if msg.Type != protocol.TypeControlDrain {
+		return false
+	}
+	source := msg.Source
+	r.drainedBy.Store(&source)
+	return true
+}
+
+// process applies the filters, transforms, and handlers to msg and
+// returns the messages to send for it, none if it was dropped.
+func (r *Relay) process(ctx context.Context, msg *protocol.Message) ([]*protocol.Message, error) {
+	for _, f := range r.filters {
+		if !f(msg) {
+			r.filtered.Add(1)
+			return nil, nil
+		}
+	}
+	for _, t := range r.transforms {
+		t(msg)
+	}
+
+	msgs, failed := []*protocol.Message{msg}, false
+	for _, h := range r.handlers {
+		var next []*protocol.Message
+		for _, m := range msgs {
+			out, err := h(ctx, m)
+			if err != nil {
+				if err := r.deadLetter(ctx, m, err); err != nil {
+					return nil, err
+				}
+				failed = true
+				continue
+			}
+			next = append(next, out...)
+		}
+		msgs = next
+	}
+	if len(msgs) == 0 && !failed {
+		r.filtered.Add(1)
+	}
+	return msgs, nil
+}
+
+// deadLetter sends msg, which a handler failed on with handlerErr, to
+// the dead-letter destination. It returns the failure instead if there
+// is none, or if ctx is done.
+func (r *Relay) deadLetter(ctx context.Context, msg *protocol.Message, handlerErr error) error {
+	if r.dlq == nil || ctx.Err() != nil {
+		return fmt.Errorf("relay: handler: %w", handlerErr)
+	}
+	if msg.Meta == nil {
+		msg.Meta = make(map[string]string, 1)
+	}
+	msg.Meta[transport.MetaDeadLetterError] = handlerErr.Error()
+	if err := r.dlq.Send(ctx, msg); err != nil {
+		return fmt.Errorf("relay: dead letter: %w (after handler failed: %w)", err, handlerErr)
+	}
+	r.deadLettered.Add(1)
+	return nil
+}
+
+// send sends msgs to the destination and their copies to the fan-out
+// destinations. Copies are taken first, as middleware on the destination
+// may stamp the originals.
+func (r *Relay) send(ctx context.Context, msgs []*protocol.Message) error {
+	type group struct {
+		dst  transport.Sender
+		msgs []*protocol.Message
+	}
+	var groups []group
+	for _, msg := range msgs {
+		for _, fn := range r.fanOuts {
+			for _, dst := range fn(msg) {
+				i := slices.IndexFunc(groups, func(g group) bool { return g.dst == dst })
+				if i < 0 {
+					i = len(groups)
+					groups = append(groups, group{dst: dst})
+				}
+				groups[i].msgs = append(groups[i].msgs, clone(msg))
+			}
+		}
+	}
+
+	expired, err := sendTo(ctx, r.dst, msgs)
+	if err != nil {
+		return fmt.Errorf("relay: send: %w", err)
+	}
+	r.relayed.Add(int64(len(msgs) - expired))
+	r.expired.Add(int64(expired))
+	for _, g := range groups {
+		if _, err := sendTo(ctx, g.dst, g.msgs); err != nil {
+			return fmt.Errorf("relay: fan-out send: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendTo sends msgs to dst, in one call if there are several and dst is
+// a BatchSender, and returns how many it refused as expired.
+func sendTo(ctx context.Context, dst transport.Sender, msgs []*protocol.Message) (expired int, err error) {
+	if bs, ok := dst.(transport.BatchSender); ok && len(msgs) > 1 {
+		err := bs.SendBatch(ctx, msgs)
+		var eb *transport.ExpiredBatchError
+		if errors.As(err, &eb) {
+			return eb.Expired, nil
+		}
+		return 0, err
+	}
+	for _, msg := range msgs {
+		if err := dst.Send(ctx, msg); misterrors.Is(err, transport.ErrExpired) {
+			expired++
+		} else if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// clone copies msg for another destination. The payload is shared, as
+// nothing modifies it in flight.
+func clone(msg *protocol.Message) *protocol.Message {
+	c := *msg
+	c.Meta = maps.Clone(msg.Meta)
+	return &c
+}
+
+// batched is Run for a batch size above one. Messages already received
+// are still sent when ctx is cancelled.
+func (r *Relay) batched(ctx context.Context) error {
+	type received struct {
+		d   *transport.Delivery
+		err error
+	}
+	recvCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	items := make(chan received)
+	go func() {
+		defer close(items)
+		for d, err := range transport.Deliveries(recvCtx, r.src) {
+			if err == nil && !r.wait(recvCtx) {
+				d.Nack()
+				return
+			}
+			select {
+			case items <- received{d, err}:
+			case <-recvCtx.Done():
+				return
+			}
+			if err != nil || d.Message.Type == protocol.TypeControlDrain {
+				return
+			}
+		}
+	}()
+
+	batch := make([]*protocol.Message, 0, r.batchSize)
+	pending := make([]*transport.Delivery, 0, r.batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flushTimeout)
+		defer cancel()
+		err := settle(pending, r.send(sendCtx, batch))
+		batch, pending = batch[:0], pending[:0]
+		return err
+	}
+
+	timer := time.NewTimer(linger)
+	timer.Stop()
+	for {
+		select {
+		case item, ok := <-items:
+			switch {
+			case !ok:
+				return flush()
+			case item.err != nil:
+				if err := flush(); err != nil {
+					return err
+				}
+				return fmt.Errorf("relay: receive: %w", item.err)
+			case r.drain(item.d.Message):
+				if err := flush(); err != nil {
+					return err
+				}
+				return settle([]*transport.Delivery{item.d}, nil)
+			}
+			msgs, err := r.process(ctx, item.d.Message)
+			if err != nil || len(msgs) == 0 {
+				if err != nil {
+					err = errors.Join(flush(), err)
+				}
+				if err := settle([]*transport.Delivery{item.d}, err); err != nil {
+					return err
+				}
+				continue
+			}
+			batch = append(batch, msgs...)
+			pending = append(pending, item.d)
+			if len(batch) >= r.batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			} else if len(pending) == 1 {
+				timer.Reset(linger)
+			}
+		case <-timer.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
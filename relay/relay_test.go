@@ -0,0 +1,349 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/tokentrace"
+	"github.com/greynewell/mist-go/transport"
+)
+
+func message(t *testing.T, typ string) *protocol.Message {
+	t.Helper()
+	msg, err := protocol.New("origin", typ, protocol.HealthPing{From: "origin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// feed sends msgs on a channel and closes it, so a relay reading it
+// stops at the end.
+func feed(t *testing.T, msgs ...*protocol.Message) *transport.Channel {
+	t.Helper()
+	src := transport.NewChannel(len(msgs))
+	for _, msg := range msgs {
+		if err := src.Send(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Close()
+	return src
+}
+
+func drain(ch *transport.Channel) []*protocol.Message {
+	ch.Close()
+	var out []*protocol.Message
+	for msg := range transport.Messages(context.Background(), ch) {
+		out = append(out, msg)
+	}
+	return out
+}
+
+func TestRelayFilterTransformTee(t *testing.T) {
+	for _, batch := range []int{1, 4} {
+		src := feed(t,
+			message(t, protocol.TypeTraceSpan),
+			message(t, protocol.TypeHealthPing),
+			message(t, protocol.TypeTraceSpan),
+		)
+		dst, archive := transport.NewChannel(10), transport.NewChannel(10)
+		r := New(src, dst,
+			WithBatchSize(batch),
+			WithFilter(DropTypes(protocol.TypeHealthPing)),
+			WithTransform(SetSource("edge")),
+			WithTransform(func(msg *protocol.Message) { msg.Meta = map[string]string{"relayed": "yes"} }),
+			WithTee(archive))
+		if err := r.Run(context.Background()); err != nil {
+			t.Fatalf("batch %d: Run: %v", batch, err)
+		}
+
+		got, teed := drain(dst), drain(archive)
+		if len(got) != 2 || len(teed) != 2 {
+			t.Fatalf("batch %d: dst got %d, tee got %d; want 2 each", batch, len(got), len(teed))
+		}
+		for i, msg := range got {
+			if msg.Type != protocol.TypeTraceSpan || msg.Source != "edge" || msg.Meta["relayed"] != "yes" {
+				t.Errorf("batch %d: msg %d = %s from %s, meta %v", batch, i, msg.Type, msg.Source, msg.Meta)
+			}
+			if teed[i] == msg || teed[i].ID != msg.ID || teed[i].Source != "edge" {
+				t.Errorf("batch %d: tee copy %d = %+v", batch, i, teed[i])
+			}
+		}
+		// The tee's copy has its own meta.
+		got[0].Meta["relayed"] = "changed"
+		if teed[0].Meta["relayed"] != "yes" {
+			t.Errorf("batch %d: tee shares meta with the destination", batch)
+		}
+		if s := r.Stats(); s.Relayed != 2 || s.Filtered != 1 || s.Expired != 0 {
+			t.Errorf("batch %d: stats = %+v", batch, s)
+		}
+	}
+}
+
+func TestRelayFanOutByType(t *testing.T) {
+	src := feed(t, message(t, protocol.TypeTraceSpan), message(t, protocol.TypeInferRequest))
+	dst, spans := transport.NewChannel(10), transport.NewChannel(10)
+	r := New(src, dst, WithFanOut(func(msg *protocol.Message) []transport.Sender {
+		if msg.Type == protocol.TypeTraceSpan {
+			return []transport.Sender{spans}
+		}
+		return nil
+	}))
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(spans); len(got) != 1 || got[0].Type != protocol.TypeTraceSpan {
+		t.Errorf("span destination got %v", got)
+	}
+	if got := drain(dst); len(got) != 2 {
+		t.Errorf("destination got %d messages, want 2", len(got))
+	}
+}
+
+func TestRelayHandler(t *testing.T) {
+	// split turns a message into one per item listed in its Meta["items"],
+	// and fails on a message without any.
+	split := func(_ context.Context, msg *protocol.Message) ([]*protocol.Message, error) {
+		items := msg.Meta["items"]
+		if items == "" {
+			return nil, errors.New("no items")
+		}
+		var out []*protocol.Message
+		for _, item := range strings.Split(items, ",") {
+			if item == "skip" {
+				continue
+			}
+			m := clone(msg)
+			m.Meta = map[string]string{"item": item}
+			out = append(out, m)
+		}
+		return out, nil
+	}
+	withItems := func(items string) *protocol.Message {
+		msg := message(t, protocol.TypeTraceSpan)
+		msg.Meta = map[string]string{"items": items}
+		return msg
+	}
+
+	for _, batch := range []int{1, 4} {
+		src := feed(t, withItems("a,b"), withItems(""), withItems("skip"), withItems("c"))
+		dst, dlq := transport.NewChannel(10), transport.NewChannel(10)
+		r := New(src, dst, WithBatchSize(batch), WithHandler(split), WithDeadLetter(dlq))
+		if err := r.Run(context.Background()); err != nil {
+			t.Fatalf("batch %d: Run: %v", batch, err)
+		}
+		var items []string
+		for _, msg := range drain(dst) {
+			items = append(items, msg.Meta["item"])
+		}
+		if strings.Join(items, ",") != "a,b,c" {
+			t.Errorf("batch %d: relayed items %v, want a,b,c", batch, items)
+		}
+		if dead := drain(dlq); len(dead) != 1 || dead[0].Meta[transport.MetaDeadLetterError] != "no items" {
+			t.Errorf("batch %d: dead letters = %v", batch, dead)
+		}
+		if s := r.Stats(); s.Relayed != 3 || s.Filtered != 1 || s.DeadLettered != 1 {
+			t.Errorf("batch %d: stats = %+v", batch, s)
+		}
+	}
+
+	// Without a dead-letter destination, a failure stops the relay after
+	// sending what came before it.
+	for _, batch := range []int{1, 4} {
+		src := feed(t, withItems("a"), withItems(""), withItems("b"))
+		dst := transport.NewChannel(10)
+		err := New(src, dst, WithBatchSize(batch), WithHandler(split)).Run(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "no items") {
+			t.Errorf("batch %d: Run = %v, want the handler's error", batch, err)
+		}
+		if got := drain(dst); len(got) != 1 || got[0].Meta["item"] != "a" {
+			t.Errorf("batch %d: relayed %v before the failure, want item a", batch, got)
+		}
+	}
+}
+
+func TestRelayDrain(t *testing.T) {
+	drainMsg, _ := protocol.New("deployer", protocol.TypeControlDrain, protocol.ControlDrain{})
+	src := feed(t, message(t, protocol.TypeTraceSpan), drainMsg, message(t, protocol.TypeTraceSpan))
+	dst := transport.NewChannel(10)
+	r := New(src, dst)
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Stats(); s.Relayed != 1 || s.DrainedBy != "deployer" {
+		t.Errorf("stats = %+v, want 1 relayed and drained by deployer", s)
+	}
+}
+
+func TestRelayPause(t *testing.T) {
+	for _, batch := range []int{1, 4} {
+		src, dst := transport.NewChannel(10), transport.NewChannel(10)
+		r := New(src, dst, WithBatchSize(batch))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- r.Run(ctx) }()
+
+		r.Pause()
+		if !r.Paused() {
+			t.Fatalf("batch %d: not paused after Pause", batch)
+		}
+		held := message(t, protocol.TypeTraceSpan)
+		src.Send(context.Background(), held)
+		wait, stop := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		if msg, err := dst.Receive(wait); err == nil {
+			t.Errorf("batch %d: paused relay forwarded %s", batch, msg.ID)
+		}
+		stop()
+
+		r.Resume()
+		wait, stop = context.WithTimeout(context.Background(), 5*time.Second)
+		if msg, err := dst.Receive(wait); err != nil || msg.ID != held.ID {
+			t.Errorf("batch %d: after Resume got %v, %v; want the held message", batch, msg, err)
+		}
+		stop()
+
+		// A message held when the relay stops while paused goes back to
+		// the source.
+		r.Pause()
+		held = message(t, protocol.TypeTraceSpan)
+		src.Send(context.Background(), held)
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("batch %d: Run: %v", batch, err)
+		}
+		wait, stop = context.WithTimeout(context.Background(), time.Second)
+		if msg, err := src.Receive(wait); err != nil || msg.ID != held.ID {
+			t.Errorf("batch %d: held message not returned to the source: %v, %v", batch, msg, err)
+		}
+		stop()
+	}
+}
+
+type failingSender struct{ err error }
+
+func (f failingSender) Send(context.Context, *protocol.Message) error { return f.err }
+
+func TestRelayExpiredAndErrors(t *testing.T) {
+	src := feed(t, message(t, protocol.TypeTraceSpan), message(t, protocol.TypeTraceSpan))
+	r := New(src, failingSender{transport.ErrExpired})
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("expired messages stopped the relay: %v", err)
+	}
+	if s := r.Stats(); s.Expired != 2 || s.Relayed != 0 {
+		t.Errorf("stats = %+v, want 2 expired", s)
+	}
+
+	boom := errors.New("boom")
+	src = feed(t, message(t, protocol.TypeTraceSpan))
+	r = New(src, transport.NewChannel(1), WithTee(failingSender{boom}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Run(ctx); !errors.Is(err, boom) {
+		t.Errorf("Run = %v, want the tee's error", err)
+	}
+}
+
+func TestRelayAtLeastOnce(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	w, _ := transport.NewFile(path)
+	var ids []string
+	for range 3 {
+		msg := message(t, protocol.TypeTraceSpan)
+		ids = append(ids, msg.ID)
+		w.Send(ctx, msg)
+	}
+	w.Close()
+
+	for _, batch := range []int{1, 2} {
+		t.Run(fmt.Sprintf("batch %d", batch), func(t *testing.T) {
+			os.Remove(path + ".ack")
+
+			// The destination fails its second send; the relay stops
+			// with that message unacked.
+			src, _ := transport.NewFile(path)
+			src.SetResume(true)
+			dst := &flakySender{failOn: 2, ch: transport.NewChannel(8)}
+			err := New(src, dst, WithBatchSize(batch)).Run(ctx)
+			src.Close()
+			if err == nil {
+				t.Fatal("Run succeeded despite the failed send")
+			}
+
+			// Restarted, it picks up where the acks left off.
+			src, _ = transport.NewFile(path)
+			src.SetResume(true)
+			defer src.Close()
+			if err := New(src, dst, WithBatchSize(batch)).Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, msg := range drain(dst.ch) {
+				got = append(got, msg.ID)
+			}
+			// A batch that failed part way is sent again in full, so
+			// its head may arrive twice.
+			got = slices.Compact(got)
+			if strings.Join(got, ",") != strings.Join(ids, ",") {
+				t.Errorf("relayed %v, want every one of %v", got, ids)
+			}
+		})
+	}
+}
+
+// flakySender fails its failOn'th send and sends the rest to ch.
+type flakySender struct {
+	failOn, sends int
+	ch            *transport.Channel
+}
+
+func (f *flakySender) Send(ctx context.Context, msg *protocol.Message) error {
+	if f.sends++; f.sends == f.failOn {
+		return errors.New("destination down")
+	}
+	return f.ch.Send(ctx, msg)
+}
+
+// TestRelayHTTPToTokenTrace relays batches over HTTP into a TokenTrace
+// ingest endpoint, as mist relay does with an http:// destination.
+func TestRelayHTTPToTokenTrace(t *testing.T) {
+	h := tokentrace.NewHandler(tokentrace.DefaultConfig())
+	srv := httptest.NewServer(http.HandlerFunc(h.Ingest))
+	defer srv.Close()
+
+	var msgs []*protocol.Message
+	for i := range 25 {
+		msg, err := protocol.New("agent", protocol.TypeTraceSpan, protocol.TraceSpan{
+			TraceID: "t1", SpanID: fmt.Sprint("s", i), Operation: "chat",
+			StartNS: 1, EndNS: 2, Status: "ok",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	dlq := transport.NewChannel(len(msgs))
+	dst := transport.NewDeadLetter(transport.NewHTTP(srv.URL+"/mist"), dlq, srv.URL)
+	if err := New(feed(t, msgs...), dst, WithBatchSize(10)).Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if dead := drain(dlq); len(dead) != 0 {
+		t.Errorf("%d messages dead-lettered, want none", len(dead))
+	}
+	if n := h.Store().Len(); n != len(msgs) {
+		t.Errorf("store holds %d spans, want %d", n, len(msgs))
+	}
+}
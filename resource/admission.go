@@ -0,0 +1,114 @@
+package resource
+
+import (
+	"net/http"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// ErrOverBudget is returned when a request's estimated memory cost does
+// not fit in the remaining budget. It carries CodeUnavailable, so
+// errors.WriteHTTP renders it as 503 and retry treats it as retryable.
+var ErrOverBudget = misterrors.New(misterrors.CodeUnavailable, "memory budget exceeded")
+
+// Estimator estimates the peak memory needed to handle a payload. A
+// payload is typically held several times over while it is processed —
+// the raw bytes, the decoded message, and the decoded payload — so the
+// estimate is Overhead + Factor × size.
+type Estimator struct {
+	// Factor multiplies the payload size. Zero means 3.
+	Factor float64
+
+	// Overhead is a fixed per-request cost in bytes.
+	Overhead int64
+
+	// Unknown is the payload size assumed when a request has no
+	// Content-Length. Zero means protocol.MaxMessageSize.
+	Unknown int64
+}
+
+// DefaultEstimator assumes three copies of the payload plus 16 KiB.
+var DefaultEstimator = Estimator{Factor: 3, Overhead: 16 << 10}
+
+// Estimate returns the estimated cost in bytes of handling a payload of
+// the given size.
+func (e Estimator) Estimate(payloadBytes int64) int64 {
+	factor := e.Factor
+	if factor <= 0 {
+		factor = 3
+	}
+	return e.Overhead + int64(float64(payloadBytes)*factor)
+}
+
+// EstimateMessage returns the estimated cost of handling msg.
+func (e Estimator) EstimateMessage(msg *protocol.Message) int64 {
+	return e.Estimate(int64(len(msg.Payload)))
+}
+
+// EstimateRequest returns the estimated cost of handling r's body and
+// the maximum body size the estimate covers.
+func (e Estimator) EstimateRequest(r *http.Request) (cost, maxBody int64) {
+	maxBody = r.ContentLength
+	if maxBody < 0 {
+		maxBody = e.Unknown
+		if maxBody <= 0 {
+			maxBody = protocol.MaxMessageSize
+		}
+	}
+	return e.Estimate(maxBody), maxBody
+}
+
+// Admit reserves bytes from the budget and returns a func that releases
+// them. It returns ErrOverBudget without reserving anything if the
+// reservation would exceed the limit.
+//
+//	release, err := budget.Admit(resource.DefaultEstimator.EstimateMessage(msg))
+//	if err != nil {
+//	    return err
+//	}
+//	defer release()
+func (m *MemoryBudget) Admit(bytes int64) (release func(), err error) {
+	if !m.Reserve(bytes) {
+		return nil, misterrors.Wrapf(misterrors.CodeUnavailable, ErrOverBudget,
+			"resource %s: need %d bytes, %d available", m.name, bytes, m.Available())
+	}
+	return func() { m.Release(bytes) }, nil
+}
+
+// Admission wraps next so each request's estimated memory cost is
+// reserved from the budget before next runs and released after it
+// returns. Requests that do not fit are rejected with 503 and a
+// Retry-After header. Bodies without a Content-Length are capped at the
+// estimator's Unknown size so they cannot outgrow their reservation.
+// Handlers that queue work to finish after they return, as the HTTP
+// transport's listener does, reserve with AdmitRequest instead and hold
+// the reservation until the work is done. Backpressure applies the same
+// check with BackpressureConfig.MemoryBudget.
+func Admission(b *MemoryBudget, est Estimator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := AdmitRequest(b, est, w, r)
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			misterrors.WriteHTTP(w, r, err)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdmitRequest reserves r's estimated memory cost from b and returns a
+// func that releases it, or ErrOverBudget. A body without a
+// Content-Length is capped at the estimator's Unknown size so it cannot
+// outgrow its reservation.
+func AdmitRequest(b *MemoryBudget, est Estimator, w http.ResponseWriter, r *http.Request) (release func(), err error) {
+	cost, maxBody := est.EstimateRequest(r)
+	if release, err = b.Admit(cost); err != nil {
+		return nil, err
+	}
+	if r.ContentLength < 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+	}
+	return release, nil
+}
@@ -0,0 +1,81 @@
+package resource
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestEstimator(t *testing.T) {
+	e := Estimator{Factor: 2, Overhead: 100}
+	if got := e.Estimate(1000); got != 2100 {
+		t.Errorf("Estimate = %d, want 2100", got)
+	}
+	if got := (Estimator{}).Estimate(10); got != 30 {
+		t.Errorf("zero Factor Estimate = %d, want 30", got)
+	}
+
+	msg, _ := protocol.New("test", protocol.TypeHealthPing, map[string]string{"k": "v"})
+	if got, want := e.EstimateMessage(msg), 100+2*int64(len(msg.Payload)); got != want {
+		t.Errorf("EstimateMessage = %d, want %d", got, want)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.ContentLength = -1
+	if cost, max := (Estimator{Factor: 1, Unknown: 500}).EstimateRequest(r); cost != 500 || max != 500 {
+		t.Errorf("unknown length: cost=%d max=%d", cost, max)
+	}
+}
+
+func TestMemoryBudgetAdmit(t *testing.T) {
+	b := NewMemoryBudget("ingest", 1000)
+	release, err := b.Admit(800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Admit(300); !misterrors.Is(err, ErrOverBudget) {
+		t.Fatalf("err = %v, want ErrOverBudget", err)
+	}
+	if b.Reserved() != 800 {
+		t.Errorf("failed Admit reserved bytes: %d", b.Reserved())
+	}
+	release()
+	if b.Reserved() != 0 {
+		t.Errorf("reserved after release = %d", b.Reserved())
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	b := NewMemoryBudget("ingest", 10_000)
+	var during int64
+	h := Admission(b, Estimator{Factor: 2}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		during = b.Reserved()
+		io.Copy(io.Discard, r.Body)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", 1000))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if during != 2000 {
+		t.Errorf("reserved during request = %d, want 2000", during)
+	}
+	if b.Reserved() != 0 {
+		t.Errorf("reserved after request = %d, want 0", b.Reserved())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", 6000))))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("oversized status = %d, want 503", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After")
+	}
+}
@@ -0,0 +1,257 @@
+package resource
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+)
+
+// ErrOverloaded is returned to requests a Backpressure refuses. It
+// carries CodeUnavailable, so errors.WriteHTTP renders it as 503.
+var ErrOverloaded = misterrors.New(misterrors.CodeUnavailable, "overloaded")
+
+// Backpressure defaults.
+const (
+	DefaultRetryAfter    = time.Second
+	DefaultMaxRetryAfter = 30 * time.Second
+)
+
+// heapSampleInterval bounds how often a Backpressure reads the heap
+// size, as runtime.ReadMemStats briefly stops the world.
+const heapSampleInterval = 100 * time.Millisecond
+
+// BackpressureConfig sets when a Backpressure refuses requests. A zero
+// threshold disables its check; with neither set, every request is
+// admitted.
+type BackpressureConfig struct {
+	// MaxInFlight is the queue depth, requests being handled at once,
+	// past which new ones are refused.
+	MaxInFlight int `toml:"max_in_flight"`
+
+	// MemoryLimit is the heap size in bytes past which requests are
+	// refused.
+	MemoryLimit int64 `toml:"memory_limit"`
+
+	// MemoryBudget reserves each request's estimated memory cost
+	// (DefaultEstimator over its body size) from a budget of this many
+	// bytes until the request is handled, refusing requests that don't
+	// fit. Unlike MemoryLimit, which reads the heap once it has grown,
+	// it refuses a large request before its body is read.
+	MemoryBudget int64 `toml:"memory_budget"`
+
+	// RetryAfter is the shortest Retry-After sent with a refusal
+	// (default 1s). It grows with saturation and with the time the
+	// queue takes to drain.
+	RetryAfter time.Duration `toml:"retry_after"`
+
+	// MaxRetryAfter caps the Retry-After sent (default 30s).
+	MaxRetryAfter time.Duration `toml:"max_retry_after"`
+}
+
+// Enabled reports whether any threshold is set.
+func (c BackpressureConfig) Enabled() bool {
+	return c.MaxInFlight > 0 || c.MemoryLimit > 0 || c.MemoryBudget > 0
+}
+
+// Validate checks the thresholds and retry bounds.
+func (c BackpressureConfig) Validate() error {
+	switch {
+	case c.MaxInFlight < 0:
+		return fmt.Errorf("max_in_flight must be >= 0 (got %d)", c.MaxInFlight)
+	case c.MemoryLimit < 0:
+		return fmt.Errorf("memory_limit must be >= 0 (got %d)", c.MemoryLimit)
+	case c.MemoryBudget < 0:
+		return fmt.Errorf("memory_budget must be >= 0 (got %d)", c.MemoryBudget)
+	case c.RetryAfter < 0:
+		return fmt.Errorf("retry_after must be >= 0 (got %s)", c.RetryAfter)
+	case c.MaxRetryAfter < 0:
+		return fmt.Errorf("max_retry_after must be >= 0 (got %s)", c.MaxRetryAfter)
+	case c.RetryAfter > 0 && c.MaxRetryAfter > 0 && c.RetryAfter > c.MaxRetryAfter:
+		return fmt.Errorf("retry_after (%s) must be <= max_retry_after (%s)", c.RetryAfter, c.MaxRetryAfter)
+	}
+	return nil
+}
+
+// Backpressure sheds load before a server falls over. It counts the
+// requests in flight and watches the heap, and once either passes its
+// threshold refuses new requests with a Retry-After that tells clients
+// how long to back off, so they retry later instead of timing out.
+//
+//	bp := resource.NewBackpressure("ingest", cfg, reg)
+//	mux.Handle("POST /mist", bp.Middleware(ingest))
+//
+// Saturation, the larger of in-flight over MaxInFlight and heap over
+// MemoryLimit, is exported as backpressure_saturation and refusals as
+// backpressure_rejected_total, by reason ("queue", "memory", or
+// "budget").
+type Backpressure struct {
+	name   string
+	cfg    BackpressureConfig
+	heap   func() int64
+	budget *MemoryBudget // nil without a MemoryBudget
+
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	latency   time.Duration // moving average of request durations
+	heapBytes int64
+	heapAt    time.Time
+
+	saturation *metrics.Gauge
+	reg        *metrics.Registry
+}
+
+// NewBackpressure creates a controller with the given thresholds,
+// recording its metrics in reg, labelled with name. reg may be nil.
+func NewBackpressure(name string, cfg BackpressureConfig, reg *metrics.Registry) *Backpressure {
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = DefaultRetryAfter
+	}
+	if cfg.MaxRetryAfter <= 0 {
+		cfg.MaxRetryAfter = max(DefaultMaxRetryAfter, cfg.RetryAfter)
+	}
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	b := &Backpressure{
+		name:       name,
+		cfg:        cfg,
+		heap:       HeapUsage,
+		reg:        reg,
+		saturation: reg.Gauge("backpressure_saturation", "name", name),
+	}
+	if cfg.MemoryBudget > 0 {
+		b.budget = NewMemoryBudget(name, cfg.MemoryBudget)
+	}
+	return b
+}
+
+// InFlight returns the number of admitted requests not yet released.
+func (b *Backpressure) InFlight() int64 { return b.inFlight.Load() }
+
+// Saturation returns how close the server is to its thresholds: 0 when
+// idle, 1 or more at or past one of them.
+func (b *Backpressure) Saturation() float64 {
+	return b.saturationAt(b.inFlight.Load(), b.heapUsage())
+}
+
+func (b *Backpressure) saturationAt(inFlight, heap int64) float64 {
+	var s float64
+	if b.cfg.MaxInFlight > 0 {
+		s = float64(inFlight) / float64(b.cfg.MaxInFlight)
+	}
+	if b.cfg.MemoryLimit > 0 {
+		s = max(s, float64(heap)/float64(b.cfg.MemoryLimit))
+	}
+	return s
+}
+
+// Admit admits a request and returns a func to call once it has been
+// handled. If the server is saturated it admits nothing and returns a
+// nil release and how long the client should wait before retrying.
+func (b *Backpressure) Admit() (release func(), retryAfter time.Duration) {
+	heap := b.heapUsage()
+	n := b.inFlight.Add(1)
+	reason := ""
+	switch {
+	case b.cfg.MaxInFlight > 0 && n > int64(b.cfg.MaxInFlight):
+		reason = "queue"
+	case b.cfg.MemoryLimit > 0 && heap >= b.cfg.MemoryLimit:
+		reason = "memory"
+	}
+	if reason != "" {
+		b.inFlight.Add(-1)
+		b.reg.Counter("backpressure_rejected_total", "name", b.name, "reason", reason).Inc()
+		b.saturation.Set(b.saturationAt(n-1, heap))
+		return nil, b.retryAfter(n, heap)
+	}
+	b.saturation.Set(b.saturationAt(n, heap))
+
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.observe(time.Since(start))
+			b.saturation.Set(b.saturationAt(b.inFlight.Add(-1), b.heapUsage()))
+		})
+	}, 0
+}
+
+// retryAfter estimates how long a client refused with n requests in
+// flight should wait: the base RetryAfter scaled by saturation, or the
+// time the requests over the limit take to finish at the recent average
+// latency, whichever is longer.
+func (b *Backpressure) retryAfter(n, heap int64) time.Duration {
+	wait := time.Duration(float64(b.cfg.RetryAfter) * b.saturationAt(n, heap))
+	if b.cfg.MaxInFlight > 0 && n > int64(b.cfg.MaxInFlight) {
+		b.mu.Lock()
+		latency := b.latency
+		b.mu.Unlock()
+		excess := n - int64(b.cfg.MaxInFlight)
+		wait = max(wait, time.Duration(excess)*latency/time.Duration(b.cfg.MaxInFlight))
+	}
+	return min(max(wait, b.cfg.RetryAfter), b.cfg.MaxRetryAfter)
+}
+
+// observe folds a request's duration into the moving average.
+func (b *Backpressure) observe(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.latency == 0 {
+		b.latency = d
+		return
+	}
+	b.latency += (d - b.latency) / 5
+}
+
+// heapUsage returns the heap size, read at most every
+// heapSampleInterval. It is 0 without a MemoryLimit.
+func (b *Backpressure) heapUsage() int64 {
+	if b.cfg.MemoryLimit <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now := time.Now(); now.Sub(b.heapAt) >= heapSampleInterval {
+		b.heapBytes, b.heapAt = b.heap(), now
+	}
+	return b.heapBytes
+}
+
+// Middleware wraps next so requests are admitted by b first, and with a
+// MemoryBudget hold their reservation until next returns. Refused
+// requests get 503 with a Retry-After header in whole seconds.
+func (b *Backpressure) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, wait := b.Admit()
+		if release == nil {
+			b.refuse(w, r, wait, ErrOverloaded)
+			return
+		}
+		defer release()
+		if b.budget != nil {
+			unreserve, err := AdmitRequest(b.budget, DefaultEstimator, w, r)
+			if err != nil {
+				b.reg.Counter("backpressure_rejected_total", "name", b.name, "reason", "budget").Inc()
+				b.refuse(w, r, b.cfg.RetryAfter, err)
+				return
+			}
+			defer unreserve()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refuse writes a 503 for err with a Retry-After of wait, in whole
+// seconds.
+func (b *Backpressure) refuse(w http.ResponseWriter, r *http.Request, wait time.Duration, err error) {
+	secs := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	misterrors.WriteHTTP(w, r, misterrors.Wrapf(misterrors.CodeUnavailable, err, "%s: retry in %ds", b.name, secs))
+}
@@ -0,0 +1,157 @@
+package resource
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+)
+
+func TestBackpressureQueue(t *testing.T) {
+	reg := metrics.NewRegistry()
+	b := NewBackpressure("ingest", BackpressureConfig{MaxInFlight: 2}, reg)
+
+	r1, _ := b.Admit()
+	r2, _ := b.Admit()
+	if r1 == nil || r2 == nil {
+		t.Fatal("requests under the limit were refused")
+	}
+	if s := b.Saturation(); s != 1 {
+		t.Errorf("Saturation = %v at the limit, want 1", s)
+	}
+
+	// With no latency observed yet the base RetryAfter applies, scaled
+	// by saturation.
+	release, wait := b.Admit()
+	if release != nil {
+		t.Fatal("request over the limit was admitted")
+	}
+	if wait != 1500*time.Millisecond {
+		t.Errorf("retryAfter = %s, want 1.5s", wait)
+	}
+	if n := reg.Counter("backpressure_rejected_total", "name", "ingest", "reason", "queue").Value(); n != 1 {
+		t.Errorf("backpressure_rejected_total = %d, want 1", n)
+	}
+
+	// Slow requests lengthen the wait: the excess takes latency/max each
+	// to drain.
+	b.observe(time.Minute)
+	if _, wait := b.Admit(); wait != DefaultMaxRetryAfter {
+		t.Errorf("retryAfter with 1m latency = %s, want the 30s cap", wait)
+	}
+
+	r1()
+	r1() // releasing twice is harmless
+	if b.InFlight() != 1 {
+		t.Errorf("InFlight = %d after a release, want 1", b.InFlight())
+	}
+	if release, _ := b.Admit(); release == nil {
+		t.Error("request refused after room was released")
+	}
+	if g := reg.Gauge("backpressure_saturation", "name", "ingest").Value(); g != 1 {
+		t.Errorf("backpressure_saturation = %v, want 1", g)
+	}
+}
+
+func TestBackpressureMemory(t *testing.T) {
+	b := NewBackpressure("ingest", BackpressureConfig{MemoryLimit: 1000, RetryAfter: 2 * time.Second}, nil)
+	heap := int64(500)
+	b.heap = func() int64 { return heap }
+
+	if release, _ := b.Admit(); release == nil {
+		t.Fatal("request under the memory limit was refused")
+	}
+	heap = 2000
+	b.heapAt = time.Time{} // skip the sampling interval
+	release, wait := b.Admit()
+	if release != nil {
+		t.Fatal("request over the memory limit was admitted")
+	}
+	if wait != 4*time.Second {
+		t.Errorf("retryAfter = %s, want 4s at saturation 2", wait)
+	}
+}
+
+func TestBackpressureMiddleware(t *testing.T) {
+	b := NewBackpressure("ingest", BackpressureConfig{MaxInFlight: 1}, nil)
+	hold := make(chan struct{})
+	started := make(chan struct{})
+	h := b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-hold
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/mist", nil))
+		done <- w.Code
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/mist", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("saturated: status %d, Retry-After %q; want 503 and 2", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	close(hold)
+	if code := <-done; code != http.StatusAccepted {
+		t.Errorf("admitted request: status %d", code)
+	}
+	if b.InFlight() != 0 {
+		t.Errorf("InFlight = %d after the request finished", b.InFlight())
+	}
+}
+
+func TestBackpressureMemoryBudget(t *testing.T) {
+	reg := metrics.NewRegistry()
+	b := NewBackpressure("ingest", BackpressureConfig{MemoryBudget: 64 << 10}, reg)
+	post := func(size int) *http.Request {
+		return httptest.NewRequest("POST", "/mist", strings.NewReader(strings.Repeat("x", size)))
+	}
+	var reserved int64
+	h := b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reserved = b.budget.Reserved()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, post(1<<10))
+	if w.Code != http.StatusAccepted || reserved != DefaultEstimator.Estimate(1<<10) {
+		t.Errorf("small request: status %d, %d bytes reserved while handled", w.Code, reserved)
+	}
+	if n := b.budget.Reserved(); n != 0 {
+		t.Errorf("%d bytes still reserved after the handler returned", n)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, post(32<<10))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("request over budget: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if n := reg.Counter("backpressure_rejected_total", "name", "ingest", "reason", "budget").Value(); n != 1 {
+		t.Errorf("budget rejections = %d, want 1", n)
+	}
+}
+
+func TestBackpressureConfigValidate(t *testing.T) {
+	for _, cfg := range []BackpressureConfig{
+		{MaxInFlight: -1},
+		{MemoryLimit: -1},
+		{MemoryBudget: -1},
+		{RetryAfter: -time.Second},
+		{RetryAfter: time.Minute, MaxRetryAfter: time.Second},
+	} {
+		if cfg.Validate() == nil {
+			t.Errorf("Validate(%+v) = nil", cfg)
+		}
+	}
+	if err := (BackpressureConfig{MaxInFlight: 10, RetryAfter: time.Second}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,226 @@
+package resource
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/platform"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted on Linux, and
+// selfCgroup where the kernel lists the process's cgroups under it.
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	selfCgroup = "/proc/self/cgroup"
+)
+
+// EffectiveCPUs returns the number of CPUs this process can actually use:
+// the container CPU quota (cgroup v2 cpu.max or v1 cfs_quota_us) rounded
+// up, capped at runtime.NumCPU, and never less than 1. The quota is the
+// tightest set on the process's own cgroup or any cgroup above it.
+// Without a quota it is runtime.NumCPU. The result is computed once.
+//
+// Use it instead of runtime.NumCPU to size worker pools; a container with
+// a 1 CPU quota on a 64-core host should run one worker, not 64.
+func EffectiveCPUs() int {
+	return effectiveCPUs()
+}
+
+var effectiveCPUs = sync.OnceValue(func() int {
+	self, _ := os.ReadFile(selfCgroup)
+	return cpusFor(cgroupRoot, string(self), runtime.NumCPU())
+})
+
+// cpusFor computes the effective CPU count from the cgroup tree at root
+// for a process whose /proc/self/cgroup reads self.
+func cpusFor(root, self string, numCPU int) int {
+	quota, ok := cpuQuota(root, self)
+	if !ok {
+		return numCPU
+	}
+	n := int(math.Ceil(quota))
+	if n > numCPU {
+		n = numCPU
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// cgroupPaths parses /proc/self/cgroup into the process's cgroup v2 path
+// and its cgroup v1 cpu controller path, each "/" if not listed.
+func cgroupPaths(self string) (v2, v1 string) {
+	v2, v1 = "/", "/"
+	for _, line := range strings.Split(self, "\n") {
+		// "<id>:<controllers>:<path>"; v2 is "0::<path>".
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			v2 = parts[2]
+		case slices.Contains(strings.Split(parts[1], ","), "cpu"):
+			v1 = parts[2]
+		}
+	}
+	return v2, v1
+}
+
+// ancestors returns the cgroup path and each of its parents up to "/".
+func ancestors(path string) []string {
+	var dirs []string
+	for p := filepath.Clean("/" + path); ; p = filepath.Dir(p) {
+		dirs = append(dirs, p)
+		if p == "/" {
+			return dirs
+		}
+	}
+}
+
+// cpuQuota reads the CPU quota, in cores, from the cgroup tree at root:
+// the smallest set on the process's cgroup (see cgroupPaths) or its
+// ancestors. A process whose cgroup isn't under root, as in a container
+// without a cgroup namespace, gets root's own quota. It reports false if
+// no quota is set or none can be read.
+func cpuQuota(root, self string) (float64, bool) {
+	v2, v1 := cgroupPaths(self)
+	var (
+		quota          float64
+		limited, found bool
+	)
+	tighten := func(q float64, ok bool) {
+		if ok && (!limited || q < quota) {
+			quota, limited = q, true
+		}
+	}
+
+	// cgroup v2: "<quota> <period>" or "max <period>".
+	for _, dir := range ancestors(v2) {
+		data, err := os.ReadFile(filepath.Join(root, dir, "cpu.max"))
+		if err != nil {
+			continue
+		}
+		found = true
+		if fields := strings.Fields(string(data)); len(fields) == 2 && fields[0] != "max" {
+			tighten(quotaRatio(fields[0], fields[1]))
+		}
+	}
+	if found {
+		return quota, limited
+	}
+
+	// cgroup v1: separate quota and period files; quota -1 is unlimited.
+	for _, ctrl := range []string{"cpu", "cpu,cpuacct"} {
+		for _, dir := range ancestors(v1) {
+			q, err := os.ReadFile(filepath.Join(root, ctrl, dir, "cpu.cfs_quota_us"))
+			if err != nil {
+				continue
+			}
+			p, err := os.ReadFile(filepath.Join(root, ctrl, dir, "cpu.cfs_period_us"))
+			if err != nil {
+				continue
+			}
+			found = true
+			tighten(quotaRatio(strings.TrimSpace(string(q)), strings.TrimSpace(string(p))))
+		}
+		if found {
+			return quota, limited
+		}
+	}
+	return 0, false
+}
+
+func quotaRatio(quota, period string) (float64, bool) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return float64(q) / float64(p), true
+}
+
+// SetMaxProcs sets GOMAXPROCS to EffectiveCPUs and returns the value in
+// effect. An explicit GOMAXPROCS environment variable takes precedence
+// and is left untouched. Call it early in main.
+func SetMaxProcs() int {
+	if os.Getenv("GOMAXPROCS") != "" {
+		return runtime.GOMAXPROCS(0)
+	}
+	n := EffectiveCPUs()
+	runtime.GOMAXPROCS(n)
+	return n
+}
+
+// CPUSampler measures the process's CPU usage over time. Each Sample
+// reports the average number of cores used since the previous one.
+type CPUSampler struct {
+	mu       sync.Mutex
+	lastCPU  time.Duration
+	lastWall time.Time
+	usage    float64
+}
+
+// NewCPUSampler creates a sampler whose first interval starts now.
+func NewCPUSampler() *CPUSampler {
+	cpu, _ := platform.ProcessCPUTime()
+	return &CPUSampler{lastCPU: cpu, lastWall: time.Now()}
+}
+
+// Sample records the CPU time used since the last sample and returns it
+// as a number of cores (1.5 means one and a half cores were busy).
+func (s *CPUSampler) Sample() float64 {
+	cpu, err := platform.ProcessCPUTime()
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		return s.usage
+	}
+	if wall := now.Sub(s.lastWall); wall > 0 {
+		s.usage = float64(cpu-s.lastCPU) / float64(wall)
+	}
+	s.lastCPU, s.lastWall = cpu, now
+	return s.usage
+}
+
+// Usage returns the cores used as of the most recent Sample.
+func (s *CPUSampler) Usage() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage
+}
+
+// Millicores returns Usage in thousandths of a core.
+func (s *CPUSampler) Millicores() int64 {
+	return int64(s.Usage() * 1000)
+}
+
+// Start samples every interval until ctx is cancelled.
+func (s *CPUSampler) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Sample()
+			}
+		}
+	}()
+}
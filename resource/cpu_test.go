@@ -0,0 +1,124 @@
+package resource
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func writeCgroup(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCPUsForCgroupV2(t *testing.T) {
+	tests := []struct {
+		cpuMax string
+		want   int
+	}{
+		{"100000 100000\n", 1},
+		{"150000 100000\n", 2},
+		{"50000 100000\n", 1},
+		{"max 100000\n", 64},
+		{"6400000 100000\n", 64},
+	}
+	for _, tt := range tests {
+		root := writeCgroup(t, map[string]string{"cpu.max": tt.cpuMax})
+		if got := cpusFor(root, "", 64); got != tt.want {
+			t.Errorf("cpu.max %q: got %d, want %d", tt.cpuMax, got, tt.want)
+		}
+	}
+}
+
+func TestCPUsForCgroupV1(t *testing.T) {
+	root := writeCgroup(t, map[string]string{
+		"cpu,cpuacct/cpu.cfs_quota_us":  "200000\n",
+		"cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+	})
+	if got := cpusFor(root, "", 64); got != 2 {
+		t.Errorf("got %d, want 2", got)
+	}
+
+	unlimited := writeCgroup(t, map[string]string{
+		"cpu/cpu.cfs_quota_us":  "-1\n",
+		"cpu/cpu.cfs_period_us": "100000\n",
+	})
+	if got := cpusFor(unlimited, "", 8); got != 8 {
+		t.Errorf("unlimited: got %d, want 8", got)
+	}
+}
+
+func TestCPUsForNestedCgroup(t *testing.T) {
+	root := writeCgroup(t, map[string]string{
+		"kubepods/cpu.max":          "max 100000\n",
+		"kubepods/pod1/cpu.max":     "300000 100000\n",
+		"kubepods/pod1/app/cpu.max": "max 100000\n",
+		"other/cpu.max":             "100000 100000\n",
+	})
+	if got := cpusFor(root, "0::/kubepods/pod1/app\n", 64); got != 3 {
+		t.Errorf("v2 leaf: got %d, want the pod's 3", got)
+	}
+	if got := cpusFor(root, "0::/kubepods\n", 64); got != 64 {
+		t.Errorf("v2 unlimited: got %d, want 64", got)
+	}
+
+	v1 := writeCgroup(t, map[string]string{
+		"cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  "250000\n",
+		"cpu,cpuacct/docker/abc/cpu.cfs_period_us": "100000\n",
+		"cpu,cpuacct/docker/cpu.cfs_quota_us":      "400000\n",
+		"cpu,cpuacct/docker/cpu.cfs_period_us":     "100000\n",
+	})
+	self := "12:memory:/docker/abc\n3:cpu,cpuacct:/docker/abc\n0::/\n"
+	if got := cpusFor(v1, self, 64); got != 3 {
+		t.Errorf("v1: got %d, want 3", got)
+	}
+
+	// A cgroup path not under root falls back to root's own quota.
+	ns := writeCgroup(t, map[string]string{"cpu.max": "200000 100000\n"})
+	if got := cpusFor(ns, "0::/system.slice/host.scope\n", 64); got != 2 {
+		t.Errorf("unmounted path: got %d, want 2", got)
+	}
+}
+
+func TestCPUsForNoCgroup(t *testing.T) {
+	if got := cpusFor(t.TempDir(), "", 4); got != 4 {
+		t.Errorf("got %d, want 4", got)
+	}
+}
+
+func TestEffectiveCPUs(t *testing.T) {
+	n := EffectiveCPUs()
+	if n < 1 || n > runtime.NumCPU() {
+		t.Errorf("EffectiveCPUs = %d, NumCPU = %d", n, runtime.NumCPU())
+	}
+}
+
+func TestCPUSampler(t *testing.T) {
+	s := NewCPUSampler()
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+	if got := s.Sample(); got <= 0 {
+		t.Errorf("Sample after busy loop = %v, want > 0", got)
+	}
+
+	m := NewMonitor()
+	m.TrackCPU(s)
+	st, ok := m.Status()["cpu"]
+	if !ok {
+		t.Fatal("cpu missing from status")
+	}
+	if st.Max != int64(EffectiveCPUs())*1000 || st.Active != s.Millicores() {
+		t.Errorf("cpu status = %+v", st)
+	}
+}
@@ -171,17 +171,19 @@ func GoroutineCount() int {
 
 // Snapshot captures current resource usage.
 type Snapshot struct {
-	HeapBytes  int64 `json:"heap_bytes"`
-	Goroutines int   `json:"goroutines"`
-	NumCPU     int   `json:"num_cpu"`
+	HeapBytes     int64 `json:"heap_bytes"`
+	Goroutines    int   `json:"goroutines"`
+	NumCPU        int   `json:"num_cpu"`
+	EffectiveCPUs int   `json:"effective_cpus"`
 }
 
 // TakeSnapshot captures the current resource state.
 func TakeSnapshot() Snapshot {
 	return Snapshot{
-		HeapBytes:  HeapUsage(),
-		Goroutines: GoroutineCount(),
-		NumCPU:     runtime.NumCPU(),
+		HeapBytes:     HeapUsage(),
+		Goroutines:    GoroutineCount(),
+		NumCPU:        runtime.NumCPU(),
+		EffectiveCPUs: EffectiveCPUs(),
 	}
 }
 
@@ -191,6 +193,7 @@ type Monitor struct {
 	mu       sync.RWMutex
 	limiters []*Limiter
 	budgets  []*MemoryBudget
+	cpu      *CPUSampler
 }
 
 // NewMonitor creates a resource monitor.
@@ -212,6 +215,15 @@ func (m *Monitor) TrackBudget(b *MemoryBudget) {
 	m.budgets = append(m.budgets, b)
 }
 
+// TrackCPU reports s under the "cpu" key, in millicores against a max
+// of EffectiveCPUs*1000. The sampler must be sampled elsewhere, for
+// example with Start.
+func (m *Monitor) TrackCPU(s *CPUSampler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cpu = s
+}
+
 // Status returns a map of resource names to their current usage.
 func (m *Monitor) Status() map[string]ResourceStatus {
 	m.mu.RLock()
@@ -231,6 +243,12 @@ func (m *Monitor) Status() map[string]ResourceStatus {
 			Max:    b.Limit(),
 		}
 	}
+	if m.cpu != nil {
+		status["cpu"] = ResourceStatus{
+			Active: m.cpu.Millicores(),
+			Max:    int64(EffectiveCPUs()) * 1000,
+		}
+	}
 	return status
 }
 
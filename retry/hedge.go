@@ -0,0 +1,93 @@
+package retry
+
+import (
+	"context"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// Hedged runs fn and, if it has not returned within delay, launches
+// another concurrent attempt, and so on up to max attempts in flight or
+// finished. The first success wins and the ctx of every other attempt is
+// cancelled. Use it to cut tail latency on idempotent calls such as
+// provider requests:
+//
+//	resp, err := retry.Hedged(ctx, 300*time.Millisecond, 3, func(ctx context.Context) (*Response, error) {
+//	    return provider.Infer(ctx, req)
+//	})
+//
+// A failed attempt launches its replacement immediately. Errors the MIST
+// errors package marks as permanent (validation, auth, ...) are returned
+// at once. If every attempt fails, the last error is returned.
+func Hedged[T any](ctx context.Context, delay time.Duration, max int, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if max < 1 {
+		max = 1
+	}
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		val T
+		err error
+	}
+	// Buffered so losing attempts never block after we return.
+	results := make(chan outcome, max)
+	launched, pending := 0, 0
+	launch := func() {
+		launched++
+		pending++
+		go func() {
+			v, err := fn(ctx)
+			results <- outcome{v, err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.val, nil
+			}
+			lastErr = r.err
+			if !misterrors.IsRetryable(r.err) {
+				return zero, r.err
+			}
+			if launched < max {
+				launch()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay)
+			} else if pending == 0 {
+				return zero, lastErr
+			}
+
+		case <-timer.C:
+			if launched < max {
+				launch()
+				timer.Reset(delay)
+			}
+
+		case <-ctx.Done():
+			if lastErr != nil {
+				return zero, lastErr
+			}
+			return zero, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+func TestHedgedFastPath(t *testing.T) {
+	var calls atomic.Int32
+	v, err := Hedged(context.Background(), 50*time.Millisecond, 3, func(context.Context) (string, error) {
+		calls.Add(1)
+		return "ok", nil
+	})
+	if err != nil || v != "ok" {
+		t.Fatalf("Hedged = %q, %v", v, err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestHedgedSecondAttemptWins(t *testing.T) {
+	var calls atomic.Int32
+	loserCancelled := make(chan struct{})
+
+	start := time.Now()
+	v, err := Hedged(context.Background(), 20*time.Millisecond, 3, func(ctx context.Context) (int, error) {
+		n := calls.Add(1)
+		if n == 1 {
+			// Slow first attempt; it should be cancelled once the hedge wins.
+			<-ctx.Done()
+			close(loserCancelled)
+			return 0, ctx.Err()
+		}
+		return int(n), nil
+	})
+	if err != nil || v != 2 {
+		t.Fatalf("Hedged = %d, %v; want 2 from the hedge", v, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, hedge did not cut latency", elapsed)
+	}
+
+	select {
+	case <-loserCancelled:
+	case <-time.After(time.Second):
+		t.Error("losing attempt was not cancelled")
+	}
+}
+
+func TestHedgedAllFail(t *testing.T) {
+	var calls atomic.Int32
+	_, err := Hedged(context.Background(), time.Hour, 3, func(context.Context) (int, error) {
+		calls.Add(1)
+		return 0, errors.New("flaky")
+	})
+	if err == nil || err.Error() != "flaky" {
+		t.Fatalf("err = %v", err)
+	}
+	// Failures launch replacements immediately, without waiting for delay.
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestHedgedPermanentError(t *testing.T) {
+	var calls atomic.Int32
+	_, err := Hedged(context.Background(), time.Hour, 3, func(context.Context) (int, error) {
+		calls.Add(1)
+		return 0, misterrors.New(misterrors.CodeValidation, "bad request")
+	})
+	if misterrors.Code(err) != misterrors.CodeValidation {
+		t.Fatalf("err = %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1 for permanent error", calls.Load())
+	}
+}
+
+func TestHedgedContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	_, err := Hedged(ctx, 10*time.Millisecond, 2, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+}
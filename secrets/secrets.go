@@ -0,0 +1,400 @@
+// Package secrets loads encryption keys for MIST tools and seals data at
+// rest with AES-256-GCM. It is used by the file transport and checkpoint
+// logs to keep prompts and results on shared disks unreadable without
+// the key.
+//
+// Keys are 32 random bytes, written as base64 and loaded by reference:
+//
+//	key, err := secrets.LoadKey("env:MIST_DATA_KEY")
+//	ring := secrets.NewKeyring(key)
+//	line, err := ring.SealLine([]byte(`{"step":"download"}`))
+//
+// A Keyring holds one primary key, used to seal, and any number of old
+// keys that can still open data sealed before a rotation. RekeyFile
+// rewrites a file under the primary key.
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// KeySize is the length of a key in bytes (AES-256).
+const KeySize = 32
+
+var (
+	// ErrUnknownKey is returned when sealed data names a key the Keyring
+	// does not hold.
+	ErrUnknownKey = misterrors.New(misterrors.CodeAuth, "secrets: unknown key").Permanent()
+
+	// ErrDecrypt is returned when sealed data is malformed, or fails
+	// authentication because it was tampered with, moved, or the key is
+	// wrong.
+	ErrDecrypt = misterrors.New(misterrors.CodeAuth, "secrets: decryption failed").Permanent()
+
+	// ErrPlaintext is returned by a Keyring's NewReader for a line that
+	// is not sealed, which anyone able to write the file could have
+	// added. See Keyring.AllowPlaintext.
+	ErrPlaintext = misterrors.New(misterrors.CodeAuth, "secrets: plaintext line in an encrypted file").Permanent()
+)
+
+// Key is an AES-256 key. Its ID, the first 4 bytes of the SHA-256 of the
+// key in hex, is stored with sealed data so the right key can be found
+// after a rotation without revealing the key.
+type Key struct {
+	id   string
+	aead cipher.AEAD
+	raw  []byte
+}
+
+// NewKey creates a key from KeySize bytes of key material.
+func NewKey(material []byte) (*Key, error) {
+	if len(material) != KeySize {
+		return nil, misterrors.Newf(misterrors.CodeValidation, "secrets: key must be %d bytes, got %d", KeySize, len(material))
+	}
+	block, err := aes.NewCipher(material)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	sum := sha256.Sum256(material)
+	return &Key{id: hex.EncodeToString(sum[:4]), aead: aead, raw: bytes.Clone(material)}, nil
+}
+
+// GenerateKey returns a new random key.
+func GenerateKey() (*Key, error) {
+	material := make([]byte, KeySize)
+	if _, err := rand.Read(material); err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	return NewKey(material)
+}
+
+// ParseKey decodes a base64 key, as written by Key.String.
+func ParseKey(s string) (*Key, error) {
+	material, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, misterrors.Wrap(misterrors.CodeValidation, err, "secrets: key is not valid base64")
+	}
+	return NewKey(material)
+}
+
+// LoadKey loads a base64 key by reference: "env:NAME" reads the
+// environment variable NAME, and "file:PATH" the file at PATH. Keeping
+// the reference, not the key, in config files and URLs keeps the key out
+// of them.
+func LoadKey(ref string) (*Key, error) {
+	kind, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return nil, misterrors.Newf(misterrors.CodeValidation, "secrets: key reference %q: want env:NAME or file:PATH", ref)
+	}
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, misterrors.Newf(misterrors.CodeNotFound, "secrets: environment variable %s is not set", name)
+		}
+		return ParseKey(v)
+	case "file":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("secrets: %w", err)
+		}
+		return ParseKey(string(data))
+	default:
+		return nil, misterrors.Newf(misterrors.CodeValidation, "secrets: key reference %q: want env:NAME or file:PATH", ref)
+	}
+}
+
+// ID returns the key's identifier.
+func (k *Key) ID() string { return k.id }
+
+// String returns the key as base64, for storing it in a secret store.
+func (k *Key) String() string { return base64.StdEncoding.EncodeToString(k.raw) }
+
+// Keyring seals with its primary key and opens with any key it holds.
+// It is safe for concurrent use.
+type Keyring struct {
+	primary   *Key
+	keys      map[string]*Key
+	plaintext bool // see AllowPlaintext
+}
+
+// NewKeyring creates a keyring that seals with primary and can also open
+// data sealed with any of old.
+func NewKeyring(primary *Key, old ...*Key) *Keyring {
+	r := &Keyring{primary: primary, keys: map[string]*Key{primary.id: primary}}
+	for _, k := range old {
+		if _, ok := r.keys[k.id]; !ok {
+			r.keys[k.id] = k
+		}
+	}
+	return r
+}
+
+// LoadKeyring loads a keyring by reference, as LoadKey: the primary key
+// from ref and old keys from oldRefs. Empty oldRefs are ignored, so an
+// unset --old-key flag can be passed as is.
+func LoadKeyring(ref string, oldRefs ...string) (*Keyring, error) {
+	primary, err := LoadKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	var old []*Key
+	for _, r := range oldRefs {
+		if r == "" {
+			continue
+		}
+		k, err := LoadKey(r)
+		if err != nil {
+			return nil, err
+		}
+		old = append(old, k)
+	}
+	return NewKeyring(primary, old...), nil
+}
+
+// Primary returns the key the keyring seals with.
+func (r *Keyring) Primary() *Key { return r.primary }
+
+// linePrefix starts every sealed line, followed by the key ID, a colon,
+// and base64 of the nonce and ciphertext. JSON lines never start with it,
+// so sealed and plaintext lines can be told apart.
+const linePrefix = "mistenc1:"
+
+// SealLine encrypts p, which may contain newlines, into a single line of
+// text without a trailing newline, for data stored on its own such as a
+// checkpoint artifact. Lines of a file read with NewReader are sealed
+// with SealLineAt.
+func (r *Keyring) SealLine(p []byte) ([]byte, error) {
+	return r.seal(p, []byte(r.primary.id))
+}
+
+// SealLineAt is SealLine for line n, counting from 0, of a file read with
+// NewReader. The line number is authenticated along with p, so a sealed
+// line moved, copied, or removed from before the end of the file fails
+// to open. NewReader yields p exactly, so a writer sealing JSON lines
+// includes their newlines in p.
+func (r *Keyring) SealLineAt(p []byte, n int64) ([]byte, error) {
+	return r.seal(p, lineAAD(r.primary.id, n))
+}
+
+// lineAAD returns the additional data line n of a file is sealed with.
+// It differs from a lone line's, the key ID alone, for every n.
+func lineAAD(id string, n int64) []byte {
+	return strconv.AppendInt([]byte(id+":"), n, 10)
+}
+
+func (r *Keyring) seal(p, aad []byte) ([]byte, error) {
+	k := r.primary
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(p)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	sealed := k.aead.Seal(nonce, nonce, p, aad)
+
+	line := make([]byte, 0, len(linePrefix)+len(k.id)+1+base64.StdEncoding.EncodedLen(len(sealed)))
+	line = append(line, linePrefix...)
+	line = append(line, k.id...)
+	line = append(line, ':')
+	return base64.StdEncoding.AppendEncode(line, sealed), nil
+}
+
+// IsSealed reports whether line was produced by SealLine or SealLineAt.
+func IsSealed(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(linePrefix))
+}
+
+// OpenLine decrypts a line produced by SealLine with any key in r. A
+// trailing newline is ignored.
+func (r *Keyring) OpenLine(line []byte) ([]byte, error) {
+	return r.open(line, func(id string) []byte { return []byte(id) })
+}
+
+// OpenLineAt decrypts line n of a file, produced by SealLineAt with any
+// key in r. A trailing newline is ignored.
+func (r *Keyring) OpenLineAt(line []byte, n int64) ([]byte, error) {
+	return r.open(line, func(id string) []byte { return lineAAD(id, n) })
+}
+
+func (r *Keyring) open(line []byte, aad func(id string) []byte) ([]byte, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	rest, ok := bytes.CutPrefix(line, []byte(linePrefix))
+	if !ok {
+		return nil, misterrors.Wrap(misterrors.CodeAuth, ErrDecrypt, "secrets: line is not sealed")
+	}
+	id, data, ok := bytes.Cut(rest, []byte(":"))
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	k, ok := r.keys[string(id)]
+	if !ok {
+		return nil, misterrors.Wrapf(misterrors.CodeAuth, ErrUnknownKey, "secrets: key %s", id)
+	}
+	sealed, err := base64.StdEncoding.AppendDecode(nil, data)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	p, err := k.aead.Open(nil, nonce, ciphertext, aad(k.id))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return p, nil
+}
+
+// AllowPlaintext returns a copy of r whose NewReader passes plaintext
+// lines through unchanged rather than failing with ErrPlaintext. It is
+// for reading a file written before encryption was turned on, until
+// RekeyFile has sealed it: anyone who can write to the file can add
+// plaintext lines, and they are not authenticated.
+func (r *Keyring) AllowPlaintext() *Keyring {
+	cp := *r
+	cp.plaintext = true
+	return &cp
+}
+
+// NewReader returns a reader of the plaintext of src, a stream of lines
+// written by SealLineAt: each sealed line is opened at its line number
+// and its plaintext emitted in turn. A plaintext line fails with
+// ErrPlaintext, unless r is from AllowPlaintext. A final line cut short
+// by a writer still appending is treated as the end of the stream.
+func (r *Keyring) NewReader(src io.Reader) io.Reader {
+	return &lineReader{ring: r, src: bufio.NewReader(src)}
+}
+
+type lineReader struct {
+	ring *Keyring
+	src  *bufio.Reader
+	n    int64 // number of the next line
+	buf  []byte
+	err  error
+}
+
+func (lr *lineReader) Read(p []byte) (int, error) {
+	for len(lr.buf) == 0 {
+		if lr.err != nil {
+			return 0, lr.err
+		}
+		line, err := lr.src.ReadBytes('\n')
+		if err != nil {
+			// A line without its newline is still being written.
+			if errors.Is(err, io.EOF) {
+				lr.err = io.EOF
+				if !IsSealed(line) && lr.ring.plaintext {
+					lr.buf = line
+				}
+				continue
+			}
+			lr.err = err
+			continue
+		}
+		n := lr.n
+		lr.n++
+		if !IsSealed(line) {
+			if !lr.ring.plaintext {
+				lr.err = misterrors.Wrapf(misterrors.CodeAuth, ErrPlaintext, "secrets: line %d", n+1)
+				continue
+			}
+			lr.buf = line
+			continue
+		}
+		if lr.buf, lr.err = lr.ring.OpenLineAt(line, n); lr.err != nil {
+			lr.buf = nil
+		}
+	}
+	n := copy(p, lr.buf)
+	lr.buf = lr.buf[n:]
+	return n, nil
+}
+
+// RekeyFile rewrites the line file at path so every line is sealed with
+// the primary key of ring: sealed lines are opened with whichever key
+// sealed them, and plaintext lines are sealed as they are, so RekeyFile
+// also encrypts an existing JSON lines file, whether or not ring allows
+// plaintext. The file is rewritten to a
+// temporary file and renamed into place, and must not be written to
+// meanwhile. It returns the number of lines rewritten.
+func RekeyFile(path string, ring *Keyring) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("secrets: rekey: %w", err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("secrets: rekey: %w", err)
+	}
+
+	tmp := path + ".rekey"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return 0, fmt.Errorf("secrets: rekey: %w", err)
+	}
+	fail := func(err error) (int, error) {
+		out.Close()
+		os.Remove(tmp)
+		return 0, fmt.Errorf("secrets: rekey %s: %w", path, err)
+	}
+
+	w := bufio.NewWriter(out)
+	src := bufio.NewReader(in)
+	var n int64
+	for {
+		line, err := src.ReadBytes('\n')
+		if len(line) > 0 {
+			plain := line
+			if IsSealed(line) {
+				var oerr error
+				if plain, oerr = ring.OpenLineAt(line, n); oerr != nil {
+					return fail(oerr)
+				}
+			}
+			sealed, serr := ring.SealLineAt(plain, n)
+			if serr != nil {
+				return fail(serr)
+			}
+			w.Write(sealed)
+			w.WriteByte('\n')
+			n++
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := out.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("secrets: rekey %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("secrets: rekey %s: %w", path, err)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,202 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+func mustKey(t *testing.T) *Key {
+	t.Helper()
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpenLine(t *testing.T) {
+	ring := NewKeyring(mustKey(t))
+	plain := []byte("{\"a\":1}\n{\"b\":2}\n")
+
+	line, err := ring.SealLine(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(line, []byte("\n")) || bytes.Contains(line, []byte(`"a"`)) {
+		t.Fatalf("sealed line leaks plaintext or newlines: %q", line)
+	}
+	if !IsSealed(line) {
+		t.Error("IsSealed = false")
+	}
+
+	got, err := ring.OpenLine(append(line, '\n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("OpenLine = %q, want %q", got, plain)
+	}
+}
+
+func TestOpenLineErrors(t *testing.T) {
+	ring := NewKeyring(mustKey(t))
+	line, _ := ring.SealLine([]byte("secret"))
+
+	other := NewKeyring(mustKey(t))
+	if _, err := other.OpenLine(line); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("other key: err = %v, want ErrUnknownKey", err)
+	}
+
+	tampered := bytes.Clone(line)
+	tampered[len(tampered)-3] ^= 0x01
+	if _, err := ring.OpenLine(tampered); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("tampered: err = %v, want ErrDecrypt", err)
+	}
+	if _, err := ring.OpenLine([]byte(`{"plain":true}`)); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("plaintext: err = %v, want ErrDecrypt", err)
+	}
+	if misterrors.IsRetryable(ErrDecrypt) {
+		t.Error("ErrDecrypt should not be retryable")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKey := mustKey(t), mustKey(t)
+	line, _ := NewKeyring(oldKey).SealLine([]byte("before rotation"))
+
+	ring := NewKeyring(newKey, oldKey)
+	got, err := ring.OpenLine(line)
+	if err != nil || string(got) != "before rotation" {
+		t.Fatalf("OpenLine = %q, %v", got, err)
+	}
+	sealed, _ := ring.SealLine([]byte("after"))
+	if !strings.HasPrefix(string(sealed), linePrefix+newKey.ID()+":") {
+		t.Errorf("sealed with wrong key: %q", sealed[:20])
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	ring := NewKeyring(mustKey(t))
+	var file bytes.Buffer
+	for i, chunk := range []string{"{\"n\":1}\n", "{\"n\":2}\n{\"n\":3}\n"} {
+		line, _ := ring.SealLineAt([]byte(chunk), int64(i))
+		file.Write(line)
+		file.WriteByte('\n')
+	}
+	partial, _ := ring.SealLineAt([]byte("{\"n\":4}\n"), 2)
+	file.Write(partial[:len(partial)/2]) // still being appended
+
+	got, err := io.ReadAll(ring.NewReader(&file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewReaderRefusesForgedLines(t *testing.T) {
+	ring := NewKeyring(mustKey(t))
+	first, _ := ring.SealLineAt([]byte("{\"n\":1}\n"), 0)
+	second, _ := ring.SealLineAt([]byte("{\"n\":2}\n"), 1)
+	lone, _ := ring.SealLine([]byte("{\"n\":3}\n"))
+	read := func(r *Keyring, lines ...[]byte) (string, error) {
+		data, err := io.ReadAll(r.NewReader(bytes.NewReader(append(bytes.Join(lines, []byte("\n")), '\n'))))
+		return string(data), err
+	}
+
+	for name, lines := range map[string][][]byte{
+		"reordered":    {second, first},
+		"duplicated":   {first, first},
+		"dropped":      {second},
+		"lone line":    {first, lone},
+		"appended":     {first, second, []byte(`{"forged":true}`)},
+		"interspersed": {[]byte(`{"forged":true}`), first},
+	} {
+		if _, err := read(ring, lines...); err == nil {
+			t.Errorf("%s: read without error", name)
+		}
+	}
+	if _, err := read(ring, first, []byte(`{"forged":true}`)); !errors.Is(err, ErrPlaintext) {
+		t.Errorf("plaintext line: err = %v, want ErrPlaintext", err)
+	}
+
+	// A file from before encryption was turned on is read with
+	// AllowPlaintext, line numbers counting its plaintext lines.
+	legacy, _ := ring.SealLineAt([]byte("{\"n\":2}\n"), 1)
+	got, err := read(ring.AllowPlaintext(), []byte(`{"n":1}`), legacy)
+	if err != nil || got != "{\"n\":1}\n{\"n\":2}\n" {
+		t.Errorf("AllowPlaintext: got %q, %v", got, err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	k := mustKey(t)
+	t.Setenv("MIST_TEST_KEY", k.String())
+	got, err := LoadKey("env:MIST_TEST_KEY")
+	if err != nil || got.ID() != k.ID() {
+		t.Fatalf("env: %v, %v", got, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte(k.String()+"\n"), 0o600)
+	if got, err = LoadKey("file:" + path); err != nil || got.ID() != k.ID() {
+		t.Fatalf("file: %v, %v", got, err)
+	}
+
+	for _, ref := range []string{"MIST_TEST_KEY", "vault:x", "env:MIST_TEST_KEY_UNSET"} {
+		if _, err := LoadKey(ref); err == nil {
+			t.Errorf("LoadKey(%q): expected error", ref)
+		}
+	}
+	if _, err := ParseKey("c2hvcnQ="); misterrors.Code(err) != misterrors.CodeValidation {
+		t.Errorf("short key: err = %v", err)
+	}
+
+	old := mustKey(t)
+	t.Setenv("MIST_TEST_OLD_KEY", old.String())
+	ring, err := LoadKeyring("env:MIST_TEST_KEY", "", "env:MIST_TEST_OLD_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := NewKeyring(old).SealLine([]byte("x"))
+	if ring.Primary().ID() != k.ID() {
+		t.Errorf("primary = %s, want %s", ring.Primary().ID(), k.ID())
+	}
+	if _, err := ring.OpenLine(sealed); err != nil {
+		t.Errorf("open with old key: %v", err)
+	}
+}
+
+func TestRekeyFile(t *testing.T) {
+	oldKey, newKey := mustKey(t), mustKey(t)
+	path := filepath.Join(t.TempDir(), "run.jsonl")
+	sealed, _ := NewKeyring(oldKey).SealLineAt([]byte("{\"n\":2}\n"), 1)
+	os.WriteFile(path, []byte("{\"n\":1}\n"+string(sealed)+"\n"), 0o600)
+
+	n, err := RekeyFile(path, NewKeyring(newKey, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("rekeyed %d lines, want 2", n)
+	}
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	got, err := io.ReadAll(NewKeyring(newKey).NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "{\"n\":1}\n{\"n\":2}\n" {
+		t.Errorf("after rekey: %q", got)
+	}
+}
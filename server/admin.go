@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// Admin is a local control endpoint for a long-running command such as
+// mist relay, served over a unix socket so that only users who can open
+// the socket file reach it:
+//
+//	GET  /status   AdminStatus as JSON
+//	POST /pause    stop taking new work; work in hand finishes
+//	POST /resume   take work again
+//	POST /stop     stop gracefully, as SIGTERM does
+//
+// mist admin --socket <path> is its client. Pause, Resume, and Stop are
+// set by the command; a nil hook makes its endpoint fail.
+type Admin struct {
+	Command string
+
+	// Status returns command-specific details for GET /status, such as
+	// a relay's counts.
+	Status func() any
+
+	Pause  func()
+	Resume func()
+	Stop   func()
+
+	started time.Time
+	paused  atomic.Bool
+}
+
+// AdminStatus is the JSON body of GET /status, and of the pause, resume,
+// and stop endpoints once they have acted.
+type AdminStatus struct {
+	Command  string    `json:"command"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Paused   bool      `json:"paused"`
+	Stopping bool      `json:"stopping,omitempty"`
+	Details  any       `json:"details,omitempty"`
+}
+
+// NewAdmin creates an admin endpoint for the named command.
+func NewAdmin(command string) *Admin {
+	return &Admin{Command: command, started: time.Now()}
+}
+
+// Handler returns the admin API.
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		a.writeStatus(w, false)
+	})
+	mux.HandleFunc("POST /{action}", func(w http.ResponseWriter, r *http.Request) {
+		action := r.PathValue("action")
+		var hook func()
+		switch action {
+		case "pause":
+			hook = a.Pause
+		case "resume":
+			hook = a.Resume
+		case "stop":
+			hook = a.Stop
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if hook == nil {
+			misterrors.WriteHTTP(w, r, misterrors.Newf(misterrors.CodeValidation, "admin: %s can't %s", a.Command, action))
+			return
+		}
+		hook()
+		if action != "stop" {
+			a.paused.Store(action == "pause")
+		}
+		a.writeStatus(w, action == "stop")
+	})
+	return mux
+}
+
+func (a *Admin) writeStatus(w http.ResponseWriter, stopping bool) {
+	st := AdminStatus{
+		Command:  a.Command,
+		PID:      os.Getpid(),
+		Started:  a.started,
+		Paused:   a.paused.Load(),
+		Stopping: stopping,
+	}
+	if a.Status != nil {
+		st.Details = a.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// Serve serves the admin API on ln, from ListenAdmin, until ctx is done.
+// Closing ln removes its socket.
+func (a *Admin) Serve(ctx context.Context, ln net.Listener) error {
+	defer ln.Close()
+	srv := &http.Server{Handler: a.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// ListenAdmin listens on a unix socket at path that only its owner can
+// connect to. A socket left behind by a process that has exited is
+// replaced; one still being served is an error, so two relays can't
+// share a path.
+func ListenAdmin(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("admin: %s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, misterrors.Newf(misterrors.CodeConflict, "admin: %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("admin: %w", err)
+		}
+	}
+
+	// Bind in a directory only we can enter and move the socket into
+	// place once it is 0600, so it is never reachable with the umask's
+	// permissions.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".admin-")
+	if err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+	ul := ln.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+	return &adminListener{UnixListener: ul, path: path}, nil
+}
+
+// adminListener is a unix listener moved to path after binding. It
+// reports path as its address and removes it on Close, as a listener
+// bound there directly would.
+type adminListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (l *adminListener) Addr() net.Addr { return &net.UnixAddr{Name: l.path, Net: "unix"} }
+
+func (l *adminListener) Close() error {
+	err := l.UnixListener.Close()
+	l.once.Do(func() { os.Remove(l.path) })
+	return err
+}
+
+// AdminClient returns an HTTP client whose requests all go to the admin
+// socket at path, whatever the URL's host:
+//
+//	resp, err := server.AdminClient(path).Get("http://admin/status")
+func AdminClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				conn, err := d.DialContext(ctx, "unix", path)
+				if err != nil {
+					return nil, misterrors.Wrapf(misterrors.CodeUnavailable, err, "admin: nothing is serving %s", path)
+				}
+				return conn, nil
+			},
+		},
+	}
+}
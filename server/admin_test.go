@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// adminSocket returns a socket path short enough for the platform limit,
+// which t.TempDir paths can exceed.
+func adminSocket(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "mist.sock")
+}
+
+func adminCall(t *testing.T, path, method, endpoint string) (AdminStatus, int) {
+	t.Helper()
+	req, _ := http.NewRequest(method, "http://admin"+endpoint, nil)
+	resp, err := AdminClient(path).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st AdminStatus
+	json.NewDecoder(resp.Body).Decode(&st)
+	return st, resp.StatusCode
+}
+
+func TestAdmin(t *testing.T) {
+	path := adminSocket(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var paused bool
+	a := NewAdmin("relay")
+	a.Status = func() any { return map[string]int{"relayed": 3} }
+	a.Pause = func() { paused = true }
+	a.Resume = func() { paused = false }
+	a.Stop = cancel
+	ln, err := ListenAdmin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- a.Serve(ctx, ln) }()
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v; want 0600", fi, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("socket directory holds %v, want only the socket", entries)
+	}
+	if ln.Addr().String() != path {
+		t.Errorf("Addr = %s, want %s", ln.Addr(), path)
+	}
+	st, code := adminCall(t, path, "GET", "/status")
+	if code != http.StatusOK || st.Command != "relay" || st.PID != os.Getpid() || st.Paused {
+		t.Errorf("status = %d %+v", code, st)
+	}
+	if d, _ := st.Details.(map[string]any); d["relayed"] != 3.0 {
+		t.Errorf("details = %v", st.Details)
+	}
+
+	if st, _ := adminCall(t, path, "POST", "/pause"); !st.Paused || !paused {
+		t.Errorf("after pause: status paused = %v, hook called = %v", st.Paused, paused)
+	}
+	if st, _ := adminCall(t, path, "POST", "/resume"); st.Paused || paused {
+		t.Errorf("after resume: status paused = %v, hook paused = %v", st.Paused, paused)
+	}
+	if _, code := adminCall(t, path, "POST", "/restart"); code != http.StatusNotFound {
+		t.Errorf("unknown action: status %d, want 404", code)
+	}
+
+	if st, _ := adminCall(t, path, "POST", "/stop"); !st.Stopping {
+		t.Errorf("stop = %+v, want stopping", st)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after Serve: %v", err)
+	}
+	_, err = AdminClient(path).Get("http://admin/status")
+	if misterrors.Code(err) != misterrors.CodeUnavailable {
+		t.Errorf("status of a stopped command = %v, want unavailable", err)
+	}
+}
+
+func TestAdminUnsupported(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/pause", nil)
+	w := httptest.NewRecorder()
+	NewAdmin("serve").Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("pause without a hook: status %d, want 400", w.Code)
+	}
+}
+
+func TestAdminSocketInUse(t *testing.T) {
+	path := adminSocket(t)
+	ln, err := ListenAdmin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenAdmin(path); misterrors.Code(err) != misterrors.CodeConflict {
+		t.Errorf("second listener = %v, want a conflict", err)
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after Close: %v", err)
+	}
+
+	// The socket file of an exited process is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = ListenAdmin(path)
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	ln.Close()
+
+	os.WriteFile(path, nil, 0o600)
+	if _, err := ListenAdmin(path); err == nil {
+		t.Error("listened over a regular file")
+	}
+}
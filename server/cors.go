@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig selects which cross-origin browser requests are allowed.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://dash.example.com".
+	// "*" allows any origin, and "https://*.example.com" any subdomain.
+	AllowedOrigins []string
+
+	// AllowedMethods defaults to GET, POST, PUT, and DELETE.
+	AllowedMethods []string
+
+	// AllowedHeaders are request headers a page may send. Default
+	// Content-Type and Authorization. Matching ignores case.
+	AllowedHeaders []string
+
+	// ExposedHeaders are response headers a page may read.
+	ExposedHeaders []string
+
+	// AllowCredentials lets pages send cookies and HTTP auth. The
+	// request's origin is then echoed even when AllowedOrigins is "*",
+	// as browsers reject a wildcard with credentials.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight result. Default
+	// 10 minutes; negative disables caching.
+	MaxAge time.Duration
+}
+
+// CORS returns middleware that applies cfg. It answers preflight requests
+// itself with 204, or 403 if the origin, method, or headers are not
+// allowed. Other requests from an allowed origin get CORS headers and
+// pass through; requests from other origins pass through without them,
+// so browsers block the response. Apply it per route group with
+// Server.Group so only browser-facing endpoints are opened up.
+func CORS(cfg CORSConfig) Middleware {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = []string{"Content-Type", "Authorization"}
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 10 * time.Minute
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if !cfg.originAllowed(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if slices.Contains(cfg.AllowedOrigins, "*") && !cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if exposed != "" {
+					h.Set("Access-Control-Expose-Headers", exposed)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			if !slices.Contains(cfg.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) ||
+				!cfg.headersAllowed(r.Header.Get("Access-Control-Request-Headers")) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func (cfg *CORSConfig) originAllowed(origin string) bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			host, found := strings.CutPrefix(origin, scheme+"://")
+			if found && strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headersAllowed reports whether every header in a comma-separated
+// Access-Control-Request-Headers value is allowed.
+func (cfg *CORSConfig) headersAllowed(requested string) bool {
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.ContainsFunc(cfg.AllowedHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return false
+		}
+	}
+	return true
+}
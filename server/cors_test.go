@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func corsServer(cfg CORSConfig) *httptest.Server {
+	s := New(":0")
+	api := s.Group("/api", CORS(cfg))
+	api.Handle("GET /traces", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	s.Handle("GET /internal", func(w http.ResponseWriter, r *http.Request) {})
+	return httptest.NewServer(s.Mux())
+}
+
+func corsDo(t *testing.T, method, url string, headers map[string]string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORSPreflight(t *testing.T) {
+	ts := corsServer(CORSConfig{AllowedOrigins: []string{"https://dash.example.com", "https://*.mist.dev"}, AllowCredentials: true})
+	defer ts.Close()
+
+	resp := corsDo(t, "OPTIONS", ts.URL+"/api/traces", map[string]string{
+		"Origin":                         "https://dash.example.com",
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "content-type",
+	})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("preflight status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if resp.Header.Get("Access-Control-Allow-Credentials") != "true" || resp.Header.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("headers = %v", resp.Header)
+	}
+
+	for name, h := range map[string]map[string]string{
+		"origin": {"Origin": "https://evil.com", "Access-Control-Request-Method": "GET"},
+		"method": {"Origin": "https://dash.example.com", "Access-Control-Request-Method": "PATCH"},
+		"header": {"Origin": "https://dash.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Secret"},
+	} {
+		if resp := corsDo(t, "OPTIONS", ts.URL+"/api/traces", h); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("disallowed %s: status %d", name, resp.StatusCode)
+		}
+	}
+
+	resp = corsDo(t, "OPTIONS", ts.URL+"/api/traces", map[string]string{"Origin": "https://ui.mist.dev", "Access-Control-Request-Method": "GET"})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("wildcard subdomain: status %d", resp.StatusCode)
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	ts := corsServer(CORSConfig{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Request-ID"}})
+	defer ts.Close()
+
+	resp := corsDo(t, "GET", ts.URL+"/api/traces", map[string]string{"Origin": "https://anywhere.test"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("status %d, Allow-Origin %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	if resp.Header.Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+		t.Errorf("Expose-Headers = %q", resp.Header.Get("Access-Control-Expose-Headers"))
+	}
+
+	// Routes outside the group are unaffected.
+	resp = corsDo(t, "GET", ts.URL+"/internal", map[string]string{"Origin": "https://anywhere.test"})
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("CORS applied outside the group")
+	}
+	resp = corsDo(t, "OPTIONS", ts.URL+"/internal", map[string]string{"Origin": "https://anywhere.test", "Access-Control-Request-Method": "GET"})
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("preflight outside group: status %d", resp.StatusCode)
+	}
+}
+
+func TestGroupPrefix(t *testing.T) {
+	s := New(":0")
+	g := s.Group("v1/")
+	g.Handle("/ping", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("pong")) })
+	w := httptest.NewRecorder()
+	s.Mux().ServeHTTP(w, httptest.NewRequest("GET", "/v1/ping", nil))
+	if w.Body.String() != "pong" {
+		t.Errorf("GET /v1/ping = %d %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"strings"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/resource"
+)
+
+// DebugOption configures EnableDebug.
+type DebugOption func(*debugConfig)
+
+type debugConfig struct {
+	token string
+}
+
+// WithDebugToken requires "Authorization: Bearer <token>" on every debug
+// endpoint. Without it the endpoints are open, so only enable debug on
+// servers bound to a private interface.
+func WithDebugToken(token string) DebugOption {
+	return func(c *debugConfig) { c.token = token }
+}
+
+// EnableDebug mounts runtime diagnostics under prefix (default "/debug"):
+//
+//	<prefix>/pprof/       pprof index and profiles (profile, heap, trace, ...)
+//	<prefix>/vars         expvar
+//	<prefix>/goroutines   full goroutine dump as text
+//	<prefix>/resources    resource.Snapshot as JSON
+//
+// Profiles can be fetched from a running node with mist debug profile.
+func (s *Server) EnableDebug(prefix string, opts ...DebugOption) {
+	var cfg debugConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		prefix = "/debug"
+	}
+
+	handle := func(pattern string, h http.Handler) {
+		s.mux.Handle(pattern, debugAuth(cfg.token, h))
+	}
+
+	// pprof.Index only resolves named profiles under /debug/pprof/, so
+	// they are routed explicitly to work under any prefix.
+	handle(prefix+"/pprof/", http.HandlerFunc(pprof.Index))
+	handle(prefix+"/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	handle(prefix+"/pprof/profile", http.HandlerFunc(pprof.Profile))
+	handle(prefix+"/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	handle(prefix+"/pprof/trace", http.HandlerFunc(pprof.Trace))
+	handle(prefix+"/pprof/{name}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(r.PathValue("name")).ServeHTTP(w, r)
+	}))
+
+	handle("GET "+prefix+"/vars", expvar.Handler())
+	handle("GET "+prefix+"/goroutines", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	}))
+	handle("GET "+prefix+"/resources", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resource.TakeSnapshot())
+	}))
+}
+
+// debugAuth wraps h with a bearer token check when token is set.
+func debugAuth(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			misterrors.WriteHTTP(w, r, misterrors.New(misterrors.CodeAuth, "server: debug endpoints require a valid bearer token"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
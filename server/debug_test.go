@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/resource"
+)
+
+func TestEnableDebug(t *testing.T) {
+	s := New(":0")
+	s.EnableDebug("/_debug")
+	ts := httptest.NewServer(s.Mux())
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/_debug/pprof/", "Types of profiles available"},
+		{"/_debug/pprof/goroutine?debug=1", "goroutine profile"},
+		{"/_debug/vars", `"memstats"`},
+		{"/_debug/goroutines", "goroutine "},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", tt.path, resp.StatusCode)
+		}
+		if !strings.Contains(string(body), tt.want) {
+			t.Errorf("%s: body missing %q", tt.path, tt.want)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/_debug/resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var snap resource.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Goroutines == 0 {
+		t.Errorf("snapshot = %+v", snap)
+	}
+}
+
+func TestEnableDebugToken(t *testing.T) {
+	s := New(":0")
+	s.EnableDebug("", WithDebugToken("s3cret"))
+	ts := httptest.NewServer(s.Mux())
+	defer ts.Close()
+
+	for _, auth := range []string{"", "Bearer wrong"} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/debug/vars", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("auth %q: status %d, want 401", auth, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/debug/vars", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("valid token: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Middleware wraps a handler, for example with CORS.
+type Middleware func(http.Handler) http.Handler
+
+// Group registers routes under a common path prefix with shared
+// middleware:
+//
+//	api := srv.Group("/api", server.CORS(server.CORSConfig{
+//	    AllowedOrigins: []string{"https://dash.example.com"},
+//	}))
+//	api.Handle("GET /traces", h.Traces) // serves GET /api/traces
+type Group struct {
+	s      *Server
+	prefix string
+	mw     []Middleware
+}
+
+// Group returns a route group for prefix. Middleware runs in the order
+// given, the first outermost. An empty prefix groups routes at the root.
+func (s *Server) Group(prefix string, mw ...Middleware) *Group {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return &Group{s: s, prefix: prefix, mw: mw}
+}
+
+// Handle registers handler for pattern beneath the group's prefix. A
+// pattern naming a method, such as "GET /traces", also answers OPTIONS
+// for the path through the group's middleware, so CORS preflight
+// requests reach CORS instead of failing with 405.
+func (g *Group) Handle(pattern string, handler http.HandlerFunc) {
+	method, path, hasMethod := strings.Cut(pattern, " ")
+	if !hasMethod {
+		method, path = "", pattern
+	}
+	path = g.prefix + "/" + strings.TrimLeft(strings.TrimSpace(path), "/")
+
+	h := g.wrap(handler)
+	if method == "" {
+		g.s.mux.Handle(path, h)
+		return
+	}
+	g.s.mux.Handle(method+" "+path, h)
+
+	if len(g.mw) == 0 || method == http.MethodOptions {
+		return
+	}
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	if g.s.options == nil {
+		g.s.options = make(map[string]bool)
+	}
+	if !g.s.options[path] {
+		g.s.options[path] = true
+		g.s.mux.Handle("OPTIONS "+path, g.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		})))
+	}
+}
+
+// wrap applies the group's middleware to h.
+func (g *Group) wrap(h http.Handler) http.Handler {
+	return chain(h, g.mw)
+}
+
+// chain wraps h with mw, the first outermost.
+func chain(h http.Handler, mw []Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/greynewell/mist-go/config"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/logging"
+)
+
+// DefaultRedactKeys are the config keys /configz never reveals. A key is
+// redacted if its full dotted name or final segment matches.
+var DefaultRedactKeys = []string{"password", "secret", "token", "api_key", "apikey", "private_key"}
+
+// maxConfigBody bounds PUT /configz bodies.
+const maxConfigBody = 1 << 20
+
+// Configz holds a server's current configuration for introspection and
+// runtime replacement through /configz.
+type Configz struct {
+	mu     sync.RWMutex
+	cfg    *config.Config
+	redact []string
+	apply  func(*config.Config) error
+}
+
+// ConfigzOption configures a Configz.
+type ConfigzOption func(*Configz)
+
+// WithRedact adds keys to DefaultRedactKeys.
+func WithRedact(keys ...string) ConfigzOption {
+	return func(z *Configz) { z.redact = append(z.redact, keys...) }
+}
+
+// WithApply enables PUT /configz. fn receives the parsed TOML body and
+// returns an error to reject it; the config is replaced only if fn
+// succeeds.
+func WithApply(fn func(*config.Config) error) ConfigzOption {
+	return func(z *Configz) { z.apply = fn }
+}
+
+// NewConfigz creates a Configz holding cfg.
+func NewConfigz(cfg *config.Config, opts ...ConfigzOption) *Configz {
+	z := &Configz{
+		cfg:    cfg,
+		redact: append([]string(nil), DefaultRedactKeys...),
+	}
+	for _, opt := range opts {
+		opt(z)
+	}
+	return z
+}
+
+// Get returns the current configuration.
+func (z *Configz) Get() *config.Config {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.cfg
+}
+
+// Set replaces the current configuration, for example from config.Watch.
+func (z *Configz) Set(cfg *config.Config) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.cfg = cfg
+}
+
+// ServeHTTP serves GET (the redacted config as a flat JSON object) and,
+// with WithApply, PUT (a TOML body replacing the config).
+func (z *Configz) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if z.apply == nil {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "configz is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBody))
+		if err != nil {
+			misterrors.WriteHTTP(w, r, misterrors.Wrap(misterrors.CodeValidation, err, "server: read config"))
+			return
+		}
+		data, err := config.ParseTOML(bytes.NewReader(body))
+		if err != nil {
+			misterrors.WriteHTTP(w, r, misterrors.Wrap(misterrors.CodeValidation, err, "server: parse config"))
+			return
+		}
+		cfg := config.New(data)
+		if err := z.apply(cfg); err != nil {
+			misterrors.WriteHTTP(w, r, misterrors.Wrap(misterrors.CodeValidation, err, "server: apply config"))
+			return
+		}
+		z.Set(cfg)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flat := map[string]any{}
+	if cfg := z.Get(); cfg != nil {
+		flat = cfg.Flatten(z.redact...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flat)
+}
+
+// LogLevel is the body of /logz/level requests and responses.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler serves GET and PUT for a logger's level, so debug
+// logging can be turned on for a running node without a restart:
+//
+//	curl -X PUT -d '{"level":"debug"}' localhost:8080/logz/level
+func LogLevelHandler(l *logging.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var req LogLevel
+			if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+				misterrors.WriteHTTP(w, r, misterrors.Wrap(misterrors.CodeValidation, err, "server: decode log level"))
+				return
+			}
+			level, err := logging.ParseLevel(req.Level)
+			if err != nil {
+				misterrors.WriteHTTP(w, r, misterrors.Wrap(misterrors.CodeValidation, err, "server: log level"))
+				return
+			}
+			l.SetLevel(level)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LogLevel{Level: l.Level().String()})
+	}
+}
+
+// EnableConfigz mounts z at GET /configz, and PUT /configz if it was
+// created WithApply. Use WithDebugToken to require authentication.
+func (s *Server) EnableConfigz(z *Configz, opts ...DebugOption) {
+	var cfg debugConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	h := debugAuth(cfg.token, z)
+	s.mux.Handle("GET /configz", h)
+	if z.apply != nil {
+		s.mux.Handle("PUT /configz", h)
+	}
+}
+
+// EnableLogz mounts GET and PUT /logz/level for l.
+func (s *Server) EnableLogz(l *logging.Logger, opts ...DebugOption) {
+	var cfg debugConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	h := debugAuth(cfg.token, LogLevelHandler(l))
+	s.mux.Handle("GET /logz/level", h)
+	s.mux.Handle("PUT /logz/level", h)
+}
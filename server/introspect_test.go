@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/config"
+	"github.com/greynewell/mist-go/logging"
+)
+
+func newTestConfig(t *testing.T, src string) *config.Config {
+	t.Helper()
+	data, err := config.ParseTOML(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.New(data)
+}
+
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestConfigz(t *testing.T) {
+	cfg := newTestConfig(t, `
+[provider]
+api_key = "sk-secret"
+url = "https://api.example.com"
+region = "us-east"
+`)
+	var applied *config.Config
+	z := NewConfigz(cfg, WithRedact("region"), WithApply(func(c *config.Config) error {
+		if !c.Has("provider.url") {
+			return errors.New("provider.url is required")
+		}
+		applied = c
+		return nil
+	}))
+
+	s := New(":0")
+	s.EnableConfigz(z)
+	ts := httptest.NewServer(s.Mux())
+	defer ts.Close()
+
+	status, body := do(t, http.MethodGet, ts.URL+"/configz", "")
+	if status != http.StatusOK {
+		t.Fatalf("GET status %d", status)
+	}
+	var got map[string]any
+	json.Unmarshal([]byte(body), &got)
+	if got["provider.api_key"] != "[REDACTED]" || got["provider.region"] != "[REDACTED]" {
+		t.Errorf("secrets not redacted: %v", got)
+	}
+	if got["provider.url"] != "https://api.example.com" {
+		t.Errorf("provider.url = %v", got["provider.url"])
+	}
+
+	status, _ = do(t, http.MethodPut, ts.URL+"/configz", "name = \"x\"\n")
+	if status != http.StatusBadRequest {
+		t.Errorf("rejected PUT status %d, want 400", status)
+	}
+	if z.Get() != cfg {
+		t.Error("rejected PUT replaced config")
+	}
+
+	status, body = do(t, http.MethodPut, ts.URL+"/configz", "[provider]\nurl = \"https://new.example.com\"\n")
+	if status != http.StatusOK {
+		t.Fatalf("PUT status %d: %s", status, body)
+	}
+	if applied == nil || z.Get() != applied {
+		t.Error("PUT did not replace config")
+	}
+	if !strings.Contains(body, "new.example.com") {
+		t.Errorf("PUT response = %s", body)
+	}
+}
+
+func TestConfigzReadOnly(t *testing.T) {
+	s := New(":0")
+	s.EnableConfigz(NewConfigz(config.New(nil)))
+	ts := httptest.NewServer(s.Mux())
+	defer ts.Close()
+
+	if status, _ := do(t, http.MethodPut, ts.URL+"/configz", "a = 1\n"); status != http.StatusMethodNotAllowed {
+		t.Errorf("PUT status %d, want 405", status)
+	}
+}
+
+func TestLogz(t *testing.T) {
+	var buf strings.Builder
+	log := logging.New("test", logging.LevelInfo, logging.WithWriter(&buf))
+
+	s := New(":0")
+	s.EnableLogz(log)
+	ts := httptest.NewServer(s.Mux())
+	defer ts.Close()
+
+	if _, body := do(t, http.MethodGet, ts.URL+"/logz/level", ""); !strings.Contains(body, `"INFO"`) {
+		t.Errorf("GET = %s", body)
+	}
+
+	status, body := do(t, http.MethodPut, ts.URL+"/logz/level", `{"level":"debug"}`)
+	if status != http.StatusOK || !strings.Contains(body, `"DEBUG"`) {
+		t.Errorf("PUT = %d %s", status, body)
+	}
+	if log.Level() != logging.LevelDebug {
+		t.Errorf("level = %v, want debug", log.Level())
+	}
+
+	if status, _ := do(t, http.MethodPut, ts.URL+"/logz/level", `{"level":"loud"}`); status != http.StatusBadRequest {
+		t.Errorf("invalid level status %d, want 400", status)
+	}
+	if log.Level() != logging.LevelDebug {
+		t.Error("invalid level changed the logger")
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/greynewell/mist-go/metadata"
+)
+
+// HeaderRequestID carries a request's ID in requests and responses.
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestIDLen bounds client-supplied request IDs.
+const maxRequestIDLen = 128
+
+// RequestID returns middleware that gives every request an ID: the
+// client's X-Request-ID if it is well formed, otherwise a new random one.
+// The ID is echoed in the response header and stored in the request
+// context's metadata under metadata.KeyRequestID, where error responses
+// (errors.WriteHTTP), logs, and spans started from the context pick it
+// up. Install it for every route with Use:
+//
+//	srv.Use(server.RequestID())
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(HeaderRequestID)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(HeaderRequestID, id)
+			ctx := metadata.With(r.Context(), metadata.KeyRequestID, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestIDFrom returns the request ID stored by RequestID, or "".
+func RequestIDFrom(r *http.Request) string {
+	return metadata.Get(r.Context(), metadata.KeyRequestID)
+}
+
+// validRequestID accepts IDs made of characters common in UUIDs and
+// other ID formats, so a client cannot inject arbitrary text into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, ch := range id {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.', ch == ':', ch == '/', ch == '+', ch == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
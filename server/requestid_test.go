@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/trace"
+)
+
+func TestRequestID(t *testing.T) {
+	var span protocol.TraceSpan
+	s := New(":0")
+	s.Use(RequestID())
+	s.Handle("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		ctx := trace.WithExporter(r.Context(), trace.ExporterFunc(func(sp protocol.TraceSpan) { span = sp }))
+		_, sp := trace.Start(ctx, "work")
+		sp.End("error")
+		misterrors.WriteHTTP(w, r, misterrors.New(misterrors.CodeNotFound, "no such thing"))
+	})
+
+	// An incoming ID is honored everywhere.
+	req := httptest.NewRequest("GET", "/fail", nil)
+	req.Header.Set(HeaderRequestID, "client-abc-123")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+
+	if got := w.Header().Get(HeaderRequestID); got != "client-abc-123" {
+		t.Errorf("echoed ID = %q", got)
+	}
+	var body struct {
+		Error struct {
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Error.RequestID != "client-abc-123" {
+		t.Errorf("error body = %s", w.Body)
+	}
+	if span.Attrs["request_id"] != "client-abc-123" {
+		t.Errorf("span attrs = %v", span.Attrs)
+	}
+
+	// Missing or malformed IDs are replaced.
+	for _, in := range []string{"", "bad id\nwith newline"} {
+		req := httptest.NewRequest("GET", "/fail", nil)
+		req.Header.Set(HeaderRequestID, in)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		if got := w.Header().Get(HeaderRequestID); len(got) != 32 || got == in {
+			t.Errorf("ID for %q = %q", in, got)
+		}
+	}
+}
+
+func TestRequestIDFrom(t *testing.T) {
+	var got string
+	h := RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestIDFrom(r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got == "" {
+		t.Error("no request ID in context")
+	}
+	if RequestIDFrom(httptest.NewRequest("GET", "/", nil).WithContext(context.Background())) != "" {
+		t.Error("request ID without middleware")
+	}
+}
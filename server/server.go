@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 )
 
@@ -17,6 +18,10 @@ type Server struct {
 	Addr string
 	mux  *http.ServeMux
 	srv  *http.Server
+
+	mu      sync.Mutex
+	options map[string]bool // paths with an OPTIONS route from a Group
+	mw      []Middleware    // added by Use
 }
 
 // New creates a server bound to the given address.
@@ -39,7 +44,25 @@ func (s *Server) Handle(pattern string, handler http.HandlerFunc) {
 	s.mux.HandleFunc(pattern, handler)
 }
 
-// Mux returns the underlying ServeMux for direct access.
+// Use wraps every route, including routes registered later, with mw.
+// Middleware runs in the order added, the first outermost.
+func (s *Server) Use(mw ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mw = append(s.mw, mw...)
+	s.srv.Handler = chain(s.mux, s.mw)
+}
+
+// Handler returns the server's root handler: the mux wrapped with any
+// middleware added by Use.
+func (s *Server) Handler() http.Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.srv.Handler
+}
+
+// Mux returns the underlying ServeMux for direct access. Requests served
+// through it directly bypass middleware added by Use.
 func (s *Server) Mux() *http.ServeMux {
 	return s.mux
 }
@@ -74,3 +97,28 @@ func (s *Server) ListenAndServe() error {
 
 	return nil
 }
+
+// Serve serves on ln until ctx is done, then shuts down gracefully,
+// giving in-flight requests up to 5 seconds. Unlike ListenAndServe it
+// leaves signal handling to the caller, such as lifecycle.Run, which
+// cancels ctx on SIGTERM or SIGINT.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	fmt.Fprintf(os.Stderr, "listening on %s\n", ln.Addr())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return s.srv.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/resource"
+)
+
+// Snapshot is the body of GET /snapshotz: the node's state gathered into
+// one document for attaching to incident tickets. Sections that were not
+// configured are omitted.
+type Snapshot struct {
+	Host    string    `json:"host"`
+	TakenAt time.Time `json:"taken_at"`
+
+	// Window is how long the sections took to read. Each section is
+	// consistent in itself, but sections may differ by changes made
+	// within the window.
+	Window time.Duration `json:"window_ns"`
+
+	Metrics        *metrics.RegistrySnapshot          `json:"metrics,omitempty"`
+	Resources      map[string]resource.ResourceStatus `json:"resources,omitempty"`
+	ConfigChecksum string                             `json:"config_checksum,omitempty"`
+
+	// Components holds the state of each component registered with
+	// WithSnapshotComponent or Register, by name.
+	Components map[string]any `json:"components,omitempty"`
+}
+
+// Snapshotter gathers a Snapshot from the sources it was given.
+// Components register their own sources, so the server package doesn't
+// depend on them:
+//
+//	sn.Register("aggregator", func() any { return agg.Stats() })
+type Snapshotter struct {
+	metrics   *metrics.Registry
+	resources *resource.Monitor
+	config    *Configz
+
+	mu         sync.Mutex // guards components; held by Take
+	components map[string]func() any
+}
+
+// SnapshotOption adds a source to a Snapshotter.
+type SnapshotOption func(*Snapshotter)
+
+// WithSnapshotMetrics includes reg's metrics.
+func WithSnapshotMetrics(reg *metrics.Registry) SnapshotOption {
+	return func(s *Snapshotter) { s.metrics = reg }
+}
+
+// WithSnapshotResources includes the resource monitor's status.
+func WithSnapshotResources(m *resource.Monitor) SnapshotOption {
+	return func(s *Snapshotter) { s.resources = m }
+}
+
+// WithSnapshotConfig includes a checksum of z's current config, redacted
+// as /configz is, so nodes can be compared for drift.
+func WithSnapshotConfig(z *Configz) SnapshotOption {
+	return func(s *Snapshotter) { s.config = z }
+}
+
+// WithSnapshotComponent includes the value fn returns under name in
+// Components.
+func WithSnapshotComponent(name string, fn func() any) SnapshotOption {
+	return func(s *Snapshotter) { s.components[name] = fn }
+}
+
+// NewSnapshotter creates a Snapshotter over the given sources.
+func NewSnapshotter(opts ...SnapshotOption) *Snapshotter {
+	s := &Snapshotter{components: make(map[string]func() any)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register includes the value fn returns under name in Components,
+// replacing any earlier source of that name. fn should return a copy of
+// the component's state read under its own lock.
+func (s *Snapshotter) Register(name string, fn func() any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.components[name] = fn
+}
+
+// Take gathers a snapshot. Each section is a copy its source reads in
+// one step; the sections are read one after another, and Window records
+// how long that took. Concurrent Takes run one at a time.
+func (s *Snapshotter) Take() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now()
+	snap := Snapshot{TakenAt: start.UTC()}
+	snap.Host, _ = os.Hostname()
+	if s.metrics != nil {
+		m := s.metrics.Snapshot()
+		snap.Metrics = &m
+	}
+	if s.resources != nil {
+		snap.Resources = s.resources.Status()
+	}
+	if s.config != nil {
+		if cfg := s.config.Get(); cfg != nil {
+			snap.ConfigChecksum = cfg.Checksum(s.config.redact...)
+		}
+	}
+	if len(s.components) > 0 {
+		snap.Components = make(map[string]any, len(s.components))
+		for name, fn := range s.components {
+			snap.Components[name] = fn()
+		}
+	}
+	snap.Window = time.Since(start)
+	return snap
+}
+
+// ServeHTTP writes a fresh snapshot as JSON.
+func (s *Snapshotter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Take())
+}
+
+// EnableSnapshotz mounts sn at GET /snapshotz. Fetch it from a running
+// node with mist snapshot. Use WithDebugToken to require authentication.
+func (s *Server) EnableSnapshotz(sn *Snapshotter, opts ...DebugOption) {
+	var cfg debugConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	s.mux.Handle("GET /snapshotz", debugAuth(cfg.token, sn))
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greynewell/mist-go/circuitbreaker"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/resource"
+)
+
+func TestSnapshotz(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.Counter("requests_total").Add(3)
+
+	mon := resource.NewMonitor()
+	mon.Track(resource.NewLimiter("workers", 4))
+
+	br := circuitbreaker.New(circuitbreaker.Config{Threshold: 1})
+	br.Do(context.Background(), func(context.Context) error { return errors.New("down") })
+
+	z := NewConfigz(newTestConfig(t, "api_key = \"sk-secret\"\n"))
+
+	s := New(":0")
+	sn := NewSnapshotter(
+		WithSnapshotMetrics(reg),
+		WithSnapshotResources(mon),
+		WithSnapshotComponent("breakers", func() any {
+			return map[string]circuitbreaker.Stats{"openai": br.Stats()}
+		}),
+		WithSnapshotConfig(z),
+	)
+	sn.Register("queue", func() any { return map[string]int{"depth": 7} })
+	s.EnableSnapshotz(sn, WithDebugToken("t0k"))
+	ts := httptest.NewServer(s.Mux())
+	defer ts.Close()
+
+	if status, _ := do(t, http.MethodGet, ts.URL+"/snapshotz", ""); status != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d", status)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/snapshotz", nil)
+	req.Header.Set("Authorization", "Bearer t0k")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var snap struct {
+		Snapshot
+		Components struct {
+			Breakers map[string]circuitbreaker.Stats `json:"breakers"`
+			Queue    struct{ Depth int }             `json:"queue"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		t.Fatal(err)
+	}
+
+	if snap.TakenAt.IsZero() {
+		t.Error("taken_at missing")
+	}
+	if snap.Metrics == nil || len(snap.Metrics.Counters) != 1 {
+		t.Errorf("metrics = %+v", snap.Metrics)
+	}
+	if snap.Resources["workers"].Max != 4 {
+		t.Errorf("resources = %+v", snap.Resources)
+	}
+	if b := snap.Components.Breakers["openai"]; b.State != "open" || b.Failures != 1 {
+		t.Errorf("breaker = %+v", b)
+	}
+	if snap.Components.Queue.Depth != 7 {
+		t.Errorf("registered component = %+v", snap.Components.Queue)
+	}
+	if snap.ConfigChecksum != z.Get().Checksum(DefaultRedactKeys...) {
+		t.Errorf("config_checksum = %q", snap.ConfigChecksum)
+	}
+}
+
+func TestSnapshotOmitsUnconfigured(t *testing.T) {
+	snap := NewSnapshotter().Take()
+	if snap.Metrics != nil || snap.Components != nil || snap.ConfigChecksum != "" {
+		t.Errorf("snapshot = %+v", snap)
+	}
+}
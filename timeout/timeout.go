@@ -0,0 +1,128 @@
+// Package timeout derives budget-aware deadlines for MIST tools and
+// carries them across tool boundaries on the message envelope.
+//
+// A caller's ctx deadline is the whole budget for a request. Each attempt
+// gets what is left minus a reserve kept back for the caller's own work
+// (logging, fallback, writing the response):
+//
+//	ctx, cancel, err := timeout.Attempt(ctx, 5*time.Second, 100*time.Millisecond)
+//	if err != nil {
+//	    return err // budget already spent
+//	}
+//	defer cancel()
+//
+// Outgoing messages are stamped with the deadline, and receivers restore
+// it, rejecting work whose caller has already given up:
+//
+//	timeout.Annotate(ctx, msg)            // sender
+//	ctx, cancel, err := timeout.FromMessage(ctx, msg) // receiver
+package timeout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+var (
+	// ErrUpstreamDeadline means the deadline set by an upstream caller
+	// passed. The caller has already given up, so retrying locally is
+	// pointless.
+	ErrUpstreamDeadline = misterrors.New(misterrors.CodeTimeout, "deadline exceeded upstream").Permanent()
+
+	// ErrBudgetExhausted means the remaining budget, after the reserve,
+	// is too small to start another attempt.
+	ErrBudgetExhausted = misterrors.New(misterrors.CodeTimeout, "deadline budget exhausted").Permanent()
+)
+
+// Remaining returns the time left before ctx's deadline minus reserve.
+// ok is false if ctx has no deadline. The result may be zero or negative
+// when the budget is spent.
+func Remaining(ctx context.Context, reserve time.Duration) (d time.Duration, ok bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline) - reserve, true
+}
+
+// Attempt derives a ctx for a single attempt. Its timeout is perAttempt,
+// shortened to fit the remaining budget minus reserve. A perAttempt of
+// zero means "use whatever budget is left". If ctx has no deadline and
+// perAttempt is zero, the returned ctx has no deadline either.
+//
+// Attempt returns ErrBudgetExhausted if no budget remains.
+func Attempt(ctx context.Context, perAttempt, reserve time.Duration) (context.Context, context.CancelFunc, error) {
+	d := perAttempt
+	if left, ok := Remaining(ctx, reserve); ok {
+		if left <= 0 {
+			return ctx, func() {}, ErrBudgetExhausted
+		}
+		if d <= 0 || left < d {
+			d = left
+		}
+	}
+	if d <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, nil
+}
+
+// Annotate stamps msg with ctx's deadline. An earlier deadline already on
+// the message is kept.
+func Annotate(ctx context.Context, msg *protocol.Message) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	ns := deadline.UnixNano()
+	if msg.DeadlineNS == 0 || ns < msg.DeadlineNS {
+		msg.DeadlineNS = ns
+	}
+}
+
+// FromMessage restores a received message's deadline into ctx. If the
+// deadline has already passed it returns ErrUpstreamDeadline and the work
+// should be rejected. When the restored deadline later fires, Err(ctx)
+// reports ErrUpstreamDeadline rather than a local timeout.
+func FromMessage(ctx context.Context, msg *protocol.Message) (context.Context, context.CancelFunc, error) {
+	deadline, ok := msg.Deadline()
+	if !ok {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	if !time.Now().Before(deadline) {
+		return ctx, func() {}, ErrUpstreamDeadline
+	}
+	ctx, cancel := context.WithDeadlineCause(ctx, deadline, ErrUpstreamDeadline)
+	return ctx, cancel, nil
+}
+
+// Err describes why ctx ended: ErrUpstreamDeadline for deadlines restored
+// by FromMessage, a CodeTimeout error for local deadlines, a
+// CodeCancelled error for cancellation, or nil if ctx is still live.
+func Err(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	if IsUpstream(cause) {
+		return ErrUpstreamDeadline
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return misterrors.Wrap(misterrors.CodeTimeout, cause, "deadline exceeded")
+	}
+	return misterrors.Wrap(misterrors.CodeCancelled, cause, "cancelled")
+}
+
+// IsUpstream reports whether err means an upstream caller's deadline
+// passed, as opposed to a local timeout.
+func IsUpstream(err error) bool {
+	return misterrors.Is(err, ErrUpstreamDeadline)
+}
@@ -0,0 +1,136 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestAttemptFitsBudget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	actx, acancel, err := Attempt(ctx, 10*time.Second, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acancel()
+
+	dl, ok := actx.Deadline()
+	if !ok {
+		t.Fatal("attempt ctx should have a deadline")
+	}
+	if left := time.Until(dl); left > 400*time.Millisecond || left < 300*time.Millisecond {
+		t.Errorf("attempt timeout = %v, want ~400ms (budget minus reserve)", left)
+	}
+}
+
+func TestAttemptPerAttemptCap(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	actx, acancel, _ := Attempt(ctx, 50*time.Millisecond, time.Second)
+	defer acancel()
+	dl, _ := actx.Deadline()
+	if left := time.Until(dl); left > 50*time.Millisecond {
+		t.Errorf("attempt timeout = %v, want <= 50ms", left)
+	}
+}
+
+func TestAttemptBudgetExhausted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err := Attempt(ctx, time.Second, 50*time.Millisecond)
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("err = %v, want ErrBudgetExhausted", err)
+	}
+	if misterrors.IsRetryable(err) {
+		t.Error("exhausted budget should not be retryable")
+	}
+}
+
+func TestAttemptNoDeadline(t *testing.T) {
+	actx, cancel, err := Attempt(context.Background(), 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if _, ok := actx.Deadline(); ok {
+		t.Error("no budget and no per-attempt timeout should mean no deadline")
+	}
+}
+
+func TestAnnotateKeepsEarliest(t *testing.T) {
+	msg, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{})
+
+	Annotate(context.Background(), msg)
+	if msg.DeadlineNS != 0 {
+		t.Error("no ctx deadline should leave message untouched")
+	}
+
+	early := time.Now().Add(time.Second)
+	msg.DeadlineNS = early.UnixNano()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	Annotate(ctx, msg)
+	if msg.DeadlineNS != early.UnixNano() {
+		t.Error("Annotate should keep the earlier deadline")
+	}
+}
+
+func TestFromMessageUpstreamDeadline(t *testing.T) {
+	msg, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{})
+	msg.DeadlineNS = time.Now().Add(20 * time.Millisecond).UnixNano()
+
+	ctx, cancel, err := FromMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	<-ctx.Done()
+	err = Err(ctx)
+	if !IsUpstream(err) {
+		t.Errorf("Err = %v, want upstream deadline", err)
+	}
+	if misterrors.Code(err) != misterrors.CodeTimeout {
+		t.Errorf("code = %s", misterrors.Code(err))
+	}
+}
+
+func TestFromMessageAlreadyExpired(t *testing.T) {
+	msg, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{})
+	msg.DeadlineNS = time.Now().Add(-time.Second).UnixNano()
+
+	if _, _, err := FromMessage(context.Background(), msg); !IsUpstream(err) {
+		t.Errorf("err = %v, want ErrUpstreamDeadline", err)
+	}
+}
+
+func TestErrLocalTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := Err(ctx)
+	if IsUpstream(err) {
+		t.Error("local timeout reported as upstream")
+	}
+	if misterrors.Code(err) != misterrors.CodeTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Err = %v", err)
+	}
+
+	cctx, ccancel := context.WithCancel(context.Background())
+	ccancel()
+	if misterrors.Code(Err(cctx)) != misterrors.CodeCancelled {
+		t.Errorf("cancelled Err = %v", Err(cctx))
+	}
+	if Err(context.Background()) != nil {
+		t.Error("live ctx should have nil Err")
+	}
+}
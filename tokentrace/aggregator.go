@@ -1,6 +1,7 @@
 package tokentrace
 
 import (
+	"sort"
 	"sync"
 	"sync/atomic"
 
@@ -11,6 +12,14 @@ import (
 // latencyBuckets are histogram boundaries for span latency in milliseconds.
 var latencyBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
 
+// OtherOperation is the overflow bucket for operations observed after the
+// per-operation cardinality cap is reached.
+const OtherOperation = "__other__"
+
+// DefaultMaxOperations is the default cap on distinct operation names
+// tracked in the per-operation breakdown.
+const DefaultMaxOperations = 1000
+
 // Aggregator computes real-time metrics from ingested trace spans.
 type Aggregator struct {
 	registry *metrics.Registry
@@ -25,24 +34,56 @@ type Aggregator struct {
 	costMu       sync.Mutex
 	totalCostUSD float64
 
-	// Per-operation stats.
-	opMu sync.Mutex
-	ops  map[string]*opStats
+	// Per-operation stats, capped at maxOps distinct names. Spans for
+	// operations beyond the cap are folded into OtherOperation.
+	opMu   sync.Mutex
+	ops    map[string]*opStats
+	maxOps int
+	capped *metrics.Counter
+
+	// Per-arm stats for spans routed by an InferMux experiment, keyed by
+	// the "experiment" and "arm" attrs. Guarded by opMu; arms beyond
+	// maxOps are not tracked and count toward capped.
+	arms    map[string]map[string]*opStats
+	numArms int
 }
 
 type opStats struct {
-	count  int64
-	errors int64
+	count     int64
+	errors    int64
+	latencyMS float64
+	costUSD   float64
+}
+
+// AggregatorOption configures an Aggregator.
+type AggregatorOption func(*Aggregator)
+
+// WithMaxOperations caps the number of distinct operation names tracked
+// in the per-operation breakdown. Operation names that contain request
+// IDs would otherwise grow the breakdown without bound.
+func WithMaxOperations(n int) AggregatorOption {
+	return func(a *Aggregator) {
+		if n > 0 {
+			a.maxOps = n
+		}
+	}
 }
 
 // NewAggregator creates an aggregator backed by a metrics registry.
-func NewAggregator() *Aggregator {
+func NewAggregator(opts ...AggregatorOption) *Aggregator {
 	reg := metrics.NewRegistry()
-	return &Aggregator{
+	a := &Aggregator{
 		registry: reg,
 		latency:  reg.Histogram("span_latency_ms", latencyBuckets),
 		ops:      make(map[string]*opStats),
+		arms:     make(map[string]map[string]*opStats),
+		maxOps:   DefaultMaxOperations,
+		capped:   reg.Counter("operations_capped_total"),
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
 }
 
 // Observe records a span into the aggregator's metrics.
@@ -55,9 +96,10 @@ func (a *Aggregator) Observe(span protocol.TraceSpan) {
 
 	// Latency in milliseconds.
 	latencyMS := float64(span.EndNS-span.StartNS) / 1_000_000.0
-	a.latency.Observe(latencyMS)
+	a.latency.ObserveExemplar(latencyMS, span.TraceID, span.SpanID)
 
 	// Token counts from attrs.
+	var cost float64
 	if span.Attrs != nil {
 		if v, ok := span.Attrs["tokens_in"]; ok {
 			if f, ok := v.(float64); ok {
@@ -71,6 +113,7 @@ func (a *Aggregator) Observe(span protocol.TraceSpan) {
 		}
 		if v, ok := span.Attrs["cost_usd"]; ok {
 			if f, ok := v.(float64); ok {
+				cost = f
 				a.costMu.Lock()
 				a.totalCostUSD += f
 				a.costMu.Unlock()
@@ -82,16 +125,52 @@ func (a *Aggregator) Observe(span protocol.TraceSpan) {
 	a.opMu.Lock()
 	op, ok := a.ops[span.Operation]
 	if !ok {
-		op = &opStats{}
-		a.ops[span.Operation] = op
+		name := span.Operation
+		// The overflow bucket doesn't count toward the cap.
+		named := len(a.ops)
+		if _, hasOther := a.ops[OtherOperation]; hasOther {
+			named--
+		}
+		if named >= a.maxOps {
+			name = OtherOperation
+			a.capped.Inc()
+		}
+		if op, ok = a.ops[name]; !ok {
+			op = &opStats{}
+			a.ops[name] = op
+		}
 	}
-	op.count++
-	if span.Status == "error" {
-		op.errors++
+	op.add(span.Status, latencyMS, cost)
+	if arm := a.arm(span.Attrs); arm != nil {
+		arm.add(span.Status, latencyMS, cost)
 	}
 	a.opMu.Unlock()
 }
 
+// arm returns the per-arm stats for a span's experiment and arm attrs, or
+// nil if the span is not part of an experiment. opMu must be held.
+func (a *Aggregator) arm(attrs map[string]any) *opStats {
+	exp, _ := attrs["experiment"].(string)
+	name, _ := attrs["arm"].(string)
+	if exp == "" || name == "" {
+		return nil
+	}
+	if op, ok := a.arms[exp][name]; ok {
+		return op
+	}
+	if a.numArms >= a.maxOps {
+		a.capped.Inc()
+		return nil
+	}
+	if a.arms[exp] == nil {
+		a.arms[exp] = make(map[string]*opStats)
+	}
+	op := &opStats{}
+	a.arms[exp][name] = op
+	a.numArms++
+	return op
+}
+
 // Stats returns a point-in-time snapshot of aggregated metrics.
 func (a *Aggregator) Stats() AggregatorStats {
 	total := a.totalSpans.Load()
@@ -111,7 +190,17 @@ func (a *Aggregator) Stats() AggregatorStats {
 	a.opMu.Lock()
 	byOp := make(map[string]OperationStats, len(a.ops))
 	for name, op := range a.ops {
-		byOp[name] = OperationStats{Count: op.count, Errors: op.errors}
+		byOp[name] = op.stats()
+	}
+	var byExp map[string]map[string]OperationStats
+	if len(a.arms) > 0 {
+		byExp = make(map[string]map[string]OperationStats, len(a.arms))
+		for exp, arms := range a.arms {
+			byExp[exp] = make(map[string]OperationStats, len(arms))
+			for name, op := range arms {
+				byExp[exp][name] = op.stats()
+			}
+		}
 	}
 	a.opMu.Unlock()
 
@@ -126,9 +215,65 @@ func (a *Aggregator) Stats() AggregatorStats {
 		TotalTokensOut: a.totalTokenOut.Load(),
 		TotalCostUSD:   cost,
 		ByOperation:    byOp,
+		ByExperiment:   byExp,
+		CappedSpans:    a.capped.Value(),
 	}
 }
 
+// TopOperations returns up to k operations ranked by the given key:
+// "count" (default), "errors", "latency" (average), or "cost" (total).
+// Ties are broken by operation name for stable output.
+func (a *Aggregator) TopOperations(k int, by string) []RankedOperation {
+	a.opMu.Lock()
+	ranked := make([]RankedOperation, 0, len(a.ops))
+	for name, op := range a.ops {
+		ranked = append(ranked, RankedOperation{Operation: name, OperationStats: op.stats()})
+	}
+	a.opMu.Unlock()
+
+	key := func(r RankedOperation) float64 {
+		switch by {
+		case "errors":
+			return float64(r.Errors)
+		case "latency":
+			return r.LatencyAvgMS
+		case "cost":
+			return r.CostUSD
+		default:
+			return float64(r.Count)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		ki, kj := key(ranked[i]), key(ranked[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return ranked[i].Operation < ranked[j].Operation
+	})
+
+	if k > 0 && len(ranked) > k {
+		ranked = ranked[:k]
+	}
+	return ranked
+}
+
+func (op *opStats) add(status string, latencyMS, cost float64) {
+	op.count++
+	if status == "error" {
+		op.errors++
+	}
+	op.latencyMS += latencyMS
+	op.costUSD += cost
+}
+
+func (op *opStats) stats() OperationStats {
+	s := OperationStats{Count: op.count, Errors: op.errors, CostUSD: op.costUSD}
+	if op.count > 0 {
+		s.LatencyAvgMS = op.latencyMS / float64(op.count)
+	}
+	return s
+}
+
 // Registry returns the underlying metrics registry for HTTP exposure.
 func (a *Aggregator) Registry() *metrics.Registry {
 	return a.registry
@@ -146,6 +291,11 @@ type AggregatorStats struct {
 	TotalTokensOut int64                     `json:"total_tokens_out"`
 	TotalCostUSD   float64                   `json:"total_cost_usd"`
 	ByOperation    map[string]OperationStats `json:"by_operation,omitempty"`
+	CappedSpans    int64                     `json:"capped_spans,omitempty"`
+
+	// ByExperiment holds stats per experiment, then per arm, for spans
+	// routed by an InferMux traffic split.
+	ByExperiment map[string]map[string]OperationStats `json:"by_experiment,omitempty"`
 }
 
 // Metric returns the value for a named metric, for use by the alerter.
@@ -168,6 +318,14 @@ func (s AggregatorStats) Metric(name string) float64 {
 
 // OperationStats holds per-operation counters.
 type OperationStats struct {
-	Count  int64 `json:"count"`
-	Errors int64 `json:"errors"`
+	Count        int64   `json:"count"`
+	Errors       int64   `json:"errors"`
+	LatencyAvgMS float64 `json:"latency_avg_ms"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// RankedOperation is an entry in a TopOperations result.
+type RankedOperation struct {
+	Operation string `json:"operation"`
+	OperationStats
 }
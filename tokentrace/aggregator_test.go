@@ -1,6 +1,8 @@
 package tokentrace
 
 import (
+	"fmt"
+	"math"
 	"sync"
 	"testing"
 
@@ -244,3 +246,95 @@ func TestAggregatorMetric(t *testing.T) {
 		t.Error("Metric(unknown) should return 0")
 	}
 }
+
+func TestAggregatorOperationCap(t *testing.T) {
+	agg := NewAggregator(WithMaxOperations(3))
+	for i := 0; i < 10; i++ {
+		agg.Observe(protocol.TraceSpan{
+			Operation: fmt.Sprintf("req-%d", i),
+			StartNS:   0, EndNS: 1_000_000, Status: "ok",
+		})
+	}
+	// A previously seen operation still gets its own bucket.
+	agg.Observe(protocol.TraceSpan{Operation: "req-0", StartNS: 0, EndNS: 1_000_000, Status: "ok"})
+
+	stats := agg.Stats()
+	if len(stats.ByOperation) != 4 {
+		t.Fatalf("ByOperation has %d entries, want 4 (3 + overflow)", len(stats.ByOperation))
+	}
+	if got := stats.ByOperation[OtherOperation].Count; got != 7 {
+		t.Errorf("%s count = %d, want 7", OtherOperation, got)
+	}
+	if got := stats.ByOperation["req-0"].Count; got != 2 {
+		t.Errorf("req-0 count = %d, want 2", got)
+	}
+	if stats.CappedSpans != 7 {
+		t.Errorf("CappedSpans = %d, want 7", stats.CappedSpans)
+	}
+	if v := agg.Registry().Counter("operations_capped_total").Value(); v != 7 {
+		t.Errorf("operations_capped_total = %d, want 7", v)
+	}
+}
+
+func TestAggregatorTopOperations(t *testing.T) {
+	agg := NewAggregator()
+	observe := func(op string, n int, latencyMS int64, cost float64) {
+		for i := 0; i < n; i++ {
+			agg.Observe(protocol.TraceSpan{
+				Operation: op, StartNS: 0, EndNS: latencyMS * 1_000_000, Status: "ok",
+				Attrs: map[string]any{"cost_usd": cost},
+			})
+		}
+	}
+	observe("frequent", 10, 1, 0.001)
+	observe("slow", 2, 500, 0.01)
+	observe("pricey", 1, 10, 1.0)
+
+	tests := []struct {
+		by   string
+		want string
+	}{
+		{"count", "frequent"},
+		{"latency", "slow"},
+		{"cost", "pricey"},
+	}
+	for _, tt := range tests {
+		top := agg.TopOperations(1, tt.by)
+		if len(top) != 1 || top[0].Operation != tt.want {
+			t.Errorf("TopOperations(1, %q) = %+v, want %s", tt.by, top, tt.want)
+		}
+	}
+
+	if all := agg.TopOperations(0, "count"); len(all) != 3 {
+		t.Errorf("TopOperations(0) = %d entries, want 3", len(all))
+	}
+}
+
+func TestAggregatorByExperiment(t *testing.T) {
+	agg := NewAggregator()
+	arm := func(name, status string, ms int64, cost float64) protocol.TraceSpan {
+		return protocol.TraceSpan{
+			Operation: "infermux.infer", StartNS: 0, EndNS: ms * 1_000_000, Status: status,
+			Attrs: map[string]any{"experiment": "canary", "arm": name, "cost_usd": cost},
+		}
+	}
+	agg.Observe(arm("control", "ok", 100, 0.01))
+	agg.Observe(arm("control", "ok", 300, 0.01))
+	agg.Observe(arm("new", "error", 50, 0.002))
+	agg.Observe(protocol.TraceSpan{Operation: "infermux.infer", EndNS: 1_000_000, Status: "ok"})
+
+	stats := agg.Stats()
+	if len(stats.ByExperiment) != 1 || len(stats.ByExperiment["canary"]) != 2 {
+		t.Fatalf("ByExperiment = %+v", stats.ByExperiment)
+	}
+	control := stats.ByExperiment["canary"]["control"]
+	if control.Count != 2 || control.LatencyAvgMS != 200 || math.Abs(control.CostUSD-0.02) > 1e-9 {
+		t.Errorf("control = %+v", control)
+	}
+	if n := stats.ByExperiment["canary"]["new"]; n.Count != 1 || n.Errors != 1 {
+		t.Errorf("new = %+v", n)
+	}
+	if stats.ByOperation["infermux.infer"].Count != 4 {
+		t.Errorf("operation count = %d", stats.ByOperation["infermux.infer"].Count)
+	}
+}
@@ -8,15 +8,44 @@ import (
 	"github.com/greynewell/mist-go/protocol"
 )
 
+// maxAlertHistory bounds the resolved alerts kept for GET /alerts.
+const maxAlertHistory = 100
+
+// Alert states.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertStatus is one episode of a rule breaching its threshold, from the
+// check that first saw the breach to the check that saw it clear.
+type AlertStatus struct {
+	Rule       string    `json:"rule"` // e.g. "error_rate > 0.1"
+	Metric     string    `json:"metric"`
+	Level      string    `json:"level"`
+	State      string    `json:"state"`
+	Value      float64   `json:"value"` // the latest value seen
+	Threshold  float64   `json:"threshold"`
+	FiredAt    time.Time `json:"fired_at"`
+	ResolvedAt time.Time `json:"resolved_at,omitzero"`
+	Silenced   bool      `json:"silenced,omitempty"`
+}
+
 // Alerter evaluates alert rules against aggregated stats and emits
 // TraceAlert payloads when thresholds are breached. Each rule has an
-// independent cooldown to prevent alert storms.
+// independent cooldown to prevent alert storms. The alerter also tracks
+// which rules are firing, a history of resolved alerts, and silences
+// that mute matching rules for a while.
 type Alerter struct {
 	rules    []AlertRule
 	cooldown time.Duration
+	now      func() time.Time
 
 	mu       sync.Mutex
-	lastFire map[int]time.Time // rule index → last fire time
+	lastFire map[int]time.Time    // rule index → last fire time
+	active   map[int]*AlertStatus // rule index → firing alert
+	history  []AlertStatus        // resolved alerts, oldest first
+	silences []Silence
 }
 
 // NewAlerter creates an alerter with the given rules and cooldown period.
@@ -24,31 +53,27 @@ func NewAlerter(rules []AlertRule, cooldown time.Duration) *Alerter {
 	return &Alerter{
 		rules:    rules,
 		cooldown: cooldown,
+		now:      time.Now,
 		lastFire: make(map[int]time.Time),
+		active:   make(map[int]*AlertStatus),
 	}
 }
 
 // Check evaluates all rules against the current stats and returns any
-// triggered alerts. Rules within their cooldown period are suppressed.
+// triggered alerts. Rules within their cooldown period or matched by a
+// silence are suppressed, but their state is still tracked.
 func (a *Alerter) Check(stats AggregatorStats) []protocol.TraceAlert {
 	if len(a.rules) == 0 {
 		return nil
 	}
 
-	now := time.Now()
 	var alerts []protocol.TraceAlert
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	now := a.now()
 
 	for i, rule := range a.rules {
-		// Check cooldown.
-		if last, ok := a.lastFire[i]; ok {
-			if now.Sub(last) < a.cooldown {
-				continue
-			}
-		}
-
 		value := stats.Metric(rule.Metric)
 		fired := false
 
@@ -59,17 +84,76 @@ func (a *Alerter) Check(stats AggregatorStats) []protocol.TraceAlert {
 			fired = value < rule.Threshold
 		}
 
-		if fired {
-			a.lastFire[i] = now
-			alerts = append(alerts, protocol.TraceAlert{
-				Level:     rule.Level,
+		st := a.active[i]
+		if !fired {
+			if st != nil {
+				st.State = AlertResolved
+				st.Value = value
+				st.ResolvedAt = now
+				a.resolve(i)
+			}
+			continue
+		}
+		if st == nil {
+			st = &AlertStatus{
+				Rule:      rule.String(),
 				Metric:    rule.Metric,
-				Value:     value,
+				Level:     rule.Level,
+				State:     AlertFiring,
 				Threshold: rule.Threshold,
-				Message:   fmt.Sprintf("%s %s %.4g (threshold: %.4g)", rule.Metric, rule.Op, value, rule.Threshold),
-			})
+				FiredAt:   now,
+			}
+			a.active[i] = st
 		}
+		st.Value = value
+		st.Silenced = a.silenced(rule, now)
+		if st.Silenced {
+			continue
+		}
+
+		// Check cooldown.
+		if last, ok := a.lastFire[i]; ok {
+			if now.Sub(last) < a.cooldown {
+				continue
+			}
+		}
+
+		a.lastFire[i] = now
+		alerts = append(alerts, protocol.TraceAlert{
+			Level:     rule.Level,
+			Metric:    rule.Metric,
+			Value:     value,
+			Threshold: rule.Threshold,
+			Message:   fmt.Sprintf("%s %s %.4g (threshold: %.4g)", rule.Metric, rule.Op, value, rule.Threshold),
+		})
 	}
 
 	return alerts
 }
+
+// resolve moves rule i's alert to the history. The caller holds a.mu.
+func (a *Alerter) resolve(i int) {
+	a.history = append(a.history, *a.active[i])
+	if len(a.history) > maxAlertHistory {
+		a.history = a.history[len(a.history)-maxAlertHistory:]
+	}
+	delete(a.active, i)
+}
+
+// Alerts returns the firing alerts, in rule order, and the most recently
+// resolved ones, oldest first.
+func (a *Alerter) Alerts() (active, history []AlertStatus) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+
+	active = []AlertStatus{}
+	for i, rule := range a.rules {
+		if st, ok := a.active[i]; ok {
+			s := *st
+			s.Silenced = a.silenced(rule, now)
+			active = append(active, s)
+		}
+	}
+	return active, append([]AlertStatus{}, a.history...)
+}
@@ -0,0 +1,222 @@
+package tokentrace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/greynewell/mist-go/resource"
+)
+
+// Autoscale defaults.
+const (
+	DefaultAutoscaleInterval = 30 * time.Second
+	DefaultAutoscaleHigh     = 0.85
+	DefaultAutoscaleLow      = 0.6
+	DefaultAutoscaleStep     = 0.25
+)
+
+// AutoscaleConfig grows and shrinks the span store within bounds to keep
+// memory use under a budget. It is off unless MemoryLimit is set.
+type AutoscaleConfig struct {
+	// MinSpans and MaxSpans bound the store's capacity. Zero means 1 and
+	// the configured max_spans respectively.
+	MinSpans int `toml:"min_spans"`
+	MaxSpans int `toml:"max_spans"`
+
+	// MemoryLimit is the memory budget, in bytes, that the process's heap
+	// is measured against.
+	MemoryLimit int64 `toml:"memory_limit"`
+
+	// Interval is how often memory use is checked.
+	Interval time.Duration `toml:"interval"`
+
+	// The store shrinks by Step of its capacity while heap use is above
+	// High of the budget, and grows by Step while it is full and heap use
+	// is below Low.
+	High float64 `toml:"high"`
+	Low  float64 `toml:"low"`
+	Step float64 `toml:"step"`
+}
+
+func (c AutoscaleConfig) enabled() bool { return c.MemoryLimit > 0 }
+
+// withDefaults fills in unset fields, taking the bound on MaxSpans from
+// the store's configured capacity.
+func (c AutoscaleConfig) withDefaults(maxSpans int) AutoscaleConfig {
+	c.MinSpans = max(c.MinSpans, 1)
+	if c.MaxSpans == 0 {
+		c.MaxSpans = max(maxSpans, c.MinSpans)
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultAutoscaleInterval
+	}
+	if c.High == 0 {
+		c.High = DefaultAutoscaleHigh
+	}
+	if c.Low == 0 {
+		c.Low = DefaultAutoscaleLow
+	}
+	if c.Step == 0 {
+		c.Step = DefaultAutoscaleStep
+	}
+	return c
+}
+
+// Validate checks that the config is well-formed.
+func (c *AutoscaleConfig) Validate() error {
+	if c.MemoryLimit < 0 {
+		return fmt.Errorf("memory_limit must be >= 0 (got %d)", c.MemoryLimit)
+	}
+	if c.MinSpans < 0 || c.MaxSpans < 0 {
+		return fmt.Errorf("min_spans and max_spans must be >= 0")
+	}
+	if c.MaxSpans > 0 && c.MinSpans > c.MaxSpans {
+		return fmt.Errorf("min_spans (%d) exceeds max_spans (%d)", c.MinSpans, c.MaxSpans)
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must be >= 0")
+	}
+	d := c.withDefaults(0)
+	if d.High > 1 || d.Low < 0 || d.Low >= d.High {
+		return fmt.Errorf("need 0 <= low < high <= 1 (got low %g, high %g)", d.Low, d.High)
+	}
+	if d.Step < 0 || d.Step >= 1 {
+		return fmt.Errorf("step must be in [0, 1) (got %g)", d.Step)
+	}
+	return nil
+}
+
+// Autoscaler resizes a Store to keep memory use within a budget. Call
+// Step periodically, or Run.
+type Autoscaler struct {
+	store  *Store
+	budget *resource.MemoryBudget
+	cfg    AutoscaleConfig
+
+	// usage reports the bytes in use; resource.HeapUsage by default.
+	usage func() int64
+
+	// OnResize, if set, is called after each resize with the old and
+	// new capacity.
+	OnResize func(from, to int)
+}
+
+// NewAutoscaler returns an autoscaler for s. maxSpans bounds the store's
+// growth when cfg sets no MaxSpans of its own.
+func NewAutoscaler(s *Store, cfg AutoscaleConfig, maxSpans int) *Autoscaler {
+	return &Autoscaler{
+		store:  s,
+		budget: resource.NewMemoryBudget("tokentrace_store", cfg.MemoryLimit),
+		cfg:    cfg.withDefaults(maxSpans),
+		usage:  resource.HeapUsage,
+	}
+}
+
+// Budget returns the memory budget heap use is measured against. Bytes
+// reserved from it, for example by resource.Admission for requests in
+// flight, count towards the pressure as well.
+func (a *Autoscaler) Budget() *resource.MemoryBudget { return a.budget }
+
+// Pressure returns the fraction of the memory budget in use.
+func (a *Autoscaler) Pressure() float64 {
+	limit := a.budget.Limit()
+	if limit <= 0 {
+		return 0
+	}
+	return float64(a.usage()+a.budget.Reserved()) / float64(limit)
+}
+
+// Step checks memory pressure once and resizes the store if needed. It
+// returns the store's capacity afterwards.
+func (a *Autoscaler) Step() int {
+	cur := a.store.Cap()
+	next := cur
+	switch p := a.Pressure(); {
+	case p > a.cfg.High:
+		next = cur - max(int(float64(cur)*a.cfg.Step), 1)
+	case p < a.cfg.Low && a.store.Len() >= cur:
+		// Only grow a full store: an emptier one has room already.
+		next = cur + max(int(float64(cur)*a.cfg.Step), 1)
+	}
+	next = min(max(next, a.cfg.MinSpans), a.cfg.MaxSpans)
+	if next != cur {
+		a.store.Resize(next)
+		if a.OnResize != nil {
+			a.OnResize(cur, next)
+		}
+	}
+	return next
+}
+
+// Run calls Step every interval until ctx is done.
+func (a *Autoscaler) Run(ctx context.Context) {
+	t := time.NewTicker(a.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			a.Step()
+		}
+	}
+}
+
+// Autoscaler returns the store autoscaler, or nil if Config.Autoscale is
+// off. The handler doesn't run it; whoever serves the handler calls Run,
+// as mist serve does.
+func (h *Handler) Autoscaler() *Autoscaler { return h.autoscale }
+
+// StoreStatus is the body of GET and PUT /store.
+type StoreStatus struct {
+	Capacity  int  `json:"capacity"`
+	Spans     int  `json:"spans"`
+	Autoscale bool `json:"autoscale"`
+
+	// The autoscaler's bounds and current memory pressure, when on.
+	MinSpans int     `json:"min_spans,omitempty"`
+	MaxSpans int     `json:"max_spans,omitempty"`
+	Pressure float64 `json:"pressure,omitempty"`
+}
+
+// StoreCapacity handles GET /store, reporting the store's capacity, and
+// PUT /store with {"capacity": n}, resizing it. Shrinking evicts the
+// oldest spans. With autoscaling on, the autoscaler carries on from the
+// new capacity, so n must be within its bounds.
+func (h *Handler) StoreCapacity(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Capacity int `json:"capacity"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Capacity <= 0 {
+			http.Error(w, "capacity must be > 0", http.StatusBadRequest)
+			return
+		}
+		if a := h.autoscale; a != nil && (req.Capacity < a.cfg.MinSpans || req.Capacity > a.cfg.MaxSpans) {
+			http.Error(w, fmt.Sprintf("capacity must be within the autoscale bounds [%d, %d]", a.cfg.MinSpans, a.cfg.MaxSpans), http.StatusBadRequest)
+			return
+		}
+		h.store.Resize(req.Capacity)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := StoreStatus{Capacity: h.store.Cap(), Spans: h.store.Len()}
+	if a := h.autoscale; a != nil {
+		status.Autoscale = true
+		status.MinSpans, status.MaxSpans = a.cfg.MinSpans, a.cfg.MaxSpans
+		status.Pressure = a.Pressure()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
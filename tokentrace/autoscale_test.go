@@ -0,0 +1,111 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAutoscalerStep(t *testing.T) {
+	s := NewStore(100)
+	a := NewAutoscaler(s, AutoscaleConfig{MinSpans: 60, MemoryLimit: 1000, Step: 0.25}, 150)
+	var heap int64
+	a.usage = func() int64 { return heap }
+	var resizes []string
+	a.OnResize = func(from, to int) { resizes = append(resizes, fmt.Sprintf("%d->%d", from, to)) }
+
+	heap = 500 // below low, but the store has room
+	if got := a.Step(); got != 100 {
+		t.Errorf("grew a store with room to %d", got)
+	}
+	for i := range 100 {
+		s.Add(span("t", fmt.Sprint(i), "op", int64(i), int64(i+1)))
+	}
+	if got := a.Step(); got != 125 {
+		t.Errorf("full store under low pressure = %d, want 125", got)
+	}
+	for i := range 25 {
+		s.Add(span("t", fmt.Sprint(100+i), "op", int64(100+i), int64(101+i)))
+	}
+	if got := a.Step(); got != 150 {
+		t.Errorf("growth = %d, want capped at max_spans 150", got)
+	}
+
+	heap = 700 // between low and high: hold
+	if got := a.Step(); got != 150 {
+		t.Errorf("capacity = %d between thresholds, want 150", got)
+	}
+
+	heap = 900
+	if got := a.Step(); got != 113 || s.Len() != 113 {
+		t.Errorf("capacity, len = %d, %d under high pressure, want 113", got, s.Len())
+	}
+	a.Step()
+	a.Step()
+	if got := a.Step(); got != 60 {
+		t.Errorf("shrink = %d, want floored at min_spans 60", got)
+	}
+	// Reservations from the budget count towards the pressure.
+	heap = 0
+	a.Budget().Reserve(950)
+	if got := a.Step(); got != 60 {
+		t.Errorf("grew to %d with the budget reserved", got)
+	}
+
+	want := "100->125 125->150 150->113 113->85 85->64 64->60"
+	if strings.Join(resizes, " ") != want {
+		t.Errorf("resizes = %v, want %s", resizes, want)
+	}
+}
+
+func TestAutoscaleConfigValidate(t *testing.T) {
+	for _, c := range []AutoscaleConfig{
+		{MemoryLimit: -1},
+		{MemoryLimit: 1, MinSpans: 10, MaxSpans: 5},
+		{MemoryLimit: 1, Low: 0.9},
+		{MemoryLimit: 1, High: 1.5},
+		{MemoryLimit: 1, Step: 1},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+	for _, c := range []AutoscaleConfig{{}, {MemoryLimit: 1 << 30, MinSpans: 1000, High: 0.9, Low: 0.5}} {
+		if err := c.Validate(); err != nil {
+			t.Errorf("%+v: %v", c, err)
+		}
+	}
+}
+
+func TestStoreCapacityHandler(t *testing.T) {
+	h := NewHandler(Config{MaxSpans: 10, Autoscale: AutoscaleConfig{MemoryLimit: 1 << 40, MinSpans: 2}})
+	for i := range 10 {
+		h.store.Add(span("t", fmt.Sprint(i), "op", int64(i), int64(i+1)))
+	}
+
+	do := func(method, body string) (*httptest.ResponseRecorder, StoreStatus) {
+		w := httptest.NewRecorder()
+		h.StoreCapacity(w, httptest.NewRequest(method, "/store", strings.NewReader(body)))
+		var st StoreStatus
+		json.NewDecoder(w.Body).Decode(&st)
+		return w, st
+	}
+
+	if w, st := do(http.MethodGet, ""); w.Code != http.StatusOK || st.Capacity != 10 || !st.Autoscale || st.MaxSpans != 10 {
+		t.Fatalf("GET /store = %d %+v", w.Code, st)
+	}
+	if w, st := do(http.MethodPut, `{"capacity":4}`); w.Code != http.StatusOK || st.Capacity != 4 || st.Spans != 4 {
+		t.Errorf("PUT /store = %d %+v, want capacity 4", w.Code, st)
+	}
+	for _, body := range []string{`{"capacity":0}`, `{"capacity":11}`, `{"capacity":1}`, `nope`} {
+		if w, _ := do(http.MethodPut, body); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status %d, want 400", body, w.Code)
+		}
+	}
+	if w, _ := do(http.MethodPost, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d", w.Code)
+	}
+}
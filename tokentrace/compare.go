@@ -0,0 +1,181 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// TraceComparison describes how two traces differ. Spans are aligned by
+// operation name; when an operation occurs several times in a trace, its
+// occurrences are paired in start-time order.
+type TraceComparison struct {
+	TraceA         string      `json:"trace_a"`
+	TraceB         string      `json:"trace_b"`
+	Spans          []SpanDelta `json:"spans"`
+	OnlyInA        []string    `json:"only_in_a,omitempty"`
+	OnlyInB        []string    `json:"only_in_b,omitempty"`
+	LatencyDeltaMS float64     `json:"latency_delta_ms"`
+	CostDeltaUSD   float64     `json:"cost_delta_usd"`
+}
+
+// SpanDelta compares one aligned pair of spans. Deltas are B minus A.
+type SpanDelta struct {
+	Operation      string  `json:"operation"`
+	Occurrence     int     `json:"occurrence"`
+	LatencyAMS     float64 `json:"latency_a_ms"`
+	LatencyBMS     float64 `json:"latency_b_ms"`
+	LatencyDeltaMS float64 `json:"latency_delta_ms"`
+	CostAUSD       float64 `json:"cost_a_usd"`
+	CostBUSD       float64 `json:"cost_b_usd"`
+	CostDeltaUSD   float64 `json:"cost_delta_usd"`
+	StatusA        string  `json:"status_a"`
+	StatusB        string  `json:"status_b"`
+	ParentOpA      string  `json:"parent_op_a,omitempty"`
+	ParentOpB      string  `json:"parent_op_b,omitempty"`
+}
+
+// StatusChanged reports whether the two spans finished with different statuses.
+func (d SpanDelta) StatusChanged() bool {
+	return d.StatusA != d.StatusB
+}
+
+// Reparented reports whether the span hangs off a different parent
+// operation in each trace.
+func (d SpanDelta) Reparented() bool {
+	return d.ParentOpA != d.ParentOpB
+}
+
+// CompareTraces aligns the spans of two traces by operation and reports
+// per-span latency and cost deltas plus operations present in only one
+// trace. Totals cover all spans, including unaligned ones.
+func CompareTraces(traceA, traceB string, a, b []protocol.TraceSpan) TraceComparison {
+	cmp := TraceComparison{
+		TraceA: traceA,
+		TraceB: traceB,
+		Spans:  []SpanDelta{},
+	}
+
+	byOpA := groupByOperation(a)
+	byOpB := groupByOperation(b)
+	parentsA := parentOperations(a)
+	parentsB := parentOperations(b)
+
+	ops := make([]string, 0, len(byOpA)+len(byOpB))
+	for op := range byOpA {
+		ops = append(ops, op)
+	}
+	for op := range byOpB {
+		if _, ok := byOpA[op]; !ok {
+			ops = append(ops, op)
+		}
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		sa, sb := byOpA[op], byOpB[op]
+		n := len(sa)
+		if len(sb) < n {
+			n = len(sb)
+		}
+		for i := 0; i < n; i++ {
+			d := SpanDelta{
+				Operation:  op,
+				Occurrence: i,
+				LatencyAMS: spanLatencyMS(sa[i]),
+				LatencyBMS: spanLatencyMS(sb[i]),
+				CostAUSD:   spanCostUSD(sa[i]),
+				CostBUSD:   spanCostUSD(sb[i]),
+				StatusA:    sa[i].Status,
+				StatusB:    sb[i].Status,
+				ParentOpA:  parentsA[sa[i].ParentID],
+				ParentOpB:  parentsB[sb[i].ParentID],
+			}
+			d.LatencyDeltaMS = d.LatencyBMS - d.LatencyAMS
+			d.CostDeltaUSD = d.CostBUSD - d.CostAUSD
+			cmp.Spans = append(cmp.Spans, d)
+		}
+		for i := n; i < len(sa); i++ {
+			cmp.OnlyInA = append(cmp.OnlyInA, op)
+		}
+		for i := n; i < len(sb); i++ {
+			cmp.OnlyInB = append(cmp.OnlyInB, op)
+		}
+	}
+
+	for _, s := range a {
+		cmp.LatencyDeltaMS -= spanLatencyMS(s)
+		cmp.CostDeltaUSD -= spanCostUSD(s)
+	}
+	for _, s := range b {
+		cmp.LatencyDeltaMS += spanLatencyMS(s)
+		cmp.CostDeltaUSD += spanCostUSD(s)
+	}
+
+	return cmp
+}
+
+// Compare handles GET /traces/compare?a=<id>&b=<id> — diffs two traces.
+func (h *Handler) Compare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idA := r.URL.Query().Get("a")
+	idB := r.URL.Query().Get("b")
+	if idA == "" || idB == "" {
+		http.Error(w, "query parameters a and b are required", http.StatusBadRequest)
+		return
+	}
+
+	spansA := h.store.GetTrace(idA)
+	if len(spansA) == 0 {
+		http.Error(w, "trace not found: "+idA, http.StatusNotFound)
+		return
+	}
+	spansB := h.store.GetTrace(idB)
+	if len(spansB) == 0 {
+		http.Error(w, "trace not found: "+idB, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CompareTraces(idA, idB, spansA, spansB))
+}
+
+// groupByOperation buckets spans by operation, each bucket sorted by start time.
+func groupByOperation(spans []protocol.TraceSpan) map[string][]protocol.TraceSpan {
+	m := make(map[string][]protocol.TraceSpan)
+	for _, s := range spans {
+		m[s.Operation] = append(m[s.Operation], s)
+	}
+	for _, group := range m {
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].StartNS < group[j].StartNS
+		})
+	}
+	return m
+}
+
+// parentOperations maps span ID → operation for resolving parent names.
+func parentOperations(spans []protocol.TraceSpan) map[string]string {
+	m := make(map[string]string, len(spans))
+	for _, s := range spans {
+		m[s.SpanID] = s.Operation
+	}
+	return m
+}
+
+func spanLatencyMS(s protocol.TraceSpan) float64 {
+	return float64(s.EndNS-s.StartNS) / 1_000_000.0
+}
+
+func spanCostUSD(s protocol.TraceSpan) float64 {
+	if f, ok := s.Attrs["cost_usd"].(float64); ok {
+		return f
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestCompareTracesAligned(t *testing.T) {
+	a := []protocol.TraceSpan{
+		{TraceID: "a", SpanID: "a1", Operation: "root", StartNS: 0, EndNS: 100_000_000, Status: "ok"},
+		{TraceID: "a", SpanID: "a2", ParentID: "a1", Operation: "infer", StartNS: 0, EndNS: 40_000_000, Status: "ok",
+			Attrs: map[string]any{"cost_usd": 0.01}},
+	}
+	b := []protocol.TraceSpan{
+		{TraceID: "b", SpanID: "b1", Operation: "root", StartNS: 0, EndNS: 120_000_000, Status: "ok"},
+		{TraceID: "b", SpanID: "b2", ParentID: "b1", Operation: "infer", StartNS: 0, EndNS: 70_000_000, Status: "error",
+			Attrs: map[string]any{"cost_usd": 0.03}},
+	}
+
+	cmp := CompareTraces("a", "b", a, b)
+	if len(cmp.Spans) != 2 {
+		t.Fatalf("Spans = %d, want 2", len(cmp.Spans))
+	}
+	if len(cmp.OnlyInA) != 0 || len(cmp.OnlyInB) != 0 {
+		t.Errorf("unexpected structural diff: %v / %v", cmp.OnlyInA, cmp.OnlyInB)
+	}
+
+	infer := cmp.Spans[0]
+	if infer.Operation != "infer" {
+		t.Fatalf("Spans[0].Operation = %q, want infer", infer.Operation)
+	}
+	if infer.LatencyDeltaMS != 30 {
+		t.Errorf("LatencyDeltaMS = %g, want 30", infer.LatencyDeltaMS)
+	}
+	if infer.CostDeltaUSD < 0.0199 || infer.CostDeltaUSD > 0.0201 {
+		t.Errorf("CostDeltaUSD = %g, want 0.02", infer.CostDeltaUSD)
+	}
+	if !infer.StatusChanged() {
+		t.Error("expected StatusChanged")
+	}
+	if infer.Reparented() {
+		t.Errorf("unexpected reparent: %q vs %q", infer.ParentOpA, infer.ParentOpB)
+	}
+	if cmp.LatencyDeltaMS != 50 {
+		t.Errorf("total LatencyDeltaMS = %g, want 50", cmp.LatencyDeltaMS)
+	}
+}
+
+func TestCompareTracesStructural(t *testing.T) {
+	a := []protocol.TraceSpan{
+		{SpanID: "a1", Operation: "infer", StartNS: 0, EndNS: 10},
+		{SpanID: "a2", Operation: "infer", StartNS: 20, EndNS: 30},
+		{SpanID: "a3", Operation: "retrieve", StartNS: 0, EndNS: 10},
+	}
+	b := []protocol.TraceSpan{
+		{SpanID: "b1", Operation: "infer", StartNS: 0, EndNS: 10},
+		{SpanID: "b2", Operation: "rerank", StartNS: 0, EndNS: 10},
+	}
+
+	cmp := CompareTraces("a", "b", a, b)
+	if len(cmp.Spans) != 1 {
+		t.Errorf("Spans = %d, want 1", len(cmp.Spans))
+	}
+	if len(cmp.OnlyInA) != 2 || cmp.OnlyInA[0] != "infer" || cmp.OnlyInA[1] != "retrieve" {
+		t.Errorf("OnlyInA = %v, want [infer retrieve]", cmp.OnlyInA)
+	}
+	if len(cmp.OnlyInB) != 1 || cmp.OnlyInB[0] != "rerank" {
+		t.Errorf("OnlyInB = %v, want [rerank]", cmp.OnlyInB)
+	}
+}
+
+func TestHandlerCompare(t *testing.T) {
+	h := newTestHandler()
+	postSpan(t, h, protocol.TraceSpan{
+		TraceID: "t1", SpanID: "s1", Operation: "infer",
+		StartNS: 0, EndNS: 5_000_000, Status: "ok",
+	})
+	postSpan(t, h, protocol.TraceSpan{
+		TraceID: "t2", SpanID: "s2", Operation: "infer",
+		StartNS: 0, EndNS: 8_000_000, Status: "ok",
+	})
+
+	req := httptest.NewRequest("GET", "/traces/compare?a=t1&b=t2", nil)
+	w := httptest.NewRecorder()
+	h.Compare(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var resp TraceComparison
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Spans) != 1 || resp.Spans[0].LatencyDeltaMS != 3 {
+		t.Errorf("unexpected comparison: %+v", resp)
+	}
+}
+
+func TestHandlerCompareErrors(t *testing.T) {
+	h := newTestHandler()
+	postSpan(t, h, protocol.TraceSpan{
+		TraceID: "t1", SpanID: "s1", Operation: "infer",
+		StartNS: 0, EndNS: 5_000_000, Status: "ok",
+	})
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusBadRequest},
+		{"?a=t1", http.StatusBadRequest},
+		{"?a=t1&b=missing", http.StatusNotFound},
+		{"?a=missing&b=t1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/traces/compare"+tt.query, nil)
+		w := httptest.NewRecorder()
+		h.Compare(w, req)
+		if w.Code != tt.want {
+			t.Errorf("query %q: status = %d, want %d", tt.query, w.Code, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.Compare(w, httptest.NewRequest("POST", "/traces/compare?a=t1&b=t1", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", w.Code)
+	}
+}
@@ -14,6 +14,56 @@ type Config struct {
 	MaxSpans      int           `toml:"max_spans"`
 	AlertCooldown time.Duration `toml:"alert_cooldown"`
 	AlertRules    []AlertRule   `toml:"alert_rules"`
+
+	// IndexedAttrs lists span attribute keys to index for GET /spans
+	// lookups (e.g. "model", "tenant", "user_id").
+	IndexedAttrs []string `toml:"indexed_attrs"`
+
+	// MaxOperations caps distinct operation names in the per-operation
+	// breakdown; the rest are counted under "__other__". 0 means
+	// DefaultMaxOperations.
+	MaxOperations int `toml:"max_operations"`
+
+	// Retention expires spans by age, per tenant (the "tenant" attr). A
+	// rule with an empty tenant applies to tenants without their own rule.
+	// Spans are kept until evicted when no rule applies.
+	Retention []RetentionRule `toml:"retention"`
+
+	// Dedup drops spans whose (trace_id, span_id) was already ingested
+	// within DedupWindow, so spans redelivered by a retrying reporter
+	// aren't counted twice. Duplicates are counted in
+	// spans_duplicate_total. At most MaxSpans IDs are remembered. Off
+	// by default.
+	Dedup       bool          `toml:"dedup"`
+	DedupWindow time.Duration `toml:"dedup_window"`
+
+	// MaxConcurrentPerSource and MaxConcurrentPerOperation cap the spans
+	// ingested at once from one message source and for one operation;
+	// spans over either limit are refused with 429 so the sender backs
+	// off. Admitted and refused spans are counted per source and
+	// operation in ingest_admitted_total and ingest_throttled_total.
+	// Zero means unlimited, the default.
+	MaxConcurrentPerSource    int `toml:"max_concurrent_per_source"`
+	MaxConcurrentPerOperation int `toml:"max_concurrent_per_operation"`
+
+	// Enrich tags, renames, and normalizes span attrs at ingest, and
+	// derives missing costs from a pricing table.
+	Enrich EnrichConfig `toml:"enrich"`
+
+	// Autoscale resizes the span store at runtime to keep memory use
+	// under a budget, starting from MaxSpans. Off by default.
+	Autoscale AutoscaleConfig `toml:"autoscale"`
+
+	// DeleteToken enables DELETE /traces and DELETE /spans for requests
+	// carrying it as a bearer token. Without it they are refused with
+	// 403. Off by default.
+	DeleteToken string `toml:"delete_token"`
+}
+
+// RetentionRule sets how long a tenant's spans are kept.
+type RetentionRule struct {
+	Tenant string        `toml:"tenant"`
+	MaxAge time.Duration `toml:"max_age"`
 }
 
 // AlertRule defines a threshold that triggers an alert.
@@ -30,6 +80,8 @@ func DefaultConfig() Config {
 		Addr:          ":8700",
 		MaxSpans:      100_000,
 		AlertCooldown: 5 * time.Minute,
+		MaxOperations: DefaultMaxOperations,
+		DedupWindow:   DefaultDedupWindow,
 	}
 }
 
@@ -44,6 +96,34 @@ func (c *Config) Validate() error {
 	if c.AlertCooldown <= 0 {
 		return fmt.Errorf("tokentrace: alert_cooldown must be > 0")
 	}
+	if c.MaxOperations < 0 {
+		return fmt.Errorf("tokentrace: max_operations must be >= 0 (got %d)", c.MaxOperations)
+	}
+	if c.Dedup && c.DedupWindow <= 0 {
+		return fmt.Errorf("tokentrace: dedup_window must be > 0 when dedup is on")
+	}
+	if c.MaxConcurrentPerSource < 0 {
+		return fmt.Errorf("tokentrace: max_concurrent_per_source must be >= 0 (got %d)", c.MaxConcurrentPerSource)
+	}
+	if c.MaxConcurrentPerOperation < 0 {
+		return fmt.Errorf("tokentrace: max_concurrent_per_operation must be >= 0 (got %d)", c.MaxConcurrentPerOperation)
+	}
+	if err := c.Enrich.Validate(); err != nil {
+		return fmt.Errorf("tokentrace: enrich: %w", err)
+	}
+	if err := c.Autoscale.Validate(); err != nil {
+		return fmt.Errorf("tokentrace: autoscale: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i, rule := range c.Retention {
+		if rule.MaxAge <= 0 {
+			return fmt.Errorf("tokentrace: retention[%d]: max_age must be > 0", i)
+		}
+		if seen[rule.Tenant] {
+			return fmt.Errorf("tokentrace: retention[%d]: duplicate tenant %q", i, rule.Tenant)
+		}
+		seen[rule.Tenant] = true
+	}
 	for i := range c.AlertRules {
 		if err := c.AlertRules[i].Validate(); err != nil {
 			return fmt.Errorf("tokentrace: alert_rules[%d]: %w", i, err)
@@ -65,3 +145,8 @@ func (r *AlertRule) Validate() error {
 	}
 	return nil
 }
+
+// String formats the rule's condition, e.g. "error_rate > 0.1".
+func (r AlertRule) String() string {
+	return fmt.Sprintf("%s %s %g", r.Metric, r.Op, r.Threshold)
+}
@@ -31,6 +31,21 @@ func TestConfigValidation(t *testing.T) {
 		{"zero cooldown", func(c *Config) { c.AlertCooldown = 0 }, true},
 		{"custom addr", func(c *Config) { c.Addr = ":9090" }, false},
 		{"large max spans", func(c *Config) { c.MaxSpans = 10_000_000 }, false},
+		{"zero max operations", func(c *Config) { c.MaxOperations = 0 }, false},
+		{"negative max operations", func(c *Config) { c.MaxOperations = -1 }, true},
+		{"retention", func(c *Config) {
+			c.Retention = []RetentionRule{{MaxAge: time.Hour}, {Tenant: "acme", MaxAge: time.Minute}}
+		}, false},
+		{"zero retention", func(c *Config) { c.Retention = []RetentionRule{{Tenant: "acme"}} }, true},
+		{"duplicate retention", func(c *Config) { c.Retention = []RetentionRule{{MaxAge: time.Hour}, {MaxAge: time.Minute}} }, true},
+		{"dedup", func(c *Config) { c.Dedup = true }, false},
+		{"dedup zero window", func(c *Config) { c.Dedup, c.DedupWindow = true, 0 }, true},
+		{"dedup off zero window", func(c *Config) { c.DedupWindow = 0 }, false},
+		{"concurrency limits", func(c *Config) { c.MaxConcurrentPerSource, c.MaxConcurrentPerOperation = 8, 4 }, false},
+		{"negative source limit", func(c *Config) { c.MaxConcurrentPerSource = -1 }, true},
+		{"negative operation limit", func(c *Config) { c.MaxConcurrentPerOperation = -1 }, true},
+		{"enrich", func(c *Config) { c.Enrich.Tags = map[string]string{"region": "us-east-1"} }, false},
+		{"invalid enrich", func(c *Config) { c.Enrich.Rename = map[string]string{"a": "a"} }, true},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,76 @@
+package tokentrace
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// DefaultDedupWindow is how long an ingested span's (trace ID, span ID)
+// is remembered for deduplication by default.
+const DefaultDedupWindow = 10 * time.Minute
+
+// spanKey identifies a span for deduplication.
+type spanKey struct{ traceID, spanID string }
+
+type seenSpan struct {
+	key spanKey
+	at  time.Time
+}
+
+// spanDedup remembers the spans ingested within a sliding window, so a
+// span a reporter or exporter delivers twice after a retry is counted
+// once. Message-level dedup (transport.WithDedup) doesn't catch these:
+// a retried export wraps the same span in a new message.
+type spanDedup struct {
+	window time.Duration
+	max    int
+
+	mu    sync.Mutex
+	order *list.List // of seenSpan, most recent first
+	seen  map[spanKey]*list.Element
+}
+
+func newSpanDedup(window time.Duration, maxEntries int) *spanDedup {
+	return &spanDedup{
+		window: window,
+		max:    maxEntries,
+		order:  list.New(),
+		seen:   make(map[spanKey]*list.Element),
+	}
+}
+
+// duplicate records span and reports whether it was already ingested
+// within the window. Spans without both IDs are never duplicates.
+func (d *spanDedup) duplicate(span protocol.TraceSpan, now time.Time) bool {
+	if span.TraceID == "" || span.SpanID == "" {
+		return false
+	}
+	key := spanKey{span.TraceID, span.SpanID}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Expire spans older than the window.
+	for e := d.order.Back(); e != nil; e = d.order.Back() {
+		s := e.Value.(seenSpan)
+		if now.Sub(s.at) < d.window {
+			break
+		}
+		d.order.Remove(e)
+		delete(d.seen, s.key)
+	}
+
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+	d.seen[key] = d.order.PushFront(seenSpan{key, now})
+	if d.max > 0 && d.order.Len() > d.max {
+		e := d.order.Back()
+		d.order.Remove(e)
+		delete(d.seen, e.Value.(seenSpan).key)
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package tokentrace
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestHandlerDedup(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dedup = true
+	h := NewHandler(cfg)
+
+	span := protocol.TraceSpan{
+		TraceID: "t1", SpanID: "s1", Operation: "infer",
+		StartNS: 0, EndNS: 1_000_000, Status: "error",
+		Attrs: map[string]any{"cost_usd": 0.5},
+	}
+	for range 3 {
+		if w := postSpan(t, h, span); w.Code != http.StatusAccepted {
+			t.Fatalf("status = %d", w.Code)
+		}
+	}
+	span.SpanID = "s2"
+	postSpan(t, h, span)
+
+	stats := h.Aggregator().Stats()
+	if stats.TotalSpans != 2 {
+		t.Errorf("TotalSpans = %d, want 2", stats.TotalSpans)
+	}
+	if n := h.duplicates.Value(); n != 2 {
+		t.Errorf("spans_duplicate_total = %d, want 2", n)
+	}
+	if got := len(h.Store().Recent(10)); got != 2 {
+		t.Errorf("stored %d spans, want 2", got)
+	}
+}
+
+func TestSpanDedupWindow(t *testing.T) {
+	d := newSpanDedup(time.Minute, 2)
+	now := time.Unix(1000, 0)
+	a := protocol.TraceSpan{TraceID: "t", SpanID: "a"}
+	b := protocol.TraceSpan{TraceID: "t", SpanID: "b"}
+	c := protocol.TraceSpan{TraceID: "t", SpanID: "c"}
+
+	if d.duplicate(a, now) || !d.duplicate(a, now.Add(time.Second)) {
+		t.Fatal("second delivery within the window not caught")
+	}
+	if d.duplicate(a, now.Add(2*time.Minute)) {
+		t.Error("span outside the window treated as duplicate")
+	}
+
+	// The oldest span is evicted beyond maxEntries.
+	now = now.Add(time.Hour)
+	d.duplicate(a, now)
+	d.duplicate(b, now)
+	d.duplicate(c, now)
+	if d.duplicate(a, now) {
+		t.Error("evicted span still remembered")
+	}
+
+	if d.duplicate(protocol.TraceSpan{}, now) || d.duplicate(protocol.TraceSpan{}, now) {
+		t.Error("spans without IDs are never duplicates")
+	}
+}
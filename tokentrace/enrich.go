@@ -0,0 +1,182 @@
+package tokentrace
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/greynewell/mist-go/pricing"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// EnrichConfig is the [tokentrace.enrich] table: rules applied to every
+// span at ingest, before it is stored or aggregated, so spans from tools
+// that name or format attrs differently land on the same dashboards.
+//
+//	[tokentrace.enrich]
+//	pricing_file = "prices.toml"
+//	lowercase = ["model", "provider"]
+//	numeric = ["tokens_in", "tokens_out", "cost_usd"]
+//
+//	[tokentrace.enrich.tags]
+//	cluster = "us-east-1a"
+//	region = "us-east-1"
+//
+//	[tokentrace.enrich.rename]
+//	input_tokens = "tokens_in"
+//	output_tokens = "tokens_out"
+type EnrichConfig struct {
+	// Tags are attrs added to spans that don't set them.
+	Tags map[string]string `toml:"tags"`
+
+	// Rename maps attr aliases to their canonical names. A span that
+	// sets both keeps the canonical value and drops the alias.
+	Rename map[string]string `toml:"rename"`
+
+	// Lowercase lists attrs whose string values are lowercased.
+	Lowercase []string `toml:"lowercase"`
+
+	// Numeric lists attrs whose string values are parsed as numbers, so
+	// "120" from one tool counts the same as 120 from another. Values
+	// that don't parse are left alone.
+	Numeric []string `toml:"numeric"`
+
+	// PricingFile derives cost_usd from the model, tokens_in, and
+	// tokens_out attrs of spans without one, using the pricing table at
+	// this path (see the pricing package). Spans for models not in the
+	// table are left unpriced. The handler doesn't read the file itself;
+	// whoever builds it sets Handler.Pricer, as mist serve does.
+	PricingFile string `toml:"pricing_file"`
+}
+
+// Validate checks that the enrichment rules are well-formed.
+func (c *EnrichConfig) Validate() error {
+	for k := range c.Tags {
+		if k == "" {
+			return fmt.Errorf("tags: empty attr name")
+		}
+	}
+	for from, to := range c.Rename {
+		switch {
+		case from == "" || to == "":
+			return fmt.Errorf("rename: empty attr name (%q = %q)", from, to)
+		case from == to:
+			return fmt.Errorf("rename: %q renamed to itself", from)
+		}
+		if _, ok := c.Rename[to]; ok {
+			return fmt.Errorf("rename: %q renamed to %q, which is itself renamed", from, to)
+		}
+	}
+	for _, k := range c.Lowercase {
+		if k == "" {
+			return fmt.Errorf("lowercase: empty attr name")
+		}
+	}
+	for _, k := range c.Numeric {
+		if k == "" {
+			return fmt.Errorf("numeric: empty attr name")
+		}
+	}
+	return nil
+}
+
+// empty reports whether the config has no rules, so ingest can skip it.
+func (c *EnrichConfig) empty() bool {
+	return len(c.Tags) == 0 && len(c.Rename) == 0 && len(c.Lowercase) == 0 &&
+		len(c.Numeric) == 0 && c.PricingFile == ""
+}
+
+// Pricer looks up the price of a model. *pricing.Table and
+// *pricing.Source implement it.
+type Pricer interface {
+	Price(model string) (pricing.Price, bool)
+}
+
+// enricher applies an EnrichConfig to spans.
+type enricher struct {
+	tags      map[string]string
+	rename    map[string]string
+	aliases   []string // keys of rename, sorted so renames are deterministic
+	lowercase []string
+	numeric   []string
+}
+
+func newEnricher(cfg EnrichConfig) *enricher {
+	e := &enricher{
+		tags:      cfg.Tags,
+		rename:    cfg.Rename,
+		lowercase: cfg.Lowercase,
+		numeric:   cfg.Numeric,
+	}
+	for from := range cfg.Rename {
+		e.aliases = append(e.aliases, from)
+	}
+	sort.Strings(e.aliases)
+	return e
+}
+
+// enrich rewrites span's attrs in place: renames first, so the
+// normalization rules and pricing see canonical names, then
+// normalization, tags, and a derived cost_usd if prices is non-nil.
+func (e *enricher) enrich(span *protocol.TraceSpan, prices Pricer) {
+	attrs := span.Attrs
+	if attrs == nil {
+		attrs = make(map[string]any, len(e.tags))
+	}
+	for _, from := range e.aliases {
+		v, ok := attrs[from]
+		if !ok {
+			continue
+		}
+		delete(attrs, from)
+		if to := e.rename[from]; !has(attrs, to) {
+			attrs[to] = v
+		}
+	}
+	for _, k := range e.lowercase {
+		if s, ok := attrs[k].(string); ok {
+			attrs[k] = strings.ToLower(s)
+		}
+	}
+	for _, k := range e.numeric {
+		if s, ok := attrs[k].(string); ok {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				attrs[k] = f
+			}
+		}
+	}
+	for k, v := range e.tags {
+		if !has(attrs, k) {
+			attrs[k] = v
+		}
+	}
+	if prices != nil {
+		deriveCost(attrs, prices)
+	}
+	if len(attrs) > 0 {
+		span.Attrs = attrs
+	}
+}
+
+// deriveCost sets cost_usd from the model and token attrs when the span
+// has tokens but no cost.
+func deriveCost(attrs map[string]any, prices Pricer) {
+	if has(attrs, "cost_usd") {
+		return
+	}
+	model, _ := attrs["model"].(string)
+	in, okIn := attrs["tokens_in"].(float64)
+	out, okOut := attrs["tokens_out"].(float64)
+	if model == "" || (!okIn && !okOut) {
+		return
+	}
+	if p, ok := prices.Price(model); ok {
+		attrs["cost_usd"] = p.Cost(int64(in), int64(out))
+	}
+}
+
+func has(attrs map[string]any, k string) bool {
+	_, ok := attrs[k]
+	return ok
+}
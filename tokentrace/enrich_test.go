@@ -0,0 +1,111 @@
+package tokentrace
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/greynewell/mist-go/pricing"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestEnrich(t *testing.T) {
+	e := newEnricher(EnrichConfig{
+		Tags:      map[string]string{"cluster": "c1", "region": "us-east-1"},
+		Rename:    map[string]string{"input_tokens": "tokens_in", "llm.model": "model"},
+		Lowercase: []string{"model"},
+		Numeric:   []string{"tokens_in", "tokens_out"},
+	})
+	prices := pricing.NewTable(pricing.Rate{Model: "gpt-4o", Price: pricing.Price{InputPerMTok: 2, OutputPerMTok: 10}})
+
+	tests := []struct {
+		name  string
+		attrs map[string]any
+		want  map[string]any
+	}{
+		{"nil attrs get tags", nil, map[string]any{"cluster": "c1", "region": "us-east-1"}},
+		{"span's own tag wins",
+			map[string]any{"region": "eu-west-1"},
+			map[string]any{"cluster": "c1", "region": "eu-west-1"}},
+		{"rename, normalize, and price",
+			map[string]any{"llm.model": "GPT-4o", "input_tokens": "1000", "tokens_out": 500.0},
+			map[string]any{"cluster": "c1", "region": "us-east-1", "model": "gpt-4o",
+				"tokens_in": 1000.0, "tokens_out": 500.0, "cost_usd": 0.007}},
+		{"canonical attr wins over alias",
+			map[string]any{"tokens_in": 7.0, "input_tokens": 9.0},
+			map[string]any{"cluster": "c1", "region": "us-east-1", "tokens_in": 7.0}},
+		{"reported cost kept",
+			map[string]any{"model": "gpt-4o", "tokens_in": 1000.0, "cost_usd": 1.0},
+			map[string]any{"cluster": "c1", "region": "us-east-1", "model": "gpt-4o", "tokens_in": 1000.0, "cost_usd": 1.0}},
+		{"unknown model unpriced",
+			map[string]any{"model": "mystery", "tokens_in": 1000.0},
+			map[string]any{"cluster": "c1", "region": "us-east-1", "model": "mystery", "tokens_in": 1000.0}},
+		{"unparseable number left alone",
+			map[string]any{"tokens_out": "many"},
+			map[string]any{"cluster": "c1", "region": "us-east-1", "tokens_out": "many"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := protocol.TraceSpan{Attrs: tt.attrs}
+			e.enrich(&span, prices)
+			if c, ok := span.Attrs["cost_usd"].(float64); ok {
+				span.Attrs["cost_usd"] = math.Round(c*1e9) / 1e9
+			}
+			if !reflect.DeepEqual(span.Attrs, tt.want) {
+				t.Errorf("attrs = %v, want %v", span.Attrs, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerEnrich(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.IndexedAttrs = []string{"region"}
+	cfg.Enrich = EnrichConfig{
+		Tags:        map[string]string{"region": "us-east-1"},
+		Rename:      map[string]string{"prompt_tokens": "tokens_in"},
+		PricingFile: "prices.toml",
+	}
+	h := NewHandler(cfg)
+	h.Pricer = pricing.NewTable(pricing.Rate{Model: "m", Price: pricing.Price{InputPerMTok: 1_000_000}})
+
+	span := protocol.TraceSpan{TraceID: "t1", SpanID: "s1", Operation: "infer", EndNS: 1e6, Status: "ok",
+		Attrs: map[string]any{"model": "m", "prompt_tokens": 3}}
+	if w := postSpan(t, h, span); w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	stats := h.Aggregator().Stats()
+	if stats.TotalTokensIn != 3 || stats.TotalCostUSD != 3 {
+		t.Errorf("tokens_in = %d, cost = %g; want 3 and 3", stats.TotalTokensIn, stats.TotalCostUSD)
+	}
+	got := h.Store().GetTrace("t1")
+	if len(got) != 1 || got[0].Attrs["region"] != "us-east-1" || got[0].Attrs["prompt_tokens"] != nil {
+		t.Errorf("stored span = %+v", got)
+	}
+}
+
+func TestEnrichConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     EnrichConfig
+		wantErr bool
+	}{
+		{"empty", EnrichConfig{}, false},
+		{"rules", EnrichConfig{Tags: map[string]string{"region": "r"}, Rename: map[string]string{"a": "b"}, Numeric: []string{"b"}}, false},
+		{"empty tag name", EnrichConfig{Tags: map[string]string{"": "r"}}, true},
+		{"empty rename target", EnrichConfig{Rename: map[string]string{"a": ""}}, true},
+		{"rename to itself", EnrichConfig{Rename: map[string]string{"a": "a"}}, true},
+		{"rename chain", EnrichConfig{Rename: map[string]string{"a": "b", "b": "c"}}, true},
+		{"empty lowercase name", EnrichConfig{Lowercase: []string{""}}, true},
+		{"empty numeric name", EnrichConfig{Numeric: []string{""}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package tokentrace
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// ExportFormats lists the formats accepted by ExportSpans.
+var ExportFormats = []string{"csv", "parquet"}
+
+// spanColumns are the span fields exported ahead of attributes.
+var spanColumns = []string{"trace_id", "span_id", "parent_id", "operation", "start_ns", "end_ns", "duration_ms", "status"}
+
+// Since returns the stored spans that started at or after t, oldest
+// first.
+func (s *Store) Since(t time.Time) []protocol.TraceSpan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ns := startNS(t)
+	var result []protocol.TraceSpan
+	for age := s.cap - s.count; age < s.cap; age++ {
+		span := s.spans[(s.head+age)%s.cap]
+		if span.StartNS >= ns {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+// Each calls fn with the spans stored when it is called that started at
+// or after t, oldest first, in batches of at most n. The store is locked
+// only while a batch is copied, so a slow fn doesn't hold up ingest;
+// spans evicted or deleted before their batch is copied are skipped. fn
+// must not keep the batch, which is reused.
+func (s *Store) Each(t time.Time, n int, fn func([]protocol.TraceSpan) error) error {
+	return s.each(startNS(t), s.end(), n, fn)
+}
+
+// startNS is t in Unix nanoseconds, or the earliest time if t is zero.
+func startNS(t time.Time) int64 {
+	if t.IsZero() {
+		return math.MinInt64
+	}
+	return t.UnixNano()
+}
+
+// end returns the sequence number the next span added will get.
+func (s *Store) end() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.next
+}
+
+// each implements Each over the spans with sequence numbers below end.
+func (s *Store) each(ns int64, end uint64, n int, fn func([]protocol.TraceSpan) error) error {
+	batch := make([]protocol.TraceSpan, 0, max(n, 1))
+	var from uint64
+	for {
+		batch, from = s.batch(ns, from, end, batch[:0])
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// batch fills buf, up to its capacity, with the spans numbered from
+// from up to end that started at or after ns, and returns it with the
+// sequence number to continue from.
+func (s *Store) batch(ns int64, from, end uint64, buf []protocol.TraceSpan) ([]protocol.TraceSpan, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Sequence numbers increase from the oldest span to the newest.
+	oldest := s.cap - s.count
+	age := oldest + sort.Search(s.count, func(i int) bool {
+		return s.seqs[(s.head+oldest+i)%s.cap] >= from
+	})
+	for ; age < s.cap && len(buf) < cap(buf); age++ {
+		pos := (s.head + age) % s.cap
+		if s.seqs[pos] >= end {
+			return buf, end
+		}
+		if s.spans[pos].StartNS >= ns {
+			buf = append(buf, s.spans[pos])
+		}
+		from = s.seqs[pos] + 1
+	}
+	if age == s.cap {
+		return buf, end
+	}
+	return buf, from
+}
+
+// exportBatch is how many spans an export copies from the store at a
+// time, and parquetGroupRows how many rows go in each Parquet row group;
+// an export holds at most one row group in memory.
+var (
+	exportBatch      = 1024
+	parquetGroupRows = 64 * 1024
+)
+
+// spanWalk calls fn with batches of spans, oldest first, and passes the
+// same spans each time it is called.
+type spanWalk func(fn func([]protocol.TraceSpan) error) error
+
+// ExportColumns returns the default export columns for spans: the span
+// fields, then every attribute key seen, sorted, as "attrs.<key>".
+// Nested attribute maps are flattened to dotted keys.
+func ExportColumns(spans []protocol.TraceSpan) []string {
+	cols, _ := scanColumns(sliceWalk(spans), nil)
+	return cols
+}
+
+// sliceWalk walks spans as a single batch.
+func sliceWalk(spans []protocol.TraceSpan) spanWalk {
+	return func(fn func([]protocol.TraceSpan) error) error {
+		if len(spans) == 0 {
+			return nil
+		}
+		return fn(spans)
+	}
+}
+
+// scanColumns walks the spans once, returning columns, or ExportColumns
+// if columns is empty, and the kind of value each column holds.
+func scanColumns(walk spanWalk, columns []string) ([]string, map[string]*columnKind) {
+	kinds := make(map[string]*columnKind)
+	for _, c := range columns {
+		kinds[c] = &columnKind{}
+	}
+	walk(func(spans []protocol.TraceSpan) error {
+		for _, span := range spans {
+			for k, v := range exportRow(span) {
+				kind, ok := kinds[k]
+				if !ok {
+					if len(columns) > 0 {
+						continue
+					}
+					kind = &columnKind{}
+					kinds[k] = kind
+				}
+				kind.observe(v)
+			}
+		}
+		return nil
+	})
+	if len(columns) > 0 {
+		return columns, kinds
+	}
+
+	var attrs []string
+	for k := range kinds {
+		if strings.HasPrefix(k, "attrs.") {
+			attrs = append(attrs, k)
+		}
+	}
+	sort.Strings(attrs)
+	columns = append(append([]string(nil), spanColumns...), attrs...)
+	for _, c := range columns {
+		if kinds[c] == nil {
+			kinds[c] = &columnKind{}
+		}
+	}
+	return columns, kinds
+}
+
+// ExportSpans writes spans to w as a table in format ("csv" or
+// "parquet") for loading into a warehouse. columns selects and orders
+// the columns; if empty, ExportColumns is used. Columns are span fields
+// or "attrs.<key>" and may be absent from some spans, which leaves the
+// cell empty. CSV rows are written as they are produced; Parquet is
+// written in row groups of up to 64Ki rows.
+func ExportSpans(w io.Writer, spans []protocol.TraceSpan, format string, columns []string) error {
+	return exportSpans(w, sliceWalk(spans), format, columns)
+}
+
+// exportSpans implements ExportSpans over walk. Parquet, and CSV without
+// columns, walk the spans twice: first for the columns and their types.
+func exportSpans(w io.Writer, walk spanWalk, format string, columns []string) error {
+	if err := checkExport(format, columns); err != nil {
+		return err
+	}
+	var kinds map[string]*columnKind
+	if len(columns) == 0 || format == "parquet" {
+		columns, kinds = scanColumns(walk, columns)
+	}
+
+	var err error
+	switch format {
+	case "csv":
+		err = exportCSV(w, walk, columns)
+	case "parquet":
+		err = exportParquet(w, walk, columns, kinds)
+	}
+	if err != nil {
+		return fmt.Errorf("tokentrace: export: %w", err)
+	}
+	return nil
+}
+
+func exportCSV(w io.Writer, walk spanWalk, columns []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	rec := make([]string, len(columns))
+	err := walk(func(spans []protocol.TraceSpan) error {
+		for _, span := range spans {
+			row := exportRow(span)
+			for i, c := range columns {
+				rec[i] = cellString(row[c])
+			}
+			cw.Write(rec)
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportParquet(w io.Writer, walk spanWalk, columns []string, kinds map[string]*columnKind) error {
+	types := make([]int32, len(columns))
+	for i, c := range columns {
+		types[i] = kinds[c].physical()
+	}
+	pw, err := newParquetWriter(w, columns, types)
+	if err != nil {
+		return err
+	}
+
+	group := make([][]any, len(columns))
+	flush := func() error {
+		if len(group[0]) == 0 {
+			return nil
+		}
+		if err := pw.writeGroup(group); err != nil {
+			return err
+		}
+		for i := range group {
+			group[i] = group[i][:0]
+		}
+		return nil
+	}
+	err = walk(func(spans []protocol.TraceSpan) error {
+		for _, span := range spans {
+			row := exportRow(span)
+			for i, c := range columns {
+				group[i] = append(group[i], row[c])
+			}
+			if len(group[0]) >= parquetGroupRows {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return err
+	}
+	return pw.close()
+}
+
+// checkExport validates an export request before anything is written.
+func checkExport(format string, columns []string) error {
+	if !slices.Contains(ExportFormats, format) {
+		return fmt.Errorf("tokentrace: export: unknown format %q (want %s)", format, strings.Join(ExportFormats, ", "))
+	}
+	for _, c := range columns {
+		if !strings.HasPrefix(c, "attrs.") && !slices.Contains(spanColumns, c) {
+			return fmt.Errorf("tokentrace: export: unknown column %q (want %s, or attrs.<key>)", c, strings.Join(spanColumns, ", "))
+		}
+	}
+	return nil
+}
+
+// exportRow flattens span into column values. A missing parent or
+// attribute is left out so it exports as null.
+func exportRow(span protocol.TraceSpan) map[string]any {
+	row := map[string]any{
+		"trace_id":    span.TraceID,
+		"span_id":     span.SpanID,
+		"operation":   span.Operation,
+		"start_ns":    span.StartNS,
+		"end_ns":      span.EndNS,
+		"duration_ms": float64(span.EndNS-span.StartNS) / 1e6,
+		"status":      span.Status,
+	}
+	if span.ParentID != "" {
+		row["parent_id"] = span.ParentID
+	}
+	flattenAttrs("attrs.", span.Attrs, row)
+	return row
+}
+
+func flattenAttrs(prefix string, attrs map[string]any, row map[string]any) {
+	for k, v := range attrs {
+		switch v := v.(type) {
+		case map[string]any:
+			flattenAttrs(prefix+k+".", v, row)
+		case nil:
+		case int:
+			row[prefix+k] = int64(v)
+		default:
+			row[prefix+k] = v
+		}
+	}
+}
+
+// cellString formats a value as text; lists and other composite values
+// are written as JSON.
+func cellString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Export handles GET /export?since=24h&format=csv|parquet&columns=a,b —
+// streams stored spans as a table for analytics. since defaults to
+// every stored span and format to csv.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var since time.Time
+	if s := params.Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid since: "+s, http.StatusBadRequest)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	format := params.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var columns []string
+	if s := params.Get("columns"); s != "" {
+		columns = strings.Split(s, ",")
+	}
+
+	if err := checkExport(format, columns); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == "parquet" {
+		contentType = "application/vnd.apache.parquet"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="spans.`+format+`"`)
+	ns, end := startNS(since), h.store.end()
+	exportSpans(w, func(fn func([]protocol.TraceSpan) error) error {
+		return h.store.each(ns, end, exportBatch, fn)
+	}, format, columns)
+}
@@ -0,0 +1,353 @@
+package tokentrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func exportTestSpans() []protocol.TraceSpan {
+	return []protocol.TraceSpan{
+		{TraceID: "t1", SpanID: "a", Operation: "chat", StartNS: 1000, EndNS: 2_001_000, Status: "ok",
+			Attrs: map[string]any{"model": "gpt-4o", "tokens_in": float64(12), "usage": map[string]any{"cached": true}}},
+		{TraceID: "t1", SpanID: "b", ParentID: "a", Operation: "tool", StartNS: 2000, EndNS: 3000, Status: "error",
+			Attrs: map[string]any{"model": "claude"}},
+	}
+}
+
+func TestExportColumns(t *testing.T) {
+	cols := ExportColumns(exportTestSpans())
+	want := append(append([]string(nil), spanColumns...), "attrs.model", "attrs.tokens_in", "attrs.usage.cached")
+	if strings.Join(cols, ",") != strings.Join(want, ",") {
+		t.Errorf("columns = %v, want %v", cols, want)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ExportSpans(&buf, exportTestSpans(), "csv", []string{"span_id", "parent_id", "duration_ms", "attrs.tokens_in", "attrs.usage.cached"}); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"span_id", "parent_id", "duration_ms", "attrs.tokens_in", "attrs.usage.cached"},
+		{"a", "", "2", "12", "true"},
+		{"b", "a", "0.001", "", ""},
+	}
+	for i := range want {
+		if strings.Join(recs[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d = %v, want %v", i, recs[i], want[i])
+		}
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	if err := ExportSpans(&bytes.Buffer{}, nil, "xlsx", nil); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if err := ExportSpans(&bytes.Buffer{}, nil, "csv", []string{"model"}); err == nil {
+		t.Error("unknown column accepted")
+	}
+}
+
+// readThrift decodes one compact-protocol struct into field ID → value,
+// enough to check the Parquet footer.
+func readThrift(t *testing.T, b []byte) (map[int16]any, []byte) {
+	t.Helper()
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(b)
+		b = b[n:]
+		return v
+	}
+	unzig := func(v uint64) int64 { return int64(v>>1) ^ -int64(v&1) }
+	var value func(typ byte) any
+	value = func(typ byte) any {
+		switch typ {
+		case ctI32, ctI64:
+			return unzig(uvarint())
+		case ctBinary:
+			n := uvarint()
+			s := string(b[:n])
+			b = b[n:]
+			return s
+		case ctList:
+			h := b[0]
+			b = b[1:]
+			n, elem := uint64(h>>4), h&0x0f
+			if n == 15 {
+				n = uvarint()
+			}
+			list := make([]any, n)
+			for i := range list {
+				list[i] = value(elem)
+			}
+			return list
+		case ctStruct:
+			var m map[int16]any
+			m, b = readThrift(t, b)
+			return m
+		}
+		t.Fatalf("unexpected thrift type %d", typ)
+		return nil
+	}
+
+	m := make(map[int16]any)
+	var id int16
+	for {
+		h := b[0]
+		b = b[1:]
+		if h == 0 {
+			return m, b
+		}
+		if d := h >> 4; d != 0 {
+			id += int16(d)
+		} else {
+			id = int16(unzig(uvarint()))
+		}
+		m[id] = value(h & 0x0f)
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ExportSpans(&buf, exportTestSpans(), "parquet", []string{"span_id", "start_ns", "attrs.tokens_in", "attrs.usage.cached"}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatal("missing PAR1 magic")
+	}
+	n := binary.LittleEndian.Uint32(file[len(file)-8:])
+	meta, rest := readThrift(t, file[len(file)-8-int(n):len(file)-8])
+	if len(rest) != 0 {
+		t.Fatalf("%d bytes after footer", len(rest))
+	}
+
+	if meta[3] != int64(2) {
+		t.Errorf("num_rows = %v", meta[3])
+	}
+	schema := meta[2].([]any)
+	wantTypes := map[string]int64{"span_id": parquetByteArray, "start_ns": parquetInt64, "attrs.tokens_in": parquetDouble, "attrs.usage.cached": parquetBoolean}
+	if len(schema) != 5 {
+		t.Fatalf("schema has %d elements", len(schema))
+	}
+	for _, e := range schema[1:] {
+		el := e.(map[int16]any)
+		if want := wantTypes[el[4].(string)]; el[1] != want {
+			t.Errorf("%s type = %v, want %d", el[4], el[1], want)
+		}
+	}
+
+	// Read the start_ns values back from its data page.
+	group := meta[4].([]any)[0].(map[int16]any)
+	col := group[1].([]any)[1].(map[int16]any)[3].(map[int16]any)
+	off := col[9].(int64)
+	_, page := readThrift(t, file[off:])
+	levels := binary.LittleEndian.Uint32(page)
+	vals := page[4+levels:]
+	if a, b := binary.LittleEndian.Uint64(vals), binary.LittleEndian.Uint64(vals[8:]); a != 1000 || b != 2000 {
+		t.Errorf("start_ns = %d, %d", a, b)
+	}
+
+	// tokens_in is null in the second row: one value follows the levels.
+	col = group[1].([]any)[2].(map[int16]any)[3].(map[int16]any)
+	_, page = readThrift(t, file[col[9].(int64):])
+	levels = binary.LittleEndian.Uint32(page)
+	if page[4+1] != 0b01 {
+		t.Errorf("definition levels = %08b, want 01", page[5])
+	}
+	if v := math.Float64frombits(binary.LittleEndian.Uint64(page[4+levels:])); v != 12 {
+		t.Errorf("tokens_in = %v", v)
+	}
+}
+
+// readParquet decodes every row group of a file written by exportParquet
+// into its column names, the values of each column (nil for nulls), and
+// the number of row groups.
+func readParquet(t *testing.T, file []byte) ([]string, [][]any, int) {
+	t.Helper()
+	n := binary.LittleEndian.Uint32(file[len(file)-8:])
+	meta, _ := readThrift(t, file[len(file)-8-int(n):len(file)-8])
+
+	var names []string
+	var types []int64
+	for _, e := range meta[2].([]any)[1:] {
+		el := e.(map[int16]any)
+		names = append(names, el[4].(string))
+		types = append(types, el[1].(int64))
+	}
+	columns := make([][]any, len(names))
+	groups := meta[4].([]any)
+	for _, g := range groups {
+		for i, c := range g.(map[int16]any)[1].([]any) {
+			col := c.(map[int16]any)[3].(map[int16]any)
+			header, page := readThrift(t, file[col[9].(int64):])
+			rows := int(header[5].(map[int16]any)[1].(int64))
+			defined, vals := readLevels(t, page, rows)
+			bit := 0
+			for _, ok := range defined {
+				if !ok {
+					columns[i] = append(columns[i], nil)
+					continue
+				}
+				var v any
+				switch types[i] {
+				case parquetInt64:
+					v = int64(binary.LittleEndian.Uint64(vals))
+					vals = vals[8:]
+				case parquetDouble:
+					v = math.Float64frombits(binary.LittleEndian.Uint64(vals))
+					vals = vals[8:]
+				case parquetBoolean:
+					v = vals[bit/8]&(1<<(bit%8)) != 0
+					bit++
+				default:
+					l := binary.LittleEndian.Uint32(vals)
+					v = string(vals[4 : 4+l])
+					vals = vals[4+l:]
+				}
+				columns[i] = append(columns[i], v)
+			}
+		}
+	}
+	if meta[3] != int64(len(columns[0])) {
+		t.Errorf("num_rows = %v, decoded %d", meta[3], len(columns[0]))
+	}
+	return names, columns, len(groups)
+}
+
+// readLevels decodes the length-prefixed RLE/bit-packed hybrid
+// definition levels of a page of rows values, returning which are
+// defined and the values that follow.
+func readLevels(t *testing.T, page []byte, rows int) ([]bool, []byte) {
+	t.Helper()
+	size := binary.LittleEndian.Uint32(page)
+	b := page[4 : 4+size]
+	var defined []bool
+	for len(b) > 0 {
+		h, n := binary.Uvarint(b)
+		b = b[n:]
+		if h&1 == 1 {
+			for _, packed := range b[:h>>1] {
+				for i := 0; i < 8; i++ {
+					defined = append(defined, packed&(1<<i) != 0)
+				}
+			}
+			b = b[h>>1:]
+		} else {
+			for i := uint64(0); i < h>>1; i++ {
+				defined = append(defined, b[0] == 1)
+			}
+			b = b[1:]
+		}
+	}
+	if len(defined) < rows {
+		t.Fatalf("%d definition levels for %d rows", len(defined), rows)
+	}
+	return defined[:rows], page[4+size:]
+}
+
+func TestExportParquetRowGroups(t *testing.T) {
+	defer func(n int) { parquetGroupRows = n }(parquetGroupRows)
+	parquetGroupRows = 3
+
+	var spans []protocol.TraceSpan
+	for i := range 8 {
+		span := protocol.TraceSpan{TraceID: "t", SpanID: fmt.Sprint(i), StartNS: int64(i), Attrs: map[string]any{}}
+		if i%2 == 0 {
+			span.Attrs["cost"] = float64(i) / 2
+		}
+		if i%3 == 0 {
+			span.Attrs["cached"] = i == 3
+		}
+		spans = append(spans, span)
+	}
+	var buf bytes.Buffer
+	columns := []string{"span_id", "start_ns", "attrs.cost", "attrs.cached"}
+	if err := ExportSpans(&buf, spans, "parquet", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	names, values, groups := readParquet(t, buf.Bytes())
+	if groups != 3 {
+		t.Errorf("row groups = %d, want 3", groups)
+	}
+	if strings.Join(names, ",") != strings.Join(columns, ",") {
+		t.Fatalf("columns = %v", names)
+	}
+	for i, span := range spans {
+		row := exportRow(span)
+		for c, name := range columns {
+			if got, want := values[c][i], row[name]; got != want {
+				t.Errorf("row %d %s = %#v, want %#v", i, name, got, want)
+			}
+		}
+	}
+}
+
+func TestStoreEach(t *testing.T) {
+	s := NewStore(10)
+	for i := range 7 {
+		s.Add(protocol.TraceSpan{TraceID: "t", SpanID: fmt.Sprint(i), StartNS: int64(i)})
+	}
+
+	var got []string
+	err := s.Each(time.Unix(0, 1), 2, func(batch []protocol.TraceSpan) error {
+		if len(batch) > 2 {
+			t.Errorf("batch of %d", len(batch))
+		}
+		for _, span := range batch {
+			got = append(got, span.SpanID)
+		}
+		if len(got) == 2 {
+			// Spans added during the walk aren't visited; deleted
+			// ones not yet copied are skipped.
+			s.Add(protocol.TraceSpan{TraceID: "t", SpanID: "new", StartNS: 9})
+			s.DeleteFunc(func(span protocol.TraceSpan) bool { return span.SpanID == "4" })
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "1,2,3,5,6" {
+		t.Errorf("spans = %v", got)
+	}
+}
+
+func TestHandlerExport(t *testing.T) {
+	h := newTestHandler()
+	now := time.Now().UnixNano()
+	h.Store().Add(protocol.TraceSpan{TraceID: "old", SpanID: "1", Operation: "op", StartNS: now - int64(48*time.Hour), EndNS: now})
+	h.Store().Add(protocol.TraceSpan{TraceID: "new", SpanID: "2", Operation: "op", StartNS: now - int64(time.Hour), EndNS: now})
+
+	w := httptest.NewRecorder()
+	h.Export(w, httptest.NewRequest("GET", "/export?since=24h&columns=trace_id", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "trace_id\nnew\n" {
+		t.Errorf("export = %d %q", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.Export(w, httptest.NewRequest("GET", "/export?format=parquet", nil))
+	if w.Header().Get("Content-Type") != "application/vnd.apache.parquet" || !bytes.HasPrefix(w.Body.Bytes(), []byte("PAR1")) {
+		t.Errorf("parquet export = %q", w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	h.Export(w, httptest.NewRequest("GET", "/export?format=xml", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad format status = %d", w.Code)
+	}
+}
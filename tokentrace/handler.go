@@ -5,8 +5,12 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/transport"
 )
 
 // Handler provides HTTP handlers for the TokenTrace API.
@@ -14,18 +18,61 @@ type Handler struct {
 	store *Store
 	agg   *Aggregator
 	alert *Alerter
+	drain *transport.DrainGate
+
+	dedup      *spanDedup // nil when Config.Dedup is off
+	duplicates *metrics.Counter
+	limits     *ingestLimits // nil when no concurrency limit is set
+	enrich     *enricher     // nil when Config.Enrich has no rules
+	autoscale  *Autoscaler   // nil when Config.Autoscale is off
+
+	retention   []RetentionRule
+	deleteToken string // Config.DeleteToken
+	auditMu     sync.Mutex
+	audit       []AuditRecord
 
 	// OnAlert is called when an alert fires. Used for logging, forwarding, etc.
 	OnAlert func(protocol.TraceAlert)
+
+	// OnAudit is called for every deletion, for persisting the audit
+	// trail outside the process.
+	OnAudit func(AuditRecord)
+
+	// Pricer prices spans with tokens but no cost_usd, typically a
+	// pricing.Source watching Config.Enrich.PricingFile. Set it before
+	// serving; nil leaves such spans unpriced.
+	Pricer Pricer
 }
 
 // NewHandler creates a fully wired handler from the given config.
 func NewHandler(cfg Config) *Handler {
-	return &Handler{
-		store: NewStore(cfg.MaxSpans),
-		agg:   NewAggregator(),
+	h := &Handler{
+		store: NewStore(cfg.MaxSpans, WithIndexedAttrs(cfg.IndexedAttrs...)),
+		agg:   NewAggregator(WithMaxOperations(cfg.MaxOperations)),
 		alert: NewAlerter(cfg.AlertRules, cfg.AlertCooldown),
+		drain: transport.NewDrainGate(nil),
+
+		retention:   cfg.Retention,
+		deleteToken: cfg.DeleteToken,
+	}
+	h.duplicates = h.agg.Registry().Counter("spans_duplicate_total")
+	if cfg.Dedup && cfg.DedupWindow > 0 {
+		h.dedup = newSpanDedup(cfg.DedupWindow, cfg.MaxSpans)
 	}
+	if cfg.MaxConcurrentPerSource > 0 || cfg.MaxConcurrentPerOperation > 0 {
+		maxKeys := cfg.MaxOperations
+		if maxKeys <= 0 {
+			maxKeys = DefaultMaxOperations
+		}
+		h.limits = newIngestLimits(cfg.MaxConcurrentPerSource, cfg.MaxConcurrentPerOperation, maxKeys, h.agg.Registry())
+	}
+	if !cfg.Enrich.empty() {
+		h.enrich = newEnricher(cfg.Enrich)
+	}
+	if cfg.Autoscale.enabled() {
+		h.autoscale = NewAutoscaler(h.store, cfg.Autoscale, cfg.MaxSpans)
+	}
+	return h
 }
 
 // Store returns the underlying span store.
@@ -34,30 +81,106 @@ func (h *Handler) Store() *Store { return h.store }
 // Aggregator returns the underlying aggregator.
 func (h *Handler) Aggregator() *Aggregator { return h.agg }
 
-// Ingest handles POST /mist — accepts MIST protocol messages containing trace spans.
+// DrainGate returns the gate Ingest uses for the control.drain handshake.
+func (h *Handler) DrainGate() *transport.DrainGate { return h.drain }
+
+// Drain handles GET /drain — reports drain progress after a control.drain.
+func (h *Handler) Drain(w http.ResponseWriter, r *http.Request) {
+	h.drain.ServeHTTP(w, r)
+}
+
+// Ingest handles POST /mist — accepts MIST protocol messages containing
+// trace spans, control.drain to stop accepting them (from this host, or
+// with the gate's token; see transport.DrainGate.Authorize), and
+// control.handshake to agree on an envelope version. The body is one
+// message or a JSON array of them, as a relay batching into the node
+// sends, optionally compressed (see transport.ReadBody). A batch is
+// checked whole before any of it is stored, so a malformed span refuses
+// the batch with 400; a batch refused part way by a concurrency limit
+// keeps the spans before it, which a resend skips when Config.Dedup is
+// on.
 func (h *Handler) Ingest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var msg protocol.Message
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+	data, ok := transport.ReadBody(w, r, transport.DefaultMaxMessageSize)
+	if !ok {
+		return
+	}
+	msgs, err := transport.DecodeMessages(data)
+	if err != nil {
 		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	if msg.Type != protocol.TypeTraceSpan {
-		http.Error(w, "expected type trace.span, got "+msg.Type, http.StatusBadRequest)
+	if len(msgs) == 1 && (transport.HandleHandshake(w, protocol.SourceTokenTrace, msgs[0]) || h.drain.HandleControl(w, r, msgs[0])) {
 		return
 	}
+	if h.drain.Forbid(w, r, msgs...) {
+		return
+	}
+
+	spans := make([]sourcedSpan, 0, len(msgs))
+	for _, msg := range msgs {
+		if h.drain.Control(msg) {
+			continue
+		}
+		if msg.Type != protocol.TypeTraceSpan {
+			http.Error(w, "expected type trace.span, got "+msg.Type, http.StatusBadRequest)
+			return
+		}
+		var span protocol.TraceSpan
+		if err := msg.Decode(&span); err != nil {
+			http.Error(w, "invalid span payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		spans = append(spans, sourcedSpan{msg.Source, span})
+	}
 
-	var span protocol.TraceSpan
-	if err := msg.Decode(&span); err != nil {
-		http.Error(w, "invalid span payload: "+err.Error(), http.StatusBadRequest)
+	if !h.drain.Acquire() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
 		return
 	}
+	defer h.drain.Release()
+
+	for _, s := range spans {
+		if limit := h.ingest(s.source, s.span); limit != "" {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent spans for this "+limit, http.StatusTooManyRequests)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
 
+// sourcedSpan is a span with the source of the message that carried it.
+type sourcedSpan struct {
+	source string
+	span   protocol.TraceSpan
+}
+
+// ingest stores and aggregates one span, then checks alerts. It returns
+// "source" or "operation" if a concurrency limit refused the span.
+func (h *Handler) ingest(source string, span protocol.TraceSpan) (limit string) {
+	if h.limits != nil {
+		release, limit := h.limits.acquire(source, span.Operation)
+		if release == nil {
+			return limit
+		}
+		defer release()
+	}
+
+	// A redelivered span is acknowledged, so the sender stops retrying,
+	// but not counted again.
+	if h.dedup != nil && h.dedup.duplicate(span, time.Now()) {
+		h.duplicates.Inc()
+		return ""
+	}
+
+	if h.enrich != nil {
+		h.enrich.enrich(&span, h.Pricer)
+	}
 	h.store.Add(span)
 	h.agg.Observe(span)
 
@@ -68,8 +191,7 @@ func (h *Handler) Ingest(w http.ResponseWriter, r *http.Request) {
 			h.OnAlert(a)
 		}
 	}
-
-	w.WriteHeader(http.StatusAccepted)
+	return ""
 }
 
 // TracesResponse is the JSON body for GET /traces.
@@ -146,6 +268,38 @@ func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(h.agg.Stats())
 }
 
+// TopOperationsResponse is the JSON body for GET /stats/operations.
+type TopOperationsResponse struct {
+	By         string            `json:"by"`
+	Operations []RankedOperation `json:"operations"`
+}
+
+// TopOperations handles GET /stats/operations?top=N&by=count|errors|latency|cost —
+// returns the top operations by the given key.
+func (h *Handler) TopOperations(w http.ResponseWriter, r *http.Request) {
+	top := 10
+	if s := r.URL.Query().Get("top"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			top = n
+		}
+	}
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		by = "count"
+	case "count", "errors", "latency", "cost":
+	default:
+		http.Error(w, "by must be one of count, errors, latency, cost", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TopOperationsResponse{
+		By:         by,
+		Operations: h.agg.TopOperations(top, by),
+	})
+}
+
 // CheckAlerts manually triggers an alert check and returns any fired alerts.
 func (h *Handler) CheckAlerts() []protocol.TraceAlert {
 	return h.alert.Check(h.agg.Stats())
@@ -2,6 +2,8 @@ package tokentrace
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +11,7 @@ import (
 	"time"
 
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/transport"
 )
 
 func newTestHandler() *Handler {
@@ -79,6 +82,117 @@ func TestHandlerIngestWrongType(t *testing.T) {
 	}
 }
 
+func TestHandlerIngestBatch(t *testing.T) {
+	h := newTestHandler()
+	batch := func(msgs ...*protocol.Message) int {
+		body, _ := json.Marshal(msgs)
+		w := httptest.NewRecorder()
+		h.Ingest(w, httptest.NewRequest("POST", "/mist", bytes.NewReader(body)))
+		return w.Code
+	}
+	span := func(id string) *protocol.Message {
+		msg, _ := protocol.New("test", protocol.TypeTraceSpan, protocol.TraceSpan{
+			TraceID: "t1", SpanID: id, Operation: "infer", EndNS: 1_000_000, Status: "ok",
+		})
+		return msg
+	}
+
+	if code := batch(span("s1"), span("s2"), span("s3")); code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", code)
+	}
+	if n := h.Store().Len(); n != 3 {
+		t.Errorf("store holds %d spans, want 3", n)
+	}
+
+	// One bad message refuses the whole batch.
+	ping, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "test"})
+	if code := batch(span("s4"), ping); code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a batch with a wrong type", code)
+	}
+	if n := h.Store().Len(); n != 3 {
+		t.Errorf("store holds %d spans after a refused batch, want 3", n)
+	}
+}
+
+func TestHandlerIngestCompressed(t *testing.T) {
+	h := newTestHandler()
+	msg, _ := protocol.New("test", protocol.TypeTraceSpan, protocol.TraceSpan{
+		TraceID: "t1", SpanID: "s1", Operation: "infer", EndNS: 1_000_000, Status: "ok",
+	})
+	body, _ := msg.Marshal()
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(body)
+	zw.Close()
+
+	req := httptest.NewRequest("POST", "/mist", bytes.NewReader(zipped.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.Ingest(w, req)
+	if w.Code != http.StatusAccepted || h.Store().Len() != 1 {
+		t.Fatalf("gzip body: status %d, %d spans stored", w.Code, h.Store().Len())
+	}
+
+	// An encoding it can't decode is refused with the ones it can, so
+	// the sender falls back.
+	req = httptest.NewRequest("POST", "/mist", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "br")
+	w = httptest.NewRecorder()
+	h.Ingest(w, req)
+	if w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Accept-Encoding") == "" {
+		t.Errorf("unknown encoding: status %d, Accept-Encoding %q; want 415 listing gzip", w.Code, w.Header().Get("Accept-Encoding"))
+	}
+}
+
+func TestHandlerIngestHandshake(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(newTestHandler().Ingest))
+	defer srv.Close()
+	v, err := transport.Handshake(context.Background(), transport.NewHTTP(srv.URL), "relay")
+	if err != nil || v != protocol.MaxSupportedVersion {
+		t.Errorf("Handshake = %q, %v; want %s", v, err, protocol.MaxSupportedVersion)
+	}
+}
+
+func TestHandlerIngestDrain(t *testing.T) {
+	h := newTestHandler()
+	msg, _ := protocol.New("mist", protocol.TypeControlDrain, protocol.ControlDrain{Reason: "deploy"})
+	body, _ := msg.Marshal()
+
+	w := httptest.NewRecorder()
+	h.Ingest(w, httptest.NewRequest("POST", "/mist", bytes.NewReader(body)))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("drain from another host: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	req := httptest.NewRequest("POST", "/mist", bytes.NewReader(body))
+	req.RemoteAddr = "127.0.0.1:40000"
+	w = httptest.NewRecorder()
+	h.Ingest(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("drain status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	var st protocol.DrainStatus
+	json.NewDecoder(w.Body).Decode(&st)
+	if st.State != protocol.DrainDrained {
+		t.Errorf("state = %q, want drained with nothing in flight", st.State)
+	}
+
+	w = postSpan(t, h, protocol.TraceSpan{TraceID: "t1", SpanID: "s1", Operation: "infer", Status: "ok"})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("span after drain: status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if h.Store().Len() != 0 {
+		t.Error("span stored after drain")
+	}
+
+	w = httptest.NewRecorder()
+	h.Drain(w, httptest.NewRequest("GET", "/drain", nil))
+	json.NewDecoder(w.Body).Decode(&st)
+	if st.State != protocol.DrainDrained {
+		t.Errorf("GET /drain state = %q", st.State)
+	}
+}
+
 func TestHandlerTraces(t *testing.T) {
 	h := newTestHandler()
 	postSpan(t, h, protocol.TraceSpan{
@@ -289,3 +403,39 @@ func TestHandlerMethodNotAllowed(t *testing.T) {
 		t.Errorf("status = %d, want 405", w.Code)
 	}
 }
+
+func TestHandlerTopOperations(t *testing.T) {
+	h := newTestHandler()
+	for i := 0; i < 3; i++ {
+		postSpan(t, h, protocol.TraceSpan{
+			TraceID: "t1", SpanID: "s", Operation: "infer",
+			StartNS: 0, EndNS: 1_000_000, Status: "ok",
+		})
+	}
+	postSpan(t, h, protocol.TraceSpan{
+		TraceID: "t1", SpanID: "s", Operation: "eval",
+		StartNS: 0, EndNS: 1_000_000, Status: "ok",
+	})
+
+	req := httptest.NewRequest("GET", "/stats/operations?top=1", nil)
+	w := httptest.NewRecorder()
+	h.TopOperations(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var resp TopOperationsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.By != "count" || len(resp.Operations) != 1 || resp.Operations[0].Operation != "infer" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	req = httptest.NewRequest("GET", "/stats/operations?by=bogus", nil)
+	w = httptest.NewRecorder()
+	h.TopOperations(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for invalid by", w.Code)
+	}
+}
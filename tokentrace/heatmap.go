@@ -0,0 +1,132 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// maxHeatmapColumns caps the time buckets in one heatmap, e.g. a day at
+// one-minute resolution.
+const maxHeatmapColumns = 1440
+
+// Heatmap is a 2D histogram of span latency over time: Counts[i][j] is
+// the number of spans started in time bucket i whose latency fell in
+// latency bucket j. Latency bucket j counts spans up to
+// LatencyBucketsMS[j] milliseconds and above the previous bound; the
+// last column, one past the bounds, counts slower spans. Unlike a single
+// p50 or p99, it shows bimodal latency, such as one slow provider among
+// fast ones.
+type Heatmap struct {
+	Start            time.Time `json:"start"`
+	Bucket           string    `json:"bucket"`
+	Times            []int64   `json:"times_ms"` // start of each time bucket, Unix ms
+	LatencyBucketsMS []float64 `json:"latency_buckets_ms"`
+	Counts           [][]int64 `json:"counts"`
+	Total            int64     `json:"total"`
+}
+
+// LatencyHeatmap buckets spans started within columns time buckets of
+// width bucket from start. Spans outside that range are ignored.
+func LatencyHeatmap(spans []protocol.TraceSpan, start time.Time, bucket time.Duration, columns int) Heatmap {
+	hm := Heatmap{
+		Start:            start,
+		Bucket:           bucket.String(),
+		Times:            make([]int64, columns),
+		LatencyBucketsMS: latencyBuckets,
+		Counts:           make([][]int64, columns),
+	}
+	for i := range columns {
+		hm.Times[i] = start.Add(time.Duration(i) * bucket).UnixMilli()
+		hm.Counts[i] = make([]int64, len(latencyBuckets)+1)
+	}
+
+	startNS := start.UnixNano()
+	for _, span := range spans {
+		if span.StartNS < startNS {
+			continue
+		}
+		col := int((span.StartNS - startNS) / int64(bucket))
+		if col >= columns {
+			continue
+		}
+		ms := float64(span.EndNS-span.StartNS) / 1_000_000.0
+		row := len(latencyBuckets)
+		for j, bound := range latencyBuckets {
+			if ms <= bound {
+				row = j
+				break
+			}
+		}
+		hm.Counts[col][row]++
+		hm.Total++
+	}
+	return hm
+}
+
+// StatsHeatmap handles GET /stats/heatmap?bucket=1m&range=1h — returns a
+// latency Heatmap of the spans started in the last range, in time buckets
+// aligned to bucket. operation, status, and attr.<key>=<value> narrow it
+// to matching spans, as for GET /spans.
+func (h *Handler) StatsHeatmap(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	bucket, err := heatmapDuration(params.Get("bucket"), time.Minute)
+	if err != nil {
+		http.Error(w, "invalid bucket: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rng, err := heatmapDuration(params.Get("range"), time.Hour)
+	if err != nil {
+		http.Error(w, "invalid range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns := int((rng + bucket - 1) / bucket)
+	if columns > maxHeatmapColumns {
+		http.Error(w, fmt.Sprintf("range/bucket is %d buckets; at most %d", columns, maxHeatmapColumns), http.StatusBadRequest)
+		return
+	}
+
+	q := SpanQuery{
+		Attrs:     make(map[string]string),
+		Status:    params.Get("status"),
+		Operation: params.Get("operation"),
+	}
+	for key, values := range params {
+		if name, ok := strings.CutPrefix(key, "attr."); ok && name != "" && len(values) > 0 {
+			q.Attrs[name] = values[0]
+		}
+	}
+
+	// The last bucket is the one in progress, so the heatmap ends now.
+	end := time.Now().Truncate(bucket).Add(bucket)
+	start := end.Add(-time.Duration(columns) * bucket)
+	var spans []protocol.TraceSpan
+	for _, span := range h.store.Since(start) {
+		if q.matches(span) {
+			spans = append(spans, span)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LatencyHeatmap(spans, start, bucket, columns))
+}
+
+// heatmapDuration parses a positive duration parameter, or returns def if
+// it is empty.
+func heatmapDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be > 0")
+	}
+	return d, nil
+}
@@ -0,0 +1,87 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestLatencyHeatmap(t *testing.T) {
+	start := time.Unix(1000, 0)
+	span := func(at time.Duration, latency time.Duration) protocol.TraceSpan {
+		s := start.Add(at).UnixNano()
+		return protocol.TraceSpan{StartNS: s, EndNS: s + int64(latency)}
+	}
+	spans := []protocol.TraceSpan{
+		span(0, 3*time.Millisecond),              // bucket 0, ≤5ms
+		span(10*time.Second, 4*time.Millisecond), // bucket 0, ≤5ms
+		span(90*time.Second, 2*time.Second),      // bucket 1, ≤2500ms
+		span(90*time.Second, time.Minute),        // bucket 1, overflow
+		span(-time.Second, time.Millisecond),     // before start
+		span(3*time.Minute, time.Millisecond),    // after the last bucket
+	}
+
+	hm := LatencyHeatmap(spans, start, time.Minute, 2)
+	if hm.Total != 4 || len(hm.Counts) != 2 || hm.Times[1] != start.Add(time.Minute).UnixMilli() {
+		t.Fatalf("heatmap = %+v", hm)
+	}
+	row := func(bound float64) int {
+		for j, b := range latencyBuckets {
+			if b == bound {
+				return j
+			}
+		}
+		return len(latencyBuckets)
+	}
+	if hm.Counts[0][row(5)] != 2 {
+		t.Errorf("bucket 0 = %v", hm.Counts[0])
+	}
+	if hm.Counts[1][row(2500)] != 1 || hm.Counts[1][len(latencyBuckets)] != 1 {
+		t.Errorf("bucket 1 = %v", hm.Counts[1])
+	}
+}
+
+func TestHandlerStatsHeatmap(t *testing.T) {
+	h := newTestHandler()
+	now := time.Now().UnixNano()
+	for _, p := range []string{"fast", "fast", "slow"} {
+		latency := int64(2 * time.Millisecond)
+		if p == "slow" {
+			latency = int64(800 * time.Millisecond)
+		}
+		postSpan(t, h, protocol.TraceSpan{
+			TraceID: "t1", SpanID: p, Operation: "infer", StartNS: now, EndNS: now + latency,
+			Status: "ok", Attrs: map[string]any{"provider": p},
+		})
+	}
+
+	get := func(query string) (*httptest.ResponseRecorder, Heatmap) {
+		w := httptest.NewRecorder()
+		h.StatsHeatmap(w, httptest.NewRequest("GET", "/stats/heatmap?"+query, nil))
+		var hm Heatmap
+		json.Unmarshal(w.Body.Bytes(), &hm)
+		return w, hm
+	}
+
+	w, hm := get("bucket=1m&range=1h")
+	if w.Code != http.StatusOK || len(hm.Counts) != 60 || hm.Total != 3 || hm.Bucket != "1m0s" {
+		t.Fatalf("status %d, %d buckets, total %d", w.Code, len(hm.Counts), hm.Total)
+	}
+	if last := hm.Counts[59]; last[1] != 2 || last[8] != 1 {
+		t.Errorf("current bucket = %v, want 2 spans ≤5ms and 1 ≤1000ms", last)
+	}
+
+	if _, hm := get("attr.provider=slow"); hm.Total != 1 {
+		t.Errorf("provider filter: total = %d, want 1", hm.Total)
+	}
+
+	for _, bad := range []string{"bucket=0s", "range=x", "bucket=1s&range=24h"} {
+		if w, _ := get(bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad, w.Code)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package tokentrace
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// ImportFormats lists the formats accepted by ImportSpans.
+var ImportFormats = []string{"jaeger", "otlp-json", "csv"}
+
+// ImportSpans converts a trace dump produced outside MIST into spans, so
+// traces recorded before adopting MIST can be loaded into TokenTrace.
+// Supported formats:
+//
+//	jaeger     Jaeger JSON, as served by /api/traces and the UI's download
+//	otlp-json  OTLP/JSON ExportTraceServiceRequest documents, one or more
+//	           (the OpenTelemetry Collector file exporter writes one per line)
+//	csv        a header row naming trace_id, span_id, parent_id, operation,
+//	           start_ns, and end_ns or duration_ms, with an optional status;
+//	           any other column becomes a span attribute
+//
+// Tags and attributes become span attrs and the service name is kept as
+// the "service" attr.
+func ImportSpans(r io.Reader, format string) ([]protocol.TraceSpan, error) {
+	var spans []protocol.TraceSpan
+	err := ImportEach(r, format, func(span protocol.TraceSpan) error {
+		spans = append(spans, span)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spans, nil
+}
+
+// ImportEach is ImportSpans for dumps too large to hold in memory: it
+// decodes r as it goes and calls fn with each span, holding at most one
+// Jaeger trace, OTLP document, or CSV row at a time. It stops at the
+// first error from the decode or from fn.
+func ImportEach(r io.Reader, format string, fn func(protocol.TraceSpan) error) error {
+	switch format {
+	case "jaeger":
+		return importJaeger(r, fn)
+	case "otlp-json":
+		return importOTLP(r, fn)
+	case "csv":
+		return importCSV(r, fn)
+	}
+	return fmt.Errorf("tokentrace: import: unknown format %q (want %s)", format, strings.Join(ImportFormats, ", "))
+}
+
+// jaegerTrace is one element of a Jaeger dump's data array.
+type jaegerTrace struct {
+	Spans []struct {
+		TraceID       string `json:"traceID"`
+		SpanID        string `json:"spanID"`
+		OperationName string `json:"operationName"`
+		References    []struct {
+			RefType string `json:"refType"`
+			SpanID  string `json:"spanID"`
+		} `json:"references"`
+		StartTime int64       `json:"startTime"` // microseconds
+		Duration  int64       `json:"duration"`  // microseconds
+		Tags      []jaegerTag `json:"tags"`
+		ProcessID string      `json:"processID"`
+	} `json:"spans"`
+	Processes map[string]struct {
+		ServiceName string `json:"serviceName"`
+	} `json:"processes"`
+}
+
+type jaegerTag struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// importJaeger decodes the dump's data array a trace at a time; the
+// trace is the smallest unit, as its processes may follow its spans.
+func importJaeger(r io.Reader, fn func(protocol.TraceSpan) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return fmt.Errorf("tokentrace: import jaeger: %w", err)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("tokentrace: import jaeger: %w", err)
+		}
+		if key != "data" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fmt.Errorf("tokentrace: import jaeger: %w", err)
+			}
+			continue
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return fmt.Errorf("tokentrace: import jaeger: data: %w", err)
+		}
+		for dec.More() {
+			var tr jaegerTrace
+			if err := dec.Decode(&tr); err != nil {
+				return fmt.Errorf("tokentrace: import jaeger: %w", err)
+			}
+			if err := jaegerSpans(tr, fn); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return fmt.Errorf("tokentrace: import jaeger: data: %w", err)
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return fmt.Errorf("tokentrace: import jaeger: %w", err)
+	}
+	return nil
+}
+
+// expectDelim reads the next token from dec and checks it is want.
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("expected %v, got %v", want, tok)
+	}
+	return nil
+}
+
+func jaegerSpans(tr jaegerTrace, fn func(protocol.TraceSpan) error) error {
+	for _, js := range tr.Spans {
+		span := protocol.TraceSpan{
+			TraceID:   js.TraceID,
+			SpanID:    js.SpanID,
+			Operation: js.OperationName,
+			StartNS:   js.StartTime * 1000,
+			EndNS:     (js.StartTime + js.Duration) * 1000,
+			Status:    "ok",
+			Attrs:     make(map[string]any),
+		}
+		for _, ref := range js.References {
+			if ref.RefType == "CHILD_OF" || span.ParentID == "" {
+				span.ParentID = ref.SpanID
+			}
+		}
+		if p, ok := tr.Processes[js.ProcessID]; ok && p.ServiceName != "" {
+			span.Attrs["service"] = p.ServiceName
+		}
+		for _, tag := range js.Tags {
+			if tag.Key == "error" && (tag.Value == true || tag.Value == "true") {
+				span.Status = "error"
+				continue
+			}
+			span.Attrs[tag.Key] = tag.Value
+		}
+		if err := fn(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+		// Deprecated name for scopeSpans, still written by older SDKs.
+		InstrumentationLibrarySpans []otlpScopeSpans `json:"instrumentationLibrarySpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpScopeSpans struct {
+	Spans []struct {
+		TraceID      string         `json:"traceId"`
+		SpanID       string         `json:"spanId"`
+		ParentSpanID string         `json:"parentSpanId"`
+		Name         string         `json:"name"`
+		Start        string         `json:"startTimeUnixNano"`
+		End          string         `json:"endTimeUnixNano"`
+		Attributes   []otlpKeyValue `json:"attributes"`
+		Status       struct {
+			Code any `json:"code"` // 2 or "STATUS_CODE_ERROR" for errors
+		} `json:"status"`
+	} `json:"spans"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue"`
+	BoolValue   *bool    `json:"boolValue"`
+	IntValue    any      `json:"intValue"` // a string in OTLP/JSON, a number in some exporters
+	DoubleValue *float64 `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpValue `json:"values"`
+	} `json:"arrayValue"`
+}
+
+func (v otlpValue) value() any {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		if n, err := strconv.ParseInt(fmt.Sprint(v.IntValue), 10, 64); err == nil {
+			return n
+		}
+		return v.IntValue
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		vals := make([]any, len(v.ArrayValue.Values))
+		for i, e := range v.ArrayValue.Values {
+			vals[i] = e.value()
+		}
+		return vals
+	}
+	return nil
+}
+
+func importOTLP(r io.Reader, fn func(protocol.TraceSpan) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var req otlpRequest
+		if err := dec.Decode(&req); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("tokentrace: import otlp-json: %w", err)
+		}
+
+		for _, rs := range req.ResourceSpans {
+			var service string
+			for _, kv := range rs.Resource.Attributes {
+				if kv.Key == "service.name" && kv.Value.StringValue != nil {
+					service = *kv.Value.StringValue
+				}
+			}
+			for _, ss := range append(rs.ScopeSpans, rs.InstrumentationLibrarySpans...) {
+				for _, s := range ss.Spans {
+					start, err1 := strconv.ParseInt(s.Start, 10, 64)
+					end, err2 := strconv.ParseInt(s.End, 10, 64)
+					if err := errors.Join(err1, err2); err != nil {
+						return fmt.Errorf("tokentrace: import otlp-json: span %s: %w", s.SpanID, err)
+					}
+					span := protocol.TraceSpan{
+						TraceID:   s.TraceID,
+						SpanID:    s.SpanID,
+						ParentID:  s.ParentSpanID,
+						Operation: s.Name,
+						StartNS:   start,
+						EndNS:     end,
+						Status:    "ok",
+						Attrs:     make(map[string]any, len(s.Attributes)+1),
+					}
+					if code := fmt.Sprint(s.Status.Code); code == "2" || code == "STATUS_CODE_ERROR" {
+						span.Status = "error"
+					}
+					if service != "" {
+						span.Attrs["service"] = service
+					}
+					for _, kv := range s.Attributes {
+						span.Attrs[kv.Key] = kv.Value.value()
+					}
+					if err := fn(span); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+func importCSV(r io.Reader, fn func(protocol.TraceSpan) error) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("tokentrace: import csv: header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"trace_id", "span_id", "operation", "start_ns"} {
+		if _, ok := col[name]; !ok {
+			return fmt.Errorf("tokentrace: import csv: missing %s column", name)
+		}
+	}
+	_, hasEnd := col["end_ns"]
+	_, hasDuration := col["duration_ms"]
+	if !hasEnd && !hasDuration {
+		return fmt.Errorf("tokentrace: import csv: need an end_ns or duration_ms column")
+	}
+
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tokentrace: import csv: %w", err)
+		}
+
+		span := protocol.TraceSpan{Status: "ok", Attrs: make(map[string]any)}
+		for i, name := range header {
+			v := rec[i]
+			switch strings.TrimSpace(name) {
+			case "trace_id":
+				span.TraceID = v
+			case "span_id":
+				span.SpanID = v
+			case "parent_id":
+				span.ParentID = v
+			case "operation":
+				span.Operation = v
+			case "status":
+				if v != "" {
+					span.Status = v
+				}
+			case "start_ns":
+				span.StartNS, err = strconv.ParseInt(v, 10, 64)
+			case "end_ns":
+				span.EndNS, err = strconv.ParseInt(v, 10, 64)
+			case "duration_ms":
+				if !hasEnd {
+					var ms float64
+					ms, err = strconv.ParseFloat(v, 64)
+					span.EndNS = int64(ms * 1e6) // start is added below
+				}
+			default:
+				if v != "" {
+					span.Attrs[name] = csvValue(v)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("tokentrace: import csv: line %d: %s: %w", line, name, err)
+			}
+		}
+		if !hasEnd {
+			span.EndNS += span.StartNS
+		}
+		if err := fn(span); err != nil {
+			return err
+		}
+	}
+}
+
+// csvValue keeps numeric cells as numbers so they aggregate like spans
+// reported natively.
+func csvValue(s string) any {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
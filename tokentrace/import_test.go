@@ -0,0 +1,127 @@
+package tokentrace
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestImportJaeger(t *testing.T) {
+	dump := `{"data":[{"traceID":"abc","spans":[
+		{"traceID":"abc","spanID":"s1","operationName":"chat","references":[],
+		 "startTime":1000,"duration":250,"processID":"p1",
+		 "tags":[{"key":"model","type":"string","value":"gpt-4o"},{"key":"tokens_in","type":"int64","value":12}]},
+		{"traceID":"abc","spanID":"s2","operationName":"http","references":[{"refType":"CHILD_OF","traceID":"abc","spanID":"s1"}],
+		 "startTime":1010,"duration":100,"processID":"p1","tags":[{"key":"error","type":"bool","value":true}]}
+	],"processes":{"p1":{"serviceName":"gateway","tags":[]}}}]}`
+
+	spans, err := ImportSpans(strings.NewReader(dump), "jaeger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	s := spans[0]
+	if s.TraceID != "abc" || s.Operation != "chat" || s.StartNS != 1_000_000 || s.EndNS != 1_250_000 {
+		t.Errorf("span = %+v", s)
+	}
+	if s.Attrs["model"] != "gpt-4o" || s.Attrs["service"] != "gateway" || s.Status != "ok" {
+		t.Errorf("attrs = %v, status = %s", s.Attrs, s.Status)
+	}
+	if spans[1].ParentID != "s1" || spans[1].Status != "error" {
+		t.Errorf("child = %+v", spans[1])
+	}
+}
+
+func TestImportOTLP(t *testing.T) {
+	doc := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"infer"}}]},
+	 "scopeSpans":[{"spans":[{"traceId":"t1","spanId":"a","name":"generate",
+	  "startTimeUnixNano":"100","endTimeUnixNano":"300",
+	  "attributes":[{"key":"tokens_out","value":{"intValue":"42"}},{"key":"cached","value":{"boolValue":true}}],
+	  "status":{"code":2}}]}]}]}
+{"resourceSpans":[{"resource":{},"instrumentationLibrarySpans":[{"spans":[{"traceId":"t2","spanId":"b","parentSpanId":"a","name":"old",
+	  "startTimeUnixNano":"1","endTimeUnixNano":"2","status":{"code":"STATUS_CODE_OK"}}]}]}]}`
+
+	spans, err := ImportSpans(strings.NewReader(doc), "otlp-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	s := spans[0]
+	if s.Operation != "generate" || s.EndNS-s.StartNS != 200 || s.Status != "error" {
+		t.Errorf("span = %+v", s)
+	}
+	if s.Attrs["tokens_out"] != int64(42) || s.Attrs["cached"] != true || s.Attrs["service"] != "infer" {
+		t.Errorf("attrs = %v", s.Attrs)
+	}
+	if spans[1].ParentID != "a" || spans[1].Status != "ok" {
+		t.Errorf("legacy span = %+v", spans[1])
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	data := "trace_id,span_id,parent_id,operation,start_ns,duration_ms,status,model,cost_usd\n" +
+		"t1,s1,,chat,1000,1.5,,gpt-4o,0.002\n" +
+		"t1,s2,s1,tool,2000,2,error,,\n"
+
+	spans, err := ImportSpans(strings.NewReader(data), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	if s := spans[0]; s.EndNS != 1000+1_500_000 || s.Status != "ok" || s.Attrs["model"] != "gpt-4o" || s.Attrs["cost_usd"] != 0.002 {
+		t.Errorf("span = %+v", s)
+	}
+	if s := spans[1]; s.ParentID != "s1" || s.Status != "error" || len(s.Attrs) != 0 {
+		t.Errorf("span = %+v", s)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	cases := []struct{ format, data string }{
+		{"zipkin", ""},
+		{"csv", "trace_id,span_id,operation\n"},
+		{"csv", "trace_id,span_id,operation,start_ns,end_ns\nt,s,op,soon,2\n"},
+		{"jaeger", "{"},
+		{"otlp-json", `{"resourceSpans":[{"scopeSpans":[{"spans":[{"startTimeUnixNano":"x","endTimeUnixNano":"1"}]}]}]}`},
+	}
+	for _, c := range cases {
+		if _, err := ImportSpans(strings.NewReader(c.data), c.format); err == nil {
+			t.Errorf("%s %q: expected error", c.format, c.data)
+		}
+	}
+}
+
+func TestImportEach(t *testing.T) {
+	dump := `{"total":2,"data":[
+		{"spans":[{"traceID":"t1","spanID":"a"}],"processes":{}},
+		{"spans":[{"traceID":"t2","spanID":"b"}],"processes":{}}
+	],"errors":null}`
+
+	var ids []string
+	err := ImportEach(strings.NewReader(dump), "jaeger", func(span protocol.TraceSpan) error {
+		ids = append(ids, span.TraceID)
+		return nil
+	})
+	if err != nil || strings.Join(ids, ",") != "t1,t2" {
+		t.Errorf("traces = %v, err = %v", ids, err)
+	}
+
+	// An error from fn stops the import.
+	stop := errors.New("stop")
+	calls := 0
+	err = ImportEach(strings.NewReader("trace_id,span_id,operation,start_ns,end_ns\nt,a,op,1,2\nt,b,op,1,2\n"), "csv", func(protocol.TraceSpan) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("err = %v after %d calls", err, calls)
+	}
+}
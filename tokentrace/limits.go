@@ -0,0 +1,108 @@
+package tokentrace
+
+import (
+	"sync"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/resource"
+)
+
+// ingestLimits bounds the spans Ingest processes at once per message
+// source and per operation, so one client flooding TokenTrace with huge
+// spans is refused with 429 instead of starving the others. Each key
+// gets its own resource.Limiter; keys beyond maxKeys share the limiter
+// of OtherOperation, as in the aggregator's per-operation breakdown.
+type ingestLimits struct {
+	perSource, perOperation int // 0 means unlimited
+	maxKeys                 int
+	reg                     *metrics.Registry
+
+	mu         sync.Mutex
+	sources    map[string]*limitedKey
+	operations map[string]*limitedKey
+}
+
+// limitedKey is the limiter and fairness counters of one source or
+// operation.
+type limitedKey struct {
+	lim       *resource.Limiter
+	inflight  *metrics.Gauge
+	admitted  *metrics.Counter
+	throttled *metrics.Counter
+}
+
+func newIngestLimits(perSource, perOperation, maxKeys int, reg *metrics.Registry) *ingestLimits {
+	return &ingestLimits{
+		perSource:    perSource,
+		perOperation: perOperation,
+		maxKeys:      maxKeys,
+		reg:          reg,
+		sources:      make(map[string]*limitedKey),
+		operations:   make(map[string]*limitedKey),
+	}
+}
+
+// acquire claims a slot for a span from source with operation. It
+// returns a func that releases the slots, or "source" or "operation"
+// naming the limit that was reached. Spans are refused rather than
+// queued: a sender told 429 backs off, while a queue would let the
+// flood hold memory anyway.
+func (l *ingestLimits) acquire(source, operation string) (release func(), limit string) {
+	var src, op *limitedKey
+	if l.perSource > 0 {
+		src = l.key(l.sources, "source", source, l.perSource)
+		if !src.lim.TryAcquire() {
+			src.throttled.Inc()
+			return nil, "source"
+		}
+	}
+	if l.perOperation > 0 {
+		op = l.key(l.operations, "operation", operation, l.perOperation)
+		if !op.lim.TryAcquire() {
+			op.throttled.Inc()
+			if src != nil {
+				src.lim.Release()
+			}
+			return nil, "operation"
+		}
+	}
+	for _, k := range []*limitedKey{src, op} {
+		if k != nil {
+			k.admitted.Inc()
+			k.inflight.Inc()
+		}
+	}
+	return func() {
+		for _, k := range []*limitedKey{src, op} {
+			if k != nil {
+				k.inflight.Dec()
+				k.lim.Release()
+			}
+		}
+	}, ""
+}
+
+// key returns the limiter for name in keys, creating it on first use.
+// kind labels its metrics: ingest_inflight, ingest_admitted_total, and
+// ingest_throttled_total, each with a source or operation label.
+func (l *ingestLimits) key(keys map[string]*limitedKey, kind, name string, max int) *limitedKey {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if k, ok := keys[name]; ok {
+		return k
+	}
+	if len(keys) >= l.maxKeys {
+		name = OtherOperation
+		if k, ok := keys[name]; ok {
+			return k
+		}
+	}
+	k := &limitedKey{
+		lim:       resource.NewLimiter(kind+":"+name, max),
+		inflight:  l.reg.Gauge("ingest_inflight", kind, name),
+		admitted:  l.reg.Counter("ingest_admitted_total", kind, name),
+		throttled: l.reg.Counter("ingest_throttled_total", kind, name),
+	}
+	keys[name] = k
+	return k
+}
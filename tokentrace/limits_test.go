@@ -0,0 +1,72 @@
+package tokentrace
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestHandlerConcurrencyLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrentPerSource = 1
+	cfg.MaxConcurrentPerOperation = 2
+	h := NewHandler(cfg)
+	span := protocol.TraceSpan{TraceID: "t1", SpanID: "s1", Operation: "infer", EndNS: 1e6, Status: "ok"}
+
+	// Hold the only slot of postSpan's source, as a slow ingest would.
+	release, limit := h.limits.acquire("tokentrace-test", "other")
+	if release == nil {
+		t.Fatalf("acquire refused by %s limit", limit)
+	}
+	w := postSpan(t, h, span)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After %q; want 429", w.Code, w.Header().Get("Retry-After"))
+	}
+	release()
+	if w := postSpan(t, h, span); w.Code != http.StatusAccepted {
+		t.Fatalf("after release: status = %d", w.Code)
+	}
+
+	reg := h.Aggregator().Registry()
+	if n := reg.Counter("ingest_throttled_total", "source", "tokentrace-test").Value(); n != 1 {
+		t.Errorf("throttled = %d, want 1", n)
+	}
+	if n := reg.Counter("ingest_admitted_total", "source", "tokentrace-test").Value(); n != 2 {
+		t.Errorf("admitted = %d, want 2", n)
+	}
+	if g := reg.Gauge("ingest_inflight", "source", "tokentrace-test").Value(); g != 0 {
+		t.Errorf("inflight = %g, want 0", g)
+	}
+}
+
+func TestIngestLimitsPerOperation(t *testing.T) {
+	reg := metrics.NewRegistry()
+	l := newIngestLimits(0, 2, 2, reg)
+
+	r1, _ := l.acquire("a", "infer")
+	r2, _ := l.acquire("b", "infer")
+	if r, limit := l.acquire("c", "infer"); r != nil || limit != "operation" {
+		t.Fatalf("third infer span admitted (limit %q)", limit)
+	}
+	// Other operations are unaffected by the flood.
+	r3, _ := l.acquire("c", "embed")
+	if r3 == nil {
+		t.Fatal("embed span refused while infer is saturated")
+	}
+	r1()
+	if r, _ := l.acquire("c", "infer"); r == nil {
+		t.Error("infer span refused after a release")
+	} else {
+		r()
+	}
+	r2()
+	r3()
+
+	// Keys beyond maxKeys share one limiter.
+	l.acquire("a", "op3")
+	if _, ok := l.operations[OtherOperation]; !ok {
+		t.Errorf("operations = %v, want overflow under %s", len(l.operations), OtherOperation)
+	}
+}
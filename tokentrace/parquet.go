@@ -0,0 +1,315 @@
+package tokentrace
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// This file holds a minimal Parquet writer: row groups of uncompressed,
+// PLAIN-encoded OPTIONAL columns, which every Parquet reader accepts.
+// The footer is Thrift compact protocol, written by hand to stay within
+// the standard library.
+
+// Parquet physical types.
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Thrift compact protocol type IDs.
+const (
+	ctI32    = 5
+	ctI64    = 6
+	ctBinary = 8
+	ctList   = 9
+	ctStruct = 12
+)
+
+// columnKind accumulates the values of a column to pick its physical
+// type: INT64 if every value is an integer, DOUBLE if every value is a
+// number, BOOLEAN if every value is a bool, and otherwise a UTF-8
+// string.
+type columnKind struct {
+	n                       int // non-null values
+	notInt, notNum, notBool bool
+	text                    bool
+}
+
+func (k *columnKind) observe(v any) {
+	if v == nil {
+		return
+	}
+	k.n++
+	switch v.(type) {
+	case int64:
+		k.notBool = true
+	case float64:
+		k.notInt, k.notBool = true, true
+	case bool:
+		k.notInt, k.notNum = true, true
+	default:
+		k.text = true
+	}
+}
+
+func (k *columnKind) physical() int32 {
+	switch {
+	case k.n == 0 || k.text:
+		return parquetByteArray
+	case !k.notInt:
+		return parquetInt64
+	case !k.notNum:
+		return parquetDouble
+	case !k.notBool:
+		return parquetBoolean
+	}
+	return parquetByteArray
+}
+
+// parquetPage encodes values, whose non-null entries all fit typ, as a
+// data page: definition levels, then the non-null values.
+func parquetPage(typ int32, values []any) []byte {
+	// Definition levels as bit-packed runs of the RLE hybrid encoding.
+	groups := (len(values) + 7) / 8
+	levels := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	packed := make([]byte, groups)
+	for i, v := range values {
+		if v != nil {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	levels = append(levels, packed...)
+
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	data = append(data, levels...)
+
+	var bits []byte
+	nbits := 0
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		switch typ {
+		case parquetInt64:
+			data = binary.LittleEndian.AppendUint64(data, uint64(v.(int64)))
+		case parquetDouble:
+			f, _ := v.(float64)
+			if n, ok := v.(int64); ok {
+				f = float64(n)
+			}
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(f))
+		case parquetBoolean:
+			if nbits%8 == 0 {
+				bits = append(bits, 0)
+			}
+			if v.(bool) {
+				bits[nbits/8] |= 1 << (nbits % 8)
+			}
+			nbits++
+		default:
+			s := cellString(v)
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(s)))
+			data = append(data, s...)
+		}
+	}
+	return append(data, bits...)
+}
+
+// parquetWriter writes a Parquet file a row group at a time, keeping
+// only the row groups' locations for the footer.
+type parquetWriter struct {
+	w      io.Writer
+	names  []string
+	types  []int32
+	offset int64
+	groups []parquetGroup
+}
+
+// parquetGroup locates a written row group's column chunks.
+type parquetGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	offset, size int64
+}
+
+// newParquetWriter starts a file with the named columns of the given
+// physical types.
+func newParquetWriter(w io.Writer, names []string, types []int32) (*parquetWriter, error) {
+	if _, err := io.WriteString(w, "PAR1"); err != nil {
+		return nil, err
+	}
+	return &parquetWriter{w: w, names: names, types: types, offset: 4}, nil
+}
+
+// writeGroup writes a row group from columns, one slice of values per
+// column, all the same length; nil values are nulls.
+func (p *parquetWriter) writeGroup(columns [][]any) error {
+	rows := len(columns[0])
+	g := parquetGroup{rows: int64(rows), chunks: make([]parquetChunk, len(columns))}
+	for i, values := range columns {
+		data := parquetPage(p.types[i], values)
+
+		var h thriftWriter
+		h.begin()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.beginStruct(5)
+		h.i32(1, int32(rows))
+		h.i32(2, 0) // PLAIN
+		h.i32(3, 3) // RLE definition levels
+		h.i32(4, 3) // RLE repetition levels
+		h.end()
+		h.end()
+
+		if _, err := p.w.Write(h.buf); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(data); err != nil {
+			return err
+		}
+		size := int64(len(h.buf) + len(data))
+		g.chunks[i] = parquetChunk{p.offset, size}
+		p.offset += size
+	}
+	p.groups = append(p.groups, g)
+	return nil
+}
+
+// close writes the footer.
+func (p *parquetWriter) close() error {
+	var rows int64
+	for _, g := range p.groups {
+		rows += g.rows
+	}
+
+	var m thriftWriter
+	m.begin()
+	m.i32(1, 1) // version
+	m.list(2, ctStruct, len(p.names)+1)
+	m.begin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(p.names)))
+	m.end()
+	for i, name := range p.names {
+		m.begin()
+		m.i32(1, p.types[i])
+		m.i32(3, 1) // OPTIONAL
+		m.str(4, name)
+		if p.types[i] == parquetByteArray {
+			m.i32(6, 0) // UTF8
+		}
+		m.end()
+	}
+	m.i64(3, rows)
+	m.list(4, ctStruct, len(p.groups))
+	for _, g := range p.groups {
+		m.begin()
+		m.list(1, ctStruct, len(p.names))
+		var total int64
+		for i, name := range p.names {
+			c := g.chunks[i]
+			total += c.size
+			m.begin()
+			m.i64(2, c.offset)
+			m.beginStruct(3)
+			m.i32(1, p.types[i])
+			m.list(2, ctI32, 2)
+			m.listI32(0) // PLAIN
+			m.listI32(3) // RLE
+			m.list(3, ctBinary, 1)
+			m.listStr(name)
+			m.i32(4, 0) // UNCOMPRESSED
+			m.i64(5, g.rows)
+			m.i64(6, c.size)
+			m.i64(7, c.size)
+			m.i64(9, c.offset)
+			m.end()
+			m.end()
+		}
+		m.i64(2, total)
+		m.i64(3, g.rows)
+		m.end()
+	}
+	m.str(6, "mist-go tokentrace")
+	m.end()
+
+	footer := binary.LittleEndian.AppendUint32(m.buf, uint32(len(m.buf)))
+	footer = append(footer, "PAR1"...)
+	_, err := p.w.Write(footer)
+	return err
+}
+
+// thriftWriter encodes structs in the Thrift compact protocol.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // previous field ID in each open struct
+}
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
+
+// begin opens a struct that is the top-level value or a list element.
+func (t *thriftWriter) begin() { t.last = append(t.last, 0) }
+
+// beginStruct opens a struct-typed field.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, ctStruct)
+	t.begin()
+}
+
+// end closes the innermost struct.
+func (t *thriftWriter) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		t.buf = append(t.buf, byte(d)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendUvarint(t.buf, zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, ctI32)
+	t.buf = binary.AppendUvarint(t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, ctI64)
+	t.buf = binary.AppendUvarint(t.buf, zigzag(v))
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, ctBinary)
+	t.listStr(s)
+}
+
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, ctList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.buf = binary.AppendUvarint(t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) listStr(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
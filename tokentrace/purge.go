@@ -0,0 +1,227 @@
+package tokentrace
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/trace"
+)
+
+// maxAuditRecords bounds the in-memory audit trail; OnAudit sees every
+// record.
+const maxAuditRecords = 1000
+
+// DeleteFunc removes every stored span for which del returns true and
+// returns the number removed. The remaining spans keep their order.
+func (s *Store) DeleteFunc(del func(protocol.TraceSpan) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keep []protocol.TraceSpan
+	var keepSeqs []uint64
+	seqs := s.orderedSeqs()
+	for i, span := range s.ordered() {
+		if !del(span) {
+			keep = append(keep, span)
+			keepSeqs = append(keepSeqs, seqs[i])
+		}
+	}
+	n := s.count - len(keep)
+	if n == 0 {
+		return 0
+	}
+
+	// Rebuild the buffer and indexes from the survivors. Deletes are rare
+	// compliance operations, so a full rebuild beats leaving holes in the
+	// ring.
+	s.rebuild(keep, keepSeqs, s.cap)
+	return n
+}
+
+// AuditRecord records a deletion of spans, by request or by retention
+// expiry. It never contains span contents.
+type AuditRecord struct {
+	ID       string            `json:"id"`
+	Time     time.Time         `json:"time"`
+	Action   string            `json:"action"` // "delete_traces", "delete_spans", or "retention"
+	Criteria map[string]string `json:"criteria,omitempty"`
+	Deleted  int               `json:"deleted"`
+	Actor    string            `json:"actor,omitempty"` // remote address of the request
+	Reason   string            `json:"reason,omitempty"`
+}
+
+func (h *Handler) recordAudit(rec AuditRecord) AuditRecord {
+	rec.ID = trace.NewID()
+	rec.Time = time.Now().UTC()
+
+	h.auditMu.Lock()
+	h.audit = append(h.audit, rec)
+	if len(h.audit) > maxAuditRecords {
+		h.audit = h.audit[len(h.audit)-maxAuditRecords:]
+	}
+	h.auditMu.Unlock()
+
+	if h.OnAudit != nil {
+		h.OnAudit(rec)
+	}
+	return rec
+}
+
+// AuditLog returns the most recent deletion records, oldest first.
+func (h *Handler) AuditLog() []AuditRecord {
+	h.auditMu.Lock()
+	defer h.auditMu.Unlock()
+	return append([]AuditRecord(nil), h.audit...)
+}
+
+// DeleteResponse is the JSON body for DELETE /traces and DELETE /spans.
+type DeleteResponse struct {
+	Deleted int         `json:"deleted"`
+	Audit   AuditRecord `json:"audit"`
+}
+
+// DeleteTraces handles DELETE /traces?tenant=X&reason= — purges every
+// trace with a span for the tenant, including the trace's spans that
+// carry no tenant attr. Aggregated metrics are counts and are kept. It
+// requires Config.DeleteToken, as DeleteSpans does.
+func (h *Handler) DeleteTraces(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeDelete(w, r) {
+		return
+	}
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		http.Error(w, "tenant required", http.StatusBadRequest)
+		return
+	}
+
+	traces := make(map[string]bool)
+	for _, span := range h.store.Search(SpanQuery{Attrs: map[string]string{"tenant": tenant}}) {
+		traces[span.TraceID] = true
+	}
+	n := h.store.DeleteFunc(func(span protocol.TraceSpan) bool { return traces[span.TraceID] })
+
+	h.writeDelete(w, r, "delete_traces", map[string]string{"tenant": tenant}, n)
+}
+
+// DeleteSpans handles DELETE /spans?attr.<key>=<value>&status=&operation=&reason=
+// — purges spans matching all given criteria, e.g. attr.user_id=Y to
+// erase one user's prompts. At least one attr criterion is required.
+// It requires Config.DeleteToken as a bearer token.
+func (h *Handler) DeleteSpans(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeDelete(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	q := SpanQuery{
+		Attrs:     make(map[string]string),
+		Status:    params.Get("status"),
+		Operation: params.Get("operation"),
+	}
+	criteria := make(map[string]string)
+	for key, values := range params {
+		if name, ok := strings.CutPrefix(key, "attr."); ok && name != "" && len(values) > 0 {
+			q.Attrs[name] = values[0]
+			criteria[key] = values[0]
+		}
+	}
+	if len(q.Attrs) == 0 {
+		http.Error(w, "at least one attr.<key> criterion required", http.StatusBadRequest)
+		return
+	}
+	if q.Status != "" {
+		criteria["status"] = q.Status
+	}
+	if q.Operation != "" {
+		criteria["operation"] = q.Operation
+	}
+
+	n := h.store.DeleteFunc(q.matches)
+	h.writeDelete(w, r, "delete_spans", criteria, n)
+}
+
+// authorizeDelete reports whether r carries the delete token, answering
+// it with 403 if deletion is disabled or 401 if the token is missing or
+// wrong.
+func (h *Handler) authorizeDelete(w http.ResponseWriter, r *http.Request) bool {
+	if h.deleteToken == "" {
+		http.Error(w, "deletion is disabled; set delete_token to enable it", http.StatusForbidden)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.deleteToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) writeDelete(w http.ResponseWriter, r *http.Request, action string, criteria map[string]string, n int) {
+	rec := h.recordAudit(AuditRecord{
+		Action:   action,
+		Criteria: criteria,
+		Deleted:  n,
+		Actor:    r.RemoteAddr,
+		Reason:   r.URL.Query().Get("reason"),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeleteResponse{Deleted: n, Audit: rec})
+}
+
+// Audit handles GET /audit — the most recent deletion records.
+func (h *Handler) Audit(w http.ResponseWriter, r *http.Request) {
+	recs := h.AuditLog()
+	if recs == nil {
+		recs = []AuditRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
+// ExpireRetention deletes spans older than their tenant's retention
+// (Config.Retention) as of now, and returns the number deleted. A
+// non-zero deletion is recorded in the audit log.
+func (h *Handler) ExpireRetention(now time.Time) int {
+	if len(h.retention) == 0 {
+		return 0
+	}
+	maxAge := make(map[string]time.Duration, len(h.retention))
+	for _, rule := range h.retention {
+		maxAge[rule.Tenant] = rule.MaxAge
+	}
+
+	n := h.store.DeleteFunc(func(span protocol.TraceSpan) bool {
+		var tenant string
+		if v, ok := span.Attrs["tenant"]; ok {
+			tenant = attrString(v)
+		}
+		age, ok := maxAge[tenant]
+		if !ok {
+			age, ok = maxAge[""]
+		}
+		return ok && span.StartNS < now.Add(-age).UnixNano()
+	})
+	if n > 0 {
+		h.recordAudit(AuditRecord{Action: "retention", Deleted: n})
+	}
+	return n
+}
+
+// RunRetention calls ExpireRetention every interval until ctx is done.
+func (h *Handler) RunRetention(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			h.ExpireRetention(now)
+		}
+	}
+}
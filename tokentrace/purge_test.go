@@ -0,0 +1,161 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+const testDeleteToken = "erase"
+
+// newDeleteHandler returns a test handler with deletion enabled.
+func newDeleteHandler() *Handler {
+	cfg := DefaultConfig()
+	cfg.MaxSpans = 1000
+	cfg.DeleteToken = testDeleteToken
+	return NewHandler(cfg)
+}
+
+// deleteRequest returns a DELETE of target carrying the delete token.
+func deleteRequest(target string) *http.Request {
+	r := httptest.NewRequest("DELETE", target, nil)
+	r.Header.Set("Authorization", "Bearer "+testDeleteToken)
+	return r
+}
+
+func TestStoreDeleteFunc(t *testing.T) {
+	s := NewStore(4, WithIndexedAttrs("user_id"))
+	for i, user := range []string{"a", "b", "a", "c", "b"} {
+		s.Add(protocol.TraceSpan{TraceID: user, SpanID: string(rune('0' + i)), Attrs: map[string]any{"user_id": user}})
+	}
+	if n := s.DeleteFunc(func(span protocol.TraceSpan) bool { return span.Attrs["user_id"] == "b" }); n != 2 {
+		t.Fatalf("deleted %d, want 2", n)
+	}
+	if s.Len() != 2 || len(s.GetTrace("b")) != 0 {
+		t.Errorf("len = %d, trace b = %v", s.Len(), s.GetTrace("b"))
+	}
+	if got := s.Search(SpanQuery{Attrs: map[string]string{"user_id": "b"}}); len(got) != 0 {
+		t.Errorf("index still has %v", got)
+	}
+
+	// The ring keeps working after a rebuild.
+	for i := 0; i < 3; i++ {
+		s.Add(protocol.TraceSpan{TraceID: "d", SpanID: "d"})
+	}
+	if recent := s.Recent(4); s.Len() != 4 || recent[3].TraceID != "c" {
+		t.Errorf("after refill: %+v", recent)
+	}
+}
+
+func TestHandlerDeleteTraces(t *testing.T) {
+	h := newDeleteHandler()
+	h.Store().Add(protocol.TraceSpan{TraceID: "t1", SpanID: "root", Attrs: map[string]any{"tenant": "acme"}})
+	h.Store().Add(protocol.TraceSpan{TraceID: "t1", SpanID: "child"})
+	h.Store().Add(protocol.TraceSpan{TraceID: "t2", SpanID: "other", Attrs: map[string]any{"tenant": "globex"}})
+	var audited []AuditRecord
+	h.OnAudit = func(rec AuditRecord) { audited = append(audited, rec) }
+
+	w := httptest.NewRecorder()
+	h.DeleteTraces(w, deleteRequest("/traces?tenant=acme&reason=erasure+request"))
+	var resp DeleteResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Deleted != 2 || h.Store().Len() != 1 {
+		t.Errorf("deleted %d, %d left", resp.Deleted, h.Store().Len())
+	}
+	if len(audited) != 1 || audited[0].Action != "delete_traces" || audited[0].Criteria["tenant"] != "acme" || audited[0].Reason != "erasure request" {
+		t.Errorf("audit = %+v", audited)
+	}
+
+	w = httptest.NewRecorder()
+	h.DeleteTraces(w, deleteRequest("/traces"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing tenant status = %d", w.Code)
+	}
+}
+
+func TestHandlerDeleteSpans(t *testing.T) {
+	h := newDeleteHandler()
+	h.Store().Add(protocol.TraceSpan{TraceID: "t1", SpanID: "1", Attrs: map[string]any{"user_id": "u1"}})
+	h.Store().Add(protocol.TraceSpan{TraceID: "t1", SpanID: "2", Attrs: map[string]any{"user_id": "u2"}})
+
+	w := httptest.NewRecorder()
+	h.DeleteSpans(w, deleteRequest("/spans?attr.user_id=u1"))
+	if w.Code != http.StatusOK || h.Store().Len() != 1 || h.Store().GetTrace("t1")[0].SpanID != "2" {
+		t.Errorf("status %d, spans %v", w.Code, h.Store().GetTrace("t1"))
+	}
+
+	w = httptest.NewRecorder()
+	h.DeleteSpans(w, deleteRequest("/spans?status=ok"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("no attr criteria status = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.Audit(w, httptest.NewRequest("GET", "/audit", nil))
+	var recs []AuditRecord
+	json.NewDecoder(w.Body).Decode(&recs)
+	if len(recs) != 1 || recs[0].Criteria["attr.user_id"] != "u1" || recs[0].Deleted != 1 {
+		t.Errorf("audit = %+v", recs)
+	}
+}
+
+func TestHandlerDeleteAuth(t *testing.T) {
+	disabled := newTestHandler()
+	disabled.Store().Add(protocol.TraceSpan{TraceID: "t1", SpanID: "1", Attrs: map[string]any{"tenant": "acme"}})
+	w := httptest.NewRecorder()
+	disabled.DeleteTraces(w, deleteRequest("/traces?tenant=acme"))
+	if w.Code != http.StatusForbidden || disabled.Store().Len() != 1 {
+		t.Errorf("without delete_token: status %d, %d spans left", w.Code, disabled.Store().Len())
+	}
+
+	h := newDeleteHandler()
+	h.Store().Add(protocol.TraceSpan{TraceID: "t1", SpanID: "1", Attrs: map[string]any{"user_id": "u1"}})
+	for _, auth := range []string{"", "Bearer wrong", testDeleteToken} {
+		r := httptest.NewRequest("DELETE", "/spans?attr.user_id=u1", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.DeleteSpans(w, r)
+		if w.Code != http.StatusUnauthorized || h.Store().Len() != 1 {
+			t.Errorf("Authorization %q: status %d, %d spans left", auth, w.Code, h.Store().Len())
+		}
+	}
+}
+
+func TestHandlerExpireRetention(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Retention = []RetentionRule{{MaxAge: 24 * time.Hour}, {Tenant: "acme", MaxAge: time.Hour}}
+	h := NewHandler(cfg)
+	now := time.Now()
+	add := func(id, tenant string, age time.Duration) {
+		span := protocol.TraceSpan{TraceID: id, SpanID: id, StartNS: now.Add(-age).UnixNano()}
+		if tenant != "" {
+			span.Attrs = map[string]any{"tenant": tenant}
+		}
+		h.Store().Add(span)
+	}
+	add("acme-old", "acme", 2*time.Hour)
+	add("acme-new", "acme", time.Minute)
+	add("other-2h", "globex", 2*time.Hour)
+	add("none-old", "", 48*time.Hour)
+
+	if n := h.ExpireRetention(now); n != 2 {
+		t.Errorf("expired %d, want 2", n)
+	}
+	for _, id := range []string{"acme-new", "other-2h"} {
+		if len(h.Store().GetTrace(id)) != 1 {
+			t.Errorf("%s expired", id)
+		}
+	}
+	if recs := h.AuditLog(); len(recs) != 1 || recs[0].Action != "retention" {
+		t.Errorf("audit = %+v", recs)
+	}
+	if n := h.ExpireRetention(now); n != 0 || len(h.AuditLog()) != 1 {
+		t.Error("empty expiry was audited")
+	}
+}
@@ -0,0 +1,471 @@
+package tokentrace
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Usage is token and cost usage for one provider and model over a
+// period. Model is empty when a provider reports usage only in total.
+type Usage struct {
+	Provider  string  `json:"provider"`
+	Model     string  `json:"model,omitempty"`
+	Requests  int64   `json:"requests,omitempty"`
+	TokensIn  int64   `json:"tokens_in"`
+	TokensOut int64   `json:"tokens_out"`
+	CostUSD   float64 `json:"cost_usd"`
+}
+
+// Tokens returns the input and output tokens together.
+func (u Usage) Tokens() int64 {
+	return u.TokensIn + u.TokensOut
+}
+
+func (u *Usage) add(o Usage) {
+	u.Requests += o.Requests
+	u.TokensIn += o.TokensIn
+	u.TokensOut += o.TokensOut
+	u.CostUSD += o.CostUSD
+}
+
+// SumUsage totals the provider, model, tokens_in, tokens_out, and
+// cost_usd attributes of the spans that started in [from, to), by
+// provider and model. Spans without a provider attribute are totalled
+// under an empty provider, and a span stored more than once, as a
+// retried report can be, counts once. A zero from or to leaves that end
+// open.
+func SumUsage(spans []protocol.TraceSpan, from, to time.Time) []Usage {
+	byKey := make(map[[2]string]*Usage)
+	seen := make(map[[2]string]bool)
+	for _, span := range spans {
+		start := time.Unix(0, span.StartNS)
+		if (!from.IsZero() && start.Before(from)) || (!to.IsZero() && !start.Before(to)) {
+			continue
+		}
+		if id := [2]string{span.TraceID, span.SpanID}; span.SpanID != "" {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+		tokensIn, okIn := number(span.Attrs["tokens_in"])
+		tokensOut, okOut := number(span.Attrs["tokens_out"])
+		cost, okCost := number(span.Attrs["cost_usd"])
+		if !okIn && !okOut && !okCost {
+			continue // not an inference span
+		}
+		provider, _ := span.Attrs["provider"].(string)
+		model, _ := span.Attrs["model"].(string)
+		key := [2]string{provider, model}
+		u, ok := byKey[key]
+		if !ok {
+			u = &Usage{Provider: provider, Model: model}
+			byKey[key] = u
+		}
+		u.add(Usage{Requests: 1, TokensIn: int64(tokensIn), TokensOut: int64(tokensOut), CostUSD: cost})
+	}
+
+	out := make([]Usage, 0, len(byKey))
+	for _, u := range byKey {
+		out = append(out, *u)
+	}
+	sortUsage(out)
+	return out
+}
+
+// number returns an attribute value as a float64, accepting the numeric
+// types spans carry after JSON decoding, CSV import, or in-process use.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func sortUsage(us []Usage) {
+	sort.Slice(us, func(i, j int) bool {
+		if us[i].Provider != us[j].Provider {
+			return us[i].Provider < us[j].Provider
+		}
+		return us[i].Model < us[j].Model
+	})
+}
+
+// UsageFetcher fetches a provider's own record of usage over [from, to),
+// typically from its billing or usage API. Rows may leave Provider empty;
+// Reconcile attributes them to the provider the fetcher is registered
+// under.
+type UsageFetcher interface {
+	FetchUsage(ctx context.Context, from, to time.Time) ([]Usage, error)
+}
+
+// UsageFetcherFunc adapts a function to a UsageFetcher.
+type UsageFetcherFunc func(ctx context.Context, from, to time.Time) ([]Usage, error)
+
+// FetchUsage calls f.
+func (f UsageFetcherFunc) FetchUsage(ctx context.Context, from, to time.Time) ([]Usage, error) {
+	return f(ctx, from, to)
+}
+
+// UsageFile returns a fetcher that reads usage exported from a
+// provider's billing console. A .json file holds a list of Usage
+// objects; anything else is read as CSV with a header naming some of
+// provider, model, requests, tokens_in, tokens_out, and cost_usd. The
+// file is taken to cover the period being reconciled.
+func UsageFile(path string) UsageFetcher {
+	return UsageFetcherFunc(func(ctx context.Context, from, to time.Time) ([]Usage, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("tokentrace: usage: %w", err)
+		}
+		defer f.Close()
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			return decodeUsageJSON(f)
+		}
+		return decodeUsageCSV(f)
+	})
+}
+
+// UsageHTTP returns a fetcher that GETs
+// endpoint?from=<RFC 3339>&to=<RFC 3339> and decodes a JSON list of
+// Usage objects, for a billing API or an internal service that proxies
+// one.
+func UsageHTTP(endpoint string, client *http.Client) UsageFetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return UsageFetcherFunc(func(ctx context.Context, from, to time.Time) ([]Usage, error) {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("tokentrace: usage: %w", err)
+		}
+		q := u.Query()
+		q.Set("from", from.UTC().Format(time.RFC3339))
+		q.Set("to", to.UTC().Format(time.RFC3339))
+		u.RawQuery = q.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("tokentrace: usage: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("tokentrace: usage: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return nil, fmt.Errorf("tokentrace: usage: %s: status %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(msg)))
+		}
+		return decodeUsageJSON(resp.Body)
+	})
+}
+
+func decodeUsageJSON(r io.Reader) ([]Usage, error) {
+	var us []Usage
+	if err := json.NewDecoder(r).Decode(&us); err != nil {
+		return nil, fmt.Errorf("tokentrace: usage: decode: %w", err)
+	}
+	return us, nil
+}
+
+func decodeUsageCSV(r io.Reader) ([]Usage, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("tokentrace: usage csv: header: %w", err)
+	}
+	var us []Usage
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return us, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tokentrace: usage csv: %w", err)
+		}
+		var u Usage
+		for i, name := range header {
+			v := strings.TrimSpace(rec[i])
+			if v == "" {
+				continue
+			}
+			name = strings.TrimSpace(name)
+			switch name {
+			case "provider":
+				u.Provider = v
+			case "model":
+				u.Model = v
+			case "requests":
+				u.Requests, err = strconv.ParseInt(v, 10, 64)
+			case "tokens_in":
+				u.TokensIn, err = strconv.ParseInt(v, 10, 64)
+			case "tokens_out":
+				u.TokensOut, err = strconv.ParseInt(v, 10, 64)
+			case "cost_usd":
+				u.CostUSD, err = strconv.ParseFloat(v, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tokentrace: usage csv: line %d: %s: %w", line, name, err)
+			}
+		}
+		us = append(us, u)
+	}
+}
+
+// UsageDiscrepancy compares local and provider-reported usage for one
+// provider, or one of its models. Deltas are relative to the provider's
+// figure: +0.12 means spans account for 12% more than the provider
+// billed, -0.12 for 12% less.
+type UsageDiscrepancy struct {
+	Provider    string  `json:"provider"`
+	Model       string  `json:"model,omitempty"` // empty for the provider total
+	Local       Usage   `json:"local"`
+	Reported    Usage   `json:"reported"`
+	TokensDelta float64 `json:"tokens_delta"`
+	CostDelta   float64 `json:"cost_delta"`
+	Flagged     bool    `json:"flagged"`
+}
+
+// Reconciliation is the result of Reconcile.
+type Reconciliation struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Threshold float64   `json:"threshold"`
+
+	// Providers has each reconciled provider's total, followed by its
+	// models when the provider reported usage per model.
+	Providers []UsageDiscrepancy `json:"providers"`
+
+	// Unreconciled lists providers seen in spans that had no fetcher,
+	// with their local usage.
+	Unreconciled []Usage `json:"unreconciled,omitempty"`
+
+	// Errors holds fetchers that failed, by provider.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Flagged returns the discrepancies above the threshold.
+func (r Reconciliation) Flagged() []UsageDiscrepancy {
+	var out []UsageDiscrepancy
+	for _, d := range r.Providers {
+		if d.Flagged {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Reconcile compares local usage, as returned by SumUsage, with what
+// each provider's fetcher reports over [from, to), flagging totals and
+// models whose token or cost figures differ by more than threshold (a
+// fraction: 0.05 for 5%). Token counts are compared only when the
+// provider reports them, as some billing APIs give cost alone.
+//
+// A failing fetcher is recorded in Errors and its provider skipped, so
+// one unreachable billing API does not hide the others' discrepancies.
+func Reconcile(ctx context.Context, local []Usage, fetchers map[string]UsageFetcher, from, to time.Time, threshold float64) Reconciliation {
+	rec := Reconciliation{From: from, To: to, Threshold: threshold, Providers: []UsageDiscrepancy{}}
+
+	localBy := make(map[string][]Usage)
+	for _, u := range local {
+		localBy[u.Provider] = append(localBy[u.Provider], u)
+	}
+	for _, p := range sortedKeys(localBy) {
+		if _, ok := fetchers[p]; !ok {
+			total := Usage{Provider: p}
+			for _, u := range localBy[p] {
+				total.add(u)
+			}
+			rec.Unreconciled = append(rec.Unreconciled, total)
+		}
+	}
+
+	for _, p := range sortedKeys(fetchers) {
+		reported, err := fetchers[p].FetchUsage(ctx, from, to)
+		if err != nil {
+			if rec.Errors == nil {
+				rec.Errors = make(map[string]string)
+			}
+			rec.Errors[p] = err.Error()
+			continue
+		}
+		rec.Providers = append(rec.Providers, reconcileProvider(p, localBy[p], reported, threshold)...)
+	}
+	return rec
+}
+
+// reconcileProvider compares one provider's usage: its total, then each
+// model if the provider broke its usage down by model.
+func reconcileProvider(provider string, local, reported []Usage, threshold float64) []UsageDiscrepancy {
+	total := UsageDiscrepancy{Provider: provider}
+	localModels := make(map[string]*Usage)
+	reportedModels := make(map[string]*Usage)
+	for _, u := range local {
+		total.Local.add(u)
+		sumInto(localModels, u)
+	}
+	byModel := false
+	for _, u := range reported {
+		if u.Provider != "" && u.Provider != provider {
+			continue
+		}
+		total.Reported.add(u)
+		if u.Model != "" {
+			byModel = true
+			sumInto(reportedModels, u)
+		}
+	}
+	out := []UsageDiscrepancy{total.compare(threshold)}
+	if !byModel {
+		return out
+	}
+
+	models := sortedKeys(localModels)
+	for m := range reportedModels {
+		if _, ok := localModels[m]; !ok {
+			models = append(models, m)
+		}
+	}
+	sort.Strings(models)
+	for _, m := range models {
+		d := UsageDiscrepancy{Provider: provider, Model: m}
+		if u := localModels[m]; u != nil {
+			d.Local = *u
+		}
+		if u := reportedModels[m]; u != nil {
+			d.Reported = *u
+		}
+		out = append(out, d.compare(threshold))
+	}
+	return out
+}
+
+func sumInto(by map[string]*Usage, u Usage) {
+	sum, ok := by[u.Model]
+	if !ok {
+		sum = &Usage{Provider: u.Provider, Model: u.Model}
+		by[u.Model] = sum
+	}
+	sum.add(u)
+}
+
+// compare fills in the deltas and whether either exceeds threshold.
+func (d UsageDiscrepancy) compare(threshold float64) UsageDiscrepancy {
+	d.Local.Provider, d.Local.Model = d.Provider, d.Model
+	d.Reported.Provider, d.Reported.Model = d.Provider, d.Model
+	d.CostDelta = relDelta(d.Local.CostUSD, d.Reported.CostUSD)
+	if d.Reported.Tokens() > 0 {
+		d.TokensDelta = relDelta(float64(d.Local.Tokens()), float64(d.Reported.Tokens()))
+	}
+	d.Flagged = math.Abs(d.CostDelta) > threshold || math.Abs(d.TokensDelta) > threshold
+	return d
+}
+
+// relDelta returns (local - reported) / reported. Usage the provider did
+// not report at all counts as a 100% discrepancy.
+func relDelta(local, reported float64) float64 {
+	switch {
+	case reported == 0 && local == 0:
+		return 0
+	case reported == 0:
+		return 1
+	}
+	return (local - reported) / reported
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UsageResponse is returned by GET /usage.
+type UsageResponse struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Usage []Usage   `json:"usage"`
+
+	// Complete is false when the store has evicted spans that started
+	// after from, so the totals undercount the period.
+	Complete bool `json:"complete"`
+}
+
+// Usage handles GET /usage?from=2025-01-01&to=2025-02-01 — token and
+// cost totals of stored spans by provider and model, for reconciling
+// against provider bills. from and to are dates or RFC 3339 times; to
+// defaults to now and from to 30 days before to.
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	to := time.Now()
+	if s := params.Get("to"); s != "" {
+		t, err := parseUsageTime(s)
+		if err != nil {
+			http.Error(w, "invalid to: "+s, http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -30)
+	if s := params.Get("from"); s != "" {
+		t, err := parseUsageTime(s)
+		if err != nil {
+			http.Error(w, "invalid from: "+s, http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageResponse{
+		From:     from,
+		To:       to,
+		Usage:    SumUsage(h.store.Since(from), from, to),
+		Complete: h.store.covers(from),
+	})
+}
+
+// parseUsageTime accepts a date (YYYY-MM-DD, midnight UTC) or an
+// RFC 3339 time.
+func parseUsageTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// covers reports whether the store still holds every span that started
+// at or after t: no span that started then has been evicted to make room.
+// Spans removed by DeleteFunc don't count; they're gone on purpose.
+func (s *Store) covers(t time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.evictedNS < t.UnixNano()
+}
@@ -0,0 +1,208 @@
+package tokentrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func usageSpan(start time.Time, provider, model string, in, out int64, cost float64) protocol.TraceSpan {
+	return protocol.TraceSpan{
+		TraceID: "t", SpanID: fmt.Sprint(start.UnixNano(), provider, model), Operation: "infer",
+		StartNS: start.UnixNano(), EndNS: start.UnixNano() + 1e6, Status: "ok",
+		Attrs: map[string]any{
+			"provider": provider, "model": model,
+			"tokens_in": float64(in), "tokens_out": out, "cost_usd": cost,
+		},
+	}
+}
+
+func TestSumUsage(t *testing.T) {
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	spans := []protocol.TraceSpan{
+		usageSpan(day, "openai", "gpt-4o", 100, 50, 0.5),
+		usageSpan(day.Add(time.Hour), "openai", "gpt-4o", 200, 100, 1),
+		usageSpan(day.Add(time.Hour), "anthropic", "claude", 10, 10, 0.1),
+		usageSpan(day.AddDate(0, 0, -1), "openai", "gpt-4o", 1000, 0, 9),  // before the period
+		usageSpan(day.Add(time.Hour), "anthropic", "claude", 10, 10, 0.1), // reported twice
+		{TraceID: "t", SpanID: "x", Operation: "route", StartNS: day.UnixNano()},
+	}
+	got := SumUsage(spans, day, day.AddDate(0, 0, 1))
+	want := []Usage{
+		{Provider: "anthropic", Model: "claude", Requests: 1, TokensIn: 10, TokensOut: 10, CostUSD: 0.1},
+		{Provider: "openai", Model: "gpt-4o", Requests: 2, TokensIn: 300, TokensOut: 150, CostUSD: 1.5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("usage = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("usage[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	local := []Usage{
+		{Provider: "openai", Model: "gpt-4o", TokensIn: 800, TokensOut: 200, CostUSD: 8.8},
+		{Provider: "openai", Model: "gpt-4o-mini", TokensIn: 1000, CostUSD: 1},
+		{Provider: "anthropic", Model: "claude", TokensIn: 100, CostUSD: 1},
+		{Provider: "local", Model: "llama", TokensIn: 5},
+	}
+	fetchers := map[string]UsageFetcher{
+		// Per model; spans missed 12% of gpt-4o spend.
+		"openai": UsageFetcherFunc(func(ctx context.Context, from, to time.Time) ([]Usage, error) {
+			return []Usage{
+				{Model: "gpt-4o", TokensIn: 800, TokensOut: 200, CostUSD: 10},
+				{Model: "gpt-4o-mini", TokensIn: 1000, CostUSD: 1},
+			}, nil
+		}),
+		// Cost only, in total: within threshold.
+		"anthropic": UsageFetcherFunc(func(ctx context.Context, from, to time.Time) ([]Usage, error) {
+			return []Usage{{CostUSD: 1.02}}, nil
+		}),
+		"broken": UsageFetcherFunc(func(ctx context.Context, from, to time.Time) ([]Usage, error) {
+			return nil, errors.New("billing API down")
+		}),
+	}
+	rec := Reconcile(context.Background(), local, fetchers, time.Time{}, time.Time{}, 0.05)
+
+	byKey := make(map[string]UsageDiscrepancy)
+	for _, d := range rec.Providers {
+		byKey[d.Provider+"/"+d.Model] = d
+	}
+	if len(rec.Providers) != 4 {
+		t.Fatalf("providers = %+v, want anthropic, openai, and openai's two models", rec.Providers)
+	}
+	if d := byKey["openai/gpt-4o"]; !d.Flagged || math.Abs(d.CostDelta+0.12) > 1e-9 || d.TokensDelta != 0 {
+		t.Errorf("gpt-4o = %+v, want flagged at -12%% cost", d)
+	}
+	if d := byKey["openai/gpt-4o-mini"]; d.Flagged {
+		t.Errorf("gpt-4o-mini flagged: %+v", d)
+	}
+	if d := byKey["openai/"]; !d.Flagged || d.Local.CostUSD != 9.8 || d.Reported.CostUSD != 11 {
+		t.Errorf("openai total = %+v", d)
+	}
+	if d := byKey["anthropic/"]; d.Flagged || d.TokensDelta != 0 {
+		t.Errorf("anthropic = %+v, want unflagged with tokens not compared", d)
+	}
+	if len(rec.Flagged()) != 2 {
+		t.Errorf("flagged = %+v", rec.Flagged())
+	}
+	if len(rec.Unreconciled) != 1 || rec.Unreconciled[0].Provider != "local" || rec.Unreconciled[0].TokensIn != 5 {
+		t.Errorf("unreconciled = %+v", rec.Unreconciled)
+	}
+	if rec.Errors["broken"] == "" {
+		t.Errorf("errors = %v, want the broken fetcher", rec.Errors)
+	}
+}
+
+func TestReconcileUnbilledModel(t *testing.T) {
+	local := []Usage{{Provider: "openai", Model: "o1", CostUSD: 2}}
+	fetchers := map[string]UsageFetcher{"openai": UsageFetcherFunc(func(ctx context.Context, from, to time.Time) ([]Usage, error) {
+		return []Usage{{Provider: "openai", Model: "gpt-4o", CostUSD: 2}}, nil
+	})}
+	rec := Reconcile(context.Background(), local, fetchers, time.Time{}, time.Time{}, 0.05)
+	for _, d := range rec.Providers {
+		if d.Model != "" && !d.Flagged {
+			t.Errorf("model %s seen on only one side not flagged: %+v", d.Model, d)
+		}
+	}
+	if d := rec.Providers[0]; d.Model != "" || d.Flagged {
+		t.Errorf("total = %+v, want matching totals unflagged", d)
+	}
+}
+
+func TestUsageFile(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "openai.csv")
+	os.WriteFile(csvPath, []byte("model,tokens_in,tokens_out,cost_usd\ngpt-4o,10,5,0.25\n"), 0o644)
+	jsonPath := filepath.Join(dir, "openai.json")
+	os.WriteFile(jsonPath, []byte(`[{"provider":"openai","model":"gpt-4o","tokens_in":10,"tokens_out":5,"cost_usd":0.25}]`), 0o644)
+
+	want := Usage{Model: "gpt-4o", TokensIn: 10, TokensOut: 5, CostUSD: 0.25}
+	for _, path := range []string{csvPath, jsonPath} {
+		us, err := UsageFile(path).FetchUsage(context.Background(), time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(us) != 1 {
+			t.Fatalf("%s: usage = %+v", path, us)
+		}
+		us[0].Provider = ""
+		if us[0] != want {
+			t.Errorf("%s: usage = %+v, want %+v", path, us[0], want)
+		}
+	}
+
+	os.WriteFile(csvPath, []byte("model,cost_usd\ngpt-4o,lots\n"), 0o644)
+	if _, err := UsageFile(csvPath).FetchUsage(context.Background(), time.Time{}, time.Time{}); err == nil {
+		t.Error("bad cost accepted")
+	}
+}
+
+func TestUsageHTTP(t *testing.T) {
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("from") != "2025-03-01T00:00:00Z" || r.URL.Query().Get("org") != "acme" {
+			http.Error(w, "bad query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode([]Usage{{Model: "gpt-4o", CostUSD: 3}})
+	}))
+	defer srv.Close()
+
+	us, err := UsageHTTP(srv.URL+"?org=acme", nil).FetchUsage(context.Background(), from, from.AddDate(0, 1, 0))
+	if err != nil || len(us) != 1 || us[0].CostUSD != 3 {
+		t.Fatalf("FetchUsage = %+v, %v", us, err)
+	}
+	if _, err := UsageHTTP(srv.URL, nil).FetchUsage(context.Background(), from, from); err == nil {
+		t.Error("error status not reported")
+	}
+}
+
+func TestUsageHandler(t *testing.T) {
+	h := NewHandler(Config{MaxSpans: 2})
+	now := time.Now()
+	h.store.Add(usageSpan(now.Add(-time.Hour), "openai", "gpt-4o", 10, 5, 0.5))
+
+	get := func(query string) (*httptest.ResponseRecorder, UsageResponse) {
+		w := httptest.NewRecorder()
+		h.Usage(w, httptest.NewRequest(http.MethodGet, "/usage"+query, nil))
+		var resp UsageResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	w, resp := get("")
+	if w.Code != http.StatusOK || len(resp.Usage) != 1 || resp.Usage[0].CostUSD != 0.5 || !resp.Complete {
+		t.Fatalf("GET /usage = %d %+v", w.Code, resp)
+	}
+
+	// Once spans from within the period are evicted, totals are partial.
+	h.store.Add(usageSpan(now.Add(-time.Minute), "openai", "gpt-4o", 10, 5, 0.5))
+	h.store.Add(usageSpan(now, "openai", "gpt-4o", 10, 5, 0.5))
+	if _, resp := get(""); resp.Complete {
+		t.Error("Complete after evicting spans in the period")
+	}
+	if _, resp := get("?from=" + now.Add(-30*time.Second).UTC().Format(time.RFC3339)); !resp.Complete {
+		t.Error("not Complete for a period the store still covers")
+	}
+
+	if w, _ := get("?from=2025-03-02&to=2025-03-01"); w.Code != http.StatusBadRequest {
+		t.Errorf("reversed period: status %d", w.Code)
+	}
+	if w, _ := get("?to=yesterday"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad to: status %d", w.Code)
+	}
+}
@@ -3,77 +3,267 @@ package tokentrace
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/lifecycle"
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/retry"
 	"github.com/greynewell/mist-go/trace"
 	"github.com/greynewell/mist-go/transport"
 )
 
+// Reasons a Reporter drops a span, used as the reason label of
+// tokentrace_reporter_dropped_total.
+const (
+	DropQueueFull  = "queue_full"  // the queue was full when the span was reported
+	DropSendFailed = "send_failed" // every retry failed
+	DropShutdown   = "shutdown"    // Close gave up before the span was sent
+	DropEncode     = "encode"      // the span could not be encoded
+)
+
 // Reporter sends trace spans to a TokenTrace server. It is safe for
-// concurrent use. If the TokenTrace URL is empty, spans are silently
+// concurrent use. Spans are queued and sent in the background in
+// batches, with retries, so Report never blocks on the network and a
+// collector blip does not lose spans. Call Close on shutdown to flush
+// the queue. If the TokenTrace URL is empty, spans are silently
 // discarded (no-op mode).
 type Reporter struct {
-	source string
-	tr     transport.Sender
+	source    string
+	tr        transport.Sender
+	queueSize int
+	batchSize int
+	interval  time.Duration
+	policy    retry.Policy
+	metrics   *metrics.Registry
+
+	mu     sync.RWMutex // guards closed and sends on queue
+	closed bool
+	queue  chan *protocol.Message
+	done   chan struct{}
+	ctx    context.Context // cancelled when Close gives up
+	cancel context.CancelFunc
+
+	sent    atomic.Int64
+	dropped atomic.Int64
+}
+
+// ReporterOption configures a Reporter.
+type ReporterOption func(*Reporter)
+
+// WithQueueSize sets how many spans may wait to be sent. Spans reported
+// while the queue is full are dropped. Default 4096.
+func WithQueueSize(n int) ReporterOption {
+	return func(r *Reporter) { r.queueSize = n }
+}
+
+// WithBatch sets the batch size and the longest a span waits for its
+// batch to fill before being sent. Default 100 spans or 1 second.
+func WithBatch(size int, interval time.Duration) ReporterOption {
+	return func(r *Reporter) {
+		r.batchSize = size
+		r.interval = interval
+	}
+}
 
-	mu      sync.Mutex
-	dropped int64
+// WithRetryPolicy sets the retry policy for each batch. Default
+// retry.DefaultPolicy.
+func WithRetryPolicy(p retry.Policy) ReporterOption {
+	return func(r *Reporter) { r.policy = p }
+}
+
+// WithReporterMetrics records tokentrace_reporter_sent_total and
+// tokentrace_reporter_dropped_total{reason} in reg.
+func WithReporterMetrics(reg *metrics.Registry) ReporterOption {
+	return func(r *Reporter) { r.metrics = reg }
 }
 
 // NewReporter creates a reporter that sends spans to the given TokenTrace URL.
 // If url is empty, the reporter operates in no-op mode.
-func NewReporter(source, url string) *Reporter {
-	r := &Reporter{source: source}
-	if url != "" {
-		r.tr = transport.NewHTTP(url + "/mist")
+func NewReporter(source, url string, opts ...ReporterOption) *Reporter {
+	r := &Reporter{
+		source:    source,
+		queueSize: 4096,
+		batchSize: 100,
+		interval:  time.Second,
+		policy:    retry.DefaultPolicy,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	if url == "" {
+		return r
+	}
+	return r.start(transport.NewHTTP(url + "/mist"))
+}
+
+// start begins sending queued spans through tr.
+func (r *Reporter) start(tr transport.Sender) *Reporter {
+	r.tr = tr
+	r.queueSize = max(r.queueSize, 1)
+	r.batchSize = max(r.batchSize, 1)
+	if r.interval <= 0 {
+		r.interval = time.Second
+	}
+	r.queue = make(chan *protocol.Message, r.queueSize)
+	r.done = make(chan struct{})
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	go r.run()
 	return r
 }
 
-// Report sends a completed span to TokenTrace. It is non-blocking: if the
-// send fails, the span is silently dropped and the drop count incremented.
+// Report queues a completed span for sending. It never blocks: if the
+// queue is full or the reporter is closed, the span is dropped and the
+// drop count incremented. Unsampled spans are skipped.
 func (r *Reporter) Report(ctx context.Context, span *trace.Span) {
-	if r.tr == nil {
+	if r.tr == nil || !span.Sampled() {
 		return
 	}
-
 	msg, err := trace.SpanToMessage(r.source, span)
 	if err != nil {
-		r.recordDrop()
+		r.drop(DropEncode, 1)
 		return
 	}
-
-	if err := r.tr.Send(ctx, msg); err != nil {
-		r.recordDrop()
-	}
+	r.enqueue(msg)
 }
 
-// ReportProto sends a protocol.TraceSpan directly.
+// ReportProto queues a protocol.TraceSpan directly.
 func (r *Reporter) ReportProto(ctx context.Context, span protocol.TraceSpan) {
 	if r.tr == nil {
 		return
 	}
-
 	msg, err := protocol.New(r.source, protocol.TypeTraceSpan, span)
 	if err != nil {
-		r.recordDrop()
+		r.drop(DropEncode, 1)
+		return
+	}
+	r.enqueue(msg)
+}
+
+// ExportSpan queues span, so a Reporter can serve as a trace.Exporter.
+func (r *Reporter) ExportSpan(span protocol.TraceSpan) {
+	r.ReportProto(context.Background(), span)
+}
+
+func (r *Reporter) enqueue(msg *protocol.Message) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.drop(DropShutdown, 1)
 		return
 	}
+	select {
+	case r.queue <- msg:
+	default:
+		r.drop(DropQueueFull, 1)
+	}
+}
+
+// run sends queued spans until the queue is closed and drained.
+func (r *Reporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
 
-	if err := r.tr.Send(ctx, msg); err != nil {
-		r.recordDrop()
+	batch := make([]*protocol.Message, 0, r.batchSize)
+	for {
+		select {
+		case msg, ok := <-r.queue:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) < r.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		r.flush(batch)
+		batch = batch[:0]
 	}
 }
 
-// Dropped returns the number of spans that failed to send.
-func (r *Reporter) Dropped() int64 {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	return r.dropped
+// flush sends a batch in one request where the transport allows, as
+// HTTP does, retrying the batch whole; a TokenTrace server with
+// Config.Dedup on ignores the spans a retry repeats. Once Close has
+// given up, the batch is dropped without being sent.
+func (r *Reporter) flush(batch []*protocol.Message) {
+	if len(batch) == 0 {
+		return
+	}
+	if r.ctx.Err() != nil {
+		r.drop(DropShutdown, len(batch))
+		return
+	}
+	err := retry.Do(r.ctx, r.policy, func(ctx context.Context) error {
+		return transport.SendBatch(ctx, r.tr, batch)
+	})
+	switch {
+	case err == nil:
+		r.sent.Add(int64(len(batch)))
+		if r.metrics != nil {
+			r.metrics.Counter("tokentrace_reporter_sent_total").Add(int64(len(batch)))
+		}
+	case r.ctx.Err() != nil:
+		r.drop(DropShutdown, len(batch))
+	default:
+		r.drop(DropSendFailed, len(batch))
+	}
 }
 
-func (r *Reporter) recordDrop() {
+// Close stops accepting spans and waits for queued spans to be sent. If
+// ctx ends first, the remaining spans are dropped and the context error
+// returned. Close is safe to call more than once.
+func (r *Reporter) Close(ctx context.Context) error {
+	if r.tr == nil {
+		return nil
+	}
 	r.mu.Lock()
-	r.dropped++
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
 	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		before := r.Dropped()
+		r.cancel()
+		<-r.done
+		return misterrors.Wrapf(misterrors.CodeTimeout, ctx.Err(),
+			"tokentrace: reporter: dropped %d unsent spans", r.Dropped()-before)
+	}
+}
+
+// CloseOnShutdown registers Close as a lifecycle shutdown hook, so spans
+// queued when the process is asked to stop are still delivered.
+func (r *Reporter) CloseOnShutdown(ctx context.Context, opts ...lifecycle.HookOption) {
+	lifecycle.OnShutdownHook(ctx, "tokentrace-reporter", r.Close, opts...)
+}
+
+// Pending returns the number of spans waiting in the queue.
+func (r *Reporter) Pending() int {
+	return len(r.queue)
+}
+
+// Sent returns the number of spans delivered.
+func (r *Reporter) Sent() int64 {
+	return r.sent.Load()
+}
+
+// Dropped returns the number of spans that were never delivered.
+func (r *Reporter) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+func (r *Reporter) drop(reason string, n int) {
+	r.dropped.Add(int64(n))
+	if r.metrics != nil {
+		r.metrics.Counter("tokentrace_reporter_dropped_total", "reason", reason).Add(int64(n))
+	}
 }
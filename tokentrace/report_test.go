@@ -2,8 +2,17 @@ package tokentrace
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/retry"
 	"github.com/greynewell/mist-go/trace"
 )
 
@@ -18,17 +27,155 @@ func TestReporterNoOp(t *testing.T) {
 	if r.Dropped() != 0 {
 		t.Errorf("no-op reporter should have 0 drops, got %d", r.Dropped())
 	}
+	if err := r.Close(ctx); err != nil {
+		t.Errorf("Close: %v", err)
+	}
 }
 
 func TestReporterDropsOnBadURL(t *testing.T) {
 	// Point at a URL that will refuse connections.
-	r := NewReporter("test", "http://127.0.0.1:1")
+	r := NewReporter("test", "http://127.0.0.1:1", WithRetryPolicy(retry.Policy{MaxAttempts: 2}))
 
 	ctx, span := trace.Start(context.Background(), "test-op")
 	span.End("ok")
 	r.Report(ctx, span)
+	r.Close(context.Background())
 
 	if r.Dropped() != 1 {
 		t.Errorf("expected 1 drop, got %d", r.Dropped())
 	}
 }
+
+// fakeSender records sends and fails the first failures of them.
+type fakeSender struct {
+	mu       sync.Mutex
+	failures int
+	block    chan struct{}
+	sent     []*protocol.Message
+}
+
+func (f *fakeSender) Send(ctx context.Context, msg *protocol.Message) error {
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("collector unavailable")
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeSender) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sent)
+}
+
+func fastRetry() ReporterOption {
+	return WithRetryPolicy(retry.Policy{MaxAttempts: 3, InitialWait: time.Millisecond, Multiplier: 1})
+}
+
+func TestReporterRetriesAndFlushesOnClose(t *testing.T) {
+	reg := metrics.NewRegistry()
+	fs := &fakeSender{failures: 2}
+	r := NewReporter("test", "", fastRetry(), WithBatch(10, time.Hour), WithReporterMetrics(reg)).start(fs)
+
+	for i := range 5 {
+		r.ReportProto(context.Background(), protocol.TraceSpan{Operation: "op", SpanID: string(rune('a' + i))})
+	}
+	if fs.count() != 0 {
+		t.Errorf("sent %d spans before the batch filled", fs.count())
+	}
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if fs.count() != 5 || r.Sent() != 5 || r.Dropped() != 0 {
+		t.Errorf("sent %d (Sent=%d), dropped %d; want 5, 0", fs.count(), r.Sent(), r.Dropped())
+	}
+	if n := reg.Counter("tokentrace_reporter_sent_total").Value(); n != 5 {
+		t.Errorf("sent_total = %d", n)
+	}
+
+	// Spans reported after Close are dropped.
+	r.ReportProto(context.Background(), protocol.TraceSpan{Operation: "late"})
+	if r.Dropped() != 1 {
+		t.Errorf("dropped = %d after reporting to a closed reporter", r.Dropped())
+	}
+}
+
+func TestReporterBatchInterval(t *testing.T) {
+	fs := &fakeSender{}
+	r := NewReporter("test", "", WithBatch(100, 10*time.Millisecond)).start(fs)
+	defer r.Close(context.Background())
+
+	r.ReportProto(context.Background(), protocol.TraceSpan{Operation: "op"})
+	deadline := time.Now().Add(time.Second)
+	for fs.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fs.count() != 1 {
+		t.Error("partial batch not flushed on interval")
+	}
+}
+
+func TestReporterQueueFull(t *testing.T) {
+	reg := metrics.NewRegistry()
+	fs := &fakeSender{block: make(chan struct{})}
+	r := NewReporter("test", "", WithQueueSize(2), WithBatch(1, time.Hour), WithReporterMetrics(reg)).start(fs)
+
+	// The first span is taken by the sender and blocks; two more fill
+	// the queue; the rest are dropped.
+	r.ReportProto(context.Background(), protocol.TraceSpan{Operation: "op"})
+	time.Sleep(20 * time.Millisecond)
+	for range 5 {
+		r.ReportProto(context.Background(), protocol.TraceSpan{Operation: "op"})
+	}
+	if n := reg.Counter("tokentrace_reporter_dropped_total", "reason", DropQueueFull).Value(); n != 3 {
+		t.Errorf("queue_full drops = %d, want 3", n)
+	}
+
+	// Close gives up on the blocked sender when its context ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Close(ctx); err == nil {
+		t.Error("Close succeeded with a blocked sender")
+	}
+	if n := reg.Counter("tokentrace_reporter_dropped_total", "reason", DropShutdown).Value(); n != 3 {
+		t.Errorf("shutdown drops = %d, want 3", n)
+	}
+	if r.Dropped() != 6 {
+		t.Errorf("Dropped = %d, want 6", r.Dropped())
+	}
+}
+
+func TestReporterSendsBatches(t *testing.T) {
+	h := newTestHandler()
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		h.Ingest(w, r)
+	}))
+	defer srv.Close()
+
+	r := NewReporter("test", srv.URL, WithBatch(10, time.Hour))
+	for i := range 10 {
+		r.ReportProto(context.Background(), protocol.TraceSpan{TraceID: "t1", SpanID: string(rune('a' + i)), Operation: "op", Status: "ok"})
+	}
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if r.Sent() != 10 || h.Store().Len() != 10 {
+		t.Errorf("sent %d, stored %d; want 10", r.Sent(), h.Store().Len())
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want the batch in one", n)
+	}
+}
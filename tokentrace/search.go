@@ -0,0 +1,140 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// SpanQuery selects spans by attribute values, status, and operation.
+// All non-empty criteria must match.
+type SpanQuery struct {
+	Attrs     map[string]string // attr key → stringified value
+	Status    string
+	Operation string
+	Limit     int // 0 means no limit
+}
+
+// matches reports whether a span satisfies every criterion in the query.
+func (q SpanQuery) matches(span protocol.TraceSpan) bool {
+	if q.Status != "" && span.Status != q.Status {
+		return false
+	}
+	if q.Operation != "" && span.Operation != q.Operation {
+		return false
+	}
+	for k, want := range q.Attrs {
+		v, ok := span.Attrs[k]
+		if !ok || attrString(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns spans matching the query, newest first. Criteria on
+// indexed attribute keys narrow the candidate set via the index; all
+// other criteria are checked per candidate.
+func (s *Store) Search(q SpanQuery) []protocol.TraceSpan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Ages are offsets back from head: 0 is the newest span.
+	var ages []int
+	if candidates, ok := s.indexedCandidates(q.Attrs); ok {
+		ages = make([]int, 0, len(candidates))
+		for pos := range candidates {
+			ages = append(ages, (s.head-1-pos+s.cap)%s.cap)
+		}
+		sort.Ints(ages)
+	} else {
+		ages = make([]int, s.count)
+		for i := range ages {
+			ages[i] = i
+		}
+	}
+
+	var result []protocol.TraceSpan
+	for _, age := range ages {
+		if q.Limit > 0 && len(result) >= q.Limit {
+			break
+		}
+		span := s.spans[(s.head-1-age+s.cap)%s.cap]
+		if q.matches(span) {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+// indexedCandidates intersects index entries for every indexed key in
+// attrs. It returns false if no key in attrs is indexed, meaning the
+// caller must scan all spans. Must be called with mu held.
+func (s *Store) indexedCandidates(attrs map[string]string) (map[int]struct{}, bool) {
+	var result map[int]struct{}
+	indexed := false
+
+	for k, want := range attrs {
+		values, ok := s.attrIndex[k]
+		if !ok {
+			continue
+		}
+		indexed = true
+		positions := values[want]
+		if result == nil {
+			result = make(map[int]struct{}, len(positions))
+			for pos := range positions {
+				result[pos] = struct{}{}
+			}
+			continue
+		}
+		for pos := range result {
+			if _, ok := positions[pos]; !ok {
+				delete(result, pos)
+			}
+		}
+	}
+	return result, indexed
+}
+
+// SpansResponse is the JSON body for GET /spans.
+type SpansResponse struct {
+	Spans []protocol.TraceSpan `json:"spans"`
+	Count int                  `json:"count"`
+}
+
+// Spans handles GET /spans?attr.<key>=<value>&status=&operation=&limit=N —
+// returns spans matching all given criteria, newest first.
+func (h *Handler) Spans(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := SpanQuery{
+		Attrs:     make(map[string]string),
+		Status:    params.Get("status"),
+		Operation: params.Get("operation"),
+		Limit:     100,
+	}
+	if s := params.Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			q.Limit = n
+		}
+	}
+	for key, values := range params {
+		if name, ok := strings.CutPrefix(key, "attr."); ok && name != "" && len(values) > 0 {
+			q.Attrs[name] = values[0]
+		}
+	}
+
+	spans := h.store.Search(q)
+	if spans == nil {
+		spans = []protocol.TraceSpan{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SpansResponse{
+		Spans: spans,
+		Count: len(spans),
+	})
+}
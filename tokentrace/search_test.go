@@ -0,0 +1,110 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func attrSpan(spanID, status string, attrs map[string]any) protocol.TraceSpan {
+	return protocol.TraceSpan{
+		TraceID: "t-" + spanID, SpanID: spanID, Operation: "infer",
+		StartNS: 0, EndNS: 1_000_000, Status: status, Attrs: attrs,
+	}
+}
+
+func TestStoreSearchIndexed(t *testing.T) {
+	s := NewStore(100, WithIndexedAttrs("model", "tenant"))
+	s.Add(attrSpan("s1", "error", map[string]any{"model": "gpt-4", "tenant": "acme"}))
+	s.Add(attrSpan("s2", "ok", map[string]any{"model": "gpt-4", "tenant": "acme"}))
+	s.Add(attrSpan("s3", "error", map[string]any{"model": "gpt-4", "tenant": "globex"}))
+	s.Add(attrSpan("s4", "error", map[string]any{"model": "claude", "tenant": "acme"}))
+	s.Add(attrSpan("s5", "error", map[string]any{"model": "gpt-4", "tenant": "acme"}))
+
+	got := s.Search(SpanQuery{
+		Attrs:  map[string]string{"model": "gpt-4", "tenant": "acme"},
+		Status: "error",
+	})
+	if len(got) != 2 {
+		t.Fatalf("Search = %d spans, want 2", len(got))
+	}
+	if got[0].SpanID != "s5" || got[1].SpanID != "s1" {
+		t.Errorf("order = %s, %s; want s5, s1", got[0].SpanID, got[1].SpanID)
+	}
+}
+
+func TestStoreSearchUnindexedKeyScans(t *testing.T) {
+	s := NewStore(100, WithIndexedAttrs("model"))
+	s.Add(attrSpan("s1", "ok", map[string]any{"model": "gpt-4", "user_id": "u1"}))
+	s.Add(attrSpan("s2", "ok", map[string]any{"model": "gpt-4", "user_id": "u2"}))
+
+	got := s.Search(SpanQuery{Attrs: map[string]string{"user_id": "u2"}})
+	if len(got) != 1 || got[0].SpanID != "s2" {
+		t.Errorf("Search = %+v, want [s2]", got)
+	}
+}
+
+func TestStoreSearchNumericAttr(t *testing.T) {
+	s := NewStore(10, WithIndexedAttrs("tokens_in"))
+	s.Add(attrSpan("s1", "ok", map[string]any{"tokens_in": float64(100)}))
+
+	if got := s.Search(SpanQuery{Attrs: map[string]string{"tokens_in": "100"}}); len(got) != 1 {
+		t.Errorf("Search = %d spans, want 1", len(got))
+	}
+}
+
+func TestStoreSearchEvictionCleansAttrIndex(t *testing.T) {
+	s := NewStore(3, WithIndexedAttrs("tenant"))
+	s.Add(attrSpan("s0", "ok", map[string]any{"tenant": "acme"}))
+	for i := 1; i <= 3; i++ {
+		s.Add(attrSpan(fmt.Sprintf("s%d", i), "ok", map[string]any{"tenant": "globex"}))
+	}
+
+	if got := s.Search(SpanQuery{Attrs: map[string]string{"tenant": "acme"}}); len(got) != 0 {
+		t.Errorf("evicted span still found: %+v", got)
+	}
+	if n := len(s.attrIndex["tenant"]); n != 1 {
+		t.Errorf("attr index has %d values, want 1", n)
+	}
+}
+
+func TestStoreSearchLimit(t *testing.T) {
+	s := NewStore(100)
+	for i := 0; i < 10; i++ {
+		s.Add(attrSpan(fmt.Sprintf("s%d", i), "ok", nil))
+	}
+	got := s.Search(SpanQuery{Limit: 3})
+	if len(got) != 3 || got[0].SpanID != "s9" {
+		t.Errorf("Search(limit=3) = %+v", got)
+	}
+}
+
+func TestHandlerSpans(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxSpans = 100
+	cfg.IndexedAttrs = []string{"model", "tenant"}
+	h := NewHandler(cfg)
+
+	postSpan(t, h, attrSpan("s1", "error", map[string]any{"model": "gpt-4", "tenant": "acme"}))
+	postSpan(t, h, attrSpan("s2", "ok", map[string]any{"model": "gpt-4", "tenant": "acme"}))
+	postSpan(t, h, attrSpan("s3", "error", map[string]any{"model": "gpt-4", "tenant": "globex"}))
+
+	req := httptest.NewRequest("GET", "/spans?attr.model=gpt-4&attr.tenant=acme&status=error", nil)
+	w := httptest.NewRecorder()
+	h.Spans(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var resp SpansResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Count != 1 || resp.Spans[0].SpanID != "s1" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
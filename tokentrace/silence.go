@@ -0,0 +1,167 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/greynewell/mist-go/trace"
+)
+
+// AlertMatcher selects alert rules. Empty fields match anything; at least
+// one must be set.
+type AlertMatcher struct {
+	Rule   string `json:"rule,omitempty"` // as AlertRule.String formats it
+	Metric string `json:"metric,omitempty"`
+	Level  string `json:"level,omitempty"`
+}
+
+func (m AlertMatcher) empty() bool {
+	return m.Rule == "" && m.Metric == "" && m.Level == ""
+}
+
+func (m AlertMatcher) matches(rule AlertRule) bool {
+	return (m.Rule == "" || m.Rule == rule.String()) &&
+		(m.Metric == "" || m.Metric == rule.Metric) &&
+		(m.Level == "" || m.Level == rule.Level)
+}
+
+// Silence mutes alerts from the rules its matcher selects until EndsAt,
+// for example a known-noisy rule during maintenance. Silenced rules are
+// still evaluated and show as firing in GET /alerts, but OnAlert is not
+// called for them.
+type Silence struct {
+	ID       string       `json:"id"`
+	Matcher  AlertMatcher `json:"matcher"`
+	Comment  string       `json:"comment,omitempty"`
+	Actor    string       `json:"actor,omitempty"` // remote address of the request
+	StartsAt time.Time    `json:"starts_at"`
+	EndsAt   time.Time    `json:"ends_at"`
+}
+
+// Silence adds s, lasting d from now, and returns it with its ID and
+// times set.
+func (a *Alerter) Silence(s Silence, d time.Duration) Silence {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s.ID = trace.NewID()
+	s.StartsAt = a.now().UTC()
+	s.EndsAt = s.StartsAt.Add(d)
+	a.silences = append(a.silences, s)
+	return s
+}
+
+// Unsilence ends the silence with the given ID early. It reports whether
+// the silence was active.
+func (a *Alerter) Unsilence(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expireSilences(a.now())
+	for i, s := range a.silences {
+		if s.ID == id {
+			a.silences = append(a.silences[:i], a.silences[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Silences returns the active silences, oldest first.
+func (a *Alerter) Silences() []Silence {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expireSilences(a.now())
+	return append([]Silence{}, a.silences...)
+}
+
+// silenced reports whether an active silence matches rule. The caller
+// holds a.mu.
+func (a *Alerter) silenced(rule AlertRule, now time.Time) bool {
+	a.expireSilences(now)
+	for _, s := range a.silences {
+		if s.Matcher.matches(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// expireSilences drops silences that ended by now. The caller holds a.mu.
+func (a *Alerter) expireSilences(now time.Time) {
+	keep := a.silences[:0]
+	for _, s := range a.silences {
+		if now.Before(s.EndsAt) {
+			keep = append(keep, s)
+		}
+	}
+	clear(a.silences[len(keep):])
+	a.silences = keep
+}
+
+// AlertsResponse is the JSON body for GET /alerts.
+type AlertsResponse struct {
+	Active   []AlertStatus `json:"active"`
+	History  []AlertStatus `json:"history"` // resolved alerts, oldest first
+	Silences []Silence     `json:"silences"`
+}
+
+// Alerts handles GET /alerts — firing and recently resolved alerts, and
+// the active silences.
+func (h *Handler) Alerts(w http.ResponseWriter, r *http.Request) {
+	active, history := h.alert.Alerts()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AlertsResponse{
+		Active:   active,
+		History:  history,
+		Silences: h.alert.Silences(),
+	})
+}
+
+// SilenceRequest is the JSON body for POST /alerts/silence.
+type SilenceRequest struct {
+	Matcher  AlertMatcher `json:"matcher"`
+	Duration string       `json:"duration"` // e.g. "2h"
+	Comment  string       `json:"comment,omitempty"`
+}
+
+// SilenceAlerts handles POST /alerts/silence — mutes the matching rules
+// for the given duration and returns the new Silence — and
+// DELETE /alerts/silence?id=X, which ends a silence early.
+func (h *Handler) SilenceAlerts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		if !h.alert.Unsilence(r.URL.Query().Get("id")) {
+			http.Error(w, "silence not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SilenceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, "invalid silence: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Matcher.empty() {
+		http.Error(w, "matcher needs a rule, metric, or level", http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		http.Error(w, "duration must be a positive Go duration such as 2h", http.StatusBadRequest)
+		return
+	}
+
+	s := h.alert.Silence(Silence{Matcher: req.Matcher, Comment: req.Comment, Actor: r.RemoteAddr}, d)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
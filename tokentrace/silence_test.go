@@ -0,0 +1,143 @@
+package tokentrace
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlerterState(t *testing.T) {
+	a := NewAlerter([]AlertRule{{Metric: "error_rate", Op: ">", Threshold: 0.1, Level: "warning"}}, time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	a.Check(AggregatorStats{ErrorRate: 0.5})
+	active, history := a.Alerts()
+	if len(active) != 1 || len(history) != 0 {
+		t.Fatalf("active %d, history %d", len(active), len(history))
+	}
+	if st := active[0]; st.State != AlertFiring || st.Rule != "error_rate > 0.1" || !st.FiredAt.Equal(now) {
+		t.Errorf("active = %+v", st)
+	}
+
+	now = now.Add(time.Minute)
+	a.Check(AggregatorStats{ErrorRate: 0.7})
+	if active, _ := a.Alerts(); active[0].Value != 0.7 || !active[0].FiredAt.Equal(now.Add(-time.Minute)) {
+		t.Errorf("still firing = %+v", active[0])
+	}
+
+	now = now.Add(time.Minute)
+	a.Check(AggregatorStats{ErrorRate: 0.01})
+	active, history = a.Alerts()
+	if len(active) != 0 || len(history) != 1 {
+		t.Fatalf("active %d, history %d", len(active), len(history))
+	}
+	if st := history[0]; st.State != AlertResolved || !st.ResolvedAt.Equal(now) || st.Value != 0.01 {
+		t.Errorf("resolved = %+v", st)
+	}
+}
+
+func TestAlerterSilence(t *testing.T) {
+	a := NewAlerter([]AlertRule{
+		{Metric: "error_rate", Op: ">", Threshold: 0.1, Level: "warning"},
+		{Metric: "error_rate", Op: ">", Threshold: 0.3, Level: "critical"},
+	}, 0)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	s := a.Silence(Silence{Matcher: AlertMatcher{Metric: "error_rate", Level: "warning"}}, time.Hour)
+	if s.ID == "" || !s.EndsAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("silence = %+v", s)
+	}
+
+	alerts := a.Check(AggregatorStats{ErrorRate: 0.5})
+	if len(alerts) != 1 || alerts[0].Level != "critical" {
+		t.Fatalf("alerts = %+v, want only critical", alerts)
+	}
+	active, _ := a.Alerts()
+	if len(active) != 2 || !active[0].Silenced || active[1].Silenced {
+		t.Errorf("active = %+v", active)
+	}
+
+	// Once the silence ends the warning fires again.
+	now = now.Add(time.Hour)
+	if alerts := a.Check(AggregatorStats{ErrorRate: 0.5}); len(alerts) != 2 {
+		t.Errorf("after silence: %d alerts, want 2", len(alerts))
+	}
+	if len(a.Silences()) != 0 {
+		t.Error("expired silence still listed")
+	}
+}
+
+func TestAlerterUnsilence(t *testing.T) {
+	a := NewAlerter([]AlertRule{{Metric: "error_rate", Op: ">", Threshold: 0.1, Level: "warning"}}, 0)
+	s := a.Silence(Silence{Matcher: AlertMatcher{Rule: "error_rate > 0.1"}}, time.Hour)
+	if alerts := a.Check(AggregatorStats{ErrorRate: 0.5}); len(alerts) != 0 {
+		t.Fatalf("silenced rule fired: %+v", alerts)
+	}
+	if !a.Unsilence(s.ID) || a.Unsilence(s.ID) {
+		t.Error("Unsilence should succeed once")
+	}
+	if alerts := a.Check(AggregatorStats{ErrorRate: 0.5}); len(alerts) != 1 {
+		t.Errorf("after unsilence: %d alerts, want 1", len(alerts))
+	}
+}
+
+func TestHandlerAlertsAndSilences(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AlertRules = []AlertRule{{Metric: "error_rate", Op: ">", Threshold: 0.1, Level: "warning"}}
+	h := NewHandler(cfg)
+
+	w := httptest.NewRecorder()
+	h.SilenceAlerts(w, httptest.NewRequest("POST", "/alerts/silence",
+		strings.NewReader(`{"matcher":{"metric":"error_rate"},"duration":"2h","comment":"maintenance"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var s Silence
+	json.NewDecoder(w.Body).Decode(&s)
+	if s.ID == "" || s.Comment != "maintenance" || s.EndsAt.Sub(s.StartsAt) != 2*time.Hour {
+		t.Errorf("silence = %+v", s)
+	}
+
+	h.alert.Check(AggregatorStats{ErrorRate: 0.5})
+	w = httptest.NewRecorder()
+	h.Alerts(w, httptest.NewRequest("GET", "/alerts", nil))
+	var resp AlertsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Active) != 1 || !resp.Active[0].Silenced || len(resp.Silences) != 1 || resp.History == nil {
+		t.Errorf("alerts = %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	h.SilenceAlerts(w, httptest.NewRequest("DELETE", "/alerts/silence?id="+s.ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d", w.Code)
+	}
+}
+
+func TestHandlerSilenceValidation(t *testing.T) {
+	h := NewHandler(DefaultConfig())
+	for _, body := range []string{
+		`{"duration":"1h"}`,
+		`{"matcher":{"level":"warning"},"duration":"-1h"}`,
+		`{"matcher":{"level":"warning"}}`,
+		`not json`,
+	} {
+		w := httptest.NewRecorder()
+		h.SilenceAlerts(w, httptest.NewRequest("POST", "/alerts/silence", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.SilenceAlerts(w, httptest.NewRequest("DELETE", "/alerts/silence?id=nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown id: status = %d, want 404", w.Code)
+	}
+}
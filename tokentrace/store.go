@@ -1,13 +1,17 @@
 package tokentrace
 
 import (
+	"fmt"
+	"math"
+	"sort"
 	"sync"
 
 	"github.com/greynewell/mist-go/protocol"
 )
 
-// Store is a fixed-capacity ring buffer of trace spans, indexed by trace ID
-// for fast lookup. When the buffer is full, the oldest span is evicted.
+// Store is a bounded ring buffer of trace spans, indexed by trace ID for
+// fast lookup. When the buffer is full, the oldest span is evicted. The
+// capacity can be changed at runtime with Resize.
 type Store struct {
 	mu    sync.RWMutex
 	spans []protocol.TraceSpan
@@ -15,34 +19,82 @@ type Store struct {
 	head  int // next write position
 	count int // number of spans stored (≤ cap)
 
+	// seqs holds each position's sequence number, which increases with
+	// every span added and survives Resize and DeleteFunc, so a walk
+	// over the store (Store.Each) can resume where it left off.
+	seqs []uint64
+	next uint64
+
+	// evictedNS is the latest StartNS of any span evicted for capacity,
+	// math.MinInt64 until one is.
+	evictedNS int64
+
 	// index maps trace_id → set of ring buffer positions.
 	// Positions are invalidated on eviction.
 	index map[string]map[int]struct{}
+
+	// attrIndex maps attr key → stringified value → ring buffer positions,
+	// for the keys configured via WithIndexedAttrs.
+	attrIndex map[string]map[string]map[int]struct{}
+}
+
+// StoreOption configures a Store.
+type StoreOption func(*Store)
+
+// WithIndexedAttrs enables value indexing for the given span attribute
+// keys (e.g. "model", "tenant", "user_id"), making Search on those keys
+// proportional to the number of matches rather than the store size.
+func WithIndexedAttrs(keys ...string) StoreOption {
+	return func(s *Store) {
+		for _, k := range keys {
+			s.attrIndex[k] = make(map[string]map[int]struct{})
+		}
+	}
 }
 
 // NewStore creates a span store with the given capacity.
-func NewStore(capacity int) *Store {
-	return &Store{
-		spans: make([]protocol.TraceSpan, capacity),
-		cap:   capacity,
-		index: make(map[string]map[int]struct{}),
+func NewStore(capacity int, opts ...StoreOption) *Store {
+	s := &Store{
+		spans:     make([]protocol.TraceSpan, capacity),
+		seqs:      make([]uint64, capacity),
+		cap:       capacity,
+		evictedNS: math.MinInt64,
+		index:     make(map[string]map[int]struct{}),
+		attrIndex: make(map[string]map[string]map[int]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // Add inserts a span into the store, evicting the oldest if full.
 func (s *Store) Add(span protocol.TraceSpan) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.add(span)
+}
+
+func (s *Store) add(span protocol.TraceSpan) {
+	s.put(span, s.next)
+	s.next++
+}
 
+// put stores span with sequence number seq at the write position.
+func (s *Store) put(span protocol.TraceSpan, seq uint64) {
 	// Evict the span at the current write position if the buffer is full.
 	if s.count == s.cap {
 		evicted := s.spans[s.head]
 		s.removeFromIndex(evicted.TraceID, s.head)
+		s.removeFromAttrIndex(evicted, s.head)
+		s.evictedNS = max(s.evictedNS, evicted.StartNS)
 	}
 
 	pos := s.head
 	s.spans[pos] = span
+	s.seqs[pos] = seq
 	s.addToIndex(span.TraceID, pos)
+	s.addToAttrIndex(span, pos)
 
 	s.head = (s.head + 1) % s.cap
 	if s.count < s.cap {
@@ -50,7 +102,7 @@ func (s *Store) Add(span protocol.TraceSpan) {
 	}
 }
 
-// GetTrace returns all stored spans for the given trace ID.
+// GetTrace returns all stored spans for the given trace ID, oldest first.
 func (s *Store) GetTrace(traceID string) []protocol.TraceSpan {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -60,9 +112,17 @@ func (s *Store) GetTrace(traceID string) []protocol.TraceSpan {
 		return nil
 	}
 
-	result := make([]protocol.TraceSpan, 0, len(positions))
+	// Map iteration order is random; sort by insertion age so callers
+	// see spans in the order they were added.
+	ages := make([]int, 0, len(positions))
 	for pos := range positions {
-		result = append(result, s.spans[pos])
+		ages = append(ages, (pos-s.head+s.cap)%s.cap)
+	}
+	sort.Ints(ages)
+
+	result := make([]protocol.TraceSpan, 0, len(ages))
+	for _, age := range ages {
+		result = append(result, s.spans[(s.head+age)%s.cap])
 	}
 	return result
 }
@@ -95,6 +155,75 @@ func (s *Store) Len() int {
 	return s.count
 }
 
+// Cap returns the maximum number of spans the store holds.
+func (s *Store) Cap() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cap
+}
+
+// Resize sets the store's capacity to n spans, evicting the oldest spans
+// that no longer fit, and returns the number evicted. A capacity below 1
+// is treated as 1.
+func (s *Store) Resize(n int) int {
+	n = max(n, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n == s.cap {
+		return 0
+	}
+
+	spans, seqs := s.ordered(), s.orderedSeqs()
+	evicted := max(len(spans)-n, 0)
+	for _, span := range spans[:evicted] {
+		s.evictedNS = max(s.evictedNS, span.StartNS)
+	}
+	s.rebuild(spans[evicted:], seqs[evicted:], n)
+	return evicted
+}
+
+// ordered returns the stored spans, oldest first. Must be called with mu
+// held.
+func (s *Store) ordered() []protocol.TraceSpan {
+	spans := make([]protocol.TraceSpan, 0, s.count)
+	for age := s.cap - s.count; age < s.cap; age++ {
+		spans = append(spans, s.spans[(s.head+age)%s.cap])
+	}
+	return spans
+}
+
+// orderedSeqs returns the sequence numbers of ordered's spans. Must be
+// called with mu held.
+func (s *Store) orderedSeqs() []uint64 {
+	seqs := make([]uint64, 0, s.count)
+	for age := s.cap - s.count; age < s.cap; age++ {
+		seqs = append(seqs, s.seqs[(s.head+age)%s.cap])
+	}
+	return seqs
+}
+
+// rebuild replaces the buffer with one of the given capacity holding
+// spans, oldest first, with their sequence numbers seqs, and reindexes
+// them. len(spans) must not exceed capacity. Must be called with mu
+// held.
+func (s *Store) rebuild(spans []protocol.TraceSpan, seqs []uint64, capacity int) {
+	if capacity == s.cap {
+		clear(s.spans)
+	} else {
+		s.spans = make([]protocol.TraceSpan, capacity)
+		s.seqs = make([]uint64, capacity)
+		s.cap = capacity
+	}
+	clear(s.index)
+	for _, values := range s.attrIndex {
+		clear(values)
+	}
+	s.head, s.count = 0, 0
+	for i, span := range spans {
+		s.put(span, seqs[i])
+	}
+}
+
 // TraceIDs returns all distinct trace IDs currently in the store.
 func (s *Store) TraceIDs() []string {
 	s.mu.RLock()
@@ -126,3 +255,43 @@ func (s *Store) removeFromIndex(traceID string, pos int) {
 		delete(s.index, traceID)
 	}
 }
+
+func (s *Store) addToAttrIndex(span protocol.TraceSpan, pos int) {
+	for key, values := range s.attrIndex {
+		v, ok := span.Attrs[key]
+		if !ok {
+			continue
+		}
+		val := attrString(v)
+		positions, ok := values[val]
+		if !ok {
+			positions = make(map[int]struct{})
+			values[val] = positions
+		}
+		positions[pos] = struct{}{}
+	}
+}
+
+func (s *Store) removeFromAttrIndex(span protocol.TraceSpan, pos int) {
+	for key, values := range s.attrIndex {
+		v, ok := span.Attrs[key]
+		if !ok {
+			continue
+		}
+		val := attrString(v)
+		positions, ok := values[val]
+		if !ok {
+			continue
+		}
+		delete(positions, pos)
+		if len(positions) == 0 {
+			delete(values, val)
+		}
+	}
+}
+
+// attrString renders an attribute value the way it appears in a query
+// string, so that attr.tokens_in=100 matches a float64(100) attribute.
+func attrString(v any) string {
+	return fmt.Sprint(v)
+}
@@ -43,6 +43,24 @@ func TestStoreGetTrace(t *testing.T) {
 	}
 }
 
+func TestStoreGetTraceOrder(t *testing.T) {
+	// Enough spans, wrapped around the ring, that map iteration order
+	// would show through.
+	s := NewStore(8)
+	for i := 0; i < 13; i++ {
+		s.Add(span(fmt.Sprintf("t%d", i%2), fmt.Sprintf("s%d", i), "op", int64(i), int64(i+1)))
+	}
+
+	spans := s.GetTrace("t0")
+	var got []string
+	for _, sp := range spans {
+		got = append(got, sp.SpanID)
+	}
+	if want := "s6 s8 s10 s12"; fmt.Sprint(got) != "["+want+"]" {
+		t.Errorf("GetTrace(t0) = %v, want [%s]", got, want)
+	}
+}
+
 func TestStoreGetTraceNotFound(t *testing.T) {
 	s := NewStore(10)
 	spans := s.GetTrace("nonexistent")
@@ -221,3 +239,42 @@ func TestStoreRecentWraparound(t *testing.T) {
 		t.Errorf("oldest in buffer = %s, want s3", recent[3].SpanID)
 	}
 }
+
+func TestStoreResize(t *testing.T) {
+	s := NewStore(4, WithIndexedAttrs("model"))
+	for i := range 4 {
+		sp := span(fmt.Sprintf("t%d", i%2), fmt.Sprintf("s%d", i), "op", int64(i), int64(i+1))
+		sp.Attrs = map[string]any{"model": "m"}
+		s.Add(sp)
+	}
+
+	// Shrinking keeps the most recent spans.
+	if n := s.Resize(2); n != 2 {
+		t.Errorf("Resize(2) evicted %d, want 2", n)
+	}
+	if s.Cap() != 2 || s.Len() != 2 {
+		t.Fatalf("Cap, Len = %d, %d, want 2, 2", s.Cap(), s.Len())
+	}
+	if got := s.Recent(2); got[0].SpanID != "s3" || got[1].SpanID != "s2" {
+		t.Errorf("Recent = %s, %s, want s3, s2", got[0].SpanID, got[1].SpanID)
+	}
+	if got := s.GetTrace("t0"); len(got) != 1 || got[0].SpanID != "s2" {
+		t.Errorf("GetTrace(t0) = %+v, want only s2", got)
+	}
+	if got := s.Search(SpanQuery{Attrs: map[string]string{"model": "m"}}); len(got) != 2 {
+		t.Errorf("Search by indexed attr = %d spans, want 2", len(got))
+	}
+
+	// Growing keeps everything and makes room for more.
+	if n := s.Resize(3); n != 0 {
+		t.Errorf("Resize(3) evicted %d", n)
+	}
+	s.Add(span("t2", "s4", "op", 4, 5))
+	if s.Len() != 3 {
+		t.Errorf("Len = %d after growing, want 3", s.Len())
+	}
+	s.Add(span("t2", "s5", "op", 5, 6))
+	if got := s.Recent(3); got[0].SpanID != "s5" || got[2].SpanID != "s3" {
+		t.Errorf("Recent = %+v, want s5 to s3", got)
+	}
+}
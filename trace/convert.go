@@ -2,7 +2,6 @@ package trace
 
 import (
 	"context"
-	"time"
 
 	"github.com/greynewell/mist-go/protocol"
 )
@@ -22,7 +21,8 @@ func (s *Span) ToProto() protocol.TraceSpan {
 }
 
 // FromProto creates a Span from a protocol.TraceSpan received over transport.
-// The returned span is already ended and should not be modified.
+// The returned span is already ended and should not be modified. It is
+// sampled, since only sampled spans are sent.
 func FromProto(ts protocol.TraceSpan) *Span {
 	attrs := ts.Attrs
 	if attrs == nil {
@@ -37,12 +37,13 @@ func FromProto(ts protocol.TraceSpan) *Span {
 		EndNS:     ts.EndNS,
 		Status:    ts.Status,
 		attrs:     attrs,
+		sampled:   true,
 	}
 }
 
 // ContinueFrom starts a child span using the trace context from a received
-// protocol.TraceSpan. This is the standard way to propagate traces across
-// tool boundaries:
+// protocol.TraceSpan, whose trace counts as sampled. This is the standard
+// way to propagate traces across tool boundaries:
 //
 //	// Tool B receives a message from Tool A:
 //	var span protocol.TraceSpan
@@ -50,15 +51,8 @@ func FromProto(ts protocol.TraceSpan) *Span {
 //	ctx, childSpan := trace.ContinueFrom(ctx, span, "process")
 //	defer childSpan.End("ok")
 func ContinueFrom(ctx context.Context, ts protocol.TraceSpan, operation string) (context.Context, *Span) {
-	s := &Span{
-		TraceID:   ts.TraceID,
-		SpanID:    newID(),
-		ParentID:  ts.SpanID,
-		Operation: operation,
-		StartNS:   time.Now().UnixNano(),
-		attrs:     make(map[string]any),
-	}
-	return context.WithValue(ctx, contextKey{}, s), s
+	s := &Span{TraceID: ts.TraceID, ParentID: ts.SpanID, Operation: operation}
+	return start(ctx, s, true, true), s
 }
 
 // SpanToMessage creates a protocol.Message containing the span as payload.
@@ -0,0 +1,78 @@
+package trace
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Exporter receives spans when they end, for example to send them to
+// TokenTrace or to collect them in tests.
+type Exporter interface {
+	ExportSpan(span protocol.TraceSpan)
+}
+
+// ExporterFunc adapts a function to the Exporter interface.
+type ExporterFunc func(span protocol.TraceSpan)
+
+// ExportSpan calls f(span).
+func (f ExporterFunc) ExportSpan(span protocol.TraceSpan) { f(span) }
+
+type exporterKey struct{}
+
+type exporterBox struct{ Exporter }
+
+var defaultExporter atomic.Pointer[exporterBox]
+
+// SetExporter sets the process-wide exporter used by spans started from
+// a context without one. Pass nil to stop exporting.
+func SetExporter(e Exporter) {
+	if e == nil {
+		defaultExporter.Store(nil)
+		return
+	}
+	defaultExporter.Store(&exporterBox{e})
+}
+
+// WithExporter returns a context whose spans, and their descendants,
+// are exported to e instead of the process-wide exporter.
+func WithExporter(ctx context.Context, e Exporter) context.Context {
+	return context.WithValue(ctx, exporterKey{}, e)
+}
+
+// exporterFor returns the exporter for spans started from ctx.
+func exporterFor(ctx context.Context) Exporter {
+	if e, ok := ctx.Value(exporterKey{}).(Exporter); ok {
+		return e
+	}
+	return DefaultExporter()
+}
+
+// DefaultExporter returns the process-wide exporter, or nil.
+func DefaultExporter() Exporter {
+	if b := defaultExporter.Load(); b != nil {
+		return b.Exporter
+	}
+	return nil
+}
+
+// MultiExporter returns an exporter that sends each span to every
+// non-nil exporter in order.
+func MultiExporter(exporters ...Exporter) Exporter {
+	var m multiExporter
+	for _, e := range exporters {
+		if e != nil {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+type multiExporter []Exporter
+
+func (m multiExporter) ExportSpan(span protocol.TraceSpan) {
+	for _, e := range m {
+		e.ExportSpan(span)
+	}
+}
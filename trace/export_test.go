@@ -0,0 +1,67 @@
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestWithExporter(t *testing.T) {
+	var got []protocol.TraceSpan
+	ctx := WithExporter(context.Background(), ExporterFunc(func(s protocol.TraceSpan) {
+		got = append(got, s)
+	}))
+
+	ctx, parent := Start(ctx, "parent")
+	_, child := Start(ctx, "child")
+	child.SetAttr("model", "m1")
+	child.End("ok")
+	child.End("ok") // second End does not export again
+	parent.End("error")
+
+	if len(got) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(got))
+	}
+	if got[0].Operation != "child" || got[0].Attrs["model"] != "m1" || got[0].ParentID != parent.SpanID {
+		t.Errorf("child = %+v", got[0])
+	}
+	if got[1].Operation != "parent" || got[1].Status != "error" {
+		t.Errorf("parent = %+v", got[1])
+	}
+}
+
+func TestSetExporter(t *testing.T) {
+	var n int
+	SetExporter(ExporterFunc(func(protocol.TraceSpan) { n++ }))
+	defer SetExporter(nil)
+
+	_, s := Start(context.Background(), "op")
+	s.End("ok")
+	if n != 1 {
+		t.Errorf("exported %d, want 1", n)
+	}
+
+	SetExporter(nil)
+	_, s = Start(context.Background(), "op")
+	s.End("ok")
+	if n != 1 {
+		t.Errorf("exported after SetExporter(nil)")
+	}
+}
+
+func TestMultiExporter(t *testing.T) {
+	var a, b int
+	e := MultiExporter(
+		ExporterFunc(func(protocol.TraceSpan) { a++ }),
+		nil,
+		ExporterFunc(func(protocol.TraceSpan) { b++ }),
+	)
+	ctx := WithExporter(context.Background(), e)
+	_, s := Start(ctx, "op")
+	s.End("ok")
+
+	if a != 1 || b != 1 {
+		t.Errorf("exports = %d, %d; want 1, 1", a, b)
+	}
+}
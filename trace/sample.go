@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync/atomic"
+)
+
+// SamplingParams describes a span being started, for a Sampler.
+type SamplingParams struct {
+	TraceID   string
+	Operation string
+
+	// HasParent is set when the span continues a trace, from a parent
+	// span in the context or a remote caller. ParentSampled is then the
+	// parent's decision.
+	HasParent     bool
+	ParentSampled bool
+}
+
+// Sampler decides whether a span is sampled. Unsampled spans work as
+// usual but are not exported, and InjectHTTP passes the decision on to
+// downstream services through the traceparent flags.
+type Sampler interface {
+	ShouldSample(p SamplingParams) bool
+}
+
+// SamplerFunc adapts a function to the Sampler interface.
+type SamplerFunc func(p SamplingParams) bool
+
+// ShouldSample calls f(p).
+func (f SamplerFunc) ShouldSample(p SamplingParams) bool { return f(p) }
+
+// AlwaysSample samples every span.
+func AlwaysSample() Sampler {
+	return SamplerFunc(func(SamplingParams) bool { return true })
+}
+
+// NeverSample samples no spans.
+func NeverSample() Sampler {
+	return SamplerFunc(func(SamplingParams) bool { return false })
+}
+
+// RatioSampler samples the given fraction of traces. The decision is a
+// function of the trace ID, so every service using the same ratio makes
+// the same decision for a trace.
+func RatioSampler(ratio float64) Sampler {
+	switch {
+	case ratio >= 1:
+		return AlwaysSample()
+	case ratio <= 0:
+		return NeverSample()
+	}
+	bound := uint64(ratio * math.MaxUint64)
+	return SamplerFunc(func(p SamplingParams) bool {
+		return traceIDHash(p.TraceID) < bound
+	})
+}
+
+// traceIDHash maps a trace ID to a uniformly distributed value: the low
+// 64 bits of a hex ID, which are random, or a hash of any other ID.
+func traceIDHash(id string) uint64 {
+	if len(id) >= 16 {
+		if v, err := strconv.ParseUint(id[len(id)-16:], 16, 64); err == nil {
+			return v
+		}
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64()
+}
+
+// ParentBased follows the parent's decision for spans that continue a
+// trace and asks root to decide for new traces.
+func ParentBased(root Sampler) Sampler {
+	return SamplerFunc(func(p SamplingParams) bool {
+		if p.HasParent {
+			return p.ParentSampled
+		}
+		return root.ShouldSample(p)
+	})
+}
+
+type samplerKey struct{}
+
+type samplerBox struct{ Sampler }
+
+var defaultSampler atomic.Pointer[samplerBox]
+
+// SetSampler sets the process-wide sampler used by spans started from a
+// context without one. The default, also restored by passing nil, is
+// ParentBased(AlwaysSample()): new traces are sampled and incoming
+// decisions are honoured.
+func SetSampler(s Sampler) {
+	if s == nil {
+		defaultSampler.Store(nil)
+		return
+	}
+	defaultSampler.Store(&samplerBox{s})
+}
+
+// WithSampler returns a context whose spans, and their descendants, are
+// sampled by s instead of the process-wide sampler.
+func WithSampler(ctx context.Context, s Sampler) context.Context {
+	return context.WithValue(ctx, samplerKey{}, s)
+}
+
+var parentBasedAlways = ParentBased(AlwaysSample())
+
+// samplerFor returns the sampler for spans started from ctx.
+func samplerFor(ctx context.Context) Sampler {
+	if s, ok := ctx.Value(samplerKey{}).(Sampler); ok {
+		return s
+	}
+	if b := defaultSampler.Load(); b != nil {
+		return b.Sampler
+	}
+	return parentBasedAlways
+}
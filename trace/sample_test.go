@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestRatioSampler(t *testing.T) {
+	s := RatioSampler(0.25)
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		id := NewID()
+		got := s.ShouldSample(SamplingParams{TraceID: id})
+		if got != s.ShouldSample(SamplingParams{TraceID: id}) {
+			t.Fatal("decision is not deterministic for a trace ID")
+		}
+		if got {
+			sampled++
+		}
+	}
+	if sampled < 2200 || sampled > 2800 {
+		t.Errorf("sampled %d of 10000 at ratio 0.25", sampled)
+	}
+	if !RatioSampler(1).ShouldSample(SamplingParams{TraceID: "x"}) || RatioSampler(0).ShouldSample(SamplingParams{TraceID: "x"}) {
+		t.Error("ratio 1 and 0 should always and never sample")
+	}
+}
+
+func TestSamplingPropagates(t *testing.T) {
+	var exported []protocol.TraceSpan
+	ctx := WithExporter(context.Background(), ExporterFunc(func(s protocol.TraceSpan) { exported = append(exported, s) }))
+
+	// A parent-based sampler over NeverSample drops the whole trace.
+	ctx = WithSampler(ctx, ParentBased(NeverSample()))
+	ctx, root := Start(ctx, "root")
+	_, child := Start(ctx, "child")
+	if root.Sampled() || child.Sampled() {
+		t.Fatal("spans sampled under NeverSample")
+	}
+	child.End("ok")
+	root.End("ok")
+	if len(exported) != 0 {
+		t.Errorf("exported %d unsampled spans", len(exported))
+	}
+
+	h := make(http.Header)
+	InjectHTTP(ctx, h)
+	if !strings.HasSuffix(h.Get(TraceparentHeader), "-00") {
+		t.Errorf("traceparent = %s, want flags 00", h.Get(TraceparentHeader))
+	}
+
+	var nilSpan *Span
+	if nilSpan.Sampled() {
+		t.Error("nil span reports sampled")
+	}
+}
+
+func TestExtractHTTPHonoursSampledFlag(t *testing.T) {
+	const traceID, parentID = "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"
+	for _, tt := range []struct {
+		flags string
+		want  bool
+	}{{"01", true}, {"00", false}, {"03", true}} {
+		h := make(http.Header)
+		h.Set(TraceparentHeader, "00-"+traceID+"-"+parentID+"-"+tt.flags)
+		ctx, span := ExtractHTTP(context.Background(), h, "handle")
+		if span.Sampled() != tt.want {
+			t.Errorf("flags %s: Sampled = %v, want %v", tt.flags, span.Sampled(), tt.want)
+		}
+		if _, child := Start(ctx, "child"); child.Sampled() != tt.want {
+			t.Errorf("flags %s: child Sampled = %v, want %v", tt.flags, child.Sampled(), tt.want)
+		}
+	}
+
+	// A sampler that ignores the parent overrides the incoming flag.
+	h := make(http.Header)
+	h.Set(TraceparentHeader, FormatTraceparentFlags(traceID, parentID, 0))
+	ctx := WithSampler(context.Background(), AlwaysSample())
+	if _, span := ExtractHTTP(ctx, h, "handle"); !span.Sampled() {
+		t.Error("AlwaysSample did not sample")
+	}
+}
+
+func TestSetSampler(t *testing.T) {
+	SetSampler(NeverSample())
+	defer SetSampler(nil)
+	if _, span := Start(context.Background(), "op"); span.Sampled() {
+		t.Error("process-wide NeverSample ignored")
+	}
+	SetSampler(nil)
+	if _, span := Start(context.Background(), "op"); !span.Sampled() {
+		t.Error("default sampler did not sample a new trace")
+	}
+}
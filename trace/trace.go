@@ -17,6 +17,8 @@ import (
 	"encoding/hex"
 	"sync"
 	"time"
+
+	"github.com/greynewell/mist-go/metadata"
 )
 
 type contextKey struct{}
@@ -33,29 +35,43 @@ type Span struct {
 	Status    string // set by End
 	EndNS     int64  // set by End
 
-	mu    sync.Mutex
-	attrs map[string]any
+	mu       sync.Mutex
+	attrs    map[string]any
+	exporter Exporter
+	sampled  bool
 }
 
 // Start creates a new span and attaches it to the context. If the context
 // already has a span, the new span inherits its trace ID and uses the
 // parent's span ID as its parent.
 func Start(ctx context.Context, operation string) (context.Context, *Span) {
-	s := &Span{
-		SpanID:    newID(),
-		Operation: operation,
-		StartNS:   time.Now().UnixNano(),
-		attrs:     make(map[string]any),
-	}
-
-	if parent := FromContext(ctx); parent != nil {
+	s := &Span{Operation: operation}
+	parent := FromContext(ctx)
+	if parent != nil {
 		s.TraceID = parent.TraceID
 		s.ParentID = parent.SpanID
 	} else {
 		s.TraceID = newID()
 	}
+	ctx = start(ctx, s, parent != nil, parent.Sampled())
+	s.setRequestID(ctx)
+	return ctx, s
+}
 
-	return context.WithValue(ctx, contextKey{}, s), s
+// start fills in the rest of s, makes its sampling decision, and
+// attaches it to ctx.
+func start(ctx context.Context, s *Span, hasParent, parentSampled bool) context.Context {
+	s.SpanID = newID()
+	s.StartNS = time.Now().UnixNano()
+	s.attrs = make(map[string]any)
+	s.exporter = exporterFor(ctx)
+	s.sampled = samplerFor(ctx).ShouldSample(SamplingParams{
+		TraceID:       s.TraceID,
+		Operation:     s.Operation,
+		HasParent:     hasParent,
+		ParentSampled: parentSampled,
+	})
+	return context.WithValue(ctx, contextKey{}, s)
 }
 
 // ValidID reports whether an ID contains only printable ASCII characters
@@ -81,27 +97,49 @@ func StartWithTraceID(ctx context.Context, traceID, operation string) (context.C
 		traceID = newID()
 	}
 
-	s := &Span{
-		TraceID:   traceID,
-		SpanID:    newID(),
-		Operation: operation,
-		StartNS:   time.Now().UnixNano(),
-		attrs:     make(map[string]any),
+	s := &Span{TraceID: traceID, Operation: operation}
+	parent := FromContext(ctx)
+	if parent != nil {
+		s.ParentID = parent.SpanID
 	}
+	ctx = start(ctx, s, parent != nil, parent.Sampled())
+	s.setRequestID(ctx)
+	return ctx, s
+}
 
-	if parent := FromContext(ctx); parent != nil {
-		s.ParentID = parent.SpanID
+// setRequestID records the request ID from ctx's metadata, if any, in the
+// "request_id" attr, so spans can be found from a client-reported ID.
+func (s *Span) setRequestID(ctx context.Context) {
+	if id := metadata.Get(ctx, metadata.KeyRequestID); id != "" {
+		s.attrs["request_id"] = id
 	}
+}
 
-	return context.WithValue(ctx, contextKey{}, s), s
+// Sampled reports whether the span was sampled and will be exported. A
+// nil span is not sampled. Code on hot paths can skip building costly
+// attributes for unsampled spans:
+//
+//	if span.Sampled() {
+//	    span.SetAttr("prompt", render(req))
+//	}
+func (s *Span) Sampled() bool {
+	return s != nil && s.sampled
 }
 
 // End marks the span as complete with the given status ("ok" or "error").
+// The first End of a sampled span exports it if an exporter is
+// configured.
 func (s *Span) End(status string) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	first := s.EndNS == 0
 	s.Status = status
 	s.EndNS = time.Now().UnixNano()
+	exp := s.exporter
+	s.mu.Unlock()
+
+	if first && exp != nil && s.sampled {
+		exp.ExportSpan(s.ToProto())
+	}
 }
 
 // SetAttr sets a key-value attribute on the span. Common attributes:
@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
-	"time"
 )
 
 // W3C Trace Context header names.
@@ -19,15 +19,20 @@ const (
 // version(2)-trace_id(32)-parent_id(16)-flags(2)
 var traceparentRe = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
 
+// FlagSampled is the trace-flags bit set when the caller sampled the
+// trace.
+const FlagSampled byte = 0x01
+
 var zeroTraceID = strings.Repeat("0", 32)
 var zeroParentID = strings.Repeat("0", 16)
 
 // InjectHTTP writes W3C traceparent and tracestate headers from the
 // current span in the context. If the context has no span, this is a no-op.
 //
-// The traceparent header encodes the trace ID and span ID in the W3C format:
+// The traceparent header encodes the trace ID, span ID, and sampling
+// decision in the W3C format:
 //
-//	traceparent: 00-{trace_id_32hex}-{parent_id_16hex}-01
+//	traceparent: 00-{trace_id_32hex}-{parent_id_16hex}-{01 sampled, 00 not}
 //
 // MIST generates 32-hex span IDs; for W3C compatibility, the last 16 hex
 // characters are used as the parent-id.
@@ -40,48 +45,55 @@ func InjectHTTP(ctx context.Context, h http.Header) {
 	traceID := normalizeTraceID(span.TraceID)
 	parentID := normalizeParentID(span.SpanID)
 
-	h.Set(TraceparentHeader, FormatTraceparent(traceID, parentID))
+	var flags byte
+	if span.Sampled() {
+		flags = FlagSampled
+	}
+	h.Set(TraceparentHeader, FormatTraceparentFlags(traceID, parentID, flags))
 	h.Set(TracestateHeader, fmt.Sprintf("mist=%s", span.SpanID))
 }
 
-// ExtractHTTP reads the W3C traceparent header and creates a child span.
-// If the header is missing or invalid, a new root span is created.
-// If a tracestate header is present, it is preserved as a span attribute.
+// ExtractHTTP reads the W3C traceparent header and creates a child span,
+// passing the caller's sampled flag to the sampler (the default sampler
+// follows it). If the header is missing or invalid, a new root span is
+// created. If a tracestate header is present, it is preserved as a span
+// attribute.
 func ExtractHTTP(ctx context.Context, h http.Header, operation string) (context.Context, *Span) {
 	tp := h.Get(TraceparentHeader)
 	if tp == "" {
 		return Start(ctx, operation)
 	}
 
-	traceID, parentID, ok := ParseTraceparent(tp)
+	traceID, parentID, flags, ok := ParseTraceparentFlags(tp)
 	if !ok {
 		return Start(ctx, operation)
 	}
 
-	s := &Span{
-		TraceID:   traceID,
-		SpanID:    newID(),
-		ParentID:  parentID,
-		Operation: operation,
-		StartNS:   time.Now().UnixNano(),
-		attrs:     make(map[string]any),
-	}
+	s := &Span{TraceID: traceID, ParentID: parentID, Operation: operation}
+	ctx = start(ctx, s, true, flags&FlagSampled != 0)
 
 	// Preserve tracestate if present.
 	if ts := h.Get(TracestateHeader); ts != "" {
 		s.SetAttr("tracestate", ts)
 	}
 
-	return context.WithValue(ctx, contextKey{}, s), s
+	return ctx, s
 }
 
 // ParseTraceparent parses a W3C traceparent header value.
 // Returns the trace ID, parent ID, and whether the parse succeeded.
 // Returns false for invalid formats, all-zero trace IDs, or all-zero parent IDs.
 func ParseTraceparent(header string) (traceID, parentID string, ok bool) {
+	traceID, parentID, _, ok = ParseTraceparentFlags(header)
+	return traceID, parentID, ok
+}
+
+// ParseTraceparentFlags is like ParseTraceparent and also returns the
+// trace-flags byte; test it against FlagSampled.
+func ParseTraceparentFlags(header string) (traceID, parentID string, flags byte, ok bool) {
 	matches := traceparentRe.FindStringSubmatch(header)
 	if matches == nil {
-		return "", "", false
+		return "", "", 0, false
 	}
 
 	traceID = matches[2]
@@ -89,15 +101,23 @@ func ParseTraceparent(header string) (traceID, parentID string, ok bool) {
 
 	// W3C spec: all-zero trace-id and parent-id are invalid.
 	if traceID == zeroTraceID || parentID == zeroParentID {
-		return "", "", false
+		return "", "", 0, false
 	}
 
-	return traceID, parentID, true
+	f, _ := strconv.ParseUint(matches[4], 16, 8)
+	return traceID, parentID, byte(f), true
 }
 
-// FormatTraceparent formats a W3C traceparent header value.
+// FormatTraceparent formats a W3C traceparent header value for a sampled
+// trace.
 func FormatTraceparent(traceID, parentID string) string {
-	return fmt.Sprintf("00-%s-%s-01", traceID, parentID)
+	return FormatTraceparentFlags(traceID, parentID, FlagSampled)
+}
+
+// FormatTraceparentFlags formats a W3C traceparent header value with the
+// given trace-flags.
+func FormatTraceparentFlags(traceID, parentID string, flags byte) string {
+	return fmt.Sprintf("00-%s-%s-%02x", traceID, parentID, flags)
 }
 
 // normalizeTraceID ensures the trace ID is exactly 32 lowercase hex characters.
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"context"
+	"iter"
+	"sync"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// ErrSettled is returned by Delivery.Ack or Nack on a delivery that was
+// already acked or nacked.
+var ErrSettled = misterrors.New(misterrors.CodeConflict, "transport: delivery already settled").Permanent()
+
+// AckReceiver can receive messages for at-least-once processing. A
+// message received with ReceiveDelivery counts as handled only once it
+// is acked; one that is nacked, or never settled before a restart, is
+// delivered again.
+type AckReceiver interface {
+	ReceiveDelivery(ctx context.Context) (*Delivery, error)
+}
+
+// Delivery is a message received from an AckReceiver. Ack it once the
+// message has been processed, or Nack it to have it delivered again:
+//
+//	for d, err := range transport.Deliveries(ctx, src) {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    if err := handle(d.Message); err != nil {
+//	        d.Nack()
+//	        continue
+//	    }
+//	    d.Ack()
+//	}
+type Delivery struct {
+	Message *protocol.Message
+
+	// Attempt is 1 the first time this process delivers the message and
+	// counts up with each Nack, so a handler can give up on a message
+	// that keeps failing.
+	Attempt int
+
+	mu      sync.Mutex
+	settled bool
+	settle  func(ack bool) error // nil for a receiver without acks
+}
+
+// Ack marks the message processed.
+func (d *Delivery) Ack() error {
+	return d.finish(true)
+}
+
+// Nack returns the message to the receiver to be delivered again.
+func (d *Delivery) Nack() error {
+	return d.finish(false)
+}
+
+func (d *Delivery) finish(ack bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.settled {
+		return ErrSettled
+	}
+	d.settled = true
+	if d.settle == nil {
+		return nil
+	}
+	return d.settle(ack)
+}
+
+// Deliveries returns an iterator over deliveries from r, like Messages.
+// If r is not an AckReceiver, each message it receives is delivered with
+// an Ack and Nack that do nothing, so the same loop serves any source,
+// with at-least-once delivery where the source supports it.
+func Deliveries(ctx context.Context, r Receiver) iter.Seq2[*Delivery, error] {
+	ar, ok := r.(AckReceiver)
+	if !ok {
+		return func(yield func(*Delivery, error) bool) {
+			for msg, err := range Messages(ctx, r) {
+				var d *Delivery
+				if err == nil {
+					d = &Delivery{Message: msg, Attempt: 1}
+				}
+				if !yield(d, err) {
+					return
+				}
+			}
+		}
+	}
+	return func(yield func(*Delivery, error) bool) {
+		for {
+			d, err := ar.ReceiveDelivery(ctx)
+			var msg *protocol.Message
+			if d != nil {
+				msg = d.Message
+			}
+			switch {
+			case ctx.Err() != nil, endOfStream(msg, err):
+				return
+			case err != nil:
+				if !yield(nil, err) || !misterrors.IsRetryable(err) {
+					return
+				}
+			default:
+				if !yield(d, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// redelivery is a nacked message waiting to be delivered again.
+type redelivery struct {
+	msg     *protocol.Message
+	seq     int64  // position in the source, for File
+	release func() // frees the message's memory reservation, for HTTP
+	attempt int
+}
+
+// requeue holds nacked messages until they are received again, oldest
+// nack last so the most recently failed message is retried first.
+type requeue struct {
+	mu     sync.Mutex
+	items  []redelivery
+	signal chan struct{} // has a value while items is non-empty
+}
+
+func (q *requeue) push(r redelivery) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append([]redelivery{r}, q.items...)
+	select {
+	case q.wake() <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next nacked message, if any.
+func (q *requeue) pop() (redelivery, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return redelivery{}, false
+	}
+	r := q.items[0]
+	q.items = q.items[1:]
+	if len(q.items) == 0 {
+		select {
+		case <-q.wake():
+		default:
+		}
+	}
+	return r, true
+}
+
+// wake returns the channel that has a value while messages are waiting.
+// Must be called with mu held.
+func (q *requeue) wake() chan struct{} {
+	if q.signal == nil {
+		q.signal = make(chan struct{}, 1)
+	}
+	return q.signal
+}
+
+// waiting returns the channel to select on for nacked messages.
+func (q *requeue) waiting() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.wake()
+}
@@ -0,0 +1,252 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestChannelNackRedelivers(t *testing.T) {
+	ctx := context.Background()
+	ch := NewChannel(4)
+	first, second := ping(t), ping(t)
+	ch.Send(ctx, first)
+	ch.Send(ctx, second)
+
+	d, err := ch.ReceiveDelivery(ctx)
+	if err != nil || d.Message.ID != first.ID || d.Attempt != 1 {
+		t.Fatalf("ReceiveDelivery = %+v, %v", d, err)
+	}
+	if err := d.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(); !errors.Is(err, ErrSettled) {
+		t.Errorf("Ack after Nack = %v, want ErrSettled", err)
+	}
+
+	// The nacked message comes back ahead of the rest.
+	d, _ = ch.ReceiveDelivery(ctx)
+	if d.Message.ID != first.ID || d.Attempt != 2 {
+		t.Fatalf("redelivery = %s attempt %d, want %s attempt 2", d.Message.ID, d.Attempt, first.ID)
+	}
+	d.Ack()
+	if msg, _ := ch.Receive(ctx); msg.ID != second.ID {
+		t.Errorf("Receive = %s, want %s", msg.ID, second.ID)
+	}
+}
+
+func TestChannelNackWakesReceiver(t *testing.T) {
+	ctx := context.Background()
+	ch := NewChannel(1)
+	ch.Send(ctx, ping(t))
+	d, _ := ch.ReceiveDelivery(ctx)
+
+	got := make(chan *Delivery)
+	go func() {
+		d, _ := ch.ReceiveDelivery(ctx)
+		got <- d
+	}()
+	time.Sleep(10 * time.Millisecond)
+	d.Nack()
+	select {
+	case r := <-got:
+		if r.Message.ID != d.Message.ID {
+			t.Errorf("woke with %s, want %s", r.Message.ID, d.Message.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked receiver not woken by Nack")
+	}
+}
+
+func writeMessages(t *testing.T, path string, n int) []*protocol.Message {
+	t.Helper()
+	msgs := make([]*protocol.Message, n)
+	for i := range msgs {
+		msgs[i] = ping(t)
+	}
+	writeMessagesFrom(t, path, msgs...)
+	return msgs
+}
+
+func TestFileAckResume(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	msgs := writeMessages(t, path, 5)
+
+	f, _ := NewFile(path)
+	f.SetResume(true)
+	var ds []*Delivery
+	for range 4 {
+		d, err := f.ReceiveDelivery(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, d)
+	}
+	// Acks out of order commit only the unbroken run from the start.
+	ds[0].Ack()
+	ds[2].Ack()
+	ds[1].Nack()
+	f.Close()
+	if data, _ := os.ReadFile(path + ".ack"); strings.TrimSpace(string(data)) != "1" {
+		t.Fatalf("ack file = %q, want 1", data)
+	}
+
+	// A new reader resumes at the first message not acked. The one
+	// acked past it is delivered again: at least once.
+	f, _ = NewFile(path)
+	f.SetResume(true)
+	var got []string
+	for d, err := range Deliveries(ctx, f) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d.Message.ID)
+		d.Ack()
+	}
+	want := []string{msgs[1].ID, msgs[2].ID, msgs[3].ID, msgs[4].ID}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("resumed with %v, want %v", got, want)
+	}
+	if _, err := f.ReceiveDelivery(ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("ReceiveDelivery at end = %v, want io.EOF", err)
+	}
+	// Acks within ackSaveInterval of the last save wait for Close.
+	if data, _ := os.ReadFile(path + ".ack"); strings.TrimSpace(string(data)) != "2" {
+		t.Errorf("ack file before Close = %q, want 2", data)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path + ".ack"); strings.TrimSpace(string(data)) != "5" {
+		t.Errorf("ack file = %q, want 5", data)
+	}
+}
+
+func TestFileAckNoResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dead.jsonl")
+	writeMessages(t, path, 3)
+	// A read-only directory is fine: nothing is written beside the file.
+	os.Chmod(dir, 0o500)
+	defer os.Chmod(dir, 0o700)
+
+	for run := range 2 {
+		f, _ := NewFile(path)
+		n := 0
+		for d, err := range Deliveries(ctx, f) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Ack(); err != nil {
+				t.Fatalf("run %d: Ack = %v", run, err)
+			}
+			n++
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("run %d delivered %d messages, want all 3", run, n)
+		}
+	}
+	if _, err := os.Stat(path + ".ack"); !os.IsNotExist(err) {
+		t.Errorf("ack file written without SetResume: %v", err)
+	}
+}
+
+func TestFileAckBadLine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	os.WriteFile(path, []byte("not json\n"), 0o600)
+	writeMessages(t, path, 1)
+
+	f, _ := NewFile(path)
+	f.SetResume(true)
+	if _, err := f.ReceiveDelivery(ctx); err == nil {
+		t.Fatal("bad line delivered")
+	}
+	d, err := f.ReceiveDelivery(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Ack()
+	f.Close()
+	if data, _ := os.ReadFile(path + ".ack"); strings.TrimSpace(string(data)) != "2" {
+		t.Errorf("ack file = %q, want the bad line settled too", data)
+	}
+}
+
+func TestDeliveriesWithoutAcks(t *testing.T) {
+	ctx := context.Background()
+	ch := NewChannel(2)
+	ch.Send(ctx, ping(t))
+	ch.Close()
+	// Receiver only, so Deliveries can't use the Channel's acks.
+	var r Receiver = struct{ Receiver }{ch}
+	n := 0
+	for d, err := range Deliveries(ctx, r) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Nack(); err != nil {
+			t.Errorf("Nack = %v", err)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("got %d deliveries, want 1", n)
+	}
+}
+
+func TestMiddlewareDeliveries(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	msgs := writeMessages(t, path, 2)
+	dup := *msgs[0]
+	writeMessagesFrom(t, path, &dup)
+
+	f, _ := NewFile(path)
+	f.SetResume(true)
+	m := Wrap(f, WithDedup(time.Minute, 100))
+	d, err := m.ReceiveDelivery(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A nacked message comes back through dedup.
+	d.Nack()
+	if d, _ = m.ReceiveDelivery(ctx); d.Message.ID != msgs[0].ID || d.Attempt != 2 {
+		t.Fatalf("redelivery = %s attempt %d", d.Message.ID, d.Attempt)
+	}
+	d.Ack()
+	d, _ = m.ReceiveDelivery(ctx)
+	d.Ack()
+	// The duplicate is dropped and acked, committing the whole file.
+	if _, err := m.ReceiveDelivery(ctx); !errors.Is(err, io.EOF) {
+		t.Fatalf("ReceiveDelivery = %v, want io.EOF", err)
+	}
+	m.Close()
+	if data, _ := os.ReadFile(path + ".ack"); strings.TrimSpace(string(data)) != "3" {
+		t.Errorf("ack file = %q, want 3", data)
+	}
+}
+
+func writeMessagesFrom(t *testing.T, path string, msgs ...*protocol.Message) {
+	t.Helper()
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.SendBatch(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,319 @@
+package transport
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// DefaultMaxInlineAttachment is the largest attachment WithAttachments
+// sends inline unless AttachmentPolicy.MaxInline says otherwise.
+const DefaultMaxInlineAttachment = 256 << 10
+
+// DefaultMaxAttachment is the largest attachment FetchAttachment reads
+// unless AttachmentPolicy.MaxSize says otherwise.
+const DefaultMaxAttachment = 64 << 20
+
+// ErrAttachmentNotAllowed is returned by FetchAttachment for a reference
+// its AttachmentPolicy doesn't allow it to follow.
+var ErrAttachmentNotAllowed = misterrors.New(misterrors.CodeValidation, "transport: attachment location not allowed").Permanent()
+
+// AttachmentStore holds attachment content out of band, so messages
+// carry a reference instead of the bytes.
+type AttachmentStore interface {
+	// Put stores data, whose digest is given, and returns the URL
+	// FetchAttachment reads it back from.
+	Put(ctx context.Context, digest string, data []byte) (url string, err error)
+}
+
+// DirStore is an AttachmentStore in a directory, typically one shared by
+// the nodes exchanging messages. Content is addressed by digest, at
+// <dir>/sha256/<hex>, so the same bytes are stored once, and referenced
+// by file:// URL.
+type DirStore struct {
+	Dir string
+}
+
+// Put writes data to the store unless it is already there.
+func (s DirStore) Put(ctx context.Context, digest string, data []byte) (string, error) {
+	algo, sum, ok := strings.Cut(digest, ":")
+	if _, err := hex.DecodeString(sum); !ok || err != nil || algo != "sha256" {
+		return "", fmt.Errorf("attachment store: bad digest %q", digest)
+	}
+	path, err := filepath.Abs(filepath.Join(s.Dir, algo, sum))
+	if err != nil {
+		return "", fmt.Errorf("attachment store: %w", err)
+	}
+	ref := (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	if _, err := os.Stat(path); err == nil {
+		return ref, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("attachment store: %w", err)
+	}
+	// Write then rename, so readers never see a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return "", fmt.Errorf("attachment store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("attachment store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("attachment store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("attachment store: %w", err)
+	}
+	return ref, nil
+}
+
+// FetchAttachment returns a's content: its inline data, or the bytes at
+// its file:// or http(s) URL, checked against its size and digest. A
+// reference is only followed where p allows: a file:// URL under one of
+// p.AllowedRoots, or an http(s) URL on one of p.AllowedHosts. The zero
+// policy reads inline attachments alone, so references in untrusted
+// messages can't make a service read its own files or reach internal
+// hosts. An attachment over p.MaxSize, or DefaultMaxAttachment, is
+// refused before anything is read. Content that doesn't match is refused
+// without saying what was read.
+func FetchAttachment(ctx context.Context, a *protocol.Attachment, p AttachmentPolicy) ([]byte, error) {
+	maxSize := p.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxAttachment
+	}
+	if a.Size > maxSize {
+		return nil, misterrors.Wrapf(misterrors.CodeValidation, ErrMessageTooLarge,
+			"attachment %q: %d bytes exceeds %d", a.Name, a.Size, maxSize)
+	}
+	if a.Inline() {
+		return a.Data, verifyAttachment(a, a.Data)
+	}
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+	}
+	var data []byte
+	switch u.Scheme {
+	case "file":
+		path, err := p.allowedPath(filepath.FromSlash(u.Path))
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+		}
+		defer f.Close()
+		if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+			return nil, misterrors.Wrapf(misterrors.CodeValidation, ErrAttachmentNotAllowed, "attachment %q: not a regular file", a.Name)
+		}
+		data, err = readAttachment(f, a.Size)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+		}
+	case "http", "https":
+		if !p.allowedHost(u) {
+			return nil, misterrors.Wrapf(misterrors.CodeValidation, ErrAttachmentNotAllowed, "attachment %q: host %s", a.Name, u.Host)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+		}
+		client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !p.allowedHost(req.URL) {
+				return misterrors.Wrapf(misterrors.CodeValidation, ErrAttachmentNotAllowed, "redirect to host %s", req.URL.Host)
+			}
+			return nil
+		}}
+		resp, err := client.Do(req)
+		if err != nil {
+			if misterrors.Is(err, ErrAttachmentNotAllowed) {
+				return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+			}
+			return nil, misterrors.Wrapf(misterrors.CodeUnavailable, err, "attachment %q", a.Name)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("attachment %q: %s: status %d", a.Name, a.URL, resp.StatusCode)
+		}
+		if data, err = readAttachment(resp.Body, a.Size); err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", a.Name, err)
+		}
+	default:
+		return nil, fmt.Errorf("attachment %q: unsupported url scheme %q", a.Name, u.Scheme)
+	}
+	if err := verifyAttachment(a, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyAttachment checks data against a without reporting the size or
+// digest of what was read, which may be a file the sender can't see.
+func verifyAttachment(a *protocol.Attachment, data []byte) error {
+	if a.Verify(data) != nil {
+		return misterrors.Newf(misterrors.CodeValidation, "attachment %q: content does not match its size and digest", a.Name)
+	}
+	return nil
+}
+
+// readAttachment reads up to one byte past size, enough for Verify to
+// tell the content is longer than declared without reading all of it.
+func readAttachment(r io.Reader, size int64) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r, size+1))
+}
+
+// allowedPath returns path with symlinks resolved if it lies under one of
+// p.AllowedRoots. A path outside them is refused before the file system
+// is consulted.
+func (p *AttachmentPolicy) allowedPath(path string) (string, error) {
+	path = filepath.Clean(path)
+	for _, root := range p.AllowedRoots {
+		root, err := filepath.Abs(root)
+		if err != nil || !within(root, path) {
+			continue
+		}
+		// A symlink under the root may lead out of it.
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return "", err
+		}
+		if realRoot, err := filepath.EvalSymlinks(root); err == nil && within(realRoot, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", misterrors.Wrapf(misterrors.CodeValidation, ErrAttachmentNotAllowed, "%s is outside the allowed roots", path)
+}
+
+// within reports whether path is root or lies under it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// allowedHost reports whether u's host, with or without its port, is one
+// of p.AllowedHosts.
+func (p *AttachmentPolicy) allowedHost(u *url.URL) bool {
+	for _, h := range p.AllowedHosts {
+		if strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// AttachmentPolicy configures WithAttachments and FetchAttachment.
+type AttachmentPolicy struct {
+	// MaxInline is the largest attachment sent inline. Larger ones are
+	// moved to Store and sent by reference. Zero means
+	// DefaultMaxInlineAttachment.
+	MaxInline int64
+
+	// MaxSize is the largest attachment sent or received at all, inline
+	// or by reference. Messages with a larger one are refused with
+	// ErrMessageTooLarge. Zero means no limit on send and receive, and
+	// DefaultMaxAttachment for FetchAttachment.
+	MaxSize int64
+
+	// AllowedRoots are the directories FetchAttachment reads file://
+	// references from, such as a DirStore's Dir. There are none by
+	// default.
+	AllowedRoots []string
+
+	// AllowedHosts are the hosts, as "host" or "host:port", that
+	// FetchAttachment fetches http(s) references from, and follows
+	// redirects to. There are none by default.
+	AllowedHosts []string
+
+	// Store holds attachments over MaxInline. If nil, messages with one
+	// are refused on send with ErrMessageTooLarge.
+	Store AttachmentStore
+
+	// Metrics, if set, counts attachments moved to Store in
+	// transport_attachments_offloaded_total and refused ones in
+	// transport_attachments_rejected_total{direction}.
+	Metrics *metrics.Registry
+}
+
+// WithAttachments enforces an attachment size policy: on send, inline
+// attachments over MaxInline are moved to the policy's Store and replaced
+// by a reference, and any attachment over MaxSize is refused, on send and
+// on receive. Receivers read references with FetchAttachment.
+func WithAttachments(p AttachmentPolicy) MiddlewareOption {
+	if p.MaxInline <= 0 {
+		p.MaxInline = DefaultMaxInlineAttachment
+	}
+	return func(m *Middleware) { m.attachments = &p }
+}
+
+// offload applies the policy to an outgoing message. The message gets a
+// new Attachments slice, so copies sharing the old one are unaffected.
+func (p *AttachmentPolicy) offload(ctx context.Context, msg *protocol.Message) error {
+	if err := p.check("send", msg); err != nil {
+		return err
+	}
+	var out []protocol.Attachment
+	for i, a := range msg.Attachments {
+		if !a.Inline() || a.Size <= p.MaxInline {
+			continue
+		}
+		if p.Store == nil {
+			p.reject("send")
+			return misterrors.Wrapf(misterrors.CodeValidation, ErrMessageTooLarge,
+				"attachment %q: %d bytes exceeds %d inline and no store is set", a.Name, a.Size, p.MaxInline)
+		}
+		ref, err := p.Store.Put(ctx, a.Digest, a.Data)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = append([]protocol.Attachment(nil), msg.Attachments...)
+		}
+		out[i].Data, out[i].URL = nil, ref
+		if p.Metrics != nil {
+			p.Metrics.Counter("transport_attachments_offloaded_total").Inc()
+		}
+	}
+	if out != nil {
+		msg.Attachments = out
+	}
+	return nil
+}
+
+// check refuses a message with an attachment over MaxSize.
+func (p *AttachmentPolicy) check(direction string, msg *protocol.Message) error {
+	if p.MaxSize <= 0 {
+		return nil
+	}
+	for _, a := range msg.Attachments {
+		if a.Size > p.MaxSize {
+			p.reject(direction)
+			return misterrors.Wrapf(misterrors.CodeValidation, ErrMessageTooLarge,
+				"%s %s: attachment %q: %d bytes exceeds %d", direction, msg.Type, a.Name, a.Size, p.MaxSize)
+		}
+	}
+	return nil
+}
+
+func (p *AttachmentPolicy) reject(direction string) {
+	if p.Metrics != nil {
+		p.Metrics.Counter("transport_attachments_rejected_total", "direction", direction).Inc()
+	}
+}
@@ -0,0 +1,160 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestAttachmentsOffload(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+	ch := NewChannel(4)
+	store := DirStore{Dir: t.TempDir()}
+	m := Wrap(ch, WithAttachments(AttachmentPolicy{MaxInline: 16, Store: store, Metrics: reg}))
+
+	small, large := []byte("thumbnail"), bytes.Repeat([]byte{0xab}, 1024)
+	msg := ping(t)
+	msg.Attach("small", "image/png", small)
+	msg.Attach("large", "image/png", large)
+	shared := msg.Attachments
+	if err := m.Send(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if !shared[1].Inline() {
+		t.Error("offload changed the sender's original attachments")
+	}
+
+	got, err := m.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := got.Attachment("small"); !a.Inline() {
+		t.Error("attachment under MaxInline was offloaded")
+	}
+	a, _ := got.Attachment("large")
+	if a.Inline() || !strings.HasPrefix(a.URL, "file://") {
+		t.Fatalf("large attachment = %+v, want a file:// reference", a)
+	}
+	data, err := FetchAttachment(ctx, a, AttachmentPolicy{AllowedRoots: []string{store.Dir}})
+	if err != nil || !bytes.Equal(data, large) {
+		t.Fatalf("FetchAttachment = %d bytes, %v", len(data), err)
+	}
+	if n := reg.Counter("transport_attachments_offloaded_total").Value(); n != 1 {
+		t.Errorf("transport_attachments_offloaded_total = %d, want 1", n)
+	}
+
+	// The same content is stored once.
+	ref, err := store.Put(ctx, a.Digest, large)
+	if err != nil || ref != a.URL {
+		t.Errorf("second Put = %q, %v; want %q", ref, err, a.URL)
+	}
+}
+
+func TestAttachmentsReject(t *testing.T) {
+	ctx := context.Background()
+	ch := NewChannel(4)
+	m := Wrap(ch, WithAttachments(AttachmentPolicy{MaxInline: 16}))
+	msg := ping(t)
+	msg.Attach("large", "", make([]byte, 32))
+	if err := m.Send(ctx, msg); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("Send without a store = %v, want ErrMessageTooLarge", err)
+	}
+	if len(ch.recv) != 0 {
+		t.Error("refused message was sent")
+	}
+
+	// MaxSize applies to references too, and on receive.
+	reg := metrics.NewRegistry()
+	msg = ping(t)
+	msg.AttachRef("shard", "", "https://blobs.example/shard", 1<<30, protocol.Digest(nil))
+	ch.Send(ctx, msg)
+	m = Wrap(ch, WithAttachments(AttachmentPolicy{MaxSize: 1 << 20, Metrics: reg}))
+	if _, err := m.Receive(ctx); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("Receive = %v, want ErrMessageTooLarge", err)
+	}
+	if n := reg.Counter("transport_attachments_rejected_total", "direction", "receive").Value(); n != 1 {
+		t.Errorf("transport_attachments_rejected_total = %d, want 1", n)
+	}
+}
+
+func TestFetchAttachment(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("parquet shard")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/shard":
+			w.Write(content)
+		case "/elsewhere":
+			http.Redirect(w, r, "http://internal.example/shard", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	policy := AttachmentPolicy{AllowedHosts: []string{host}}
+
+	var msg protocol.Message
+	a := msg.AttachRef("shard", "", srv.URL+"/shard", int64(len(content)), protocol.Digest(content))
+	if data, err := FetchAttachment(ctx, a, policy); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("FetchAttachment = %q, %v", data, err)
+	}
+	if _, err := FetchAttachment(ctx, a, AttachmentPolicy{}); !misterrors.Is(err, ErrAttachmentNotAllowed) {
+		t.Errorf("FetchAttachment without allowed hosts = %v, want ErrAttachmentNotAllowed", err)
+	}
+	a = msg.AttachRef("shard", "", srv.URL+"/elsewhere", int64(len(content)), protocol.Digest(content))
+	if _, err := FetchAttachment(ctx, a, policy); !misterrors.Is(err, ErrAttachmentNotAllowed) {
+		t.Errorf("FetchAttachment redirected to another host = %v, want ErrAttachmentNotAllowed", err)
+	}
+
+	// Content that doesn't match the digest is refused without saying
+	// what was read.
+	a = msg.AttachRef("shard", "", srv.URL+"/shard", int64(len(content)), protocol.Digest([]byte("other shard!!")))
+	if _, err := FetchAttachment(ctx, a, policy); err == nil || strings.Contains(err.Error(), protocol.Digest(content)) {
+		t.Errorf("FetchAttachment of content with the wrong digest = %v", err)
+	}
+	a = msg.AttachRef("missing", "", srv.URL+"/missing", 1, protocol.Digest([]byte("x")))
+	if _, err := FetchAttachment(ctx, a, policy); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("FetchAttachment of a missing URL = %v", err)
+	}
+	a = msg.AttachRef("large", "", srv.URL+"/shard", 1<<30, protocol.Digest(nil))
+	if _, err := FetchAttachment(ctx, a, policy); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("FetchAttachment over the default size = %v, want ErrMessageTooLarge", err)
+	}
+
+	// Files are read only under an allowed root, even through a symlink.
+	root, outside := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret"), content, 0o600)
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "link"))
+	files := AttachmentPolicy{AllowedRoots: []string{root}}
+	a = msg.AttachRef("missing", "", "file://"+filepath.ToSlash(filepath.Join(root, "missing")), 1, protocol.Digest([]byte("x")))
+	if _, err := FetchAttachment(ctx, a, files); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FetchAttachment of a missing file = %v", err)
+	}
+	for _, path := range []string{
+		filepath.Join(outside, "secret"),
+		filepath.Join(root, "..", filepath.Base(outside), "secret"),
+		filepath.Join(root, "link"),
+	} {
+		a = msg.AttachRef("secret", "", "file://"+filepath.ToSlash(path), int64(len(content)), protocol.Digest(content))
+		if _, err := FetchAttachment(ctx, a, files); !misterrors.Is(err, ErrAttachmentNotAllowed) {
+			t.Errorf("FetchAttachment of %s = %v, want ErrAttachmentNotAllowed", path, err)
+		}
+	}
+	a = msg.AttachRef("zero", "", "file:///dev/zero", 1, protocol.Digest([]byte{0}))
+	if _, err := FetchAttachment(ctx, a, AttachmentPolicy{}); !misterrors.Is(err, ErrAttachmentNotAllowed) {
+		t.Errorf("FetchAttachment of /dev/zero by default = %v, want ErrAttachmentNotAllowed", err)
+	}
+}
@@ -0,0 +1,214 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/greynewell/mist-go/circuitbreaker"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Strategy chooses which endpoint of a Balanced transport a send goes to.
+type Strategy int
+
+const (
+	// RoundRobin sends to each endpoint in turn.
+	RoundRobin Strategy = iota
+	// LeastErrors sends to the endpoint with the lowest error rate so
+	// far, taking endpoints in turn among equals.
+	LeastErrors
+	// Weighted sends to endpoints in proportion to their weights (see
+	// Balanced.WithWeights), spreading each endpoint's turns evenly.
+	Weighted
+)
+
+// ErrNoEndpoint is returned by a Balanced send when every endpoint's
+// circuit breaker is open.
+var ErrNoEndpoint = misterrors.New(misterrors.CodeUnavailable, "transport: no endpoint available")
+
+// Balanced is a Transport that spreads sends across equivalent
+// endpoints, such as several InferMux instances:
+//
+//	t := transport.NewBalanced([]transport.Transport{
+//		transport.NewHTTP("http://infermux-a:8081"),
+//		transport.NewHTTP("http://infermux-b:8081"),
+//	}, transport.LeastErrors)
+//
+// Each endpoint has a circuit breaker. An endpoint whose breaker is open
+// is skipped, and a send that fails with a retryable error moves on to
+// the next endpoint, so one bad instance costs at most a failed attempt
+// per send until its breaker opens. Receive reads from the endpoint the
+// last send went to.
+type Balanced struct {
+	endpoints []*endpoint
+	strategy  Strategy
+
+	mu   sync.Mutex
+	next int // round-robin position
+	last int // endpoint of the last send
+}
+
+type endpoint struct {
+	t       Transport
+	breaker *circuitbreaker.Breaker
+	weight  int
+	current int // smooth weighted round-robin state, under Balanced.mu
+}
+
+// NewBalanced creates a balanced transport over endpoints. Each gets a
+// circuit breaker with circuitbreaker's defaults; see WithBreaker.
+func NewBalanced(endpoints []Transport, strategy Strategy) *Balanced {
+	b := &Balanced{strategy: strategy}
+	for _, t := range endpoints {
+		b.endpoints = append(b.endpoints, &endpoint{
+			t:       t,
+			breaker: circuitbreaker.New(circuitbreaker.Config{}),
+			weight:  1,
+		})
+	}
+	return b
+}
+
+// WithBreaker replaces each endpoint's circuit breaker with one built
+// from cfg, and returns b. Call it before the first Send.
+func (b *Balanced) WithBreaker(cfg circuitbreaker.Config) *Balanced {
+	for _, ep := range b.endpoints {
+		ep.breaker = circuitbreaker.New(cfg)
+	}
+	return b
+}
+
+// WithWeights sets the endpoints' weights for the Weighted strategy, in
+// the order they were given to NewBalanced, and returns b. Missing or
+// non-positive weights count as 1. Call it before the first Send.
+func (b *Balanced) WithWeights(weights ...int) *Balanced {
+	for i, ep := range b.endpoints {
+		ep.weight = 1
+		if i < len(weights) && weights[i] > 0 {
+			ep.weight = weights[i]
+		}
+	}
+	return b
+}
+
+// Breaker returns the circuit breaker of endpoint i, for example to
+// report its Stats in a server.Snapshotter.
+func (b *Balanced) Breaker(i int) *circuitbreaker.Breaker {
+	return b.endpoints[i].breaker
+}
+
+// Send sends msg to the endpoint the strategy picks, moving on to the
+// others if it fails.
+func (b *Balanced) Send(ctx context.Context, msg *protocol.Message) error {
+	return b.send(ctx, func(t Transport) error { return t.Send(ctx, msg) })
+}
+
+// SendBatch sends msgs to one endpoint, as Send does, in one call if it
+// is a BatchSender.
+func (b *Balanced) SendBatch(ctx context.Context, msgs []*protocol.Message) error {
+	return b.send(ctx, func(t Transport) error { return SendBatch(ctx, t, msgs) })
+}
+
+func (b *Balanced) send(ctx context.Context, send func(Transport) error) error {
+	var failed []error
+	for _, i := range b.order() {
+		ep := b.endpoints[i]
+		var sendErr error
+		err := ep.breaker.Do(ctx, func(context.Context) error {
+			sendErr = send(ep.t)
+			// Only failures another endpoint might not have count
+			// against this one; an oversized or expired message
+			// says nothing about its health.
+			if misterrors.IsRetryable(sendErr) {
+				return sendErr
+			}
+			return nil
+		})
+		if errors.Is(err, circuitbreaker.ErrOpen) {
+			continue
+		}
+		b.mu.Lock()
+		b.last = i
+		b.mu.Unlock()
+		if sendErr == nil || !misterrors.IsRetryable(sendErr) || ctx.Err() != nil {
+			return sendErr
+		}
+		failed = append(failed, fmt.Errorf("endpoint %d: %w", i, sendErr))
+	}
+	if len(failed) == 0 {
+		return ErrNoEndpoint
+	}
+	return misterrors.Wrapf(misterrors.CodeUnavailable, errors.Join(failed...), "transport: all %d endpoints tried failed", len(failed))
+}
+
+// order returns the endpoint indices in the order a send tries them:
+// the strategy's pick first, then the rest in turn after it.
+func (b *Balanced) order() []int {
+	n := len(b.endpoints)
+	if n == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	start := b.next % n
+	b.next++
+	if b.strategy == Weighted {
+		start = b.pickWeighted()
+	}
+	b.mu.Unlock()
+
+	order := make([]int, n)
+	for k := range order {
+		order[k] = (start + k) % n
+	}
+	if b.strategy == LeastErrors {
+		rates := make([]float64, n)
+		for i, ep := range b.endpoints {
+			ok, failed := ep.breaker.Counts()
+			if total := ok + failed; total > 0 {
+				rates[i] = float64(failed) / float64(total)
+			}
+		}
+		sort.SliceStable(order, func(a, c int) bool { return rates[order[a]] < rates[order[c]] })
+	}
+	return order
+}
+
+// pickWeighted is smooth weighted round-robin: each pick, every
+// endpoint gains its weight, and the one with the most is chosen and
+// loses the total. Must be called with mu held.
+func (b *Balanced) pickWeighted() int {
+	total, best := 0, 0
+	for i, ep := range b.endpoints {
+		ep.current += ep.weight
+		total += ep.weight
+		if ep.current > b.endpoints[best].current {
+			best = i
+		}
+	}
+	b.endpoints[best].current -= total
+	return best
+}
+
+// Receive receives from the endpoint the last send went to.
+func (b *Balanced) Receive(ctx context.Context) (*protocol.Message, error) {
+	if len(b.endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	b.mu.Lock()
+	ep := b.endpoints[b.last]
+	b.mu.Unlock()
+	return ep.t.Receive(ctx)
+}
+
+// Close closes every endpoint.
+func (b *Balanced) Close() error {
+	var errs []error
+	for _, ep := range b.endpoints {
+		errs = append(errs, ep.t.Close())
+	}
+	return errors.Join(errs...)
+}
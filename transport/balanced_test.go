@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/circuitbreaker"
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+func counts(ts []*switchTransport) []int {
+	out := make([]int, len(ts))
+	for i, t := range ts {
+		out[i] = t.count()
+	}
+	return out
+}
+
+func balanced(ts []*switchTransport, s Strategy) *Balanced {
+	endpoints := make([]Transport, len(ts))
+	for i, t := range ts {
+		endpoints[i] = t
+	}
+	return NewBalanced(endpoints, s)
+}
+
+func TestBalancedRoundRobin(t *testing.T) {
+	ts := []*switchTransport{{}, {}, {}}
+	b := balanced(ts, RoundRobin)
+	for range 9 {
+		if err := b.Send(context.Background(), ping(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := counts(ts); got[0] != 3 || got[1] != 3 || got[2] != 3 {
+		t.Errorf("sends = %v, want 3 each", got)
+	}
+}
+
+func TestBalancedWeighted(t *testing.T) {
+	ts := []*switchTransport{{}, {}, {}}
+	b := balanced(ts, Weighted).WithWeights(5, 1, 0)
+	for range 14 {
+		if err := b.Send(context.Background(), ping(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := counts(ts); got[0] != 10 || got[1] != 2 || got[2] != 2 {
+		t.Errorf("sends = %v, want 10, 2, 2", got)
+	}
+}
+
+func TestBalancedSkipsFailingEndpoint(t *testing.T) {
+	ts := []*switchTransport{{}, {}}
+	down := errors.New("connection refused")
+	ts[0].setErr(down)
+	var opened int
+	b := balanced(ts, RoundRobin).WithBreaker(circuitbreaker.Config{
+		Threshold: 2,
+		Timeout:   time.Hour,
+		OnStateChange: func(from, to circuitbreaker.State) {
+			if to == circuitbreaker.Open {
+				opened++
+			}
+		},
+	})
+
+	// Every send succeeds: a failure on the first endpoint moves on.
+	for range 6 {
+		if err := b.Send(context.Background(), ping(t)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if ts[1].count() != 6 {
+		t.Errorf("healthy endpoint got %d sends, want 6", ts[1].count())
+	}
+	if opened != 1 || b.Breaker(0).State() != circuitbreaker.Open {
+		t.Errorf("breaker state = %s after %d opens", b.Breaker(0).State(), opened)
+	}
+	if _, failures := b.Breaker(0).Counts(); failures != 2 {
+		t.Errorf("failing endpoint tried %d times, want 2 before its breaker opened", failures)
+	}
+
+	ts[1].setErr(down)
+	err := b.Send(context.Background(), ping(t))
+	if !errors.Is(err, down) || misterrors.Code(err) != misterrors.CodeUnavailable {
+		t.Errorf("Send with every endpoint down = %v", err)
+	}
+}
+
+func TestBalancedLeastErrors(t *testing.T) {
+	ts := []*switchTransport{{}, {}}
+	b := balanced(ts, LeastErrors).WithBreaker(circuitbreaker.Config{Threshold: 100})
+	ts[0].setErr(errors.New("flaky"))
+	b.Send(context.Background(), ping(t)) // endpoint 0 fails once
+	ts[0].setErr(nil)
+
+	for range 4 {
+		if err := b.Send(context.Background(), ping(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := counts(ts); got[0] != 0 || got[1] != 5 {
+		t.Errorf("sends = %v, want all on the endpoint without errors", got)
+	}
+}
+
+func TestBalancedPermanentError(t *testing.T) {
+	ts := []*switchTransport{{}, {}}
+	ts[0].setErr(ErrExpired)
+	ts[1].setErr(ErrExpired)
+	b := balanced(ts, RoundRobin).WithBreaker(circuitbreaker.Config{Threshold: 1})
+	if err := b.Send(context.Background(), ping(t)); err != ErrExpired {
+		t.Errorf("Send = %v, want ErrExpired without trying the next endpoint", err)
+	}
+	if b.Breaker(0).State() != circuitbreaker.Closed {
+		t.Error("an expired message opened the breaker")
+	}
+}
@@ -0,0 +1,451 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// ErrBufferDropped is passed to BufferConfig.OnDrop for a message a
+// Buffered dropped because the buffer was full.
+var ErrBufferDropped = misterrors.New(misterrors.CodeUnavailable, "transport: buffer full, message dropped")
+
+// OverflowPolicy decides what a Buffered does with a send while its
+// buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Send wait for room, or for its ctx to end. The
+	// default.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest buffered message to make room.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the message being sent.
+	OverflowDropNewest
+
+	// OverflowSpill writes messages that don't fit to a file in
+	// BufferConfig.SpillDir, delivered in order once the buffer drains.
+	OverflowSpill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowSpill:
+		return "spill"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// BufferConfig controls a Buffered transport.
+type BufferConfig struct {
+	// Size is how many messages are held in memory (default 1024).
+	Size int
+
+	// Policy decides what happens to sends while the buffer is full.
+	Policy OverflowPolicy
+
+	// SpillDir is where OverflowSpill writes messages that don't fit
+	// (default os.TempDir()). The spill file is removed on Close.
+	SpillDir string
+
+	// OnDrop is called for each message dropped: with ErrBufferDropped
+	// by a drop policy, or with the error the destination returned, or
+	// with ErrClosed for messages still buffered at Close.
+	OnDrop func(msg *protocol.Message, err error)
+
+	// Metrics, if set, counts dropped messages by reason ("overflow",
+	// "send_failed", or "closed") in transport_buffer_dropped_total and
+	// spilled ones in transport_buffer_spilled_total, and sets
+	// transport_buffer_depth to the number of messages waiting.
+	Metrics *metrics.Registry
+}
+
+// ErrClosed is returned by sends to a Buffered after Close.
+var ErrClosed = misterrors.New(misterrors.CodeUnavailable, "transport: closed").Permanent()
+
+// Buffered is a Transport that decouples senders from a slower
+// destination. Send puts the message in a bounded in-memory buffer and
+// returns; a background loop delivers buffered messages to the
+// destination in order. While the buffer is full, the Policy decides
+// whether Send waits, a message is dropped, or the overflow spills to
+// disk:
+//
+//	buf, err := transport.NewBuffered(dst, transport.BufferConfig{
+//	    Size:   10_000,
+//	    Policy: transport.OverflowDropOldest,
+//	})
+//
+// A message the destination fails to accept is dropped; wrap the
+// destination with WithRetry, or in a DeadLetter, to keep it. Use an
+// Outbox instead when messages must survive a restart. Receive reads
+// from the destination.
+type Buffered struct {
+	inner Transport
+	cfg   BufferConfig
+	spill *spillQueue // nil unless cfg.Policy is OverflowSpill
+
+	mu      sync.Mutex
+	ring    []*protocol.Message
+	head, n int
+	sending bool          // the loop holds a message taken from the buffer
+	ready   chan struct{} // has a value when messages are waiting
+	room    chan struct{} // closed when room is made, then replaced
+	idle    chan struct{} // closed when nothing is waiting or sending
+	closed  bool
+
+	dropped atomic.Int64
+	depth   *metrics.Gauge
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBuffered wraps t in a buffer and starts delivering to it. Close
+// stops delivery and closes t.
+func NewBuffered(t Transport, cfg BufferConfig) (*Buffered, error) {
+	if cfg.Size <= 0 {
+		cfg.Size = 1024
+	}
+	b := &Buffered{
+		inner: t,
+		cfg:   cfg,
+		ring:  make([]*protocol.Message, cfg.Size),
+		ready: make(chan struct{}, 1),
+		room:  make(chan struct{}),
+		idle:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	close(b.idle)
+	if cfg.Policy == OverflowSpill {
+		var err error
+		if b.spill, err = newSpillQueue(cfg.SpillDir); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Metrics != nil {
+		b.depth = cfg.Metrics.Gauge("transport_buffer_depth")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.forward(ctx)
+	return b, nil
+}
+
+// Send buffers msg for delivery, applying the overflow policy if the
+// buffer is full. Only OverflowBlock makes it wait, and it returns ctx's
+// error if ctx ends first; a spill that fails to write is returned too.
+func (b *Buffered) Send(ctx context.Context, msg *protocol.Message) error {
+	b.mu.Lock()
+	for {
+		if b.closed {
+			b.mu.Unlock()
+			return ErrClosed
+		}
+		if b.n < len(b.ring) && (b.spill == nil || b.spill.len() == 0) {
+			break
+		}
+		switch b.cfg.Policy {
+		case OverflowDropOldest:
+			oldest := b.ring[b.head]
+			b.ring[b.head] = nil
+			b.head = (b.head + 1) % len(b.ring)
+			b.n--
+			b.mu.Unlock()
+			b.drop(oldest, "overflow", ErrBufferDropped)
+			b.mu.Lock()
+			continue
+		case OverflowDropNewest:
+			b.mu.Unlock()
+			b.drop(msg, "overflow", ErrBufferDropped)
+			return nil
+		case OverflowSpill:
+			err := b.spill.push(msg)
+			if err == nil {
+				b.wake()
+				if b.cfg.Metrics != nil {
+					b.cfg.Metrics.Counter("transport_buffer_spilled_total").Inc()
+				}
+			}
+			b.mu.Unlock()
+			return err
+		}
+
+		room := b.room
+		b.mu.Unlock()
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		b.mu.Lock()
+	}
+
+	b.ring[(b.head+b.n)%len(b.ring)] = msg
+	b.n++
+	b.wake()
+	b.mu.Unlock()
+	return nil
+}
+
+// wake signals the loop that messages are waiting. Must be called with
+// mu held.
+func (b *Buffered) wake() {
+	select {
+	case <-b.idle:
+		b.idle = make(chan struct{})
+	default:
+	}
+	if b.depth != nil {
+		b.depth.Set(float64(b.waiting()))
+	}
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// waiting returns the number of messages buffered or spilled. Must be
+// called with mu held.
+func (b *Buffered) waiting() int {
+	n := b.n
+	if b.spill != nil {
+		n += b.spill.len()
+	}
+	return n
+}
+
+// Receive receives from the destination.
+func (b *Buffered) Receive(ctx context.Context) (*protocol.Message, error) {
+	return b.inner.Receive(ctx)
+}
+
+// Len returns the number of messages waiting to be delivered, in memory
+// and spilled.
+func (b *Buffered) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waiting()
+}
+
+// Dropped returns the number of messages dropped so far.
+func (b *Buffered) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+// Flush waits until every message sent so far has been delivered or
+// dropped, or ctx is done.
+func (b *Buffered) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	idle := b.idle
+	b.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops delivery, drops any messages still waiting, and closes
+// the destination. Call Flush first to deliver them.
+func (b *Buffered) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.room) // wake blocked senders to see closed
+	b.room = make(chan struct{})
+	b.mu.Unlock()
+
+	b.cancel()
+	<-b.done
+
+	var errs []error
+	for {
+		msg, err := b.next()
+		if msg == nil && err == nil {
+			break
+		}
+		if msg != nil {
+			b.drop(msg, "closed", ErrClosed)
+		}
+	}
+	if b.spill != nil {
+		errs = append(errs, b.spill.close())
+	}
+	errs = append(errs, b.inner.Close())
+	return errors.Join(errs...)
+}
+
+// forward delivers waiting messages until ctx is done.
+func (b *Buffered) forward(ctx context.Context) {
+	defer close(b.done)
+	for {
+		msg, err := b.next()
+		switch {
+		case err != nil:
+			// An unreadable spilled message; the rest may be fine.
+			b.drop(nil, "send_failed", err)
+		case msg == nil:
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.ready:
+			}
+			continue
+		default:
+			if err := b.inner.Send(ctx, msg); err != nil {
+				if ctx.Err() != nil {
+					b.drop(msg, "closed", ErrClosed)
+					b.settled()
+					return
+				}
+				b.drop(msg, "send_failed", err)
+			}
+		}
+		b.settled()
+	}
+}
+
+// next takes the oldest waiting message, or returns nil if there is
+// none. Spilled messages follow the in-memory ones.
+func (b *Buffered) next() (*protocol.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var msg *protocol.Message
+	var err error
+	switch {
+	case b.n > 0:
+		msg = b.ring[b.head]
+		b.ring[b.head] = nil
+		b.head = (b.head + 1) % len(b.ring)
+		b.n--
+		close(b.room)
+		b.room = make(chan struct{})
+	case b.spill != nil && b.spill.len() > 0:
+		msg, err = b.spill.pop()
+	default:
+		return nil, nil
+	}
+	b.sending = true
+	if b.depth != nil {
+		b.depth.Set(float64(b.waiting()))
+	}
+	return msg, err
+}
+
+// settled records that the loop is done with the message from next.
+func (b *Buffered) settled() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sending = false
+	if b.waiting() == 0 {
+		select {
+		case <-b.idle:
+		default:
+			close(b.idle)
+		}
+	}
+}
+
+func (b *Buffered) drop(msg *protocol.Message, reason string, err error) {
+	b.dropped.Add(1)
+	if b.cfg.Metrics != nil {
+		b.cfg.Metrics.Counter("transport_buffer_dropped_total", "reason", reason).Inc()
+	}
+	if b.cfg.OnDrop != nil {
+		b.cfg.OnDrop(msg, err)
+	}
+}
+
+// spillQueue is a FIFO of messages in a temporary file: appended at the
+// end, read from the front, and truncated whenever it empties.
+type spillQueue struct {
+	w  *os.File
+	r  *os.File
+	br *bufio.Reader
+	n  int
+}
+
+func newSpillQueue(dir string) (*spillQueue, error) {
+	w, err := os.CreateTemp(dir, "mist-spill-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("buffered transport: spill: %w", err)
+	}
+	r, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return nil, fmt.Errorf("buffered transport: spill: %w", err)
+	}
+	return &spillQueue{w: w, r: r, br: bufio.NewReader(r)}, nil
+}
+
+func (q *spillQueue) len() int { return q.n }
+
+func (q *spillQueue) push(msg *protocol.Message) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("buffered transport: spill: marshal: %w", err)
+	}
+	if _, err := q.w.Write(append(data, '\n')); err != nil {
+		return misterrors.Wrap(misterrors.CodeInternal, err, "buffered transport: spill")
+	}
+	q.n++
+	return nil
+}
+
+func (q *spillQueue) pop() (*protocol.Message, error) {
+	line, err := q.br.ReadBytes('\n')
+	q.n--
+	if q.n == 0 {
+		q.reset()
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("buffered transport: spill: %w", err)
+	}
+	msg, err := protocol.Unmarshal(bytes.TrimSuffix(line, []byte{'\n'}))
+	if err != nil {
+		return nil, fmt.Errorf("buffered transport: spill: %w", err)
+	}
+	return msg, nil
+}
+
+// reset empties the file once everything in it has been read.
+func (q *spillQueue) reset() {
+	if q.w.Truncate(0) == nil {
+		q.w.Seek(0, io.SeekStart)
+		q.r.Seek(0, io.SeekStart)
+		q.br.Reset(q.r)
+	}
+}
+
+func (q *spillQueue) close() error {
+	q.r.Close()
+	err := q.w.Close()
+	if rerr := os.Remove(q.w.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
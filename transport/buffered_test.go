@@ -0,0 +1,184 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// gatedTransport holds every send until open is closed.
+type gatedTransport struct {
+	*Channel
+	open chan struct{}
+}
+
+func newGated() *gatedTransport {
+	return &gatedTransport{Channel: NewChannel(100), open: make(chan struct{})}
+}
+
+func (g *gatedTransport) Send(ctx context.Context, msg *protocol.Message) error {
+	select {
+	case <-g.open:
+		return g.Channel.Send(ctx, msg)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fillBuffered sends n messages to b once the first is in flight to its
+// gated destination.
+func fillBuffered(t *testing.T, b *Buffered, n int) []*protocol.Message {
+	t.Helper()
+	msgs := make([]*protocol.Message, n)
+	for i := range msgs {
+		msgs[i] = ping(t)
+		if err := b.Send(context.Background(), msgs[i]); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+		for i == 0 && b.Len() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return msgs
+}
+
+// openAndDrain opens dst, flushes b, and returns the IDs dst received.
+func openAndDrain(t *testing.T, b *Buffered, dst *gatedTransport) []string {
+	t.Helper()
+	select {
+	case <-dst.open:
+	default:
+		close(dst.open)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	dst.Channel.Close()
+	var ids []string
+	for msg, err := range Messages(ctx, dst.Channel) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func ids(msgs ...*protocol.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.ID
+	}
+	return out
+}
+
+func TestBufferedBlock(t *testing.T) {
+	dst := newGated()
+	b, _ := NewBuffered(dst, BufferConfig{Size: 2})
+	defer b.Close()
+	msgs := fillBuffered(t, b, 3)
+
+	extra := ping(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Send(ctx, extra); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Send to a full buffer = %v, want it to block until the deadline", err)
+	}
+
+	sent := make(chan error)
+	go func() { sent <- b.Send(context.Background(), extra) }()
+	close(dst.open)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	got := openAndDrain(t, b, dst)
+	if want := ids(append(msgs, extra)...); !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestBufferedDropPolicies(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropOldest, OverflowDropNewest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			dst := newGated()
+			reg := metrics.NewRegistry()
+			var dropped []*protocol.Message
+			b, _ := NewBuffered(dst, BufferConfig{Size: 2, Policy: policy, Metrics: reg,
+				OnDrop: func(msg *protocol.Message, err error) {
+					if !errors.Is(err, ErrBufferDropped) {
+						t.Errorf("OnDrop err = %v", err)
+					}
+					dropped = append(dropped, msg)
+				}})
+			defer b.Close()
+			msgs := fillBuffered(t, b, 4)
+
+			want := []*protocol.Message{msgs[0], msgs[2], msgs[3]}
+			lost := msgs[1]
+			if policy == OverflowDropNewest {
+				want, lost = msgs[:3], msgs[3]
+			}
+			if got := openAndDrain(t, b, dst); !slices.Equal(got, ids(want...)) {
+				t.Errorf("delivered %v, want %v", got, ids(want...))
+			}
+			if len(dropped) != 1 || dropped[0] != lost || b.Dropped() != 1 {
+				t.Errorf("dropped %v (%d), want %s", ids(dropped...), b.Dropped(), lost.ID)
+			}
+			if n := reg.Counter("transport_buffer_dropped_total", "reason", "overflow").Value(); n != 1 {
+				t.Errorf("transport_buffer_dropped_total = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestBufferedSpill(t *testing.T) {
+	dir := t.TempDir()
+	dst := newGated()
+	reg := metrics.NewRegistry()
+	b, err := NewBuffered(dst, BufferConfig{Size: 1, Policy: OverflowSpill, SpillDir: dir, Metrics: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := fillBuffered(t, b, 5)
+	if b.Len() != 4 {
+		t.Errorf("Len = %d, want 4 waiting", b.Len())
+	}
+	if n := reg.Counter("transport_buffer_spilled_total").Value(); n != 3 {
+		t.Errorf("spilled %d, want 3", n)
+	}
+	if got := openAndDrain(t, b, dst); !slices.Equal(got, ids(msgs...)) {
+		t.Errorf("delivered %v, want %v in order", got, ids(msgs...))
+	}
+
+	// The spill file empties once read and is removed on Close.
+	dst.Channel = NewChannel(10)
+	b.Send(context.Background(), ping(t))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spill dir holds %d files after Close", len(entries))
+	}
+}
+
+func TestBufferedClose(t *testing.T) {
+	dst := newGated()
+	var dropped int
+	b, _ := NewBuffered(dst, BufferConfig{Size: 4, OnDrop: func(*protocol.Message, error) { dropped++ }})
+	fillBuffered(t, b, 3)
+	b.Close()
+	if dropped != 3 {
+		t.Errorf("dropped %d at Close, want the 3 undelivered", dropped)
+	}
+	if err := b.Send(context.Background(), ping(t)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send after Close = %v, want ErrClosed", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
 )
 
@@ -15,10 +16,16 @@ import (
 //
 //	a, b := NewChannelPair(256)
 //	// tool A sends on 'a', tool B receives on 'b' and vice versa
+//
+// A Channel is an AckReceiver: a delivery that is nacked is received
+// again, ahead of messages still in the channel. Acks only last as long
+// as the process.
 type Channel struct {
-	send chan *protocol.Message
-	recv chan *protocol.Message
-	once sync.Once
+	send    chan *protocol.Message
+	recv    chan *protocol.Message
+	once    sync.Once
+	size    sizeLimit
+	requeue requeue
 }
 
 // NewChannel creates a unidirectional channel transport. Messages sent
@@ -38,8 +45,24 @@ func NewChannelPair(bufSize int) (*Channel, *Channel) {
 	return a, b
 }
 
+// SetMaxMessageSize limits the encoded size of messages sent. Channels
+// are unlimited by default; a limit costs an encode per Send. Call it
+// before the first Send.
+func (c *Channel) SetMaxMessageSize(n int, reg *metrics.Registry) {
+	c.size = sizeLimit{max: n, metrics: reg}
+}
+
 // Send puts a message on the channel.
 func (c *Channel) Send(ctx context.Context, msg *protocol.Message) error {
+	if c.size.max > 0 {
+		data, err := msg.Marshal()
+		if err != nil {
+			return fmt.Errorf("channel transport: marshal: %w", err)
+		}
+		if err := c.size.check("channel", "send", len(data), msg.Type); err != nil {
+			return err
+		}
+	}
 	select {
 	case c.send <- msg:
 		return nil
@@ -50,13 +73,42 @@ func (c *Channel) Send(ctx context.Context, msg *protocol.Message) error {
 	}
 }
 
-// Receive reads the next message from the channel.
+// Receive reads the next message from the channel, starting with any
+// nacked deliveries.
 func (c *Channel) Receive(ctx context.Context) (*protocol.Message, error) {
-	select {
-	case msg := <-c.recv:
-		return msg, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	r, err := c.next(ctx)
+	return r.msg, err
+}
+
+// ReceiveDelivery reads the next message for at-least-once processing.
+// Nacking the delivery puts the message back on this Channel.
+func (c *Channel) ReceiveDelivery(ctx context.Context) (*Delivery, error) {
+	r, err := c.next(ctx)
+	if err != nil || r.msg == nil {
+		return nil, err
+	}
+	return &Delivery{Message: r.msg, Attempt: r.attempt, settle: func(ack bool) error {
+		if !ack {
+			c.requeue.push(redelivery{msg: r.msg, attempt: r.attempt + 1})
+		}
+		return nil
+	}}, nil
+}
+
+// next returns the next nacked message, or else the next message from
+// the channel. A zero msg means the channel is closed.
+func (c *Channel) next(ctx context.Context) (redelivery, error) {
+	for {
+		if r, ok := c.requeue.pop(); ok {
+			return r, nil
+		}
+		select {
+		case msg := <-c.recv:
+			return redelivery{msg: msg, attempt: 1}, nil
+		case <-c.requeue.waiting():
+		case <-ctx.Done():
+			return redelivery{}, ctx.Err()
+		}
 	}
 }
 
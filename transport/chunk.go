@@ -0,0 +1,277 @@
+package transport
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// maxChunks bounds the chunks one message may be split into, so a bogus
+// count can't make the receiver allocate a huge index.
+const maxChunks = 1 << 16
+
+// ChunkPolicy configures WithChunking.
+type ChunkPolicy struct {
+	// Size is the largest encoded chunk message sent. Zero means the
+	// WithMaxMessageSize limit if set, else DefaultMaxMessageSize, so
+	// chunks fit the receiving transport's default limit.
+	Size int
+
+	// MaxMessageSize is the largest message reassembled from chunks.
+	// Larger messages are refused on receive, from their first chunk,
+	// with ErrMessageTooLarge. Zero, or anything larger, means
+	// protocol.MaxMessageSize.
+	MaxMessageSize int
+
+	// MaxBuffered bounds the bytes held for incomplete messages. When a
+	// chunk would exceed it the oldest incomplete messages are dropped.
+	// Zero means twice MaxMessageSize.
+	MaxBuffered int
+
+	// Timeout drops incomplete messages whose first chunk arrived this
+	// long ago. Zero means one minute.
+	Timeout time.Duration
+
+	// Metrics, if set, counts dropped incomplete messages in
+	// transport_chunks_dropped_total{reason} and refused ones in
+	// transport_oversize_total{transport="chunk"}.
+	Metrics *metrics.Registry
+}
+
+// WithChunking splits outgoing messages that encode to more than
+// ChunkPolicy.Size into transport.chunk messages, and reassembles them on
+// receive, so payloads larger than a transport's limit still get through
+// while no receiver buffers more than the policy allows. Both ends must
+// use it. Chunks of a message are sent in one batch; with retry the batch
+// is resent whole and the receiver ignores chunks it already has.
+func WithChunking(p ChunkPolicy) MiddlewareOption {
+	return func(m *Middleware) { m.chunks = newChunker(p) }
+}
+
+// chunker splits and reassembles messages for WithChunking.
+type chunker struct {
+	size      int
+	max       int
+	buffered  int
+	timeout   time.Duration
+	metrics   *metrics.Registry
+	limit     sizeLimit
+	logger    *slog.Logger
+	mu        sync.Mutex
+	pending   map[string]*partialMessage
+	order     []string // pending message IDs, oldest first
+	heldBytes int
+
+	// done remembers messages reassembled within the timeout, so chunks
+	// resent after a retry don't start them over.
+	done      map[string]bool
+	doneOrder []completedMessage
+}
+
+type completedMessage struct {
+	id string
+	at time.Time
+}
+
+type partialMessage struct {
+	parts    [][]byte
+	received int
+	bytes    int
+	size     int
+	started  time.Time
+}
+
+func newChunker(p ChunkPolicy) *chunker {
+	c := &chunker{
+		size:     p.Size,
+		max:      p.MaxMessageSize,
+		buffered: p.MaxBuffered,
+		timeout:  p.Timeout,
+		metrics:  p.Metrics,
+		pending:  make(map[string]*partialMessage),
+		done:     make(map[string]bool),
+	}
+	if c.max <= 0 || c.max > protocol.MaxMessageSize {
+		c.max = protocol.MaxMessageSize
+	}
+	if c.buffered <= 0 {
+		c.buffered = 2 * c.max
+	}
+	if c.timeout <= 0 {
+		c.timeout = time.Minute
+	}
+	c.limit = sizeLimit{max: c.max, metrics: p.Metrics}
+	return c
+}
+
+// split returns the chunks of msg, or nil if it fits in one message.
+func (c *chunker) split(msg *protocol.Message) ([]*protocol.Message, error) {
+	data, err := msg.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= c.size {
+		return nil, nil
+	}
+	if err := c.limit.check("chunk", "send", len(data), msg.Type); err != nil {
+		return nil, err
+	}
+
+	// Measure the envelope with the widest index and count this message
+	// could need, then fill the rest of each chunk with base64 data.
+	probe, err := c.chunk(msg, protocol.MessageChunk{MessageID: msg.ID, Index: len(data), Count: len(data), Size: len(data), Data: []byte{}})
+	if err != nil {
+		return nil, err
+	}
+	overhead, err := probe.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	per := (c.size - len(overhead)) / 4 * 3
+	if per <= 0 {
+		return nil, misterrors.Newf(misterrors.CodeValidation, "transport: chunk size %d is too small for the chunk envelope", c.size).Permanent()
+	}
+
+	count := (len(data) + per - 1) / per
+	if count > maxChunks {
+		return nil, misterrors.Newf(misterrors.CodeValidation, "transport: chunk size %d would split %d bytes into more than %d chunks", c.size, len(data), maxChunks).Permanent()
+	}
+	chunks := make([]*protocol.Message, 0, count)
+	for i := range count {
+		part := data[i*per : min((i+1)*per, len(data))]
+		chunk, err := c.chunk(msg, protocol.MessageChunk{MessageID: msg.ID, Index: i, Count: count, Size: len(data), Data: part})
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// chunk wraps part in a message that expires with msg.
+func (c *chunker) chunk(msg *protocol.Message, part protocol.MessageChunk) (*protocol.Message, error) {
+	chunk, err := protocol.New(msg.Source, protocol.TypeTransportChunk, part)
+	if err != nil {
+		return nil, err
+	}
+	chunk.TimestampNS = msg.TimestampNS
+	chunk.DeadlineNS = msg.DeadlineNS
+	chunk.TTLNS = msg.TTLNS
+	return chunk, nil
+}
+
+// add records a received chunk and returns the reassembled message once
+// every chunk of it has arrived, or nil until then.
+func (c *chunker) add(msg *protocol.Message, now time.Time) (*protocol.Message, error) {
+	var part protocol.MessageChunk
+	if err := msg.Decode(&part); err != nil {
+		return nil, misterrors.Wrap(misterrors.CodeValidation, err, "transport: invalid chunk")
+	}
+	if part.MessageID == "" || part.Count <= 0 || part.Index < 0 || part.Index >= part.Count || part.Count > min(part.Size, maxChunks) {
+		return nil, misterrors.Newf(misterrors.CodeValidation, "transport: invalid chunk %d of %d for %q", part.Index, part.Count, part.MessageID)
+	}
+	if err := c.limit.check("chunk", "receive", part.Size, ""); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+	if c.done[part.MessageID] {
+		return nil, nil
+	}
+	p, ok := c.pending[part.MessageID]
+	if !ok {
+		p = &partialMessage{parts: make([][]byte, part.Count), size: part.Size, started: now}
+		c.pending[part.MessageID] = p
+		c.order = append(c.order, part.MessageID)
+	}
+	if part.Count != len(p.parts) || part.Size != p.size {
+		c.drop(part.MessageID, "invalid")
+		return nil, misterrors.Newf(misterrors.CodeValidation, "transport: chunks of %q disagree on their count or size", part.MessageID)
+	}
+	if p.parts[part.Index] != nil {
+		return nil, nil // a resent chunk
+	}
+	if p.bytes+len(part.Data) > p.size {
+		c.drop(part.MessageID, "invalid")
+		return nil, misterrors.Newf(misterrors.CodeValidation, "transport: chunks of %q exceed their declared %d bytes", part.MessageID, p.size)
+	}
+
+	for c.heldBytes+len(part.Data) > c.buffered && c.order[0] != part.MessageID {
+		c.drop(c.order[0], "buffer_full")
+	}
+	p.parts[part.Index] = part.Data
+	p.received++
+	p.bytes += len(part.Data)
+	c.heldBytes += len(part.Data)
+	if p.received < len(p.parts) {
+		return nil, nil
+	}
+
+	data := make([]byte, 0, p.bytes)
+	for _, b := range p.parts {
+		data = append(data, b...)
+	}
+	c.remove(part.MessageID)
+	c.done[part.MessageID] = true
+	c.doneOrder = append(c.doneOrder, completedMessage{part.MessageID, now})
+	if len(data) != p.size {
+		return nil, misterrors.Newf(misterrors.CodeValidation, "transport: chunks of %q total %d bytes, want %d", part.MessageID, len(data), p.size)
+	}
+	full, err := protocol.Unmarshal(data)
+	if err != nil {
+		return nil, misterrors.Wrap(misterrors.CodeValidation, err, "transport: reassemble chunks")
+	}
+	return full, nil
+}
+
+// expire drops incomplete messages started before the timeout, and
+// forgets messages completed before it. The caller holds c.mu.
+func (c *chunker) expire(now time.Time) {
+	for len(c.order) > 0 && now.Sub(c.pending[c.order[0]].started) >= c.timeout {
+		c.drop(c.order[0], "timeout")
+	}
+	n := 0
+	for n < len(c.doneOrder) && now.Sub(c.doneOrder[n].at) >= c.timeout {
+		delete(c.done, c.doneOrder[n].id)
+		n++
+	}
+	c.doneOrder = c.doneOrder[n:]
+}
+
+// drop discards an incomplete message. The caller holds c.mu.
+func (c *chunker) drop(id, reason string) {
+	p := c.pending[id]
+	c.remove(id)
+	if c.logger != nil {
+		c.logger.Warn("dropped incomplete chunked message",
+			"msg_id", id,
+			"received", p.received,
+			"count", len(p.parts),
+			"reason", reason,
+		)
+	}
+	if c.metrics != nil {
+		c.metrics.Counter("transport_chunks_dropped_total", "reason", reason).Inc()
+	}
+}
+
+// remove forgets a pending message. The caller holds c.mu.
+func (c *chunker) remove(id string) {
+	if p, ok := c.pending[id]; ok {
+		c.heldBytes -= p.bytes
+		delete(c.pending, id)
+	}
+	for i, pid := range c.order {
+		if pid == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
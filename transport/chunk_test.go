@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestMiddlewareChunking(t *testing.T) {
+	ch := NewChannel(64)
+	m := Wrap(ch, WithMaxMessageSize(1024), WithChunking(ChunkPolicy{}))
+	ctx := context.Background()
+
+	big := sizedMessage(t, 10*1024)
+	big.SetTTL(time.Minute)
+	if err := m.Send(ctx, big); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ch.recv); n < 10 {
+		t.Fatalf("sent %d chunks, want at least 10", n)
+	}
+	small := sizedMessage(t, 10)
+	if err := m.Send(ctx, small); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != big.ID || string(got.Payload) != string(big.Payload) || got.TTLNS != big.TTLNS {
+		t.Errorf("reassembled %s (%d bytes), want %s", got.ID, len(got.Payload), big.ID)
+	}
+	if got, err := m.Receive(ctx); err != nil || got.ID != small.ID {
+		t.Errorf("Receive = %v, %v; want the small message", got, err)
+	}
+}
+
+func TestMiddlewareChunkingBatch(t *testing.T) {
+	ch := NewChannel(64)
+	m := Wrap(ch, WithChunking(ChunkPolicy{Size: 1024}))
+	ctx := context.Background()
+
+	msgs := []*protocol.Message{sizedMessage(t, 10), sizedMessage(t, 4096), sizedMessage(t, 20)}
+	if err := m.SendBatch(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range msgs {
+		got, err := m.Receive(ctx)
+		if err != nil || got.ID != want.ID {
+			t.Fatalf("Receive = %v, %v; want %s", got, err, want.ID)
+		}
+	}
+}
+
+func TestChunkerOutOfOrder(t *testing.T) {
+	c := newChunker(ChunkPolicy{})
+	c.size = 512
+	msg := sizedMessage(t, 2048)
+	chunks, err := c.split(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		data, _ := chunk.Marshal()
+		if len(data) > 512 {
+			t.Fatalf("chunk is %d bytes, want at most 512", len(data))
+		}
+	}
+
+	now := time.Now()
+	var full *protocol.Message
+	for i := len(chunks) - 1; i >= 0; i-- {
+		if full != nil {
+			t.Fatal("reassembled before the last chunk")
+		}
+		// Resent chunks are ignored.
+		for range 2 {
+			got, err := c.add(chunks[i], now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != nil {
+				full = got
+			}
+		}
+	}
+	if full == nil || full.ID != msg.ID {
+		t.Fatalf("reassembled %v, want %s", full, msg.ID)
+	}
+	if len(c.pending) != 0 || c.heldBytes != 0 {
+		t.Errorf("%d pending, %d bytes held after reassembly", len(c.pending), c.heldBytes)
+	}
+}
+
+func TestChunkerLimits(t *testing.T) {
+	reg := metrics.NewRegistry()
+	sender := newChunker(ChunkPolicy{})
+	sender.size = 512
+	chunks, err := sender.split(sizedMessage(t, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A message over the receiver's limit is refused from its first chunk.
+	small := newChunker(ChunkPolicy{MaxMessageSize: 1024, Metrics: reg})
+	if _, err := small.add(chunks[0], time.Now()); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("oversized: err = %v, want ErrMessageTooLarge", err)
+	}
+	if len(small.pending) != 0 {
+		t.Error("oversized message was buffered")
+	}
+
+	// Incomplete messages time out.
+	c := newChunker(ChunkPolicy{Timeout: time.Second, Metrics: reg})
+	now := time.Now()
+	c.add(chunks[0], now)
+	other, _ := sender.split(sizedMessage(t, 4096))
+	c.add(other[0], now.Add(2*time.Second))
+	if _, ok := c.pending[chunks[0].ID]; ok || len(c.pending) != 1 {
+		t.Errorf("pending = %d, want only the newer message", len(c.pending))
+	}
+	if n := reg.Counter("transport_chunks_dropped_total", "reason", "timeout").Value(); n != 1 {
+		t.Errorf("timeout drops = %d, want 1", n)
+	}
+
+	// The oldest incomplete message is dropped when the buffer is full.
+	c = newChunker(ChunkPolicy{MaxBuffered: 600, Metrics: reg})
+	c.add(chunks[0], now)
+	c.add(chunks[1], now)
+	c.add(other[0], now)
+	if len(c.pending) != 1 || c.heldBytes > 600 {
+		t.Errorf("pending = %d holding %d bytes, want 1 under 600", len(c.pending), c.heldBytes)
+	}
+
+	// A bogus count is rejected before anything is allocated.
+	bogus, _ := protocol.New("test", protocol.TypeTransportChunk, protocol.MessageChunk{MessageID: "x", Count: 1 << 30, Size: 1 << 30})
+	if _, err := c.add(bogus, now); misterrors.Code(err) != misterrors.CodeValidation {
+		t.Errorf("bogus chunk: err = %v, want CodeValidation", err)
+	}
+}
@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Compressor compresses message data on the wire or on disk. Its Name is
+// the HTTP Content-Encoding token. Gzip is built in; others, such as
+// zstd, can be added with RegisterCompressor.
+type Compressor interface {
+	Name() string
+	NewWriter(w io.Writer) io.WriteCloser
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip is the built-in gzip Compressor.
+var Gzip Compressor = gzipCompressor{}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string                         { return "gzip" }
+func (gzipCompressor) NewWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{"gzip": Gzip}
+
+	// compressedExts maps file extensions to compressor names.
+	compressedExts = map[string]string{".gz": "gzip", ".zst": "zstd"}
+)
+
+// RegisterCompressor makes c available to HTTP listeners, which then
+// accept its Content-Encoding, and to file transports, which use it for
+// paths ending in ".zst" when c is named "zstd".
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[strings.ToLower(c.Name())] = c
+}
+
+// LookupCompressor returns the registered compressor with the given name.
+func LookupCompressor(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[strings.ToLower(strings.TrimSpace(name))]
+	return c, ok
+}
+
+// acceptedEncodings lists the registered compressors for Accept-Encoding.
+func acceptedEncodings() string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// compressorForPath returns the compressor implied by path's extension,
+// or nil for an uncompressed file.
+func compressorForPath(path string) (Compressor, error) {
+	name, ok := compressedExts[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil, nil
+	}
+	c, ok := LookupCompressor(name)
+	if !ok {
+		return nil, fmt.Errorf("file transport: %s compression is not registered (see RegisterCompressor)", name)
+	}
+	return c, nil
+}
+
+// compress returns data compressed with c.
+func compress(c Compressor, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metadata"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/retry"
+)
+
+// Messages returns an iterator over messages received from r:
+//
+//	for msg, err := range transport.Messages(ctx, t) {
+//	    if err != nil {
+//	        log.Warn("receive", "error", err)
+//	        continue
+//	    }
+//	    handle(msg)
+//	}
+//
+// Retryable receive errors are yielded and iteration continues; the loop
+// body decides whether to back off or stop. Iteration ends without an
+// error when ctx is done or the stream ends (io.EOF, or a closed
+// channel), and after yielding any other error.
+func Messages(ctx context.Context, r Receiver) iter.Seq2[*protocol.Message, error] {
+	return func(yield func(*protocol.Message, error) bool) {
+		for {
+			msg, err := r.Receive(ctx)
+			switch {
+			case ctx.Err() != nil, endOfStream(msg, err):
+				return
+			case err != nil:
+				if !yield(nil, err) || !misterrors.IsRetryable(err) {
+					return
+				}
+			default:
+				if !yield(msg, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// endOfStream reports whether a Receive result means no more messages
+// will arrive.
+func endOfStream(msg *protocol.Message, err error) bool {
+	return (msg == nil && err == nil) || errors.Is(err, io.EOF)
+}
+
+// MessageHandler processes one received message. ctx carries the
+// message's metadata (see metadata.Extract).
+type MessageHandler func(ctx context.Context, msg *protocol.Message) error
+
+// ConsumeOption configures Consume.
+type ConsumeOption func(*consumer)
+
+type consumer struct {
+	workers int
+	retry   retry.Policy
+	onError func(*protocol.Message, error)
+}
+
+// WithWorkers runs up to n handlers concurrently. Default 1, which
+// handles messages in order.
+func WithWorkers(n int) ConsumeOption {
+	return func(c *consumer) {
+		if n > 0 {
+			c.workers = n
+		}
+	}
+}
+
+// WithReceiveRetry sets the backoff between retryable receive errors and
+// how many may occur in a row before Consume gives up. Default 10
+// attempts from 100ms up to 5s.
+func WithReceiveRetry(p retry.Policy) ConsumeOption {
+	return func(c *consumer) { c.retry = p }
+}
+
+// WithErrorHandler is called with each message whose handler returned an
+// error or panicked. A panic is reported as an errors.CodeInternal error
+// with the stack in its "stack" meta. Default: errors are dropped.
+func WithErrorHandler(fn func(*protocol.Message, error)) ConsumeOption {
+	return func(c *consumer) { c.onError = fn }
+}
+
+// Consume receives messages from r and calls handler for each until ctx
+// is done, the stream ends, or a receive error is not retryable or
+// persists past the retry policy. It replaces hand-written
+// for/Receive loops:
+//
+//	err := transport.Consume(ctx, t, func(ctx context.Context, msg *protocol.Message) error {
+//	    return process(ctx, msg)
+//	}, transport.WithWorkers(8), transport.WithErrorHandler(logFailure))
+//
+// Handler errors and panics go to WithErrorHandler and do not stop
+// consumption. Before returning, Consume waits for running handlers to
+// finish; their ctx derives from ctx, so cancelling it asks them to stop
+// early. If r has a DrainGate (see HTTP.EnableDrain), Consume also
+// returns once a control.drain has emptied the queue. It returns nil on a
+// clean stop and the receive error otherwise.
+func Consume(ctx context.Context, r Receiver, handler MessageHandler, opts ...ConsumeOption) error {
+	c := &consumer{
+		workers: 1,
+		retry:   retry.Policy{MaxAttempts: 10, InitialWait: 100 * time.Millisecond, MaxWait: 5 * time.Second, Multiplier: 2},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	recvCtx := ctx
+	if d, ok := r.(interface{ DrainGate() *DrainGate }); ok {
+		if gate := d.DrainGate(); gate != nil {
+			var cancel context.CancelFunc
+			recvCtx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				select {
+				case <-gate.Done():
+					cancel()
+				case <-recvCtx.Done():
+				}
+			}()
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sem := make(chan struct{}, c.workers)
+
+	failures := 0
+	wait := c.retry.InitialWait
+	for {
+		msg, err := r.Receive(recvCtx)
+		switch {
+		case recvCtx.Err() != nil, endOfStream(msg, err):
+			return nil
+		case err != nil:
+			failures++
+			if !misterrors.IsRetryable(err) || failures >= c.retry.MaxAttempts {
+				return fmt.Errorf("transport: consume: %w", err)
+			}
+			select {
+			case <-time.After(wait):
+			case <-recvCtx.Done():
+				return nil
+			}
+			wait = time.Duration(float64(wait) * c.retry.Multiplier)
+			if c.retry.MaxWait > 0 && wait > c.retry.MaxWait {
+				wait = c.retry.MaxWait
+			}
+			continue
+		}
+		failures, wait = 0, c.retry.InitialWait
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := c.handle(metadata.Extract(ctx, msg), handler, msg); err != nil && c.onError != nil {
+				c.onError(msg, err)
+			}
+		}()
+	}
+}
+
+// handle runs handler, converting a panic into an error.
+func (c *consumer) handle(ctx context.Context, handler MessageHandler, msg *protocol.Message) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = misterrors.Recovered(p).WithMeta("message_type", msg.Type).WithMeta("message_id", msg.ID)
+		}
+	}()
+	return handler(ctx, msg)
+}
@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metadata"
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/retry"
+)
+
+// scriptedReceiver returns its results in order, then blocks until ctx
+// is done.
+type scriptedReceiver struct {
+	mu      sync.Mutex
+	results []error // nil yields a message
+	n       int
+}
+
+func (s *scriptedReceiver) Receive(ctx context.Context) (*protocol.Message, error) {
+	s.mu.Lock()
+	if s.n < len(s.results) {
+		err := s.results[s.n]
+		s.n++
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		msg, _ := protocol.New("test", protocol.TypeHealthPing, nil)
+		return msg, nil
+	}
+	s.mu.Unlock()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestMessagesIterator(t *testing.T) {
+	ch := NewChannel(4)
+	for i := 0; i < 3; i++ {
+		ch.Send(context.Background(), sizedMessage(t, 1))
+	}
+	ch.Close()
+
+	n := 0
+	for msg, err := range Messages(context.Background(), ch) {
+		if err != nil || msg == nil {
+			t.Fatalf("msg = %v, err = %v", msg, err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("got %d messages, want 3", n)
+	}
+
+	transient := misterrors.New(misterrors.CodeTransport, "blip")
+	fatal := misterrors.New(misterrors.CodeValidation, "bad")
+	r := &scriptedReceiver{results: []error{nil, transient, nil, fatal, nil}}
+	var got []string
+	for msg, err := range Messages(context.Background(), r) {
+		if err != nil {
+			got = append(got, misterrors.Code(err))
+		} else if msg != nil {
+			got = append(got, "msg")
+		}
+	}
+	if want := "msg transport msg validation"; strings.Join(got, " ") != want {
+		t.Errorf("sequence = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	ch := NewChannel(8)
+	for _, tenant := range []string{"a", "b", "panic", "c"} {
+		msg := sizedMessage(t, 1)
+		msg.Meta = map[string]string{metadata.KeyTenant: tenant}
+		ch.Send(context.Background(), msg)
+	}
+	ch.Close()
+
+	var mu sync.Mutex
+	var seen []string
+	var failures []error
+	err := Consume(context.Background(), ch, func(ctx context.Context, msg *protocol.Message) error {
+		tenant := metadata.Get(ctx, metadata.KeyTenant)
+		if tenant == "panic" {
+			panic("boom")
+		}
+		mu.Lock()
+		seen = append(seen, tenant)
+		mu.Unlock()
+		if tenant == "b" {
+			return errors.New("handler failed")
+		}
+		return nil
+	}, WithErrorHandler(func(_ *protocol.Message, err error) {
+		mu.Lock()
+		failures = append(failures, err)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(seen, " ") != "a b c" {
+		t.Errorf("handled %v", seen)
+	}
+	if len(failures) != 2 || !misterrors.IsPanic(failures[1]) || misterrors.IsRetryable(failures[1]) {
+		t.Errorf("failures = %v", failures)
+	}
+}
+
+func TestConsumeReceiveErrors(t *testing.T) {
+	fast := retry.Policy{MaxAttempts: 3, InitialWait: time.Millisecond, Multiplier: 1}
+	transient := misterrors.New(misterrors.CodeTransport, "blip")
+
+	// Transient errors are retried, and a success resets the count.
+	r := &scriptedReceiver{results: []error{transient, transient, nil, transient, transient, nil}}
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan struct{}, 2)
+	done := make(chan error)
+	go func() {
+		done <- Consume(ctx, r, func(context.Context, *protocol.Message) error {
+			handled <- struct{}{}
+			return nil
+		}, WithReceiveRetry(fast), WithWorkers(2))
+	}()
+	<-handled
+	<-handled
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("cancelled Consume = %v", err)
+	}
+
+	r = &scriptedReceiver{results: []error{transient, transient, transient}}
+	if err := Consume(context.Background(), r, nil, WithReceiveRetry(fast)); !misterrors.Is(err, transient) {
+		t.Errorf("persistent errors: %v", err)
+	}
+	r = &scriptedReceiver{results: []error{misterrors.New(misterrors.CodeAuth, "denied")}}
+	if err := Consume(context.Background(), r, nil, WithReceiveRetry(fast)); misterrors.Code(err) != misterrors.CodeAuth {
+		t.Errorf("permanent error: %v", err)
+	}
+}
+
+func TestConsumeStopsWhenDrained(t *testing.T) {
+	h := NewHTTP("")
+	gate := h.EnableDrain()
+	msg := sizedMessage(t, 1)
+	h.inbox <- redelivery{msg: msg, attempt: 1, release: func() {}}
+	h.unsettled.Add(1)
+
+	done := make(chan error)
+	go func() {
+		done <- Consume(context.Background(), h, func(context.Context, *protocol.Message) error { return nil })
+	}()
+	time.Sleep(10 * time.Millisecond)
+	gate.Drain()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Consume did not stop after drain")
+	}
+}
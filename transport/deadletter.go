@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Meta keys a DeadLetter stamps on each message it diverts.
+const (
+	MetaDeadLetterError = "dead_letter_error" // why the send failed
+	MetaDeadLetterDest  = "dead_letter_dest"  // the wrapper's name for the destination
+)
+
+// DeadLetter wraps a transport so messages it fails to send are written
+// to a dead-letter sender, typically a File, instead of being lost or
+// stopping the caller. Send then reports success: the message is safe,
+// just not delivered. Replay the dead letters later by relaying the file
+// to the destination:
+//
+//	dlq, _ := transport.NewFile("failed.jsonl")
+//	dst := transport.NewDeadLetter(out, dlq, "tokentrace")
+//	...
+//	// mist relay file://failed.jsonl http://tokentrace:8700
+//
+// DeadLetter doesn't retry: the first failed send is diverted. Wrap t
+// with WithRetry, as mist relay does, so a transient failure is retried
+// before it is dead-lettered:
+//
+//	dst := transport.NewDeadLetter(transport.Wrap(out, transport.WithRetry(policy)), dlq, "tokentrace")
+//
+// Messages refused with ErrExpired are not dead-lettered, since they are
+// no longer worth delivering, and neither are sends cut short by ctx. If
+// the dead-letter write fails too, Send returns both errors.
+type DeadLetter struct {
+	inner Transport
+	dlq   Sender
+	dest  string
+
+	count atomic.Int64
+}
+
+// NewDeadLetter wraps t, diverting failed sends to dlq. dest names the
+// destination in each dead letter's MetaDeadLetterDest, to tell apart
+// files shared by several relays. Close closes t and, if it has a Close
+// method, dlq.
+func NewDeadLetter(t Transport, dlq Sender, dest string) *DeadLetter {
+	return &DeadLetter{inner: t, dlq: dlq, dest: dest}
+}
+
+// Send sends msg, or writes it to the dead-letter sender if that fails.
+func (d *DeadLetter) Send(ctx context.Context, msg *protocol.Message) error {
+	err := d.inner.Send(ctx, msg)
+	if !d.divertable(ctx, err) {
+		return err
+	}
+	return d.divert(ctx, err, []*protocol.Message{msg})
+}
+
+// SendBatch sends msgs, or writes the batch to the dead-letter sender if
+// that fails. Part of a failed batch may already have been delivered,
+// so a replay can duplicate it; see WithDedup. An *ExpiredBatchError is
+// returned as is, as the rest of its batch was sent.
+func (d *DeadLetter) SendBatch(ctx context.Context, msgs []*protocol.Message) error {
+	err := SendBatch(ctx, d.inner, msgs)
+	var eb *ExpiredBatchError
+	if errors.As(err, &eb) || !d.divertable(ctx, err) {
+		return err
+	}
+	return d.divert(ctx, err, msgs)
+}
+
+// divertable reports whether a send that returned err should be
+// dead-lettered.
+func (d *DeadLetter) divertable(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && !misterrors.Is(err, ErrExpired)
+}
+
+func (d *DeadLetter) divert(ctx context.Context, sendErr error, msgs []*protocol.Message) error {
+	for _, msg := range msgs {
+		if msg.Meta == nil {
+			msg.Meta = make(map[string]string, 2)
+		}
+		msg.Meta[MetaDeadLetterError] = sendErr.Error()
+		if d.dest != "" {
+			msg.Meta[MetaDeadLetterDest] = d.dest
+		}
+	}
+	if err := SendBatch(ctx, d.dlq, msgs); err != nil {
+		return fmt.Errorf("transport: dead letter: %w (after send failed: %w)", err, sendErr)
+	}
+	d.count.Add(int64(len(msgs)))
+	return nil
+}
+
+// Count returns the number of messages written to the dead-letter
+// sender.
+func (d *DeadLetter) Count() int64 { return d.count.Load() }
+
+// Receive receives from the wrapped transport.
+func (d *DeadLetter) Receive(ctx context.Context) (*protocol.Message, error) {
+	return d.inner.Receive(ctx)
+}
+
+// Close closes the wrapped transport and the dead-letter sender.
+func (d *DeadLetter) Close() error {
+	err := d.inner.Close()
+	if c, ok := d.dlq.(interface{ Close() error }); ok {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	dst := &switchTransport{}
+	dlq, err := NewFile(filepath.Join(t.TempDir(), "dead.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDeadLetter(dst, dlq, "tokentrace")
+	defer d.Close()
+
+	if err := d.Send(ctx, ping(t)); err != nil || dst.count() != 1 {
+		t.Fatalf("healthy send: err %v, sent %d", err, dst.count())
+	}
+
+	dst.setErr(errors.New("connection refused"))
+	failed := ping(t)
+	if err := d.Send(ctx, failed); err != nil {
+		t.Fatalf("failed send not diverted: %v", err)
+	}
+	if err := d.SendBatch(ctx, []*protocol.Message{ping(t), ping(t)}); err != nil {
+		t.Fatalf("failed batch not diverted: %v", err)
+	}
+	if d.Count() != 3 {
+		t.Errorf("Count = %d, want 3", d.Count())
+	}
+
+	got, err := dlq.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != failed.ID || got.Meta[MetaDeadLetterError] != "connection refused" || got.Meta[MetaDeadLetterDest] != "tokentrace" {
+		t.Errorf("dead letter = %s, meta %v", got.ID, got.Meta)
+	}
+}
+
+// flakyTransport fails its first n sends.
+type flakyTransport struct {
+	switchTransport
+	n int
+}
+
+func (f *flakyTransport) Send(ctx context.Context, msg *protocol.Message) error {
+	f.mu.Lock()
+	if f.n > 0 {
+		f.n--
+		f.mu.Unlock()
+		return errors.New("503 service unavailable")
+	}
+	f.mu.Unlock()
+	return f.switchTransport.Send(ctx, msg)
+}
+
+func TestDeadLetterAfterRetry(t *testing.T) {
+	dst := &flakyTransport{n: 1}
+	dlq := &switchTransport{}
+	d := NewDeadLetter(Wrap(dst, WithRetry(RetryPolicy{MaxAttempts: 3, InitialWait: time.Millisecond, Multiplier: 2})), dlq, "")
+
+	if err := d.Send(context.Background(), ping(t)); err != nil {
+		t.Fatal(err)
+	}
+	if dst.count() != 1 || d.Count() != 0 {
+		t.Errorf("one failure: sent %d, dead-lettered %d", dst.count(), d.Count())
+	}
+
+	dst.setErr(errors.New("503 service unavailable"))
+	if err := d.Send(context.Background(), ping(t)); err != nil {
+		t.Fatal(err)
+	}
+	if d.Count() != 1 {
+		t.Errorf("after retries: dead-lettered %d, want 1", d.Count())
+	}
+}
+
+func TestDeadLetterSkipsExpiredAndCancelled(t *testing.T) {
+	dst := &switchTransport{}
+	dlq := &switchTransport{}
+	d := NewDeadLetter(dst, dlq, "")
+
+	dst.setErr(ErrExpired)
+	if err := d.Send(context.Background(), ping(t)); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired: err = %v", err)
+	}
+
+	dst.setErr(errors.New("boom"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.Send(ctx, ping(t)); err == nil {
+		t.Error("cancelled send diverted")
+	}
+	if d.Count() != 0 || dlq.count() != 0 {
+		t.Errorf("dead-lettered %d", dlq.count())
+	}
+}
+
+func TestDeadLetterWriteFails(t *testing.T) {
+	dst := &switchTransport{}
+	dst.setErr(errors.New("dst down"))
+	dlq := &switchTransport{}
+	dlq.setErr(errors.New("disk full"))
+	d := NewDeadLetter(dst, dlq, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := d.Send(ctx, ping(t))
+	if err == nil || d.Count() != 0 {
+		t.Fatalf("err = %v, count %d", err, d.Count())
+	}
+	for _, want := range []string{"dst down", "disk full"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want it to mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package transport
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// DedupOption configures WithDedup.
+type DedupOption func(*deduper)
+
+// WithDedupMetrics counts dropped duplicates in
+// transport_duplicates_total{type}.
+func WithDedupMetrics(reg *metrics.Registry) DedupOption {
+	return func(d *deduper) { d.metrics = reg }
+}
+
+// WithDedupFile backs the in-memory window with a bitmap of hashed IDs
+// persisted to path, so duplicates are still caught for IDs evicted from
+// memory and across restarts. IDs stay in the bitmap for one to two
+// windows. The bitmap is a Bloom filter of the given size in bits: a
+// unique message is wrongly dropped with probability about
+// (1-e^(-3n/bits))^3 for n IDs per window, so size it at 20 bits or more
+// per expected ID. It is saved when the window rotates and on Close.
+func WithDedupFile(path string, bits int) DedupOption {
+	return func(d *deduper) {
+		d.path = path
+		d.bits = uint32(max(bits, 64))
+	}
+}
+
+// WithDedup drops received messages whose ID was already seen within
+// window, complementing at-least-once transports that redeliver after
+// timeouts or reconnects. At most maxEntries IDs are held in memory; the
+// least recently seen are evicted first.
+func WithDedup(window time.Duration, maxEntries int, opts ...DedupOption) MiddlewareOption {
+	d := &deduper{
+		window: window,
+		max:    maxEntries,
+		order:  list.New(),
+		seen:   make(map[string]*list.Element),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return func(m *Middleware) { m.dedup = d }
+}
+
+type deduper struct {
+	window  time.Duration
+	max     int
+	metrics *metrics.Registry
+
+	mu    sync.Mutex
+	order *list.List // of seenID, most recent first
+	seen  map[string]*list.Element
+
+	path   string
+	bits   uint32
+	bloom  *bloomWindow
+	logger *slog.Logger
+}
+
+type seenID struct {
+	id string
+	at time.Time
+}
+
+// duplicate records msg's ID and reports whether it was already seen.
+func (d *deduper) duplicate(msg *protocol.Message, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Expire IDs older than the window.
+	for e := d.order.Back(); e != nil; e = d.order.Back() {
+		s := e.Value.(seenID)
+		if now.Sub(s.at) < d.window {
+			break
+		}
+		d.order.Remove(e)
+		delete(d.seen, s.id)
+	}
+
+	if _, ok := d.seen[msg.ID]; ok {
+		d.count(msg)
+		return true
+	}
+
+	if d.path != "" {
+		if d.bloom == nil {
+			var err error
+			if d.bloom, err = loadBloom(d.path, d.bits, d.window, now); err != nil {
+				d.warn(err)
+			}
+		}
+		if d.bloom.rotate(now) {
+			if err := d.bloom.save(d.path); err != nil {
+				d.warn(err)
+			}
+		}
+		if d.bloom.test(msg.ID) {
+			d.count(msg)
+			return true
+		}
+		d.bloom.add(msg.ID)
+	}
+
+	d.seen[msg.ID] = d.order.PushFront(seenID{msg.ID, now})
+	if d.max > 0 && d.order.Len() > d.max {
+		e := d.order.Back()
+		d.order.Remove(e)
+		delete(d.seen, e.Value.(seenID).id)
+	}
+	return false
+}
+
+func (d *deduper) count(msg *protocol.Message) {
+	if d.metrics != nil {
+		d.metrics.Counter("transport_duplicates_total", "type", msg.Type).Inc()
+	}
+}
+
+func (d *deduper) warn(err error) {
+	if d.logger != nil {
+		d.logger.Warn("dedup bitmap", "path", d.path, "error", err)
+	}
+}
+
+// close saves the persistent bitmap, if any.
+func (d *deduper) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.bloom == nil {
+		return nil
+	}
+	return d.bloom.save(d.path)
+}
+
+// bloomWindow is a pair of Bloom filters covering the current and
+// previous window, so an ID stays known for between one and two windows.
+type bloomWindow struct {
+	bits    uint32
+	window  time.Duration
+	rotated time.Time
+	cur     []byte
+	prev    []byte
+}
+
+const bloomMagic = "MISTDDP1"
+
+func newBloom(bits uint32, window time.Duration, now time.Time) *bloomWindow {
+	n := (bits + 7) / 8
+	return &bloomWindow{bits: n * 8, window: window, rotated: now, cur: make([]byte, n), prev: make([]byte, n)}
+}
+
+// loadBloom reads a saved bitmap, or starts an empty one if the file is
+// missing, unreadable, or was created with a different size.
+func loadBloom(path string, bits uint32, window time.Duration, now time.Time) (*bloomWindow, error) {
+	b := newBloom(bits, window, now)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return b, fmt.Errorf("transport: dedup: %w", err)
+	}
+	n := len(b.cur)
+	if len(data) != len(bloomMagic)+12+2*n || !bytes.HasPrefix(data, []byte(bloomMagic)) {
+		return b, fmt.Errorf("transport: dedup: %s: unrecognized bitmap; starting empty", path)
+	}
+	data = data[len(bloomMagic):]
+	if binary.BigEndian.Uint32(data[8:12]) != b.bits {
+		return b, fmt.Errorf("transport: dedup: %s: bitmap size changed; starting empty", path)
+	}
+	b.rotated = time.Unix(0, int64(binary.BigEndian.Uint64(data[:8])))
+	copy(b.cur, data[12:12+n])
+	copy(b.prev, data[12+n:])
+	return b, nil
+}
+
+// save writes the bitmap via a temporary file so a crash never leaves a
+// torn file behind.
+func (b *bloomWindow) save(path string) error {
+	buf := make([]byte, 0, len(bloomMagic)+12+2*len(b.cur))
+	buf = append(buf, bloomMagic...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(b.rotated.UnixNano()))
+	buf = binary.BigEndian.AppendUint32(buf, b.bits)
+	buf = append(buf, b.cur...)
+	buf = append(buf, b.prev...)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".dedup-*")
+	if err != nil {
+		return fmt.Errorf("transport: dedup: %w", err)
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("transport: dedup: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("transport: dedup: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("transport: dedup: %w", err)
+	}
+	return nil
+}
+
+// rotate ages out the previous window and reports whether it did.
+func (b *bloomWindow) rotate(now time.Time) bool {
+	elapsed := now.Sub(b.rotated)
+	if elapsed < b.window {
+		return false
+	}
+	if elapsed >= 2*b.window {
+		clear(b.prev)
+	} else {
+		copy(b.prev, b.cur)
+	}
+	clear(b.cur)
+	b.rotated = now
+	return true
+}
+
+// positions returns the three bit positions for id.
+func (b *bloomWindow) positions(id string) [3]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	var p [3]uint32
+	for i := range p {
+		p[i] = (h1 + uint32(i)*h2) % b.bits
+	}
+	return p
+}
+
+func (b *bloomWindow) test(id string) bool {
+	for _, filter := range [][]byte{b.cur, b.prev} {
+		hit := true
+		for _, p := range b.positions(id) {
+			if filter[p/8]&(1<<(p%8)) == 0 {
+				hit = false
+				break
+			}
+		}
+		if hit {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bloomWindow) add(id string) {
+	for _, p := range b.positions(id) {
+		b.cur[p/8] |= 1 << (p % 8)
+	}
+}
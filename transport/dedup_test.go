@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func ping(t *testing.T) *protocol.Message {
+	t.Helper()
+	msg, err := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// receiveIDs drains ch through m and returns the IDs received.
+func receiveIDs(t *testing.T, m *Middleware, ch *Channel) []string {
+	t.Helper()
+	ch.Close()
+	var ids []string
+	for {
+		msg, err := m.Receive(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil {
+			return ids
+		}
+		ids = append(ids, msg.ID)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	reg := metrics.NewRegistry()
+	a, b := ping(t), ping(t)
+
+	ch := NewChannel(8)
+	for _, msg := range []*protocol.Message{a, b, a, a, b} {
+		ch.Send(context.Background(), msg)
+	}
+	m := Wrap(ch, WithDedup(time.Minute, 100, WithDedupMetrics(reg)))
+
+	ids := receiveIDs(t, m, ch)
+	if len(ids) != 2 || ids[0] != a.ID || ids[1] != b.ID {
+		t.Errorf("received %v, want [a b]", ids)
+	}
+	if n := reg.Counter("transport_duplicates_total", "type", protocol.TypeHealthPing).Value(); n != 3 {
+		t.Errorf("duplicates = %d, want 3", n)
+	}
+}
+
+func TestDedupWindowAndCapacity(t *testing.T) {
+	var m Middleware
+	WithDedup(time.Second, 2)(&m)
+	d := m.dedup
+	a, b, c := ping(t), ping(t), ping(t)
+	now := time.Now()
+
+	d.duplicate(a, now)
+	if !d.duplicate(a, now.Add(500*time.Millisecond)) {
+		t.Error("a within window not detected")
+	}
+	if d.duplicate(a, now.Add(2*time.Second)) {
+		t.Error("a after window treated as duplicate")
+	}
+
+	// Capacity 2: recording b and c evicts a.
+	d.duplicate(b, now.Add(2*time.Second))
+	d.duplicate(c, now.Add(2*time.Second))
+	if d.duplicate(a, now.Add(2*time.Second)) {
+		t.Error("evicted a still detected without a bitmap")
+	}
+}
+
+func TestDedupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.bin")
+	a, b := ping(t), ping(t)
+
+	ch := NewChannel(8)
+	ch.Send(context.Background(), a)
+	m := Wrap(ch, WithDedup(time.Minute, 1, WithDedupFile(path, 1<<16)))
+	if ids := receiveIDs(t, m, ch); len(ids) != 1 {
+		t.Fatalf("first run received %v", ids)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A restarted receiver still drops a redelivery of a.
+	ch = NewChannel(8)
+	ch.Send(context.Background(), a)
+	ch.Send(context.Background(), b)
+	m = Wrap(ch, WithDedup(time.Minute, 1, WithDedupFile(path, 1<<16)))
+	ids := receiveIDs(t, m, ch)
+	if len(ids) != 1 || ids[0] != b.ID {
+		t.Errorf("second run received %v, want [b]", ids)
+	}
+}
+
+func TestBloomWindowRotate(t *testing.T) {
+	now := time.Now()
+	bw := newBloom(1024, time.Minute, now)
+	bw.add("x")
+
+	bw.rotate(now.Add(61 * time.Second))
+	if !bw.test("x") {
+		t.Error("x forgotten after one window")
+	}
+	bw.rotate(now.Add(122 * time.Second))
+	if bw.test("x") {
+		t.Error("x remembered after two windows")
+	}
+}
@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// DrainGate coordinates the control.drain handshake used for zero-loss
+// rolling deploys. Until Drain is called it admits all work. Once
+// draining, Acquire refuses new work and the gate reports drained when
+// nothing is in flight and the queue, if any, is empty. Operators start a
+// drain by sending a control.drain message and poll Status (served over
+// HTTP as GET /drain) until the state is drained.
+//
+// A drain stops a node taking work, so over HTTP only callers on the
+// node's own host may start one, or others that present the gate's token
+// (see SetToken).
+type DrainGate struct {
+	queued func() int
+	token  string
+
+	mu       sync.Mutex
+	draining bool
+	inflight int64
+	done     chan struct{}
+	closed   bool
+}
+
+// NewDrainGate creates a gate. queued, if non-nil, reports messages
+// accepted but not yet taken for processing; the gate is not drained
+// until it returns zero.
+func NewDrainGate(queued func() int) *DrainGate {
+	return &DrainGate{queued: queued, done: make(chan struct{})}
+}
+
+// SetToken lets callers from other hosts start a drain by sending
+// "Authorization: Bearer <token>" with their control.drain. Without a
+// token only loopback callers can. Call it before serving requests.
+func (g *DrainGate) SetToken(token string) {
+	g.token = token
+}
+
+// Authorize reports whether r may start a drain: it comes from a
+// loopback address, or carries the gate's token.
+func (g *DrainGate) Authorize(r *http.Request) bool {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	if g.token == "" {
+		return false
+	}
+	got := []byte(r.Header.Get("Authorization"))
+	return subtle.ConstantTimeCompare(got, []byte("Bearer "+g.token)) == 1
+}
+
+// Forbid refuses r with 403, and reports true, if msgs include a
+// control.drain that r may not send. Handlers call it before Control.
+func (g *DrainGate) Forbid(w http.ResponseWriter, r *http.Request, msgs ...*protocol.Message) bool {
+	for _, msg := range msgs {
+		if msg.Type == protocol.TypeControlDrain && !g.Authorize(r) {
+			http.Error(w, "control.drain is only accepted from this host or with the drain token", http.StatusForbidden)
+			return true
+		}
+	}
+	return false
+}
+
+// Acquire registers a unit of in-flight work. It returns false once the
+// gate is draining; the caller should reject the work so the sender can
+// retry elsewhere. Every successful Acquire must be paired with Release.
+func (g *DrainGate) Acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.inflight++
+	return true
+}
+
+// Release marks a unit of work acquired with Acquire as finished.
+func (g *DrainGate) Release() {
+	g.mu.Lock()
+	g.inflight--
+	g.mu.Unlock()
+	g.Check()
+}
+
+// Drain stops admitting new work. It is safe to call more than once.
+func (g *DrainGate) Drain() {
+	g.mu.Lock()
+	g.draining = true
+	g.mu.Unlock()
+	g.Check()
+}
+
+// Draining reports whether Drain has been called.
+func (g *DrainGate) Draining() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.draining
+}
+
+// Check closes Done if the gate has drained. Owners of the queue call it
+// after taking a message off the queue.
+func (g *DrainGate) Check() {
+	g.Status()
+}
+
+// Done returns a channel that is closed once the gate has drained.
+func (g *DrainGate) Done() <-chan struct{} {
+	return g.done
+}
+
+// Status reports the gate's state and what is left to finish.
+func (g *DrainGate) Status() protocol.DrainStatus {
+	queued := 0
+	if g.queued != nil {
+		queued = g.queued()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := protocol.DrainStatus{State: protocol.DrainAccepting, InFlight: g.inflight, Queued: queued}
+	if g.draining {
+		st.State = protocol.DrainDraining
+		if g.inflight == 0 && queued == 0 {
+			st.State = protocol.DrainDrained
+			if !g.closed {
+				g.closed = true
+				close(g.done)
+			}
+		}
+	}
+	return st
+}
+
+// Control starts a drain if msg is a control.drain message and reports
+// whether it was one. Handlers call it before admitting msg as work.
+func (g *DrainGate) Control(msg *protocol.Message) bool {
+	if msg.Type != protocol.TypeControlDrain {
+		return false
+	}
+	g.Drain()
+	return true
+}
+
+// ServeHTTP writes the drain status as JSON. It is mounted as GET /drain.
+func (g *DrainGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeDrainStatus(w, http.StatusOK, g.Status())
+}
+
+func writeDrainStatus(w http.ResponseWriter, code int, st protocol.DrainStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(st)
+}
+
+// HandleControl answers a control.drain message on an HTTP ingest
+// endpoint: it starts the drain and replies 202 with the status, or 403
+// if r may not start one (see Authorize). Any other message arriving
+// while draining is refused with 503 so the sender retries another node.
+// It reports whether a reply was written.
+func (g *DrainGate) HandleControl(w http.ResponseWriter, r *http.Request, msg *protocol.Message) bool {
+	if g.Forbid(w, r, msg) {
+		return true
+	}
+	if g.Control(msg) {
+		writeDrainStatus(w, http.StatusAccepted, g.Status())
+		return true
+	}
+	if g.Draining() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestDrainGate(t *testing.T) {
+	queued := 1
+	g := NewDrainGate(func() int { return queued })
+
+	if !g.Acquire() {
+		t.Fatal("Acquire refused before drain")
+	}
+	if st := g.Status(); st.State != protocol.DrainAccepting || st.InFlight != 1 || st.Queued != 1 {
+		t.Errorf("status = %+v", st)
+	}
+
+	g.Drain()
+	if g.Acquire() {
+		t.Error("Acquire admitted work while draining")
+	}
+	if st := g.Status(); st.State != protocol.DrainDraining {
+		t.Errorf("state = %q, want draining", st.State)
+	}
+
+	g.Release()
+	select {
+	case <-g.Done():
+		t.Fatal("drained with a message still queued")
+	default:
+	}
+
+	queued = 0
+	g.Check()
+	select {
+	case <-g.Done():
+	default:
+		t.Fatal("not drained once empty")
+	}
+	if st := g.Status(); st.State != protocol.DrainDrained {
+		t.Errorf("state = %q, want drained", st.State)
+	}
+	g.Drain() // idempotent
+}
+
+func TestDrainGateHandleControl(t *testing.T) {
+	g := NewDrainGate(nil)
+	ping, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "test"})
+
+	local := httptest.NewRequest("POST", "/mist", nil)
+	local.RemoteAddr = "127.0.0.1:40000"
+	w := httptest.NewRecorder()
+	if g.HandleControl(w, local, ping) {
+		t.Fatal("ordinary message handled before drain")
+	}
+
+	drain, _ := protocol.New("test", protocol.TypeControlDrain, protocol.ControlDrain{Reason: "deploy"})
+	w = httptest.NewRecorder()
+	if !g.HandleControl(w, local, drain) || w.Code != http.StatusAccepted {
+		t.Fatalf("control.drain: code = %d", w.Code)
+	}
+	var st protocol.DrainStatus
+	if err := json.NewDecoder(w.Body).Decode(&st); err != nil || st.State != protocol.DrainDrained {
+		t.Errorf("reply = %+v, %v", st, err)
+	}
+
+	w = httptest.NewRecorder()
+	if !g.HandleControl(w, local, ping) || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("message while draining: code = %d, want 503", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest("GET", "/drain", nil))
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"state":"drained"`)) {
+		t.Errorf("GET /drain = %s", w.Body)
+	}
+}
+
+func TestDrainGateAuthorize(t *testing.T) {
+	g := NewDrainGate(nil)
+	drain, _ := protocol.New("test", protocol.TypeControlDrain, protocol.ControlDrain{})
+	remote := func(auth string) *http.Request {
+		r := httptest.NewRequest("POST", "/mist", nil)
+		r.RemoteAddr = "203.0.113.9:40000"
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	if !g.HandleControl(w, remote(""), drain) || w.Code != http.StatusForbidden || g.Draining() {
+		t.Fatalf("remote drain without a token: code = %d, draining = %v; want 403", w.Code, g.Draining())
+	}
+
+	g.SetToken("s3cret")
+	w = httptest.NewRecorder()
+	if g.HandleControl(w, remote("Bearer wrong"), drain); w.Code != http.StatusForbidden || g.Draining() {
+		t.Fatalf("remote drain with the wrong token: code = %d, want 403", w.Code)
+	}
+	w = httptest.NewRecorder()
+	if g.HandleControl(w, remote("Bearer s3cret"), drain); w.Code != http.StatusAccepted || !g.Draining() {
+		t.Errorf("remote drain with the token: code = %d, want 202", w.Code)
+	}
+}
+
+func TestHTTPDrainWaitsForDeliveries(t *testing.T) {
+	h := NewHTTP("")
+	gate := h.EnableDrain()
+	ctx := context.Background()
+	client := NewHTTP("http://" + listenHTTP(t, h) + "/mist")
+	if err := client.Send(ctx, ping(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := h.ReceiveDelivery(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain, _ := protocol.New("test", protocol.TypeControlDrain, protocol.ControlDrain{})
+	if err := client.Send(ctx, drain); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Send(ctx, ping(t)); err == nil {
+		t.Error("send while draining was accepted")
+	}
+
+	// A nacked message goes back on the queue and still holds the drain.
+	d.Nack()
+	if st := gate.Status(); st.State != protocol.DrainDraining || st.Queued != 1 {
+		t.Errorf("status with a nacked message = %+v, want draining with 1 queued", st)
+	}
+	if d, err = h.ReceiveDelivery(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-gate.Done():
+		t.Fatal("drained before the delivery was acked")
+	default:
+	}
+	d.Ack()
+	select {
+	case <-gate.Done():
+	default:
+		t.Fatalf("not drained once acked: %+v", gate.Status())
+	}
+}
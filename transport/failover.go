@@ -0,0 +1,264 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// FailoverPolicy controls a failover group created by DialMulti or
+// NewFailover.
+type FailoverPolicy struct {
+	// SendTimeout bounds each attempt to send to one destination, so a
+	// hung destination fails over instead of stalling the sender
+	// (default 5s).
+	SendTimeout time.Duration
+
+	// RecoverAfter is how long a failed destination is skipped before
+	// Send tries it again (default 30s). Destinations are always tried
+	// in order, so sends return to the primary once it recovers.
+	RecoverAfter time.Duration
+
+	// Probe, if set, checks a failed destination before it is tried
+	// again, for example with a GET of its /healthz. A destination whose
+	// probe fails is skipped for another RecoverAfter.
+	Probe func(ctx context.Context, name string) error
+
+	// OnFailover is called when sends move from one destination to
+	// another, including back to the primary.
+	OnFailover func(from, to string)
+}
+
+// FailoverMember is one destination of a failover group. Dial is called
+// when the member is first used and again after it fails, as by
+// Resilient.
+type FailoverMember struct {
+	Name string
+	Dial DialFunc
+}
+
+// Failover is a Transport that sends to the first healthy destination of
+// an ordered group: the primary while it works, then each secondary in
+// turn. A destination that fails a send is closed and skipped for
+// RecoverAfter, then re-dialed and tried again, so a relay pointed at a
+// collector keeps delivering while the collector is down for
+// maintenance and moves back once it is up. Receive reads from the
+// destination sends currently go to.
+//
+// Send returns an error only when every destination has failed, or for
+// an error that is not retryable (such as ErrMessageTooLarge), which no
+// other destination would accept either. To keep retrying through a full
+// outage, wrap the group with NewResilient.
+type Failover struct {
+	policy  FailoverPolicy
+	members []*failoverMember
+	now     func() time.Time
+
+	mu     sync.Mutex
+	active int
+	closed bool
+}
+
+type failoverMember struct {
+	FailoverMember
+	conn      Transport // nil until dialed, and after a failure
+	downUntil time.Time // zero while healthy
+}
+
+// DialMulti creates a failover group from transport URLs, the first
+// being the primary. Every URL is dialed up front, so a malformed one
+// fails here rather than at failover time.
+//
+//	t, err := transport.DialMulti([]string{
+//		"http://collector-a:8700/mist",
+//		"http://collector-b:8700/mist",
+//	}, transport.FailoverPolicy{})
+func DialMulti(urls []string, policy FailoverPolicy) (*Failover, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("transport: DialMulti needs at least one URL")
+	}
+	members := make([]FailoverMember, len(urls))
+	conns := make([]Transport, len(urls))
+	for i, url := range urls {
+		conn, err := Dial(url)
+		if err != nil {
+			for _, c := range conns[:i] {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns[i] = conn
+		members[i] = FailoverMember{Name: url, Dial: func() (Transport, error) { return Dial(url) }}
+	}
+	f := NewFailover(policy, members...)
+	for i, conn := range conns {
+		f.members[i].conn = conn
+	}
+	return f, nil
+}
+
+// NewFailover creates a failover group from members, the first being
+// the primary. Members are dialed when first used.
+func NewFailover(policy FailoverPolicy, members ...FailoverMember) *Failover {
+	if policy.SendTimeout == 0 {
+		policy.SendTimeout = 5 * time.Second
+	}
+	if policy.RecoverAfter == 0 {
+		policy.RecoverAfter = 30 * time.Second
+	}
+	f := &Failover{policy: policy, now: time.Now}
+	for _, m := range members {
+		f.members = append(f.members, &failoverMember{FailoverMember: m})
+	}
+	return f
+}
+
+// Active returns the name of the destination sends currently go to.
+func (f *Failover) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.members[f.active].Name
+}
+
+// Send sends msg to the first destination that accepts it.
+func (f *Failover) Send(ctx context.Context, msg *protocol.Message) error {
+	var lastErr error
+	tried := 0
+	// The first pass skips destinations that are down; if they all
+	// are, the second tries them anyway rather than give up.
+	for _, skipDown := range []bool{true, false} {
+		for i, m := range f.members {
+			conn, err := f.conn(ctx, m, skipDown)
+			if conn == nil && err == nil {
+				continue
+			}
+			tried++
+			if err == nil {
+				sendCtx, cancel := context.WithTimeout(ctx, f.policy.SendTimeout)
+				err = conn.Send(sendCtx, msg)
+				cancel()
+			}
+			if err == nil {
+				f.up(i)
+				return nil
+			}
+			if ctx.Err() != nil {
+				return err
+			}
+			if !misterrors.IsRetryable(err) {
+				return err
+			}
+			f.down(m, conn)
+			lastErr = err
+		}
+		if tried > 0 {
+			break
+		}
+	}
+	return fmt.Errorf("transport: failover: all %d destinations failed: %w", len(f.members), lastErr)
+}
+
+// Receive receives from the destination sends currently go to.
+func (f *Failover) Receive(ctx context.Context) (*protocol.Message, error) {
+	f.mu.Lock()
+	m := f.members[f.active]
+	f.mu.Unlock()
+	conn, err := f.conn(ctx, m, false)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Receive(ctx)
+}
+
+// Close closes every dialed destination.
+func (f *Failover) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	var first error
+	for _, m := range f.members {
+		if m.conn != nil {
+			if err := m.conn.Close(); err != nil && first == nil {
+				first = err
+			}
+			m.conn = nil
+		}
+	}
+	return first
+}
+
+// conn returns m's connection, dialing it if needed. With skipDown, a
+// member still within RecoverAfter of a failure, or whose probe fails,
+// is skipped with a nil connection and error.
+func (f *Failover) conn(ctx context.Context, m *failoverMember, skipDown bool) (Transport, error) {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("transport: failover: closed")
+	}
+	down := !m.downUntil.IsZero()
+	if skipDown && down && f.now().Before(m.downUntil) {
+		f.mu.Unlock()
+		return nil, nil
+	}
+	conn := m.conn
+	f.mu.Unlock()
+
+	if skipDown && down && f.policy.Probe != nil {
+		if err := f.policy.Probe(ctx, m.Name); err != nil {
+			f.mu.Lock()
+			m.downUntil = f.now().Add(f.policy.RecoverAfter)
+			f.mu.Unlock()
+			return nil, nil
+		}
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := m.Dial()
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		conn.Close()
+		return nil, fmt.Errorf("transport: failover: closed")
+	}
+	if m.conn != nil {
+		// Another sender dialed it first.
+		conn.Close()
+		return m.conn, nil
+	}
+	m.conn = conn
+	return conn, nil
+}
+
+// up records a successful send to member i, moving sends to it.
+func (f *Failover) up(i int) {
+	f.mu.Lock()
+	f.members[i].downUntil = time.Time{}
+	from := f.active
+	f.active = i
+	f.mu.Unlock()
+	if from != i && f.policy.OnFailover != nil {
+		f.policy.OnFailover(f.members[from].Name, f.members[i].Name)
+	}
+}
+
+// down marks m failed until RecoverAfter from now and closes the
+// connection that failed, so it is re-dialed on recovery.
+func (f *Failover) down(m *failoverMember, failed Transport) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m.downUntil = f.now().Add(f.policy.RecoverAfter)
+	if failed != nil && m.conn == failed {
+		m.conn.Close()
+		m.conn = nil
+	}
+}
@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// switchTransport counts sends and fails them while err is set.
+type switchTransport struct {
+	mu   sync.Mutex
+	err  error
+	sent int
+}
+
+func (s *switchTransport) setErr(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *switchTransport) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+func (s *switchTransport) Send(context.Context, *protocol.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.sent++
+	return nil
+}
+
+func (s *switchTransport) Receive(ctx context.Context) (*protocol.Message, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *switchTransport) Close() error { return nil }
+
+// hungTransport blocks every Send until ctx is done.
+type hungTransport struct{ switchTransport }
+
+func (h *hungTransport) Send(ctx context.Context, _ *protocol.Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func member(name string, t Transport) FailoverMember {
+	return FailoverMember{Name: name, Dial: func() (Transport, error) { return t, nil }}
+}
+
+func TestFailoverFailsOverAndRecovers(t *testing.T) {
+	primary, secondary := &switchTransport{}, &switchTransport{}
+	var moves []string
+	f := NewFailover(FailoverPolicy{
+		RecoverAfter: time.Minute,
+		OnFailover:   func(from, to string) { moves = append(moves, from+"→"+to) },
+	}, member("a", primary), member("b", secondary))
+	now := time.Unix(1000, 0)
+	f.now = func() time.Time { return now }
+	ctx := context.Background()
+	msg := ping(t)
+
+	if err := f.Send(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	primary.setErr(fmt.Errorf("collector down"))
+	for range 3 {
+		if err := f.Send(ctx, msg); err != nil {
+			t.Fatalf("Send during outage: %v", err)
+		}
+	}
+	if f.Active() != "b" || secondary.count() != 3 {
+		t.Errorf("active = %s, secondary sent %d; want b, 3", f.Active(), secondary.count())
+	}
+
+	// The primary is back, but is not retried until RecoverAfter.
+	primary.setErr(nil)
+	f.Send(ctx, msg)
+	if primary.count() != 1 {
+		t.Errorf("primary retried before RecoverAfter")
+	}
+	now = now.Add(time.Minute)
+	f.Send(ctx, msg)
+	if f.Active() != "a" || primary.count() != 2 {
+		t.Errorf("active = %s, primary sent %d; want a, 2", f.Active(), primary.count())
+	}
+	if got := strings.Join(moves, ","); got != "a→b,b→a" {
+		t.Errorf("moves = %s", got)
+	}
+}
+
+func TestFailoverAllDown(t *testing.T) {
+	a, b := &switchTransport{}, &switchTransport{}
+	a.setErr(fmt.Errorf("a down"))
+	b.setErr(fmt.Errorf("b down"))
+	f := NewFailover(FailoverPolicy{}, member("a", a), member("b", b))
+
+	err := f.Send(context.Background(), ping(t))
+	if err == nil || !strings.Contains(err.Error(), "all 2 destinations failed") {
+		t.Fatalf("err = %v", err)
+	}
+
+	// With every destination down, they are still tried.
+	b.setErr(nil)
+	if err := f.Send(context.Background(), ping(t)); err != nil {
+		t.Fatalf("Send after b recovered: %v", err)
+	}
+}
+
+func TestFailoverPermanentError(t *testing.T) {
+	a, b := &switchTransport{}, &switchTransport{}
+	a.setErr(misterrors.Wrap(misterrors.CodeValidation, ErrMessageTooLarge, "too big"))
+	f := NewFailover(FailoverPolicy{}, member("a", a), member("b", b))
+
+	err := f.Send(context.Background(), ping(t))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("err = %v, want ErrMessageTooLarge", err)
+	}
+	if b.count() != 0 {
+		t.Error("a permanent error should not fail over")
+	}
+}
+
+func TestFailoverProbe(t *testing.T) {
+	a, b := &switchTransport{}, &switchTransport{}
+	healthy := false
+	f := NewFailover(FailoverPolicy{
+		RecoverAfter: time.Second,
+		Probe: func(_ context.Context, name string) error {
+			if !healthy {
+				return fmt.Errorf("%s unhealthy", name)
+			}
+			return nil
+		},
+	}, member("a", a), member("b", b))
+	now := time.Unix(1000, 0)
+	f.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	a.setErr(fmt.Errorf("down"))
+	f.Send(ctx, ping(t))
+	a.setErr(nil)
+
+	now = now.Add(time.Second)
+	f.Send(ctx, ping(t))
+	if a.count() != 0 {
+		t.Error("a was tried although its probe failed")
+	}
+
+	healthy = true
+	now = now.Add(time.Second)
+	f.Send(ctx, ping(t))
+	if a.count() != 1 || f.Active() != "a" {
+		t.Errorf("a sent %d, active %s; want 1, a", a.count(), f.Active())
+	}
+}
+
+func TestFailoverSendTimeout(t *testing.T) {
+	hung := &hungTransport{}
+	b := &switchTransport{}
+	f := NewFailover(FailoverPolicy{SendTimeout: 20 * time.Millisecond}, member("hung", hung), member("b", b))
+
+	if err := f.Send(context.Background(), ping(t)); err != nil {
+		t.Fatal(err)
+	}
+	if b.count() != 1 {
+		t.Error("expected failover after SendTimeout")
+	}
+}
+
+func TestDialMulti(t *testing.T) {
+	f, err := DialMulti([]string{"chan://?buffer=4", "chan://"}, FailoverPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Active() != "chan://?buffer=4" {
+		t.Errorf("Active() = %s", f.Active())
+	}
+
+	if _, err := DialMulti([]string{"chan://", "chan://?bogus=1"}, FailoverPolicy{}); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("err = %v, want ErrInvalidOption", err)
+	}
+	if _, err := DialMulti(nil, FailoverPolicy{}); err == nil {
+		t.Error("expected error for no URLs")
+	}
+}
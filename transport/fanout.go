@@ -0,0 +1,205 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// FanOutMode decides when a fan-out send has succeeded.
+type FanOutMode int
+
+const (
+	// FanOutAll fails the send if any destination fails. The default.
+	FanOutAll FanOutMode = iota
+	// FanOutBestEffort fails the send only if every destination fails.
+	FanOutBestEffort
+	// FanOutQuorum fails the send unless at least Quorum destinations
+	// accept it.
+	FanOutQuorum
+)
+
+// FanOutPolicy controls how a FanOut treats partial failure.
+type FanOutPolicy struct {
+	Mode FanOutMode
+
+	// Quorum is how many destinations must accept a message in
+	// FanOutQuorum mode. Zero means a majority.
+	Quorum int
+
+	// OnError is called for each destination that fails a send, by its
+	// index in NewFanOut's arguments, even when the send as a whole
+	// succeeds. Best-effort senders use it to log what was lost.
+	OnError func(dst int, err error)
+}
+
+// FanOut is a Transport that sends every message to all of its
+// destinations at once, for example trace spans to both TokenTrace and a
+// file archive:
+//
+//	archive, _ := transport.NewFile("spans.jsonl")
+//	t := transport.NewFanOut(transport.NewHTTP("http://tokentrace:8700"), archive).
+//		WithPolicy(transport.FanOutPolicy{Mode: transport.FanOutBestEffort})
+//
+// Each destination receives its own copy of the message, so middleware
+// stamping one copy doesn't alter another. Receive reads from the first
+// destination.
+type FanOut struct {
+	dsts   []Transport
+	policy FanOutPolicy
+	err    error // why the fan-out can't send; see check
+}
+
+// NewFanOut creates a fan-out to dsts with the FanOutAll policy. A
+// fan-out with no destinations refuses every send with a validation
+// error, rather than accepting messages nothing receives.
+func NewFanOut(dsts ...Transport) *FanOut {
+	f := &FanOut{dsts: dsts}
+	f.check()
+	return f
+}
+
+// WithPolicy sets the partial-failure policy and returns f. Call it
+// before the first Send. A quorum that the destinations can never meet
+// makes every send fail with a validation error.
+func (f *FanOut) WithPolicy(p FanOutPolicy) *FanOut {
+	f.policy = p
+	f.check()
+	return f
+}
+
+// check records in f.err why f's destinations and policy can never
+// accept a send.
+func (f *FanOut) check() {
+	switch n := len(f.dsts); {
+	case n == 0:
+		f.err = misterrors.New(misterrors.CodeValidation, "transport: fan-out has no destinations").Permanent()
+	case f.policy.Mode == FanOutQuorum && (f.policy.Quorum < 0 || f.policy.Quorum > n):
+		f.err = misterrors.Newf(misterrors.CodeValidation, "transport: fan-out: quorum %d of %d destinations", f.policy.Quorum, n).Permanent()
+	default:
+		f.err = nil
+	}
+}
+
+// Send sends msg to every destination concurrently and applies the
+// policy to the results. A failed send's error joins the errors of the
+// destinations that refused it. If every failure was ErrExpired, Send
+// returns ErrExpired itself, so callers can count it as expired.
+func (f *FanOut) Send(ctx context.Context, msg *protocol.Message) error {
+	return f.fanOut(func(dst Transport, m []*protocol.Message) error {
+		return dst.Send(ctx, m[0])
+	}, []*protocol.Message{msg})
+}
+
+// SendBatch sends msgs to every destination concurrently, in one call to
+// each that is a BatchSender, and applies the policy as Send does. A
+// destination that returns an *ExpiredBatchError delivered the rest of
+// the batch, so it counts as accepting it; the first such error is
+// returned if the send succeeds.
+func (f *FanOut) SendBatch(ctx context.Context, msgs []*protocol.Message) error {
+	return f.fanOut(func(dst Transport, m []*protocol.Message) error {
+		return SendBatch(ctx, dst, m)
+	}, msgs)
+}
+
+func (f *FanOut) fanOut(send func(Transport, []*protocol.Message) error, msgs []*protocol.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	errs := make([]error, len(f.dsts))
+	var wg sync.WaitGroup
+	for i, dst := range f.dsts {
+		copies := make([]*protocol.Message, len(msgs))
+		for j, msg := range msgs {
+			copies[j] = cloneMessage(msg)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = send(dst, copies)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		accepted int
+		expired  *ExpiredBatchError
+		failed   []error
+		allExp   = true
+	)
+	for i, err := range errs {
+		var eb *ExpiredBatchError
+		switch {
+		case err == nil:
+			accepted++
+		case errors.As(err, &eb):
+			accepted++
+			if expired == nil {
+				expired = eb
+			}
+		default:
+			if f.policy.OnError != nil {
+				f.policy.OnError(i, err)
+			}
+			failed = append(failed, fmt.Errorf("destination %d: %w", i, err))
+			allExp = allExp && misterrors.Is(err, ErrExpired)
+		}
+	}
+
+	if accepted >= f.required() {
+		if expired != nil {
+			return expired
+		}
+		return nil
+	}
+	if allExp {
+		return ErrExpired
+	}
+	return fmt.Errorf("transport: fan-out: %d of %d destinations failed: %w", len(failed), len(f.dsts), errors.Join(failed...))
+}
+
+// required returns how many destinations must accept a send under the
+// policy.
+func (f *FanOut) required() int {
+	n := len(f.dsts)
+	switch f.policy.Mode {
+	case FanOutBestEffort:
+		return 1
+	case FanOutQuorum:
+		if f.policy.Quorum > 0 {
+			return f.policy.Quorum
+		}
+		return n/2 + 1
+	}
+	return n
+}
+
+// Receive receives from the first destination.
+func (f *FanOut) Receive(ctx context.Context) (*protocol.Message, error) {
+	if len(f.dsts) == 0 {
+		return nil, fmt.Errorf("transport: fan-out has no destinations")
+	}
+	return f.dsts[0].Receive(ctx)
+}
+
+// Close closes every destination.
+func (f *FanOut) Close() error {
+	var errs []error
+	for _, dst := range f.dsts {
+		errs = append(errs, dst.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// cloneMessage copies msg for another destination. The payload is
+// shared, as transports don't modify it.
+func cloneMessage(msg *protocol.Message) *protocol.Message {
+	c := *msg
+	c.Meta = maps.Clone(msg.Meta)
+	return &c
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestFanOutPolicies(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name    string
+		policy  FanOutPolicy
+		failing int // of three destinations
+		wantErr bool
+	}{
+		{"all ok", FanOutPolicy{}, 0, false},
+		{"all one down", FanOutPolicy{}, 1, true},
+		{"best effort one up", FanOutPolicy{Mode: FanOutBestEffort}, 2, false},
+		{"best effort all down", FanOutPolicy{Mode: FanOutBestEffort}, 3, true},
+		{"majority one down", FanOutPolicy{Mode: FanOutQuorum}, 1, false},
+		{"majority two down", FanOutPolicy{Mode: FanOutQuorum}, 2, true},
+		{"quorum of three one down", FanOutPolicy{Mode: FanOutQuorum, Quorum: 3}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsts := []*switchTransport{{}, {}, {}}
+			for _, d := range dsts[:tt.failing] {
+				d.setErr(boom)
+			}
+			var reported []int
+			policy := tt.policy
+			policy.OnError = func(dst int, err error) { reported = append(reported, dst) }
+			f := NewFanOut(dsts[0], dsts[1], dsts[2]).WithPolicy(policy)
+
+			err := f.Send(context.Background(), ping(t))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, boom) {
+				t.Errorf("error %v does not wrap the destination error", err)
+			}
+			if len(reported) != tt.failing {
+				t.Errorf("OnError called for %v, want %d destinations", reported, tt.failing)
+			}
+			for i, d := range dsts[tt.failing:] {
+				if d.count() != 1 {
+					t.Errorf("healthy destination %d got %d messages", tt.failing+i, d.count())
+				}
+			}
+		})
+	}
+}
+
+func TestFanOutCopies(t *testing.T) {
+	a, b := NewChannel(4), NewChannel(4)
+	f := NewFanOut(a, b)
+	msg := ping(t)
+	msg.Meta = map[string]string{"k": "v"}
+	if err := f.SendBatch(context.Background(), []*protocol.Message{msg, ping(t)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	got, _ := a.Receive(ctx)
+	other, _ := b.Receive(ctx)
+	if got == msg || got == other || got.ID != msg.ID || other.ID != msg.ID {
+		t.Fatalf("destinations share a message: %p %p %p", msg, got, other)
+	}
+	got.Meta["k"] = "changed"
+	if other.Meta["k"] != "v" || msg.Meta["k"] != "v" {
+		t.Error("copies share meta")
+	}
+	if next, _ := f.Receive(ctx); next == nil || next.ID == msg.ID {
+		t.Errorf("Receive = %v, want the first destination's second message", next)
+	}
+}
+
+func TestFanOutExpired(t *testing.T) {
+	a, b := &switchTransport{}, &switchTransport{}
+	a.setErr(ErrExpired)
+	b.setErr(ErrExpired)
+	if err := NewFanOut(a, b).Send(context.Background(), ping(t)); err != ErrExpired {
+		t.Errorf("Send = %v, want ErrExpired", err)
+	}
+
+	b.setErr(errors.New("down"))
+	err := NewFanOut(a, b).Send(context.Background(), ping(t))
+	if err == nil || err == ErrExpired || !strings.Contains(err.Error(), "2 of 2") {
+		t.Errorf("Send = %v, want a fan-out error", err)
+	}
+}
+
+func TestFanOutInvalid(t *testing.T) {
+	a, b := &switchTransport{}, &switchTransport{}
+	for name, f := range map[string]*FanOut{
+		"no destinations":  NewFanOut(),
+		"quorum too large": NewFanOut(a, b).WithPolicy(FanOutPolicy{Mode: FanOutQuorum, Quorum: 3}),
+		"negative quorum":  NewFanOut(a, b).WithPolicy(FanOutPolicy{Mode: FanOutQuorum, Quorum: -1}),
+	} {
+		err := f.Send(context.Background(), ping(t))
+		if misterrors.Code(err) != misterrors.CodeValidation || misterrors.IsRetryable(err) {
+			t.Errorf("%s: Send = %v, want a permanent validation error", name, err)
+		}
+	}
+	if a.count() != 0 || b.count() != 0 {
+		t.Errorf("invalid fan-outs sent %d and %d messages", a.count(), b.count())
+	}
+
+	f := NewFanOut(a, b).WithPolicy(FanOutPolicy{Mode: FanOutQuorum, Quorum: 3}).WithPolicy(FanOutPolicy{Mode: FanOutQuorum, Quorum: 2})
+	if err := f.Send(context.Background(), ping(t)); err != nil {
+		t.Errorf("valid quorum after an invalid one: %v", err)
+	}
+}
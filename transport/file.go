@@ -2,39 +2,114 @@ package transport
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/secrets"
 )
 
 // File reads and writes messages as JSON lines to a file. This is useful
 // for batch pipelines, CI/CD, and offline evaluation workflows where
 // tools run sequentially rather than as concurrent services.
+//
+// A File is an AckReceiver. With SetResume it keeps its progress next to
+// the file, so a reader that restarts resumes after the last message it
+// acked.
 type File struct {
-	path    string
-	mu      sync.Mutex
-	writer  *os.File
-	scanner *bufio.Scanner
-	reader  *os.File
+	path     string
+	mu       sync.Mutex
+	writer   *os.File
+	scanner  *bufio.Scanner
+	reader   *os.File
+	zreader  io.Closer
+	size     sizeLimit
+	compress Compressor
+	encrypt  *secrets.Keyring
+	lines    int64 // lines in the file, counted when sealing appends
+	resume   bool
+	acks     *fileAcks // loaded by the first ReceiveDelivery
+	requeue  requeue
 }
 
 // NewFile creates a file transport for the given path. The file is
 // opened for appending (send) and reading (receive).
-// The path is resolved to an absolute path and validated.
+// The path is resolved to an absolute path and validated. Paths ending in
+// ".gz" are gzip-compressed, and ".zst" zstd-compressed once a zstd
+// Compressor is registered.
 func NewFile(path string) (*File, error) {
 	abs, err := filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("file transport: invalid path: %w", err)
 	}
-	return &File{path: abs}, nil
+	c, err := compressorForPath(abs)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: abs, size: sizeLimit{max: DefaultMaxMessageSize}, compress: c}, nil
+}
+
+// SetCompression compresses the file with c regardless of its extension,
+// or turns compression off if c is nil. Each Send or SendBatch appends a
+// complete compressed member, so batching compresses better and the file
+// stays readable if the writer stops at any point. Call it before the
+// first Send or Receive.
+func (f *File) SetCompression(c Compressor) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.compress = c
+}
+
+// SetEncryption encrypts what each Send or SendBatch appends with the
+// primary key of ring (AES-256-GCM), after any compression, as one line
+// written by secrets.Keyring.SealLineAt, so only one File should append
+// to the file at a time. Reads open lines sealed with any key in ring
+// and refuse plaintext ones, unless ring is from AllowPlaintext, as for
+// appending to an existing file with encryption on. Files are rekeyed,
+// or encrypted in place, with secrets.RekeyFile, unless compressed. Call
+// it before the first Send or Receive.
+func (f *File) SetEncryption(ring *secrets.Keyring) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.encrypt = ring
+}
+
+// SetMaxMessageSize limits the length of lines written and read. Default
+// DefaultMaxMessageSize. Call it before the first Receive.
+func (f *File) SetMaxMessageSize(n int, reg *metrics.Registry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.size = sizeLimit{max: n, metrics: reg}
+}
+
+// SetResume makes ReceiveDelivery record the File's progress in
+// <path>.ack and resume from it (see ReceiveDelivery). It is off by
+// default, so every reader starts at the top of the file and nothing is
+// written beside it. Call it before the first Receive.
+func (f *File) SetResume(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resume = on
 }
 
 // Send appends a JSON-encoded message as a single line to the file.
-func (f *File) Send(_ context.Context, msg *protocol.Message) error {
+func (f *File) Send(ctx context.Context, msg *protocol.Message) error {
+	return f.SendBatch(ctx, []*protocol.Message{msg})
+}
+
+// SendBatch appends msgs as JSON lines in a single write. If any message
+// fails to encode or is too large, nothing is written.
+func (f *File) SendBatch(_ context.Context, msgs []*protocol.Message) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -43,17 +118,69 @@ func (f *File) Send(_ context.Context, msg *protocol.Message) error {
 		if err != nil {
 			return fmt.Errorf("file transport: %w", err)
 		}
+		if f.encrypt != nil {
+			if f.lines, err = countLines(f.path); err != nil {
+				w.Close()
+				return err
+			}
+		}
 		f.writer = w
 	}
 
-	data, err := msg.Marshal()
-	if err != nil {
-		return fmt.Errorf("file transport: marshal: %w", err)
+	var buf []byte
+	for _, msg := range msgs {
+		data, err := msg.Marshal()
+		if err != nil {
+			return fmt.Errorf("file transport: marshal: %w", err)
+		}
+		if err := f.size.check("file", "send", len(data), msg.Type); err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+	if f.compress != nil {
+		var err error
+		if buf, err = compress(f.compress, buf); err != nil {
+			return fmt.Errorf("file transport: compress: %w", err)
+		}
+	}
+	if f.encrypt != nil {
+		line, err := f.encrypt.SealLineAt(buf, f.lines)
+		if err != nil {
+			return fmt.Errorf("file transport: encrypt: %w", err)
+		}
+		buf = append(line, '\n')
+	}
+
+	if _, err := f.writer.Write(buf); err != nil {
+		return err
+	}
+	if f.encrypt != nil {
+		f.lines++
 	}
-	data = append(data, '\n')
+	return nil
+}
 
-	_, err = f.writer.Write(data)
-	return err
+// countLines returns the number of lines in the file at path, the line
+// number the next sealed append is authenticated with.
+func countLines(path string) (int64, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("file transport: %w", err)
+	}
+	defer r.Close()
+	var n int64
+	buf := make([]byte, 64*1024)
+	for {
+		k, err := r.Read(buf)
+		n += int64(bytes.Count(buf[:k], []byte{'\n'}))
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("file transport: %w", err)
+		}
+	}
 }
 
 // Receive reads the next JSON line from the file. It returns io.EOF
@@ -62,37 +189,211 @@ func (f *File) Receive(_ context.Context) (*protocol.Message, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	line, err := f.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return protocol.Unmarshal(line)
+}
+
+// ReceiveDelivery reads the next message for at-least-once processing.
+// Nacked messages are delivered again before reading on. It returns
+// io.EOF when no more lines are available. Use either Receive or
+// ReceiveDelivery on a File, not both.
+//
+// With SetResume, the File records in <path>.ack how many messages from
+// the start of the file have been acked, in order, and the first
+// ReceiveDelivery skips that many: after a restart, reading resumes at
+// the first message not yet acked, so those in flight are delivered
+// again. The count is saved at most once per ackSaveInterval and on
+// Close, so a crash may also deliver again messages acked just before
+// it.
+func (f *File) ReceiveDelivery(_ context.Context) (*Delivery, error) {
+	if r, ok := f.requeue.pop(); ok {
+		return f.delivery(r), nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.acks == nil {
+		acks := &fileAcks{acked: make(map[int64]bool)}
+		if f.resume {
+			var err error
+			if acks, err = loadFileAcks(f.path + ".ack"); err != nil {
+				return nil, err
+			}
+		}
+		for acks.read < acks.committed {
+			if _, err := f.readLine(); err != nil {
+				return nil, err
+			}
+			acks.read++
+		}
+		f.acks = acks
+	}
+
+	line, err := f.readLine()
+	if err != nil {
+		return nil, err
+	}
+	seq := f.acks.read
+	f.acks.read++
+	msg, err := protocol.Unmarshal(line)
+	if err != nil {
+		// A line that can't be decoded will never be delivered; settle
+		// it so it doesn't hold back the acks after it.
+		if ackErr := f.acks.ack(seq); ackErr != nil {
+			return nil, ackErr
+		}
+		return nil, err
+	}
+	return f.delivery(redelivery{msg: msg, seq: seq, attempt: 1}), nil
+}
+
+// delivery wraps a message read from position r.seq of the file.
+func (f *File) delivery(r redelivery) *Delivery {
+	return &Delivery{Message: r.msg, Attempt: r.attempt, settle: func(ack bool) error {
+		if !ack {
+			r.attempt++
+			f.requeue.push(r)
+			return nil
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.acks.ack(r.seq)
+	}}
+}
+
+// readLine returns the next line of the file. Must be called with mu
+// held.
+func (f *File) readLine() ([]byte, error) {
 	if f.scanner == nil {
 		r, err := os.Open(f.path)
 		if err != nil {
 			return nil, fmt.Errorf("file transport: %w", err)
 		}
 		f.reader = r
-		f.scanner = bufio.NewScanner(r)
-		f.scanner.Buffer(make([]byte, 1<<20), 1<<20) // 1MB line buffer
+		var src io.Reader = r
+		if f.encrypt != nil {
+			src = f.encrypt.NewReader(src)
+		}
+		if f.compress != nil {
+			zr, err := f.compress.NewReader(src)
+			if err != nil {
+				r.Close()
+				f.reader = nil
+				if errors.Is(err, io.EOF) {
+					return nil, fmt.Errorf("file transport: no more messages: %w", io.EOF)
+				}
+				return nil, fmt.Errorf("file transport: %w", err)
+			}
+			f.zreader, src = zr, zr
+		}
+		f.scanner = f.size.scanner(src)
 	}
 
 	if !f.scanner.Scan() {
-		if err := f.scanner.Err(); err != nil {
-			return nil, err
+		// A compressed member cut short is a write still in progress, or
+		// one interrupted; either way there is nothing more to read.
+		if err := f.scanner.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, f.size.scanErr("file", err)
 		}
-		return nil, fmt.Errorf("file transport: no more messages")
+		return nil, fmt.Errorf("file transport: no more messages: %w", io.EOF)
 	}
+	return f.scanner.Bytes(), nil
+}
 
-	return protocol.Unmarshal(f.scanner.Bytes())
+// ackSaveInterval is how often a File with SetResume saves its committed
+// count while acks keep advancing it.
+const ackSaveInterval = time.Second
+
+// fileAcks tracks which messages of a File have been acked.
+type fileAcks struct {
+	path      string         // empty unless the File resumes
+	committed int64          // messages from the start acked, in order
+	read      int64          // messages read so far
+	acked     map[int64]bool // acked messages past committed
+	saved     int64          // committed count last saved to path
+	savedAt   time.Time
+}
+
+// loadFileAcks reads the committed count saved at path, if any.
+func loadFileAcks(path string) (*fileAcks, error) {
+	a := &fileAcks{path: path, acked: make(map[int64]bool)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file transport: acks: %w", err)
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("file transport: acks: %s: invalid count %q", path, strings.TrimSpace(string(data)))
+	}
+	a.committed, a.saved = n, n
+	return a, nil
+}
+
+// ack records message seq as acked, saving the committed count if it
+// advanced and ackSaveInterval has passed since the last save.
+func (a *fileAcks) ack(seq int64) error {
+	a.acked[seq] = true
+	for a.acked[a.committed] {
+		delete(a.acked, a.committed)
+		a.committed++
+	}
+	if time.Since(a.savedAt) < ackSaveInterval {
+		return nil
+	}
+	return a.save()
 }
 
-// Close releases file handles.
+// save writes the committed count, if it changed, via a temporary file so
+// a crash never leaves a torn count behind.
+func (a *fileAcks) save() error {
+	if a.path == "" || a.committed == a.saved {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), ".ack-*")
+	if err != nil {
+		return fmt.Errorf("file transport: acks: %w", err)
+	}
+	if _, err := tmp.WriteString(strconv.FormatInt(a.committed, 10) + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("file transport: acks: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("file transport: acks: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("file transport: acks: %w", err)
+	}
+	a.saved, a.savedAt = a.committed, time.Now()
+	return nil
+}
+
+// Close saves any acks not yet saved and releases file handles.
 func (f *File) Close() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	var firstErr error
+	if f.acks != nil {
+		firstErr = f.acks.save()
+	}
 	if f.writer != nil {
-		if err := f.writer.Close(); err != nil {
+		if err := f.writer.Close(); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
+	if f.zreader != nil {
+		f.zreader.Close()
+	}
 	if f.reader != nil {
 		if err := f.reader.Close(); err != nil && firstErr == nil {
 			firstErr = err
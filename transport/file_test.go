@@ -2,11 +2,15 @@ package transport
 
 import (
 	"context"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/secrets"
 )
 
 func TestFileSendReceive(t *testing.T) {
@@ -83,3 +87,187 @@ func TestFileClose(t *testing.T) {
 		t.Fatalf("Close: %v", err)
 	}
 }
+
+func TestFileSendBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batch.jsonl")
+	ft, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Close()
+
+	ctx := context.Background()
+	msgs := []*protocol.Message{sizedMessage(t, 10), sizedMessage(t, 20)}
+	if err := ft.SendBatch(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	// An oversized message fails the whole batch.
+	ft.SetMaxMessageSize(256, nil)
+	if err := ft.SendBatch(ctx, []*protocol.Message{sizedMessage(t, 10), sizedMessage(t, 1024)}); err == nil {
+		t.Fatal("oversized batch succeeded")
+	}
+
+	for _, want := range msgs {
+		got, err := ft.Receive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != want.ID {
+			t.Errorf("received %s, want %s", got.ID, want.ID)
+		}
+	}
+	if _, err := ft.Receive(ctx); err == nil {
+		t.Error("rejected batch was partly written")
+	}
+}
+
+func TestFileGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.jsonl.gz")
+	ft, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Empty file reads as end of stream.
+	os.WriteFile(path, nil, 0o600)
+	if _, err := ft.Receive(ctx); !errors.Is(err, io.EOF) {
+		t.Fatalf("Receive on empty file = %v, want EOF", err)
+	}
+
+	var msgs []*protocol.Message
+	for i := 0; i < 20; i++ {
+		msgs = append(msgs, sizedMessage(t, 500))
+	}
+	if err := ft.SendBatch(ctx, msgs[:10]); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range msgs[10:] {
+		if err := ft.Send(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ft.Close()
+
+	raw, _ := os.ReadFile(path)
+	if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		t.Fatal("file is not gzip")
+	}
+	if len(raw) > 20*500/2 {
+		t.Errorf("compressed file is %d bytes", len(raw))
+	}
+
+	rt, _ := NewFile(path)
+	defer rt.Close()
+	for i, want := range msgs {
+		got, err := rt.Receive(ctx)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if got.ID != want.ID {
+			t.Fatalf("message %d = %s, want %s", i, got.ID, want.ID)
+		}
+	}
+	if _, err := rt.Receive(ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("Receive after last = %v, want EOF", err)
+	}
+
+	if _, err := NewFile(filepath.Join(t.TempDir(), "x.jsonl.zst")); err == nil {
+		t.Error("zst path without a registered zstd compressor succeeded")
+	}
+}
+
+func TestFileEncryption(t *testing.T) {
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MIST_TEST_FILE_KEY", key.String())
+	ctx := context.Background()
+
+	for _, tc := range []struct{ name, file, query string }{
+		{"plain", "archive.jsonl", "?key=env:MIST_TEST_FILE_KEY&allow_plaintext"},
+		{"gzip", "archive.jsonl.gz", "?key=env:MIST_TEST_FILE_KEY"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			// A plaintext message from before encryption was turned on.
+			legacy := sizedMessage(t, 100)
+			if tc.name == "plain" {
+				data, _ := legacy.Marshal()
+				os.WriteFile(path, append(data, '\n'), 0o600)
+			}
+
+			ft, err := Dial("file://" + path + tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msgs := []*protocol.Message{sizedMessage(t, 200), sizedMessage(t, 200)}
+			ft.(*File).SendBatch(ctx, msgs[:1])
+			ft.Send(ctx, msgs[1])
+			ft.Close()
+
+			raw, _ := os.ReadFile(path)
+			if strings.Contains(string(raw), msgs[0].ID) {
+				t.Fatal("message ID written in plaintext")
+			}
+
+			if tc.name == "plain" {
+				msgs = append([]*protocol.Message{legacy}, msgs...)
+			}
+			rt, _ := Dial("file://" + path + tc.query)
+			defer rt.Close()
+			for i, want := range msgs {
+				got, err := rt.Receive(ctx)
+				if err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+				if got.ID != want.ID {
+					t.Fatalf("message %d = %s, want %s", i, got.ID, want.ID)
+				}
+			}
+			if _, err := rt.Receive(ctx); !errors.Is(err, io.EOF) {
+				t.Errorf("Receive after last = %v, want EOF", err)
+			}
+
+			// Without the key the sealed lines can't be read.
+			other, _ := secrets.GenerateKey()
+			wrong, _ := NewFile(path)
+			wrong.SetEncryption(secrets.NewKeyring(other).AllowPlaintext())
+			defer wrong.Close()
+			if tc.name == "plain" {
+				wrong.Receive(ctx) // the legacy line
+			}
+			if _, err := wrong.Receive(ctx); !errors.Is(err, secrets.ErrUnknownKey) {
+				t.Errorf("Receive with wrong key = %v, want ErrUnknownKey", err)
+			}
+
+			// Unless allowed, a plaintext line, such as one appended by
+			// anyone who can write the file, is refused.
+			forged, _ := sizedMessage(t, 100).Marshal()
+			w, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+			w.Write(append(forged, '\n'))
+			w.Close()
+			strict, _ := Dial("file://" + path + "?key=env:MIST_TEST_FILE_KEY")
+			defer strict.Close()
+			var rerr error
+			for rerr == nil {
+				_, rerr = strict.Receive(ctx)
+			}
+			if !errors.Is(rerr, secrets.ErrPlaintext) {
+				t.Errorf("Receive of a plaintext line = %v, want ErrPlaintext", rerr)
+			}
+		})
+	}
+
+	if _, err := Dial("file:///tmp/x.jsonl?key=env:MIST_TEST_UNSET_KEY"); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("unset key: err = %v, want ErrInvalidOption", err)
+	}
+	if _, err := Dial("file:///tmp/x.jsonl?old_key=env:MIST_TEST_FILE_KEY"); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("old_key without key: err = %v, want ErrInvalidOption", err)
+	}
+	if _, err := Dial("file:///tmp/x.jsonl?allow_plaintext"); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("allow_plaintext without key: err = %v, want ErrInvalidOption", err)
+	}
+}
@@ -0,0 +1,482 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// The gRPC transport carries messages over one bidirectional streaming
+// RPC, /mist.Transport/Stream, using gRPC's HTTP/2 framing with the JSON
+// message encoding (content type application/grpc+json). Any gRPC
+// runtime with a JSON codec can talk to it; no protobuf definitions are
+// needed.
+const (
+	GRPCStreamPath  = "/mist.Transport/Stream"
+	grpcContentType = "application/grpc+json"
+
+	// grpcDialTimeout bounds opening a stream.
+	grpcDialTimeout = 10 * time.Second
+)
+
+// gRPC status codes used in stream trailers.
+const (
+	grpcOK          = "0"
+	grpcUnavailable = "14"
+)
+
+// GRPC is the client side of the gRPC transport: Send writes to the
+// stream and Receive reads messages the server sends back. A broken
+// stream fails every later call; Dial("grpc://...") wraps GRPC in
+// Resilient so a new stream is opened automatically.
+type GRPC struct {
+	target string
+	cancel context.CancelFunc
+
+	wmu  sync.Mutex // serializes frames on body
+	body *io.PipeWriter
+
+	inbox chan *protocol.Message
+	done  chan struct{} // closed when the stream ends
+	err   error         // why the stream ended; set before done closes
+
+	mu   sync.Mutex
+	size sizeLimit
+}
+
+// DialGRPC opens a stream to a GRPCServer at target, a grpc:// URL for
+// cleartext HTTP/2 (the usual case inside a cluster or service mesh) or
+// grpcs:// for TLS:
+//
+//	t, err := transport.DialGRPC("grpc://tokentrace.mist.svc:9090")
+func DialGRPC(target string) (*GRPC, error) {
+	scheme, host := splitScheme(target)
+	host = strings.TrimSuffix(host, "/")
+	var protocols http.Protocols
+	url := "http://" + host + GRPCStreamPath
+	switch scheme {
+	case "grpc":
+		protocols.SetUnencryptedHTTP2(true)
+	case "grpcs":
+		protocols.SetHTTP2(true)
+		url = "https://" + host + GRPCStreamPath
+	default:
+		return nil, fmt.Errorf("grpc transport: unsupported scheme %q in %q", scheme, target)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Protocols:       &protocols,
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, pr)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("grpc transport: %w", err)
+	}
+	req.Header.Set("Content-Type", grpcContentType)
+	req.Header.Set("TE", "trailers")
+
+	// Do returns once the server sends response headers, while the
+	// request body stays open for Send.
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		resp, err := client.Do(req)
+		ch <- result{resp, err}
+	}()
+	var res result
+	select {
+	case res = <-ch:
+	case <-time.After(grpcDialTimeout):
+		cancel()
+		pw.Close()
+		return nil, misterrors.Newf(misterrors.CodeTimeout, "grpc transport: dial %s: timed out", host)
+	}
+	if res.err != nil {
+		cancel()
+		pw.Close()
+		return nil, misterrors.Wrapf(misterrors.CodeTransport, res.err, "grpc transport: dial %s", host)
+	}
+	if err := grpcResponseErr(res.resp); err != nil {
+		res.resp.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, err
+	}
+
+	g := &GRPC{
+		target: target,
+		cancel: cancel,
+		body:   pw,
+		inbox:  make(chan *protocol.Message, 256),
+		done:   make(chan struct{}),
+		size:   sizeLimit{max: DefaultMaxMessageSize},
+	}
+	go g.readLoop(ctx, res.resp)
+	return g, nil
+}
+
+// grpcResponseErr checks the response headers of a new stream. A server
+// that rejects the call outright sends grpc-status in the headers.
+func grpcResponseErr(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return misterrors.Newf(misterrors.CodeTransport, "grpc transport: status %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+		return misterrors.Newf(misterrors.CodeProtocol, "grpc transport: unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	if s := resp.Header.Get("Grpc-Status"); s != "" && s != grpcOK {
+		return misterrors.Newf(misterrors.CodeUnavailable, "grpc transport: status %s: %s", s, resp.Header.Get("Grpc-Message"))
+	}
+	return nil
+}
+
+// readLoop decodes messages from the response until the stream ends.
+func (g *GRPC) readLoop(ctx context.Context, resp *http.Response) {
+	defer resp.Body.Close()
+	var err error
+	for {
+		var data []byte
+		if data, err = readGRPCFrame(resp.Body, g.limit()); err != nil {
+			break
+		}
+		msg, uerr := protocol.Unmarshal(data)
+		if uerr != nil || msg.Expired(time.Now()) {
+			continue
+		}
+		select {
+		case g.inbox <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	switch {
+	case errors.Is(err, io.EOF):
+		// Trailers are only available once the body is drained.
+		status, text := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+		if status == "" || status == grpcOK {
+			text = "stream closed by server"
+		}
+		err = misterrors.Newf(misterrors.CodeUnavailable, "grpc transport: %s", text)
+	case misterrors.Code(err) == misterrors.CodeValidation:
+		// Oversized frame; the stream can't be resynchronized.
+	default:
+		err = misterrors.Wrap(misterrors.CodeTransport, err, "grpc transport: receive")
+	}
+	g.err = err
+	close(g.done)
+}
+
+// SetMaxMessageSize limits the size of messages sent and received.
+// Default DefaultMaxMessageSize.
+func (g *GRPC) SetMaxMessageSize(n int, reg *metrics.Registry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.size = sizeLimit{max: n, metrics: reg}
+}
+
+func (g *GRPC) limit() sizeLimit {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.size
+}
+
+// Send writes msg to the stream. If ctx has a deadline and msg does not,
+// the deadline travels with the message and the server drops the message
+// once it has passed.
+func (g *GRPC) Send(ctx context.Context, msg *protocol.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-g.done:
+		return g.err
+	default:
+	}
+	if d, ok := ctx.Deadline(); ok && msg.DeadlineNS == 0 {
+		cp := *msg
+		cp.DeadlineNS = d.UnixNano()
+		msg = &cp
+	}
+	data, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("grpc transport: marshal: %w", err)
+	}
+	if err := g.limit().check("grpc", "send", len(data), msg.Type); err != nil {
+		return err
+	}
+
+	g.wmu.Lock()
+	defer g.wmu.Unlock()
+	if _, err := g.body.Write(grpcFrame(data)); err != nil {
+		return misterrors.Wrap(misterrors.CodeTransport, err, "grpc transport: send")
+	}
+	return nil
+}
+
+// Receive returns the next message sent by the server.
+func (g *GRPC) Receive(ctx context.Context) (*protocol.Message, error) {
+	select {
+	case msg := <-g.inbox:
+		return msg, nil
+	default:
+	}
+	select {
+	case msg := <-g.inbox:
+		return msg, nil
+	case <-g.done:
+		return nil, g.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close ends the stream.
+func (g *GRPC) Close() error {
+	g.wmu.Lock()
+	g.body.Close()
+	g.wmu.Unlock()
+	g.cancel()
+	<-g.done
+	return nil
+}
+
+// GRPCServer is the server side of the gRPC transport. It accepts streams
+// from any number of GRPC clients: Receive returns messages from all of
+// them, and Send delivers each message to one connected client.
+type GRPCServer struct {
+	inbox  chan *protocol.Message
+	outbox chan *protocol.Message
+	done   chan struct{}
+	once   sync.Once
+
+	mu   sync.Mutex
+	srv  *http.Server
+	size sizeLimit
+}
+
+// NewGRPCServer creates a gRPC transport server. Start it with
+// ListenAndServe or Serve, or mount it as an http.Handler on an HTTP/2
+// server.
+func NewGRPCServer() *GRPCServer {
+	return &GRPCServer{
+		inbox:  make(chan *protocol.Message, 256),
+		outbox: make(chan *protocol.Message, 256),
+		done:   make(chan struct{}),
+		size:   sizeLimit{max: DefaultMaxMessageSize},
+	}
+}
+
+// SetMaxMessageSize limits the size of messages sent and received. A
+// stream that sends an oversized message is ended. Default
+// DefaultMaxMessageSize.
+func (s *GRPCServer) SetMaxMessageSize(n int, reg *metrics.Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = sizeLimit{max: n, metrics: reg}
+}
+
+func (s *GRPCServer) limit() sizeLimit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// ListenAndServe accepts cleartext HTTP/2 streams on addr. TLS is
+// expected to be terminated in front of the server, as by a service mesh
+// or ingress.
+func (s *GRPCServer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("grpc transport: %w", err)
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts cleartext HTTP/2 streams on ln.
+func (s *GRPCServer) Serve(ln net.Listener) error {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	s.mu.Lock()
+	s.srv = &http.Server{
+		Handler:           s,
+		Protocols:         &protocols,
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+	srv := s.srv
+	s.mu.Unlock()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP handles one stream.
+func (s *GRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != GRPCStreamPath {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "expected application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	limit := s.limit()
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			data, err := readGRPCFrame(r.Body, limit)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			msg, err := protocol.Unmarshal(data)
+			if err != nil || msg.Expired(time.Now()) {
+				continue
+			}
+			select {
+			case s.inbox <- msg:
+			case <-r.Context().Done():
+				readErr <- r.Context().Err()
+				return
+			}
+		}
+	}()
+
+	status, text := grpcOK, ""
+	defer func() {
+		w.Header().Set("Grpc-Status", status)
+		if text != "" {
+			w.Header().Set("Grpc-Message", text)
+		}
+	}()
+	for {
+		select {
+		case msg := <-s.outbox:
+			data, err := msg.Marshal()
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write(grpcFrame(data)); err != nil {
+				return
+			}
+			flusher.Flush()
+		case err := <-readErr:
+			if !errors.Is(err, io.EOF) {
+				status, text = grpcUnavailable, err.Error()
+			}
+			return
+		case <-s.done:
+			status, text = grpcUnavailable, "server shutting down"
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Send queues msg for delivery to a connected client, blocking while the
+// queue is full. Delivery is at most once: a message taken by a stream
+// that then breaks is lost.
+func (s *GRPCServer) Send(ctx context.Context, msg *protocol.Message) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("grpc transport: marshal: %w", err)
+	}
+	if err := s.limit().check("grpc", "send", len(data), msg.Type); err != nil {
+		return err
+	}
+	select {
+	case s.outbox <- msg:
+		return nil
+	case <-s.done:
+		return misterrors.New(misterrors.CodeUnavailable, "grpc transport: server closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Receive returns the next message from any client.
+func (s *GRPCServer) Receive(ctx context.Context) (*protocol.Message, error) {
+	select {
+	case msg := <-s.inbox:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close ends every stream and stops the server.
+func (s *GRPCServer) Close() error {
+	s.once.Do(func() { close(s.done) })
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// grpcFrame prefixes data with the gRPC message header: an uncompressed
+// flag and the big-endian length.
+func grpcFrame(data []byte) []byte {
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+// readGRPCFrame reads one length-prefixed message, refusing compressed
+// messages and any over the limit before allocating for them.
+func readGRPCFrame(r io.Reader, limit sizeLimit) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, misterrors.New(misterrors.CodeProtocol, "grpc transport: compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if err := limit.check("grpc", "receive", int(n), ""); err != nil {
+		return nil, err
+	}
+	if n > protocol.MaxMessageSize {
+		return nil, misterrors.Newf(misterrors.CodeProtocol, "grpc transport: %d byte message exceeds protocol maximum", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
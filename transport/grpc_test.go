@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// serveGRPC starts srv on addr, or a free local port when addr is empty,
+// and returns the address.
+func serveGRPC(t *testing.T, srv *GRPCServer, addr string) string {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	var ln net.Listener
+	var err error
+	for i := 0; i < 100; i++ {
+		if ln, err = net.Listen("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	srv := NewGRPCServer()
+	addr := serveGRPC(t, srv, "")
+
+	client, err := DialGRPC("grpc://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	msg := sizedMessage(t, 10)
+	if err := client.Send(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := srv.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != msg.ID || string(got.Payload) != string(msg.Payload) {
+		t.Errorf("server received %+v", got)
+	}
+
+	reply := sizedMessage(t, 20)
+	if err := srv.Send(ctx, reply); err != nil {
+		t.Fatal(err)
+	}
+	got, err = client.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != reply.ID {
+		t.Errorf("client received %s, want %s", got.ID, reply.ID)
+	}
+}
+
+func TestGRPCDeadline(t *testing.T) {
+	srv := NewGRPCServer()
+	addr := serveGRPC(t, srv, "")
+	client, err := DialGRPC("grpc://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	msg := sizedMessage(t, 10)
+	if err := client.Send(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.DeadlineNS != 0 {
+		t.Error("Send modified the caller's message")
+	}
+	got, err := srv.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DeadlineNS != deadline.UnixNano() {
+		t.Errorf("DeadlineNS = %d, want %d", got.DeadlineNS, deadline.UnixNano())
+	}
+
+	// A message whose deadline passed in flight is dropped by the server.
+	expired := sizedMessage(t, 10)
+	expired.DeadlineNS = time.Now().Add(-time.Second).UnixNano()
+	live := sizedMessage(t, 10)
+	for _, m := range []*protocol.Message{expired, live} {
+		if err := client.Send(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err = srv.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != live.ID {
+		t.Errorf("received %s, want the live message %s", got.ID, live.ID)
+	}
+}
+
+func TestGRPCMaxMessageSize(t *testing.T) {
+	srv := NewGRPCServer()
+	addr := serveGRPC(t, srv, "")
+	client, err := DialGRPC("grpc://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.SetMaxMessageSize(256, nil)
+	err = client.Send(context.Background(), sizedMessage(t, 1024))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Send = %v, want ErrMessageTooLarge", err)
+	}
+	if err := client.Send(context.Background(), sizedMessage(t, 10)); err != nil {
+		t.Errorf("small message after rejection: %v", err)
+	}
+}
+
+func TestGRPCServerClose(t *testing.T) {
+	srv := NewGRPCServer()
+	addr := serveGRPC(t, srv, "")
+	client, err := DialGRPC("grpc://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := client.Receive(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Receive after server close = %v, want stream error", err)
+	}
+}
+
+func TestGRPCDialReconnects(t *testing.T) {
+	srv := NewGRPCServer()
+	addr := serveGRPC(t, srv, "")
+
+	tr, err := Dial("grpc://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Send(ctx, sizedMessage(t, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart the server on the same address; the client opens a new
+	// stream once it notices the old one is gone.
+	srv.Close()
+	srv2 := NewGRPCServer()
+	serveGRPC(t, srv2, addr)
+
+	msg := sizedMessage(t, 10)
+	got := make(chan *protocol.Message, 1)
+	go func() {
+		if m, err := srv2.Receive(ctx); err == nil {
+			got <- m
+		}
+	}()
+	for {
+		tr.Send(ctx, msg)
+		select {
+		case m := <-got:
+			if m.ID != msg.ID {
+				t.Errorf("received %s, want %s", m.ID, msg.ID)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("no message after server restart")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
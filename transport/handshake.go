@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Handshake agrees on an envelope version with the peer on t by sending
+// a control.handshake request and waiting for the reply, as Request
+// does. Pass the result to WithPeerVersion so messages sent to the peer
+// are converted to a version it reads:
+//
+//	v, err := transport.Handshake(ctx, t, "matchspec")
+//	if misterrors.Is(err, transport.ErrNoReply) {
+//		v = protocol.CurrentVersion // a peer that predates handshakes
+//	}
+//	t = transport.Wrap(t, transport.WithPeerVersion(v))
+func Handshake(ctx context.Context, t Transport, source string) (string, error) {
+	req, err := protocol.NewHandshake(source)
+	if err != nil {
+		return "", err
+	}
+	reply, err := Request(ctx, t, req)
+	if err != nil {
+		return "", err
+	}
+	v, err := protocol.HandshakeVersion(reply)
+	if err != nil {
+		return "", misterrors.Wrap(misterrors.CodeProtocol, err, "transport: handshake").Permanent()
+	}
+	return v, nil
+}
+
+// HandleHandshake answers a control.handshake POSTed to an HTTP ingest
+// endpoint with the agreed version, written as the response body for
+// HTTP.Request to read, and reports whether msg was one. Services call it
+// before taking msg as work, as they do DrainGate.HandleControl.
+func HandleHandshake(w http.ResponseWriter, source string, msg *protocol.Message) bool {
+	if msg.Type != protocol.TypeControlHandshake {
+		return false
+	}
+	// A range with no overlap is still answered: the reply carries the
+	// error for the requester.
+	reply, err := protocol.AnswerHandshake(source, msg)
+	if reply == nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	w.Header().Set("Content-Type", protocol.JSON.ContentType())
+	json.NewEncoder(w).Encode(reply)
+	return true
+}
+
+// WithPeerVersion converts outgoing messages to the envelope version the
+// peer reads, upgrading or downgrading them, so a relay between old and
+// new services forwards each message in a form its destination can
+// parse. Messages this library can't convert are refused with a
+// protocol error instead of being sent half-understood. Handshakes are
+// sent unchanged.
+func WithPeerVersion(version string) MiddlewareOption {
+	return func(m *Middleware) { m.peerVersion = version }
+}
+
+// convert rewrites msg for the peer version set with WithPeerVersion.
+func (m *Middleware) convert(msg *protocol.Message) error {
+	if m.peerVersion == "" || msg.Version == m.peerVersion || msg.Type == protocol.TypeControlHandshake {
+		return nil
+	}
+	if err := msg.ConvertTo(m.peerVersion); err != nil {
+		return misterrors.Wrap(misterrors.CodeProtocol, err, "transport").Permanent()
+	}
+	return nil
+}
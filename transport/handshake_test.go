@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestHandshake(t *testing.T) {
+	client, server := NewChannelPair(16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for msg, err := range Messages(ctx, server) {
+			if err != nil {
+				return
+			}
+			if msg.Type == protocol.TypeControlHandshake {
+				reply, _ := protocol.AnswerHandshake("server", msg)
+				server.Send(ctx, reply)
+			}
+		}
+	}()
+
+	v, err := Handshake(ctx, client, "client")
+	if err != nil || v != protocol.MaxSupportedVersion {
+		t.Fatalf("Handshake = %q, %v", v, err)
+	}
+}
+
+func TestHandshakeNoReply(t *testing.T) {
+	client, _ := NewChannelPair(16)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Handshake(ctx, client, "client"); !errors.Is(err, ErrNoReply) {
+		t.Errorf("err = %v, want ErrNoReply", err)
+	}
+}
+
+func TestHandshakeHTTP(t *testing.T) {
+	srv := NewHTTP("")
+	addr := listenHTTP(t, srv)
+	client := NewHTTP("http://" + addr + "/mist")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	v, err := Handshake(ctx, client, "client")
+	if err != nil || v != protocol.MaxSupportedVersion {
+		t.Fatalf("Handshake = %q, %v", v, err)
+	}
+
+	// Other messages are queued without a reply.
+	ping, _ := protocol.New("client", protocol.TypeHealthPing, protocol.HealthPing{})
+	if _, err := Request(ctx, client, ping); !errors.Is(err, ErrNoReply) {
+		t.Errorf("Request(ping) = %v, want ErrNoReply", err)
+	}
+	if got, err := srv.Receive(ctx); err != nil || got.ID != ping.ID {
+		t.Errorf("Receive = %v, %v; want the ping", got, err)
+	}
+}
+
+func TestWithPeerVersion(t *testing.T) {
+	client, server := NewChannelPair(16)
+	ctx := context.Background()
+	old := Wrap(client, WithPeerVersion("1"))
+
+	msg, _ := protocol.New("new", protocol.TypeHealthPing, protocol.HealthPing{})
+	msg.CorrelationID = "req-1"
+	msg.ConvertTo("2")
+	if err := old.Send(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := server.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "1" || got.CorrelationID != "req-1" {
+		t.Errorf("received version %s, correlation %q", got.Version, got.CorrelationID)
+	}
+
+	future := Wrap(client, WithPeerVersion("9"))
+	if err := future.Send(ctx, msg); err == nil {
+		t.Error("Send converted a message to an unsupported version")
+	}
+}
@@ -4,25 +4,64 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/resource"
 )
 
+// acceptedCodecs lists the Content-Types the listener decodes.
+var acceptedCodecs = func() string {
+	var types []string
+	for _, c := range protocol.Codecs {
+		types = append(types, c.ContentType())
+	}
+	return strings.Join(types, ", ")
+}()
+
 // HTTP sends messages via HTTP POST and receives via an embedded server.
 type HTTP struct {
 	target string // URL to POST messages to
 	client *http.Client
 
-	mu    sync.Mutex
-	inbox chan *protocol.Message
-	srv   *http.Server
+	mu      sync.Mutex
+	inbox   chan redelivery
+	requeue requeue
+	srv     *http.Server
+
+	// unsettled counts messages queued and not yet received, or, from
+	// ReceiveDelivery, not yet acked. A drain waits for it to reach zero.
+	unsettled atomic.Int64
+
+	budget    *resource.MemoryBudget
+	estimator resource.Estimator
+	drain     *DrainGate
+	size      sizeLimit
+	codec     protocol.Codec
+	compress  Compressor
 }
 
+// listenerSource is the source of the replies ListenForMessages writes.
+const listenerSource = "mist"
+
+// compressMinSize is the smallest request body SetCompression compresses;
+// below it the saving does not pay for the work.
+const compressMinSize = 1 << 10
+
+// errUnsupportedEncoding is returned by do when the receiver refuses the
+// request's Content-Encoding.
+var errUnsupportedEncoding = errors.New("http transport: content encoding not supported by receiver")
+
 // NewHTTP creates a transport that POSTs messages to the given URL.
 // Call ListenForMessages to start receiving messages on a local port.
 func NewHTTP(targetURL string) *HTTP {
@@ -40,22 +79,146 @@ func NewHTTP(targetURL string) *HTTP {
 				ForceAttemptHTTP2:  true,
 			},
 		},
-		inbox: make(chan *protocol.Message, 256),
+		inbox: make(chan redelivery, 256),
+		size:  sizeLimit{max: DefaultMaxMessageSize},
+		codec: protocol.JSON,
 	}
 }
 
+// SetCodec sets the wire encoding for sent messages, announced in the
+// Content-Type header. Default protocol.JSON. The listener decodes any
+// built-in codec by Content-Type, so peers can switch independently.
+func (h *HTTP) SetCodec(c protocol.Codec) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.codec = c
+}
+
+// SetCompression compresses request bodies of 1 KiB or more with c,
+// announced in the Content-Encoding header. If the receiver refuses the
+// encoding with 415, the request is resent uncompressed and compression
+// is turned off. nil, the default, sends bodies uncompressed. The
+// listener accepts every registered encoding regardless.
+func (h *HTTP) SetCompression(c Compressor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.compress = c
+}
+
+// SetMaxMessageSize limits the size of messages sent and of request
+// bodies the listener accepts, which it refuses with 413. Default
+// DefaultMaxMessageSize. Call it before ListenForMessages.
+func (h *HTTP) SetMaxMessageSize(n int, reg *metrics.Registry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.size = sizeLimit{max: n, metrics: reg}
+}
+
+func (h *HTTP) limit() sizeLimit {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.size
+}
+
 // Send POSTs a message to the target URL.
 func (h *HTTP) Send(ctx context.Context, msg *protocol.Message) error {
-	data, err := msg.Marshal()
+	h.mu.Lock()
+	codec, limit := h.codec, h.size
+	h.mu.Unlock()
+
+	data, err := codec.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("http transport: marshal: %w", err)
 	}
+	if err := limit.check("http", "send", len(data), msg.Type); err != nil {
+		return err
+	}
+	return h.post(ctx, codec.ContentType(), data, msg.Type)
+}
+
+// SendBatch POSTs msgs as JSON arrays, each holding at most
+// DefaultBatchSize messages and fitting within the message size limit so
+// a listener with the same limit accepts it. With a codec other than
+// JSON it sends one message per request.
+func (h *HTTP) SendBatch(ctx context.Context, msgs []*protocol.Message) error {
+	h.mu.Lock()
+	codec, limit := h.codec, h.size
+	h.mu.Unlock()
+	if codec.Name() != protocol.JSON.Name() {
+		for _, msg := range msgs {
+			if err := h.Send(ctx, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var batch [][]byte
+	size := 1 // the closing bracket
+	flush := func() error {
+		defer func() { batch, size = batch[:0], 1 }()
+		switch len(batch) {
+		case 0:
+			return nil
+		case 1:
+			return h.post(ctx, codec.ContentType(), batch[0], "message")
+		}
+		body := append([]byte{'['}, bytes.Join(batch, []byte{','})...)
+		return h.post(ctx, codec.ContentType(), append(body, ']'), fmt.Sprintf("batch of %d", len(batch)))
+	}
+	for _, msg := range msgs {
+		data, err := msg.Marshal()
+		if err != nil {
+			return fmt.Errorf("http transport: marshal: %w", err)
+		}
+		if err := limit.check("http", "send", len(data), msg.Type); err != nil {
+			return err
+		}
+		full := len(batch) == DefaultBatchSize || (limit.max > 0 && size+len(data)+1 > limit.max)
+		if len(batch) > 0 && full {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, data)
+		size += len(data) + 1
+	}
+	return flush()
+}
 
+// post sends one request body, compressed if SetCompression is in use;
+// what names its contents in errors.
+func (h *HTTP) post(ctx context.Context, contentType string, data []byte, what string) error {
+	h.mu.Lock()
+	c := h.compress
+	h.mu.Unlock()
+
+	if c != nil && len(data) >= compressMinSize {
+		zipped, err := compress(c, data)
+		if err != nil {
+			return fmt.Errorf("http transport: compress: %w", err)
+		}
+		if err := h.do(ctx, contentType, c.Name(), zipped, what); !errors.Is(err, errUnsupportedEncoding) {
+			return err
+		}
+		h.mu.Lock()
+		if h.compress == c {
+			h.compress = nil
+		}
+		h.mu.Unlock()
+	}
+	return h.do(ctx, contentType, "", data, what)
+}
+
+func (h *HTTP) do(ctx context.Context, contentType, encoding string, data []byte, what string) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.target, bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("http transport: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
 
 	resp, err := h.client.Do(req)
 	if err != nil {
@@ -64,43 +227,249 @@ func (h *HTTP) Send(ctx context.Context, msg *protocol.Message) error {
 	defer resp.Body.Close()
 	io.Copy(io.Discard, resp.Body)
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("http transport: status %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusUnsupportedMediaType && encoding != "" && resp.Header.Get("Accept-Encoding") != "" {
+		return errUnsupportedEncoding
+	}
+	return statusError(resp.StatusCode, what)
+}
+
+// statusError returns the error for a response status, or nil for a
+// success; what names the request's contents.
+func statusError(code int, what string) error {
+	if code == http.StatusRequestEntityTooLarge {
+		return misterrors.Wrapf(misterrors.CodeValidation, ErrMessageTooLarge, "http transport: %s rejected by receiver", what)
+	}
+	if code >= 400 {
+		return fmt.Errorf("http transport: status %d", code)
 	}
 	return nil
 }
 
-// Receive blocks until a message is available from the local listener.
+// Request POSTs msg and returns the reply the receiver writes in the
+// response body, as the POST /mist endpoints of mist serve do for
+// infer.request and control.handshake; Request and Handshake use it for
+// an HTTP transport. A receiver that accepts msg without replying, as
+// ListenForMessages does for anything but a handshake, fails it with
+// ErrNoReply.
+func (h *HTTP) Request(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	ctx, cancel := requestContext(ctx, msg)
+	defer cancel()
+	limit := h.limit()
+
+	data, err := msg.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("http transport: marshal: %w", err)
+	}
+	if err := limit.check("http", "send", len(data), msg.Type); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.target, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("http transport: %w", err)
+	}
+	req.Header.Set("Content-Type", protocol.JSON.ContentType())
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, noReply(ctx, msg)
+		}
+		return nil, fmt.Errorf("http transport: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit.readLimit()))
+	if err != nil {
+		return nil, fmt.Errorf("http transport: %w", err)
+	}
+	if err := statusError(resp.StatusCode, msg.Type); err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, misterrors.Wrapf(misterrors.CodeTimeout, ErrNoReply, "transport: %s %s: accepted without a reply", msg.Type, msg.ID)
+	}
+	reply, err := protocol.Unmarshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("http transport: reply: %w", err)
+	}
+	if !reply.IsReplyTo(msg.ID) {
+		return nil, fmt.Errorf("http transport: reply %s does not answer %s", reply.ID, msg.ID)
+	}
+	return reply, nil
+}
+
+// Receive blocks until a message is available from the local listener,
+// starting with any nacked deliveries. The message's share of the memory
+// budget (see SetMemoryBudget) is released as it is returned.
 func (h *HTTP) Receive(ctx context.Context) (*protocol.Message, error) {
-	select {
-	case msg := <-h.inbox:
-		return msg, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	r, err := h.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.release()
+	h.settled()
+	return r.msg, nil
+}
+
+// ReceiveDelivery receives the next message for at-least-once
+// processing. The message holds its share of the memory budget, and
+// holds off a drain (see EnableDrain), until it is acked; nacking it
+// puts it back on this listener's queue. A message
+// is acked to its sender once queued, so one left unsettled when the
+// process exits is lost.
+func (h *HTTP) ReceiveDelivery(ctx context.Context) (*Delivery, error) {
+	r, err := h.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Delivery{Message: r.msg, Attempt: r.attempt, settle: func(ack bool) error {
+		if ack {
+			r.release()
+			h.settled()
+		} else {
+			h.requeue.push(redelivery{msg: r.msg, attempt: r.attempt + 1, release: r.release})
+		}
+		return nil
+	}}, nil
+}
+
+// settled counts a message as done with, completing a drain waiting
+// only for it.
+func (h *HTTP) settled() {
+	h.unsettled.Add(-1)
+	if g := h.DrainGate(); g != nil {
+		g.Check()
+	}
+}
+
+// next returns the next nacked message, or else the next queued one.
+func (h *HTTP) next(ctx context.Context) (redelivery, error) {
+	for {
+		if r, ok := h.requeue.pop(); ok {
+			return r, nil
+		}
+		select {
+		case r := <-h.inbox:
+			return r, nil
+		case <-h.requeue.waiting():
+		case <-ctx.Done():
+			return redelivery{}, ctx.Err()
+		}
+	}
+}
+
+// SetMemoryBudget makes the listener reserve each request's estimated
+// memory cost from b before decoding it, rejecting requests that do not
+// fit with 503. The reservation is held until the messages the request
+// carried have been processed: acked, when received with
+// ReceiveDelivery, or returned by Receive. Call it before
+// ListenForMessages.
+func (h *HTTP) SetMemoryBudget(b *resource.MemoryBudget, est resource.Estimator) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if est.Unknown <= 0 {
+		est.Unknown = DefaultMaxMessageSize
+		if h.size.max > 0 {
+			est.Unknown = int64(h.size.max)
+		}
+	}
+	h.budget, h.estimator = b, est
+}
+
+// EnableDrain makes the listener honour control.drain: once one is
+// POSTed to /mist, by a caller the returned gate authorizes, further
+// messages are refused with 503, and GET /drain reports drained when
+// every queued message has been received, and those from ReceiveDelivery
+// acked, with nothing acquired from the gate in flight. Consumers that
+// process messages after Receive returns can Acquire and Release around
+// that work so the drain waits for it. Call it before ListenForMessages.
+func (h *HTTP) EnableDrain() *DrainGate {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.drain == nil {
+		h.drain = NewDrainGate(func() int { return int(h.unsettled.Load()) })
 	}
+	return h.drain
+}
+
+// DrainGate returns the gate created by EnableDrain, or nil.
+func (h *HTTP) DrainGate() *DrainGate {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.drain
 }
 
 // ListenForMessages starts an HTTP server that accepts POSTed messages.
 // This is used when a tool needs to receive messages from other tools.
 func (h *HTTP) ListenForMessages(addr string) error {
+	gate := h.DrainGate()
+	limit := h.limit()
 	mux := http.NewServeMux()
+	if gate != nil {
+		mux.Handle("GET /drain", gate)
+	}
+	h.mu.Lock()
+	budget, est := h.budget, h.estimator
+	h.mu.Unlock()
 	mux.HandleFunc("POST /mist", func(w http.ResponseWriter, r *http.Request) {
-		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)) // 1MB limit
-		if err != nil {
-			http.Error(w, "read error", http.StatusBadRequest)
+		hold := &reservation{release: func() {}}
+		if budget != nil {
+			release, err := resource.AdmitRequest(budget, est, w, r)
+			if err != nil {
+				w.Header().Set("Retry-After", "1")
+				misterrors.WriteHTTP(w, r, err)
+				return
+			}
+			hold.release = release
+		}
+		// Each queued message holds the reservation until processed.
+		hold.add()
+		defer hold.done()
+
+		data, ok := readBody(w, r, limit)
+		if !ok {
 			return
 		}
 
-		msg, err := protocol.Unmarshal(data)
+		codec := protocol.JSON
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			var ok bool
+			if codec, ok = protocol.CodecFor(ct); !ok {
+				w.Header().Set("Accept", acceptedCodecs)
+				http.Error(w, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		if codec.Name() == protocol.JSON.Name() && bytes.HasPrefix(bytes.TrimSpace(data), []byte{'['}) {
+			h.acceptBatch(w, r, data, gate, hold)
+			return
+		}
+		msg, err := codec.Unmarshal(data)
 		if err != nil {
 			http.Error(w, "invalid message", http.StatusBadRequest)
 			return
 		}
 
+		if HandleHandshake(w, listenerSource, msg) {
+			return
+		}
+		if gate != nil && gate.HandleControl(w, r, msg) {
+			return
+		}
+
+		// The sender has already given up; don't queue dead work.
+		if msg.Expired(time.Now()) {
+			http.Error(w, "deadline exceeded upstream", http.StatusGatewayTimeout)
+			return
+		}
+
+		hold.add()
+		h.unsettled.Add(1)
 		select {
-		case h.inbox <- msg:
+		case h.inbox <- redelivery{msg: msg, attempt: 1, release: hold.done}:
 			w.WriteHeader(http.StatusAccepted)
 		default:
+			hold.done()
+			h.unsettled.Add(-1)
 			http.Error(w, "inbox full", http.StatusServiceUnavailable)
 		}
 	})
@@ -120,6 +489,131 @@ func (h *HTTP) ListenForMessages(addr string) error {
 	return h.srv.ListenAndServe()
 }
 
+// acceptBatch queues a JSON array of messages POSTed by SendBatch. The
+// batch is refused whole, with 503, if the inbox lacks room for it or
+// the listener is draining. Control messages are applied and expired
+// messages dropped, as for single messages.
+func (h *HTTP) acceptBatch(w http.ResponseWriter, r *http.Request, data []byte, gate *DrainGate, hold *reservation) {
+	msgs, err := DecodeMessages(data)
+	if err != nil {
+		http.Error(w, "invalid batch", http.StatusBadRequest)
+		return
+	}
+
+	if gate != nil && gate.Forbid(w, r, msgs...) {
+		return
+	}
+	if gate != nil && gate.Draining() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if cap(h.inbox)-len(h.inbox) < len(msgs) {
+		http.Error(w, "inbox full", http.StatusServiceUnavailable)
+		return
+	}
+
+	now := time.Now()
+	for _, msg := range msgs {
+		if (gate != nil && gate.Control(msg)) || msg.Expired(now) {
+			continue
+		}
+		hold.add()
+		h.unsettled.Add(1)
+		select {
+		case h.inbox <- redelivery{msg: msg, attempt: 1, release: hold.done}:
+		case <-r.Context().Done():
+			hold.done()
+			h.unsettled.Add(-1)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ReadBody reads a POSTed request body, decoding any Content-Encoding
+// with the registered compressors as the HTTP listener does. On failure
+// it writes the reply and returns false: 415 with Accept-Encoding for an
+// encoding it can't decode, so a compressing sender falls back to plain
+// bodies; 413 for a body over max bytes once decoded; 400 for a corrupt
+// one. Zero or less means no limit.
+func ReadBody(w http.ResponseWriter, r *http.Request, max int) ([]byte, bool) {
+	return readBody(w, r, sizeLimit{max: max})
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, limit sizeLimit) ([]byte, bool) {
+	// The size limit applies to the decompressed body, so a small
+	// compressed request cannot expand without bound.
+	var body io.Reader = r.Body
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		c, ok := LookupCompressor(enc)
+		if !ok {
+			w.Header().Set("Accept-Encoding", acceptedEncodings())
+			http.Error(w, "unsupported content encoding "+enc, http.StatusUnsupportedMediaType)
+			return nil, false
+		}
+		zr, err := c.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "invalid "+enc+" body", http.StatusBadRequest)
+			return nil, false
+		}
+		defer zr.Close()
+		body = zr
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit.readLimit()))
+	if err != nil {
+		http.Error(w, "read error", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := limit.check("http", "receive", len(data), ""); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return data, true
+}
+
+// reservation is a request's share of the memory budget, released once
+// the handler and every message it queued are done with it.
+type reservation struct {
+	release func()
+	holders atomic.Int64
+}
+
+func (r *reservation) add() { r.holders.Add(1) }
+
+func (r *reservation) done() {
+	if r.holders.Add(-1) == 0 {
+		r.release()
+	}
+}
+
+// DecodeMessages decodes a JSON request body holding either one message
+// or an array of them, as SendBatch posts. Services that take messages
+// on their own POST /mist endpoint use it so that a relay batching into
+// them is understood.
+func DecodeMessages(data []byte) ([]*protocol.Message, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte{'['}) {
+		msg, err := protocol.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*protocol.Message{msg}, nil
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("http transport: batch: %w", err)
+	}
+	msgs := make([]*protocol.Message, 0, len(raw))
+	for i, m := range raw {
+		msg, err := protocol.Unmarshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("http transport: batch message %d: %w", i, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
 // Close shuts down the HTTP server if running.
 func (h *HTTP) Close() error {
 	h.mu.Lock()
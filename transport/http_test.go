@@ -0,0 +1,253 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/resource"
+)
+
+// listenHTTP starts srv's listener on a free local port and returns its
+// address once it accepts connections.
+func listenHTTP(t *testing.T, srv *HTTP) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	go srv.ListenForMessages(addr)
+	t.Cleanup(func() { srv.Close() })
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			return addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("listener on %s did not start", addr)
+	return ""
+}
+
+func TestHTTPCodec(t *testing.T) {
+	srv := NewHTTP("")
+	addr := listenHTTP(t, srv)
+
+	client := NewHTTP("http://" + addr + "/mist")
+	client.SetCodec(protocol.MsgPack)
+	msg := sizedMessage(t, 10)
+	msg.Meta = map[string]string{"tenant": "acme"}
+	if err := client.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, err := srv.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != msg.ID || got.Meta["tenant"] != "acme" || string(got.Payload) != string(msg.Payload) {
+		t.Errorf("received %+v", got)
+	}
+
+	resp, err := http.Post("http://"+addr+"/mist", "application/xml", strings.NewReader("<msg/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType || !strings.Contains(resp.Header.Get("Accept"), "application/msgpack") {
+		t.Errorf("xml: %d, Accept %q", resp.StatusCode, resp.Header.Get("Accept"))
+	}
+}
+
+func TestHTTPSendBatch(t *testing.T) {
+	srv := NewHTTP("")
+	addr := listenHTTP(t, srv)
+	client := NewHTTP("http://" + addr + "/mist")
+
+	msgs := make([]*protocol.Message, 150)
+	for i := range msgs {
+		msgs[i] = sizedMessage(t, 10)
+	}
+	expired := sizedMessage(t, 10)
+	expired.DeadlineNS = time.Now().Add(-time.Second).UnixNano()
+	if err := client.SendBatch(context.Background(), append(msgs, expired)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i, want := range msgs {
+		got, err := srv.Receive(ctx)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if got.ID != want.ID {
+			t.Fatalf("message %d = %s, want %s", i, got.ID, want.ID)
+		}
+	}
+	if n := len(srv.inbox); n != 0 {
+		t.Errorf("%d messages left in inbox; expired message was queued", n)
+	}
+}
+
+func TestHTTPSendBatchSplits(t *testing.T) {
+	var sizes []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var batch []json.RawMessage
+		if json.Unmarshal(data, &batch) != nil {
+			batch = []json.RawMessage{data} // a single message
+		}
+		if len(data) > 1024 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		sizes = append(sizes, len(batch))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	client := NewHTTP(ts.URL)
+	client.SetMaxMessageSize(1024, nil)
+	msgs := make([]*protocol.Message, 10)
+	for i := range msgs {
+		msgs[i] = sizedMessage(t, 200)
+	}
+	if err := client.SendBatch(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, n := range sizes {
+		total += n
+	}
+	if total != len(msgs) || len(sizes) < 3 {
+		t.Errorf("batch sizes = %v, want %d messages split to fit 1024 bytes", sizes, len(msgs))
+	}
+
+	// Over DefaultBatchSize messages split by count.
+	sizes = nil
+	client.SetMaxMessageSize(0, nil)
+	msgs = make([]*protocol.Message, DefaultBatchSize+1)
+	for i := range msgs {
+		msgs[i] = sizedMessage(t, 0)
+	}
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []json.RawMessage
+		if json.NewDecoder(r.Body).Decode(&batch) != nil {
+			batch = make([]json.RawMessage, 1)
+		}
+		sizes = append(sizes, len(batch))
+	})
+	if err := client.SendBatch(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] != DefaultBatchSize || sizes[1] != 1 {
+		t.Errorf("batch sizes = %v, want [%d 1]", sizes, DefaultBatchSize)
+	}
+}
+
+func TestHTTPCompression(t *testing.T) {
+	srv := NewHTTP("")
+	addr := listenHTTP(t, srv)
+	client := NewHTTP("http://" + addr + "/mist")
+	client.SetCompression(Gzip)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	big := sizedMessage(t, 4096)
+	if err := client.Send(ctx, big); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := srv.Receive(ctx); err != nil || got.ID != big.ID {
+		t.Fatalf("Receive = %v, %v", got, err)
+	}
+
+	// The decompressed size is what counts against the limit.
+	bomb, _ := compress(Gzip, []byte(`{"pad":"`+strings.Repeat("x", 2*DefaultMaxMessageSize)+`"}`))
+	req, _ := http.NewRequest("POST", "http://"+addr+"/mist", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expanding body status = %d, want 413", resp.StatusCode)
+	}
+}
+
+func TestHTTPCompressionFallback(t *testing.T) {
+	var encodings []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := r.Header.Get("Content-Encoding")
+		encodings = append(encodings, enc)
+		if enc != "" {
+			w.Header().Set("Accept-Encoding", "identity")
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	client := NewHTTP(ts.URL)
+	client.SetCompression(Gzip)
+	for i := 0; i < 2; i++ {
+		if err := client.Send(context.Background(), sizedMessage(t, 4096)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"gzip", "", ""}; strings.Join(encodings, ",") != strings.Join(want, ",") {
+		t.Errorf("encodings = %q, want %q", encodings, want)
+	}
+}
+
+func TestHTTPMemoryBudgetHeldUntilAck(t *testing.T) {
+	srv := NewHTTP("")
+	budget := resource.NewMemoryBudget("ingest", 6<<10)
+	srv.SetMemoryBudget(budget, resource.Estimator{Factor: 1, Overhead: 1})
+	addr := listenHTTP(t, srv)
+	client := NewHTTP("http://" + addr + "/mist")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := client.Send(ctx, sizedMessage(t, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	d, err := srv.ReceiveDelivery(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.Reserved() == 0 {
+		t.Fatal("reservation released before the message was processed")
+	}
+	// The unprocessed message still holds its memory, so a second one
+	// doesn't fit.
+	if err := client.Send(ctx, sizedMessage(t, 4096)); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("second send while the first is unacked = %v, want 503", err)
+	}
+
+	d.Nack()
+	if d, err = srv.ReceiveDelivery(ctx); err != nil || d.Attempt != 2 {
+		t.Fatalf("redelivery = %+v, %v", d, err)
+	}
+	d.Ack()
+	if n := budget.Reserved(); n != 0 {
+		t.Errorf("%d bytes reserved after ack, want 0", n)
+	}
+	if err := client.Send(ctx, sizedMessage(t, 4096)); err != nil {
+		t.Errorf("send after ack = %v", err)
+	}
+}
@@ -4,18 +4,35 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"time"
 
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metadata"
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/timeout"
 	"github.com/greynewell/mist-go/trace"
 )
 
 // Middleware wraps a Transport with additional behavior (logging, tracing,
 // retry) without changing the underlying transport code.
 type Middleware struct {
-	inner  Transport
-	logger *slog.Logger
-	retry  RetryPolicy
+	inner       Transport
+	logger      *slog.Logger
+	retry       RetryPolicy
+	deadlines   bool
+	metadata    bool
+	expiry      *ExpiryPolicy
+	seq         *sequencer
+	dedup       *deduper
+	size        sizeLimit
+	sizeCheck   bool // enforce size here; the inner transport can't
+	chunks      *chunker
+	attachments *AttachmentPolicy
+	peerVersion string
+	sendTimeout time.Duration
+	slow        *slowConsumer
 }
 
 // RetryPolicy configures retry behavior for middleware. Zero value means
@@ -36,16 +53,85 @@ func WithLogger(logger *slog.Logger) MiddlewareOption {
 }
 
 // WithRetry adds retry with exponential backoff to send operations.
+// Messages refused as too large or expired are not retried.
 func WithRetry(p RetryPolicy) MiddlewareOption {
 	return func(m *Middleware) { m.retry = p }
 }
 
+// WithDeadlines stamps outgoing messages with the ctx deadline and drops
+// received messages whose deadline has already passed. Receivers restore
+// the deadline with timeout.FromMessage.
+func WithDeadlines() MiddlewareOption {
+	return func(m *Middleware) { m.deadlines = true }
+}
+
+// WithMetadata copies request metadata from the ctx onto outgoing
+// messages. Receivers restore it with ReceiveContext or metadata.Extract.
+func WithMetadata() MiddlewareOption {
+	return func(m *Middleware) { m.metadata = true }
+}
+
+// ErrExpired is returned by Send for a message whose deadline or TTL has
+// passed when ExpiryPolicy.OnSend is set.
+var ErrExpired = misterrors.New(misterrors.CodeTimeout, "transport: message expired").Permanent()
+
+// MetaTTLRemaining is the Meta key a relaying middleware stamps with a
+// message's remaining lifetime in milliseconds when it forwards it.
+const MetaTTLRemaining = "ttl_remaining_ms"
+
+// ExpiryPolicy configures TTL enforcement for WithExpiry.
+type ExpiryPolicy struct {
+	// DefaultTTL is stamped on outgoing messages that have no TTL.
+	DefaultTTL time.Duration
+
+	// OnSend drops expired outgoing messages instead of sending them;
+	// Send returns ErrExpired.
+	OnSend bool
+
+	// Annotate stamps MetaTTLRemaining on outgoing messages that expire,
+	// for relays to record how much lifetime was left at each hop.
+	Annotate bool
+
+	// Metrics, if set, counts dropped messages in
+	// transport_expired_total{direction, type}.
+	Metrics *metrics.Registry
+}
+
+// WithExpiry drops received messages whose deadline or TTL has passed,
+// so stale messages are not processed late after a delivery backlog. See
+// ExpiryPolicy for send-side behavior.
+func WithExpiry(p ExpiryPolicy) MiddlewareOption {
+	return func(m *Middleware) { m.expiry = &p }
+}
+
 // Wrap creates a middleware-wrapped transport.
 func Wrap(t Transport, opts ...MiddlewareOption) *Middleware {
 	m := &Middleware{inner: t}
 	for _, opt := range opts {
 		opt(m)
 	}
+	if m.size.max > 0 {
+		if sl, ok := t.(SizeLimiter); ok {
+			sl.SetMaxMessageSize(m.size.max, m.size.metrics)
+		} else {
+			m.sizeCheck = true
+		}
+	}
+	if m.seq != nil {
+		m.seq.logger = m.logger
+	}
+	if m.dedup != nil {
+		m.dedup.logger = m.logger
+	}
+	if m.chunks != nil {
+		m.chunks.logger = m.logger
+		if m.chunks.size <= 0 {
+			m.chunks.size = DefaultMaxMessageSize
+			if m.size.max > 0 {
+				m.chunks.size = m.size.max
+			}
+		}
+	}
 	return m
 }
 
@@ -54,21 +140,57 @@ func Wrap(t Transport, opts ...MiddlewareOption) *Middleware {
 func (m *Middleware) Send(ctx context.Context, msg *protocol.Message) error {
 	start := time.Now()
 
+	if m.deadlines {
+		timeout.Annotate(ctx, msg)
+	}
+	if m.metadata {
+		metadata.Inject(ctx, msg)
+	}
+	if m.expiry != nil {
+		if err := m.checkSend(msg); err != nil {
+			return err
+		}
+	}
+	if m.attachments != nil {
+		if err := m.attachments.offload(ctx, msg); err != nil {
+			return err
+		}
+	}
+	if err := m.convert(msg); err != nil {
+		return err
+	}
+	if m.seq != nil {
+		m.seq.stamp(msg)
+	}
+	send := func(ctx context.Context) error { return m.inner.Send(ctx, msg) }
+	chunks, err := m.split(msg)
+	if err != nil {
+		return err
+	}
+	if chunks != nil {
+		send = func(ctx context.Context) error { return SendBatch(ctx, m.inner, chunks) }
+	} else if err := m.checkSize("send", msg); err != nil {
+		return err
+	}
+	send = m.bound(send)
+
 	// Start a trace span if tracing is active.
 	var span *trace.Span
 	if trace.FromContext(ctx) != nil {
 		ctx, span = trace.Start(ctx, "transport.send")
 		span.SetAttr("msg_type", msg.Type)
 		span.SetAttr("msg_source", msg.Source)
+		if chunks != nil {
+			span.SetAttr("chunks", len(chunks))
+		}
 	}
 
-	var err error
 	attempts := 1
 
 	if m.retry.MaxAttempts > 1 {
-		err = m.sendWithRetry(ctx, msg, &attempts)
+		err = m.sendWithRetry(ctx, &attempts, send)
 	} else {
-		err = m.inner.Send(ctx, msg)
+		err = send(ctx)
 	}
 
 	elapsed := time.Since(start)
@@ -101,7 +223,128 @@ func (m *Middleware) Send(ctx context.Context, msg *protocol.Message) error {
 	return err
 }
 
-func (m *Middleware) sendWithRetry(ctx context.Context, msg *protocol.Message, attempts *int) error {
+// SendBatch sends msgs through the wrapped transport, in one call if it
+// is a BatchSender. Each message is prepared as Send would prepare it.
+// With retry, a failed batch is retried whole, so a receiver may see
+// duplicates of messages delivered before the failure (see WithDedup).
+// Messages that have expired are dropped; once the rest are sent, an
+// *ExpiredBatchError reports how many.
+func (m *Middleware) SendBatch(ctx context.Context, msgs []*protocol.Message) error {
+	start := time.Now()
+
+	live := make([]*protocol.Message, 0, len(msgs))
+	expired := 0
+	for _, msg := range msgs {
+		if m.deadlines {
+			timeout.Annotate(ctx, msg)
+		}
+		if m.metadata {
+			metadata.Inject(ctx, msg)
+		}
+		if m.expiry != nil {
+			if err := m.checkSend(msg); err != nil {
+				expired++
+				continue
+			}
+		}
+		if m.attachments != nil {
+			if err := m.attachments.offload(ctx, msg); err != nil {
+				return err
+			}
+		}
+		if err := m.convert(msg); err != nil {
+			return err
+		}
+		if m.seq != nil {
+			m.seq.stamp(msg)
+		}
+		chunks, err := m.split(msg)
+		if err != nil {
+			return err
+		}
+		if chunks != nil {
+			live = append(live, chunks...)
+			continue
+		}
+		if err := m.checkSize("send", msg); err != nil {
+			return err
+		}
+		live = append(live, msg)
+	}
+
+	var span *trace.Span
+	if trace.FromContext(ctx) != nil {
+		ctx, span = trace.Start(ctx, "transport.send_batch")
+		span.SetAttr("batch_size", len(live))
+	}
+
+	var err error
+	attempts := 1
+	send := m.bound(func(ctx context.Context) error { return SendBatch(ctx, m.inner, live) })
+	switch {
+	case len(live) == 0:
+	case m.retry.MaxAttempts > 1:
+		err = m.sendWithRetry(ctx, &attempts, send)
+	default:
+		err = send(ctx)
+	}
+
+	elapsed := time.Since(start)
+
+	if span != nil {
+		span.SetAttr("duration_ms", elapsed.Milliseconds())
+		span.SetAttr("attempts", attempts)
+		if err != nil {
+			span.SetAttr("error", err.Error())
+			span.End("error")
+		} else {
+			span.End("ok")
+		}
+	}
+
+	if m.logger != nil {
+		attrs := []any{
+			"batch_size", len(live),
+			"duration_ms", elapsed.Milliseconds(),
+			"attempts", attempts,
+		}
+		if err != nil {
+			m.logger.Error("send batch failed", append(attrs, "error", err)...)
+		} else {
+			m.logger.Debug("send batch ok", attrs...)
+		}
+	}
+
+	if err == nil && expired > 0 {
+		return &ExpiredBatchError{Expired: expired, Total: len(msgs)}
+	}
+	return err
+}
+
+// split returns the chunks of an oversized msg under WithChunking, or nil
+// to send it whole.
+func (m *Middleware) split(msg *protocol.Message) ([]*protocol.Message, error) {
+	if m.chunks == nil {
+		return nil, nil
+	}
+	return m.chunks.split(msg)
+}
+
+// ExpiredBatchError reports messages that Middleware.SendBatch dropped
+// because they had expired. The rest of the batch was sent. It matches
+// ErrExpired with errors.Is.
+type ExpiredBatchError struct {
+	Expired int
+	Total   int
+}
+
+func (e *ExpiredBatchError) Error() string {
+	return fmt.Sprintf("transport: %d of %d messages expired", e.Expired, e.Total)
+}
+
+func (e *ExpiredBatchError) Unwrap() error { return ErrExpired }
+
+func (m *Middleware) sendWithRetry(ctx context.Context, attempts *int, send func(context.Context) error) error {
 	wait := m.retry.InitialWait
 	var lastErr error
 
@@ -115,10 +358,13 @@ func (m *Middleware) sendWithRetry(ctx context.Context, msg *protocol.Message, a
 			return ctx.Err()
 		}
 
-		lastErr = m.inner.Send(ctx, msg)
+		lastErr = send(ctx)
 		if lastErr == nil {
 			return nil
 		}
+		if misterrors.Is(lastErr, ErrMessageTooLarge) || misterrors.Is(lastErr, ErrExpired) {
+			return lastErr
+		}
 
 		if i == m.retry.MaxAttempts-1 {
 			break
@@ -143,7 +389,13 @@ func (m *Middleware) sendWithRetry(ctx context.Context, msg *protocol.Message, a
 func (m *Middleware) Receive(ctx context.Context) (*protocol.Message, error) {
 	start := time.Now()
 
-	msg, err := m.inner.Receive(ctx)
+	var msg *protocol.Message
+	var err error
+	if m.seq != nil {
+		msg, err = m.seq.receive(ctx, m.receiveLive)
+	} else {
+		msg, err = m.receiveLive(ctx)
+	}
 
 	elapsed := time.Since(start)
 
@@ -158,7 +410,142 @@ func (m *Middleware) Receive(ctx context.Context) (*protocol.Message, error) {
 	return msg, err
 }
 
-// Close closes the underlying transport.
+// receiveLive reads the next message that has neither expired nor been
+// seen before.
+func (m *Middleware) receiveLive(ctx context.Context) (*protocol.Message, error) {
+	for {
+		msg, err := m.inner.Receive(ctx)
+		if err != nil || msg == nil {
+			return msg, err
+		}
+		if msg, err = m.admit(msg, false); err != nil || msg != nil {
+			return msg, err
+		}
+	}
+}
+
+// ReceiveDelivery receives like Receive, passing acks through to the
+// wrapped transport if it is an AckReceiver. Messages the middleware
+// drops, as expired or duplicates, are acked; a nacked message delivered
+// again is not taken for a duplicate. With WithSequence or WithChunking,
+// which hold messages back, or over a transport without acks, messages
+// are delivered with an Ack and Nack that do nothing.
+func (m *Middleware) ReceiveDelivery(ctx context.Context) (*Delivery, error) {
+	ar, ok := m.inner.(AckReceiver)
+	if !ok || m.seq != nil || m.chunks != nil {
+		msg, err := m.Receive(ctx)
+		if err != nil || msg == nil {
+			return nil, err
+		}
+		return &Delivery{Message: msg, Attempt: 1}, nil
+	}
+	for {
+		d, err := ar.ReceiveDelivery(ctx)
+		if err != nil || d == nil {
+			return nil, err
+		}
+		msg, err := m.admit(d.Message, d.Attempt > 1)
+		if msg != nil {
+			return d, nil
+		}
+		if ackErr := d.Ack(); ackErr != nil {
+			return nil, ackErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// admit applies the receive checks to msg, returning nil if it is
+// dropped or is a chunk of a message not yet complete.
+func (m *Middleware) admit(msg *protocol.Message, redelivered bool) (*protocol.Message, error) {
+	if err := m.checkSize("receive", msg); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if m.chunks != nil && msg.Type == protocol.TypeTransportChunk {
+		var err error
+		if msg, err = m.chunks.add(msg, now); err != nil || msg == nil {
+			return nil, err
+		}
+	}
+	if m.attachments != nil {
+		if err := m.attachments.check("receive", msg); err != nil {
+			return nil, err
+		}
+	}
+	if (m.deadlines || m.expiry != nil) && msg.Expired(now) {
+		m.dropExpired("receive", msg)
+		return nil, nil
+	}
+	if m.dedup != nil && !redelivered && m.dedup.duplicate(msg, now) {
+		if m.logger != nil {
+			m.logger.Debug("dropped duplicate message", "msg_type", msg.Type, "msg_id", msg.ID)
+		}
+		return nil, nil
+	}
+	return msg, nil
+}
+
+// checkSend applies the expiry policy to an outgoing message.
+func (m *Middleware) checkSend(msg *protocol.Message) error {
+	if msg.TTLNS == 0 && m.expiry.DefaultTTL > 0 {
+		msg.SetTTL(m.expiry.DefaultTTL)
+	}
+	now := time.Now()
+	if m.expiry.OnSend && msg.Expired(now) {
+		m.dropExpired("send", msg)
+		return misterrors.Wrapf(misterrors.CodeTimeout, ErrExpired, "%s %s", msg.Type, msg.ID).Permanent()
+	}
+	if m.expiry.Annotate {
+		if d, ok := msg.Remaining(now); ok {
+			if msg.Meta == nil {
+				msg.Meta = make(map[string]string)
+			}
+			msg.Meta[MetaTTLRemaining] = strconv.FormatInt(d.Milliseconds(), 10)
+		}
+	}
+	return nil
+}
+
+// dropExpired logs and counts an expired message.
+func (m *Middleware) dropExpired(direction string, msg *protocol.Message) {
+	if m.logger != nil {
+		cause := ErrExpired
+		if msg.DeadlineNS != 0 && time.Now().UnixNano() >= msg.DeadlineNS {
+			cause = timeout.ErrUpstreamDeadline
+		}
+		m.logger.Warn("dropped expired message",
+			"direction", direction,
+			"msg_type", msg.Type,
+			"msg_id", msg.ID,
+			"error", cause,
+		)
+	}
+	if m.expiry != nil && m.expiry.Metrics != nil {
+		m.expiry.Metrics.Counter("transport_expired_total", "direction", direction, "type", msg.Type).Inc()
+	}
+}
+
+// ReceiveContext receives a message and returns ctx enriched with the
+// message's metadata, ready to pass to the handler for that message.
+func ReceiveContext(ctx context.Context, r Receiver) (context.Context, *protocol.Message, error) {
+	msg, err := r.Receive(ctx)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return metadata.Extract(ctx, msg), msg, nil
+}
+
+// Close closes the underlying transport, saving the dedup bitmap if
+// WithDedupFile is in use.
 func (m *Middleware) Close() error {
-	return m.inner.Close()
+	err := m.inner.Close()
+	if m.dedup != nil {
+		if derr := m.dedup.close(); err == nil {
+			err = derr
+		}
+	}
+	return err
 }
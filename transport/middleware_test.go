@@ -3,12 +3,17 @@ package transport
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metadata"
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
 	"github.com/greynewell/mist-go/trace"
 )
@@ -198,3 +203,156 @@ func (f *failingSender) Receive(ctx context.Context) (*protocol.Message, error)
 func (f *failingSender) Close() error {
 	return f.inner.Close()
 }
+
+func TestMiddlewareWithDeadlines(t *testing.T) {
+	ch := NewChannel(16)
+	m := Wrap(ch, WithDeadlines())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	live, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "live"})
+	if err := m.Send(ctx, live); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if live.DeadlineNS == 0 {
+		t.Fatal("Send should stamp the ctx deadline")
+	}
+
+	// An expired message queued ahead of a live one is dropped on receive.
+	expired, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "expired"})
+	expired.DeadlineNS = time.Now().Add(-time.Second).UnixNano()
+	ch2 := NewChannel(16)
+	ch2.Send(ctx, expired)
+	ch2.Send(ctx, live)
+
+	got, err := Wrap(ch2, WithDeadlines()).Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if got.ID != live.ID {
+		t.Errorf("received %s, want the live message", got.ID)
+	}
+}
+
+func TestMiddlewareWithMetadata(t *testing.T) {
+	ch := NewChannel(16)
+	m := Wrap(ch, WithMetadata())
+
+	ctx := metadata.With(context.Background(), metadata.KeyTenant, "acme")
+	ctx = metadata.With(ctx, metadata.KeyRequestID, "req-1")
+
+	msg, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "test"})
+	if err := m.Send(ctx, msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	rctx, got, err := ReceiveContext(context.Background(), m)
+	if err != nil {
+		t.Fatalf("ReceiveContext: %v", err)
+	}
+	if got.ID != msg.ID {
+		t.Error("ID mismatch")
+	}
+	if metadata.Get(rctx, metadata.KeyTenant) != "acme" || metadata.Get(rctx, metadata.KeyRequestID) != "req-1" {
+		t.Errorf("metadata not restored: %v", metadata.FromContext(rctx))
+	}
+}
+
+func TestMiddlewareWithExpiry(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+
+	stale, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "stale"})
+	stale.TimestampNS = time.Now().Add(-time.Minute).UnixNano()
+	stale.SetTTL(10 * time.Second)
+	live, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "live"})
+	live.SetTTL(time.Minute)
+
+	ch := NewChannel(16)
+	ch.Send(ctx, stale)
+	ch.Send(ctx, live)
+
+	got, err := Wrap(ch, WithExpiry(ExpiryPolicy{Metrics: reg})).Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if got.ID != live.ID {
+		t.Errorf("received %s, want the live message", got.ID)
+	}
+	if n := reg.Counter("transport_expired_total", "direction", "receive", "type", protocol.TypeHealthPing).Value(); n != 1 {
+		t.Errorf("transport_expired_total = %d, want 1", n)
+	}
+}
+
+func TestMiddlewareExpiryOnSend(t *testing.T) {
+	ctx := context.Background()
+	ch := NewChannel(16)
+	m := Wrap(ch, WithExpiry(ExpiryPolicy{DefaultTTL: time.Minute, OnSend: true, Annotate: true}))
+
+	msg, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "a"})
+	if err := m.Send(ctx, msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if msg.TTLNS != int64(time.Minute) {
+		t.Errorf("TTLNS = %d, want default TTL", msg.TTLNS)
+	}
+	ms, err := strconv.ParseInt(msg.Meta[MetaTTLRemaining], 10, 64)
+	if err != nil || ms <= 0 || ms > 60000 {
+		t.Errorf("%s = %q", MetaTTLRemaining, msg.Meta[MetaTTLRemaining])
+	}
+
+	stale, _ := protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "b"})
+	stale.TimestampNS = time.Now().Add(-time.Hour).UnixNano()
+	stale.SetTTL(time.Second)
+	err = m.Send(ctx, stale)
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("Send(stale) = %v, want ErrExpired", err)
+	}
+	if misterrors.IsRetryable(err) {
+		t.Errorf("Send(stale) = %v is retryable; sending it again can't succeed", err)
+	}
+	ch.Receive(ctx)
+	rctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if got, err := ch.Receive(rctx); err == nil {
+		t.Errorf("expired message %s was sent", got.ID)
+	}
+}
+
+// batchRecorder records the batches sent through it.
+type batchRecorder struct {
+	*Channel
+	batches [][]*protocol.Message
+}
+
+func (b *batchRecorder) SendBatch(ctx context.Context, msgs []*protocol.Message) error {
+	b.batches = append(b.batches, msgs)
+	return nil
+}
+
+func TestMiddlewareSendBatch(t *testing.T) {
+	rec := &batchRecorder{Channel: NewChannel(16)}
+	m := Wrap(rec, WithExpiry(ExpiryPolicy{OnSend: true}))
+
+	live := sizedMessage(t, 10)
+	expired := sizedMessage(t, 10)
+	expired.DeadlineNS = time.Now().Add(-time.Second).UnixNano()
+	err := m.SendBatch(context.Background(), []*protocol.Message{live, expired})
+	var eb *ExpiredBatchError
+	if !errors.As(err, &eb) || eb.Expired != 1 || eb.Total != 2 || !errors.Is(err, ErrExpired) {
+		t.Fatalf("SendBatch = %v, want 1 of 2 expired", err)
+	}
+	if len(rec.batches) != 1 || len(rec.batches[0]) != 1 || rec.batches[0][0].ID != live.ID {
+		t.Errorf("batches = %v, want one batch with the live message", rec.batches)
+	}
+
+	// Transports without SendBatch get one Send per message.
+	ch := NewChannel(16)
+	if err := Wrap(ch).SendBatch(context.Background(), []*protocol.Message{live, sizedMessage(t, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ch.recv); n != 2 {
+		t.Errorf("channel holds %d messages, want 2", n)
+	}
+}
@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+// ErrInvalidOption is returned by Dial for a URL option that is unknown
+// to the scheme or has a malformed value. It is not retryable.
+var ErrInvalidOption = misterrors.New(misterrors.CodeValidation, "transport: invalid URL option").Permanent()
+
+// URLOptions reads the options of a transport URL from its query string,
+// as in file:///var/spool/mist.jsonl.gz?max_message_size=4MiB. Each
+// accessor returns its default when the option is absent and records a
+// malformed value for Err. Options are read once per scheme, and every
+// option no accessor asked for is an error, so a misspelled option fails
+// Dial rather than being silently ignored.
+type URLOptions struct {
+	scheme string
+	values url.Values
+	known  map[string]bool
+	errs   []error
+}
+
+// ParseURLOptions parses the raw query of a URL for the given scheme.
+func ParseURLOptions(scheme, rawQuery string) (*URLOptions, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, misterrors.Wrapf(misterrors.CodeValidation, ErrInvalidOption, "%s: %v", scheme, err)
+	}
+	return &URLOptions{scheme: scheme, values: values, known: make(map[string]bool)}, nil
+}
+
+// Has reports whether the option is set.
+func (o *URLOptions) Has(name string) bool {
+	o.known[name] = true
+	_, ok := o.values[name]
+	return ok
+}
+
+// String returns the option's value, or def if it is absent.
+func (o *URLOptions) String(name, def string) string {
+	o.known[name] = true
+	vs, ok := o.values[name]
+	if !ok {
+		return def
+	}
+	if len(vs) > 1 {
+		o.invalid(name, strings.Join(vs, ","), "set more than once")
+	}
+	return vs[0]
+}
+
+// Int returns the option as an integer.
+func (o *URLOptions) Int(name string, def int) int {
+	s := o.String(name, "")
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		o.invalid(name, s, "want an integer")
+		return def
+	}
+	return n
+}
+
+// Bool returns the option as a boolean. A bare option, as in ?follow,
+// is true.
+func (o *URLOptions) Bool(name string, def bool) bool {
+	if !o.Has(name) {
+		return def
+	}
+	s := o.String(name, "")
+	if s == "" {
+		return true
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		o.invalid(name, s, "want true or false")
+		return def
+	}
+	return b
+}
+
+// Duration returns the option as a duration such as "30s".
+func (o *URLOptions) Duration(name string, def time.Duration) time.Duration {
+	s := o.String(name, "")
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		o.invalid(name, s, "want a duration such as 30s")
+		return def
+	}
+	return d
+}
+
+// sizeUnits are the suffixes Size accepts, longest first so "MiB" is
+// tried before "B".
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// Size returns the option as a byte count: a plain number, or one with a
+// decimal (KB, MB, GB) or binary (KiB, MiB, GiB) suffix, such as 100MB.
+func (o *URLOptions) Size(name string, def int64) int64 {
+	s := o.String(name, "")
+	if s == "" {
+		return def
+	}
+	num, mult := s, int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			num, mult = n, u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/mult {
+		o.invalid(name, s, "want a size such as 4MiB or 100MB")
+		return def
+	}
+	return n * mult
+}
+
+// Err returns the first malformed option, or, once every option the
+// scheme supports has been read, the first unknown one. It wraps
+// ErrInvalidOption.
+func (o *URLOptions) Err() error {
+	if len(o.errs) > 0 {
+		return o.errs[0]
+	}
+	var unknown []string
+	for name := range o.values {
+		if !o.known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	known := make([]string, 0, len(o.known))
+	for name := range o.known {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	if len(known) == 0 {
+		return misterrors.Wrapf(misterrors.CodeValidation, ErrInvalidOption, "%s: unknown option %q (the scheme takes none)", o.scheme, unknown[0])
+	}
+	return misterrors.Wrapf(misterrors.CodeValidation, ErrInvalidOption, "%s: unknown option %q (known: %s)", o.scheme, unknown[0], strings.Join(known, ", "))
+}
+
+func (o *URLOptions) invalid(name, value, reason string) {
+	o.errs = append(o.errs, misterrors.Wrapf(misterrors.CodeValidation, ErrInvalidOption, "%s: %s=%s: %s", o.scheme, name, value, reason))
+}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+)
+
+func TestURLOptions(t *testing.T) {
+	o, err := ParseURLOptions("file", "follow&rotate=100MB&buffer=64&poll=250ms&name=x&cap=4KiB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Bool("follow", false) {
+		t.Error("bare follow should be true")
+	}
+	if got := o.Size("rotate", 0); got != 100_000_000 {
+		t.Errorf("rotate = %d", got)
+	}
+	if got := o.Size("cap", 0); got != 4096 {
+		t.Errorf("cap = %d", got)
+	}
+	if got := o.Int("buffer", 0); got != 64 {
+		t.Errorf("buffer = %d", got)
+	}
+	if got := o.Duration("poll", 0); got != 250*time.Millisecond {
+		t.Errorf("poll = %v", got)
+	}
+	if got := o.String("name", ""); got != "x" {
+		t.Errorf("name = %q", got)
+	}
+	if got := o.Int("missing", 7); got != 7 {
+		t.Errorf("missing = %d, want default", got)
+	}
+	if err := o.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+}
+
+func TestURLOptionsErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		read  func(*URLOptions)
+		want  string
+	}{
+		{"folow=true", func(o *URLOptions) { o.Bool("follow", false) }, `unknown option "folow" (known: follow)`},
+		{"x=1", func(*URLOptions) {}, `unknown option "x" (the scheme takes none)`},
+		{"rotate=lots", func(o *URLOptions) { o.Size("rotate", 0) }, "rotate=lots"},
+		{"rotate=-1MB", func(o *URLOptions) { o.Size("rotate", 0) }, "rotate=-1MB"},
+		{"buffer=1.5", func(o *URLOptions) { o.Int("buffer", 0) }, "want an integer"},
+		{"follow=maybe", func(o *URLOptions) { o.Bool("follow", false) }, "want true or false"},
+		{"poll=soon", func(o *URLOptions) { o.Duration("poll", 0) }, "want a duration"},
+		{"a=1&a=2", func(o *URLOptions) { o.String("a", "") }, "set more than once"},
+	}
+	for _, tt := range tests {
+		o, err := ParseURLOptions("file", tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.read(o)
+		err = o.Err()
+		if !misterrors.Is(err, ErrInvalidOption) || misterrors.Code(err) != misterrors.CodeValidation {
+			t.Errorf("%s: err = %v, want ErrInvalidOption", tt.query, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", tt.query, err, tt.want)
+		}
+	}
+
+	if _, err := ParseURLOptions("file", "%zz"); !misterrors.Is(err, ErrInvalidOption) {
+		t.Errorf("bad query: err = %v", err)
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	tr, err := Dial("file://" + path + "?compress=gzip&max_message_size=4KiB&resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := tr.(*File)
+	if f.path != path || f.compress != Gzip || f.size.max != 4096 || !f.resume {
+		t.Errorf("file = %s, compress %v, max %d, resume %v", f.path, f.compress, f.size.max, f.resume)
+	}
+
+	tr, err = Dial("chan://?buffer=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cap(tr.(*Channel).recv); c != 4 {
+		t.Errorf("chan buffer = %d, want 4", c)
+	}
+
+	for _, url := range []string{
+		"file://" + path + "?compress=brotli",
+		"file://" + path + "?rotate=100MB",
+		"file://" + path + "?resume=maybe",
+		"chan://?buffer=-1",
+		"stdio://?max_message_size=big",
+		"grpc://localhost:9090?tls=true",
+	} {
+		if _, err := Dial(url); !misterrors.Is(err, ErrInvalidOption) {
+			t.Errorf("Dial(%q) err = %v, want ErrInvalidOption", url, err)
+		}
+	}
+
+	// HTTP query strings belong to the endpoint; options go in the
+	// fragment.
+	tr, err = Dial("http://localhost:8080/mist?token=abc#compress=gzip&max_message_size=4KiB")
+	if err != nil {
+		t.Fatalf("http with query: %v", err)
+	}
+	h := tr.(*HTTP)
+	if h.target != "http://localhost:8080/mist?token=abc" || h.compress != Gzip || h.size.max != 4096 {
+		t.Errorf("http = %s, compress %v, max %d", h.target, h.compress, h.size.max)
+	}
+	if _, err := Dial("http://localhost:8080/mist#compres=gzip"); !misterrors.Is(err, ErrInvalidOption) {
+		t.Errorf("http with a misspelled option: err = %v, want ErrInvalidOption", err)
+	}
+}
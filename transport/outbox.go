@@ -0,0 +1,338 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/checkpoint"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// OutboxConfig controls how an Outbox drains.
+type OutboxConfig struct {
+	// RetryWait is the initial wait before resending a message the
+	// destination failed to accept (default 100ms).
+	RetryWait time.Duration
+
+	// MaxRetryWait caps the exponential backoff (default 30s).
+	MaxRetryWait time.Duration
+
+	// OnDrop is called for a message the outbox gives up on: one the
+	// destination refused with a permanent error, or a queued line that
+	// no longer decodes, in which case msg is nil. Without it such
+	// messages are dropped silently; use a DeadLetter as the destination
+	// to keep them.
+	OnDrop func(msg *protocol.Message, err error)
+}
+
+// Outbox is a Transport that makes sends durable. Send appends the
+// message to a queue file in the outbox's directory, syncs it to disk,
+// and returns; a background loop then delivers queued messages to the
+// destination in order, retrying with backoff for as long as it is
+// down. Delivered messages are recorded in a checkpoint log beside the
+// queue, so an outbox reopened after a crash or restart picks up where
+// it left off. Messages are delivered at least once: one in flight
+// during a crash is sent again.
+//
+//	out, err := transport.NewOutbox("/var/lib/mist/outbox", dst, transport.OutboxConfig{})
+//	...
+//	out.Send(ctx, msg) // returns once msg is on disk
+//
+// The queue is truncated whenever it has been delivered in full. Only
+// one Outbox can hold a directory at a time. Receive reads from the
+// destination.
+type Outbox struct {
+	inner Transport
+	cfg   OutboxConfig
+	path  string
+	cp    *checkpoint.Tracker
+
+	reader *os.File // read by the drain loop only
+
+	mu        sync.Mutex
+	queue     *os.File
+	queued    int           // lines in the queue
+	delivered int           // lines settled by the drain loop
+	signal    chan struct{} // has a value when lines are queued
+	idle      chan struct{} // closed when delivered catches up with queued
+	closed    bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// outboxRun is the checkpoint run ID an Outbox keeps its progress under.
+const outboxRun = "outbox"
+
+// NewOutbox opens the outbox in dir, creating it if needed, and starts
+// delivering anything already queued there to t. Close stops delivery
+// and closes t.
+func NewOutbox(dir string, t Transport, cfg OutboxConfig) (*Outbox, error) {
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = 100 * time.Millisecond
+	}
+	if cfg.MaxRetryWait == 0 {
+		cfg.MaxRetryWait = 30 * time.Second
+	}
+	cp, err := checkpoint.Open(dir, outboxRun)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	o := &Outbox{
+		inner:  t,
+		cfg:    cfg,
+		path:   filepath.Join(dir, "queue.jsonl"),
+		cp:     cp,
+		signal: make(chan struct{}, 1),
+		idle:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := o.openQueue(); err != nil {
+		cp.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel = cancel
+	go o.drain(ctx)
+	return o, nil
+}
+
+// openQueue opens the queue for appending and counts the lines already
+// in it. A line cut short by a crash mid-write is truncated away: its
+// Send never returned.
+func (o *Outbox) openQueue() error {
+	f, err := os.OpenFile(o.path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("outbox: %w", err)
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end < len(data) {
+		if err := f.Truncate(int64(end)); err != nil {
+			f.Close()
+			return fmt.Errorf("outbox: %w", err)
+		}
+	}
+	if _, err := f.Seek(int64(end), io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("outbox: %w", err)
+	}
+	r, err := os.Open(o.path)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("outbox: %w", err)
+	}
+	o.queue, o.reader = f, r
+	o.queued = bytes.Count(data[:end], []byte{'\n'})
+	if o.queued > 0 {
+		o.signal <- struct{}{}
+	} else {
+		close(o.idle)
+	}
+	return nil
+}
+
+// Send queues msg for delivery. It returns once msg is on disk.
+func (o *Outbox) Send(ctx context.Context, msg *protocol.Message) error {
+	return o.SendBatch(ctx, []*protocol.Message{msg})
+}
+
+// SendBatch queues msgs with a single write and sync. If any message
+// fails to encode, nothing is queued.
+func (o *Outbox) SendBatch(_ context.Context, msgs []*protocol.Message) error {
+	var buf []byte
+	for _, msg := range msgs {
+		data, err := msg.Marshal()
+		if err != nil {
+			return fmt.Errorf("outbox: marshal: %w", err)
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return misterrors.New(misterrors.CodeUnavailable, "outbox: closed").Permanent()
+	}
+	if _, err := o.queue.Write(buf); err != nil {
+		return misterrors.Wrap(misterrors.CodeTransport, err, "outbox: write")
+	}
+	if err := o.queue.Sync(); err != nil {
+		return misterrors.Wrap(misterrors.CodeTransport, err, "outbox: sync")
+	}
+	if o.queued == o.delivered {
+		o.idle = make(chan struct{})
+	}
+	o.queued += len(msgs)
+	select {
+	case o.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Receive receives from the destination.
+func (o *Outbox) Receive(ctx context.Context) (*protocol.Message, error) {
+	return o.inner.Receive(ctx)
+}
+
+// Pending returns the number of queued messages not yet delivered.
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.queued - o.delivered
+}
+
+// Flush waits until every message queued so far has been delivered, or
+// ctx is done.
+func (o *Outbox) Flush(ctx context.Context) error {
+	o.mu.Lock()
+	idle := o.idle
+	o.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return misterrors.Wrap(misterrors.CodeCancelled, ctx.Err(), "outbox: flush")
+	}
+}
+
+// Close stops delivery and closes the destination. Undelivered messages
+// stay queued for the next Outbox opened on the directory; call Flush
+// first to wait for them.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	o.mu.Unlock()
+
+	o.cancel()
+	<-o.done
+	return errors.Join(o.reader.Close(), o.queue.Close(), o.cp.Close(), o.inner.Close())
+}
+
+// drain delivers queued lines in order until ctx is done.
+func (o *Outbox) drain(ctx context.Context) {
+	defer close(o.done)
+	br := bufio.NewReader(o.reader)
+
+	for {
+		o.mu.Lock()
+		seq, queued := o.delivered, o.queued
+		o.mu.Unlock()
+
+		if seq == queued {
+			if seq > 0 {
+				o.compact(br)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.signal:
+				continue
+			}
+		}
+
+		// Lines up to queued were written in full before it was raised,
+		// so only an I/O error stops the read. Leave the rest queued.
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		if !o.deliver(ctx, seq, line) {
+			return
+		}
+
+		o.mu.Lock()
+		o.delivered++
+		if o.delivered == o.queued {
+			close(o.idle)
+		}
+		o.mu.Unlock()
+	}
+}
+
+// deliver sends the queued line at seq unless the checkpoint log shows
+// it was already delivered, retrying until it is accepted, refused for
+// good, or ctx is done. It reports false in the last case.
+func (o *Outbox) deliver(ctx context.Context, seq int, line []byte) bool {
+	step := strconv.Itoa(seq)
+	if o.cp.IsCompleted(step) {
+		return true
+	}
+	msg, err := protocol.Unmarshal(bytes.TrimSuffix(line, []byte{'\n'}))
+	if err != nil {
+		o.drop(nil, fmt.Errorf("outbox: queued message %d: %w", seq, err))
+		o.cp.Step(ctx, step, func(context.Context) (any, error) { return nil, nil })
+		return true
+	}
+
+	wait := o.cfg.RetryWait
+	for {
+		err := o.cp.Step(ctx, step, func(ctx context.Context) (any, error) {
+			return nil, o.inner.Send(ctx, msg)
+		})
+		switch {
+		case err == nil:
+			return true
+		case ctx.Err() != nil:
+			return false
+		case !misterrors.IsRetryable(err):
+			o.drop(msg, err)
+			o.cp.Step(ctx, step, func(context.Context) (any, error) { return nil, nil })
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, o.cfg.MaxRetryWait)
+	}
+}
+
+// compact empties the queue and checkpoint log once everything queued
+// has been delivered. The checkpoint log is reset first: a crash between
+// the two redelivers the queue rather than skipping new messages.
+func (o *Outbox) compact(br *bufio.Reader) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.delivered != o.queued || o.closed {
+		return
+	}
+	if err := o.cp.Reset(); err != nil {
+		return
+	}
+	if err := o.queue.Truncate(0); err != nil {
+		return
+	}
+	o.queue.Seek(0, io.SeekStart)
+	o.reader.Seek(0, io.SeekStart)
+	br.Reset(o.reader)
+	o.queued, o.delivered = 0, 0
+}
+
+func (o *Outbox) drop(msg *protocol.Message, err error) {
+	if o.cfg.OnDrop != nil {
+		o.cfg.OnDrop(msg, err)
+	}
+}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/checkpoint"
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func flush(t *testing.T, o *Outbox) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := o.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v (%d pending)", err, o.Pending())
+	}
+}
+
+func TestOutboxDelivers(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ch := NewChannel(10)
+	o, err := NewOutbox(dir, ch, OutboxConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	msgs := []*protocol.Message{ping(t), ping(t), ping(t)}
+	o.Send(ctx, msgs[0])
+	if err := o.SendBatch(ctx, msgs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, o)
+	for _, want := range msgs {
+		if got, _ := ch.Receive(ctx); got.ID != want.ID {
+			t.Fatalf("received %s, want %s", got.ID, want.ID)
+		}
+	}
+	// A fully delivered queue is truncated.
+	if info, _ := os.Stat(filepath.Join(dir, "queue.jsonl")); info.Size() != 0 {
+		t.Errorf("queue is %d bytes after delivery, want 0", info.Size())
+	}
+
+	// And keeps working afterwards.
+	o.Send(ctx, msgs[0])
+	flush(t, o)
+	if got, _ := ch.Receive(ctx); got.ID != msgs[0].ID {
+		t.Errorf("received %s after truncation, want %s", got.ID, msgs[0].ID)
+	}
+}
+
+func TestOutboxRetriesThroughOutage(t *testing.T) {
+	ctx := context.Background()
+	dst := &switchTransport{}
+	dst.setErr(misterrors.New(misterrors.CodeUnavailable, "down"))
+	o, _ := NewOutbox(t.TempDir(), dst, OutboxConfig{RetryWait: time.Millisecond, MaxRetryWait: 5 * time.Millisecond})
+	defer o.Close()
+
+	for range 3 {
+		if err := o.Send(ctx, ping(t)); err != nil {
+			t.Fatalf("Send during outage = %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if o.Pending() != 3 || dst.count() != 0 {
+		t.Fatalf("pending %d, sent %d during outage", o.Pending(), dst.count())
+	}
+	dst.setErr(nil)
+	flush(t, o)
+	if dst.count() != 3 {
+		t.Errorf("sent %d after recovery, want 3", dst.count())
+	}
+}
+
+// failAfter accepts n messages and then fails.
+type failAfter struct {
+	*Channel
+	mu sync.Mutex
+	n  int
+}
+
+func (f *failAfter) Send(ctx context.Context, msg *protocol.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n == 0 {
+		return misterrors.New(misterrors.CodeUnavailable, "down")
+	}
+	f.n--
+	return f.Channel.Send(ctx, msg)
+}
+
+func TestOutboxResumesAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	first := &failAfter{Channel: NewChannel(10), n: 1}
+	o, _ := NewOutbox(dir, first, OutboxConfig{RetryWait: time.Millisecond})
+	msgs := []*protocol.Message{ping(t), ping(t), ping(t)}
+	o.SendBatch(ctx, msgs)
+	if got, _ := first.Receive(ctx); got.ID != msgs[0].ID {
+		t.Fatalf("first delivery = %s", got.ID)
+	}
+	if _, err := NewOutbox(dir, NewChannel(1), OutboxConfig{}); !errors.Is(err, checkpoint.ErrConflict) {
+		t.Errorf("second outbox on the directory = %v, want ErrConflict", err)
+	}
+	o.Close()
+
+	// A crash mid-write leaves a partial line, which is discarded.
+	q, _ := os.OpenFile(filepath.Join(dir, "queue.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	q.WriteString(`{"version":"1","id":"torn`)
+	q.Close()
+
+	ch := NewChannel(10)
+	o, err := NewOutbox(dir, ch, OutboxConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	flush(t, o)
+	ch.Close()
+	var got []string
+	for msg, err := range Messages(ctx, ch) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.ID)
+	}
+	if len(got) != 2 || got[0] != msgs[1].ID || got[1] != msgs[2].ID {
+		t.Errorf("redelivered %v, want the two undelivered messages", got)
+	}
+}
+
+func TestOutboxDropsRefused(t *testing.T) {
+	ctx := context.Background()
+	dst := &switchTransport{}
+	dst.setErr(misterrors.New(misterrors.CodeValidation, "bad message").Permanent())
+	var dropped []string
+	o, _ := NewOutbox(t.TempDir(), dst, OutboxConfig{OnDrop: func(msg *protocol.Message, err error) {
+		dropped = append(dropped, msg.ID)
+	}})
+	defer o.Close()
+
+	msg := ping(t)
+	o.Send(ctx, msg)
+	flush(t, o)
+	if len(dropped) != 1 || dropped[0] != msg.ID {
+		t.Errorf("dropped %v, want %s", dropped, msg.ID)
+	}
+
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Send(ctx, msg); misterrors.IsRetryable(err) {
+		t.Errorf("Send after Close = %v, want a permanent error", err)
+	}
+}
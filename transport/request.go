@@ -0,0 +1,220 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// DefaultRequestTimeout bounds a request whose ctx has no deadline.
+const DefaultRequestTimeout = 30 * time.Second
+
+// ErrNoReply is returned when no response to a request arrives before
+// its deadline.
+var ErrNoReply = misterrors.New(misterrors.CodeTimeout, "transport: no reply")
+
+// Request sends msg on t and waits for the response: the next message
+// received on t whose CorrelationID is msg's ID (see
+// protocol.Message.Reply).
+//
+//	ping, _ := protocol.New("mist-cli", protocol.TypeHealthPing, protocol.HealthPing{From: "mist-cli"})
+//	pong, err := transport.Request(ctx, t, ping)
+//
+// If ctx has no deadline, DefaultRequestTimeout applies, and msg carries
+// the deadline to the responder unless it already has one. Messages
+// received while waiting that don't answer msg are dropped, so Request
+// suits a transport used only for this exchange, such as in mist ping.
+// To send requests concurrently, or keep other messages, use a
+// Requester; Request on a Requester uses it. Over HTTP the reply is the
+// response body (see HTTP.Request).
+func Request(ctx context.Context, t Transport, msg *protocol.Message) (*protocol.Message, error) {
+	switch r := t.(type) {
+	case *Requester:
+		return r.Request(ctx, msg)
+	case *HTTP:
+		return r.Request(ctx, msg)
+	}
+	ctx, cancel := requestContext(ctx, msg)
+	defer cancel()
+
+	if err := t.Send(ctx, msg); err != nil {
+		return nil, err
+	}
+	for {
+		resp, err := t.Receive(ctx)
+		if ctx.Err() != nil {
+			return nil, noReply(ctx, msg)
+		}
+		if endOfStream(resp, err) {
+			return nil, fmt.Errorf("transport: request %s: stream ended before reply", msg.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.IsReplyTo(msg.ID) {
+			return resp, nil
+		}
+	}
+}
+
+// requestContext applies DefaultRequestTimeout to ctx if it has no
+// deadline, and stamps the deadline on msg.
+func requestContext(ctx context.Context, msg *protocol.Message) (context.Context, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+	}
+	if dl, _ := ctx.Deadline(); msg.DeadlineNS == 0 {
+		msg.DeadlineNS = dl.UnixNano()
+	}
+	return ctx, cancel
+}
+
+// noReply returns the error for a request whose ctx ended first.
+func noReply(ctx context.Context, msg *protocol.Message) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return misterrors.Wrapf(misterrors.CodeTimeout, ErrNoReply, "transport: %s %s", msg.Type, msg.ID)
+	}
+	return ctx.Err()
+}
+
+// Requester matches responses to requests sent over one transport, so
+// any number of goroutines can have requests in flight at once. A
+// background loop receives from the transport and hands each response to
+// the request it answers; other messages go to the unmatched handler
+// passed to NewRequester. Requester implements Transport, so Send and
+// Close pass through; Receive is not supported, as the loop owns the
+// receive side.
+type Requester struct {
+	t         Transport
+	unmatched func(*protocol.Message)
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]chan *protocol.Message
+	err     error // why the loop stopped, once done is closed
+}
+
+// NewRequester starts matching responses received on t. Messages that
+// don't answer a pending request, including responses that arrive after
+// their request timed out, are passed to unmatched, which must not
+// block; if it is nil they are dropped.
+func NewRequester(t Transport, unmatched func(*protocol.Message)) *Requester {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Requester{
+		t:         t,
+		unmatched: unmatched,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		pending:   make(map[string]chan *protocol.Message),
+	}
+	go r.loop(ctx)
+	return r
+}
+
+// Request sends msg and waits for its response, as the Request function
+// does, without dropping other messages.
+func (r *Requester) Request(ctx context.Context, msg *protocol.Message) (*protocol.Message, error) {
+	ctx, cancel := requestContext(ctx, msg)
+	defer cancel()
+
+	ch := make(chan *protocol.Message, 1)
+	r.mu.Lock()
+	if r.err != nil {
+		err := r.err
+		r.mu.Unlock()
+		return nil, err
+	}
+	r.pending[msg.ID] = ch
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, msg.ID)
+		r.mu.Unlock()
+	}()
+
+	if err := r.t.Send(ctx, msg); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, noReply(ctx, msg)
+	case <-r.done:
+		return nil, r.err
+	}
+}
+
+// Send sends msg without waiting for a response.
+func (r *Requester) Send(ctx context.Context, msg *protocol.Message) error {
+	return r.t.Send(ctx, msg)
+}
+
+// Receive always fails: received messages are matched to requests or
+// passed to the unmatched handler.
+func (r *Requester) Receive(context.Context) (*protocol.Message, error) {
+	return nil, fmt.Errorf("transport: Requester does not support Receive; use the unmatched handler")
+}
+
+// Close stops the receive loop, failing pending requests, and closes the
+// underlying transport.
+func (r *Requester) Close() error {
+	r.cancel()
+	err := r.t.Close()
+	<-r.done
+	return err
+}
+
+func (r *Requester) loop(ctx context.Context) {
+	var stopErr error
+	for msg, err := range Messages(ctx, r.t) {
+		if err != nil {
+			if !misterrors.IsRetryable(err) {
+				stopErr = fmt.Errorf("transport: requester: %w", err)
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+		r.dispatch(msg)
+	}
+	switch {
+	case stopErr != nil:
+	case ctx.Err() != nil:
+		stopErr = fmt.Errorf("transport: requester closed")
+	default:
+		stopErr = fmt.Errorf("transport: requester: stream ended")
+	}
+	r.mu.Lock()
+	r.err = stopErr
+	r.mu.Unlock()
+	close(r.done)
+}
+
+// dispatch hands msg to the request it answers, or to the unmatched
+// handler.
+func (r *Requester) dispatch(msg *protocol.Message) {
+	if msg.CorrelationID != "" {
+		r.mu.Lock()
+		ch, ok := r.pending[msg.CorrelationID]
+		delete(r.pending, msg.CorrelationID)
+		r.mu.Unlock()
+		if ok {
+			ch <- msg
+			return
+		}
+	}
+	if r.unmatched != nil {
+		r.unmatched(msg)
+	}
+}
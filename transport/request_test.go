@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// echoResponder answers each ping received on t with a pong, after
+// first sending an unrelated message.
+func echoResponder(t *testing.T, ctx context.Context, tr Transport) {
+	t.Helper()
+	go func() {
+		for msg, err := range Messages(ctx, tr) {
+			if err != nil {
+				return
+			}
+			noise, _ := protocol.New("responder", protocol.TypeTraceSpan, protocol.TraceSpan{})
+			tr.Send(ctx, noise)
+			pong, _ := msg.Reply("responder", protocol.TypeHealthPong, protocol.HealthPong{From: "responder"})
+			tr.Send(ctx, pong)
+		}
+	}()
+}
+
+func TestRequest(t *testing.T) {
+	client, server := NewChannelPair(16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	echoResponder(t, ctx, server)
+
+	msg := ping(t)
+	resp, err := Request(ctx, client, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != protocol.TypeHealthPong || resp.CorrelationID != msg.ID {
+		t.Errorf("resp = %s, correlation %q", resp.Type, resp.CorrelationID)
+	}
+	if msg.DeadlineNS == 0 {
+		t.Error("request deadline not stamped on the message")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	client, _ := NewChannelPair(16)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := Request(ctx, client, ping(t))
+	if !errors.Is(err, ErrNoReply) || misterrors.Code(err) != misterrors.CodeTimeout {
+		t.Errorf("err = %v, want ErrNoReply", err)
+	}
+}
+
+func TestRequesterConcurrent(t *testing.T) {
+	client, server := NewChannelPair(64)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	echoResponder(t, ctx, server)
+
+	var mu sync.Mutex
+	var unmatched int
+	r := NewRequester(client, func(*protocol.Message) {
+		mu.Lock()
+		unmatched++
+		mu.Unlock()
+	})
+	defer r.Close()
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := ping(t)
+			resp, err := Request(ctx, r, msg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !resp.IsReplyTo(msg.ID) {
+				t.Errorf("reply to %s matched to %s", resp.CorrelationID, msg.ID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := unmatched
+		mu.Unlock()
+		if n == 10 || time.Now().After(deadline) {
+			if n != 10 {
+				t.Errorf("unmatched = %d, want 10", n)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRequesterStreamEnd(t *testing.T) {
+	client, server := NewChannelPair(16)
+	r := NewRequester(client, nil)
+	defer r.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Request(context.Background(), ping(t))
+		done <- err
+	}()
+	// Wait for the request to be sent, then end the stream unanswered.
+	if _, err := server.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected error when the stream ends")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request still pending after the stream ended")
+	}
+	if _, err := r.Request(context.Background(), ping(t)); err == nil {
+		t.Error("expected error from a stopped requester")
+	}
+}
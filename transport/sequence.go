@@ -0,0 +1,330 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// Meta keys stamped by WithSequencing.
+const (
+	MetaSeqStream = "seq_stream" // stream key and sender instance, "<key>@<instance>"
+	MetaSeq       = "seq"        // 1-based sequence number within the stream
+)
+
+// StreamKey assigns a message to an ordered stream. Messages in different
+// streams are sequenced independently.
+type StreamKey func(msg *protocol.Message) string
+
+// StreamByType sequences each message type as its own stream.
+func StreamByType(msg *protocol.Message) string { return msg.Type }
+
+// StreamBySource sequences each source tool as its own stream.
+func StreamBySource(msg *protocol.Message) string { return msg.Source }
+
+// SingleStream sequences every message as one stream with the given name.
+func SingleStream(name string) StreamKey {
+	return func(*protocol.Message) string { return name }
+}
+
+// SequenceOption configures WithSequencing.
+type SequenceOption func(*sequencer)
+
+// WithSequenceMetrics counts ordering problems on receive in
+// transport_seq_missing_total{stream} (messages skipped as missing) and
+// transport_seq_out_of_order_total{stream} (messages delivered late).
+func WithSequenceMetrics(reg *metrics.Registry) SequenceOption {
+	return func(s *sequencer) { s.metrics = reg }
+}
+
+// WithReorder buffers messages that arrive ahead of a gap for up to
+// window, or until maxBuffered are held for a stream, and delivers them
+// in sequence order once the gap fills. If it does not fill in time the
+// missing messages are counted and skipped.
+func WithReorder(window time.Duration, maxBuffered int) SequenceOption {
+	return func(s *sequencer) {
+		s.window = window
+		s.maxBuffered = maxBuffered
+	}
+}
+
+// WithStreamTTL forgets a stream on receive once no message has arrived
+// on it for ttl and nothing is buffered for it, so senders that restart
+// with new instance IDs don't grow the receiver's state without bound.
+// A forgotten stream starts afresh from its next message. Default 10
+// minutes; zero or negative keeps every stream.
+func WithStreamTTL(ttl time.Duration) SequenceOption {
+	return func(s *sequencer) { s.ttl = ttl }
+}
+
+// WithSequencing stamps outgoing messages with a per-stream sequence
+// number and checks it on receive, logging (with WithLogger) and
+// optionally counting gaps and reordering, so relays can be verified to
+// preserve ordering. Messages that already carry a sequence, such as
+// those being forwarded by a relay, keep their original stamps.
+//
+//	t := transport.Wrap(inner,
+//	    transport.WithLogger(logger),
+//	    transport.WithSequencing(transport.StreamByType,
+//	        transport.WithSequenceMetrics(reg),
+//	        transport.WithReorder(time.Second, 256)))
+func WithSequencing(key StreamKey, opts ...SequenceOption) MiddlewareOption {
+	s := &sequencer{
+		key:      key,
+		instance: newInstanceID(),
+		sent:     make(map[string]uint64),
+		streams:  make(map[string]*streamState),
+		ttl:      10 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return func(m *Middleware) { m.seq = s }
+}
+
+type sequencer struct {
+	key         StreamKey
+	instance    string
+	metrics     *metrics.Registry
+	logger      *slog.Logger
+	window      time.Duration
+	maxBuffered int
+	ttl         time.Duration
+
+	mu      sync.Mutex
+	sent    map[string]uint64
+	streams map[string]*streamState
+	ready   []*protocol.Message
+	swept   time.Time // when idle streams were last evicted
+}
+
+type streamState struct {
+	name    string // stream key without the sender instance, for metrics
+	next    uint64
+	pending map[uint64]*protocol.Message
+	since   time.Time // when the oldest pending message arrived
+	seen    time.Time // when the latest message arrived
+}
+
+func newInstanceID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// stamp assigns the next sequence number for msg's stream.
+func (s *sequencer) stamp(msg *protocol.Message) {
+	if msg.Meta[MetaSeq] != "" {
+		return
+	}
+	stream := s.key(msg) + "@" + s.instance
+
+	s.mu.Lock()
+	s.sent[stream]++
+	n := s.sent[stream]
+	s.mu.Unlock()
+
+	if msg.Meta == nil {
+		msg.Meta = make(map[string]string)
+	}
+	msg.Meta[MetaSeqStream] = stream
+	msg.Meta[MetaSeq] = strconv.FormatUint(n, 10)
+}
+
+// receive returns the next message in sequence order, reading from recv
+// as needed. Without reordering every message is returned as it arrives.
+func (s *sequencer) receive(ctx context.Context, recv func(context.Context) (*protocol.Message, error)) (*protocol.Message, error) {
+	for {
+		s.mu.Lock()
+		if len(s.ready) > 0 {
+			msg := s.ready[0]
+			s.ready = s.ready[1:]
+			s.mu.Unlock()
+			return msg, nil
+		}
+		flushAt, waiting := s.flushDeadline()
+		s.mu.Unlock()
+
+		rctx, cancel := ctx, context.CancelFunc(func() {})
+		if waiting {
+			rctx, cancel = context.WithDeadline(ctx, flushAt)
+		}
+		msg, err := recv(rctx)
+		cancel()
+
+		s.mu.Lock()
+		switch {
+		case err != nil && waiting && ctx.Err() == nil && rctx.Err() != nil:
+			s.flush(time.Now(), false)
+		case err != nil:
+			s.mu.Unlock()
+			return nil, err
+		case msg == nil:
+			// The transport is drained; deliver whatever is held.
+			s.flush(time.Now(), true)
+			if len(s.ready) == 0 {
+				s.mu.Unlock()
+				return nil, nil
+			}
+		default:
+			s.observe(msg, time.Now())
+		}
+		s.mu.Unlock()
+	}
+}
+
+// flushDeadline returns when the oldest buffered message times out.
+func (s *sequencer) flushDeadline() (time.Time, bool) {
+	var at time.Time
+	for _, st := range s.streams {
+		if len(st.pending) > 0 && (at.IsZero() || st.since.Before(at)) {
+			at = st.since
+		}
+	}
+	if at.IsZero() {
+		return at, false
+	}
+	return at.Add(s.window), true
+}
+
+// observe checks msg's sequence and queues it, or buffers it when
+// reordering and it arrived ahead of a gap.
+func (s *sequencer) observe(msg *protocol.Message, now time.Time) {
+	stream := msg.Meta[MetaSeqStream]
+	n, err := strconv.ParseUint(msg.Meta[MetaSeq], 10, 64)
+	if stream == "" || err != nil || n == 0 {
+		s.ready = append(s.ready, msg)
+		return
+	}
+
+	s.evictIdle(now)
+	st, ok := s.streams[stream]
+	if !ok {
+		// Start from the first message seen; earlier ones may have been
+		// delivered before this receiver started.
+		name := stream
+		for i := len(stream) - 1; i >= 0; i-- {
+			if stream[i] == '@' {
+				name = stream[:i]
+				break
+			}
+		}
+		st = &streamState{name: name, next: n}
+		s.streams[stream] = st
+	}
+	st.seen = now
+
+	switch {
+	case n == st.next:
+		s.ready = append(s.ready, msg)
+		st.next++
+		s.drain(st)
+	case n < st.next:
+		s.outOfOrder(st, msg, n)
+		s.ready = append(s.ready, msg)
+	case s.window > 0:
+		if st.pending == nil {
+			st.pending = make(map[uint64]*protocol.Message)
+		}
+		if len(st.pending) == 0 {
+			st.since = now
+		}
+		st.pending[n] = msg
+		if s.maxBuffered > 0 && len(st.pending) >= s.maxBuffered {
+			s.skip(st)
+		}
+	default:
+		s.missing(st, n-st.next)
+		s.ready = append(s.ready, msg)
+		st.next = n + 1
+	}
+}
+
+// evictIdle forgets streams idle for the TTL with nothing buffered. It
+// scans at most twice per TTL.
+func (s *sequencer) evictIdle(now time.Time) {
+	if s.ttl <= 0 || now.Sub(s.swept) < s.ttl/2 {
+		return
+	}
+	s.swept = now
+	for key, st := range s.streams {
+		if len(st.pending) == 0 && now.Sub(st.seen) >= s.ttl {
+			delete(s.streams, key)
+		}
+	}
+}
+
+// drain queues buffered messages that are now in sequence.
+func (s *sequencer) drain(st *streamState) {
+	for {
+		msg, ok := st.pending[st.next]
+		if !ok {
+			return
+		}
+		delete(st.pending, st.next)
+		s.ready = append(s.ready, msg)
+		st.next++
+	}
+}
+
+// skip gives up on a stream's gap: the missing messages are counted and
+// delivery resumes from the lowest buffered sequence number.
+func (s *sequencer) skip(st *streamState) {
+	lowest := uint64(0)
+	for n := range st.pending {
+		if lowest == 0 || n < lowest {
+			lowest = n
+		}
+	}
+	if lowest == 0 {
+		return
+	}
+	s.missing(st, lowest-st.next)
+	st.next = lowest
+	s.drain(st)
+	if len(st.pending) > 0 {
+		st.since = time.Now()
+	}
+}
+
+// flush skips gaps in streams whose buffer has waited out the window, or
+// in every stream if all is set.
+func (s *sequencer) flush(now time.Time, all bool) {
+	names := make([]string, 0, len(s.streams))
+	for name := range s.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := s.streams[name]
+		for len(st.pending) > 0 && (all || !now.Before(st.since.Add(s.window))) {
+			s.skip(st)
+		}
+	}
+}
+
+func (s *sequencer) missing(st *streamState, n uint64) {
+	if s.logger != nil {
+		s.logger.Warn("sequence gap", "stream", st.name, "expected", st.next, "missing", n)
+	}
+	if s.metrics != nil {
+		s.metrics.Counter("transport_seq_missing_total", "stream", st.name).Add(int64(n))
+	}
+}
+
+func (s *sequencer) outOfOrder(st *streamState, msg *protocol.Message, n uint64) {
+	if s.logger != nil {
+		s.logger.Warn("message out of order", "stream", st.name, "seq", n, "expected", st.next, "msg_id", msg.ID)
+	}
+	if s.metrics != nil {
+		s.metrics.Counter("transport_seq_out_of_order_total", "stream", st.name).Inc()
+	}
+}
@@ -0,0 +1,172 @@
+package transport
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// sequenced sends n messages through a sequencing middleware and returns
+// them as stamped, without delivering them anywhere.
+func sequenced(t *testing.T, n int) []*protocol.Message {
+	t.Helper()
+	ch := NewChannel(n)
+	m := Wrap(ch, WithSequencing(SingleStream("s")))
+	msgs := make([]*protocol.Message, n)
+	for i := range msgs {
+		msgs[i], _ = protocol.New("test", protocol.TypeHealthPing, protocol.HealthPing{From: "test"})
+		if err := m.Send(context.Background(), msgs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msgs
+}
+
+func receiveSeqs(t *testing.T, m *Middleware, n int) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var got []string
+	for range n {
+		msg, err := m.Receive(ctx)
+		if err != nil {
+			t.Fatalf("Receive: %v (got %v)", err, got)
+		}
+		got = append(got, msg.Meta[MetaSeq])
+	}
+	return got
+}
+
+func TestSequencingStamps(t *testing.T) {
+	msgs := sequenced(t, 3)
+	for i, msg := range msgs {
+		if want := string(rune('1' + i)); msg.Meta[MetaSeq] != want {
+			t.Errorf("msg %d seq = %q, want %s", i, msg.Meta[MetaSeq], want)
+		}
+		if msg.Meta[MetaSeqStream] != msgs[0].Meta[MetaSeqStream] {
+			t.Errorf("msg %d stream = %q", i, msg.Meta[MetaSeqStream])
+		}
+	}
+
+	// A relay forwarding a stamped message keeps the original sequence.
+	fwd := Wrap(NewChannel(1), WithSequencing(SingleStream("s")))
+	fwd.Send(context.Background(), msgs[1])
+	if msgs[1].Meta[MetaSeq] != "2" {
+		t.Errorf("forwarded seq = %q, want 2", msgs[1].Meta[MetaSeq])
+	}
+}
+
+func TestSequencingDetectsGapsAndReordering(t *testing.T) {
+	msgs := sequenced(t, 5)
+	reg := metrics.NewRegistry()
+
+	ch := NewChannel(8)
+	for _, i := range []int{0, 2, 1, 4} { // 3 is lost, 1 arrives late
+		ch.Send(context.Background(), msgs[i])
+	}
+
+	m := Wrap(ch, WithSequencing(SingleStream("s"), WithSequenceMetrics(reg)))
+	got := receiveSeqs(t, m, 4)
+	want := []string{"1", "3", "2", "5"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("delivered %v, want %v", got, want)
+		}
+	}
+
+	if n := reg.Counter("transport_seq_missing_total", "stream", "s").Value(); n != 2 {
+		t.Errorf("missing = %d, want 2 (2 skipped, then 4)", n)
+	}
+	if n := reg.Counter("transport_seq_out_of_order_total", "stream", "s").Value(); n != 1 {
+		t.Errorf("out_of_order = %d, want 1", n)
+	}
+}
+
+func TestSequencingReorder(t *testing.T) {
+	msgs := sequenced(t, 4)
+	reg := metrics.NewRegistry()
+
+	ch := NewChannel(8)
+	for _, i := range []int{0, 2, 3, 1} {
+		ch.Send(context.Background(), msgs[i])
+	}
+
+	m := Wrap(ch, WithSequencing(SingleStream("s"), WithSequenceMetrics(reg), WithReorder(time.Second, 16)))
+	got := receiveSeqs(t, m, 4)
+	want := []string{"1", "2", "3", "4"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("delivered %v, want %v", got, want)
+		}
+	}
+	if n := reg.Counter("transport_seq_missing_total", "stream", "s").Value(); n != 0 {
+		t.Errorf("missing = %d, want 0", n)
+	}
+}
+
+func TestSequencingReorderWindowExpires(t *testing.T) {
+	msgs := sequenced(t, 3)
+	reg := metrics.NewRegistry()
+
+	ch := NewChannel(8)
+	ch.Send(context.Background(), msgs[0])
+	ch.Send(context.Background(), msgs[2]) // 2 never arrives
+
+	m := Wrap(ch, WithSequencing(SingleStream("s"), WithSequenceMetrics(reg), WithReorder(20*time.Millisecond, 16)))
+	start := time.Now()
+	got := receiveSeqs(t, m, 2)
+	if got[0] != "1" || got[1] != "3" {
+		t.Errorf("delivered %v, want [1 3]", got)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("gap skipped after %v, before the window", d)
+	}
+	if n := reg.Counter("transport_seq_missing_total", "stream", "s").Value(); n != 1 {
+		t.Errorf("missing = %d, want 1", n)
+	}
+}
+
+func TestSequencingReorderBufferFull(t *testing.T) {
+	msgs := sequenced(t, 4)
+
+	ch := NewChannel(8)
+	for _, i := range []int{0, 2, 3} {
+		ch.Send(context.Background(), msgs[i])
+	}
+
+	m := Wrap(ch, WithSequencing(SingleStream("s"), WithReorder(time.Hour, 2)))
+	got := receiveSeqs(t, m, 3)
+	if got[0] != "1" || got[1] != "3" || got[2] != "4" {
+		t.Errorf("delivered %v, want [1 3 4]", got)
+	}
+}
+
+func TestSequencingEvictsIdleStreams(t *testing.T) {
+	m := Wrap(NewChannel(1), WithSequencing(StreamBySource, WithStreamTTL(time.Minute)))
+	seq := m.seq
+	msg := func(stream, n string) *protocol.Message {
+		return &protocol.Message{Meta: map[string]string{MetaSeqStream: stream, MetaSeq: n}}
+	}
+
+	start := time.Now()
+	for i := range 100 {
+		seq.observe(msg("old@"+strconv.Itoa(i), "1"), start)
+	}
+	seq.observe(msg("live@x", "1"), start.Add(50*time.Second))
+	seq.observe(msg("live@x", "2"), start.Add(90*time.Second))
+	if len(seq.streams) != 1 || seq.streams["live@x"] == nil {
+		t.Fatalf("streams after the TTL = %d, want only live@x", len(seq.streams))
+	}
+
+	// A forgotten stream starts afresh rather than reporting a gap.
+	reg := metrics.NewRegistry()
+	seq.metrics = reg
+	seq.observe(msg("old@0", "7"), start.Add(100*time.Second))
+	if n := reg.Counter("transport_seq_missing_total", "stream", "old").Value(); n != 0 {
+		t.Errorf("missing = %d after eviction, want 0", n)
+	}
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+// DefaultMaxMessageSize is the largest encoded message the HTTP, file,
+// and stdio transports send or receive unless SetMaxMessageSize changes
+// it. In-process channels are unlimited by default since their messages
+// are never encoded.
+const DefaultMaxMessageSize = 1 << 20
+
+// ErrMessageTooLarge is returned for a message whose encoded size exceeds
+// the transport's limit. It is not retryable: resending the same message
+// fails the same way.
+var ErrMessageTooLarge = misterrors.New(misterrors.CodeValidation, "transport: message too large").Permanent()
+
+// SizeLimiter is implemented by transports that bound the encoded size of
+// messages they send and receive.
+type SizeLimiter interface {
+	// SetMaxMessageSize sets the limit in bytes; zero or less removes it.
+	// If reg is non-nil, rejected messages are counted in
+	// transport_oversize_total{transport, direction}.
+	SetMaxMessageSize(n int, reg *metrics.Registry)
+}
+
+// sizeLimit is the limit state shared by the built-in transports.
+type sizeLimit struct {
+	max     int
+	metrics *metrics.Registry
+}
+
+// check returns ErrMessageTooLarge if size exceeds the limit, counting the
+// rejection.
+func (l sizeLimit) check(transport, direction string, size int, msgType string) error {
+	if l.max <= 0 || size <= l.max {
+		return nil
+	}
+	l.reject(transport, direction)
+	if msgType == "" {
+		return misterrors.Wrapf(misterrors.CodeValidation, ErrMessageTooLarge, "%s %s: over %d bytes", transport, direction, l.max)
+	}
+	return misterrors.Wrapf(misterrors.CodeValidation, ErrMessageTooLarge, "%s %s %s: %d bytes exceeds %d", transport, direction, msgType, size, l.max)
+}
+
+func (l sizeLimit) reject(transport, direction string) {
+	if l.metrics != nil {
+		l.metrics.Counter("transport_oversize_total", "transport", transport, "direction", direction).Inc()
+	}
+}
+
+// scanner returns a line scanner for r that refuses lines over the limit.
+func (l sizeLimit) scanner(r io.Reader) *bufio.Scanner {
+	max := math.MaxInt32
+	if l.max > 0 {
+		max = l.max + 1 // room for the newline
+	}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, min(max, 64<<10)), max)
+	return s
+}
+
+// scanErr converts a scanner's too-long error to ErrMessageTooLarge.
+func (l sizeLimit) scanErr(transport string, err error) error {
+	if errors.Is(err, bufio.ErrTooLong) && l.max > 0 {
+		return l.check(transport, "receive", l.max+1, "")
+	}
+	return err
+}
+
+// WithMaxMessageSize rejects messages over n encoded bytes on send and
+// receive with ErrMessageTooLarge (errors.CodeValidation). If the wrapped
+// transport is a SizeLimiter the limit is enforced there, so oversized
+// input is refused before it is read into memory; otherwise the
+// middleware encodes each message to check it. Oversized sends are not
+// retried.
+func WithMaxMessageSize(n int) MiddlewareOption {
+	return func(m *Middleware) { m.size.max = n }
+}
+
+// WithSizeMetrics counts messages rejected by WithMaxMessageSize in
+// transport_oversize_total{transport, direction}.
+func WithSizeMetrics(reg *metrics.Registry) MiddlewareOption {
+	return func(m *Middleware) { m.size.metrics = reg }
+}
+
+// checkSize enforces the middleware's own size limit on msg.
+func (m *Middleware) checkSize(direction string, msg *protocol.Message) error {
+	if !m.sizeCheck {
+		return nil
+	}
+	data, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	return m.size.check("middleware", direction, len(data), msg.Type)
+}
+
+// readLimit is how much of an input to read under l: one byte past the
+// limit, so oversize input is detected without reading all of it.
+func (l sizeLimit) readLimit() int64 {
+	if l.max <= 0 {
+		return math.MaxInt64
+	}
+	return int64(l.max) + 1
+}
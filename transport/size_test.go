@@ -0,0 +1,110 @@
+package transport
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func sizedMessage(t *testing.T, n int) *protocol.Message {
+	t.Helper()
+	msg, err := protocol.New("test", protocol.TypeHealthPing, map[string]string{"pad": strings.Repeat("x", n)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestMiddlewareMaxMessageSize(t *testing.T) {
+	reg := metrics.NewRegistry()
+	ch := NewChannel(4)
+	m := Wrap(ch, WithMaxMessageSize(512), WithSizeMetrics(reg), WithRetry(RetryPolicy{MaxAttempts: 3, InitialWait: time.Millisecond, Multiplier: 1}))
+
+	err := m.Send(context.Background(), sizedMessage(t, 1024))
+	if !misterrors.Is(err, ErrMessageTooLarge) || misterrors.Code(err) != misterrors.CodeValidation {
+		t.Fatalf("err = %v", err)
+	}
+	if len(ch.recv) != 0 {
+		t.Error("oversized message was sent")
+	}
+	if n := reg.Counter("transport_oversize_total", "transport", "channel", "direction", "send").Value(); n != 1 {
+		t.Errorf("oversize count = %d, want 1 (no retries)", n)
+	}
+	if err := m.Send(context.Background(), sizedMessage(t, 10)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// plain hides a transport's SizeLimiter so the middleware checks sizes.
+type plain struct{ Transport }
+
+func TestMiddlewareMaxMessageSizeReceive(t *testing.T) {
+	ch := NewChannel(4)
+	ch.Send(context.Background(), sizedMessage(t, 1024))
+	m := Wrap(plain{ch}, WithMaxMessageSize(512))
+	if _, err := m.Receive(context.Background()); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestFileMaxMessageSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msgs.jsonl")
+	f, _ := NewFile(path)
+	defer f.Close()
+	f.SetMaxMessageSize(512, nil)
+	if err := f.Send(context.Background(), sizedMessage(t, 1024)); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("send err = %v", err)
+	}
+
+	// A line written by a peer with a larger limit is refused on read.
+	big, _ := sizedMessage(t, 1024).Marshal()
+	os.WriteFile(path, append(big, '\n'), 0600)
+	reg := metrics.NewRegistry()
+	r, _ := NewFile(path)
+	defer r.Close()
+	r.SetMaxMessageSize(512, reg)
+	if _, err := r.Receive(context.Background()); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("receive err = %v", err)
+	}
+	if n := reg.Counter("transport_oversize_total", "transport", "file", "direction", "receive").Value(); n != 1 {
+		t.Errorf("oversize count = %d", n)
+	}
+}
+
+func TestHTTPMaxMessageSize(t *testing.T) {
+	reg := metrics.NewRegistry()
+	srv := NewHTTP("")
+	srv.SetMaxMessageSize(512, reg)
+	addr := listenHTTP(t, srv)
+
+	client := NewHTTP("http://" + addr + "/mist")
+	client.SetMaxMessageSize(0, nil)
+	var sendErr error
+	for i := 0; i < 50; i++ {
+		if sendErr = client.Send(context.Background(), sizedMessage(t, 1024)); sendErr == nil || misterrors.Is(sendErr, ErrMessageTooLarge) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !misterrors.Is(sendErr, ErrMessageTooLarge) {
+		t.Fatalf("send err = %v, want receiver rejection", sendErr)
+	}
+	if n := reg.Counter("transport_oversize_total", "transport", "http", "direction", "receive").Value(); n != 1 {
+		t.Errorf("oversize count = %d", n)
+	}
+	if err := client.Send(context.Background(), sizedMessage(t, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sender's own default limit applies before anything is sent.
+	if err := NewHTTP("http://"+addr+"/mist").Send(context.Background(), sizedMessage(t, DefaultMaxMessageSize)); !misterrors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("default send limit err = %v", err)
+	}
+}
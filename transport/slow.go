@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+)
+
+// ErrSendTimeout is returned by a Middleware send attempt that
+// WithSendTimeout cut short. It is retryable: WithRetry tries again, and
+// a Failover moves to its next destination.
+var ErrSendTimeout = misterrors.New(misterrors.CodeTimeout, "transport: send timed out")
+
+// WithSendTimeout bounds each send attempt to d, whatever the caller's
+// ctx allows, so a stalled destination fails the send instead of
+// hanging a relay forever. Each retry under WithRetry gets its own d.
+func WithSendTimeout(d time.Duration) MiddlewareOption {
+	return func(m *Middleware) { m.sendTimeout = d }
+}
+
+// SlowConsumerPolicy configures WithSlowConsumer.
+type SlowConsumerPolicy struct {
+	// Threshold is how long a send attempt may block before it counts
+	// as slow (default 1s).
+	Threshold time.Duration
+
+	// StallAfter is how long the destination must stay slow — one send
+	// blocked, or every send since the first slow one slow or timed out —
+	// before OnStall is called (default 10s).
+	StallAfter time.Duration
+
+	// OnStall is called once when the destination has been slow for
+	// StallAfter, with how long it has been slow so far. Sends continue;
+	// this is the place to log, alert, or fail over.
+	OnStall func(slowFor time.Duration)
+
+	// OnRecover is called when a stalled destination accepts a send
+	// within Threshold again, with how long it was slow.
+	OnRecover func(slowFor time.Duration)
+
+	// Metrics, if set, records the time each send attempt blocked in
+	// the transport_send_blocked_ms histogram, counts slow and timed-out
+	// attempts in transport_send_slow_total and
+	// transport_send_timeouts_total, and sets transport_send_stalled to
+	// 1 while the destination is stalled.
+	Metrics *metrics.Registry
+}
+
+// WithSlowConsumer watches how long the destination takes to accept
+// each send, reporting a destination that stays slow through the
+// policy's callbacks and metrics. Combine it with WithSendTimeout so
+// stalled sends also fail.
+func WithSlowConsumer(p SlowConsumerPolicy) MiddlewareOption {
+	return func(m *Middleware) { m.slow = newSlowConsumer(p) }
+}
+
+// slowConsumer tracks how long sends to one destination block.
+type slowConsumer struct {
+	policy   SlowConsumerPolicy
+	blocked  *metrics.Histogram
+	slowN    *metrics.Counter
+	timeouts *metrics.Counter
+	stalledG *metrics.Gauge
+
+	mu        sync.Mutex
+	slowSince time.Time // zero while sends are fast
+	stalled   bool
+}
+
+func newSlowConsumer(p SlowConsumerPolicy) *slowConsumer {
+	if p.Threshold <= 0 {
+		p.Threshold = time.Second
+	}
+	if p.StallAfter <= 0 {
+		p.StallAfter = 10 * time.Second
+	}
+	s := &slowConsumer{policy: p}
+	if reg := p.Metrics; reg != nil {
+		s.blocked = reg.Histogram("transport_send_blocked_ms", metrics.DefaultBuckets)
+		s.slowN = reg.Counter("transport_send_slow_total")
+		s.timeouts = reg.Counter("transport_send_timeouts_total")
+		s.stalledG = reg.Gauge("transport_send_stalled")
+	}
+	return s
+}
+
+// begin marks the start of a send attempt made in ctx. The returned func
+// ends it; until then, a watchdog reports a stall if the attempt blocks
+// for StallAfter, as a hung destination may never return at all.
+func (s *slowConsumer) begin(ctx context.Context) func(timedOut bool) {
+	start := time.Now()
+	watchdog := time.AfterFunc(s.policy.StallAfter, func() { s.stall(start) })
+	return func(timedOut bool) {
+		watchdog.Stop()
+		s.end(ctx, start, timedOut)
+	}
+}
+
+// end records an attempt that started at start and has just returned.
+// A traced attempt becomes the exemplar of its blocked-time bucket.
+func (s *slowConsumer) end(ctx context.Context, start time.Time, timedOut bool) {
+	now := time.Now()
+	d := now.Sub(start)
+	slow := timedOut || d > s.policy.Threshold
+	if s.blocked != nil {
+		s.blocked.ObserveContext(ctx, float64(d)/float64(time.Millisecond))
+		if slow {
+			s.slowN.Inc()
+		}
+		if timedOut {
+			s.timeouts.Inc()
+		}
+	}
+
+	if !slow {
+		s.mu.Lock()
+		since, stalled := s.slowSince, s.stalled
+		s.slowSince, s.stalled = time.Time{}, false
+		s.mu.Unlock()
+		if stalled {
+			if s.stalledG != nil {
+				s.stalledG.Set(0)
+			}
+			if s.policy.OnRecover != nil {
+				s.policy.OnRecover(now.Sub(since))
+			}
+		}
+		return
+	}
+	if now.Sub(s.markSlow(start)) >= s.policy.StallAfter {
+		s.stall(start)
+	}
+}
+
+// markSlow records that sends have been slow since start, unless they
+// already were, and returns when they became slow.
+func (s *slowConsumer) markSlow(start time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slowSince.IsZero() || start.Before(s.slowSince) {
+		s.slowSince = start
+	}
+	return s.slowSince
+}
+
+// stall reports the destination stalled, once per slow period.
+func (s *slowConsumer) stall(start time.Time) {
+	since := s.markSlow(start)
+	s.mu.Lock()
+	if s.stalled {
+		s.mu.Unlock()
+		return
+	}
+	s.stalled = true
+	s.mu.Unlock()
+
+	if s.stalledG != nil {
+		s.stalledG.Set(1)
+	}
+	if s.policy.OnStall != nil {
+		s.policy.OnStall(time.Since(since))
+	}
+}
+
+// bound applies WithSendTimeout and WithSlowConsumer to one send
+// attempt.
+func (m *Middleware) bound(send func(context.Context) error) func(context.Context) error {
+	if m.sendTimeout <= 0 && m.slow == nil {
+		return send
+	}
+	return func(ctx context.Context) error {
+		attemptCtx := ctx
+		if m.sendTimeout > 0 {
+			var cancel context.CancelFunc
+			attemptCtx, cancel = context.WithTimeout(ctx, m.sendTimeout)
+			defer cancel()
+		}
+		var end func(bool)
+		if m.slow != nil {
+			end = m.slow.begin(ctx)
+		}
+
+		err := send(attemptCtx)
+		timedOut := err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		if end != nil {
+			end(timedOut)
+		}
+		if timedOut {
+			return misterrors.Wrapf(misterrors.CodeTimeout, ErrSendTimeout, "transport: send blocked for %s", m.sendTimeout)
+		}
+		return err
+	}
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	misterrors "github.com/greynewell/mist-go/errors"
+	"github.com/greynewell/mist-go/metrics"
+	"github.com/greynewell/mist-go/protocol"
+)
+
+func TestSendTimeout(t *testing.T) {
+	reg := metrics.NewRegistry()
+	m := Wrap(&hungTransport{}, WithSendTimeout(20*time.Millisecond),
+		WithSlowConsumer(SlowConsumerPolicy{Threshold: 10 * time.Millisecond, Metrics: reg}))
+
+	start := time.Now()
+	err := m.Send(context.Background(), ping(t))
+	if !misterrors.Is(err, ErrSendTimeout) || !misterrors.IsRetryable(err) {
+		t.Fatalf("Send = %v, want retryable ErrSendTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Send blocked %s despite the timeout", d)
+	}
+	err = m.SendBatch(context.Background(), []*protocol.Message{ping(t), ping(t)})
+	if !misterrors.Is(err, ErrSendTimeout) {
+		t.Errorf("SendBatch = %v, want ErrSendTimeout", err)
+	}
+
+	if n := reg.Counter("transport_send_timeouts_total").Value(); n != 2 {
+		t.Errorf("timeouts = %d, want 2", n)
+	}
+	if n := reg.Counter("transport_send_slow_total").Value(); n != 2 {
+		t.Errorf("slow = %d, want 2", n)
+	}
+	if h := reg.Histogram("transport_send_blocked_ms", metrics.DefaultBuckets).Snapshot(); h.Count != 2 || h.Min < 20 {
+		t.Errorf("blocked_ms = %+v", h)
+	}
+
+	// The caller's own cancellation is not a send timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Send(ctx, ping(t)); misterrors.Is(err, ErrSendTimeout) {
+		t.Errorf("cancelled Send = %v, want the ctx error", err)
+	}
+}
+
+// slowTransport blocks each Send for delay.
+type slowTransport struct {
+	switchTransport
+	delay atomic.Int64
+}
+
+func (s *slowTransport) Send(ctx context.Context, msg *protocol.Message) error {
+	select {
+	case <-time.After(time.Duration(s.delay.Load())):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.switchTransport.Send(ctx, msg)
+}
+
+func TestSlowConsumerStallAndRecover(t *testing.T) {
+	dst := &slowTransport{}
+	dst.delay.Store(int64(15 * time.Millisecond))
+	reg := metrics.NewRegistry()
+	stalls := make(chan time.Duration, 4)
+	recovered := make(chan time.Duration, 4)
+	m := Wrap(dst, WithSlowConsumer(SlowConsumerPolicy{
+		Threshold:  5 * time.Millisecond,
+		StallAfter: 40 * time.Millisecond,
+		OnStall:    func(d time.Duration) { stalls <- d },
+		OnRecover:  func(d time.Duration) { recovered <- d },
+		Metrics:    reg,
+	}))
+
+	// Consecutive slow sends add up to a stall, reported once.
+	for range 5 {
+		if err := m.Send(context.Background(), ping(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case d := <-stalls:
+		if d < 40*time.Millisecond {
+			t.Errorf("stalled after %s, want >= 40ms", d)
+		}
+	default:
+		t.Fatal("OnStall not called")
+	}
+	if len(stalls) != 0 {
+		t.Errorf("OnStall called %d more times", len(stalls))
+	}
+	if g := reg.Gauge("transport_send_stalled").Value(); g != 1 {
+		t.Errorf("stalled gauge = %g, want 1", g)
+	}
+
+	dst.delay.Store(0)
+	if err := m.Send(context.Background(), ping(t)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-recovered:
+	default:
+		t.Fatal("OnRecover not called")
+	}
+	if g := reg.Gauge("transport_send_stalled").Value(); g != 0 {
+		t.Errorf("stalled gauge = %g after recovery", g)
+	}
+}
+
+func TestSlowConsumerWatchdog(t *testing.T) {
+	// A send that never returns is reported while it is still blocked.
+	stalls := make(chan time.Duration, 1)
+	m := Wrap(&hungTransport{}, WithSlowConsumer(SlowConsumerPolicy{
+		StallAfter: 20 * time.Millisecond,
+		OnStall:    func(d time.Duration) { stalls <- d },
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	msg := ping(t)
+	done := make(chan error)
+	go func() { done <- m.Send(ctx, msg) }()
+
+	select {
+	case <-stalls:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnStall not called for a hung send")
+	}
+	cancel()
+	<-done
+}
@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 
+	"github.com/greynewell/mist-go/metrics"
 	"github.com/greynewell/mist-go/protocol"
 )
 
@@ -17,13 +19,20 @@ import (
 type Stdio struct {
 	mu      sync.Mutex
 	scanner *bufio.Scanner
+	size    sizeLimit
 }
 
 // NewStdio creates a stdio transport.
 func NewStdio() *Stdio {
-	s := bufio.NewScanner(os.Stdin)
-	s.Buffer(make([]byte, 1<<20), 1<<20)
-	return &Stdio{scanner: s}
+	return &Stdio{size: sizeLimit{max: DefaultMaxMessageSize}}
+}
+
+// SetMaxMessageSize limits the length of lines written and read. Default
+// DefaultMaxMessageSize. Call it before the first Receive.
+func (s *Stdio) SetMaxMessageSize(n int, reg *metrics.Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = sizeLimit{max: n, metrics: reg}
 }
 
 // Send writes a JSON-encoded message to stdout.
@@ -36,19 +45,29 @@ func (s *Stdio) Send(_ context.Context, msg *protocol.Message) error {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if err := s.size.check("stdio", "send", len(data)-1, msg.Type); err != nil {
+		return err
+	}
 	_, err = os.Stdout.Write(data)
 	return err
 }
 
 // Receive reads the next JSON line from stdin.
 func (s *Stdio) Receive(_ context.Context) (*protocol.Message, error) {
-	if !s.scanner.Scan() {
-		if err := s.scanner.Err(); err != nil {
-			return nil, err
+	s.mu.Lock()
+	if s.scanner == nil {
+		s.scanner = s.size.scanner(os.Stdin)
+	}
+	scanner, size := s.scanner, s.size
+	s.mu.Unlock()
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, size.scanErr("stdio", err)
 		}
-		return nil, fmt.Errorf("stdio transport: stdin closed")
+		return nil, fmt.Errorf("stdio transport: stdin closed: %w", io.EOF)
 	}
-	return protocol.Unmarshal(s.scanner.Bytes())
+	return protocol.Unmarshal(scanner.Bytes())
 }
 
 // Close is a no-op for stdio.
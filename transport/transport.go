@@ -9,14 +9,17 @@
 //	t, err := transport.Dial("file:///tmp/traces.jsonl") // file
 //	t, err := transport.Dial("stdio://")                 // stdin/stdout
 //	t, err := transport.Dial("chan://")                   // in-process
+//	t, err := transport.Dial("grpc://tokentrace:9090")   // gRPC stream
 package transport
 
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/greynewell/mist-go/protocol"
+	"github.com/greynewell/mist-go/secrets"
 )
 
 // Transport is the interface for bidirectional message passing between
@@ -32,6 +35,32 @@ type Sender interface {
 	Send(ctx context.Context, msg *protocol.Message) error
 }
 
+// BatchSender can send many messages in one operation, which is much
+// faster than one Send each over transports with per-call overhead such
+// as HTTP. Messages are delivered in order. On error, some leading part
+// of the batch may already have been delivered.
+type BatchSender interface {
+	SendBatch(ctx context.Context, msgs []*protocol.Message) error
+}
+
+// DefaultBatchSize is the most messages a transport sends in one
+// operation; larger batches are split.
+const DefaultBatchSize = 100
+
+// SendBatch sends msgs through s: in one call if s is a BatchSender,
+// otherwise one Send per message, stopping at the first error.
+func SendBatch(ctx context.Context, s Sender, msgs []*protocol.Message) error {
+	if bs, ok := s.(BatchSender); ok {
+		return bs.SendBatch(ctx, msgs)
+	}
+	for _, msg := range msgs {
+		if err := s.Send(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Receiver can receive messages from a remote tool.
 type Receiver interface {
 	Receive(ctx context.Context) (*protocol.Message, error)
@@ -44,23 +73,145 @@ type Receiver interface {
 //	file://             → JSON lines file transport
 //	stdio://            → stdin/stdout pipe transport
 //	chan://             → in-process Go channel transport
+//	grpc:// or grpcs:// → gRPC stream client (see DialGRPC), reconnecting
+//	                      through Resilient when the stream breaks
+//
+// Options are given as query parameters (see URLOptions), except that
+// HTTP, whose query string belongs to the endpoint, takes them in the
+// fragment, which is never sent: http://host:8700/mist#compress=gzip.
+//
+//	max_message_size=4MiB  all but chan: SetMaxMessageSize
+//	compress=gzip|none     file: SetCompression, overriding the
+//	                       extension; http: SetCompression
+//	key=env:NAME           file: SetEncryption with the key from
+//	                       secrets.LoadKey; old_key adds a retired key,
+//	                       and allow_plaintext reads unsealed lines
+//	resume=true            file: SetResume
+//	buffer=1024            chan: buffered messages, default 256
+//
+// An unknown or malformed option fails with ErrInvalidOption.
 func Dial(url string) (Transport, error) {
 	scheme, addr := splitScheme(url)
+	if scheme == "http" || scheme == "https" {
+		return dialHTTP(scheme, url)
+	}
+
+	addr, query, _ := strings.Cut(addr, "?")
+	opts, err := ParseURLOptions(scheme, query)
+	if err != nil {
+		return nil, err
+	}
 
 	switch scheme {
-	case "http", "https":
-		return NewHTTP(url), nil
 	case "file":
-		return NewFile(addr)
+		maxSize := opts.Size("max_message_size", DefaultMaxMessageSize)
+		compress := opts.String("compress", "")
+		keyRef, oldKeyRef := opts.String("key", ""), opts.String("old_key", "")
+		resume := opts.Bool("resume", false)
+		allowPlaintext := opts.Bool("allow_plaintext", false)
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		var ring *secrets.Keyring
+		if keyRef != "" {
+			var err error
+			if ring, err = secrets.LoadKeyring(keyRef, oldKeyRef); err != nil {
+				opts.invalid("key", keyRef, err.Error())
+				return nil, opts.Err()
+			}
+			if allowPlaintext {
+				ring = ring.AllowPlaintext()
+			}
+		} else if oldKeyRef != "" {
+			opts.invalid("old_key", oldKeyRef, "requires key")
+			return nil, opts.Err()
+		} else if allowPlaintext {
+			opts.invalid("allow_plaintext", "true", "requires key")
+			return nil, opts.Err()
+		}
+		var c Compressor
+		if compress != "" && compress != "none" {
+			var ok bool
+			if c, ok = LookupCompressor(compress); !ok {
+				opts.invalid("compress", compress, "not a registered compressor (see RegisterCompressor)")
+				return nil, opts.Err()
+			}
+		}
+		f, err := NewFile(addr)
+		if err != nil {
+			return nil, err
+		}
+		f.SetMaxMessageSize(int(maxSize), nil)
+		if compress != "" {
+			f.SetCompression(c)
+		}
+		if ring != nil {
+			f.SetEncryption(ring)
+		}
+		f.SetResume(resume)
+		return f, nil
 	case "stdio":
-		return NewStdio(), nil
+		maxSize := opts.Size("max_message_size", DefaultMaxMessageSize)
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		s := NewStdio()
+		s.SetMaxMessageSize(int(maxSize), nil)
+		return s, nil
 	case "chan":
-		return NewChannel(256), nil
+		buffer := opts.Int("buffer", 256)
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		if buffer < 0 {
+			opts.invalid("buffer", strconv.Itoa(buffer), "want 0 or more")
+			return nil, opts.Err()
+		}
+		return NewChannel(buffer), nil
+	case "grpc", "grpcs":
+		maxSize := opts.Size("max_message_size", DefaultMaxMessageSize)
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		target := scheme + "://" + addr
+		return NewResilient(func() (Transport, error) {
+			g, err := DialGRPC(target)
+			if err != nil {
+				return nil, err
+			}
+			g.SetMaxMessageSize(int(maxSize), nil)
+			return g, nil
+		}, ResilientConfig{}), nil
 	default:
 		return nil, fmt.Errorf("transport: unsupported scheme %q in %q", scheme, url)
 	}
 }
 
+// dialHTTP creates an HTTP transport with the options in url's fragment.
+func dialHTTP(scheme, url string) (Transport, error) {
+	target, fragment, _ := strings.Cut(url, "#")
+	opts, err := ParseURLOptions(scheme, fragment)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := opts.Size("max_message_size", DefaultMaxMessageSize)
+	compress := opts.String("compress", "")
+	if err := opts.Err(); err != nil {
+		return nil, err
+	}
+	h := NewHTTP(target)
+	h.SetMaxMessageSize(int(maxSize), nil)
+	if compress != "" && compress != "none" {
+		c, ok := LookupCompressor(compress)
+		if !ok {
+			opts.invalid("compress", compress, "not a registered compressor (see RegisterCompressor)")
+			return nil, opts.Err()
+		}
+		h.SetCompression(c)
+	}
+	return h, nil
+}
+
 func splitScheme(url string) (scheme, rest string) {
 	i := strings.Index(url, "://")
 	if i < 0 {